@@ -3,67 +3,209 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 )
 
 // aliasNameRe matches valid alias names: starts with alphanumeric, allows dots/underscores/hyphens
 var aliasNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
 
+// envNameRe matches environment variable names usable in alias scripts
+var envNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 const aliasMarker = "# ov-alias"
 
+// Alias script flavors
+const (
+	AliasFlavorSh  = "sh"  // POSIX sh script named like the alias
+	AliasFlavorCmd = "cmd" // <name>.cmd for cmd.exe
+	AliasFlavorPS1 = "ps1" // <name>.ps1 for PowerShell
+)
+
+// aliasFlavors returns the script flavors written for target: "windows" gets
+// a .cmd and a .ps1 wrapper, other platforms ("linux", "darwin", "unix") a
+// POSIX sh script. An empty target is the platform ov runs on.
+func aliasFlavors(target string) ([]string, error) {
+	if target == "" {
+		target = runtime.GOOS
+	}
+	switch target {
+	case "windows":
+		return []string{AliasFlavorCmd, AliasFlavorPS1}, nil
+	case "linux", "darwin", "freebsd", "unix":
+		return []string{AliasFlavorSh}, nil
+	}
+	return nil, fmt.Errorf("unsupported alias target %q (want windows or unix)", target)
+}
+
+// aliasScriptFile returns the file name of the flavor's script of alias name
+func aliasScriptFile(name, flavor string) string {
+	if flavor == AliasFlavorSh {
+		return name
+	}
+	return name + "." + flavor
+}
+
+// generateAliasFlavor returns the wrapper script of the flavor for alias a of image
+func generateAliasFlavor(flavor, image string, a CollectedAlias) string {
+	switch flavor {
+	case AliasFlavorCmd:
+		return generateAliasCmd(image, a)
+	case AliasFlavorPS1:
+		return generateAliasPS1(image, a)
+	default:
+		return generateAliasScript(image, a)
+	}
+}
+
+// aliasScriptVersion is stamped into generated alias scripts and bumped when
+// their content changes, so ov alias sync can tell outdated scripts apart.
+// Scripts without a stamp are version 1.
+const aliasScriptVersion = 3
+
+// aliasProjectVersion is the first aliasScriptVersion that records the project
+// directory of aliases installed from images.yml. Older scripts have none.
+const aliasProjectVersion = 3
+
 // generateAliasScript produces the wrapper script content for a host command alias.
-// The wrapper builds a properly quoted command string and calls ov shell -c.
-func generateAliasScript(image, command string) string {
-	return fmt.Sprintf(`#!/bin/sh
+// The wrapper builds a properly quoted command string and calls ov shell -c
+// with the caller's directory mounted at the same path (unless mount_cwd is
+// off). The alias's env, and its passthrough variables that are set on the
+// host, become -e flags quoted by _ov_q, so the exec line goes through eval.
+func generateAliasScript(image string, a CollectedAlias) string {
+	extra := ""
+	if a.Project != "" {
+		extra += "# project: " + a.Project + "\n"
+	}
+	if a.Completion != "" {
+		extra += "# completion: " + a.Completion + "\n"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `#!/bin/sh
 # ov-alias
 # image: %s
 # command: %s
+%s# version: %d
 _ov_q(){ printf "'"; printf '%%s' "$1" | sed "s/'/'\\\\''/g"; printf "' "; }
 c="%s"; for a in "$@"; do c="$c $(_ov_q "$a")"; done
-exec ov shell %s -c "$c"
-`, image, command, command, image)
+`, image, a.Command, extra, aliasScriptVersion, a.Command)
+
+	cwd := ""
+	if a.MountsCwd() {
+		cwd = ` --cwd "$PWD"`
+	}
+	var flags strings.Builder
+	for _, flag := range a.shellFlags() {
+		flags.WriteString(" " + shellQuote(flag))
+	}
+	if len(a.Env) == 0 && len(a.EnvPassthrough) == 0 {
+		fmt.Fprintf(&b, `exec ov shell %s%s%s -c "$c"`+"\n", image, cwd, flags.String())
+		return b.String()
+	}
+
+	b.WriteString(`e=""` + "\n")
+	keys := make([]string, 0, len(a.Env))
+	for k := range a.Env {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&b, `e="$e-e $(_ov_q %s)"`+"\n", shellQuote(k+"="+a.Env[k]))
+	}
+	for _, k := range a.EnvPassthrough {
+		fmt.Fprintf(&b, `[ -n "${%s+x}" ] && e="$e-e $(_ov_q "%s=$%s")"`+"\n", k, k, k)
+	}
+	if cwd != "" {
+		cwd = ` --cwd \"\$PWD\"`
+	}
+	// The flags go through eval, so they are escaped for the double quotes
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "`", "\\`").Replace(flags.String())
+	fmt.Fprintf(&b, `eval "exec ov shell %s%s%s $e-c \"\$c\""`+"\n", image, cwd, escaped)
+	return b.String()
 }
 
-// writeAliasScript writes a wrapper script to dir/name with mode 0755.
-func writeAliasScript(dir, name, image, command string) error {
-	path := filepath.Join(dir, name)
-	content := generateAliasScript(image, command)
+// writeAliasScript writes the wrapper scripts of the alias for target (see
+// aliasFlavors) to dir with mode 0755.
+func writeAliasScript(dir, image string, a CollectedAlias, target string) error {
+	flavors, err := aliasFlavors(target)
+	if err != nil {
+		return err
+	}
+	for _, flavor := range flavors {
+		if err := writeAliasFlavor(dir, image, a, flavor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAliasFlavor writes the wrapper script of one flavor of the alias to dir.
+func writeAliasFlavor(dir, image string, a CollectedAlias, flavor string) error {
+	path := filepath.Join(dir, aliasScriptFile(a.Name, flavor))
+	content := generateAliasFlavor(flavor, image, a)
 	if err := os.WriteFile(path, []byte(content), 0755); err != nil {
 		return fmt.Errorf("writing alias script %s: %w", path, err)
 	}
 	return nil
 }
 
-// removeAliasScript verifies the file has the ov-alias marker, then deletes it.
+// removeAliasScript verifies the alias's scripts (sh, .cmd, .ps1) have the
+// ov-alias marker, then deletes them.
 func removeAliasScript(dir, name string) error {
-	path := filepath.Join(dir, name)
-
-	data, err := os.ReadFile(path)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return fmt.Errorf("alias %q not found in %s", name, dir)
+	var found []*AliasInfo
+	for _, flavor := range []string{AliasFlavorSh, AliasFlavorCmd, AliasFlavorPS1} {
+		path := filepath.Join(dir, aliasScriptFile(name, flavor))
+		if _, err := os.Stat(path); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
 		}
-		return err
+		info, err := parseAliasScript(path)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			return fmt.Errorf("%s is not an ov alias (missing marker)", path)
+		}
+		found = append(found, info)
 	}
-
-	if !strings.Contains(string(data), aliasMarker) {
-		return fmt.Errorf("%s is not an ov alias (missing marker)", path)
+	if len(found) == 0 {
+		return fmt.Errorf("alias %q not found in %s", name, dir)
 	}
 
-	return os.Remove(path)
+	for _, info := range found {
+		if err := os.Remove(filepath.Join(dir, info.File)); err != nil {
+			return err
+		}
+		if err := removeAliasCompletion(name, info.Completion); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // AliasInfo holds parsed metadata from a wrapper script.
 type AliasInfo struct {
-	Name    string
-	Image   string
-	Command string
+	Name       string
+	File       string // file name: Name, or Name with .cmd or .ps1
+	Flavor     string // AliasFlavorSh, AliasFlavorCmd or AliasFlavorPS1
+	Image      string
+	Command    string
+	Version    int    // aliasScriptVersion the script was generated with
+	Completion string // shell of its installed completion, if any
+	Project    string // project directory it was installed from, if any
 }
 
-// listAliasScripts scans dir for files with the ov-alias marker and returns their metadata.
+// listAliasScripts scans dir for files with the ov-alias marker and returns
+// their metadata, one entry per script (a Windows alias has two).
 func listAliasScripts(dir string) ([]AliasInfo, error) {
 	entries, err := os.ReadDir(dir)
 	if err != nil {
@@ -84,7 +226,6 @@ func listAliasScripts(dir string) ([]AliasInfo, error) {
 		if err != nil || info == nil {
 			continue
 		}
-		info.Name = entry.Name()
 		aliases = append(aliases, *info)
 	}
 
@@ -101,10 +242,26 @@ func parseAliasScript(path string) (*AliasInfo, error) {
 
 	scanner := bufio.NewScanner(f)
 	var hasMarker bool
-	var image, command string
+	var image, command, completion, project string
+	version := 1
+
+	file := filepath.Base(path)
+	name, flavor := file, AliasFlavorSh
+	for _, f := range []string{AliasFlavorCmd, AliasFlavorPS1} {
+		if base, ok := strings.CutSuffix(file, "."+f); ok {
+			name, flavor = base, f
+		}
+	}
 
 	for scanner.Scan() {
 		line := scanner.Text()
+		if flavor == AliasFlavorCmd {
+			// REM comments and CRLF line endings
+			line = strings.TrimSuffix(line, "\r")
+			if rest, ok := strings.CutPrefix(line, "REM "); ok {
+				line = "# " + rest
+			}
+		}
 		if line == aliasMarker {
 			hasMarker = true
 		}
@@ -114,24 +271,82 @@ func parseAliasScript(path string) (*AliasInfo, error) {
 		if strings.HasPrefix(line, "# command: ") {
 			command = strings.TrimPrefix(line, "# command: ")
 		}
+		if v, ok := strings.CutPrefix(line, "# completion: "); ok {
+			completion = v
+		}
+		if v, ok := strings.CutPrefix(line, "# project: "); ok {
+			project = v
+		}
+		if v, ok := strings.CutPrefix(line, "# version: "); ok {
+			if n, err := strconv.Atoi(v); err == nil {
+				version = n
+			}
+		}
 	}
 
 	if !hasMarker {
 		return nil, nil
 	}
 
-	return &AliasInfo{Image: image, Command: command}, nil
+	return &AliasInfo{
+		Name:       name,
+		File:       file,
+		Flavor:     flavor,
+		Image:      image,
+		Command:    command,
+		Version:    version,
+		Completion: completion,
+		Project:    project,
+	}, nil
 }
 
 // CollectedAlias represents a resolved alias ready for installation.
 type CollectedAlias struct {
-	Name    string `json:"name"`
-	Command string `json:"command"`
+	Name           string            `json:"name"`
+	Command        string            `json:"command"`
+	MountCwd       *bool             `json:"mount_cwd,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	EnvPassthrough []string          `json:"env_passthrough,omitempty"`
+
+	Completion        string `json:"completion,omitempty"`
+	CompletionCommand string `json:"completion_command,omitempty"`
+
+	GPU     AliasGPU `json:"gpu,omitempty"`
+	Ports   []string `json:"ports,omitempty"`
+	RunArgs []string `json:"run_args,omitempty"`
+
+	// Project is the project directory the alias is installed from, recorded
+	// in the script for ov alias sync. Empty for ov alias add and aliases
+	// from image labels.
+	Project string `json:"-"`
+}
+
+// shellFlags returns the ov shell flags for the alias's GPU, ports and run
+// arguments
+func (a CollectedAlias) shellFlags() []string {
+	var flags []string
+	if flag := a.GPU.ShellFlag(); flag != "" {
+		flags = append(flags, flag)
+	}
+	for _, port := range a.Ports {
+		flags = append(flags, "--port="+port)
+	}
+	for _, arg := range a.RunArgs {
+		flags = append(flags, "--run-arg="+arg)
+	}
+	return flags
+}
+
+// MountsCwd returns true if the alias mounts the caller's directory (nil defaults to true)
+func (a CollectedAlias) MountsCwd() bool {
+	return a.MountCwd == nil || *a.MountCwd
 }
 
 // CollectImageAliases gathers aliases from the image's own layers + image-level config.
 // No base chain traversal — aliases are leaf-image specific.
-// Layer aliases come first; image-level overrides by name.
+// Layer aliases come first; image-level overrides by name. Of an alias two
+// layers declare, the first layer's wins (Validate rejects different ones,
+// see imageAliasCollisions).
 func CollectImageAliases(cfg *Config, layers map[string]*Layer, imageName string) ([]CollectedAlias, error) {
 	img, ok := cfg.Images[imageName]
 	if !ok {
@@ -158,7 +373,20 @@ func CollectImageAliases(cfg *Config, layers map[string]*Layer, imageName string
 				continue
 			}
 			seen[a.Name] = true
-			result = append(result, CollectedAlias{Name: a.Name, Command: a.Command})
+			result = append(result, CollectedAlias{
+				Name:           a.Name,
+				Command:        a.Command,
+				MountCwd:       a.MountCwd,
+				Env:            a.Env,
+				EnvPassthrough: a.EnvPassthrough,
+
+				Completion:        a.Completion,
+				CompletionCommand: a.CompletionCommand,
+
+				GPU:     a.GPU,
+				Ports:   a.Ports,
+				RunArgs: a.RunArgs,
+			})
 		}
 	}
 
@@ -173,18 +401,130 @@ func CollectImageAliases(cfg *Config, layers map[string]*Layer, imageName string
 			for i := range result {
 				if result[i].Name == a.Name {
 					result[i].Command = cmd
+					if a.MountCwd != nil {
+						result[i].MountCwd = a.MountCwd
+					}
+					result[i].Env = mergeAliasEnv(result[i].Env, a.Env)
+					result[i].EnvPassthrough = mergeAliasPassthrough(result[i].EnvPassthrough, a.EnvPassthrough)
+					if a.Completion != "" {
+						result[i].Completion, result[i].CompletionCommand = a.Completion, a.CompletionCommand
+					}
+					if a.GPU != "" {
+						result[i].GPU = a.GPU
+					}
+					if a.Ports != nil {
+						result[i].Ports = a.Ports
+					}
+					if a.RunArgs != nil {
+						result[i].RunArgs = a.RunArgs
+					}
 					break
 				}
 			}
 		} else {
 			seen[a.Name] = true
-			result = append(result, CollectedAlias{Name: a.Name, Command: cmd})
+			result = append(result, CollectedAlias{
+				Name:           a.Name,
+				Command:        cmd,
+				MountCwd:       a.MountCwd,
+				Env:            a.Env,
+				EnvPassthrough: a.EnvPassthrough,
+
+				Completion:        a.Completion,
+				CompletionCommand: a.CompletionCommand,
+
+				GPU:     a.GPU,
+				Ports:   a.Ports,
+				RunArgs: a.RunArgs,
+			})
 		}
 	}
 
 	return result, nil
 }
 
+// aliasCollision is an alias that two layers of one image declare
+type aliasCollision struct {
+	Alias        string
+	First, Other string // the layers, in install order; the first one wins
+	Identical    bool   // same definition, collapsed into one
+}
+
+// imageAliasCollisions returns the aliases declared by more than one of the
+// layers of img (the layers of CollectImageAliases), except those that img
+// overrides at the image level
+func imageAliasCollisions(img ImageConfig, layers map[string]*Layer) ([]aliasCollision, error) {
+	resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+	if err != nil {
+		return nil, err
+	}
+	overridden := make(map[string]bool, len(img.Aliases))
+	for _, a := range img.Aliases {
+		overridden[a.Name] = true
+	}
+
+	type declared struct {
+		layer string
+		alias AliasYAML
+	}
+	first := make(map[string]declared)
+	var collisions []aliasCollision
+	for _, layerName := range resolved {
+		layer, ok := layers[layerName]
+		if !ok || !layer.HasAliases {
+			continue
+		}
+		for _, a := range layer.Aliases() {
+			if overridden[a.Name] {
+				continue
+			}
+			prev, ok := first[a.Name]
+			if !ok {
+				first[a.Name] = declared{layerName, a}
+				continue
+			}
+			if prev.layer == layerName {
+				continue // duplicate within the layer, reported by validateAliases
+			}
+			collisions = append(collisions, aliasCollision{
+				Alias:     a.Name,
+				First:     prev.layer,
+				Other:     layerName,
+				Identical: reflect.DeepEqual(prev.alias, a),
+			})
+		}
+	}
+	return collisions, nil
+}
+
+// mergeAliasEnv returns the layer alias's env with the image-level entries
+// added, image-level values winning
+func mergeAliasEnv(layer, image map[string]string) map[string]string {
+	if len(image) == 0 {
+		return layer
+	}
+	merged := make(map[string]string, len(layer)+len(image))
+	for k, v := range layer {
+		merged[k] = v
+	}
+	for k, v := range image {
+		merged[k] = v
+	}
+	return merged
+}
+
+// mergeAliasPassthrough returns the layer alias's passthrough variables
+// followed by the image-level ones it doesn't have
+func mergeAliasPassthrough(layer, image []string) []string {
+	merged := append([]string(nil), layer...)
+	for _, name := range image {
+		if !slices.Contains(merged, name) {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
 // defaultAliasDir returns ~/.local/bin, creating it if needed.
 func defaultAliasDir() string {
 	home, err := os.UserHomeDir()
@@ -203,14 +543,17 @@ type AliasCmd struct {
 	List      AliasListCmd      `cmd:"" help:"List all installed aliases"`
 	Install   AliasInstallCmd   `cmd:"" help:"Install default aliases from layer.yml / images.yml"`
 	Uninstall AliasUninstallCmd `cmd:"" help:"Remove all aliases for an image"`
+	Sync      AliasSyncCmd      `cmd:"" help:"Regenerate outdated aliases of the project's images and find stale ones"`
 }
 
 // AliasAddCmd creates a single alias
 type AliasAddCmd struct {
-	Name    string `arg:"" help:"Alias name (command on host)"`
-	Image   string `arg:"" help:"Image name from images.yml"`
-	Command string `arg:"" optional:"" help:"Command inside container (default: alias name)"`
-	Dest    string `long:"dest" default:"" help:"Directory for wrapper scripts (default: ~/.local/bin)"`
+	Name       string `arg:"" help:"Alias name (command on host)"`
+	Image      string `arg:"" help:"Image name from images.yml"`
+	Command    string `arg:"" optional:"" help:"Command inside container (default: alias name)"`
+	Dest       string `long:"dest" default:"" help:"Directory for wrapper scripts (default: ~/.local/bin)"`
+	NoMountCwd bool   `long:"no-mount-cwd" help:"Don't mount the caller's directory into the container"`
+	Target     string `long:"target" help:"Platform of the wrapper scripts: windows (.cmd and .ps1) or unix (sh) (default: this platform)"`
 }
 
 func (c *AliasAddCmd) Run() error {
@@ -247,7 +590,12 @@ func (c *AliasAddCmd) Run() error {
 		return fmt.Errorf("creating directory %s: %w", dest, err)
 	}
 
-	if err := writeAliasScript(dest, c.Name, c.Image, command); err != nil {
+	alias := CollectedAlias{Name: c.Name, Command: command}
+	if c.NoMountCwd {
+		off := false
+		alias.MountCwd = &off
+	}
+	if err := writeAliasScript(dest, c.Image, alias, c.Target); err != nil {
 		return err
 	}
 
@@ -292,15 +640,16 @@ func (c *AliasListCmd) Run() error {
 	}
 
 	for _, a := range aliases {
-		fmt.Printf("%s\t%s\t%s\n", a.Name, a.Image, a.Command)
+		fmt.Printf("%s\t%s\t%s\n", a.File, a.Image, a.Command)
 	}
 	return nil
 }
 
 // AliasInstallCmd installs all default aliases for an image
 type AliasInstallCmd struct {
-	Image string `arg:"" help:"Image name from images.yml"`
-	Dest  string `long:"dest" default:"" help:"Directory for wrapper scripts (default: ~/.local/bin)"`
+	Image  string `arg:"" help:"Image name from images.yml"`
+	Dest   string `long:"dest" default:"" help:"Directory for wrapper scripts (default: ~/.local/bin)"`
+	Target string `long:"target" help:"Platform of the wrapper scripts: windows (.cmd and .ps1) or unix (sh) (default: this platform)"`
 }
 
 func (c *AliasInstallCmd) Run() error {
@@ -318,6 +667,9 @@ func (c *AliasInstallCmd) Run() error {
 		if err != nil {
 			return err
 		}
+		for i := range aliases {
+			aliases[i].Project = dir
+		}
 	} else {
 		// Fall back to image labels
 		rt, err := ResolveRuntime()
@@ -349,11 +701,23 @@ func (c *AliasInstallCmd) Run() error {
 		return fmt.Errorf("creating directory %s: %w", dest, err)
 	}
 
+	flavors, err := aliasFlavors(c.Target)
+	if err != nil {
+		return err
+	}
 	for _, a := range aliases {
-		if err := writeAliasScript(dest, a.Name, c.Image, a.Command); err != nil {
+		if err := writeAliasScript(dest, c.Image, a, c.Target); err != nil {
 			return err
 		}
 		fmt.Fprintf(os.Stderr, "Installed %s -> %s\n", a.Name, a.Command)
+		if a.Completion != "" && slices.Contains(flavors, AliasFlavorSh) {
+			path, err := installAliasCompletion(c.Image, a)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s completion for %s: %v\n", a.Completion, a.Name, err)
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "Installed %s completion for %s in %s\n", a.Completion, a.Name, path)
+		}
 	}
 
 	fmt.Fprintf(os.Stderr, "Installed %d alias(es) for %s\n", len(aliases), c.Image)
@@ -380,11 +744,14 @@ func (c *AliasUninstallCmd) Run() error {
 	count := 0
 	for _, a := range aliases {
 		if a.Image == c.Image {
-			path := filepath.Join(dest, a.Name)
+			path := filepath.Join(dest, a.File)
 			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
 				return fmt.Errorf("removing %s: %w", path, err)
 			}
-			fmt.Fprintf(os.Stderr, "Removed %s\n", a.Name)
+			if err := removeAliasCompletion(a.Name, a.Completion); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "Removed %s\n", a.File)
 			count++
 		}
 	}
@@ -393,6 +760,183 @@ func (c *AliasUninstallCmd) Run() error {
 	return nil
 }
 
+// AliasSyncCmd brings the installed aliases of the current project up to date
+type AliasSyncCmd struct {
+	Dest   string `long:"dest" default:"" help:"Directory for wrapper scripts (default: ~/.local/bin)"`
+	Prune  bool   `long:"prune" help:"Remove aliases whose image or alias definition no longer exists"`
+	Target string `long:"target" help:"Platform of new wrapper scripts: windows (.cmd and .ps1) or unix (sh) (default: this platform)"`
+}
+
+func (c *AliasSyncCmd) Run() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		return err
+	}
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		return err
+	}
+
+	defs := make(map[string][]CollectedAlias)
+	for name, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		aliases, err := CollectImageAliases(cfg, layers, name)
+		if err != nil {
+			return err
+		}
+		defs[name] = aliases
+	}
+
+	dest := c.Dest
+	if dest == "" {
+		dest = defaultAliasDir()
+	}
+	_, err = syncAliases(os.Stderr, dest, dir, defs, c.Prune, c.Target)
+	return err
+}
+
+// projectDefinesAlias reports whether defs has an alias of a's name for a's image
+func projectDefinesAlias(defs map[string][]CollectedAlias, a AliasInfo) bool {
+	return slices.ContainsFunc(defs[a.Image], func(d CollectedAlias) bool { return d.Name == a.Name })
+}
+
+// aliasSyncResult counts what syncAliases did
+type aliasSyncResult struct {
+	Created, Updated, Removed, Stale int
+}
+
+// syncAliases makes the ov alias scripts of project in dest match defs (the
+// aliases of each enabled project image). Only scripts installed from project
+// are synced: those of other projects and of ov alias add are left alone,
+// while scripts from before aliasProjectVersion are adopted when project
+// defines their alias. Scripts that differ from what
+// generateAliasFlavor emits for their flavor are rewritten, and aliases
+// defined for an image with installed aliases but missing from dest are
+// created for target (see aliasFlavors). Scripts whose image or alias
+// definition no longer exists are stale, and removed with prune. Files
+// without the ov-alias marker are never touched.
+func syncAliases(out io.Writer, dest, project string, defs map[string][]CollectedAlias, prune bool, target string) (aliasSyncResult, error) {
+	var res aliasSyncResult
+	flavors, err := aliasFlavors(target)
+	if err != nil {
+		return res, err
+	}
+	installed, err := listAliasScripts(dest)
+	if err != nil {
+		return res, err
+	}
+
+	taken := make(map[string]bool)
+	stale := make(map[string]bool)
+	var images []string
+	for _, a := range installed {
+		// The .cmd and .ps1 wrappers of an alias are one stale alias
+		if stale[a.Name] {
+			continue
+		}
+		taken[a.Name] = true
+		if a.Project != project && !(a.Project == "" && a.Version < aliasProjectVersion && projectDefinesAlias(defs, a)) {
+			continue
+		}
+		if _, ok := defs[a.Image]; ok && !slices.Contains(images, a.Image) {
+			images = append(images, a.Image)
+		}
+
+		aliases, ok := defs[a.Image]
+		if !ok {
+			stale[a.Name] = true
+			res.Stale++
+			if err := syncStaleAlias(out, dest, a, fmt.Sprintf("image %s no longer exists", a.Image), prune, &res); err != nil {
+				return res, err
+			}
+			continue
+		}
+		i := slices.IndexFunc(aliases, func(d CollectedAlias) bool { return d.Name == a.Name })
+		if i < 0 {
+			stale[a.Name] = true
+			res.Stale++
+			if err := syncStaleAlias(out, dest, a, fmt.Sprintf("no longer defined for %s", a.Image), prune, &res); err != nil {
+				return res, err
+			}
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(dest, a.File))
+		if err != nil {
+			return res, err
+		}
+		want := aliases[i]
+		want.Project = project
+		if string(data) == generateAliasFlavor(a.Flavor, a.Image, want) {
+			continue
+		}
+		if err := writeAliasFlavor(dest, a.Image, want, a.Flavor); err != nil {
+			return res, err
+		}
+		reason := "definition changed"
+		if a.Version < aliasScriptVersion {
+			reason = fmt.Sprintf("outdated since v%d", a.Version)
+		}
+		fmt.Fprintf(out, "Updated %s (%s)\n", a.File, reason)
+		res.Updated++
+	}
+
+	// New aliases of images that already have some installed
+	sortStrings(images)
+	for _, image := range images {
+		for _, a := range defs[image] {
+			if taken[a.Name] {
+				continue
+			}
+			taken[a.Name] = true
+			a.Project = project
+			exists := ""
+			for _, flavor := range flavors {
+				path := filepath.Join(dest, aliasScriptFile(a.Name, flavor))
+				if _, err := os.Stat(path); err == nil {
+					exists = path
+					break
+				}
+			}
+			if exists != "" {
+				fmt.Fprintf(out, "Warning: %s exists and is not an ov alias, not creating alias %s of %s\n", exists, a.Name, image)
+				continue
+			}
+			if err := writeAliasScript(dest, image, a, target); err != nil {
+				return res, err
+			}
+			fmt.Fprintf(out, "Created %s -> %s (image: %s)\n", a.Name, a.Command, image)
+			res.Created++
+		}
+	}
+
+	fmt.Fprintf(out, "Created %d, updated %d, removed %d alias(es)\n", res.Created, res.Updated, res.Removed)
+	if n := res.Stale - res.Removed; n > 0 {
+		fmt.Fprintf(out, "%d stale alias(es) left, remove them with --prune\n", n)
+	}
+	return res, nil
+}
+
+// syncStaleAlias reports a stale alias, or with prune removes it
+func syncStaleAlias(out io.Writer, dest string, a AliasInfo, reason string, prune bool, res *aliasSyncResult) error {
+	if !prune {
+		fmt.Fprintf(out, "Stale %s: %s\n", a.Name, reason)
+		return nil
+	}
+	if err := removeAliasScript(dest, a.Name); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Removed %s: %s\n", a.Name, reason)
+	res.Removed++
+	return nil
+}
+
 // ListAliasesCmd lists layers with alias declarations
 type ListAliasesCmd struct{}
 
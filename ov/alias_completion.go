@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// aliasCompletionMarker is appended to installed alias completions so only
+// files ov wrote are ever removed
+const aliasCompletionMarker = "# ov-alias-completion"
+
+// RunAliasCompletion runs command in image via ov shell -c and returns what it
+// prints. Package-level var for testability.
+var RunAliasCompletion = func(image, command string) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		self = "ov"
+	}
+	cmd := exec.Command(self, "shell", image, "-c", command)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("ov shell %s -c %q: %w", image, command, err)
+	}
+	return string(out), nil
+}
+
+// aliasCompletionPath returns where the completion of alias name is installed
+// for shell, in the user's XDG data (bash, zsh) or config (fish) directory
+func aliasCompletionPath(shell, name string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	data := os.Getenv("XDG_DATA_HOME")
+	if data == "" {
+		data = filepath.Join(home, ".local", "share")
+	}
+	config := os.Getenv("XDG_CONFIG_HOME")
+	if config == "" {
+		config = filepath.Join(home, ".config")
+	}
+
+	switch shell {
+	case "bash":
+		return filepath.Join(data, "bash-completion", "completions", name), nil
+	case "zsh":
+		return filepath.Join(data, "zsh", "site-functions", "_"+name), nil
+	case "fish":
+		return filepath.Join(config, "fish", "completions", name+".fish"), nil
+	}
+	return "", fmt.Errorf("unsupported completion shell %q (want bash, zsh or fish)", shell)
+}
+
+// rewriteCompletion makes a completion script of the command alias a wraps
+// complete and call the alias instead: every standalone occurrence of the
+// command's name (in complete/compdef registrations and in calls back into
+// the command) becomes the alias name, so the completion routes through the
+// alias script. Longer words and paths containing the name are left alone.
+func rewriteCompletion(script string, a CollectedAlias) string {
+	fields := strings.Fields(a.Command)
+	if len(fields) == 0 {
+		return script
+	}
+	binary := filepath.Base(fields[0])
+	if binary == a.Name {
+		return script
+	}
+
+	isWord := func(c byte) bool {
+		return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.-/", c) >= 0
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(script, binary)
+		if i < 0 {
+			b.WriteString(script)
+			return b.String()
+		}
+		end := i + len(binary)
+		b.WriteString(script[:i])
+		if (i == 0 || !isWord(script[i-1])) && (end == len(script) || !isWord(script[end])) {
+			b.WriteString(a.Name)
+		} else {
+			b.WriteString(binary)
+		}
+		script = script[end:]
+	}
+}
+
+// installAliasCompletion runs the completion command of alias a in image once,
+// rewrites the script to go through the alias and installs it. It returns the
+// path of the installed file.
+func installAliasCompletion(image string, a CollectedAlias) (string, error) {
+	path, err := aliasCompletionPath(a.Completion, a.Name)
+	if err != nil {
+		return "", err
+	}
+	if data, err := os.ReadFile(path); err == nil && !strings.Contains(string(data), aliasCompletionMarker) {
+		return "", fmt.Errorf("%s exists and was not installed by ov", path)
+	}
+
+	script, err := RunAliasCompletion(image, a.CompletionCommand)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(script) == "" {
+		return "", fmt.Errorf("%q printed no completion script", a.CompletionCommand)
+	}
+	script = rewriteCompletion(strings.TrimRight(script, "\n"), a)
+	content := fmt.Sprintf("%s\n\n%s (alias %s of image %s)\n", script, aliasCompletionMarker, a.Name, image)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("creating directory %s: %w", filepath.Dir(path), err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return "", fmt.Errorf("writing completion %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// removeAliasCompletion removes the shell completion installed for alias
+// name, if any. Files without the completion marker are left alone.
+func removeAliasCompletion(name, shell string) error {
+	if shell == "" {
+		return nil
+	}
+	path, err := aliasCompletionPath(shell, name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if !strings.Contains(string(data), aliasCompletionMarker) {
+		return nil
+	}
+	return os.Remove(path)
+}
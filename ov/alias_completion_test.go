@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRewriteCompletion(t *testing.T) {
+	a := CollectedAlias{Name: "mc", Command: "/usr/local/bin/mycli --color"}
+	tests := []struct {
+		name, in, want string
+	}{
+		{"bash registration", "complete -o default -F __start_mycli mycli", "complete -o default -F __start_mycli mc"},
+		{"bash callback", `out=$(mycli __complete "${words[@]:1}")`, `out=$(mc __complete "${words[@]:1}")`},
+		{"zsh", "#compdef mycli\ncompdef _mycli mycli", "#compdef mc\ncompdef _mycli mc"},
+		{"fish", "complete -c mycli -n '__fish_use_subcommand' -a run", "complete -c mc -n '__fish_use_subcommand' -a run"},
+		{"other words", "mycli-helper /opt/mycli mycli.d", "mycli-helper /opt/mycli mycli.d"},
+		{"adjacent", "mycli mycli", "mc mc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteCompletion(tt.in, a); got != tt.want {
+				t.Errorf("rewriteCompletion(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+
+	same := CollectedAlias{Name: "mycli", Command: "mycli"}
+	if got := rewriteCompletion("complete -F _mycli mycli", same); got != "complete -F _mycli mycli" {
+		t.Errorf("rewriteCompletion() changed the script of a same-named alias: %q", got)
+	}
+}
+
+func TestAliasCompletionPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", "")
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "cfg"))
+
+	tests := []struct {
+		shell, want string
+	}{
+		{"bash", filepath.Join(home, ".local/share/bash-completion/completions/mc")},
+		{"zsh", filepath.Join(home, ".local/share/zsh/site-functions/_mc")},
+		{"fish", filepath.Join(home, "cfg/fish/completions/mc.fish")},
+	}
+	for _, tt := range tests {
+		if got, err := aliasCompletionPath(tt.shell, "mc"); err != nil || got != tt.want {
+			t.Errorf("aliasCompletionPath(%s) = %q, %v; want %q", tt.shell, got, err, tt.want)
+		}
+	}
+	if _, err := aliasCompletionPath("tcsh", "mc"); err == nil {
+		t.Error("expected error for an unsupported shell")
+	}
+}
+
+func TestInstallAndRemoveAliasCompletion(t *testing.T) {
+	orig := RunAliasCompletion
+	defer func() { RunAliasCompletion = orig }()
+	var ran []string
+	RunAliasCompletion = func(image, command string) (string, error) {
+		ran = append(ran, image+": "+command)
+		return "complete -o default -F __start_mycli mycli\n", nil
+	}
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	dest := t.TempDir()
+	a := CollectedAlias{Name: "mc", Command: "mycli", Completion: "bash", CompletionCommand: "mycli completion bash"}
+	if err := writeAliasScript(dest, "tools", a, "unix"); err != nil {
+		t.Fatal(err)
+	}
+	path, err := installAliasCompletion("tools", a)
+	if err != nil {
+		t.Fatalf("installAliasCompletion() error = %v", err)
+	}
+	if len(ran) != 1 || ran[0] != "tools: mycli completion bash" {
+		t.Errorf("ran %v, want the completion command once in the image", ran)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "complete -o default -F __start_mycli mc\n") || !strings.Contains(string(data), aliasCompletionMarker) {
+		t.Errorf("installed completion =\n%s", data)
+	}
+
+	// A completion ov didn't write is neither replaced nor removed
+	fishAlias := CollectedAlias{Name: "mc", Command: "mycli", Completion: "fish", CompletionCommand: "mycli completion fish"}
+	fishPath, _ := aliasCompletionPath("fish", "mc")
+	os.MkdirAll(filepath.Dir(fishPath), 0755)
+	os.WriteFile(fishPath, []byte("complete -c mc\n"), 0644)
+	if _, err := installAliasCompletion("tools", fishAlias); err == nil {
+		t.Error("expected error replacing a foreign completion")
+	}
+	if err := removeAliasCompletion("mc", "fish"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fishPath); err != nil {
+		t.Error("foreign completion removed")
+	}
+
+	info, err := parseAliasScript(filepath.Join(dest, "mc"))
+	if err != nil || info.Completion != "bash" {
+		t.Fatalf("parseAliasScript() = %+v, %v; want the completion shell recorded", info, err)
+	}
+	if err := removeAliasScript(dest, "mc"); err != nil {
+		t.Fatalf("removeAliasScript() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("completion not removed with the alias")
+	}
+}
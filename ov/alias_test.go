@@ -2,14 +2,17 @@ package main
 
 import (
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestGenerateAliasScript(t *testing.T) {
-	script := generateAliasScript("openclaw", "openclaw")
+	script := generateAliasScript("openclaw", CollectedAlias{Name: "openclaw", Command: "openclaw"})
 
 	if !strings.HasPrefix(script, "#!/bin/sh\n") {
 		t.Error("script should start with shebang")
@@ -23,7 +26,7 @@ func TestGenerateAliasScript(t *testing.T) {
 	if !strings.Contains(script, "# command: openclaw") {
 		t.Error("script should contain command metadata")
 	}
-	if !strings.Contains(script, `exec ov shell openclaw -c "$c"`) {
+	if !strings.Contains(script, `exec ov shell openclaw --cwd "$PWD" -c "$c"`) {
 		t.Errorf("script should contain exec ov shell line, got:\n%s", script)
 	}
 	if !strings.Contains(script, `_ov_q()`) {
@@ -34,10 +37,117 @@ func TestGenerateAliasScript(t *testing.T) {
 	}
 }
 
+func TestGenerateAliasScriptNoMountCwd(t *testing.T) {
+	off := false
+	script := generateAliasScript("openclaw", CollectedAlias{Name: "openclaw", Command: "openclaw", MountCwd: &off})
+
+	if !strings.Contains(script, `exec ov shell openclaw -c "$c"`) {
+		t.Errorf("script should exec ov shell without --cwd, got:\n%s", script)
+	}
+	if strings.Contains(script, "--cwd") {
+		t.Error("script should not mount the caller's directory")
+	}
+}
+
+// runAliasScript runs an alias script with sh and a fake ov on PATH, and
+// returns the arguments the script passes to ov
+func runAliasScript(t *testing.T, script string, env []string, args ...string) []string {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh not available")
+	}
+
+	bin := t.TempDir()
+	fake := "#!/bin/sh\nfor a in \"$@\"; do printf '%s\\n' \"$a\"; done\n"
+	if err := os.WriteFile(filepath.Join(bin, "ov"), []byte(fake), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "alias")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(sh, append([]string{path}, args...)...)
+	cmd.Env = append([]string{"PATH=" + bin + ":" + os.Getenv("PATH")}, env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("running alias script: %v\n%s\nscript:\n%s", err, out, script)
+	}
+	return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n")
+}
+
+func TestGenerateAliasScriptEnv(t *testing.T) {
+	off := false
+	script := generateAliasScript("openclaw", CollectedAlias{
+		Name:           "openclaw",
+		Command:        "openclaw",
+		MountCwd:       &off,
+		Env:            map[string]string{"GREETING": "hello $USER 'you'", "LANG": "C.UTF-8"},
+		EnvPassthrough: []string{"OPENAI_API_KEY", "UNSET_VAR"},
+	})
+
+	got := runAliasScript(t, script, []string{"USER=nobody", "OPENAI_API_KEY=sk a$b \"c\""}, "it's", "a b")
+	want := []string{
+		"shell", "openclaw",
+		"-e", "GREETING=hello $USER 'you'",
+		"-e", "LANG=C.UTF-8",
+		"-e", `OPENAI_API_KEY=sk a$b "c"`,
+		"-c", `openclaw 'it'\''s'  'a b' `,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ov arguments =\n  %q\nwant\n  %q\nscript:\n%s", got, want, script)
+	}
+}
+
+func TestGenerateAliasScriptRunOptions(t *testing.T) {
+	off := false
+	a := CollectedAlias{
+		Name:     "train",
+		Command:  "python train.py",
+		MountCwd: &off,
+		GPU:      "true",
+		Ports:    []string{"6006", "8888:8888"},
+		RunArgs:  []string{"--shm-size=2g", "--label=note=it's $HOME"},
+	}
+	script := generateAliasScript("pytorch", a)
+	if !strings.Contains(script, `exec ov shell pytorch --gpu --port=6006 --port=8888:8888 --run-arg=--shm-size=2g '--run-arg=--label=note=it'\''s $HOME' -c "$c"`) {
+		t.Errorf("script should pass the run options quoted, got:\n%s", script)
+	}
+	wantFlags := []string{"--gpu", "--port=6006", "--port=8888:8888", "--run-arg=--shm-size=2g", "--run-arg=--label=note=it's $HOME"}
+	want := append(append([]string{"shell", "pytorch"}, wantFlags...), "-c", "python train.py")
+	if got := runAliasScript(t, script, []string{"HOME=/home/me"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ov arguments =\n  %q\nwant\n  %q", got, want)
+	}
+
+	// Through eval, with env
+	a.Env = map[string]string{"A": "b"}
+	want = append(append([]string{"shell", "pytorch"}, wantFlags...), "-e", "A=b", "-c", "python train.py")
+	if got := runAliasScript(t, generateAliasScript("pytorch", a), []string{"HOME=/home/me"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ov arguments with env =\n  %q\nwant\n  %q", got, want)
+	}
+
+	a = CollectedAlias{Name: "x", Command: "x", GPU: "false"}
+	if !strings.Contains(generateAliasScript("img", a), `--cwd "$PWD" --no-gpu -c`) {
+		t.Errorf("gpu: false should pass --no-gpu")
+	}
+	a.GPU = "auto"
+	if strings.Contains(generateAliasScript("img", a), "gpu") {
+		t.Errorf("gpu: auto should leave GPU detection to ov shell")
+	}
+}
+
+func TestGenerateAliasScriptEnvMountCwd(t *testing.T) {
+	script := generateAliasScript("openclaw", CollectedAlias{Name: "openclaw", Command: "openclaw", EnvPassthrough: []string{"TOKEN"}})
+	if !strings.Contains(script, `eval "exec ov shell openclaw --cwd \"\$PWD\" $e-c \"\$c\""`) {
+		t.Errorf("script should eval the exec line with --cwd, got:\n%s", script)
+	}
+}
+
 func TestWriteAndListAliasScripts(t *testing.T) {
 	dir := t.TempDir()
 
-	if err := writeAliasScript(dir, "mycmd", "myimage", "mycommand"); err != nil {
+	if err := writeAliasScript(dir, "myimage", CollectedAlias{Name: "mycmd", Command: "mycommand"}, "unix"); err != nil {
 		t.Fatalf("writeAliasScript() error = %v", err)
 	}
 
@@ -73,7 +183,7 @@ func TestWriteAndListAliasScripts(t *testing.T) {
 func TestRemoveAliasScript(t *testing.T) {
 	dir := t.TempDir()
 
-	if err := writeAliasScript(dir, "mycmd", "myimage", "mycommand"); err != nil {
+	if err := writeAliasScript(dir, "myimage", CollectedAlias{Name: "mycmd", Command: "mycommand"}, "unix"); err != nil {
 		t.Fatalf("writeAliasScript() error = %v", err)
 	}
 
@@ -180,6 +290,148 @@ func TestCollectImageAliasesImageOverridesLayer(t *testing.T) {
 	}
 }
 
+func TestCollectImageAliasesMountCwd(t *testing.T) {
+	off := false
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"myapp": {
+				Layers:  []string{"svc"},
+				Aliases: []AliasConfig{{Name: "svc-cli", Command: "custom-cmd"}, {Name: "sandboxed", MountCwd: &off}},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"svc": {
+			Name:       "svc",
+			HasUserYml: true,
+			HasAliases: true,
+			aliases:    []AliasYAML{{Name: "svc-cli", Command: "svc-cli-bin", MountCwd: &off}, {Name: "svc-tool", Command: "svc-tool"}},
+		},
+	}
+
+	aliases, err := CollectImageAliases(cfg, layers, "myapp")
+	if err != nil {
+		t.Fatalf("CollectImageAliases() error = %v", err)
+	}
+
+	// An image-level override without mount_cwd keeps the layer's setting
+	got := make(map[string]bool)
+	for _, a := range aliases {
+		got[a.Name] = a.MountsCwd()
+	}
+	want := map[string]bool{"svc-cli": false, "svc-tool": true, "sandboxed": false}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MountsCwd() = %v, want %v", got, want)
+	}
+}
+
+func TestCollectImageAliasesEnv(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"myapp": {
+				Layers: []string{"svc"},
+				Aliases: []AliasConfig{{
+					Name:           "svc-cli",
+					Command:        "svc-cli-bin --verbose",
+					Env:            map[string]string{"MODE": "image"},
+					EnvPassthrough: []string{"TOKEN", "EXTRA"},
+				}},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"svc": {
+			Name:       "svc",
+			HasUserYml: true,
+			HasAliases: true,
+			aliases: []AliasYAML{{
+				Name:           "svc-cli",
+				Command:        "svc-cli-bin",
+				Env:            map[string]string{"MODE": "layer", "LEVEL": "debug"},
+				EnvPassthrough: []string{"TOKEN"},
+			}},
+		},
+	}
+
+	aliases, err := CollectImageAliases(cfg, layers, "myapp")
+	if err != nil {
+		t.Fatalf("CollectImageAliases() error = %v", err)
+	}
+
+	want := []CollectedAlias{{
+		Name:           "svc-cli",
+		Command:        "svc-cli-bin --verbose",
+		Env:            map[string]string{"MODE": "image", "LEVEL": "debug"},
+		EnvPassthrough: []string{"TOKEN", "EXTRA"},
+	}}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Errorf("CollectImageAliases() = %+v, want %+v", aliases, want)
+	}
+}
+
+func TestCollectImageAliasesRunOptions(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"myapp": {
+				Layers:  []string{"svc"},
+				Aliases: []AliasConfig{{Name: "svc-cli", Command: "svc-cli-bin", Ports: []string{"9090"}}},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"svc": {
+			Name:       "svc",
+			HasUserYml: true,
+			HasAliases: true,
+			aliases: []AliasYAML{{
+				Name:    "svc-cli",
+				Command: "svc-cli-bin",
+				GPU:     "true",
+				Ports:   []string{"8080"},
+				RunArgs: []string{"--shm-size=1g"},
+			}},
+		},
+	}
+
+	aliases, err := CollectImageAliases(cfg, layers, "myapp")
+	if err != nil {
+		t.Fatalf("CollectImageAliases() error = %v", err)
+	}
+
+	// Only the fields the image sets replace the layer's
+	want := []CollectedAlias{{
+		Name:    "svc-cli",
+		Command: "svc-cli-bin",
+		GPU:     "true",
+		Ports:   []string{"9090"},
+		RunArgs: []string{"--shm-size=1g"},
+	}}
+	if !reflect.DeepEqual(aliases, want) {
+		t.Errorf("CollectImageAliases() = %+v, want %+v", aliases, want)
+	}
+}
+
+func TestAliasGPUUnmarshal(t *testing.T) {
+	tests := []struct {
+		yaml    string
+		want    AliasGPU
+		wantErr bool
+	}{
+		{"gpu: true", "true", false},
+		{"gpu: false", "false", false},
+		{"gpu: auto", "auto", false},
+		{"gpu: always", "", true},
+		{"gpu: [true]", "", true},
+	}
+	for _, tt := range tests {
+		var a AliasConfig
+		err := yaml.Unmarshal([]byte(tt.yaml), &a)
+		if (err != nil) != tt.wantErr || a.GPU != tt.want {
+			t.Errorf("Unmarshal(%q) = %q, %v; want %q (error %v)", tt.yaml, a.GPU, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
 func TestCollectImageAliasesDefaultCommand(t *testing.T) {
 	cfg := &Config{
 		Images: map[string]ImageConfig{
@@ -302,3 +554,98 @@ func TestAliasNameRegex(t *testing.T) {
 		})
 	}
 }
+
+func TestSyncAliases(t *testing.T) {
+	dest := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dest, name), []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(name string) string {
+		t.Helper()
+		data, err := os.ReadFile(filepath.Join(dest, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	defs := map[string][]CollectedAlias{
+		"openclaw": {
+			{Name: "openclaw", Command: "openclaw"},
+			{Name: "claw-cli", Command: "openclaw cli"},
+			{Name: "claw-new", Command: "openclaw new"},
+		},
+		"ollama": {{Name: "ollama", Command: "ollama"}},
+	}
+	const project = "/home/me/app"
+	inProject := func(a CollectedAlias) CollectedAlias {
+		a.Project = project
+		return a
+	}
+	current := generateAliasScript("openclaw", inProject(defs["openclaw"][0]))
+	write("openclaw", current)
+	// Before the version stamp and the _ov_q quoting
+	write("claw-cli", "#!/bin/sh\n# ov-alias\n# image: openclaw\n# command: openclaw cli\nexec ov shell openclaw -c \"openclaw cli $*\"\n")
+	write("gone", generateAliasScript("removed-image", inProject(CollectedAlias{Name: "gone", Command: "gone"})))
+	write("undefined", generateAliasScript("openclaw", inProject(CollectedAlias{Name: "undefined", Command: "old"})))
+	write("claw-new", "#!/bin/sh\necho not mine\n")
+	// Another project's alias and one from ov alias add are not ours to sync
+	other := generateAliasScript("removed-image", CollectedAlias{Name: "other", Command: "other", Project: "/srv/other"})
+	write("other", other)
+	added := generateAliasScript("openclaw", CollectedAlias{Name: "added", Command: "openclaw added"})
+	write("added", added)
+
+	var out strings.Builder
+	res, err := syncAliases(&out, dest, project, defs, false, "unix")
+	if err != nil {
+		t.Fatalf("syncAliases() error = %v", err)
+	}
+	if want := (aliasSyncResult{Updated: 1, Stale: 2}); res != want {
+		t.Errorf("syncAliases() = %+v, want %+v\n%s", res, want, out.String())
+	}
+	for _, want := range []string{"Updated claw-cli (outdated since v1)", "Stale gone: image removed-image no longer exists", "Stale undefined: no longer defined for openclaw", "Warning:", "Created 0, updated 1, removed 0", "2 stale alias(es) left"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+	if got := read("claw-cli"); got != generateAliasScript("openclaw", inProject(defs["openclaw"][1])) {
+		t.Errorf("claw-cli not regenerated:\n%s", got)
+	}
+	if read("openclaw") != current || read("claw-new") != "#!/bin/sh\necho not mine\n" {
+		t.Error("up to date alias or foreign file rewritten")
+	}
+	if read("other") != other || read("added") != added || strings.Contains(out.String(), "other") || strings.Contains(out.String(), "added") {
+		t.Errorf("alias outside the project synced:\n%s", out.String())
+	}
+	if _, err := os.Stat(filepath.Join(dest, "ollama")); !os.IsNotExist(err) {
+		t.Error("alias created for an image without installed aliases")
+	}
+
+	// With --prune, and claw-new freed up
+	os.Remove(filepath.Join(dest, "claw-new"))
+	out.Reset()
+	res, err = syncAliases(&out, dest, project, defs, true, "unix")
+	if err != nil {
+		t.Fatalf("syncAliases(prune) error = %v", err)
+	}
+	if want := (aliasSyncResult{Created: 1, Removed: 2, Stale: 2}); res != want {
+		t.Errorf("syncAliases(prune) = %+v, want %+v\n%s", res, want, out.String())
+	}
+	aliases, err := listAliasScripts(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, a := range aliases {
+		names = append(names, a.Name)
+		if a.Version != aliasScriptVersion {
+			t.Errorf("%s has version %d, want %d", a.Name, a.Version, aliasScriptVersion)
+		}
+	}
+	if want := []string{"added", "claw-cli", "claw-new", "openclaw", "other"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("aliases after prune = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Windows alias wrappers. Both flavors hand the caller's arguments to ov shell
+// after --, so ov quotes them for bash instead of cmd.exe or PowerShell. The
+// caller's directory is not mounted at the same path (Windows paths don't
+// exist in the container); it is still the /workspace of ov shell.
+
+// generateAliasCmd produces the .cmd wrapper of an alias for cmd.exe.
+func generateAliasCmd(image string, a CollectedAlias) string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\r\n", args...)
+	}
+	line("@echo off")
+	line("REM ov-alias")
+	line("REM image: %s", image)
+	line("REM command: %s", a.Command)
+	if a.Project != "" {
+		line("REM project: %s", a.Project)
+	}
+	line("REM version: %d", aliasScriptVersion)
+
+	args := []string{"ov", "shell", image}
+	for _, arg := range windowsAliasArgs(a) {
+		args = append(args, cmdQuote(arg))
+	}
+	args = append(args, "-c", cmdQuote(a.Command), "--", "%*")
+	line("%s", strings.Join(args, " "))
+	return b.String()
+}
+
+// generateAliasPS1 produces the .ps1 wrapper of an alias for PowerShell.
+func generateAliasPS1(image string, a CollectedAlias) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# ov-alias\n# image: %s\n# command: %s\n", image, a.Command)
+	if a.Project != "" {
+		fmt.Fprintf(&b, "# project: %s\n", a.Project)
+	}
+	fmt.Fprintf(&b, "# version: %d\n", aliasScriptVersion)
+
+	args := []string{"&", "ov", "shell", image}
+	for _, arg := range windowsAliasArgs(a) {
+		args = append(args, psQuote(arg))
+	}
+	args = append(args, "-c", psQuote(a.Command), "--", "@args")
+	b.WriteString(strings.Join(args, " ") + "\n")
+	b.WriteString("exit $LASTEXITCODE\n")
+	return b.String()
+}
+
+// windowsAliasArgs returns the ov shell flags of a Windows wrapper: the run
+// options and the env. Passthrough variables are passed by name (-e KEY), so
+// the engine forwards them when they are set.
+func windowsAliasArgs(a CollectedAlias) []string {
+	args := a.shellFlags()
+	keys := make([]string, 0, len(a.Env))
+	for k := range a.Env {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+	for _, k := range keys {
+		args = append(args, "-e", k+"="+a.Env[k])
+	}
+	for _, k := range a.EnvPassthrough {
+		args = append(args, "-e", k)
+	}
+	return args
+}
+
+// cmdQuote quotes s as one argument of a command line in a .cmd script:
+// first for the program's argument parsing (CommandLineToArgvW rules), then
+// for cmd.exe, which expands % everywhere and treats & | < > ^ ( ) as
+// operators outside double quotes, where they are escaped with ^.
+func cmdQuote(s string) string {
+	safe := s != ""
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune(`-_./:=,@+\`, r)) {
+			safe = false
+			break
+		}
+	}
+	arg := s
+	if !safe {
+		var q strings.Builder
+		q.WriteByte('"')
+		slashes := 0
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			switch c {
+			case '\\':
+				slashes++
+			case '"':
+				q.WriteString(strings.Repeat(`\`, slashes+1))
+				slashes = 0
+			default:
+				slashes = 0
+			}
+			q.WriteByte(c)
+		}
+		q.WriteString(strings.Repeat(`\`, slashes))
+		q.WriteByte('"')
+		arg = q.String()
+	}
+
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(arg); i++ {
+		c := arg[i]
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '%':
+			b.WriteByte('%')
+		case !quoted && strings.IndexByte("&|<>^()", c) >= 0:
+			b.WriteByte('^')
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// psQuote single-quotes s for PowerShell, which doubles embedded single quotes
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmdQuote(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`C:\Users\me`, `C:\Users\me`},
+		{"a b", `"a b"`},
+		{"", `""`},
+		{"a&b", `"a&b"`},
+		{"50%", `"50%%"`},
+		{`say "hi"`, `"say \"hi\""`},
+		{`dir\ x\`, `"dir\ x\\"`},
+		{`a"b&c"`, `"a\"b^&c\""`},
+	}
+	for _, tt := range tests {
+		if got := cmdQuote(tt.in); got != tt.want {
+			t.Errorf("cmdQuote(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestGenerateAliasWindows(t *testing.T) {
+	a := CollectedAlias{
+		Name:           "mc",
+		Command:        "mycli --color",
+		Env:            map[string]string{"MODE": "a&b"},
+		EnvPassthrough: []string{"TOKEN"},
+		Ports:          []string{"8080"},
+		GPU:            "false",
+	}
+
+	cmd := generateAliasCmd("tools", a)
+	for _, want := range []string{
+		"@echo off\r\nREM ov-alias\r\nREM image: tools\r\nREM command: mycli --color\r\n",
+		`ov shell tools --no-gpu --port=8080 -e "MODE=a&b" -e TOKEN -c "mycli --color" -- %*` + "\r\n",
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf(".cmd wrapper doesn't contain %q:\n%s", want, cmd)
+		}
+	}
+	if strings.Contains(strings.ReplaceAll(cmd, "\r\n", ""), "\n") {
+		t.Errorf(".cmd wrapper has bare LF line endings:\n%q", cmd)
+	}
+
+	ps1 := generateAliasPS1("tools", a)
+	want := "& ov shell tools '--no-gpu' '--port=8080' '-e' 'MODE=a&b' '-e' 'TOKEN' -c 'mycli --color' -- @args\nexit $LASTEXITCODE\n"
+	if !strings.HasSuffix(ps1, want) {
+		t.Errorf(".ps1 wrapper =\n%s\nwant it to end with\n%s", ps1, want)
+	}
+}
+
+func TestWindowsAliasScripts(t *testing.T) {
+	dir := t.TempDir()
+	a := CollectedAlias{Name: "mc", Command: "mycli", Project: "/proj"}
+	if err := writeAliasScript(dir, "tools", a, "windows"); err != nil {
+		t.Fatalf("writeAliasScript() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mc")); !os.IsNotExist(err) {
+		t.Error("sh wrapper written for the windows target")
+	}
+
+	aliases, err := listAliasScripts(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aliases) != 2 {
+		t.Fatalf("listAliasScripts() = %+v, want the .cmd and .ps1 wrappers", aliases)
+	}
+	for _, info := range aliases {
+		if info.Name != "mc" || info.Image != "tools" || info.Command != "mycli" || info.Version != aliasScriptVersion || info.Project != "/proj" || info.File != aliasScriptFile("mc", info.Flavor) {
+			t.Errorf("alias = %+v", info)
+		}
+	}
+
+	// sync keeps both wrappers up to date and doesn't add an sh one
+	defs := map[string][]CollectedAlias{"tools": {{Name: "mc", Command: "mycli -v"}}}
+	var out strings.Builder
+	res, err := syncAliases(&out, dir, "/proj", defs, false, "unix")
+	if err != nil {
+		t.Fatalf("syncAliases() error = %v", err)
+	}
+	if res.Updated != 2 || res.Created != 0 {
+		t.Errorf("syncAliases() = %+v, want both wrappers updated\n%s", res, out.String())
+	}
+
+	if err := removeAliasScript(dir, "mc"); err != nil {
+		t.Fatalf("removeAliasScript() error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("%d file(s) left after removing the alias", len(entries))
+	}
+	if _, err := aliasFlavors("plan9"); err == nil {
+		t.Error("expected error for an unsupported target")
+	}
+}
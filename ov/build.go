@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
-	"os/exec"
+	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
 )
 
 // BuildCmd builds container images
@@ -15,6 +18,11 @@ type BuildCmd struct {
 	Tag      string   `long:"tag" help:"Override tag (default: CalVer)"`
 	Platform string   `long:"platform" help:"Target platform (default: host platform)"`
 	Cache    string   `long:"cache" help:"Build cache type (registry)" env:"OV_BUILD_CACHE"`
+	Parallel int      `long:"parallel" default:"1" help:"Build up to N images at once when their dependencies are built"`
+	Force    bool     `long:"force" help:"Rebuild images even if their build inputs are unchanged"`
+
+	Profile         string `long:"profile" help:"Apply a profile from images.yml"`
+	IncludeDisabled bool   `long:"include-disabled" help:"Also build images with enabled: false"`
 }
 
 func (c *BuildCmd) Run() error {
@@ -23,11 +31,18 @@ func (c *BuildCmd) Run() error {
 		return err
 	}
 
+	// Determine platform
+	platform := c.Platform
+	if platform == "" && !c.Push {
+		platform = hostPlatform()
+	}
+
 	// Generate Containerfiles
-	gen, err := NewGenerator(dir, c.Tag)
+	gen, err := NewGenerator(dir, c.Tag, ConfigOptions{Profile: c.Profile, IncludeDisabled: c.IncludeDisabled})
 	if err != nil {
 		return err
 	}
+	gen.Platform = platform
 	if err := gen.Generate(); err != nil {
 		return fmt.Errorf("generating build files: %w", err)
 	}
@@ -54,71 +69,389 @@ func (c *BuildCmd) Run() error {
 		}
 	}
 
-	// Determine platform
-	platform := c.Platform
-	if platform == "" && !c.Push {
-		platform = hostPlatform()
+	if c.Parallel < 1 {
+		return fmt.Errorf("--parallel must be at least 1, got %d", c.Parallel)
 	}
 
-	// Build each image in order, piping Containerfile content via stdin
-	// to avoid race conditions with concurrent ov generate overwrites
-	for _, name := range order {
+	state, err := LoadBuildState(gen.BuildDir)
+	if err != nil {
+		return err
+	}
+
+	// Build images once their dependencies are built, piping Containerfile
+	// content via stdin to avoid race conditions with concurrent ov generate
+	// overwrites. Parallel output is prefixed with the image name. Local builds
+	// reuse images whose inputs digest is unchanged since the last build.
+	var outMu, stateMu sync.Mutex
+	statuses, buildErr := scheduleBuilds(order, imageDependencies(gen.Images, gen.Layers), c.Parallel, func(name string) (string, error) {
+		var out io.Writer = os.Stderr
+		if c.Parallel > 1 {
+			pw := newPrefixWriter(os.Stderr, &outMu, "["+name+"] ")
+			defer pw.Flush()
+			out = pw
+		}
 		img := gen.Images[name]
-		content := gen.Containerfiles[name]
-		if err := c.buildImage(engine, dir, name, img, gen.Config, platform, rt.BuildEngine, content); err != nil {
-			return fmt.Errorf("building %s: %w", name, err)
+		digest := gen.InputDigests[name]
+		tags := img.Tags
+
+		key := buildStateKey(name, platform)
+		stateMu.Lock()
+		prev := state.Images[key]
+		stateMu.Unlock()
+		if !c.Force && !c.Push && unchangedImage(rt.BuildEngine, prev, digest) {
+			fmt.Fprintf(out, "\n--- %s unchanged, reusing %s ---\n", name, prev.Tag)
+			if err := tagImage(engine, prev.Tag, tags, out); err != nil {
+				return "", err
+			}
+			return buildUnchanged, nil
 		}
-	}
 
-	// Auto-merge if enabled
-	if !c.Push {
-		mergeCmd := &MergeCmd{All: true, Tag: "latest"}
-		if err := mergeCmd.Run(); err != nil {
-			// Non-fatal: log and continue
-			fmt.Fprintf(os.Stderr, "Warning: merge --all: %v\n", err)
+		content := gen.Containerfiles[name]
+		if err := c.buildImage(engine, dir, name, img, gen, platform, rt.BuildEngine, content, tags, out); err != nil {
+			return "", err
 		}
+		stateMu.Lock()
+		state.Images[key] = BuildStateEntry{Digest: digest, Tag: img.FullTag}
+		stateMu.Unlock()
+		return buildBuilt, nil
+	})
+	if len(order) > 1 {
+		printBuildSummary(os.Stderr, order, statuses)
+	}
+	if err := state.Save(gen.BuildDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: saving build state: %v\n", err)
+	}
+	if buildErr != nil {
+		return buildErr
 	}
 
 	return nil
 }
 
 // buildImage builds a single image with the configured engine.
-// containerfileContent is piped via stdin (-f -) to avoid race conditions
-// with concurrent ov generate overwrites on disk.
-func (c *BuildCmd) buildImage(engine, dir, name string, img *ResolvedImage, cfg *Config, platform, engineName, containerfileContent string) error {
-	// Compute tags
-	tags := []string{img.FullTag}
-	origCfg := cfg.Images[name]
-	if origCfg.Tag == "" || origCfg.Tag == "auto" {
-		if img.Registry != "" {
-			tags = append(tags, fmt.Sprintf("%s/%s:latest", img.Registry, name))
-		} else {
-			tags = append(tags, fmt.Sprintf("%s:latest", name))
-		}
-	}
-
+// containerfileContent is piped via stdin (-f -), or for docker written to a
+// private temporary copy, to avoid race conditions with concurrent ov generate
+// overwrites on disk.
+func (c *BuildCmd) buildImage(engine, dir, name string, img *ResolvedImage, gen *Generator, platform, engineName, containerfileContent string, tags []string, out io.Writer) error {
 	var args []string
+	cfg := gen.Config
 
 	if c.Push {
 		args = c.buildPushArgs(engine, tags, img.Platforms, engineName, name, img.Registry)
+		if engineName != "podman" {
+			// buildx pushes while building: keep unpushed intermediates in an
+			// OCI layout and resolve them from there as the base of children
+			if keptLocal(img, cfg) {
+				args = withLocalOutput(args, intermediateLayout(dir, name), img.FullTag)
+			}
+			if base, ok := gen.Images[img.Base]; ok && !img.IsExternalBase && keptLocal(base, cfg) {
+				args = withContextArgs(args, layoutContextArgs(intermediateLayout(dir, img.Base), base.FullTag)...)
+			}
+		}
 	} else {
 		args = c.buildLocalArgs(engine, tags, platform, name, img.Registry)
 	}
+	args = withContextArgs(args, gen.buildArgs()...)
+	if c.Cache == "" && img.CacheRegistry != "" && !(c.Push && engineName == "podman") {
+		args = withContextArgs(args, cacheRegistryArgs(name, img.CacheRegistry)...)
+	}
+	if len(img.Secrets) > 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("resolving secret paths: %w", err)
+		}
+		args = withSecretArgs(args, img.Secrets, home)
+	}
+	var stdin io.Reader = strings.NewReader(containerfileContent)
+	if engineName == "podman" {
+		args = withContextArgs(args, "--ignorefile", fmt.Sprintf(".build/%s/Containerfile.dockerignore", name))
+	} else {
+		// BuildKit has no --ignorefile and only reads Containerfile.dockerignore
+		// next to a Containerfile given by path: build from a private copy of both
+		files, err := os.MkdirTemp("", "ov-build-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(files)
+		containerfile, err := writeBuildFiles(files, containerfileContent, gen.ContextIgnores[name])
+		if err != nil {
+			return err
+		}
+		args = withContainerfile(args, containerfile)
+		stdin = nil
+	}
 
-	fmt.Fprintf(os.Stderr, "\n--- Building %s ---\n", name)
+	fmt.Fprintf(out, "\n--- Building %s ---\n", name)
 
-	cmd := exec.Command(args[0], args[1:]...)
+	cmd := engineCmd(args[0], args[1:]...)
 	cmd.Dir = dir
-	cmd.Stdin = strings.NewReader(containerfileContent)
-	cmd.Stdout = os.Stderr
-	cmd.Stderr = os.Stderr
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("%s build failed: %w", engine, err)
 	}
 
+	// merge.auto: merge the layers before images build on it or it is pushed
+	if img.Merge != nil && img.Merge.Auto {
+		if c.Push && engineName != "podman" {
+			fmt.Fprintf(out, "Warning: not merging %s, %s pushes it while building\n", name, engineName)
+		} else if err := mergeBuiltImage(engineName, img, tags, out); err != nil {
+			// Non-fatal: the unmerged image is still usable
+			fmt.Fprintf(out, "Warning: merging %s: %v\n", name, err)
+		}
+	}
+
+	// Podman builds the manifest list locally; push it separately
+	if c.Push && engineName == "podman" {
+		if keptLocal(img, cfg) {
+			fmt.Fprintf(out, "Skipping push of intermediate %s (push_intermediates: false)\n", name)
+			return nil
+		}
+		for _, pushArgs := range podmanManifestPushArgs(tags) {
+			cmd := engineCmd(pushArgs[0], pushArgs[1:]...)
+			cmd.Stdout = out
+			cmd.Stderr = out
+			if err := cmd.Run(); err != nil {
+				return fmt.Errorf("podman manifest push failed: %w", err)
+			}
+		}
+	}
+
 	return nil
 }
 
+// writeBuildFiles writes a Containerfile and its context ignore file to dir,
+// returning the Containerfile path
+func writeBuildFiles(dir, containerfile, ignore string) (string, error) {
+	path := filepath.Join(dir, "Containerfile")
+	if err := os.WriteFile(path, []byte(containerfile), 0644); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".dockerignore", []byte(ignore), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// withContainerfile replaces the stdin Containerfile (-f -) in build args with path
+func withContainerfile(args []string, path string) []string {
+	out := append([]string{}, args...)
+	for i := 1; i < len(out); i++ {
+		if out[i-1] == "-f" && out[i] == "-" {
+			out[i] = path
+		}
+	}
+	return out
+}
+
+// keptLocal reports whether ov build --push keeps img out of the registry: an
+// auto intermediate without push_intermediates: true
+func keptLocal(img *ResolvedImage, cfg *Config) bool {
+	return img.Auto && !cfg.Defaults.ShouldPushIntermediates()
+}
+
+// intermediateLayout is the OCI layout directory an auto intermediate kept out
+// of the registry is built into by docker buildx
+func intermediateLayout(dir, name string) string {
+	return filepath.Join(dir, ".build", name, "oci")
+}
+
+// withLocalOutput replaces --push in buildx args with an OCI layout output at
+// layout, annotated with ref (see layoutContextArgs)
+func withLocalOutput(args []string, layout, ref string) []string {
+	out := make([]string, 0, len(args)+1)
+	for _, arg := range args {
+		if arg == "--push" {
+			out = append(out, "--output", fmt.Sprintf("type=oci,dest=%s,tar=false,name=%s", layout, ref))
+			continue
+		}
+		out = append(out, arg)
+	}
+	return out
+}
+
+// layoutContextArgs returns the buildx flags that resolve the base image ref
+// (FROM ${BASE_IMAGE}) to its OCI layout instead of the registry
+func layoutContextArgs(layout, ref string) []string {
+	tag := ref[strings.LastIndex(ref, ":")+1:]
+	return []string{"--build-context", fmt.Sprintf("%s=oci-layout://%s:%s", ref, layout, tag)}
+}
+
+// mergeBuiltImage merges the layers of an image ov build just built with its
+// own merge settings, replacing its tags in the engine's local store
+func mergeBuiltImage(engineName string, img *ResolvedImage, tags []string, out io.Writer) error {
+	fmt.Fprintf(out, "\n--- Merging %s ---\n", img.Name)
+	return mergeEngineImage(engineName, tags, newMergePlanner(img.Merge, 0, 0, ""), out)
+}
+
+// unchangedImage reports whether the image from the last build can be reused:
+// its recorded digest matches and the local image still carries that digest label.
+func unchangedImage(engineName string, prev BuildStateEntry, digest string) bool {
+	if prev.Tag == "" || prev.Digest != digest {
+		return false
+	}
+	labels, err := InspectLabels(engineName, prev.Tag)
+	if err != nil {
+		return false
+	}
+	return labels[LabelInputsDigest] == digest
+}
+
+// tagImage tags an existing local image with each of tags
+func tagImage(engine, src string, tags []string, out io.Writer) error {
+	for _, tag := range tags {
+		if tag == src {
+			continue
+		}
+		cmd := engineCmd(engine, "tag", src, tag)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s tag %s %s failed: %w", engine, src, tag, err)
+		}
+	}
+	return nil
+}
+
+// Build outcomes reported in the summary
+const (
+	buildBuilt     = "built"
+	buildUnchanged = "unchanged"
+	buildFailed    = "failed"
+	buildSkipped   = "skipped"
+)
+
+// scheduleBuilds runs build for each image in order once all of its dependencies
+// (within order) are done, with at most parallel builds at a time. build returns
+// the outcome on success (built or unchanged). After the first failure no new
+// builds start; in-flight builds finish and the remaining images are skipped.
+// Returns each image's outcome and the first error.
+func scheduleBuilds(order []string, deps map[string][]string, parallel int, build func(name string) (string, error)) (map[string]string, error) {
+	inOrder := make(map[string]bool)
+	for _, name := range order {
+		inOrder[name] = true
+	}
+
+	type result struct {
+		name   string
+		status string
+		err    error
+	}
+	results := make(chan result)
+	statuses := make(map[string]string)
+	started := make(map[string]bool)
+	running := 0
+	var firstErr error
+
+	ready := func(name string) bool {
+		for _, dep := range deps[name] {
+			if inOrder[dep] && statuses[dep] != buildBuilt && statuses[dep] != buildUnchanged {
+				return false
+			}
+		}
+		return true
+	}
+
+	for {
+		if firstErr == nil {
+			for _, name := range order {
+				if running >= parallel {
+					break
+				}
+				if started[name] || !ready(name) {
+					continue
+				}
+				started[name] = true
+				running++
+				go func(name string) {
+					status, err := build(name)
+					results <- result{name, status, err}
+				}(name)
+			}
+		}
+		if running == 0 {
+			break
+		}
+		r := <-results
+		running--
+		if r.err != nil {
+			statuses[r.name] = buildFailed
+			if firstErr == nil {
+				firstErr = fmt.Errorf("building %s: %w", r.name, r.err)
+			}
+		} else {
+			statuses[r.name] = r.status
+		}
+	}
+
+	for _, name := range order {
+		if !started[name] {
+			statuses[name] = buildSkipped
+		}
+	}
+	return statuses, firstErr
+}
+
+// printBuildSummary prints one line per image with its build outcome
+func printBuildSummary(w io.Writer, order []string, statuses map[string]string) {
+	width := len("IMAGE")
+	for _, name := range order {
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+	fmt.Fprintf(w, "\n%-*s  %s\n", width, "IMAGE", "STATUS")
+	for _, name := range order {
+		fmt.Fprintf(w, "%-*s  %s\n", width, name, statuses[name])
+	}
+}
+
+// prefixWriter prefixes each complete line with a fixed string. Writers sharing
+// mu never interleave within a line.
+type prefixWriter struct {
+	w      io.Writer
+	mu     *sync.Mutex
+	prefix string
+	buf    []byte
+}
+
+func newPrefixWriter(w io.Writer, mu *sync.Mutex, prefix string) *prefixWriter {
+	return &prefixWriter{w: w, mu: mu, prefix: prefix}
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if err := p.writeLine(p.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes any trailing partial line
+func (p *prefixWriter) Flush() error {
+	if len(p.buf) == 0 {
+		return nil
+	}
+	err := p.writeLine(append(p.buf, '\n'))
+	p.buf = nil
+	return err
+}
+
+func (p *prefixWriter) writeLine(line []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, err := p.w.Write(append([]byte(p.prefix), line...))
+	return err
+}
+
 // buildLocalArgs constructs args for a local (single-platform, load into store) build.
 // Uses -f - to read the Containerfile from stdin.
 func (c *BuildCmd) buildLocalArgs(engine string, tags []string, platform, name, registry string) []string {
@@ -168,12 +501,26 @@ func (c *BuildCmd) cacheArgs(name, registry string) []string {
 			"--cache-to", fmt.Sprintf("type=registry,ref=%s,mode=max", ref),
 		}
 	case "gha":
+		return cacheRegistryArgs(name, "gha")
+	default:
+		return nil
+	}
+}
+
+// cacheRegistryArgs returns cache flags for an image's configured cache_registry.
+// "gha" selects the GitHub Actions cache; anything else is a repository that
+// stores one <image>:cache reference per image.
+func cacheRegistryArgs(name, cacheRegistry string) []string {
+	if cacheRegistry == "gha" {
 		return []string{
 			"--cache-from", fmt.Sprintf("type=gha,scope=%s", name),
 			"--cache-to", fmt.Sprintf("type=gha,mode=max,scope=%s", name),
 		}
-	default:
-		return nil
+	}
+	ref := fmt.Sprintf("%s/%s:cache", cacheRegistry, name)
+	return []string{
+		"--cache-from", fmt.Sprintf("type=registry,ref=%s", ref),
+		"--cache-to", fmt.Sprintf("type=registry,ref=%s,mode=max", ref),
 	}
 }
 
@@ -190,6 +537,40 @@ func (c *BuildCmd) buildPodmanPushArgs(tags []string, platforms []string) []stri
 	return args
 }
 
+// withSecretArgs inserts --secret flags for the image's build secrets before the
+// trailing build context argument. ~ and $HOME in a src expand to home.
+func withSecretArgs(args []string, secrets []SecretConfig, home string) []string {
+	if len(secrets) == 0 {
+		return args
+	}
+	var extra []string
+	for _, s := range secrets {
+		if s.Env != "" {
+			extra = append(extra, "--secret", fmt.Sprintf("id=%s,env=%s", s.ID, s.Env))
+		} else {
+			extra = append(extra, "--secret", fmt.Sprintf("id=%s,src=%s", s.ID, expandHome(s.Src, home)))
+		}
+	}
+	return withContextArgs(args, extra...)
+}
+
+// withContextArgs inserts extra flags before the trailing build context argument.
+func withContextArgs(args []string, extra ...string) []string {
+	out := append([]string{}, args[:len(args)-1]...)
+	out = append(out, extra...)
+	return append(out, args[len(args)-1])
+}
+
+// podmanManifestPushArgs returns one manifest push per tag for the manifest list
+// built by buildPodmanPushArgs (named after the first tag).
+func podmanManifestPushArgs(tags []string) [][]string {
+	var cmds [][]string
+	for _, tag := range tags {
+		cmds = append(cmds, []string{"podman", "manifest", "push", "--all", tags[0], "docker://" + tag})
+	}
+	return cmds
+}
+
 // hostPlatform returns the host platform in OCI format.
 func hostPlatform() string {
 	arch := runtime.GOARCH
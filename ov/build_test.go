@@ -1,8 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sync"
 	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 )
 
 func TestBuildLocalArgs(t *testing.T) {
@@ -56,6 +63,59 @@ func TestBuildDockerPushArgs(t *testing.T) {
 	}
 }
 
+func TestLocalIntermediateArgs(t *testing.T) {
+	cmd := &BuildCmd{}
+	push := cmd.buildDockerPushArgs([]string{"ghcr.io/o/fedora-pixi:2026.46.1415"}, []string{"linux/amd64"}, "fedora-pixi", "ghcr.io/o")
+	got := withLocalOutput(push, "/p/.build/fedora-pixi/oci", "ghcr.io/o/fedora-pixi:2026.46.1415")
+	want := []string{
+		"docker", "buildx", "build",
+		"--output", "type=oci,dest=/p/.build/fedora-pixi/oci,tar=false,name=ghcr.io/o/fedora-pixi:2026.46.1415",
+		"-f", "-",
+		"-t", "ghcr.io/o/fedora-pixi:2026.46.1415",
+		"--platform", "linux/amd64",
+		".",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("withLocalOutput() =\n  %v\nwant\n  %v", got, want)
+	}
+
+	ctx := layoutContextArgs("/p/.build/fedora-pixi/oci", "localhost:5000/o/fedora-pixi:2026.46.1415")
+	wantCtx := []string{"--build-context", "localhost:5000/o/fedora-pixi:2026.46.1415=oci-layout:///p/.build/fedora-pixi/oci:2026.46.1415"}
+	if !reflect.DeepEqual(ctx, wantCtx) {
+		t.Errorf("layoutContextArgs() = %v, want %v", ctx, wantCtx)
+	}
+
+	// Auto intermediates stay out of the registry unless push_intermediates: true
+	cfg := &Config{}
+	auto := &ResolvedImage{Auto: true}
+	if !keptLocal(auto, cfg) || keptLocal(&ResolvedImage{}, cfg) {
+		t.Error("keptLocal() should keep only auto intermediates by default")
+	}
+	cfg.Defaults.PushIntermediates = boolPtr(true)
+	if keptLocal(auto, cfg) {
+		t.Error("keptLocal() with push_intermediates: true")
+	}
+}
+
+func TestDockerBuildFiles(t *testing.T) {
+	dir := t.TempDir()
+	path, err := writeBuildFiles(dir, "FROM scratch\n", "*\n!layers/tool\n")
+	if err != nil {
+		t.Fatalf("writeBuildFiles() error = %v", err)
+	}
+	// BuildKit reads the ignore file next to the Containerfile
+	if ignore, err := os.ReadFile(filepath.Join(dir, "Containerfile.dockerignore")); err != nil || string(ignore) != "*\n!layers/tool\n" {
+		t.Errorf("Containerfile.dockerignore = %q, %v", ignore, err)
+	}
+
+	cmd := &BuildCmd{}
+	args := withContainerfile(cmd.buildLocalArgs("docker", []string{"app:test"}, "linux/amd64", "app", ""), path)
+	want := []string{"docker", "build", "-f", path, "-t", "app:test", "--platform", "linux/amd64", "."}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("withContainerfile() =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
 func TestBuildLocalArgsWithGHACache(t *testing.T) {
 	cmd := &BuildCmd{Cache: "gha"}
 	args := cmd.buildLocalArgs("docker",
@@ -241,3 +301,178 @@ func TestHostPlatform(t *testing.T) {
 		t.Logf("hostPlatform() = %q (non-standard arch, that's OK)", p)
 	}
 }
+
+func TestCacheRegistryArgs(t *testing.T) {
+	args := cacheRegistryArgs("fedora", "ghcr.io/overthinkos/cache")
+	want := []string{
+		"--cache-from", "type=registry,ref=ghcr.io/overthinkos/cache/fedora:cache",
+		"--cache-to", "type=registry,ref=ghcr.io/overthinkos/cache/fedora:cache,mode=max",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("cacheRegistryArgs() =\n  %v\nwant\n  %v", args, want)
+	}
+
+	args = cacheRegistryArgs("fedora", "gha")
+	want = []string{
+		"--cache-from", "type=gha,scope=fedora",
+		"--cache-to", "type=gha,mode=max,scope=fedora",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("cacheRegistryArgs(gha) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestPodmanManifestPushArgs(t *testing.T) {
+	cmds := podmanManifestPushArgs([]string{"ghcr.io/overthinkos/fedora:2026.46.1415", "ghcr.io/overthinkos/fedora:latest"})
+	want := [][]string{
+		{"podman", "manifest", "push", "--all", "ghcr.io/overthinkos/fedora:2026.46.1415", "docker://ghcr.io/overthinkos/fedora:2026.46.1415"},
+		{"podman", "manifest", "push", "--all", "ghcr.io/overthinkos/fedora:2026.46.1415", "docker://ghcr.io/overthinkos/fedora:latest"},
+	}
+	if !reflect.DeepEqual(cmds, want) {
+		t.Errorf("podmanManifestPushArgs() =\n  %v\nwant\n  %v", cmds, want)
+	}
+}
+
+func TestScheduleBuilds(t *testing.T) {
+	order := []string{"fedora", "ubuntu", "app", "tools", "child"}
+	deps := map[string][]string{
+		"app":   {"fedora"},
+		"tools": {"fedora"},
+		"child": {"app"},
+	}
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var built []string
+	statuses, err := scheduleBuilds(order, deps, 2, func(name string) (string, error) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		for _, dep := range deps[name] {
+			found := false
+			for _, b := range built {
+				found = found || b == dep
+			}
+			if !found {
+				t.Errorf("%s started before its dependency %s was built", name, dep)
+			}
+		}
+		mu.Unlock()
+
+		mu.Lock()
+		defer mu.Unlock()
+		running--
+		built = append(built, name)
+		if name == "ubuntu" {
+			return buildUnchanged, nil
+		}
+		return buildBuilt, nil
+	})
+	if err != nil {
+		t.Fatalf("scheduleBuilds() error = %v", err)
+	}
+	if maxRunning > 2 {
+		t.Errorf("ran %d builds at once, limit is 2", maxRunning)
+	}
+	for _, name := range order {
+		want := buildBuilt
+		if name == "ubuntu" {
+			want = buildUnchanged
+		}
+		if statuses[name] != want {
+			t.Errorf("%s status = %q, want %q", name, statuses[name], want)
+		}
+	}
+}
+
+func TestScheduleBuildsStopsOnFailure(t *testing.T) {
+	order := []string{"fedora", "app", "child"}
+	deps := map[string][]string{"app": {"fedora"}, "child": {"app"}}
+
+	statuses, err := scheduleBuilds(order, deps, 1, func(name string) (string, error) {
+		if name == "app" {
+			return "", errors.New("boom")
+		}
+		return buildBuilt, nil
+	})
+	if err == nil || err.Error() != "building app: boom" {
+		t.Errorf("error = %v, want building app: boom", err)
+	}
+	want := map[string]string{"fedora": buildBuilt, "app": buildFailed, "child": buildSkipped}
+	if !reflect.DeepEqual(statuses, want) {
+		t.Errorf("statuses = %v, want %v", statuses, want)
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	var mu sync.Mutex
+	pw := newPrefixWriter(&buf, &mu, "[app] ")
+	pw.Write([]byte("step 1\nstep"))
+	pw.Write([]byte(" 2\npartial"))
+	pw.Flush()
+
+	want := "[app] step 1\n[app] step 2\n[app] partial\n"
+	if buf.String() != want {
+		t.Errorf("output = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestPrintBuildSummary(t *testing.T) {
+	var buf bytes.Buffer
+	printBuildSummary(&buf, []string{"fedora", "app"}, map[string]string{"fedora": buildBuilt, "app": buildFailed})
+
+	want := "\nIMAGE   STATUS\nfedora  built\napp     failed\n"
+	if buf.String() != want {
+		t.Errorf("summary = %q, want %q", buf.String(), want)
+	}
+}
+
+func TestMergeBuiltImage(t *testing.T) {
+	origLoad, origSave := LoadEngineImage, SaveEngineImage
+	defer func() { LoadEngineImage, SaveEngineImage = origLoad, origSave }()
+
+	var source v1.Image
+	var savedRefs []string
+	var savedLayers int
+	LoadEngineImage = func(ref, engine string) (v1.Image, func(), error) {
+		if ref != "ghcr.io/x/app:v1" || engine != "docker" {
+			t.Errorf("LoadEngineImage(%q, %q), want the first tag from docker", ref, engine)
+		}
+		return source, func() {}, nil
+	}
+	SaveEngineImage = func(img v1.Image, engine string, refs ...string) error {
+		savedRefs = refs
+		layers, err := img.Layers()
+		if err != nil {
+			return err
+		}
+		savedLayers = len(layers)
+		return nil
+	}
+
+	tags := []string{"ghcr.io/x/app:v1", "ghcr.io/x/app:latest"}
+	img := &ResolvedImage{Name: "app", Merge: &MergeConfig{Auto: true, MaxMB: 128}}
+
+	// Small layers are merged and saved under every tag
+	source = indexImage(t, map[string]string{"a": "1"}, map[string]string{"b": "2"}, map[string]string{"c": "3"})
+	var out bytes.Buffer
+	if err := mergeBuiltImage("docker", img, tags, &out); err != nil {
+		t.Fatalf("mergeBuiltImage() error = %v", err)
+	}
+	if !reflect.DeepEqual(savedRefs, tags) || savedLayers != 1 {
+		t.Errorf("saved %d layers as %v, want 1 layer as %v", savedLayers, savedRefs, tags)
+	}
+
+	// Nothing to merge: the image is left alone
+	savedRefs = nil
+	source = indexImage(t, map[string]string{"a": "1"})
+	if err := mergeBuiltImage("docker", img, tags, &out); err != nil {
+		t.Fatalf("mergeBuiltImage() error = %v", err)
+	}
+	if savedRefs != nil {
+		t.Errorf("saved %v, want no save without layers to merge", savedRefs)
+	}
+}
@@ -53,7 +53,7 @@ func (c *EnableCmd) runEnable(rt *ResolvedRuntime) error {
 	dir, _ := os.Getwd()
 	cfg, cfgErr := LoadConfig(dir)
 	if cfgErr == nil {
-		resolved, err := cfg.ResolveImage(c.Image, "unused")
+		resolved, err := cfg.ResolveImage(c.Image, MetadataTag)
 		if err != nil {
 			return err
 		}
@@ -69,7 +69,7 @@ func (c *EnableCmd) runEnable(rt *ResolvedRuntime) error {
 		ports = resolved.Ports
 	} else {
 		imageRef = resolveShellImageRef("", c.Image, c.Tag)
-		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman"}
+		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman", Transfer: rt.Transfer, PullFallback: rt.PullFallback}
 		if err := EnsureImage(imageRef, podmanRT); err != nil {
 			return err
 		}
@@ -88,7 +88,7 @@ func (c *EnableCmd) runEnable(rt *ResolvedRuntime) error {
 	}
 
 	if cfgErr == nil {
-		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman"}
+		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman", Transfer: rt.Transfer, PullFallback: rt.PullFallback}
 		if err := EnsureImage(imageRef, podmanRT); err != nil {
 			return err
 		}
@@ -179,7 +179,7 @@ func (c *StatusCmd) Run() error {
 	// Direct mode: engine inspect
 	engine := EngineBinary(rt.RunEngine)
 	name := containerName(c.Image)
-	cmd := exec.Command(engine, "inspect", name)
+	cmd := engineCmd(engine, "inspect", name)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
@@ -200,7 +200,12 @@ func (c *LogsCmd) Run() error {
 		return err
 	}
 
-	if rt.RunMode == "quadlet" {
+	// A container of ov up takes precedence over the service of ov start
+	dir, _ := os.Getwd()
+	name := containerName(c.Image)
+	if up := serviceContainerName(dir, c.Image); ContainerStatus(rt.RunEngine, up) != "" {
+		name = up
+	} else if rt.RunMode == "quadlet" {
 		svc := serviceName(c.Image)
 		args := []string{"--user", "-u", svc}
 		if c.Follow {
@@ -217,13 +222,12 @@ func (c *LogsCmd) Run() error {
 
 	// Direct mode: engine logs
 	engine := EngineBinary(rt.RunEngine)
-	name := containerName(c.Image)
 	args := []string{"logs"}
 	if c.Follow {
 		args = append(args, "-f")
 	}
 	args = append(args, name)
-	cmd := exec.Command(engine, args...)
+	cmd := engineCmd(engine, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
@@ -249,7 +253,7 @@ func (c *UpdateCmd) Run() error {
 		return err
 	}
 
-	resolved, err := cfg.ResolveImage(c.Image, "unused")
+	resolved, err := cfg.ResolveImage(c.Image, MetadataTag)
 	if err != nil {
 		return err
 	}
@@ -262,7 +266,7 @@ func (c *UpdateCmd) Run() error {
 	}
 
 	if rt.RunMode == "quadlet" {
-		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman"}
+		podmanRT := &ResolvedRuntime{BuildEngine: rt.BuildEngine, RunEngine: "podman", Transfer: rt.Transfer, PullFallback: rt.PullFallback}
 		if err := EnsureImage(imageRef, podmanRT); err != nil {
 			return err
 		}
@@ -335,11 +339,11 @@ func (c *RemoveCmd) Run() error {
 	name := containerName(c.Image)
 
 	// Best-effort stop
-	stop := exec.Command(engine, "stop", name)
+	stop := engineCmd(engine, "stop", name)
 	_ = stop.Run()
 
 	// Remove container (tolerate "no such container")
-	rm := exec.Command(engine, "rm", name)
+	rm := engineCmd(engine, "rm", name)
 	_ = rm.Run()
 
 	fmt.Fprintf(os.Stderr, "Removed container %s\n", name)
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// composeNetwork is the shared network all exported services join
+const composeNetwork = "ov"
+
+// generateCompose writes .build/compose.yaml with one service per image that
+// runs supervisord. Images without service layers are skipped.
+func (g *Generator) generateCompose(order []string) error {
+	content, err := g.composeYAML(order)
+	if err != nil {
+		return err
+	}
+	return g.writeGenerated(filepath.Join(g.BuildDir, "compose.yaml"), content)
+}
+
+// composeYAML renders the compose file for the service images in build order
+func (g *Generator) composeYAML(order []string) (string, error) {
+	var b strings.Builder
+	var volumeNames []string
+
+	b.WriteString("# .build/compose.yaml (generated by ov generate --compose -- do not edit)\n")
+	b.WriteString("services:\n")
+	for _, name := range order {
+		img := g.Images[name]
+		if img.Auto || !g.imageRunsServices(img) {
+			continue
+		}
+		volumes, err := CollectImageVolumes(g.Config, g.Layers, name, img.Home)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(fmt.Sprintf("  %s:\n", name))
+		b.WriteString(fmt.Sprintf("    image: %s\n", img.FullTag))
+		b.WriteString(fmt.Sprintf("    container_name: %s\n", containerName(name)))
+		b.WriteString("    command: [\"supervisord\", \"-n\", \"-c\", \"/etc/supervisord.conf\"]\n")
+		if len(img.Ports) > 0 {
+			b.WriteString("    ports:\n")
+			for _, port := range img.Ports {
+				b.WriteString(fmt.Sprintf("      - %q\n", localizePort(port)))
+			}
+		}
+		if len(volumes) > 0 {
+			b.WriteString("    volumes:\n")
+			for _, vol := range volumes {
+				b.WriteString(fmt.Sprintf("      - %s:%s\n", vol.VolumeName, vol.ContainerPath))
+				volumeNames = append(volumeNames, vol.VolumeName)
+			}
+		}
+		if img.GPU {
+			b.WriteString("    deploy:\n")
+			b.WriteString("      resources:\n")
+			b.WriteString("        reservations:\n")
+			b.WriteString("          devices:\n")
+			b.WriteString("            - driver: nvidia\n")
+			b.WriteString("              count: all\n")
+			b.WriteString("              capabilities: [gpu]\n")
+		}
+		b.WriteString("    networks:\n")
+		b.WriteString(fmt.Sprintf("      - %s\n", composeNetwork))
+	}
+
+	b.WriteString("networks:\n")
+	b.WriteString(fmt.Sprintf("  %s: {}\n", composeNetwork))
+	if len(volumeNames) > 0 {
+		sortStrings(volumeNames)
+		b.WriteString("volumes:\n")
+		for _, vol := range volumeNames {
+			b.WriteString(fmt.Sprintf("  %s: {}\n", vol))
+		}
+	}
+	return b.String(), nil
+}
+
+// imageRunsServices returns true if the image or any image it is built on
+// installs a supervisord service layer.
+func (g *Generator) imageRunsServices(img *ResolvedImage) bool {
+	for cur := img; cur != nil; {
+		resolved, _ := ResolveLayerOrder(cur.Layers, g.Layers, nil)
+		for _, layerName := range resolved {
+			if layer, ok := g.Layers[layerName]; ok && layer.HasSupervisord {
+				return true
+			}
+		}
+		if cur.IsExternalBase {
+			break
+		}
+		cur = g.Images[cur.Base]
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateCompose(t *testing.T) {
+	g := &Generator{
+		Config: &Config{Images: map[string]ImageConfig{
+			"fedora": {Layers: []string{"tool"}},
+			"web":    {Base: "fedora", Layers: []string{"svc"}, Ports: []string{"8080:8080"}},
+			"gpu":    {Base: "web", Layers: []string{"tool"}, GPU: boolPtr(true)},
+		}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasRootYml: true},
+			"svc": {
+				Name:           "svc",
+				HasSupervisord: true,
+				HasVolumes:     true,
+				volumes:        []VolumeYAML{{Name: "data", Path: "~/.web"}},
+			},
+		},
+		Images: map[string]*ResolvedImage{
+			"fedora": {Name: "fedora", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{"tool"}, Home: "/home/user", FullTag: "ghcr.io/overthinkos/fedora:2026.46.1415"},
+			"web":    {Name: "web", Base: "fedora", Layers: []string{"svc"}, Ports: []string{"8080:8080"}, Home: "/home/user", FullTag: "ghcr.io/overthinkos/web:2026.46.1415"},
+			"gpu":    {Name: "gpu", Base: "web", Layers: []string{"tool"}, GPU: true, Home: "/home/user", FullTag: "ghcr.io/overthinkos/gpu:2026.46.1415"},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateCompose([]string{"fedora", "web", "gpu"}); err != nil {
+		t.Fatalf("generateCompose() error = %v", err)
+	}
+	got, err := os.ReadFile(filepath.Join(g.BuildDir, "compose.yaml"))
+	if err != nil {
+		t.Fatalf("reading compose.yaml: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "compose.golden.yaml"))
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("compose.yaml =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -4,45 +4,258 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the images.yml configuration file
 type Config struct {
-	Defaults ImageConfig            `yaml:"defaults"`
-	Images   map[string]ImageConfig `yaml:"images"`
+	Include  []string                 `yaml:"include,omitempty"` // glob patterns of image fragments, relative to images.yml
+	Defaults ImageConfig              `yaml:"defaults"`
+	Images   map[string]ImageConfig   `yaml:"images"`
+	Profiles map[string]ProfileConfig `yaml:"profiles,omitempty"` // overlays selected with --profile
+
+	FailOnUnused   bool                   `yaml:"fail_on_unused,omitempty"`   // ov validate fails on layers no image uses
+	StrictBaseTags bool                   `yaml:"strict_base_tags,omitempty"` // ov validate fails on external bases without a pinned tag
+	Validate       ValidateConfig         `yaml:"validate,omitempty"`         // ov validate settings (see deep.go)
+	RemoteLayers   map[string]RemoteLayer `yaml:"remote_layers,omitempty"`    // layers fetched from git (see remote_layers.go)
+	Intermediates  IntermediatesConfig    `yaml:"intermediates,omitempty"`    // when auto intermediates are created
+}
+
+// ConfigOptions selects per-run variations of images.yml
+type ConfigOptions struct {
+	Profile         string // profile to apply ("" for none)
+	IncludeDisabled bool   // treat every image as enabled
+}
+
+// configFragment is an included file: images only
+type configFragment struct {
+	Include  []string                 `yaml:"include"`
+	Defaults *ImageConfig             `yaml:"defaults"`
+	Images   map[string]ImageConfig   `yaml:"images"`
+	Profiles map[string]ProfileConfig `yaml:"profiles"`
+}
+
+// IntermediatesConfig controls the creation of auto intermediates at branch
+// points (see ComputeIntermediates). Branch points below the thresholds get no
+// intermediate; their images build on the parent directly.
+type IntermediatesConfig struct {
+	Enabled         *bool                         `yaml:"enabled,omitempty"`           // false: no auto intermediates at all (default true)
+	MinSharedImages int                           `yaml:"min_shared_images,omitempty"` // images that must share a prefix (default 2)
+	MinSharedLayers int                           `yaml:"min_shared_layers,omitempty"` // layers a shared prefix must have (default 1)
+	Pinned          map[string]PinnedIntermediate `yaml:"pinned,omitempty"`            // intermediates always built, by name
+	OrderBy         string                        `yaml:"order_by,omitempty" schema:"enum:popularity|size"`
+	PackageSizeMB   int                           `yaml:"package_size_mb,omitempty"` // size estimate per package for order_by: size (default 10)
+	BaseNames       map[string]string             `yaml:"base_names,omitempty"`      // external base ref -> its name in auto intermediate names
+}
+
+// Layer orders of intermediates.order_by
+const (
+	OrderByPopularity = "popularity" // images needing the layer (default)
+	OrderBySize       = "size"       // images needing the layer times its estimated size
+)
+
+// DefaultPackageSizeMB is the size estimate per rpm/deb/apk package of a layer
+// for intermediates.order_by: size
+const DefaultPackageSizeMB = 10
+
+// DefaultBase is the base of images without base in the image or defaults
+const DefaultBase = "quay.io/fedora/fedora:43"
+
+// PinnedIntermediate is an intermediate declared in intermediates.pinned. It is
+// built with exactly its layers (and their dependencies), and images whose
+// layer sequence starts with them build on it instead of an auto intermediate.
+type PinnedIntermediate struct {
+	Base        string   `yaml:"base,omitempty"`         // parent image (default: defaults.base)
+	Layers      []string `yaml:"layers"`                 // layers it contains
+	AllowUnused bool     `yaml:"allow_unused,omitempty"` // no validation error when no image builds on it
+}
+
+// IsEnabled returns true unless auto intermediates are turned off
+func (ic *IntermediatesConfig) IsEnabled() bool {
+	return ic.Enabled == nil || *ic.Enabled
+}
+
+// allows reports whether a prefix of pathLayers shared by images is worth an
+// auto intermediate
+func (ic *IntermediatesConfig) allows(pathLayers, images []string) bool {
+	return len(images) >= ic.MinSharedImages && len(pathLayers) >= ic.MinSharedLayers
 }
 
 // MergeConfig configures post-build layer merging
 type MergeConfig struct {
-	Auto  bool `yaml:"auto,omitempty"`   // enable automatic merging after builds
-	MaxMB int  `yaml:"max_mb,omitempty"` // maximum size of a merged layer (default: 1024)
+	Auto     bool   `yaml:"auto,omitempty"`   // enable automatic merging after builds
+	MaxMB    int    `yaml:"max_mb,omitempty"` // maximum size of a merged layer (default: 1024)
+	MinMB    int    `yaml:"min_mb,omitempty"` // groups below this size merge into the next one when it fits
+	Strategy string `yaml:"strategy,omitempty" schema:"enum:greedy|pack"`
+
+	// PreserveBaseBoundary marks where the image's own layers start (see
+	// LabelLayerBoundary) and never merges across such markers
+	PreserveBaseBoundary bool `yaml:"preserve_base_boundary,omitempty"`
+
+	// Reproducible sets the timestamps of merged layers, their history and
+	// the config to SOURCE_DATE_EPOCH (default: 0)
+	Reproducible bool `yaml:"reproducible,omitempty"`
+}
+
+// ShellConfig holds the defaults of ov shell for an image. The --mount,
+// --publish, --env-passthrough and run option flags are merged on top.
+type ShellConfig struct {
+	Mounts []string `yaml:"mounts,omitempty"` // bind mounts "src[:dst][:ro]" (relative src from the current directory)
+	Ports  []string `yaml:"ports,omitempty"`  // published ports ["port" or "host:container"], only for ov shell
+
+	EnvPassthrough []string `yaml:"env_passthrough,omitempty"` // host variables to pass, names or globs like AWS_*
+
+	// Run options (see RunOptions), replaced by the flag of the same name
+	Network  string  `yaml:"network,omitempty"`
+	ReadOnly bool    `yaml:"read_only,omitempty"`
+	Memory   string  `yaml:"memory,omitempty"`
+	CPUs     float64 `yaml:"cpus,omitempty"`
+	ShmSize  string  `yaml:"shm_size,omitempty"`
 }
 
 // AliasConfig represents a command alias in images.yml
 type AliasConfig struct {
-	Name    string `yaml:"name"`
-	Command string `yaml:"command,omitempty"` // defaults to Name if empty
+	Name           string            `yaml:"name" schema:"pattern:alias"`
+	Command        string            `yaml:"command,omitempty"`         // defaults to Name if empty
+	MountCwd       *bool             `yaml:"mount_cwd,omitempty"`       // mount the caller's directory (default: true)
+	Env            map[string]string `yaml:"env,omitempty"`             // set in the container
+	EnvPassthrough []string          `yaml:"env_passthrough,omitempty"` // forwarded from the host when set
+
+	Completion        string `yaml:"completion,omitempty" schema:"enum:bash|zsh|fish"` // shell to install a completion for
+	CompletionCommand string `yaml:"completion_command,omitempty"`                     // prints the completion script in the image
+
+	GPU     AliasGPU `yaml:"gpu,omitempty"`      // GPU passthrough (default: auto)
+	Ports   []string `yaml:"ports,omitempty"`    // "port" or "host:container", like image ports
+	RunArgs []string `yaml:"run_args,omitempty"` // extra engine run arguments
+}
+
+// AliasGPU is the GPU passthrough of an alias: "true", "false" or "auto" (the
+// default, ov shell detects the GPU). In YAML it's a boolean or "auto".
+type AliasGPU string
+
+// UnmarshalYAML decodes gpu from true, false or "auto".
+func (g *AliasGPU) UnmarshalYAML(value *yaml.Node) error {
+	var on bool
+	if err := value.Decode(&on); err == nil {
+		*g = AliasGPU(strconv.FormatBool(on))
+		return nil
+	}
+	if value.Kind != yaml.ScalarNode || value.Value != "auto" {
+		return fmt.Errorf("line %d: gpu must be true, false or auto", value.Line)
+	}
+	*g = "auto"
+	return nil
+}
+
+// ShellFlag returns the ov shell flag for the setting, "" for auto.
+func (g AliasGPU) ShellFlag() string {
+	switch g {
+	case "true":
+		return "--gpu"
+	case "false":
+		return "--no-gpu"
+	default:
+		return ""
+	}
+}
+
+// Command is a container command in exec form. In YAML it accepts either a
+// list of arguments or a single string, which is wrapped in /bin/sh -c like
+// the Containerfile shell form.
+type Command []string
+
+// UnmarshalYAML decodes a command from a string or a list of strings.
+func (c *Command) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		if value.Value == "" {
+			*c = nil
+			return nil
+		}
+		*c = Command{"/bin/sh", "-c", value.Value}
+		return nil
+	case yaml.SequenceNode:
+		var args []string
+		if err := value.Decode(&args); err != nil {
+			return err
+		}
+		*c = args
+		return nil
+	default:
+		return fmt.Errorf("line %d: command must be a string or a list of strings", value.Line)
+	}
+}
+
+// HealthcheckConfig represents a HEALTHCHECK declaration in layer.yml or images.yml.
+// Durations use Go syntax ("30s", "1m30s"); empty values use the engine defaults.
+type HealthcheckConfig struct {
+	Cmd         Command `yaml:"cmd"`
+	Interval    string  `yaml:"interval,omitempty"`
+	Timeout     string  `yaml:"timeout,omitempty"`
+	StartPeriod string  `yaml:"start_period,omitempty"`
+	Retries     int     `yaml:"retries,omitempty"`
+}
+
+// SecretConfig provides a build secret that layers mount via layer.yml secrets.
+// Exactly one of Src (a file on the build host) or Env (a host environment variable) is set.
+type SecretConfig struct {
+	ID  string `yaml:"id" schema:"pattern:secret_id"`
+	Src string `yaml:"src,omitempty"`
+	Env string `yaml:"env,omitempty"`
 }
 
 // ImageConfig represents configuration for a single image or defaults
 type ImageConfig struct {
-	Enabled   *bool         `yaml:"enabled,omitempty"`
-	Base      string        `yaml:"base,omitempty"`
-	Bootc     bool          `yaml:"bootc,omitempty"`
-	Platforms []string      `yaml:"platforms,omitempty"`
-	Tag       string        `yaml:"tag,omitempty"`
-	Registry  string        `yaml:"registry,omitempty"`
-	Pkg       string        `yaml:"pkg,omitempty"`
-	Layers    []string      `yaml:"layers,omitempty"`
-	Ports     []string      `yaml:"ports,omitempty"`    // runtime port mappings ["host:container"]
-	User      string        `yaml:"user,omitempty"`     // username (default: "user")
-	UID       *int          `yaml:"uid,omitempty"`      // user ID (default: 1000)
-	GID       *int          `yaml:"gid,omitempty"`      // group ID (default: 1000)
-	Merge     *MergeConfig  `yaml:"merge,omitempty"`    // layer merge settings
-	Aliases   []AliasConfig `yaml:"aliases,omitempty"`  // command aliases
-	Builder   string        `yaml:"builder,omitempty"`  // builder image name (per-image, falls back to defaults)
+	Enabled           *bool              `yaml:"enabled,omitempty"`
+	Extends           string             `yaml:"extends,omitempty"`        // copy unset fields from another image's config
+	InheritLayers     bool               `yaml:"inherit_layers,omitempty"` // with extends: parent layers first, then own
+	Base              string             `yaml:"base,omitempty"`
+	Bootc             bool               `yaml:"bootc,omitempty"`
+	Cleanup           *bool              `yaml:"cleanup,omitempty"` // remove package manager and temp leftovers at the end (an image's false overrides the defaults)
+	GPU               *bool              `yaml:"gpu,omitempty"`     // request GPU devices in the compose export (an image's false overrides the defaults)
+	Platforms         []string           `yaml:"platforms,omitempty" schema:"pattern:platform"`
+	Tag               string             `yaml:"tag,omitempty"`
+	TagFormat         string             `yaml:"tag_format,omitempty"` // layout for computed tags (defaults only, CalVer if empty)
+	TagSuffix         string             `yaml:"tag_suffix,omitempty"` // "git" appends -g<sha>[-dirty] to computed tags (defaults only)
+	Latest            *bool              `yaml:"latest,omitempty"`     // also tag auto-tagged images :latest (default true)
+	Registry          string             `yaml:"registry,omitempty"`
+	Pkg               string             `yaml:"pkg,omitempty" schema:"enum:rpm|deb|apk"`
+	Layers            []string           `yaml:"layers,omitempty"`
+	Ports             []string           `yaml:"ports,omitempty"`               // runtime port mappings ["host:container"]
+	User              string             `yaml:"user,omitempty"`                // username (default: "user")
+	UID               *int               `yaml:"uid,omitempty"`                 // user ID (default: 1000)
+	GID               *int               `yaml:"gid,omitempty"`                 // group ID (default: 1000)
+	Merge             *MergeConfig       `yaml:"merge,omitempty"`               // layer merge settings
+	Shell             *ShellConfig       `yaml:"shell,omitempty"`               // ov shell mounts and ports
+	Aliases           []AliasConfig      `yaml:"aliases,omitempty"`             // command aliases
+	Builder           string             `yaml:"builder,omitempty"`             // builder image name (per-image, falls back to defaults)
+	CacheID           string             `yaml:"cache_id,omitempty"`            // cache mount id namespace (defaults only)
+	CacheRegistry     string             `yaml:"cache_registry,omitempty"`      // build cache repository for ov build ("gha" for GitHub Actions)
+	PushIntermediates *bool              `yaml:"push_intermediates,omitempty"`  // push auto intermediates in ov build --push (defaults only, default false)
+	AutoPrefix        string             `yaml:"intermediate_prefix,omitempty"` // name prefix of auto intermediates, e.g. "ov-int-" (defaults only)
+	AutoNaming        string             `yaml:"intermediate_names,omitempty" schema:"enum:last-layer|path-hash|joined"`
+	NoIntermediates   bool               `yaml:"exclude_from_intermediates,omitempty"`
+	TaskVersion       string             `yaml:"task_version,omitempty"`       // go-task release for the bootstrap ("latest" opts out of pinning)
+	TaskSHA256        map[string]string  `yaml:"task_sha256,omitempty"`        // per-arch sha256 of the task tarball (amd64, arm64)
+	Secrets           []SecretConfig     `yaml:"secrets,omitempty"`            // build secrets for layer secret mounts (merged with defaults)
+	Labels            map[string]string  `yaml:"labels,omitempty"`             // extra OCI labels (merged with defaults)
+	Entrypoint        Command            `yaml:"entrypoint,omitempty"`         // ENTRYPOINT (per-image only)
+	Cmd               Command            `yaml:"cmd,omitempty"`                // CMD (per-image only)
+	Healthcheck       *HealthcheckConfig `yaml:"healthcheck,omitempty"`        // HEALTHCHECK (per-image, overrides layers)
+	ContainerfilePre  string             `yaml:"containerfile_pre,omitempty"`  // raw Containerfile lines after bootstrap (per-image only)
+	ContainerfilePost string             `yaml:"containerfile_post,omitempty"` // raw Containerfile lines before final USER (per-image only)
+}
+
+// ShouldPushIntermediates returns true if ov build --push pushes auto intermediates (nil defaults to false)
+func (ic *ImageConfig) ShouldPushIntermediates() bool {
+	if ic.PushIntermediates == nil {
+		return false
+	}
+	return *ic.PushIntermediates
 }
 
 // IsEnabled returns true if the image is enabled (nil defaults to true)
@@ -53,6 +266,16 @@ func (ic *ImageConfig) IsEnabled() bool {
 	return *ic.Enabled
 }
 
+// IncludeDisabled enables every disabled image for this run (--include-disabled)
+func (c *Config) IncludeDisabled() {
+	for name, img := range c.Images {
+		if !img.IsEnabled() {
+			img.Enabled = nil
+			c.Images[name] = img
+		}
+	}
+}
+
 // boolPtr returns a pointer to a bool value
 func boolPtr(v bool) *bool {
 	return &v
@@ -63,6 +286,8 @@ type ResolvedImage struct {
 	Name      string
 	Base      string   // Resolved base (external OCI ref or internal image name)
 	Bootc     bool
+	Cleanup   bool // final cleanup RUN (never for auto-intermediates)
+	GPU       bool // compose export requests GPU devices
 	Platforms []string
 	Tag       string
 	Registry  string
@@ -70,6 +295,10 @@ type ResolvedImage struct {
 	Layers    []string
 	Ports     []string // runtime port mappings
 
+	// Ports exposed by layer.yml across the image and its base chain
+	// (deduplicated, sorted; filled in by ov inspect)
+	ExposedPorts []string
+
 	// User configuration
 	User string // username
 	UID  int    // user ID
@@ -79,19 +308,64 @@ type ResolvedImage struct {
 	// Merge configuration
 	Merge *MergeConfig // layer merge settings (nil means use CLI defaults)
 
+	// ov shell defaults (resolved: image -> defaults -> nil)
+	Shell *ShellConfig
+
 	// Builder image name (resolved: image -> defaults -> "")
 	Builder string
 
+	// Build cache import/export for ov build (resolved: image -> defaults -> "")
+	CacheRegistry string
+
+	// go-task release installed by the bootstrap (resolved: image -> defaults -> DefaultTaskVersion)
+	// and optional per-arch tarball checksums
+	TaskVersion string
+	TaskSHA256  map[string]string
+
+	// Build secrets (defaults merged with image by id, image wins)
+	Secrets []SecretConfig
+
+	// Extra OCI labels (defaults merged with image, image wins)
+	Labels map[string]string
+
+	// Container command (image-only, not inherited from defaults)
+	Entrypoint Command
+	Cmd        Command
+
+	// Healthcheck override (image-only; nil means use the last layer that declares one)
+	Healthcheck *HealthcheckConfig
+
+	// Raw Containerfile snippets (image-only): pre runs after bootstrap, post before the final USER
+	ContainerfilePre  string
+	ContainerfilePost string
+
+	// Left alone by ComputeIntermediates (exclude_from_intermediates, image-only)
+	NoIntermediates bool
+
 	// Auto-generated intermediate image
-	Auto bool // true for auto-generated intermediate images
+	Auto      bool   // true for auto-generated intermediate images
+	Pinned    bool   // true for intermediates.pinned images (generated, but built like user images)
+	BaseGroup string // external base at the root of an intermediate's base chain
+
+	// Settings the images building on an intermediate disagree on, left at
+	// their defaults (ov validate warns about them)
+	BranchConflicts []string
 
 	// Derived fields
-	IsExternalBase bool   // true if base is external OCI image, false if internal
-	FullTag        string // registry/name:tag
+	IsExternalBase bool     // true if base is external OCI image, false if internal
+	FullTag        string   // registry/name:tag
+	Tags           []string // tags to build with: FullTag, plus :latest for auto tags (never for auto-intermediates)
 }
 
 // LoadConfig reads and parses images.yml from the given directory
 func LoadConfig(dir string) (*Config, error) {
+	return LoadConfigWith(dir, ConfigOptions{})
+}
+
+// LoadConfigWith reads images.yml like LoadConfig and applies opts: includes,
+// extends and the profile are applied in that order before variables are
+// expanded.
+func LoadConfigWith(dir string, opts ConfigOptions) (*Config, error) {
 	path := filepath.Join(dir, "images.yml")
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -99,13 +373,295 @@ func LoadConfig(dir string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("parsing images.yml: %w", err)
+	if err := unmarshalStrict("images.yml", data, &cfg); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.loadIncludes(dir); err != nil {
+		return nil, err
+	}
+
+	cfg.applyExtends()
+
+	if err := cfg.applyProfile(opts.Profile); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.interpolate(); err != nil {
+		return nil, fmt.Errorf("images.yml: %w", err)
+	}
+
+	if format := cfg.Defaults.TagFormat; format != "" {
+		if err := validateTagFormat(format); err != nil {
+			return nil, fmt.Errorf("images.yml: defaults.tag_format: %w", err)
+		}
+	}
+	if suffix := cfg.Defaults.TagSuffix; suffix != "" && suffix != "git" {
+		return nil, fmt.Errorf("images.yml: defaults.tag_suffix: must be \"git\", got %q", suffix)
+	}
+
+	if opts.IncludeDisabled {
+		cfg.IncludeDisabled()
 	}
 
 	return &cfg, nil
 }
 
+// loadIncludes merges the images of every file matched by the include globs,
+// in pattern order and lexical order within a pattern. An image defined in
+// more than one file is an error; fragments may not set defaults or include.
+func (c *Config) loadIncludes(dir string) error {
+	sources := make(map[string]string, len(c.Images))
+	for name := range c.Images {
+		sources[name] = "images.yml"
+	}
+	seen := make(map[string]bool)
+	for _, pattern := range c.Include {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return fmt.Errorf("images.yml: include %q: %w", pattern, err)
+		}
+		sortStrings(matches)
+		for _, path := range matches {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				rel = path
+			}
+			if seen[rel] || rel == "images.yml" {
+				continue
+			}
+			seen[rel] = true
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("reading %s: %w", rel, err)
+			}
+			var frag configFragment
+			if err := unmarshalStrict(rel, data, &frag); err != nil {
+				return err
+			}
+			if frag.Defaults != nil {
+				return fmt.Errorf("%s: defaults may only be set in images.yml", rel)
+			}
+			if len(frag.Profiles) > 0 {
+				return fmt.Errorf("%s: profiles may only be defined in images.yml", rel)
+			}
+			if len(frag.Include) > 0 {
+				return fmt.Errorf("%s: include may only be used in images.yml", rel)
+			}
+
+			var names []string
+			for name := range frag.Images {
+				names = append(names, name)
+			}
+			sortStrings(names)
+			for _, name := range names {
+				if prev, ok := sources[name]; ok {
+					return fmt.Errorf("%s: image %q is already defined in %s", rel, name, prev)
+				}
+				if c.Images == nil {
+					c.Images = make(map[string]ImageConfig)
+				}
+				c.Images[name] = frag.Images[name]
+				sources[name] = rel
+			}
+		}
+	}
+	return nil
+}
+
+// applyExtends fills the unset fields of every image with extends from the
+// image it extends (after that image's own extends), before defaults apply.
+// Images in an extends cycle or extending an unknown image are left as is;
+// validation reports them.
+func (c *Config) applyExtends() {
+	done := make(map[string]bool)
+	var apply func(name string, visiting map[string]bool) bool
+	apply = func(name string, visiting map[string]bool) bool {
+		if done[name] {
+			return true
+		}
+		img, ok := c.Images[name]
+		if !ok || visiting[name] {
+			return false
+		}
+		if img.Extends != "" {
+			visiting[name] = true
+			if !apply(img.Extends, visiting) {
+				return false
+			}
+			img = extendImageConfig(img, c.Images[img.Extends])
+			c.Images[name] = img
+		}
+		done[name] = true
+		return true
+	}
+	for name := range c.Images {
+		apply(name, make(map[string]bool))
+	}
+}
+
+// extendImageConfig returns child with every zero-valued field taken from
+// parent. enabled is never inherited; layers are parent's followed by the
+// child's with inherit_layers, otherwise the child's own (even if empty).
+// Inherited maps, slices and pointers are copies, so child and parent can be
+// changed independently.
+func extendImageConfig(child, parent ImageConfig) ImageConfig {
+	cv := reflect.ValueOf(&child).Elem()
+	pv := reflect.ValueOf(parent)
+	for i := 0; i < cv.NumField(); i++ {
+		switch cv.Type().Field(i).Name {
+		case "Enabled", "Extends", "InheritLayers", "Layers":
+			continue
+		}
+		if cv.Field(i).IsZero() {
+			cv.Field(i).Set(deepCopyValue(pv.Field(i)))
+		}
+	}
+
+	if child.InheritLayers {
+		child.Layers = append(append([]string{}, parent.Layers...), child.Layers...)
+	}
+	return child
+}
+
+// deepCopyValue returns a copy of v that shares no map, slice or pointer
+// with it
+func deepCopyValue(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for it := v.MapRange(); it.Next(); {
+			c.SetMapIndex(it.Key(), deepCopyValue(it.Value()))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopyValue(v.Index(i)))
+		}
+		return c
+	case reflect.Pointer:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type().Elem())
+		c.Elem().Set(deepCopyValue(v.Elem()))
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopyValue(v.Field(i)))
+			}
+		}
+		return c
+	}
+	return v
+}
+
+// interpolate expands ${VAR} and ${VAR:-default} in the defaults and every
+// image before resolution, so intermediates inherit the expanded values.
+func (c *Config) interpolate() error {
+	if err := c.Defaults.interpolate("defaults"); err != nil {
+		return err
+	}
+	var names []string
+	for name := range c.Images {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for _, name := range names {
+		img := c.Images[name]
+		if err := img.interpolate("images." + name); err != nil {
+			return err
+		}
+		c.Images[name] = img
+	}
+	return nil
+}
+
+// interpolate expands environment variables in the registry, tag, base,
+// builder, cache_registry and label values. prefix names the config key in errors.
+func (ic *ImageConfig) interpolate(prefix string) error {
+	fields := []struct {
+		key string
+		val *string
+	}{
+		{"registry", &ic.Registry},
+		{"tag", &ic.Tag},
+		{"base", &ic.Base},
+		{"builder", &ic.Builder},
+		{"cache_registry", &ic.CacheRegistry},
+	}
+	for _, f := range fields {
+		expanded, err := expandEnv(*f.val)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %w", prefix, f.key, err)
+		}
+		*f.val = expanded
+	}
+
+	var keys []string
+	for k := range ic.Labels {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+	for _, k := range keys {
+		expanded, err := expandEnv(ic.Labels[k])
+		if err != nil {
+			return fmt.Errorf("%s.labels.%s: %w", prefix, k, err)
+		}
+		ic.Labels[k] = expanded
+	}
+	return nil
+}
+
+// expandEnv replaces ${VAR} with the variable's value and ${VAR:-default}
+// with the value, or default if VAR is unset or empty. A $ not followed by {
+// is kept as is. ${VAR} with VAR unset is an error.
+func expandEnv(s string) (string, error) {
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		end := strings.Index(s[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		b.WriteString(s[:start])
+		expr := s[start+2 : start+end]
+		name, def, hasDefault := strings.Cut(expr, ":-")
+		if name == "" {
+			return "", fmt.Errorf("empty variable name in %q", s)
+		}
+		val, ok := os.LookupEnv(name)
+		switch {
+		case hasDefault && val == "":
+			val = def
+		case !ok:
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		b.WriteString(val)
+		s = s[start+end+1:]
+	}
+}
+
+// MetadataTag is the CalVer tag to resolve images with when only settings
+// that don't depend on the tag are needed (layers, user, ports, merge...).
+// Tags and refs resolved with it are placeholders and must not be used.
+const MetadataTag = "unused"
+
 // ResolveImage resolves a single image's configuration by applying defaults
 func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, error) {
 	img, ok := c.Images[name]
@@ -120,13 +676,13 @@ func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, er
 		Name: name,
 	}
 
-	// Resolve base: image -> defaults -> "quay.io/fedora/fedora:43"
+	// Resolve base: image -> defaults -> DefaultBase
 	resolved.Base = img.Base
 	if resolved.Base == "" {
 		resolved.Base = c.Defaults.Base
 	}
 	if resolved.Base == "" {
-		resolved.Base = "quay.io/fedora/fedora:43"
+		resolved.Base = DefaultBase
 	}
 
 	// Check if base is internal (another enabled image in images.yml) or external
@@ -142,6 +698,11 @@ func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, er
 		resolved.Bootc = c.Defaults.Bootc
 	}
 
+	// Resolve cleanup: image -> defaults -> false
+	resolved.Cleanup = resolveBoolPtr(img.Cleanup, c.Defaults.Cleanup, false)
+	resolved.GPU = resolveBoolPtr(img.GPU, c.Defaults.GPU, false)
+	resolved.NoIntermediates = img.NoIntermediates
+
 	// Resolve platforms: image -> defaults -> ["linux/amd64", "linux/arm64"]
 	resolved.Platforms = img.Platforms
 	if len(resolved.Platforms) == 0 {
@@ -160,7 +721,8 @@ func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, er
 		resolved.Tag = "auto"
 	}
 	// If tag is "auto", use the computed calver
-	if resolved.Tag == "auto" {
+	autoTag := resolved.Tag == "auto"
+	if autoTag {
 		resolved.Tag = calverTag
 	}
 
@@ -210,12 +772,47 @@ func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, er
 		resolved.Merge = c.Defaults.Merge
 	}
 
+	// Resolve shell defaults: image -> defaults -> nil
+	resolved.Shell = img.Shell
+	if resolved.Shell == nil {
+		resolved.Shell = c.Defaults.Shell
+	}
+
 	// Resolve builder: image -> defaults -> ""
 	resolved.Builder = img.Builder
 	if resolved.Builder == "" {
 		resolved.Builder = c.Defaults.Builder
 	}
 
+	resolved.CacheRegistry = img.CacheRegistry
+	if resolved.CacheRegistry == "" {
+		resolved.CacheRegistry = c.Defaults.CacheRegistry
+	}
+
+	// Resolve task version and checksums: image -> defaults -> pinned default
+	resolved.TaskVersion = img.TaskVersion
+	if resolved.TaskVersion == "" {
+		resolved.TaskVersion = c.Defaults.TaskVersion
+	}
+	if resolved.TaskVersion == "" {
+		resolved.TaskVersion = DefaultTaskVersion
+	}
+	resolved.TaskSHA256 = img.TaskSHA256
+	if resolved.TaskSHA256 == nil {
+		resolved.TaskSHA256 = c.Defaults.TaskSHA256
+	}
+
+	// Resolve labels: defaults merged with image (image keys override)
+	resolved.Labels = mergeLabels(c.Defaults.Labels, img.Labels)
+	resolved.Secrets = mergeSecrets(c.Defaults.Secrets, img.Secrets)
+
+	// Entrypoint and cmd are image-specific, like layers
+	resolved.Entrypoint = img.Entrypoint
+	resolved.Cmd = img.Cmd
+	resolved.Healthcheck = img.Healthcheck
+	resolved.ContainerfilePre = img.ContainerfilePre
+	resolved.ContainerfilePost = img.ContainerfilePost
+
 	// Home directory will be resolved later (after inspecting base image)
 	if resolved.User == "root" {
 		resolved.Home = "/root"
@@ -230,9 +827,50 @@ func (c *Config) ResolveImage(name string, calverTag string) (*ResolvedImage, er
 		resolved.FullTag = fmt.Sprintf("%s:%s", name, resolved.Tag)
 	}
 
+	// Auto tags are also tagged latest: image -> defaults -> true
+	resolved.Tags = []string{resolved.FullTag}
+	if autoTag && resolveBoolPtr(img.Latest, c.Defaults.Latest, true) {
+		if resolved.Registry != "" {
+			resolved.Tags = append(resolved.Tags, fmt.Sprintf("%s/%s:latest", resolved.Registry, name))
+		} else {
+			resolved.Tags = append(resolved.Tags, fmt.Sprintf("%s:latest", name))
+		}
+	}
+
 	return resolved, nil
 }
 
+// mergeLabels returns the union of base and override, with override winning.
+// Returns nil if both are empty.
+func mergeLabels(base, override map[string]string) map[string]string {
+	if len(base) == 0 && len(override) == 0 {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// mergeSecrets returns base secrets overridden by id, followed by new override secrets
+func mergeSecrets(base, override []SecretConfig) []SecretConfig {
+	var merged []SecretConfig
+	overridden := make(map[string]bool)
+	for _, s := range override {
+		overridden[s.ID] = true
+	}
+	for _, s := range base {
+		if !overridden[s.ID] {
+			merged = append(merged, s)
+		}
+	}
+	return append(merged, override...)
+}
+
 // ResolveAllImages resolves all enabled images in the config
 func (c *Config) ResolveAllImages(calverTag string) (map[string]*ResolvedImage, error) {
 	resolved := make(map[string]*ResolvedImage)
@@ -274,6 +912,17 @@ func resolveIntPtr(value, fallback *int, defaultVal int) int {
 	return defaultVal
 }
 
+// resolveBoolPtr resolves a *bool value through fallback chain: value -> fallback -> defaultVal
+func resolveBoolPtr(value, fallback *bool, defaultVal bool) bool {
+	if value != nil {
+		return *value
+	}
+	if fallback != nil {
+		return *fallback
+	}
+	return defaultVal
+}
+
 // intPtr returns a pointer to an int value
 func intPtr(v int) *int {
 	return &v
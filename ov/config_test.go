@@ -1,9 +1,13 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestLoadConfig(t *testing.T) {
@@ -155,6 +159,88 @@ func TestResolveImageNotFound(t *testing.T) {
 	}
 }
 
+func TestResolveImageCleanup(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Cleanup: boolPtr(true)},
+		Images: map[string]ImageConfig{
+			"app":   {},
+			"debug": {Cleanup: boolPtr(false)},
+		},
+	}
+	for name, want := range map[string]bool{"app": true, "debug": false} {
+		img, err := cfg.ResolveImage(name, "2026.46.1415")
+		if err != nil {
+			t.Fatalf("ResolveImage(%s) error = %v", name, err)
+		}
+		if img.Cleanup != want {
+			t.Errorf("%s Cleanup = %v, want %v", name, img.Cleanup, want)
+		}
+	}
+}
+
+func TestResolveImageGPU(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{GPU: boolPtr(true)},
+		Images: map[string]ImageConfig{
+			"app": {},
+			"cpu": {GPU: boolPtr(false)},
+		},
+	}
+	for name, want := range map[string]bool{"app": true, "cpu": false} {
+		img, err := cfg.ResolveImage(name, "2026.46.1415")
+		if err != nil {
+			t.Fatalf("ResolveImage(%s) error = %v", name, err)
+		}
+		if img.GPU != want {
+			t.Errorf("%s GPU = %v, want %v", name, img.GPU, want)
+		}
+	}
+}
+
+func TestResolveImageLatest(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/overthinkos"},
+		Images: map[string]ImageConfig{
+			"auto":     {},
+			"pinned":   {Tag: "v1"},
+			"digested": {Latest: boolPtr(false)},
+		},
+	}
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"auto", []string{"ghcr.io/overthinkos/auto:2026.46.1415", "ghcr.io/overthinkos/auto:latest"}},
+		{"pinned", []string{"ghcr.io/overthinkos/pinned:v1"}},
+		{"digested", []string{"ghcr.io/overthinkos/digested:2026.46.1415"}},
+	}
+	for _, tt := range tests {
+		img, err := cfg.ResolveImage(tt.name, "2026.46.1415")
+		if err != nil {
+			t.Fatalf("ResolveImage(%s) error = %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(img.Tags, tt.want) {
+			t.Errorf("%s tags = %v, want %v", tt.name, img.Tags, tt.want)
+		}
+	}
+
+	// defaults latest: false applies to every image unless overridden
+	cfg.Defaults.Latest = boolPtr(false)
+	cfg.Images["forced"] = ImageConfig{Latest: boolPtr(true)}
+	for name, want := range map[string][]string{
+		"auto":   {"ghcr.io/overthinkos/auto:2026.46.1415"},
+		"forced": {"ghcr.io/overthinkos/forced:2026.46.1415", "ghcr.io/overthinkos/forced:latest"},
+	} {
+		img, err := cfg.ResolveImage(name, "2026.46.1415")
+		if err != nil {
+			t.Fatalf("ResolveImage(%s) error = %v", name, err)
+		}
+		if !reflect.DeepEqual(img.Tags, want) {
+			t.Errorf("%s tags with defaults latest: false = %v, want %v", name, img.Tags, want)
+		}
+	}
+}
+
 func TestImageNames(t *testing.T) {
 	cfg, err := LoadConfig("testdata")
 	if err != nil {
@@ -243,6 +329,40 @@ func TestResolveImageBuilder(t *testing.T) {
 	}
 }
 
+func TestResolveImageTaskVersion(t *testing.T) {
+	sums := map[string]string{"amd64": strings.Repeat("a", 64)}
+	cfg := &Config{
+		Defaults: ImageConfig{TaskSHA256: sums},
+		Images: map[string]ImageConfig{
+			"pinned":   {TaskVersion: "v3.40.0"},
+			"default":  {},
+			"floating": {TaskVersion: "latest", TaskSHA256: map[string]string{}},
+		},
+	}
+
+	tests := []struct {
+		image   string
+		version string
+		sums    int
+	}{
+		{"pinned", "v3.40.0", 1},
+		{"default", DefaultTaskVersion, 1},
+		{"floating", "latest", 0},
+	}
+	for _, tt := range tests {
+		resolved, err := cfg.ResolveImage(tt.image, "test")
+		if err != nil {
+			t.Fatalf("ResolveImage(%q) error = %v", tt.image, err)
+		}
+		if resolved.TaskVersion != tt.version {
+			t.Errorf("%s: TaskVersion = %q, want %q", tt.image, resolved.TaskVersion, tt.version)
+		}
+		if len(resolved.TaskSHA256) != tt.sums {
+			t.Errorf("%s: TaskSHA256 = %v, want %d entries", tt.image, resolved.TaskSHA256, tt.sums)
+		}
+	}
+}
+
 func TestResolveImagePorts(t *testing.T) {
 	cfg := &Config{
 		Defaults: ImageConfig{
@@ -288,6 +408,74 @@ func TestResolveImagePorts(t *testing.T) {
 	}
 }
 
+func TestResolveImageLabels(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{
+			Labels: map[string]string{
+				"org.opencontainers.image.vendor": "overthink",
+				"com.example.team":                "platform",
+			},
+		},
+		Images: map[string]ImageConfig{
+			"override": {Labels: map[string]string{"com.example.team": "infra"}},
+			"inherit":  {},
+		},
+	}
+
+	resolved, err := cfg.ResolveImage("override", "test")
+	if err != nil {
+		t.Fatalf("ResolveImage() error = %v", err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.vendor": "overthink",
+		"com.example.team":                "infra",
+	}
+	if !reflect.DeepEqual(resolved.Labels, want) {
+		t.Errorf("Labels = %v, want %v", resolved.Labels, want)
+	}
+
+	resolved, err = cfg.ResolveImage("inherit", "test")
+	if err != nil {
+		t.Fatalf("ResolveImage() error = %v", err)
+	}
+	if !reflect.DeepEqual(resolved.Labels, cfg.Defaults.Labels) {
+		t.Errorf("Labels = %v, want %v", resolved.Labels, cfg.Defaults.Labels)
+	}
+
+	// Resolving must not mutate the defaults map
+	if cfg.Defaults.Labels["com.example.team"] != "platform" {
+		t.Errorf("defaults mutated: %v", cfg.Defaults.Labels)
+	}
+}
+
+func TestCommandUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want Command
+	}{
+		{"list", `cmd: ["python", "-m", "http.server"]`, Command{"python", "-m", "http.server"}},
+		{"string", `cmd: "exec my-server --port 8080"`, Command{"/bin/sh", "-c", "exec my-server --port 8080"}},
+		{"empty", `cmd: ""`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ic ImageConfig
+			if err := yaml.Unmarshal([]byte(tt.yaml), &ic); err != nil {
+				t.Fatalf("Unmarshal() error = %v", err)
+			}
+			if !reflect.DeepEqual(ic.Cmd, tt.want) {
+				t.Errorf("Cmd = %#v, want %#v", ic.Cmd, tt.want)
+			}
+		})
+	}
+
+	var ic ImageConfig
+	if err := yaml.Unmarshal([]byte("entrypoint: {a: b}"), &ic); err == nil {
+		t.Error("expected error for mapping entrypoint")
+	}
+}
+
 func TestFullTag(t *testing.T) {
 	cfg, err := LoadConfig("testdata")
 	if err != nil {
@@ -351,3 +539,268 @@ func TestEnabledField(t *testing.T) {
 		t.Errorf("ResolveImage() unexpected error for enabled image: %v", err)
 	}
 }
+
+func TestLoadConfigInterpolation(t *testing.T) {
+	t.Setenv("OV_TEST_REGISTRY", "registry.staging.example.com")
+	t.Setenv("OV_TEST_EMPTY", "")
+
+	dir := t.TempDir()
+	yml := `defaults:
+  registry: ${OV_TEST_REGISTRY}/overthink
+  base: ${OV_TEST_UNSET:-quay.io/fedora/fedora:43}
+  labels:
+    org.example.env: "${OV_TEST_EMPTY:-prod}"
+images:
+  fedora:
+    layers: [tool]
+  app:
+    base: fedora
+    tag: v${OV_TEST_UNSET:-1}-${OV_TEST_REGISTRY}
+    layers: [tool]
+    labels:
+      org.example.price: $5
+`
+	if err := os.WriteFile(filepath.Join(dir, "images.yml"), []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	if got, want := cfg.Defaults.Registry, "registry.staging.example.com/overthink"; got != want {
+		t.Errorf("Defaults.Registry = %q, want %q", got, want)
+	}
+	if got, want := cfg.Defaults.Base, "quay.io/fedora/fedora:43"; got != want {
+		t.Errorf("Defaults.Base = %q, want %q", got, want)
+	}
+	if got, want := cfg.Defaults.Labels["org.example.env"], "prod"; got != want {
+		t.Errorf("Defaults.Labels[org.example.env] = %q, want %q (empty uses default)", got, want)
+	}
+	if got, want := cfg.Images["app"].Tag, "v1-registry.staging.example.com"; got != want {
+		t.Errorf("app Tag = %q, want %q", got, want)
+	}
+	if got, want := cfg.Images["app"].Labels["org.example.price"], "$5"; got != want {
+		t.Errorf("app label = %q, want %q", got, want)
+	}
+
+	resolved, err := cfg.ResolveImage("app", "2026.46.1415")
+	if err != nil {
+		t.Fatalf("ResolveImage() error = %v", err)
+	}
+	if got, want := resolved.Registry, "registry.staging.example.com/overthink"; got != want {
+		t.Errorf("resolved Registry = %q, want %q", got, want)
+	}
+}
+
+func TestLoadConfigInterpolationUnset(t *testing.T) {
+	dir := t.TempDir()
+	yml := `images:
+  app:
+    registry: ghcr.io/${OV_TEST_UNSET}
+    layers: [tool]
+`
+	if err := os.WriteFile(filepath.Join(dir, "images.yml"), []byte(yml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := LoadConfig(dir)
+	if err == nil {
+		t.Fatal("LoadConfig() expected error for unset variable")
+	}
+	for _, want := range []string{"images.app.registry", "OV_TEST_UNSET"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q should contain %q", err, want)
+		}
+	}
+}
+
+// writeConfigFiles writes files (relative path -> content) under a temp dir
+func writeConfigFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"images.yml": `include:
+  - images.d/*.yml
+defaults:
+  registry: ghcr.io/test
+images:
+  fedora:
+    layers: [tool]
+`,
+		"images.d/app.yml": `images:
+  app:
+    base: fedora
+    layers: [tool]
+`,
+		"images.d/notes.txt": "not a fragment",
+	})
+
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if len(cfg.Images) != 2 {
+		t.Errorf("len(Images) = %d, want 2", len(cfg.Images))
+	}
+	resolved, err := cfg.ResolveImage("app", "2026.46.1415")
+	if err != nil {
+		t.Fatalf("ResolveImage(app) error = %v", err)
+	}
+	if resolved.IsExternalBase {
+		t.Error("app should build on the internal fedora image")
+	}
+	if got, want := resolved.FullTag, "ghcr.io/test/app:2026.46.1415"; got != want {
+		t.Errorf("FullTag = %q, want %q (root defaults apply to fragments)", got, want)
+	}
+}
+
+func TestLoadConfigIncludeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{
+			name: "duplicate across fragments in lexical order",
+			files: map[string]string{
+				"images.yml":       "include: [images.d/*.yml]\n",
+				"images.d/b.yml":   "images:\n  dup:\n    layers: [tool]\n",
+				"images.d/a.yml":   "images:\n  dup:\n    layers: [tool]\n",
+				"images.d/c/x.yml": "images:\n  other:\n    layers: [tool]\n",
+			},
+			want: `images.d/b.yml: image "dup" is already defined in images.d/a.yml`,
+		},
+		{
+			name: "duplicate of a root image",
+			files: map[string]string{
+				"images.yml":       "include: [images.d/*.yml]\nimages:\n  fedora:\n    layers: [tool]\n",
+				"images.d/app.yml": "images:\n  fedora:\n    layers: [tool]\n",
+			},
+			want: `images.d/app.yml: image "fedora" is already defined in images.yml`,
+		},
+		{
+			name: "defaults in fragment",
+			files: map[string]string{
+				"images.yml":       "include: [images.d/*.yml]\n",
+				"images.d/app.yml": "defaults:\n  registry: ghcr.io/other\n",
+			},
+			want: "images.d/app.yml: defaults may only be set in images.yml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFiles(t, tt.files))
+			if err == nil {
+				t.Fatal("LoadConfig() expected error")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveImageExtends(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/defaults", User: "user", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		Images: map[string]ImageConfig{
+			"common": {
+				Enabled:   boolPtr(false),
+				Registry:  "ghcr.io/common",
+				User:      "dev",
+				Platforms: []string{"linux/amd64"},
+				Builder:   "builder",
+				Layers:    []string{"tool"},
+			},
+			"builder": {Layers: []string{"tool"}},
+			"mid":     {Extends: "common", User: "mid", Layers: []string{"web"}},
+			"app":     {Extends: "mid", Registry: "ghcr.io/app"},
+			"stacked": {Extends: "mid", InheritLayers: true, Layers: []string{"extra"}},
+			"plain":   {Layers: []string{"tool"}},
+		},
+	}
+	cfg.applyExtends()
+
+	tests := []struct {
+		image     string
+		registry  string
+		user      string
+		platforms []string
+		builder   string
+		layers    []string
+	}{
+		// own registry, user from mid, the rest from common; layers are never
+		// copied without inherit_layers
+		{"app", "ghcr.io/app", "mid", []string{"linux/amd64"}, "builder", nil},
+		// own user and layers win over common
+		{"mid", "ghcr.io/common", "mid", []string{"linux/amd64"}, "builder", []string{"web"}},
+		// inherit_layers: parent layers first
+		{"stacked", "ghcr.io/common", "mid", []string{"linux/amd64"}, "builder", []string{"web", "extra"}},
+		// no extends: defaults only
+		{"plain", "ghcr.io/defaults", "user", []string{"linux/amd64", "linux/arm64"}, "", []string{"tool"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			got, err := cfg.ResolveImage(tt.image, "v1")
+			if err != nil {
+				t.Fatalf("ResolveImage() error = %v", err)
+			}
+			if got.Registry != tt.registry {
+				t.Errorf("Registry = %q, want %q", got.Registry, tt.registry)
+			}
+			if got.User != tt.user {
+				t.Errorf("User = %q, want %q", got.User, tt.user)
+			}
+			if !reflect.DeepEqual(got.Platforms, tt.platforms) {
+				t.Errorf("Platforms = %v, want %v", got.Platforms, tt.platforms)
+			}
+			if got.Builder != tt.builder {
+				t.Errorf("Builder = %q, want %q", got.Builder, tt.builder)
+			}
+			if !reflect.DeepEqual(got.Layers, tt.layers) {
+				t.Errorf("Layers = %v, want %v", got.Layers, tt.layers)
+			}
+		})
+	}
+
+	// enabled is not inherited from the disabled common config
+	if app := cfg.Images["app"]; !app.IsEnabled() {
+		t.Error("app should stay enabled when extending a disabled image")
+	}
+}
+
+func TestExtendImageConfigCopies(t *testing.T) {
+	parent := ImageConfig{
+		Labels:    map[string]string{"team": "base"},
+		Platforms: []string{"linux/amd64"},
+		Shell:     &ShellConfig{Mounts: []string{"data"}},
+		Aliases:   []AliasConfig{{Name: "tool", Env: map[string]string{"A": "1"}}},
+	}
+	child := extendImageConfig(ImageConfig{}, parent)
+	if !reflect.DeepEqual(child.Labels, parent.Labels) || !reflect.DeepEqual(child.Shell, parent.Shell) {
+		t.Fatalf("extendImageConfig() = %+v, want the parent's fields", child)
+	}
+
+	// Changing the child leaves the parent alone
+	child.Labels["team"] = "app"
+	child.Platforms[0] = "linux/arm64"
+	child.Shell.Mounts[0] = "cache"
+	child.Aliases[0].Env["A"] = "2"
+	if parent.Labels["team"] != "base" || parent.Platforms[0] != "linux/amd64" ||
+		parent.Shell.Mounts[0] != "data" || parent.Aliases[0].Env["A"] != "1" {
+		t.Errorf("parent changed with its child: %+v", parent)
+	}
+}
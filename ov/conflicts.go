@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// layerPayloadDirs maps the layer directories copied verbatim into the image
+// to their destination
+var layerPayloadDirs = map[string]string{
+	"files":   "/",
+	"systemd": "/usr/lib/systemd/system/",
+}
+
+// ValidateFileConflicts reports image paths that more than one layer of an
+// image installs with different content (the last layer silently wins).
+// It works from the layer directories alone: files/ and systemd/ payloads are
+// compared by content, whiteouts (.wh.*) are ignored. Layers inherited from
+// internal base images count as part of the image.
+func ValidateFileConflicts(cfg *Config, layers map[string]*Layer) error {
+	errs := &ValidationError{}
+
+	images, err := cfg.ResolveAllImages(MetadataTag)
+	if err != nil {
+		return err
+	}
+
+	payloads := make(map[string]map[string]string)
+	for _, imageName := range cfg.ImageNames() {
+		chain, err := imageLayerChain(imageName, images, layers)
+		if err != nil {
+			// Unknown layers and cycles are reported by Validate
+			continue
+		}
+
+		providers := make(map[string][]string) // image path -> layers
+		digests := make(map[string]map[string]bool)
+		for _, layerName := range chain {
+			payload, ok := payloads[layerName]
+			if !ok {
+				payload, err = layerPayload(layers[layerName])
+				if err != nil {
+					return fmt.Errorf("layer %s: %w", layerName, err)
+				}
+				payloads[layerName] = payload
+			}
+			for target, digest := range payload {
+				providers[target] = append(providers[target], layerName)
+				if digests[target] == nil {
+					digests[target] = make(map[string]bool)
+				}
+				digests[target][digest] = true
+			}
+		}
+
+		var targets []string
+		for target := range providers {
+			if len(digests[target]) > 1 {
+				targets = append(targets, target)
+			}
+		}
+		sortStrings(targets)
+		for _, target := range targets {
+			errs.Add("image %q: %s is provided by layers %s", imageName, target, strings.Join(providers[target], ", "))
+		}
+	}
+
+	if errs.HasErrors() {
+		return errs
+	}
+	return nil
+}
+
+// imageLayerChain returns all layers installed in an image, in install order,
+// starting with the layers of its internal base images
+func imageLayerChain(imageName string, images map[string]*ResolvedImage, layers map[string]*Layer) ([]string, error) {
+	img, ok := images[imageName]
+	if !ok {
+		return nil, fmt.Errorf("image %q not found", imageName)
+	}
+
+	var chain []string
+	var parentLayers map[string]bool
+	if !img.IsExternalBase {
+		var err error
+		if chain, err = imageLayerChain(img.Base, images, layers); err != nil {
+			return nil, err
+		}
+		if parentLayers, err = LayersProvidedByImage(img.Base, images, layers); err != nil {
+			return nil, err
+		}
+	}
+
+	order, err := ResolveLayerOrder(img.Layers, layers, parentLayers)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for _, layerName := range chain {
+		seen[layerName] = true
+	}
+	for _, layerName := range order {
+		if !seen[layerName] {
+			chain = append(chain, layerName)
+		}
+	}
+	return chain, nil
+}
+
+// layerPayload maps each image path a layer copies in from files/ or systemd/
+// to a digest of its content (symlinks by their target)
+func layerPayload(layer *Layer) (map[string]string, error) {
+	payload := make(map[string]string)
+	for dir, dest := range layerPayloadDirs {
+		root := filepath.Join(layer.Path, dir)
+		if !dirExists(root) {
+			continue
+		}
+		err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			if layer.ignore.Ignored(path.Join(dir, filepath.ToSlash(rel)), d.IsDir()) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() || strings.HasPrefix(d.Name(), ".wh.") {
+				return nil
+			}
+
+			var content []byte
+			if d.Type()&fs.ModeSymlink != 0 {
+				target, err := os.Readlink(p)
+				if err != nil {
+					return err
+				}
+				content = []byte("symlink:" + target)
+			} else if content, err = os.ReadFile(p); err != nil {
+				return err
+			}
+			sum := sha256.Sum256(content)
+			payload[path.Join(dest, filepath.ToSlash(rel))] = hex.EncodeToString(sum[:])
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
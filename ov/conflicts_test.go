@@ -0,0 +1,54 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateFileConflicts(t *testing.T) {
+	dir := writeNestedLayers(t, map[string]string{
+		"alpha/files/etc/profile.d/env.sh":    "export A=1\n",
+		"alpha/files/etc/alpha.conf":          "alpha\n",
+		"beta/files/etc/profile.d/env.sh":     "export B=1\n",
+		"same/files/etc/alpha.conf":           "alpha\n",
+		"wipe/files/etc/profile.d/.wh.env.sh": "",
+		"units/systemd/app.service":           "[Service]\n",
+		"units2/systemd/app.service":          "[Service]\nType=oneshot\n",
+	})
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"base":  {Base: "quay.io/fedora/fedora:43", Layers: []string{"alpha"}},
+			"child": {Base: "base", Layers: []string{"beta"}},
+			"dup":   {Base: "quay.io/fedora/fedora:43", Layers: []string{"alpha", "same", "wipe"}},
+			"svc":   {Base: "quay.io/fedora/fedora:43", Layers: []string{"units", "units2"}},
+		},
+	}
+
+	err = ValidateFileConflicts(cfg, layers)
+	if err == nil {
+		t.Fatal("ValidateFileConflicts() expected conflicts")
+	}
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error = %T %v, want *ValidationError", err, err)
+	}
+	want := []string{
+		`image "child": /etc/profile.d/env.sh is provided by layers alpha, beta`,
+		`image "svc": /usr/lib/systemd/system/app.service is provided by layers units, units2`,
+	}
+	if strings.Join(verr.Errors, "\n") != strings.Join(want, "\n") {
+		t.Errorf("conflicts =\n%s\nwant\n%s", strings.Join(verr.Errors, "\n"), strings.Join(want, "\n"))
+	}
+
+	// Without differing duplicates there is nothing to report
+	delete(cfg.Images, "child")
+	delete(cfg.Images, "svc")
+	if err := ValidateFileConflicts(cfg, layers); err != nil {
+		t.Errorf("ValidateFileConflicts() error = %v, want identical duplicates and whiteouts ignored", err)
+	}
+}
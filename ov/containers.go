@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// OvContainer is a container ov created and labeled with its image and
+// project: a persistent shell (ov shell --persist) or a service (ov up)
+type OvContainer struct {
+	Name    string
+	Image   string // image name (LabelShell or LabelService)
+	Project string
+	Status  string    // engine state, e.g. "exited" or "running"
+	Started time.Time // last start, zero if unknown
+}
+
+// projectScope returns <dir>-<hash> for the project directory project: its
+// base name made safe for container names and the first 8 hex digits of the
+// sha256 of the path, telling apart projects in directories of the same name
+func projectScope(project string) string {
+	sum := sha256.Sum256([]byte(project))
+	base := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '.' || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(filepath.Base(project)))
+	return strings.Trim(base, "-.") + "-" + hex.EncodeToString(sum[:])[:8]
+}
+
+// ContainerStatus returns the state of the container name ("running",
+// "exited", ...), or "" if there is none. Package-level var for testability.
+var ContainerStatus = func(engine, name string) string {
+	out, err := engineCmd(engine, "container", "inspect", "--format", "{{.State.Status}}", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// ContainerID returns the id of the container name, or "" if there is none.
+// Package-level var for testability.
+var ContainerID = func(engine, name string) string {
+	out, err := engineCmd(engine, "container", "inspect", "--format", "{{.Id}}", name).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// RemoveContainer removes the container name, stopping it if it runs.
+// Package-level var for testability.
+var RemoveContainer = func(engine, name string) error {
+	out, err := engineCmd(engine, "rm", "-f", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s rm %s: %w: %s", EngineBinary(engine), name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// ListOvContainers returns the containers of the engine that have the label
+// kind (LabelShell or LabelService), with their image in that label and their
+// project in projectLabel. filters are extra engine ps filters. Package-level
+// var for testability.
+var ListOvContainers = defaultListOvContainers
+
+func defaultListOvContainers(engine, kind, projectLabel string, filters ...string) ([]OvContainer, error) {
+	args := []string{"ps", "-a", "--filter", "label=" + kind}
+	for _, f := range filters {
+		args = append(args, "--filter", f)
+	}
+	out, err := engineCmd(engine, append(args, "--format", "{{.Names}}")...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s ps: %w", EngineBinary(engine), err)
+	}
+	names := strings.Fields(string(out))
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	format := fmt.Sprintf("{{.Name}}\t{{index .Config.Labels %q}}\t{{index .Config.Labels %q}}\t{{.State.Status}}\t{{.State.StartedAt}}", kind, projectLabel)
+	args = append([]string{"container", "inspect", "--format", format}, names...)
+	out, err = engineCmd(engine, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s container inspect: %w", EngineBinary(engine), err)
+	}
+	return parseOvContainers(string(out))
+}
+
+// parseOvContainers parses the container inspect lines of
+// defaultListOvContainers
+func parseOvContainers(out string) ([]OvContainer, error) {
+	var containers []OvContainer
+	for _, line := range strings.Split(strings.TrimRight(out, "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("container inspect: unexpected output %q", line)
+		}
+		containers = append(containers, OvContainer{
+			Name:    strings.TrimPrefix(fields[0], "/"), // docker prefixes names with /
+			Image:   fields[1],
+			Project: fields[2],
+			Status:  fields[3],
+			Started: parseEngineTime(fields[4]),
+		})
+	}
+	return containers, nil
+}
+
+// printOvContainers prints containers as an aligned table, most recently
+// started first. started is the header of the start time column.
+func printOvContainers(w io.Writer, containers []OvContainer, started string) {
+	sorted := slices.Clone(containers)
+	slices.SortStableFunc(sorted, func(a, b OvContainer) int { return b.Started.Compare(a.Started) })
+
+	rows := [][]string{{"NAME", "IMAGE", "PROJECT", started, "STATUS"}}
+	for _, c := range sorted {
+		when := "-"
+		if !c.Started.IsZero() {
+			when = c.Started.Local().Format("2006-01-02 15:04")
+		}
+		rows = append(rows, []string{c.Name, c.Image, c.Project, when, c.Status})
+	}
+	printTable(w, rows)
+}
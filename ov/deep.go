@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// ValidateConfig holds the images.yml settings of ov validate
+type ValidateConfig struct {
+	Deep bool `yaml:"deep,omitempty"` // always parse the layer files (ov validate --deep)
+}
+
+// ValidateLayerFiles parses the install files of every layer, which the build
+// would only parse inside a container (ov validate --deep): root.yml and
+// user.yml as Taskfiles with an install task, pixi.toml, pyproject.toml and
+// Cargo.toml as TOML, package.json as JSON and the supervisord fragment of
+// layer.yml as INI. Every failure is reported as path:line[:col].
+func ValidateLayerFiles(layers map[string]*Layer) error {
+	errs := &ValidationError{}
+	for _, name := range LayerNames(layers) {
+		layer := layers[name]
+		rel := func(file string) string { return filepath.Join("layers", name, file) }
+		check := func(has bool, file string, parse func(data []byte) error) {
+			if !has {
+				return
+			}
+			data, err := os.ReadFile(filepath.Join(layer.Path, file))
+			if err != nil {
+				errs.Add("%s: %v", rel(file), err)
+				return
+			}
+			if err := parse(data); err != nil {
+				errs.Add("%s", positionMessage(rel(file), err))
+			}
+		}
+
+		check(layer.HasRootYml, "root.yml", checkTaskfile)
+		check(layer.HasUserYml, "user.yml", checkTaskfile)
+		check(layer.HasPixiToml, "pixi.toml", checkTOML)
+		check(layer.HasPyprojectToml, "pyproject.toml", checkTOML)
+		check(layer.HasCargoToml, "Cargo.toml", checkTOML)
+		check(layer.HasPackageJson, "package.json", checkJSON)
+		if layer.HasSupervisord {
+			if err := checkINI(layer.ServiceConf()); err != nil {
+				errs.Add("%s", positionMessage(rel("layer.yml")+" service", err))
+			}
+		}
+	}
+	if errs.HasErrors() {
+		return errs
+	}
+	return nil
+}
+
+// positionError is a parse error at a line and column (0: unknown) of a file
+type positionError struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *positionError) Error() string {
+	return e.Msg
+}
+
+// positionMessage formats err in file as "file:line:col: message", leaving
+// out what is unknown
+func positionMessage(file string, err error) string {
+	var pe *positionError
+	if !errors.As(err, &pe) {
+		return fmt.Sprintf("%s: %v", file, err)
+	}
+	switch {
+	case pe.Line == 0:
+		return fmt.Sprintf("%s: %s", file, pe.Msg)
+	case pe.Col == 0:
+		return fmt.Sprintf("%s:%d: %s", file, pe.Line, pe.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", file, pe.Line, pe.Col, pe.Msg)
+}
+
+// yamlLineRe matches the line of a yaml.v3 error: "yaml: line 3: ..."
+var yamlLineRe = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// checkTaskfile parses a Taskfile and checks that it has an install task,
+// which the build runs (task -t root.yml install)
+func checkTaskfile(data []byte) error {
+	var doc struct {
+		Tasks map[string]yaml.Node `yaml:"tasks"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		msg := err.Error()
+		if m := yamlLineRe.FindStringSubmatch(msg); m != nil {
+			line, _ := strconv.Atoi(m[1])
+			return &positionError{Line: line, Msg: strings.TrimPrefix(msg, m[0])}
+		}
+		return &positionError{Msg: strings.TrimPrefix(msg, "yaml: ")}
+	}
+	if _, ok := doc.Tasks["install"]; !ok {
+		return &positionError{Msg: "no install task (tasks: install:)"}
+	}
+	return nil
+}
+
+// checkTOML parses a TOML file
+func checkTOML(data []byte) error {
+	var v map[string]any
+	if _, err := toml.Decode(string(data), &v); err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return &positionError{Line: pe.Position.Line, Col: pe.Position.Col, Msg: pe.Message}
+		}
+		return err
+	}
+	return nil
+}
+
+// checkJSON parses a JSON file
+func checkJSON(data []byte) error {
+	var v any
+	err := json.Unmarshal(data, &v)
+	var se *json.SyntaxError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &se):
+		line, col := offsetPosition(data, se.Offset)
+		return &positionError{Line: line, Col: col, Msg: se.Error()}
+	default:
+		return err
+	}
+}
+
+// offsetPosition returns the line and column (from 1) of the byte offset in
+// data, as reported by json.SyntaxError (the offset after the bad byte)
+func offsetPosition(data []byte, offset int64) (int, int) {
+	n := int(offset) - 1
+	if n < 0 {
+		n = 0
+	}
+	if n > len(data) {
+		n = len(data)
+	}
+	before := data[:n]
+	return bytes.Count(before, []byte("\n")) + 1, n - bytes.LastIndexByte(before, '\n')
+}
+
+// checkINI parses a supervisord fragment: sections, "key = value" (or
+// "key: value") entries with indented continuation lines, and ; or # comments
+func checkINI(conf string) error {
+	section := false
+	for i, line := range strings.Split(conf, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "", strings.HasPrefix(trimmed, ";"), strings.HasPrefix(trimmed, "#"):
+		case strings.HasPrefix(trimmed, "["):
+			if !strings.HasSuffix(trimmed, "]") || len(trimmed) == 2 {
+				return &positionError{Line: i + 1, Msg: fmt.Sprintf("malformed section header %q", trimmed)}
+			}
+			section = true
+		case section && (line[0] == ' ' || line[0] == '\t'):
+			// continuation of the previous value
+		case !section:
+			return &positionError{Line: i + 1, Msg: fmt.Sprintf("%q is outside of a [section]", trimmed)}
+		case strings.IndexAny(trimmed, "=:") <= 0:
+			return &positionError{Line: i + 1, Msg: fmt.Sprintf("%q is not a key = value line", trimmed)}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateLayerFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"good/root.yml":     "version: '3'\ntasks:\n  install:\n    cmds:\n      - dnf install -y jq\n",
+		"good/pixi.toml":    "[project]\nname = \"good\"\nchannels = [\"conda-forge\"]\n",
+		"good/package.json": "{\n  \"dependencies\": {\"left-pad\": \"1.3.0\"}\n}\n",
+		"good/Cargo.toml":   "[package]\nname = \"good\"\nversion = \"0.1.0\"\n",
+		"good/layer.yml":    "service: |\n  [program:good]\n  command=/usr/bin/good\n    --verbose\n  ; comment\n  autostart = true\n",
+
+		"bad/root.yml":       "version: '3'\ntasks:\n  setup:\n    cmds: [true]\n",
+		"bad/user.yml":       "tasks:\n  install:\n\tcmds: []\n",
+		"bad/pixi.toml":      "[project]\nname = \"bad\"\nchannels = [\"conda-forge\"\n",
+		"bad/package.json":   "{\n  \"dependencies\": {\n    \"left-pad\": \"1.3.0\",\n  }\n}\n",
+		"bad/pyproject.toml": "[project\n",
+		"bad/layer.yml":      "service: |\n  command=/usr/bin/bad\n",
+		"bad/src/main.rs":    "fn main() {}\n",
+		"bad/Cargo.toml":     "[package]\nname = bad\n",
+		"ini/layer.yml":      "service: |\n  [program:x]\n  command=/x\n  not a setting\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layers := map[string]*Layer{}
+	for _, name := range []string{"good", "bad", "ini"} {
+		layer, err := scanLayer(filepath.Join(dir, name), name)
+		if err != nil {
+			t.Fatalf("scanLayer(%s) error = %v", name, err)
+		}
+		layers[name] = layer
+	}
+
+	if err := ValidateLayerFiles(map[string]*Layer{"good": layers["good"]}); err != nil {
+		t.Errorf("ValidateLayerFiles(good) error = %v", err)
+	}
+
+	err := ValidateLayerFiles(layers)
+	if err == nil {
+		t.Fatal("expected errors")
+	}
+	for _, want := range []string{
+		"layers/bad/root.yml: no install task",
+		"layers/bad/user.yml:3: ",
+		"layers/bad/pixi.toml:3:",
+		"layers/bad/package.json:4:3: invalid character '}'",
+		"layers/bad/pyproject.toml:2:",
+		"layers/bad/Cargo.toml:2:",
+		`layers/bad/layer.yml service:1: "command=/usr/bin/bad" is outside of a [section]`,
+		`layers/ini/layer.yml service:3: "not a setting" is not a key = value line`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	if n := len(err.(*ValidationError).Errors); n != 8 {
+		t.Errorf("got %d errors, want all 8:\n%v", n, err)
+	}
+}
+
+func TestOffsetPosition(t *testing.T) {
+	data := []byte("{\n  \"a\": 1,\n}")
+	tests := []struct {
+		offset          int64
+		wantLine, wantC int
+	}{
+		{1, 1, 1},
+		{4, 2, 2},
+		{13, 3, 1},
+		{99, 3, 2},
+		{0, 1, 1},
+	}
+	for _, tt := range tests {
+		if line, col := offsetPosition(data, tt.offset); line != tt.wantLine || col != tt.wantC {
+			t.Errorf("offsetPosition(%d) = %d:%d, want %d:%d", tt.offset, line, col, tt.wantLine, tt.wantC)
+		}
+	}
+}
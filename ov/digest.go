@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// inputsDigestPlaceholder stands in for the digest in the generated
+// Containerfile until inputsDigest has hashed the rest of it
+const inputsDigestPlaceholder = "<inputs-digest>"
+
+// inputsDigest hashes everything an image build consumes: the Containerfile
+// (with the tag and digest label normalized so regenerations are stable), the
+// content hashes of the image's layers (Layer.Hash), the files of templates/,
+// and the digests of its internal base and builder. The target platform is
+// left out, so that the Containerfile (which carries the digest as a label) is
+// the same for every platform; BuildState keys builds by platform instead.
+func (g *Generator) inputsDigest(imageName string, content string, layerOrder []string) (string, error) {
+	h := sha256.New()
+
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if strings.HasPrefix(line, "LABEL "+LabelInputsDigest+"=") {
+			continue
+		}
+		if g.Tag != "" {
+			// The tag appears in image references (:tag) and the version label ("tag")
+			line = strings.ReplaceAll(line, ":"+g.Tag, ":<tag>")
+			line = strings.ReplaceAll(line, `"`+g.Tag+`"`, `"<tag>"`)
+		}
+		io.WriteString(h, line)
+	}
+
+	for _, layerName := range layerOrder {
+		hash, err := g.Layers[layerName].Hash()
+		if err != nil {
+			return "", fmt.Errorf("hashing layer %s: %w", layerName, err)
+		}
+		fmt.Fprintf(h, "layer %s %s\n", layerName, hash)
+	}
+	if g.Dir != "" {
+		if err := hashTree(h, g.Dir, "templates"); err != nil {
+			return "", err
+		}
+	}
+
+	img := g.Images[imageName]
+	if !img.IsExternalBase {
+		fmt.Fprintf(h, "base %s\n", g.InputDigests[img.Base])
+	}
+	if builder := img.Builder; builder != "" && builder != imageName && g.builderRefForImage(imageName) != "" {
+		fmt.Fprintf(h, "builder %s\n", g.InputDigests[builder])
+	}
+
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashTree writes the relative path, executable bit and content of every
+// regular file under root/dir to h in lexical order. A missing dir hashes as empty.
+func hashTree(h io.Writer, root, dir string) error {
+	err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s %t %d\n", filepath.ToSlash(rel), info.Mode()&0111 != 0, len(data))
+		h.Write(data)
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Hash returns a stable content hash of the layer directory, "sha256:<hex>":
+// relative path, executable bit and content of every file (symlinks by their
+// target) in sorted path order, skipping editor backup files and .ovignore
+// matches. The result is cached; a missing directory hashes as empty.
+func (l *Layer) Hash() (string, error) {
+	if l.hash != "" {
+		return l.hash, nil
+	}
+
+	type entry struct {
+		rel    string
+		header string
+		data   []byte
+	}
+	var entries []entry
+	err := filepath.WalkDir(l.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(l.Path, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel != "." && l.ignore.Ignored(rel, true) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isEditorBackup(d.Name()) || l.ignore.Ignored(rel, false) {
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry{rel, fmt.Sprintf("%s symlink %d\n", rel, len(target)), []byte(target)})
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		entries = append(entries, entry{rel, fmt.Sprintf("%s %t %d\n", rel, info.Mode()&0111 != 0, len(data)), data})
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].rel < entries[j].rel })
+	h := sha256.New()
+	for _, e := range entries {
+		io.WriteString(h, e.header)
+		h.Write(e.data)
+	}
+	l.hash = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	return l.hash, nil
+}
+
+// isEditorBackup reports whether name is an editor backup or swap file
+// (foo~, .foo.swp, #foo#), which must not change a layer's hash
+func isEditorBackup(name string) bool {
+	return strings.HasSuffix(name, "~") ||
+		strings.HasSuffix(name, ".swp") || strings.HasSuffix(name, ".swo") ||
+		(len(name) > 1 && strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#"))
+}
+
+// BuildState records, per image and platform, the inputs digest and tag of
+// its last successful ov build (.build/state.json).
+type BuildState struct {
+	Images map[string]BuildStateEntry `json:"images"` // keyed by buildStateKey
+}
+
+// buildStateKey returns the BuildState key of an image built for platform
+// ("" for a build of each of the image's platforms)
+func buildStateKey(name, platform string) string {
+	if platform == "" {
+		return name
+	}
+	return name + "@" + platform
+}
+
+// BuildStateEntry is the last successful build of one image
+type BuildStateEntry struct {
+	Digest string `json:"digest"`
+	Tag    string `json:"tag"`
+}
+
+// buildStatePath returns the state file location inside the build directory
+func buildStatePath(buildDir string) string {
+	return filepath.Join(buildDir, "state.json")
+}
+
+// LoadBuildState reads .build/state.json; a missing file is an empty state
+func LoadBuildState(buildDir string) (*BuildState, error) {
+	state := &BuildState{Images: make(map[string]BuildStateEntry)}
+	data, err := os.ReadFile(buildStatePath(buildDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", buildStatePath(buildDir), err)
+	}
+	if state.Images == nil {
+		state.Images = make(map[string]BuildStateEntry)
+	}
+	return state, nil
+}
+
+// Save writes the state to .build/state.json
+func (s *BuildState) Save(buildDir string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(buildStatePath(buildDir), append(data, '\n'), 0644)
+}
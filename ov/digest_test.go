@@ -0,0 +1,241 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newDigestGenerator returns a generator for a two-image chain rooted in dir
+func newDigestGenerator(t *testing.T, dir, tag string, created time.Time) *Generator {
+	t.Helper()
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/overthinkos", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool"}},
+			"app":    {Base: "fedora", Layers: []string{"web"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages(tag)
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	return &Generator{
+		Dir:    dir,
+		Config: cfg,
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", Path: filepath.Join(dir, "layers", "tool"), HasRootYml: true},
+			"web":  {Name: "web", Path: filepath.Join(dir, "layers", "web"), HasRootYml: true},
+		},
+		Tag:            tag,
+		Images:         images,
+		BuildDir:       filepath.Join(dir, ".build"),
+		Containerfiles: make(map[string]string),
+		Created:        created,
+	}
+}
+
+func generateDigests(t *testing.T, g *Generator) map[string]string {
+	t.Helper()
+	for _, name := range []string{"fedora", "app"} {
+		if err := g.generateContainerfile(name); err != nil {
+			t.Fatalf("generateContainerfile(%s) error = %v", name, err)
+		}
+	}
+	return g.InputDigests
+}
+
+func writeLayerFile(t *testing.T, dir, layer, content string) {
+	t.Helper()
+	path := filepath.Join(dir, "layers", layer, "root.yml")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInputsDigestStable(t *testing.T) {
+	dir := t.TempDir()
+	writeLayerFile(t, dir, "tool", "version: '3'\n")
+	writeLayerFile(t, dir, "web", "version: '3'\n")
+
+	first := generateDigests(t, newDigestGenerator(t, dir, "2026.46.1415", time.Date(2026, 2, 15, 14, 15, 0, 0, time.UTC)))
+	g := newDigestGenerator(t, dir, "2026.47.0900-g0123456", time.Date(2026, 2, 16, 9, 0, 0, 0, time.UTC))
+	g.Revision = "0123456789abcdef0123456789abcdef01234567"
+	second := generateDigests(t, g)
+
+	for _, name := range []string{"fedora", "app"} {
+		if !strings.HasPrefix(first[name], "sha256:") {
+			t.Errorf("%s digest = %q, want sha256:...", name, first[name])
+		}
+		if first[name] != second[name] {
+			t.Errorf("%s digest changed across no-op regeneration: %s != %s", name, first[name], second[name])
+		}
+	}
+	if want := `LABEL org.overthink.inputs-digest="` + second["app"] + `"`; !strings.Contains(g.Containerfiles["app"], want) {
+		t.Errorf("missing %q in:\n%s", want, g.Containerfiles["app"])
+	}
+}
+
+func TestInputsDigestChanges(t *testing.T) {
+	dir := t.TempDir()
+	writeLayerFile(t, dir, "tool", "version: '3'\n")
+	writeLayerFile(t, dir, "web", "version: '3'\n")
+	before := generateDigests(t, newDigestGenerator(t, dir, "2026.46.1415", time.Time{}))
+
+	// A change in the base's layer propagates to the child through the base digest
+	writeLayerFile(t, dir, "tool", "version: '3'\ntasks: {}\n")
+	after := generateDigests(t, newDigestGenerator(t, dir, "2026.46.1415", time.Time{}))
+
+	for _, name := range []string{"fedora", "app"} {
+		if before[name] == after[name] {
+			t.Errorf("%s digest did not change after editing layers/tool", name)
+		}
+	}
+
+	// The target platform doesn't, so every platform generates the same
+	// Containerfile (builds are told apart by their BuildState key)
+	g := newDigestGenerator(t, dir, "2026.46.1415", time.Time{})
+	g.Platform = "linux/arm64"
+	arm := generateDigests(t, g)
+	for _, name := range []string{"fedora", "app"} {
+		if arm[name] != after[name] {
+			t.Errorf("%s digest changed with --platform linux/arm64", name)
+		}
+	}
+}
+
+func TestUnchangedImage(t *testing.T) {
+	orig := InspectLabels
+	defer func() { InspectLabels = orig }()
+	InspectLabels = func(engine, imageRef string) (map[string]string, error) {
+		if imageRef == "ghcr.io/overthinkos/app:2026.46.1415" {
+			return map[string]string{LabelInputsDigest: "sha256:aaa"}, nil
+		}
+		return nil, os.ErrNotExist
+	}
+
+	prev := BuildStateEntry{Digest: "sha256:aaa", Tag: "ghcr.io/overthinkos/app:2026.46.1415"}
+	if !unchangedImage("docker", prev, "sha256:aaa") {
+		t.Error("expected matching state and label to be reused")
+	}
+	if unchangedImage("docker", prev, "sha256:bbb") {
+		t.Error("expected a new digest to rebuild")
+	}
+	if unchangedImage("docker", BuildStateEntry{Digest: "sha256:aaa", Tag: "ghcr.io/overthinkos/app:gone"}, "sha256:aaa") {
+		t.Error("expected a missing local image to rebuild")
+	}
+}
+
+func TestBuildStateRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	state, err := LoadBuildState(dir)
+	if err != nil {
+		t.Fatalf("LoadBuildState() on empty dir error = %v", err)
+	}
+	state.Images["app"] = BuildStateEntry{Digest: "sha256:aaa", Tag: "app:1"}
+	if err := state.Save(dir); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	loaded, err := LoadBuildState(dir)
+	if err != nil {
+		t.Fatalf("LoadBuildState() error = %v", err)
+	}
+	if loaded.Images["app"] != state.Images["app"] {
+		t.Errorf("loaded %+v, want %+v", loaded.Images["app"], state.Images["app"])
+	}
+}
+
+func TestLayerHash(t *testing.T) {
+	writeFiles := func(dir string, names []string) {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte("content of "+filepath.Base(name)+"\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	hash := func(dir string) string {
+		t.Helper()
+		h, err := (&Layer{Name: "l", Path: dir}).Hash()
+		if err != nil {
+			t.Fatalf("Hash() error = %v", err)
+		}
+		return h
+	}
+
+	// The same tree created in a different order hashes the same
+	a, b := t.TempDir(), t.TempDir()
+	writeFiles(a, []string{"root.yml", "files/etc/a.conf", "files/etc/b.conf", "layer.yml"})
+	writeFiles(b, []string{"layer.yml", "files/etc/b.conf", "root.yml", "files/etc/a.conf"})
+	if hash(a) != hash(b) {
+		t.Errorf("hash depends on creation order: %s != %s", hash(a), hash(b))
+	}
+	if !strings.HasPrefix(hash(a), "sha256:") {
+		t.Errorf("Hash() = %q, want sha256:...", hash(a))
+	}
+
+	// Editor backups don't count
+	writeFiles(b, []string{"root.yml~", ".root.yml.swp", "files/#a.conf#"})
+	if hash(a) != hash(b) {
+		t.Error("editor backup files changed the hash")
+	}
+
+	// Any changed byte, mode or name does
+	before := hash(a)
+	if err := os.WriteFile(filepath.Join(a, "files/etc/a.conf"), []byte("content of a.conF\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed := hash(a)
+	if changed == before {
+		t.Error("hash did not change after editing a file")
+	}
+	if err := os.Chmod(filepath.Join(a, "root.yml"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if hash(a) == changed {
+		t.Error("hash did not change after making a file executable")
+	}
+
+	// The result is cached on the layer
+	layer := &Layer{Name: "l", Path: b}
+	first, _ := layer.Hash()
+	writeFiles(b, []string{"user.yml"})
+	if again, _ := layer.Hash(); again != first {
+		t.Error("Hash() should be cached after the first call")
+	}
+}
+
+func TestGenerateLayerHashLabels(t *testing.T) {
+	dir := t.TempDir()
+	writeLayerFile(t, dir, "tool", "version: '3'\n")
+	writeLayerFile(t, dir, "web", "version: '3'\n")
+	g := newDigestGenerator(t, dir, "2026.46.1415", time.Time{})
+	generateDigests(t, g)
+
+	hash, err := g.Layers["web"].Hash()
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+	for _, want := range []string{
+		`LABEL org.overthink.layer.web="` + hash + `"`,
+		"# Layer: web (" + hash + ")",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+	// Layers of the base image are labeled there (and inherited)
+	if strings.Contains(content, "org.overthink.layer.tool") {
+		t.Errorf("app should only label its own layers:\n%s", content)
+	}
+}
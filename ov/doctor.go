@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// DoctorCmd reports the endpoint each engine resolves to and whether it answers
+type DoctorCmd struct{}
+
+func (c *DoctorCmd) Run() error {
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+	vals, err := ListConfigValues()
+	if err != nil {
+		return err
+	}
+	if problems := doctorReport(os.Stdout, rt, vals); problems > 0 {
+		return fmt.Errorf("%d problem(s) found", problems)
+	}
+	return nil
+}
+
+// EngineServerVersion returns the version of the engine's server at its
+// endpoint, failing when the endpoint doesn't answer.
+// Package-level var for testability.
+var EngineServerVersion = defaultEngineServerVersion
+
+func defaultEngineServerVersion(engine string) (string, error) {
+	format := "{{.ServerVersion}}"
+	if engine == "podman" {
+		format = "{{.Version.Version}}"
+	}
+	out, err := engineCmd(engine, "info", "--format", format).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s info: %w: %s", EngineBinary(engine), err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// doctorReport writes the endpoint, binary and server of docker and podman,
+// and the method transferring images from the build to the run engine, and
+// returns the number of problems with the engines rt uses. vals gives the
+// source of the endpoint settings (see ListConfigValues).
+func doctorReport(out io.Writer, rt *ResolvedRuntime, vals []configKeySource) int {
+	sources := make(map[string]string, len(vals))
+	for _, v := range vals {
+		sources[v.Key] = v.Source
+	}
+
+	problems := 0
+	for _, engine := range []string{"docker", "podman"} {
+		var roles []string
+		if rt.BuildEngine == engine {
+			roles = append(roles, "build")
+		}
+		if rt.RunEngine == engine {
+			roles = append(roles, "run")
+		}
+		used := len(roles) > 0
+		if used {
+			fmt.Fprintf(out, "%s (%s)\n", engine, strings.Join(roles, ", "))
+		} else {
+			fmt.Fprintln(out, engine)
+		}
+
+		endpoint := rt.DockerHost
+		key := "engine.docker_host"
+		if engine == "podman" {
+			endpoint, key = rt.PodmanHost, "engine.podman_host"
+		}
+		switch {
+		case endpoint == "":
+			fmt.Fprintf(out, "  endpoint: local socket (%s)\n", sources[key])
+		case isRemoteEndpoint(endpoint):
+			fmt.Fprintf(out, "  endpoint: %s, remote (%s)\n", endpoint, sources[key])
+		default:
+			fmt.Fprintf(out, "  endpoint: %s (%s)\n", endpoint, sources[key])
+		}
+
+		path, err := exec_LookPath(EngineBinary(engine))
+		if err != nil {
+			fmt.Fprintln(out, "  binary:   not installed")
+			if used {
+				problems++
+			}
+			continue
+		}
+		fmt.Fprintf(out, "  binary:   %s\n", path)
+
+		version, err := EngineServerVersion(engine)
+		if err != nil {
+			fmt.Fprintf(out, "  server:   unreachable (%v)\n", err)
+			if used {
+				problems++
+			}
+			continue
+		}
+		fmt.Fprintf(out, "  server:   %s\n", version)
+	}
+
+	if rt.BuildEngine != rt.RunEngine {
+		method, err := transferMethod(rt.Transfer, rt.BuildEngine, rt.RunEngine)
+		if err != nil {
+			fmt.Fprintf(out, "transfer %s -> %s: %v\n", rt.BuildEngine, rt.RunEngine, err)
+			problems++
+		} else {
+			fmt.Fprintf(out, "transfer %s -> %s: %s\n", rt.BuildEngine, rt.RunEngine, method)
+		}
+	}
+	return problems
+}
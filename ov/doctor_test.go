@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestDoctorReport(t *testing.T) {
+	origVersion, origLook, origEndpoints := EngineServerVersion, exec_LookPath, EngineEndpoints
+	defer func() {
+		EngineServerVersion, exec_LookPath, EngineEndpoints = origVersion, origLook, origEndpoints
+	}()
+	exec_LookPath = func(name string) (string, error) {
+		if name == "skopeo" {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	}
+	EngineServerVersion = func(engine string) (string, error) {
+		if engine == "podman" {
+			return "", errors.New("connection refused")
+		}
+		return "27.1.1", nil
+	}
+	EngineEndpoints = map[string]string{"docker": "ssh://builder"}
+	vals := []configKeySource{
+		{Key: "engine.docker_host", Value: "ssh://builder", Source: "env (DOCKER_HOST)"},
+		{Key: "engine.podman_host", Source: "default"},
+	}
+
+	t.Run("remote build, local run", func(t *testing.T) {
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", Transfer: TransferAuto, DockerHost: "ssh://builder"}
+		var out bytes.Buffer
+		problems := doctorReport(&out, rt, vals)
+		if problems != 1 {
+			t.Errorf("problems = %d, want 1 (podman unreachable)", problems)
+		}
+		for _, want := range []string{
+			"docker (build)",
+			"endpoint: ssh://builder, remote (env (DOCKER_HOST))",
+			"server:   27.1.1",
+			"podman (run)",
+			"endpoint: local socket (default)",
+			"unreachable (connection refused)",
+			"transfer docker -> podman: pipe",
+		} {
+			if !strings.Contains(out.String(), want) {
+				t.Errorf("report doesn't contain %q:\n%s", want, out.String())
+			}
+		}
+	})
+
+	t.Run("unused engine", func(t *testing.T) {
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "docker", Transfer: TransferAuto}
+		var out bytes.Buffer
+		if problems := doctorReport(&out, rt, vals); problems != 0 {
+			t.Errorf("problems = %d, want 0 for an unused unreachable podman:\n%s", problems, out.String())
+		}
+		if strings.Contains(out.String(), "transfer") {
+			t.Errorf("report has a transfer line with a single engine:\n%s", out.String())
+		}
+	})
+}
@@ -1,5 +1,12 @@
 package main
 
+import (
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
 // EngineBinary returns the binary name for the given engine.
 func EngineBinary(engine string) string {
 	switch engine {
@@ -10,6 +17,40 @@ func EngineBinary(engine string) string {
 	}
 }
 
+// EngineEndpoints maps an engine to the endpoint every invocation of it
+// targets ("" for the engine's default local socket). Set by ResolveRuntime
+// from engine.docker_host and engine.podman_host.
+var EngineEndpoints = map[string]string{}
+
+// EngineArgs returns the global flags pointing engine at its endpoint:
+// docker --host, podman --url for a URL or --connection for a connection name.
+func EngineArgs(engine string) []string {
+	endpoint := EngineEndpoints[EngineBinary(engine)]
+	switch {
+	case endpoint == "":
+		return nil
+	case EngineBinary(engine) == "docker":
+		return []string{"--host", endpoint}
+	case strings.Contains(endpoint, "://"):
+		return []string{"--url", endpoint}
+	default:
+		return []string{"--connection", endpoint}
+	}
+}
+
+// engineCmd returns the command running engine (an engine or its binary)
+// with args against its endpoint
+func engineCmd(engine string, args ...string) *exec.Cmd {
+	return exec.Command(EngineBinary(engine), append(EngineArgs(engine), args...)...)
+}
+
+// isRemoteEndpoint reports whether an engine endpoint may be on another
+// machine: anything but the default or a unix socket, podman connection
+// names included
+func isRemoteEndpoint(endpoint string) bool {
+	return endpoint != "" && !strings.HasPrefix(endpoint, "unix://")
+}
+
 // GPURunArgs returns the engine-specific CLI arguments for GPU passthrough.
 func GPURunArgs(engine string) []string {
 	switch engine {
@@ -19,3 +60,23 @@ func GPURunArgs(engine string) []string {
 		return []string{"--gpus", "all"}
 	}
 }
+
+// GPUCountRunArgs returns the engine-specific CLI arguments passing count GPUs
+// through (GPUAll for all of them, 0 for none). Podman gets the CDI devices of
+// the first count GPUs.
+func GPUCountRunArgs(engine string, count int) []string {
+	switch {
+	case count == GPUAll:
+		return GPURunArgs(engine)
+	case count <= 0:
+		return nil
+	case engine == "podman":
+		var args []string
+		for i := range count {
+			args = append(args, "--device", fmt.Sprintf("nvidia.com/gpu=%d", i))
+		}
+		return args
+	default:
+		return []string{"--gpus", strconv.Itoa(count)}
+	}
+}
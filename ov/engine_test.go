@@ -37,3 +37,72 @@ func TestGPURunArgs(t *testing.T) {
 		}
 	}
 }
+
+func TestGPUCountRunArgs(t *testing.T) {
+	tests := []struct {
+		engine string
+		count  int
+		want   []string
+	}{
+		{"docker", GPUAll, []string{"--gpus", "all"}},
+		{"podman", GPUAll, []string{"--device", "nvidia.com/gpu=all"}},
+		{"docker", 2, []string{"--gpus", "2"}},
+		{"podman", 2, []string{"--device", "nvidia.com/gpu=0", "--device", "nvidia.com/gpu=1"}},
+		{"docker", 0, nil},
+		{"podman", 0, nil},
+	}
+	for _, tt := range tests {
+		got := GPUCountRunArgs(tt.engine, tt.count)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("GPUCountRunArgs(%q, %d) = %v, want %v", tt.engine, tt.count, got, tt.want)
+		}
+	}
+}
+
+func TestEngineArgs(t *testing.T) {
+	orig := EngineEndpoints
+	defer func() { EngineEndpoints = orig }()
+	EngineEndpoints = map[string]string{"docker": "ssh://builder", "podman": "builder"}
+
+	tests := []struct {
+		engine   string
+		endpoint string
+		want     []string
+	}{
+		{"docker", "ssh://builder", []string{"--host", "ssh://builder"}},
+		{"podman", "builder", []string{"--connection", "builder"}},
+		{"podman", "ssh://core@builder/run/podman/podman.sock", []string{"--url", "ssh://core@builder/run/podman/podman.sock"}},
+		{"docker", "", nil},
+	}
+	for _, tt := range tests {
+		EngineEndpoints[tt.engine] = tt.endpoint
+		if got := EngineArgs(tt.engine); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("EngineArgs(%q) with endpoint %q = %v, want %v", tt.engine, tt.endpoint, got, tt.want)
+		}
+	}
+
+	EngineEndpoints["docker"] = "tcp://builder:2376"
+	cmd := engineCmd("docker", "image", "inspect", "app:latest")
+	want := []string{"docker", "--host", "tcp://builder:2376", "image", "inspect", "app:latest"}
+	if !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("engineCmd() args = %v, want %v", cmd.Args, want)
+	}
+}
+
+func TestIsRemoteEndpoint(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     bool
+	}{
+		{"", false},
+		{"unix:///run/user/1000/podman/podman.sock", false},
+		{"ssh://builder", true},
+		{"tcp://builder:2376", true},
+		{"builder", true},
+	}
+	for _, tt := range tests {
+		if got := isRemoteEndpoint(tt.endpoint); got != tt.want {
+			t.Errorf("isRemoteEndpoint(%q) = %v, want %v", tt.endpoint, got, tt.want)
+		}
+	}
+}
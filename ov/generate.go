@@ -3,10 +3,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // Generator holds state for generating build artifacts
@@ -18,6 +20,59 @@ type Generator struct {
 	Images         map[string]*ResolvedImage
 	BuildDir       string
 	Containerfiles map[string]string // cached content per image (used by ov build to pipe via stdin)
+	ContextIgnores map[string]string // Containerfile.dockerignore content per image (used by ov build)
+	Created        time.Time         // build timestamp for org.opencontainers.image.created (zero omits the label)
+	Revision       string            // git commit for org.opencontainers.image.revision ("" omits the label)
+	CacheID        string            // cache mount namespace (defaults.cache_id; "" keeps bare per-path caches)
+	Platform       string            // target platform of the build, keys its .build/state.json entries ("" for each image's platforms)
+	Compose        bool              // also write .build/compose.yaml for service images
+	Script         bool              // also write .build/build.sh (podman build script)
+	InputDigests   map[string]string // inputs digest per generated image (org.overthink.inputs-digest)
+	Updated        int               // files written by the last Generate
+	Unchanged      int               // files left untouched by the last Generate (same content)
+	generated      map[string]bool   // paths produced by the current Generate
+}
+
+// layerContextDir returns the directory of a layer relative to the build
+// context: layers/<name>, or its checkout below .build/remote-layers/
+func (g *Generator) layerContextDir(layerName string) string {
+	if layer, ok := g.Layers[layerName]; ok && layer.remoteDir != "" {
+		return layer.remoteDir
+	}
+	return "layers/" + layerName
+}
+
+// cacheMount returns a --mount=type=cache flag for dst. With a CacheID the mount
+// gets an explicit id, so projects sharing a builder don't share caches.
+func (g *Generator) cacheMount(dst string, opts string) string {
+	mount := "--mount=type=cache,"
+	if g.CacheID != "" {
+		mount += fmt.Sprintf("id=%s%s,", g.CacheID, strings.ReplaceAll(dst, "/", "-"))
+	}
+	return mount + "dst=" + dst + "," + opts
+}
+
+// userCacheMount returns a cache mount under the image user's home, owned by the user
+func (g *Generator) userCacheMount(img *ResolvedImage, path string) string {
+	return g.cacheMount(img.Home+"/"+path, fmt.Sprintf("uid=%d,gid=%d", img.UID, img.GID))
+}
+
+// secretMounts returns --mount=type=secret flags for a layer's secrets, one per
+// line in continuation form. opts (e.g. uid/gid) are appended to each mount.
+func (g *Generator) secretMounts(layerName string, opts string) string {
+	layer := g.Layers[layerName]
+	if layer == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, id := range layer.Secrets() {
+		mount := fmt.Sprintf("--mount=type=secret,id=%s,target=/run/secrets/%s", id, id)
+		if opts != "" {
+			mount += "," + opts
+		}
+		b.WriteString("    " + mount + " \\\n")
+	}
+	return b.String()
 }
 
 // resolveUserContext detects existing user in base image or uses configured values
@@ -66,9 +121,9 @@ func (g *Generator) resolveUserContext(img *ResolvedImage) error {
 	return nil
 }
 
-// NewGenerator creates a new generator
-func NewGenerator(dir string, tag string) (*Generator, error) {
-	cfg, err := LoadConfig(dir)
+// NewGenerator creates a new generator for images.yml loaded with opts
+func NewGenerator(dir string, tag string, opts ConfigOptions) (*Generator, error) {
+	cfg, err := LoadConfigWith(dir, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -82,9 +137,16 @@ func NewGenerator(dir string, tag string) (*Generator, error) {
 		return nil, err
 	}
 
-	// Compute CalVer if tag not specified
+	// Compute the tag if not specified (from the same instant as the created label)
+	created := time.Now().UTC()
 	if tag == "" {
-		tag = ComputeCalVer()
+		tag = ComputeTag(cfg, dir, created)
+	}
+	revision := ""
+	if cfg.Defaults.TagSuffix == "git" {
+		if sha, _, err := GitRevision(dir); err == nil {
+			revision = sha
+		}
 	}
 
 	images, err := cfg.ResolveAllImages(tag)
@@ -107,11 +169,16 @@ func NewGenerator(dir string, tag string) (*Generator, error) {
 		Images:         images,
 		BuildDir:       filepath.Join(dir, ".build"),
 		Containerfiles: make(map[string]string),
+		Created:        created,
+		Revision:       revision,
+		CacheID:        cfg.Defaults.CacheID,
+		Platform:       hostPlatform(),
 	}, nil
 }
 
 // cleanStaleBuildDirs removes image directories in .build/ that don't correspond
-// to any enabled image, and removes leftover files like docker-bake.hcl.
+// to any enabled image, and removes leftover files like docker-bake.hcl. The
+// remote layer checkouts in .build/remote-layers are kept.
 func (g *Generator) cleanStaleBuildDirs() error {
 	entries, err := os.ReadDir(g.BuildDir)
 	if err != nil {
@@ -123,6 +190,9 @@ func (g *Generator) cleanStaleBuildDirs() error {
 	for _, entry := range entries {
 		if entry.IsDir() {
 			name := entry.Name()
+			if name == filepath.Base(remoteLayersDir) {
+				continue
+			}
 			if _, exists := g.Images[name]; !exists {
 				path := filepath.Join(g.BuildDir, name)
 				if err := os.RemoveAll(path); err != nil {
@@ -149,6 +219,8 @@ func (g *Generator) Generate() error {
 		return fmt.Errorf("cleaning stale build dirs: %w", err)
 	}
 
+	g.Updated, g.Unchanged = 0, 0
+
 	// Create .build directory
 	if err := os.MkdirAll(g.BuildDir, 0755); err != nil {
 		return fmt.Errorf("creating .build directory: %w", err)
@@ -174,17 +246,77 @@ func (g *Generator) Generate() error {
 		}
 	}
 
+	if g.Compose {
+		if err := g.generateCompose(order); err != nil {
+			return fmt.Errorf("generating compose.yaml: %w", err)
+		}
+	}
+
+	if g.Script {
+		if err := g.generateBuildScript(order); err != nil {
+			return fmt.Errorf("generating build.sh: %w", err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Generated .build/: %d updated, %d unchanged\n", g.Updated, g.Unchanged)
 	return nil
 }
 
-// generateContainerfile generates a Containerfile for a single image
-func (g *Generator) generateContainerfile(imageName string) error {
-	// Clean image build directory to remove stale files from previous generations
-	imageDir := filepath.Join(g.BuildDir, imageName)
-	if err := os.RemoveAll(imageDir); err != nil {
+// writeGenerated writes a generated file unless it already has this content,
+// so unchanged files keep their mtime. Counts into Updated/Unchanged.
+func (g *Generator) writeGenerated(path string, content string) error {
+	if g.generated == nil {
+		g.generated = make(map[string]bool)
+	}
+	g.generated[path] = true
+
+	if existing, err := os.ReadFile(path); err == nil && string(existing) == content {
+		g.Unchanged++
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
 		return err
 	}
+	g.Updated++
+	return nil
+}
 
+// removeStaleFiles deletes files under dir that the current Generate did not
+// produce (e.g. fragments of a removed service), then any directories left empty.
+func (g *Generator) removeStaleFiles(dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if !g.generated[path] {
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	// Deepest first; Remove fails harmlessly on non-empty directories
+	for i := len(dirs) - 1; i > 0; i-- {
+		os.Remove(dirs[i])
+	}
+	return nil
+}
+
+// generateContainerfile generates a Containerfile for a single image
+func (g *Generator) generateContainerfile(imageName string) error {
+	imageDir := filepath.Join(g.BuildDir, imageName)
 	img := g.Images[imageName]
 	var b strings.Builder
 
@@ -206,22 +338,29 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		return err
 	}
 
-	// ARG for base image must come first (before any FROM)
-	resolvedBase := g.resolveBaseImage(img)
-	b.WriteString(fmt.Sprintf("ARG BASE_IMAGE=%s\n\n", resolvedBase))
+	// ARG for base image must come first (before any FROM). The defaults are
+	// the resolved references, so a standalone build of the Containerfile works.
+	b.WriteString(fmt.Sprintf("ARG BASE_IMAGE=%s\n", g.resolveBaseImage(img)))
+	// Builder stages start from the builder image (builder itself doesn't use them)
+	builderRef := ""
+	if ref := g.builderRefForImage(imageName); ref != "" {
+		b.WriteString(fmt.Sprintf("ARG BUILDER_IMAGE=%s\n", ref))
+		builderRef = "${BUILDER_IMAGE}"
+	}
+	b.WriteString("\n")
 
 	// Emit scratch stages for each layer
 	for _, layerName := range layerOrder {
-		b.WriteString(fmt.Sprintf("FROM scratch AS %s\n", layerName))
-		b.WriteString(fmt.Sprintf("COPY layers/%s/ /\n\n", layerName))
+		b.WriteString(fmt.Sprintf("FROM scratch AS %s\n", layerStageName(layerName)))
+		b.WriteString(fmt.Sprintf("COPY %s/ /\n\n", g.layerContextDir(layerName)))
 	}
 
-	// Resolve builder ref for this image (builder itself doesn't use builder stages)
-	builderRef := g.builderRefForImage(imageName)
-
 	// Emit per-layer pixi build stages
 	// Cache mounts for pixi/rattler caches prevent bloating build stage layers
 	// (e.g. CUDA libraries cached by pixi can add 10GB+ to intermediate layers)
+	// A requirements.txt layer adds to the pixi project of the image's previous
+	// pixi layer (its stage and manifest), so its environment keeps theirs
+	var prevStage, prevProject string
 	for _, layerName := range layerOrder {
 		layer := g.Layers[layerName]
 		manifest := layer.PixiManifest()
@@ -229,16 +368,32 @@ func (g *Generator) generateContainerfile(imageName string) error {
 			if builderRef == "" {
 				return fmt.Errorf("image %q: layer %q has pixi manifest but no builder configured", imageName, layerName)
 			}
-			b.WriteString(fmt.Sprintf("FROM %s AS %s-pixi-build\n", builderRef, layerName))
+			from := builderRef
+			if manifest == "requirements.txt" && prevStage != "" {
+				from = prevStage
+			}
+			b.WriteString(fmt.Sprintf("FROM %s AS %s-pixi-build\n", from, layerStageName(layerName)))
+			// Run as the target image's user so the environment lands in its home
+			// (WORKDIR is created owned by the current USER)
+			b.WriteString(fmt.Sprintf("USER %d:%d\n", img.UID, img.GID))
 			b.WriteString(fmt.Sprintf("WORKDIR %s\n", img.Home))
 			if layer.HasPixiLock {
-				b.WriteString(fmt.Sprintf("COPY layers/%s/pixi.lock pixi.lock\n", layerName))
+				b.WriteString(fmt.Sprintf("COPY %s/pixi.lock pixi.lock\n", g.layerContextDir(layerName)))
 			}
-			b.WriteString(fmt.Sprintf("COPY layers/%s/%s %s\n", layerName, manifest, manifest))
-			cacheMounts := fmt.Sprintf("--mount=type=cache,dst=%s/.cache/pixi,uid=%d,gid=%d \\\n    --mount=type=cache,dst=%s/.cache/rattler,uid=%d,gid=%d \\\n    ",
-				img.Home, img.UID, img.GID, img.Home, img.UID, img.GID)
+			b.WriteString(fmt.Sprintf("COPY %s/%s %s\n", g.layerContextDir(layerName), manifest, manifest))
+			cacheMounts := g.userCacheMount(img, ".cache/pixi") + " \\\n    " + g.userCacheMount(img, ".cache/rattler") + " \\\n    "
 			if manifest == "environment.yml" {
 				b.WriteString(fmt.Sprintf("RUN %spixi project import %s && pixi install\n", cacheMounts, manifest))
+			} else if manifest == "requirements.txt" {
+				// Pixi is the only Python package manager: import requirements as PyPI deps
+				// of the previous pixi layer's project, else of a new one with a pinned
+				// Python
+				init := ""
+				if prevStage == "" {
+					prevProject = "pixi.toml"
+					init = fmt.Sprintf("pixi init && pixi add \"python=%s.*\" && \\\n    ", DefaultPythonVersion)
+				}
+				b.WriteString(fmt.Sprintf("RUN %s%s%s | xargs -r -d '\\n' pixi add --manifest-path %s --pypi && \\\n    pixi install --manifest-path %s\n", cacheMounts, init, requirementsFilter, prevProject, prevProject))
 			} else if manifest == "pyproject.toml" {
 				b.WriteString(fmt.Sprintf("RUN %spixi install --manifest-path pyproject.toml\n", cacheMounts))
 			} else if layer.HasPixiLock {
@@ -247,6 +402,12 @@ func (g *Generator) generateContainerfile(imageName string) error {
 				b.WriteString(fmt.Sprintf("RUN %spixi install\n", cacheMounts))
 			}
 			b.WriteString("\n")
+			prevStage = layerStageName(layerName) + "-pixi-build"
+			if manifest == "pyproject.toml" {
+				prevProject = manifest
+			} else if manifest != "requirements.txt" {
+				prevProject = "pixi.toml"
+			}
 		}
 	}
 
@@ -256,12 +417,43 @@ func (g *Generator) generateContainerfile(imageName string) error {
 			if builderRef == "" {
 				return fmt.Errorf("image %q: layer %q has package.json but no builder configured", imageName, layerName)
 			}
-			b.WriteString(fmt.Sprintf("FROM %s AS %s-npm-build\n", builderRef, layerName))
-			b.WriteString(fmt.Sprintf("COPY layers/%s/package.json /tmp/package.json\n", layerName))
+			b.WriteString(fmt.Sprintf("FROM %s AS %s-npm-build\n", builderRef, layerStageName(layerName)))
+			b.WriteString(fmt.Sprintf("COPY %s/package.json /tmp/package.json\n", g.layerContextDir(layerName)))
 			b.WriteString("WORKDIR /tmp\n")
 			b.WriteString("USER root\n")
 			b.WriteString("ENV NPM_CONFIG_PREFIX=/npm-global\n")
-			b.WriteString("RUN node -e 'var d=require(\"./package.json\").dependencies||{};for(var[n,v]of Object.entries(d))console.log(v===\"*\"?n:n+\"@\"+v)' | xargs npm install -g\n\n")
+			b.WriteString("RUN ")
+			if mounts := g.secretMounts(layerName, ""); mounts != "" {
+				b.WriteString(strings.TrimPrefix(mounts, "    ") + "    ")
+			}
+			b.WriteString("node -e 'var d=require(\"./package.json\").dependencies||{};for(var[n,v]of Object.entries(d))console.log(v===\"*\"?n:n+\"@\"+v)' | xargs npm install -g\n\n")
+		}
+	}
+
+	// Emit per-layer cargo build stages (toolchain stays out of the final image)
+	for _, layerName := range layerOrder {
+		if g.Layers[layerName].HasCargoToml {
+			if builderRef == "" {
+				return fmt.Errorf("image %q: layer %q has Cargo.toml but no builder configured", imageName, layerName)
+			}
+			b.WriteString(fmt.Sprintf("FROM %s AS %s-cargo-build\n", builderRef, layerStageName(layerName)))
+			b.WriteString(fmt.Sprintf("USER %d:%d\n", img.UID, img.GID))
+			b.WriteString(fmt.Sprintf("WORKDIR %s\n", img.Home))
+			g.writeCargoToml(&b, layerName, img)
+			b.WriteString("\n")
+		}
+	}
+
+	// Emit per-layer go build stages (without a builder, go layers build in place)
+	if builderRef != "" {
+		for _, layerName := range layerOrder {
+			if g.Layers[layerName].HasGoMod {
+				b.WriteString(fmt.Sprintf("FROM %s AS %s-go-build\n", builderRef, layerStageName(layerName)))
+				b.WriteString(fmt.Sprintf("USER %d:%d\n", img.UID, img.GID))
+				b.WriteString(fmt.Sprintf("WORKDIR %s\n", img.Home))
+				g.writeGoMod(&b, layerName, img)
+				b.WriteString("\n")
+			}
 		}
 	}
 
@@ -301,17 +493,28 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		}
 		b.WriteString("FROM scratch AS supervisord-conf\n")
 		b.WriteString("COPY templates/supervisord.header.conf /fragments/00-header.conf\n")
-		for i, layerName := range layerOrder {
-			layer := g.Layers[layerName]
-			if layer.HasSupervisord {
-				b.WriteString(fmt.Sprintf("COPY .build/%s/fragments/%02d-%s.conf /fragments/%02d-%s.conf\n", imageName, i+1, layerName, i+1, layerName))
+		var fragments []string
+		for _, layerName := range layerOrder {
+			if layer := g.Layers[layerName]; layer.HasSupervisord {
+				fragments = append(fragments, layer.ServiceFragmentName())
 			}
 		}
+		sortStrings(fragments)
+		for _, frag := range fragments {
+			b.WriteString(fmt.Sprintf("COPY .build/%s/fragments/%s /fragments/%s\n", imageName, frag, frag))
+		}
 		b.WriteString("\n")
 	}
 
-	// Main image
+	// Main image; TARGETARCH (set by buildx/buildah) feeds the bootstrap download,
+	// OV_ARCH in layer task files and platform-restricted layer guards
 	b.WriteString("FROM ${BASE_IMAGE}\n\n")
+	b.WriteString("ARG TARGETARCH\n\n")
+
+	// No-op marker ov merge doesn't merge across, keeping the base's layers shared
+	if img.Merge != nil && img.Merge.PreserveBaseBoundary {
+		b.WriteString(fmt.Sprintf("LABEL %s=%s\n\n", LabelLayerBoundary, imageName))
+	}
 
 	// Bootstrap preamble (only for external base images)
 	if img.IsExternalBase {
@@ -321,6 +524,9 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		b.WriteString("USER root\n\n")
 	}
 
+	// Per-image raw snippet, runs as root before any layer
+	writeSnippet(&b, "containerfile_pre", img.ContainerfilePre)
+
 	// Collect and write environment variables from layers
 	g.writeLayerEnv(&b, layerOrder, img)
 
@@ -335,7 +541,7 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		layer := g.Layers[layerName]
 		if layer.PixiManifest() != "" {
 			b.WriteString(fmt.Sprintf("# Copy pixi environment: %s\n", layerName))
-			b.WriteString(fmt.Sprintf("COPY --from=%s-pixi-build --chown=%d:%d %s/.pixi/envs/default %s/.pixi/envs/default\n", layerName, img.UID, img.GID, img.Home, img.Home))
+			b.WriteString(fmt.Sprintf("COPY --from=%s-pixi-build --chown=%d:%d %s/.pixi/envs/default %s/.pixi/envs/default\n", layerStageName(layerName), img.UID, img.GID, img.Home, img.Home))
 			// Also copy the binary if it's the first time or just overwrite (pixi is self-contained?)
 			// Wait, the pixi binary itself:
 			// "please use ghcr.io/prefix-dev/pixi:latest as the build image for all pixi build layers"
@@ -360,7 +566,7 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		// We'll copy from the first pixi layer found.
 		for _, layerName := range layerOrder {
 			if g.Layers[layerName].PixiManifest() != "" {
-				b.WriteString(fmt.Sprintf("COPY --from=%s-pixi-build /usr/local/bin/pixi /usr/local/bin/pixi\n\n", layerName))
+				b.WriteString(fmt.Sprintf("COPY --from=%s-pixi-build /usr/local/bin/pixi /usr/local/bin/pixi\n\n", layerStageName(layerName)))
 				break
 			}
 		}
@@ -374,21 +580,57 @@ func (g *Generator) generateContainerfile(imageName string) error {
 				b.WriteString("# Copy npm packages\n")
 				hasNpm = true
 			}
-			b.WriteString(fmt.Sprintf("COPY --from=%s-npm-build --chown=%d:%d /npm-global %s/.npm-global\n", layerName, img.UID, img.GID, img.Home))
+			b.WriteString(fmt.Sprintf("COPY --from=%s-npm-build --chown=%d:%d /npm-global %s/.npm-global\n", layerStageName(layerName), img.UID, img.GID, img.Home))
 		}
 	}
 	if hasNpm {
 		b.WriteString("\n")
 	}
 
+	// Copy cargo binaries from build stages
+	hasCargo := false
+	for _, layerName := range layerOrder {
+		if g.Layers[layerName].HasCargoToml {
+			if !hasCargo {
+				b.WriteString("# Copy cargo binaries\n")
+				hasCargo = true
+			}
+			b.WriteString(fmt.Sprintf("COPY --from=%s-cargo-build --chown=%d:%d %s/.cargo/bin/ %s/.cargo/bin/\n", layerStageName(layerName), img.UID, img.GID, img.Home, img.Home))
+		}
+	}
+	if hasCargo {
+		b.WriteString("\n")
+	}
+
+	// Copy go binaries from build stages
+	hasGo := false
+	if builderRef != "" {
+		for _, layerName := range layerOrder {
+			if g.Layers[layerName].HasGoMod {
+				if !hasGo {
+					b.WriteString("# Copy go binaries\n")
+					hasGo = true
+				}
+				b.WriteString(fmt.Sprintf("COPY --from=%s-go-build --chown=%d:%d %s/.local/bin/ %s/.local/bin/\n", layerStageName(layerName), img.UID, img.GID, img.Home, img.Home))
+			}
+		}
+	}
+	if hasGo {
+		b.WriteString("\n")
+	}
+
 	// Process each layer
-	// Post-layer steps (supervisord, traefik, bootc) run as root,
+	// Post-layer steps (supervisord, traefik, containerfile_post, cleanup, bootc) run as root,
 	// so the last layer must reset to root only if such steps exist.
-	needsRootAfter := hasServices || hasRoutes || img.Bootc
+	cleanup := img.Cleanup && !img.Auto
+	needsRootAfter := hasServices || hasRoutes || img.ContainerfilePost != "" || cleanup || img.Bootc
 	inUserMode := false
 	for i, layerName := range layerOrder {
 		isLast := i == len(layerOrder)-1
-		inUserMode = g.writeLayerSteps(&b, layerName, img, isLast && !needsRootAfter)
+		var err error
+		if inUserMode, err = g.writeLayerSteps(&b, layerName, img, isLast && !needsRootAfter); err != nil {
+			return fmt.Errorf("image %q: layer %q: %w", imageName, layerName, err)
+		}
 	}
 
 	// Assemble supervisord config if needed
@@ -404,27 +646,121 @@ func (g *Generator) generateContainerfile(imageName string) error {
 		b.WriteString("COPY --from=traefik-routes /routes.yml /etc/traefik/dynamic/routes.yml\n\n")
 	}
 
+	// Per-image raw snippet, runs as root after all layers (before bootc lint)
+	writeSnippet(&b, "containerfile_post", img.ContainerfilePost)
+
+	// Cleanup (auto-intermediates skip it: their children build on top anyway)
+	if cleanup {
+		writeCleanup(&b, img)
+	}
+
 	// Bootc lint if applicable (must run as root)
 	if img.Bootc {
 		b.WriteString("RUN bootc container lint\n\n")
 	}
 
+	g.writeHealthcheck(&b, layerOrder, img)
+
+	g.writeOCILabels(&b, img, layerOrder)
+
 	// Final USER directive (use UID for robustness)
 	// Skip if already in user mode and no root steps followed
 	if !inUserMode || needsRootAfter {
 		b.WriteString(fmt.Sprintf("USER %d\n", img.UID))
 	}
 
-	// imageDir was cleaned at the start of this function; ensure it exists
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return err
-	}
+	g.writeCommand(&b, img)
 
 	content := b.String()
+
+	// Fill in the build inputs digest (the hash skips its own label line)
+	digest, err := g.inputsDigest(imageName, content, layerOrder)
+	if err != nil {
+		return fmt.Errorf("hashing build inputs: %w", err)
+	}
+	if g.InputDigests == nil {
+		g.InputDigests = make(map[string]string)
+	}
+	g.InputDigests[imageName] = digest
+	content = strings.Replace(content, inputsDigestPlaceholder, digest, 1)
+
 	g.Containerfiles[imageName] = content
 
-	containerfile := filepath.Join(imageDir, "Containerfile")
-	return os.WriteFile(containerfile, []byte(content), 0644)
+	if err := g.writeGenerated(filepath.Join(imageDir, "Containerfile"), content); err != nil {
+		return err
+	}
+	ignore := g.contextIgnore(imageName, layerOrder)
+	if g.ContextIgnores == nil {
+		g.ContextIgnores = make(map[string]string)
+	}
+	g.ContextIgnores[imageName] = ignore
+	if err := g.writeGenerated(filepath.Join(imageDir, "Containerfile.dockerignore"), ignore); err != nil {
+		return err
+	}
+
+	// Drop files from previous generations (removed routes, services)
+	return g.removeStaleFiles(imageDir)
+}
+
+// contextIgnore returns the per-image ignore file limiting the build context to
+// the image's own layers (minus their .ovignore patterns), templates/ and its
+// .build/<image>/ directory. The
+// project .dockerignore patterns are appended, since a Containerfile-specific
+// ignore file replaces it.
+func (g *Generator) contextIgnore(imageName string, layerOrder []string) string {
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("# .build/%s/Containerfile.dockerignore (generated -- do not edit)\n", imageName))
+	b.WriteString("*\n")
+	for _, layerName := range layerOrder {
+		b.WriteString(fmt.Sprintf("!%s\n", g.layerContextDir(layerName)))
+	}
+	b.WriteString("!templates\n")
+	b.WriteString(fmt.Sprintf("!.build/%s\n", imageName))
+	for _, layerName := range layerOrder {
+		if layer, ok := g.Layers[layerName]; ok {
+			for _, line := range layer.ignore.dockerignore(g.layerContextDir(layerName)) {
+				b.WriteString(line + "\n")
+			}
+		}
+	}
+
+	if g.Dir != "" {
+		if data, err := os.ReadFile(filepath.Join(g.Dir, ".dockerignore")); err == nil {
+			b.WriteString("\n")
+			b.Write(data)
+		}
+	}
+	return b.String()
+}
+
+// writeCleanup removes package manager metadata, temp files and user caches
+// left behind in the image (cache mounts keep the real caches outside it)
+func writeCleanup(b *strings.Builder, img *ResolvedImage) {
+	b.WriteString("# Cleanup\n")
+	switch img.Pkg {
+	case "deb":
+		b.WriteString("RUN apt-get clean && \\\n")
+		b.WriteString("    rm -rf /var/lib/apt/lists/* /var/log/apt /var/log/dpkg.log \\\n")
+	case "apk":
+		b.WriteString("RUN rm -rf /var/cache/apk/* \\\n")
+	default:
+		b.WriteString("RUN rm -rf /var/cache/dnf /var/cache/libdnf5/* /var/lib/dnf/repos /var/log/dnf5.log* \\\n")
+	}
+	b.WriteString(fmt.Sprintf("    /tmp/* /var/tmp/* /root/.cache %s/.cache\n\n", img.Home))
+}
+
+// writeSnippet writes a raw images.yml Containerfile snippet verbatim,
+// fenced by marker comments so the injected block is easy to spot
+func writeSnippet(b *strings.Builder, field string, snippet string) {
+	if strings.TrimSpace(snippet) == "" {
+		return
+	}
+	b.WriteString(fmt.Sprintf("# --- begin %s (images.yml) ---\n", field))
+	b.WriteString(snippet)
+	if !strings.HasSuffix(snippet, "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(fmt.Sprintf("# --- end %s ---\n\n", field))
 }
 
 // resolveBaseImage returns the full base image reference.
@@ -442,8 +778,8 @@ func (g *Generator) resolveBaseImage(img *ResolvedImage) string {
 // builderRefForImage returns the full tag of the builder image for a given image,
 // or "" if the image has no builder or is the builder itself.
 func (g *Generator) builderRefForImage(imageName string) string {
-	img := g.Images[imageName]
-	if img.Builder == "" || img.Builder == imageName {
+	img, ok := g.Images[imageName]
+	if !ok || img.Builder == "" || img.Builder == imageName {
 		return ""
 	}
 	if builderImg, ok := g.Images[img.Builder]; ok {
@@ -452,6 +788,36 @@ func (g *Generator) builderRefForImage(imageName string) string {
 	return ""
 }
 
+// requirementsFilter prints the requirements of requirements.txt one per line:
+// CRLF line endings, comments (inline ones too), surrounding whitespace, blank
+// lines and pip options like -r/--index-url are dropped
+const requirementsFilter = `tr -d '\r' < requirements.txt | sed -E -e 's/(^|[[:space:]])#.*$//' -e 's/^[[:space:]]+|[[:space:]]+$//g' | grep -vE '^(-|$)'`
+
+// DefaultPythonVersion is the Python a requirements.txt layer's pixi
+// environment gets when no earlier pixi layer of its image provides one
+// (known-good; bump deliberately)
+const DefaultPythonVersion = "3.12"
+
+// DefaultTaskVersion is the go-task release the bootstrap installs unless
+// task_version is configured (known-good; bump deliberately)
+const DefaultTaskVersion = "v3.44.0"
+
+// taskDownloadURL returns the task tarball URL for a release ("" means
+// DefaultTaskVersion, "latest" the moving latest release). ${ARCH} is left
+// for the shell.
+func taskDownloadURL(version string) string {
+	if version == "" {
+		version = DefaultTaskVersion
+	}
+	if version == "latest" {
+		return "https://github.com/go-task/task/releases/latest/download/task_linux_${ARCH}.tar.gz"
+	}
+	if !strings.HasPrefix(version, "v") {
+		version = "v" + version
+	}
+	return fmt.Sprintf("https://github.com/go-task/task/releases/download/%s/task_linux_${ARCH}.tar.gz", version)
+}
+
 // writeBootstrap writes the bootstrap preamble for external base images
 func (g *Generator) writeBootstrap(b *strings.Builder, img *ResolvedImage) {
 	b.WriteString("# Bootstrap\n")
@@ -459,11 +825,18 @@ func (g *Generator) writeBootstrap(b *strings.Builder, img *ResolvedImage) {
 	// Install task
 	b.WriteString("RUN ")
 	if img.Pkg == "deb" {
-		b.WriteString("--mount=type=cache,dst=/var/cache/apt,sharing=locked \\\n")
-		b.WriteString("    --mount=type=cache,dst=/var/lib/apt,sharing=locked \\\n")
+		b.WriteString(g.cacheMount("/var/cache/apt", "sharing=locked") + " \\\n")
+		b.WriteString("    " + g.cacheMount("/var/lib/apt", "sharing=locked") + " \\\n")
 		b.WriteString("    apt-get update && apt-get install -y --no-install-recommends curl ca-certificates && \\\n    ")
+	} else if img.Pkg == "apk" {
+		// Alpine ships neither curl nor bash; task files and the user shell need both.
+		// apk only keeps packages in /var/cache/apk (the cache mount) when
+		// /etc/apk/cache links there.
+		b.WriteString(g.cacheMount("/var/cache/apk", "sharing=locked") + " \\\n")
+		b.WriteString("    ln -sfn /var/cache/apk /etc/apk/cache && \\\n")
+		b.WriteString("    apk add --update-cache curl ca-certificates bash && \\\n    ")
 	} else {
-		b.WriteString("--mount=type=cache,dst=/var/cache/libdnf5,sharing=locked \\\n    ")
+		b.WriteString(g.cacheMount("/var/cache/libdnf5", "sharing=locked") + " \\\n    ")
 	}
 	// Remove repos with corrupt zchunk metadata (dnf5 skip_if_unavailable doesn't handle this;
 	// disabling via sed is insufficient because the shared libdnf5 cache mount retains stale metadata)
@@ -471,14 +844,38 @@ func (g *Generator) writeBootstrap(b *strings.Builder, img *ResolvedImage) {
 		b.WriteString("rm -f /etc/yum.repos.d/terra-mesa.repo 2>/dev/null || true && \\\n    ")
 	}
 	b.WriteString("{ [ -L /usr/local ] && mkdir -p \"$(readlink /usr/local)\"; mkdir -p /usr/local/bin; } && \\\n")
-	b.WriteString("    ARCH=$(uname -m) && \\\n")
+	b.WriteString("    ARCH=${TARGETARCH:-$(uname -m)} && \\\n")
 	b.WriteString("    case \"$ARCH\" in x86_64) ARCH=amd64;; aarch64) ARCH=arm64;; esac && \\\n")
-	b.WriteString("    curl -fsSL \"https://github.com/go-task/task/releases/latest/download/task_linux_${ARCH}.tar.gz\" | tar -xzf - -C /usr/local/bin task\n\n")
+	taskURL := taskDownloadURL(img.TaskVersion)
+	if len(img.TaskSHA256) == 0 {
+		b.WriteString(fmt.Sprintf("    curl -fsSL \"%s\" | tar -xzf - -C /usr/local/bin task\n\n", taskURL))
+	} else {
+		// Verify the tarball; an arch without a checksum fails the build
+		b.WriteString(fmt.Sprintf("    curl -fsSLo /tmp/task.tar.gz \"%s\" && \\\n", taskURL))
+		var arches []string
+		for arch := range img.TaskSHA256 {
+			arches = append(arches, arch)
+		}
+		sortStrings(arches)
+		b.WriteString("    case \"$ARCH\" in")
+		for _, arch := range arches {
+			b.WriteString(fmt.Sprintf(" %s) SUM=%s;;", arch, img.TaskSHA256[arch]))
+		}
+		b.WriteString(" *) SUM=missing;; esac && \\\n")
+		b.WriteString("    echo \"$SUM  /tmp/task.tar.gz\" | sha256sum -c - && \\\n")
+		b.WriteString("    tar -xzf /tmp/task.tar.gz -C /usr/local/bin task && rm -f /tmp/task.tar.gz\n\n")
+	}
 
 	// Create user/group if they don't exist at configured UID/GID
 	b.WriteString(fmt.Sprintf("RUN getent passwd %d >/dev/null 2>&1 || \\\n", img.UID))
-	b.WriteString(fmt.Sprintf("    (getent group %d >/dev/null 2>&1 || groupadd -g %d %s && \\\n", img.GID, img.GID, img.User))
-	b.WriteString(fmt.Sprintf("     useradd -m -u %d -g %d -s /bin/bash %s)\n\n", img.UID, img.GID, img.User))
+	if img.Pkg == "apk" {
+		// BusyBox adduser/addgroup take the group by name, not GID
+		b.WriteString(fmt.Sprintf("    (getent group %d >/dev/null 2>&1 || addgroup -g %d %s && \\\n", img.GID, img.GID, img.User))
+		b.WriteString(fmt.Sprintf("     adduser -D -u %d -G \"$(getent group %d | cut -d: -f1)\" -s /bin/bash %s)\n\n", img.UID, img.GID, img.User))
+	} else {
+		b.WriteString(fmt.Sprintf("    (getent group %d >/dev/null 2>&1 || groupadd -g %d %s && \\\n", img.GID, img.GID, img.User))
+		b.WriteString(fmt.Sprintf("     useradd -m -u %d -g %d -s /bin/bash %s)\n\n", img.UID, img.GID, img.User))
+	}
 
 	// WORKDIR only - ENV comes from layer env files
 	b.WriteString(fmt.Sprintf("WORKDIR %s\n\n", img.Home))
@@ -534,14 +931,14 @@ func (g *Generator) writeLayerEnv(b *strings.Builder, layerOrder []string, img *
 	}
 }
 
-// writeExpose collects ports from all layers, deduplicates, sorts, and emits EXPOSE directives
-func (g *Generator) writeExpose(b *strings.Builder, layerOrder []string) {
+// collectLayerPorts returns the deduplicated, sorted union of ports declared by the given layers.
+func collectLayerPorts(layerNames []string, layers map[string]*Layer) []string {
 	seen := make(map[string]bool)
 	var ports []string
 
-	for _, layerName := range layerOrder {
-		layer := g.Layers[layerName]
-		if !layer.HasPorts {
+	for _, layerName := range layerNames {
+		layer, ok := layers[layerName]
+		if !ok || !layer.HasPorts {
 			continue
 		}
 		layerPorts, err := layer.Ports()
@@ -556,11 +953,31 @@ func (g *Generator) writeExpose(b *strings.Builder, layerOrder []string) {
 		}
 	}
 
+	sortStrings(ports)
+	return ports
+}
+
+// CollectImageExposedPorts returns the ports exposed by an image: the union of
+// layer.yml ports across the image's layers and its internal base chain.
+func CollectImageExposedPorts(cfg *Config, layers map[string]*Layer, imageName string) ([]string, error) {
+	images, err := cfg.ResolveAllImages(MetadataTag)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := imageLayerChain(imageName, images, layers)
+	if err != nil {
+		return nil, err
+	}
+	return collectLayerPorts(chain, layers), nil
+}
+
+// writeExpose collects ports from all layers, deduplicates, sorts, and emits EXPOSE directives
+func (g *Generator) writeExpose(b *strings.Builder, layerOrder []string) {
+	ports := collectLayerPorts(layerOrder, g.Layers)
 	if len(ports) == 0 {
 		return
 	}
 
-	sortStrings(ports)
 	b.WriteString("# Exposed ports\n")
 	for _, port := range ports {
 		b.WriteString(fmt.Sprintf("EXPOSE %s\n", port))
@@ -591,7 +1008,7 @@ func (g *Generator) generateTraefikRoutes(imageName string, layerOrder []string)
 		if err != nil || route == nil {
 			continue
 		}
-		routes = append(routes, routeEntry{name: layerName, cfg: route})
+		routes = append(routes, routeEntry{name: layerStageName(layerName), cfg: route})
 	}
 
 	for _, r := range routes {
@@ -610,22 +1027,13 @@ func (g *Generator) generateTraefikRoutes(imageName string, layerOrder []string)
 		b.WriteString(fmt.Sprintf("          - url: \"http://127.0.0.1:%s\"\n", r.cfg.Port))
 	}
 
-	imageDir := filepath.Join(g.BuildDir, imageName)
-	if err := os.MkdirAll(imageDir, 0755); err != nil {
-		return err
-	}
-
-	return os.WriteFile(filepath.Join(imageDir, "traefik-routes.yml"), []byte(b.String()), 0644)
+	return g.writeGenerated(filepath.Join(g.BuildDir, imageName, "traefik-routes.yml"), b.String())
 }
 
 // generateSupervisordFragments writes service fragments from layer.yml to .build/<image>/fragments/
 func (g *Generator) generateSupervisordFragments(imageName string, layerOrder []string) error {
 	fragDir := filepath.Join(g.BuildDir, imageName, "fragments")
-	if err := os.MkdirAll(fragDir, 0755); err != nil {
-		return err
-	}
-
-	for i, layerName := range layerOrder {
+	for _, layerName := range layerOrder {
 		layer := g.Layers[layerName]
 		if !layer.HasSupervisord {
 			continue
@@ -634,8 +1042,8 @@ func (g *Generator) generateSupervisordFragments(imageName string, layerOrder []
 		if !strings.HasSuffix(content, "\n") {
 			content += "\n"
 		}
-		fragFile := filepath.Join(fragDir, fmt.Sprintf("%02d-%s.conf", i+1, layerName))
-		if err := os.WriteFile(fragFile, []byte(content), 0644); err != nil {
+		fragFile := filepath.Join(fragDir, layer.ServiceFragmentName())
+		if err := g.writeGenerated(fragFile, content); err != nil {
 			return err
 		}
 	}
@@ -646,35 +1054,119 @@ func (g *Generator) generateSupervisordFragments(imageName string, layerOrder []
 // skipRootReset prevents emitting USER root after user-mode steps (used for the
 // last layer when no post-layer root steps follow).
 // Returns true if the layer ended in user mode.
-func (g *Generator) writeLayerSteps(b *strings.Builder, layerName string, img *ResolvedImage, skipRootReset bool) bool {
+func (g *Generator) writeLayerSteps(b *strings.Builder, layerName string, img *ResolvedImage, skipRootReset bool) (bool, error) {
+	guard := platformGuard(g.Layers[layerName].Platforms())
+	if guard == "" {
+		return g.writeLayerInstall(b, layerName, img, skipRootReset)
+	}
+
+	// Platform-restricted layer: keep the steps (so layer order and intermediates
+	// are identical on every platform) but turn each RUN into a no-op elsewhere
+	var steps strings.Builder
+	asUser, err := g.writeLayerInstall(&steps, layerName, img, skipRootReset)
+	if err != nil {
+		return false, err
+	}
+	b.WriteString(guardRuns(steps.String(), guard))
+	return asUser, nil
+}
+
+// platformGuard returns a shell prefix that exits 0 unless TARGETARCH matches
+// one of the platforms ("linux/arm64/v8" matches TARGETARCH=arm64)
+func platformGuard(platforms []string) string {
+	if len(platforms) == 0 {
+		return ""
+	}
+	var arches []string
+	seen := make(map[string]bool)
+	for _, p := range platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) < 2 || seen[parts[1]] {
+			continue
+		}
+		seen[parts[1]] = true
+		arches = append(arches, parts[1])
+	}
+	return fmt.Sprintf("case \"$TARGETARCH\" in %s) ;; *) exit 0 ;; esac", strings.Join(arches, "|"))
+}
+
+// guardRuns prefixes the command of every RUN instruction with guard
+// (after any --mount continuation lines)
+func guardRuns(steps string, guard string) string {
+	lines := strings.Split(steps, "\n")
+	inRun := false
+	for i, line := range lines {
+		prefix, body := "    ", strings.TrimPrefix(line, "    ")
+		if strings.HasPrefix(line, "RUN ") {
+			inRun = true
+			prefix, body = "RUN ", line[len("RUN "):]
+		}
+		if !inRun || strings.HasPrefix(body, "--mount=") {
+			continue
+		}
+		lines[i] = prefix + guard + " && " + body
+		inRun = false
+	}
+	return strings.Join(lines, "\n")
+}
+
+// writeLayerInstall writes a layer's install steps, returning whether it ended in user mode
+func (g *Generator) writeLayerInstall(b *strings.Builder, layerName string, img *ResolvedImage, skipRootReset bool) (bool, error) {
 	layer := g.Layers[layerName]
 
-	b.WriteString(fmt.Sprintf("# Layer: %s\n", layerName))
+	if hash, err := layer.Hash(); err == nil {
+		b.WriteString(fmt.Sprintf("# Layer: %s (%s)\n", layerName, hash))
+	} else {
+		b.WriteString(fmt.Sprintf("# Layer: %s\n", layerName))
+	}
 
 	// Track if we've switched to user mode
 	asUser := false
 
-	// 1. rpm or deb packages from layer.yml (root)
+	// Go layers without a builder build in place with the distro toolchain
+	goInPlace := layer.HasGoMod && g.builderRefForImage(img.Name) == ""
+
+	// 1. rpm, deb or apk packages from layer.yml (root)
 	rpm := layer.RpmConfig()
 	deb := layer.DebConfig()
+	apk := layer.ApkConfig()
+	if goInPlace {
+		rpm, deb, apk = withGoToolchain(rpm, deb, apk)
+	}
 	if img.Pkg == "rpm" && rpm != nil && len(rpm.Packages) > 0 {
-		g.writeDnfInstall(b, rpm)
+		if err := g.writeDnfInstall(b, rpm); err != nil {
+			return false, err
+		}
 	} else if img.Pkg == "deb" && deb != nil && len(deb.Packages) > 0 {
-		g.writeAptInstall(b, deb)
+		if err := g.writeAptInstall(b, layerName, deb); err != nil {
+			return false, err
+		}
+	} else if img.Pkg == "apk" && apk != nil && len(apk.Packages) > 0 {
+		g.writeApkInstall(b, apk)
 	}
 
-	// 2. root.yml (root)
+	// 2. files/ (copied before root.yml so it can reference them)
+	if layer.HasFiles {
+		g.writeFiles(b, layerName, layer.FilesOwner(), img)
+	}
+
+	// 3. root.yml (root)
 	if layer.HasRootYml {
 		g.writeRootYml(b, layerName, img.Pkg)
 	}
 
-	// 4. Cargo.toml (user)
-	if layer.HasCargoToml {
+	// 3b. systemd units (root, bootc images only; other images use supervisord)
+	if img.Bootc && layer.HasSystemd {
+		g.writeSystemdUnits(b, layerName, layer.SystemdUnits())
+	}
+
+	// 4. go.mod built in place (user, no builder)
+	if goInPlace {
 		if !asUser {
 			b.WriteString(fmt.Sprintf("USER %d\n", img.UID))
 			asUser = true
 		}
-		g.writeCargoToml(b, layerName, img)
+		g.writeGoMod(b, layerName, img)
 	}
 
 	// 5. user.yml (user)
@@ -692,11 +1184,16 @@ func (g *Generator) writeLayerSteps(b *strings.Builder, layerName string, img *R
 	}
 
 	b.WriteString("\n")
-	return asUser
+	return asUser, nil
 }
 
-func (g *Generator) writeDnfInstall(b *strings.Builder, rpm *RpmConfig) {
-	b.WriteString("RUN --mount=type=cache,dst=/var/cache/libdnf5,sharing=locked \\\n")
+func (g *Generator) writeDnfInstall(b *strings.Builder, rpm *RpmConfig) error {
+	specs, err := rpm.Specs()
+	if err != nil {
+		return err
+	}
+
+	b.WriteString("RUN " + g.cacheMount("/var/cache/libdnf5", "sharing=locked") + " \\\n")
 
 	// External repos: add disabled, import GPG keys
 	for _, repo := range rpm.Repos {
@@ -724,14 +1221,21 @@ func (g *Generator) writeDnfInstall(b *strings.Builder, rpm *RpmConfig) {
 		b.WriteString(fmt.Sprintf(" --enable-repo=%q", repo.Name))
 	}
 
-	// Exclude patterns
+	// Exclude patterns (rpm.exclude and "!name" packages entries)
 	for _, excl := range rpm.Exclude {
 		b.WriteString(fmt.Sprintf(" --exclude='%s'", excl))
 	}
+	for _, spec := range specs {
+		if spec.Exclude {
+			b.WriteString(fmt.Sprintf(" --exclude='%s'", spec.Name))
+		}
+	}
 
-	// Packages
-	for _, pkg := range rpm.Packages {
-		b.WriteString(fmt.Sprintf(" \\\n      %s", pkg))
+	// Packages (pins as name-version)
+	for _, spec := range specs {
+		if !spec.Exclude {
+			b.WriteString(fmt.Sprintf(" \\\n      %s", shellQuoteGlob(spec.Arg("rpm"))))
+		}
 	}
 
 	// Disable COPR repos after install
@@ -740,39 +1244,163 @@ func (g *Generator) writeDnfInstall(b *strings.Builder, rpm *RpmConfig) {
 	}
 
 	b.WriteString("\n")
+	return nil
 }
 
-func (g *Generator) writeAptInstall(b *strings.Builder, deb *DebConfig) {
-	b.WriteString("RUN --mount=type=cache,dst=/var/cache/apt,sharing=locked \\\n")
-	b.WriteString("    --mount=type=cache,dst=/var/lib/apt,sharing=locked \\\n")
+func (g *Generator) writeAptInstall(b *strings.Builder, layerName string, deb *DebConfig) error {
+	specs, err := deb.Specs()
+	if err != nil {
+		return err
+	}
+
+	b.WriteString("RUN " + g.cacheMount("/var/cache/apt", "sharing=locked") + " \\\n")
+	b.WriteString("    " + g.cacheMount("/var/lib/apt", "sharing=locked") + " \\\n")
+
+	// Signing keys: fetched into /etc/apt/keyrings for "signed-by=" in deb lines
+	if len(deb.Keys) > 0 {
+		b.WriteString("    install -d -m 0755 /etc/apt/keyrings && \\\n")
+	}
+	for _, key := range deb.Keys {
+		b.WriteString(fmt.Sprintf("    curl -fsSL %q -o /etc/apt/keyrings/%s.asc && \\\n", key.URL, key.Name))
+	}
+
+	// deb lines: one sources.list.d file per layer
+	for i, src := range deb.Sources() {
+		redirect := ">>"
+		if i == 0 {
+			redirect = ">"
+		}
+		b.WriteString(fmt.Sprintf("    echo '%s' %s /etc/apt/sources.list.d/%s.list && \\\n", src, redirect, layerStageName(layerName)))
+	}
+
+	// PPAs: add-apt-repository comes from software-properties-common
+	if ppas := deb.PPAs(); len(ppas) > 0 {
+		b.WriteString("    apt-get update && apt-get install -y --no-install-recommends software-properties-common && \\\n")
+		for _, ppa := range ppas {
+			b.WriteString(fmt.Sprintf("    add-apt-repository -y -n %s && \\\n", ppa))
+		}
+	}
+
+	// "!name" entries: pin below zero so apt never installs them
+	var excludes []string
+	for _, spec := range specs {
+		if spec.Exclude {
+			excludes = append(excludes, spec.Name)
+		}
+	}
+	if len(excludes) > 0 {
+		b.WriteString(fmt.Sprintf("    printf 'Package: %s\\nPin: release *\\nPin-Priority: -1\\n' > /etc/apt/preferences.d/%s-exclude && \\\n", strings.Join(excludes, " "), layerStageName(layerName)))
+	}
+
 	b.WriteString("    apt-get update && apt-get install -y --no-install-recommends")
-	for _, pkg := range deb.Packages {
+	for _, spec := range specs {
+		if !spec.Exclude {
+			b.WriteString(fmt.Sprintf(" \\\n      %s", shellQuoteGlob(spec.Arg("deb"))))
+		}
+	}
+	b.WriteString("\n")
+	return nil
+}
+
+// shellQuoteGlob single-quotes a package argument containing glob characters
+// or parentheses (provides like pkgconfig(gtk+-3.0)) so the shell passes it to
+// the package manager unexpanded
+func shellQuoteGlob(arg string) string {
+	if strings.ContainsAny(arg, "*?[()") {
+		return "'" + arg + "'"
+	}
+	return arg
+}
+
+func (g *Generator) writeApkInstall(b *strings.Builder, apk *ApkConfig) {
+	b.WriteString("RUN " + g.cacheMount("/var/cache/apk", "sharing=locked") + " \\\n")
+	b.WriteString("    apk add --update-cache")
+	for _, pkg := range apk.Packages {
 		b.WriteString(fmt.Sprintf(" \\\n      %s", pkg))
 	}
 	b.WriteString("\n")
 }
 
+func (g *Generator) writeFiles(b *strings.Builder, layerName string, owner string, img *ResolvedImage) {
+	if owner == "user" {
+		b.WriteString(fmt.Sprintf("COPY --chown=%d:%d %s/files/ /\n", img.UID, img.GID, g.layerContextDir(layerName)))
+		return
+	}
+	b.WriteString(fmt.Sprintf("COPY %s/files/ /\n", g.layerContextDir(layerName)))
+}
+
 func (g *Generator) writeRootYml(b *strings.Builder, layerName string, pkg string) {
-	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerName))
+	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerStageName(layerName)))
 	if pkg == "deb" {
-		b.WriteString("    --mount=type=cache,dst=/var/cache/apt,sharing=locked \\\n")
-		b.WriteString("    --mount=type=cache,dst=/var/lib/apt,sharing=locked \\\n")
+		b.WriteString("    " + g.cacheMount("/var/cache/apt", "sharing=locked") + " \\\n")
+		b.WriteString("    " + g.cacheMount("/var/lib/apt", "sharing=locked") + " \\\n")
+	} else if pkg == "apk" {
+		b.WriteString("    " + g.cacheMount("/var/cache/apk", "sharing=locked") + " \\\n")
 	} else {
-		b.WriteString("    --mount=type=cache,dst=/var/cache/libdnf5,sharing=locked \\\n")
+		b.WriteString("    " + g.cacheMount("/var/cache/libdnf5", "sharing=locked") + " \\\n")
 	}
-	b.WriteString("    cd /ctx && task -t root.yml install\n")
+	b.WriteString(g.secretMounts(layerName, ""))
+	b.WriteString("    cd /ctx && OV_ARCH=${TARGETARCH} task -t root.yml install\n")
 }
 
 func (g *Generator) writeCargoToml(b *strings.Builder, layerName string, img *ResolvedImage) {
-	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerName))
-	b.WriteString(fmt.Sprintf("    --mount=type=cache,dst=%s/.cargo/registry,uid=%d,gid=%d \\\n", img.Home, img.UID, img.GID))
-	b.WriteString("    cargo install --path /ctx\n")
+	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerStageName(layerName)))
+	b.WriteString("    " + g.userCacheMount(img, ".cargo/registry") + " \\\n")
+	b.WriteString(fmt.Sprintf("    cargo install --path /ctx --root %s/.cargo\n", img.Home))
+}
+
+// writeSystemdUnits copies a layer's systemd/ units into the image and enables them.
+// Template units (name@.service) are copied but not enabled.
+func (g *Generator) writeSystemdUnits(b *strings.Builder, layerName string, units []string) {
+	b.WriteString(fmt.Sprintf("COPY %s/systemd/ /usr/lib/systemd/system/\n", g.layerContextDir(layerName)))
+	var enable []string
+	for _, unit := range units {
+		if !strings.Contains(unit, "@.") {
+			enable = append(enable, unit)
+		}
+	}
+	if len(enable) > 0 {
+		b.WriteString(fmt.Sprintf("RUN systemctl enable %s\n", strings.Join(enable, " ")))
+	}
+}
+
+// writeGoMod builds all main packages of a go.mod layer into <home>/.local/bin
+func (g *Generator) writeGoMod(b *strings.Builder, layerName string, img *ResolvedImage) {
+	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerStageName(layerName)))
+	b.WriteString("    " + g.userCacheMount(img, "go/pkg/mod") + " \\\n")
+	b.WriteString("    " + g.userCacheMount(img, ".cache/go-build") + " \\\n")
+	b.WriteString(fmt.Sprintf("    cd /ctx && GOBIN=%s/.local/bin go install ./...\n", img.Home))
+}
+
+// withGoToolchain returns copies of the package configs with the distro Go
+// toolchain package appended (golang, golang-go, go).
+func withGoToolchain(rpm *RpmConfig, deb *DebConfig, apk *ApkConfig) (*RpmConfig, *DebConfig, *ApkConfig) {
+	rpmCopy := &RpmConfig{}
+	if rpm != nil {
+		*rpmCopy = *rpm
+	}
+	rpmCopy.Packages = append(append([]string{}, rpmCopy.Packages...), "golang")
+
+	debCopy := &DebConfig{}
+	if deb != nil {
+		*debCopy = *deb
+	}
+	debCopy.Packages = append(append([]string{}, debCopy.Packages...), "golang-go")
+
+	apkCopy := &ApkConfig{}
+	if apk != nil {
+		*apkCopy = *apk
+	}
+	apkCopy.Packages = append(append([]string{}, apkCopy.Packages...), "go")
+
+	return rpmCopy, debCopy, apkCopy
 }
 
 func (g *Generator) writeUserYml(b *strings.Builder, layerName string, img *ResolvedImage) {
-	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerName))
-	b.WriteString(fmt.Sprintf("    --mount=type=cache,dst=%s/.cache/npm,uid=%d,gid=%d \\\n", img.Home, img.UID, img.GID))
-	b.WriteString("    cd /ctx && task -t user.yml install\n")
+	b.WriteString(fmt.Sprintf("RUN --mount=type=bind,from=%s,source=/,target=/ctx \\\n", layerStageName(layerName)))
+	b.WriteString("    " + g.userCacheMount(img, ".cache/npm") + " \\\n")
+	b.WriteString(g.secretMounts(layerName, fmt.Sprintf("uid=%d,gid=%d", img.UID, img.GID)))
+	b.WriteString("    cd /ctx && OV_ARCH=${TARGETARCH} task -t user.yml install\n")
 }
 
 // writeLabels emits OCI LABEL directives with runtime-relevant metadata.
@@ -788,6 +1416,18 @@ func (g *Generator) writeLabels(b *strings.Builder, imageName string, layerOrder
 	b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelUser, img.User))
 	b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelHome, img.Home))
 
+	// Provenance: the base this image was built FROM and the layers it installs
+	if base := g.labelBaseRef(img); base != "" {
+		b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelBase, base))
+	}
+	if img.BaseGroup != "" {
+		b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelBaseGroup, img.BaseGroup))
+	}
+	if len(layerOrder) > 0 {
+		layersJSON, _ := json.Marshal(layerOrder)
+		b.WriteString(fmt.Sprintf("LABEL %s='%s'\n", LabelLayers, string(layersJSON)))
+	}
+
 	// Ports from images.yml (runtime mappings)
 	if len(img.Ports) > 0 {
 		portsJSON, _ := json.Marshal(img.Ports)
@@ -815,6 +1455,164 @@ func (g *Generator) writeLabels(b *strings.Builder, imageName string, layerOrder
 		b.WriteString(fmt.Sprintf("LABEL %s='%s'\n", LabelAliases, string(aliasJSON)))
 	}
 
+	// Extra labels from images.yml, sorted by key for stable output
+	if len(img.Labels) > 0 {
+		keys := make([]string, 0, len(img.Labels))
+		for k := range img.Labels {
+			keys = append(keys, k)
+		}
+		sortStrings(keys)
+		for _, k := range keys {
+			b.WriteString(fmt.Sprintf("LABEL %s=%q\n", k, img.Labels[k]))
+		}
+	}
+
+	b.WriteString("\n")
+}
+
+// resolveHealthcheck returns the image's healthcheck: the image-level config
+// if set, otherwise the last layer in install order that declares one. Layers
+// of the auto-intermediates it builds on count, since those never emit one.
+func (g *Generator) resolveHealthcheck(layerOrder []string, img *ResolvedImage) *HealthcheckConfig {
+	if img.Healthcheck != nil {
+		return img.Healthcheck
+	}
+	for cur := img; cur != nil; {
+		var hc *HealthcheckConfig
+		for _, layerName := range layerOrder {
+			if layer, ok := g.Layers[layerName]; ok && layer.HasHealthcheck {
+				hc = layer.Healthcheck()
+			}
+		}
+		if hc != nil {
+			return hc
+		}
+		if cur.IsExternalBase {
+			break
+		}
+		parent, ok := g.Images[cur.Base]
+		if !ok || !parent.Auto {
+			break
+		}
+		cur = parent
+		layerOrder, _ = ResolveLayerOrder(cur.Layers, g.Layers, nil)
+	}
+	return nil
+}
+
+// writeHealthcheck emits a single HEALTHCHECK directive in exec form.
+// Auto-intermediates never get one, it belongs to the images built on them.
+func (g *Generator) writeHealthcheck(b *strings.Builder, layerOrder []string, img *ResolvedImage) {
+	if img.Auto {
+		return
+	}
+	hc := g.resolveHealthcheck(layerOrder, img)
+	if hc == nil || len(hc.Cmd) == 0 {
+		return
+	}
+	b.WriteString("# Healthcheck\n")
+	b.WriteString("HEALTHCHECK")
+	if hc.Interval != "" {
+		b.WriteString(" --interval=" + hc.Interval)
+	}
+	if hc.Timeout != "" {
+		b.WriteString(" --timeout=" + hc.Timeout)
+	}
+	if hc.StartPeriod != "" {
+		b.WriteString(" --start-period=" + hc.StartPeriod)
+	}
+	if hc.Retries > 0 {
+		b.WriteString(fmt.Sprintf(" --retries=%d", hc.Retries))
+	}
+	cmdJSON, _ := json.Marshal([]string(hc.Cmd))
+	b.WriteString(fmt.Sprintf(" CMD %s\n\n", cmdJSON))
+}
+
+// supervisordCmd is the default CMD for images with supervisord service layers.
+var supervisordCmd = Command{"supervisord", "-n", "-c", "/etc/supervisord.conf"}
+
+// writeCommand emits ENTRYPOINT and CMD in exec form. Images with supervisord
+// layers default to running supervisord. Auto-intermediates never get a command
+// so it does not leak into the images built on top of them.
+func (g *Generator) writeCommand(b *strings.Builder, img *ResolvedImage) {
+	if img.Auto {
+		return
+	}
+	cmd := img.Cmd
+	if len(cmd) == 0 && len(img.Entrypoint) == 0 && g.imageHasServices(img) {
+		cmd = supervisordCmd
+	}
+	if len(img.Entrypoint) > 0 {
+		entrypointJSON, _ := json.Marshal([]string(img.Entrypoint))
+		b.WriteString(fmt.Sprintf("ENTRYPOINT %s\n", entrypointJSON))
+	}
+	if len(cmd) > 0 {
+		cmdJSON, _ := json.Marshal([]string(cmd))
+		b.WriteString(fmt.Sprintf("CMD %s\n", cmdJSON))
+	}
+}
+
+// imageHasServices returns true if the image, or an auto-intermediate it is
+// built on, installs a supervisord service layer. User-defined parents emit
+// their own CMD, which the image inherits.
+func (g *Generator) imageHasServices(img *ResolvedImage) bool {
+	for cur := img; cur != nil; {
+		resolved, _ := ResolveLayerOrder(cur.Layers, g.Layers, nil)
+		for _, layerName := range resolved {
+			if layer, ok := g.Layers[layerName]; ok && layer.HasSupervisord {
+				return true
+			}
+		}
+		if cur.IsExternalBase {
+			break
+		}
+		parent, ok := g.Images[cur.Base]
+		if !ok || !parent.Auto {
+			break
+		}
+		cur = parent
+	}
+	return false
+}
+
+// labelBaseRef returns the base reference recorded in the org.overthink.base label,
+// or "" if an internal parent cannot be resolved.
+func (g *Generator) labelBaseRef(img *ResolvedImage) string {
+	if img.IsExternalBase {
+		return img.Base
+	}
+	if parent, ok := g.Images[img.Base]; ok {
+		return parent.FullTag
+	}
+	return ""
+}
+
+// writeOCILabels emits the layer content hashes, the OCI version (the tag) and
+// the inputs digest. They change with the layers and the tag, so they are
+// written at the end of the Containerfile to keep the layer steps above them
+// cacheable.
+func (g *Generator) writeOCILabels(b *strings.Builder, img *ResolvedImage, layerOrder []string) {
+	b.WriteString("# OCI annotations\n")
+	for _, layerName := range layerOrder {
+		if hash, err := g.Layers[layerName].Hash(); err == nil {
+			b.WriteString(fmt.Sprintf("LABEL %s%s=%q\n", LabelLayerHashPrefix, layerStageName(layerName), hash))
+		}
+	}
+	b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelOCIVersion, img.Tag))
+	b.WriteString(fmt.Sprintf("LABEL %s=%q\n", LabelInputsDigest, inputsDigestPlaceholder))
 	b.WriteString("\n")
 }
 
+// buildArgs returns the ov build flags for the labels of the build itself, the
+// created and revision labels. They stay out of the generated files so a
+// regeneration leaves them unchanged; build.sh takes them when it runs.
+func (g *Generator) buildArgs() []string {
+	var args []string
+	if !g.Created.IsZero() {
+		args = append(args, "--label", LabelOCICreated+"="+g.Created.UTC().Format(time.RFC3339))
+	}
+	if g.Revision != "" {
+		args = append(args, "--label", LabelOCIRevision+"="+g.Revision)
+	}
+	return args
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestResolveBaseImage_InternalUseCalVer(t *testing.T) {
@@ -128,8 +132,8 @@ func TestGenerateSupervisordFragments(t *testing.T) {
 		t.Fatalf("generateSupervisordFragments() error = %v", err)
 	}
 
-	// svc fragment should be at position 02 (index 1 + 1)
-	data, err := os.ReadFile(tmpDir + "/test-image/fragments/02-svc.conf")
+	// svc fragment uses the default priority
+	data, err := os.ReadFile(tmpDir + "/test-image/fragments/50-svc.conf")
 	if err != nil {
 		t.Fatalf("reading svc fragment: %v", err)
 	}
@@ -140,8 +144,8 @@ func TestGenerateSupervisordFragments(t *testing.T) {
 		t.Error("fragment should end with newline")
 	}
 
-	// other fragment should be at position 03
-	data, err = os.ReadFile(tmpDir + "/test-image/fragments/03-other.conf")
+	// other fragment also uses the default priority
+	data, err = os.ReadFile(tmpDir + "/test-image/fragments/50-other.conf")
 	if err != nil {
 		t.Fatalf("reading other fragment: %v", err)
 	}
@@ -153,8 +157,1657 @@ func TestGenerateSupervisordFragments(t *testing.T) {
 	}
 
 	// python has no supervisord, should not have a fragment
-	_, err = os.ReadFile(tmpDir + "/test-image/fragments/01-python.conf")
-	if err == nil {
-		t.Error("python should not have a supervisord fragment")
+	entries, err := os.ReadDir(tmpDir + "/test-image/fragments")
+	if err != nil {
+		t.Fatalf("reading fragments dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected 2 fragments, got %d", len(entries))
+	}
+}
+
+func TestSupervisordFragmentsStable(t *testing.T) {
+	newLayers := func() map[string]*Layer {
+		return map[string]*Layer{
+			"supervisord": {Name: "supervisord", HasRootYml: true},
+			"api":         {Name: "api", HasUserYml: true, HasSupervisord: true, serviceConf: "[program:api]\ncommand=api\n", Depends: []string{"supervisord"}},
+			"worker":      {Name: "worker", HasUserYml: true, HasSupervisord: true, serviceConf: "[program:worker]\ncommand=worker\n", priority: 90, Depends: []string{"supervisord"}},
+			"cache":       {Name: "cache", HasUserYml: true, HasSupervisord: true, serviceConf: "[program:cache]\ncommand=cache\n", priority: 10, Depends: []string{"supervisord"}},
+		}
+	}
+
+	generate := func(imageLayers []string) string {
+		t.Helper()
+		g := &Generator{
+			BuildDir: t.TempDir(),
+			Config: &Config{
+				Images: map[string]ImageConfig{
+					"app": {Base: "quay.io/fedora/fedora:43", Layers: imageLayers},
+				},
+			},
+			Layers:         newLayers(),
+			Tag:            "test",
+			Containerfiles: make(map[string]string),
+		}
+		var err error
+		g.Images, err = g.Config.ResolveAllImages("test")
+		if err != nil {
+			t.Fatalf("ResolveAllImages() error = %v", err)
+		}
+		if err := g.generateContainerfile("app"); err != nil {
+			t.Fatalf("generateContainerfile() error = %v", err)
+		}
+		return g.Containerfiles["app"]
+	}
+
+	before := generate([]string{"api", "worker"})
+	after := generate([]string{"cache", "api", "worker"})
+
+	for _, frag := range []string{"50-api.conf", "90-worker.conf"} {
+		line := "COPY .build/app/fragments/" + frag + " /fragments/" + frag
+		if !strings.Contains(before, line) {
+			t.Errorf("first generation missing %q", line)
+		}
+		if !strings.Contains(after, line) {
+			t.Errorf("inserting a layer renamed fragment %s", frag)
+		}
+	}
+	if !strings.Contains(after, "COPY .build/app/fragments/10-cache.conf /fragments/10-cache.conf") {
+		t.Error("priority 10 layer should get fragment 10-cache.conf")
+	}
+	if strings.Index(after, "10-cache.conf") > strings.Index(after, "50-api.conf") {
+		t.Error("fragments should be copied in priority order")
+	}
+}
+
+func TestWriteBootstrapApk(t *testing.T) {
+	g := &Generator{}
+	img := &ResolvedImage{
+		Pkg:  "apk",
+		User: "user",
+		UID:  1000,
+		GID:  1000,
+		Home: "/home/user",
+	}
+
+	var b strings.Builder
+	g.writeBootstrap(&b, img)
+	out := b.String()
+
+	if !strings.Contains(out, "--mount=type=cache,dst=/var/cache/apk,sharing=locked") {
+		t.Error("apk bootstrap should use /var/cache/apk cache mount")
+	}
+	if !strings.Contains(out, "ln -sfn /var/cache/apk /etc/apk/cache && \\\n    apk add --update-cache curl ca-certificates bash") {
+		t.Error("apk bootstrap should install curl, ca-certificates and bash through the cache")
+	}
+	if strings.Contains(out, "--no-cache") {
+		t.Error("apk bootstrap should not bypass its cache mount with --no-cache")
+	}
+	if !strings.Contains(out, "addgroup -g 1000 user") {
+		t.Error("apk bootstrap should create group with addgroup")
+	}
+	if !strings.Contains(out, "adduser -D -u 1000") {
+		t.Error("apk bootstrap should create user with adduser")
+	}
+	if strings.Contains(out, "useradd") || strings.Contains(out, "groupadd") {
+		t.Error("apk bootstrap should not use useradd/groupadd")
+	}
+	if strings.Contains(out, "libdnf5") || strings.Contains(out, "apt-get") {
+		t.Error("apk bootstrap should not reference dnf or apt")
+	}
+}
+
+func TestWriteBootstrapTaskVersion(t *testing.T) {
+	g := &Generator{}
+	bootstrap := func(version string, sums map[string]string) string {
+		img := &ResolvedImage{Pkg: "rpm", User: "user", UID: 1000, GID: 1000, Home: "/home/user", TaskVersion: version, TaskSHA256: sums}
+		var b strings.Builder
+		g.writeBootstrap(&b, img)
+		return b.String()
+	}
+
+	// Default is the pinned release baked into ov
+	out := bootstrap(DefaultTaskVersion, nil)
+	pinned := `curl -fsSL "https://github.com/go-task/task/releases/download/` + DefaultTaskVersion + `/task_linux_${ARCH}.tar.gz" | tar -xzf - -C /usr/local/bin task`
+	if !strings.Contains(out, pinned) {
+		t.Errorf("expected pinned task URL, got:\n%s", out)
+	}
+	if strings.Contains(out, "latest") {
+		t.Errorf("latest should only be used when configured:\n%s", out)
+	}
+
+	// A version without the v prefix is normalized
+	if out := bootstrap("3.40.0", nil); !strings.Contains(out, "/releases/download/v3.40.0/task_linux_${ARCH}.tar.gz") {
+		t.Errorf("expected v3.40.0 URL, got:\n%s", out)
+	}
+
+	if out := bootstrap("latest", nil); !strings.Contains(out, "/releases/latest/download/task_linux_${ARCH}.tar.gz") {
+		t.Errorf("expected latest URL, got:\n%s", out)
+	}
+
+	// Checksums download to a file and verify before extracting
+	amd, arm := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	out = bootstrap("v3.40.0", map[string]string{"arm64": arm, "amd64": amd})
+	for _, want := range []string{
+		`curl -fsSLo /tmp/task.tar.gz "https://github.com/go-task/task/releases/download/v3.40.0/task_linux_${ARCH}.tar.gz" && \`,
+		`case "$ARCH" in amd64) SUM=` + amd + `;; arm64) SUM=` + arm + `;; *) SUM=missing;; esac && \`,
+		`echo "$SUM  /tmp/task.tar.gz" | sha256sum -c - && \`,
+		"tar -xzf /tmp/task.tar.gz -C /usr/local/bin task && rm -f /tmp/task.tar.gz\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+}
+
+func TestWriteLayerStepsApk(t *testing.T) {
+	g := &Generator{
+		Layers: map[string]*Layer{
+			"tools": {
+				Name:       "tools",
+				HasRootYml: true,
+				rpmConfig:  &RpmConfig{Packages: []string{"git-core"}},
+				apkConfig:  &ApkConfig{Packages: []string{"git", "jq"}},
+			},
+		},
+	}
+	img := &ResolvedImage{Pkg: "apk", UID: 1000, GID: 1000, Home: "/home/user"}
+
+	var b strings.Builder
+	g.writeLayerSteps(&b, "tools", img, false)
+	out := b.String()
+
+	if !strings.Contains(out, "apk add --update-cache \\\n      git \\\n      jq\n") {
+		t.Errorf("expected apk add step, got:\n%s", out)
+	}
+	if strings.Contains(out, "dnf install") || strings.Contains(out, "git-core") {
+		t.Error("apk image should not emit rpm packages")
+	}
+	if strings.Count(out, "--mount=type=cache,dst=/var/cache/apk,sharing=locked") != 2 {
+		t.Errorf("expected apk cache mount on package and root.yml steps, got:\n%s", out)
+	}
+}
+
+func TestWriteAptInstallRepos(t *testing.T) {
+	g := &Generator{}
+	deb := &DebConfig{
+		Packages: []string{"python3.12", "example-tool"},
+		Repos: []string{
+			"ppa:deadsnakes/ppa",
+			"deb [signed-by=/etc/apt/keyrings/example.asc] https://apt.example.com/ stable main",
+			"deb [signed-by=/etc/apt/keyrings/example.asc] https://apt.example.com/ stable extra",
+		},
+		Keys: []DebKey{{Name: "example", URL: "https://apt.example.com/key.asc"}},
+	}
+
+	var b strings.Builder
+	g.writeAptInstall(&b, "apt-extras", deb)
+	out := b.String()
+
+	want := "RUN --mount=type=cache,dst=/var/cache/apt,sharing=locked \\\n" +
+		"    --mount=type=cache,dst=/var/lib/apt,sharing=locked \\\n" +
+		"    install -d -m 0755 /etc/apt/keyrings && \\\n" +
+		"    curl -fsSL \"https://apt.example.com/key.asc\" -o /etc/apt/keyrings/example.asc && \\\n" +
+		"    echo 'deb [signed-by=/etc/apt/keyrings/example.asc] https://apt.example.com/ stable main' > /etc/apt/sources.list.d/apt-extras.list && \\\n" +
+		"    echo 'deb [signed-by=/etc/apt/keyrings/example.asc] https://apt.example.com/ stable extra' >> /etc/apt/sources.list.d/apt-extras.list && \\\n" +
+		"    apt-get update && apt-get install -y --no-install-recommends software-properties-common && \\\n" +
+		"    add-apt-repository -y -n ppa:deadsnakes/ppa && \\\n" +
+		"    apt-get update && apt-get install -y --no-install-recommends \\\n      python3.12 \\\n      example-tool\n"
+	if out != want {
+		t.Errorf("writeAptInstall() =\n%s\nwant:\n%s", out, want)
+	}
+
+	// Plain packages keep the single install line
+	b.Reset()
+	g.writeAptInstall(&b, "plain", &DebConfig{Packages: []string{"jq"}})
+	if strings.Contains(b.String(), "add-apt-repository") || strings.Contains(b.String(), "sources.list.d") {
+		t.Errorf("unexpected repo setup:\n%s", b.String())
+	}
+}
+
+func TestWritePackagePins(t *testing.T) {
+	g := &Generator{}
+
+	var b strings.Builder
+	g.writeDnfInstall(&b, &RpmConfig{
+		Packages: []string{"nodejs=20.11.*", "git-core", "!nodejs-docs"},
+		Exclude:  []string{"kernel-debug*"},
+	})
+	want := "RUN --mount=type=cache,dst=/var/cache/libdnf5,sharing=locked \\\n" +
+		"    dnf install -y --exclude='kernel-debug*' --exclude='nodejs-docs' \\\n" +
+		"      'nodejs-20.11.*' \\\n" +
+		"      git-core\n"
+	if b.String() != want {
+		t.Errorf("writeDnfInstall() =\n%s\nwant:\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	g.writeAptInstall(&b, "pinned", &DebConfig{Packages: []string{"nodejs=20.11.1-1nodesource1", "git", "!nodejs-doc"}})
+	want = "RUN --mount=type=cache,dst=/var/cache/apt,sharing=locked \\\n" +
+		"    --mount=type=cache,dst=/var/lib/apt,sharing=locked \\\n" +
+		"    printf 'Package: nodejs-doc\\nPin: release *\\nPin-Priority: -1\\n' > /etc/apt/preferences.d/pinned-exclude && \\\n" +
+		"    apt-get update && apt-get install -y --no-install-recommends \\\n" +
+		"      nodejs=20.11.1-1nodesource1 \\\n" +
+		"      git\n"
+	if b.String() != want {
+		t.Errorf("writeAptInstall() =\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestGenerateContainerfilePlatformLayer(t *testing.T) {
+	layers := map[string]*Layer{
+		"common": {Name: "common", HasRootYml: true},
+		"cuda": {
+			Name:       "cuda",
+			HasRootYml: true,
+			Depends:    []string{"common"},
+			rpmConfig:  &RpmConfig{Packages: []string{"cuda-toolkit"}},
+			platforms:  []string{"linux/amd64"},
+		},
+	}
+	img := &ResolvedImage{
+		Name:           "app",
+		Base:           "quay.io/fedora/fedora:43",
+		IsExternalBase: true,
+		Platforms:      []string{"linux/amd64", "linux/arm64"},
+		Pkg:            "rpm",
+		Layers:         []string{"cuda"},
+		User:           "user",
+		UID:            1000,
+		GID:            1000,
+		Home:           "/home/user",
+		FullTag:        "app:test",
+	}
+	g := &Generator{
+		Config:         &Config{Images: map[string]ImageConfig{"app": {Layers: img.Layers}}},
+		BuildDir:       t.TempDir(),
+		Layers:         layers,
+		Images:         map[string]*ResolvedImage{"app": img},
+		Containerfiles: make(map[string]string),
+	}
+
+	// The restricted layer stays in the order on every platform
+	order, err := ResolveLayerOrder(img.Layers, layers, nil)
+	if err != nil {
+		t.Fatalf("ResolveLayerOrder() error = %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"common", "cuda"}) {
+		t.Errorf("ResolveLayerOrder() = %v, want [common cuda]", order)
+	}
+	seq := AbsoluteLayerSequence("app", g.Images, layers, []string{"common", "cuda"})
+	if !reflect.DeepEqual(seq, []string{"common", "cuda"}) {
+		t.Errorf("AbsoluteLayerSequence() = %v, want [common cuda]", seq)
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+
+	guard := `case "$TARGETARCH" in amd64) ;; *) exit 0 ;; esac && `
+	if !strings.Contains(content, "FROM ${BASE_IMAGE}\n\nARG TARGETARCH\n") {
+		t.Errorf("expected ARG TARGETARCH after FROM:\n%s", content)
+	}
+	common := content[strings.Index(content, "# Layer: common"):strings.Index(content, "# Layer: cuda")]
+	if strings.Contains(common, "exit 0") {
+		t.Errorf("universal layer should not be guarded:\n%s", common)
+	}
+	cuda := content[strings.Index(content, "# Layer: cuda"):]
+	if strings.Count(cuda, guard) != 2 {
+		t.Errorf("expected guarded dnf and root.yml steps:\n%s", cuda)
+	}
+	if !strings.Contains(cuda, "    "+guard+"dnf install -y \\\n      cuda-toolkit\n") {
+		t.Errorf("guard should follow the cache mounts:\n%s", cuda)
+	}
+
+	// The guard runs the step on amd64 and skips it on arm64
+	for arch, want := range map[string]string{"amd64": "installed\n", "arm64": ""} {
+		cmd := exec.Command("sh", "-c", guard+"echo installed")
+		cmd.Env = append(os.Environ(), "TARGETARCH="+arch)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("guard on %s: %v", arch, err)
+		}
+		if string(out) != want {
+			t.Errorf("guard on %s printed %q, want %q", arch, out, want)
+		}
+	}
+}
+
+func TestWriteLayerStepsFiles(t *testing.T) {
+	g := &Generator{
+		Layers: map[string]*Layer{
+			"dotfiles": {
+				Name:     "dotfiles",
+				HasFiles: true,
+			},
+			"config": {
+				Name:       "config",
+				HasFiles:   true,
+				HasRootYml: true,
+				filesOwner: "user",
+			},
+		},
+	}
+	img := &ResolvedImage{Pkg: "rpm", UID: 1500, GID: 1600, Home: "/home/dev"}
+
+	var b strings.Builder
+	g.writeLayerSteps(&b, "dotfiles", img, false)
+	out := b.String()
+	if !strings.Contains(out, "COPY layers/dotfiles/files/ /\n") {
+		t.Errorf("expected root-owned files COPY, got:\n%s", out)
+	}
+	if strings.Contains(out, "RUN") {
+		t.Errorf("files-only layer should not emit RUN steps, got:\n%s", out)
+	}
+
+	b.Reset()
+	g.writeLayerSteps(&b, "config", img, false)
+	out = b.String()
+	copyIdx := strings.Index(out, "COPY --chown=1500:1600 layers/config/files/ /\n")
+	if copyIdx < 0 {
+		t.Fatalf("expected user-owned files COPY, got:\n%s", out)
+	}
+	rootIdx := strings.Index(out, "task -t root.yml install")
+	if rootIdx < 0 || copyIdx > rootIdx {
+		t.Errorf("files/ should be copied before root.yml runs, got:\n%s", out)
+	}
+}
+
+func TestGenerateContainerfileCustomUser(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"builder": {},
+			"devbox":  {Layers: []string{"python", "webapp", "tool", "dotfiles"}},
+		},
+	}
+	g := &Generator{
+		Config:   cfg,
+		BuildDir: tmpDir,
+		Layers: map[string]*Layer{
+			"python": {
+				Name:        "python",
+				HasPixiToml: true,
+				HasEnv:      true,
+				envConfig: &EnvConfig{
+					Vars:       map[string]string{"PIXI_CACHE_DIR": "~/.cache/pixi"},
+					PathAppend: []string{"~/.pixi/envs/default/bin"},
+				},
+			},
+			"webapp": {
+				Name:           "webapp",
+				HasPackageJson: true,
+				HasEnv:         true,
+				envConfig: &EnvConfig{
+					Vars:       map[string]string{"NPM_CONFIG_PREFIX": "~/.npm-global"},
+					PathAppend: []string{"~/.npm-global/bin"},
+				},
+			},
+			"tool": {
+				Name:         "tool",
+				HasCargoToml: true,
+				HasSrcDir:    true,
+				HasUserYml:   true,
+			},
+			"dotfiles": {
+				Name:       "dotfiles",
+				HasFiles:   true,
+				filesOwner: "user",
+			},
+		},
+		Images: map[string]*ResolvedImage{
+			"builder": {
+				Name:           "builder",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				FullTag:        "builder:test",
+			},
+			"devbox": {
+				Name:           "devbox",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"python", "webapp", "tool", "dotfiles"},
+				User:           "dev",
+				UID:            1500,
+				GID:            1600,
+				Home:           "/home/dev",
+				Builder:        "builder",
+				FullTag:        "devbox:test",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("devbox"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["devbox"]
+
+	if strings.Contains(content, "1000") {
+		t.Errorf("Containerfile should not contain default UID/GID 1000:\n%s", content)
+	}
+	if strings.Contains(content, "/home/user") {
+		t.Errorf("Containerfile should not contain default home /home/user:\n%s", content)
+	}
+
+	for _, want := range []string{
+		"useradd -m -u 1500 -g 1600 -s /bin/bash dev",
+		"WORKDIR /home/dev\n",
+		"USER 1500:1600\nWORKDIR /home/dev\n",
+		"--mount=type=cache,dst=/home/dev/.cache/pixi,uid=1500,gid=1600",
+		"COPY --from=python-pixi-build --chown=1500:1600 /home/dev/.pixi/envs/default /home/dev/.pixi/envs/default",
+		"COPY --from=webapp-npm-build --chown=1500:1600 /npm-global /home/dev/.npm-global",
+		"--mount=type=cache,dst=/home/dev/.cargo/registry,uid=1500,gid=1600",
+		"--mount=type=cache,dst=/home/dev/.cache/npm,uid=1500,gid=1600",
+		"COPY --chown=1500:1600 layers/dotfiles/files/ /",
+		`ENV NPM_CONFIG_PREFIX="/home/dev/.npm-global"`,
+		`ENV PATH="/home/dev/.pixi/envs/default/bin:/home/dev/.npm-global/bin:${PATH}"`,
+		"USER 1500\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("Containerfile missing %q:\n%s", want, content)
+		}
+	}
+}
+
+func TestGenerateContainerfileBuilderStages(t *testing.T) {
+	newGen := func() *Generator {
+		return &Generator{
+			Config: &Config{
+				Images: map[string]ImageConfig{
+					"builder": {},
+					"ml":      {Layers: []string{"python"}},
+					"tools":   {Layers: []string{"tool"}},
+					"plain":   {Layers: []string{"system"}},
+				},
+			},
+			BuildDir: t.TempDir(),
+			Layers: map[string]*Layer{
+				"python": {Name: "python", HasPixiToml: true},
+				"tool":   {Name: "tool", HasCargoToml: true, HasSrcDir: true},
+				"system": {Name: "system", rpmConfig: &RpmConfig{Packages: []string{"git"}}},
+			},
+			Images:         map[string]*ResolvedImage{},
+			Containerfiles: make(map[string]string),
+		}
+	}
+	addImage := func(g *Generator, name string, layers []string) {
+		g.Images[name] = &ResolvedImage{
+			Name:           name,
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Pkg:            "rpm",
+			Layers:         layers,
+			User:           "user",
+			UID:            1000,
+			GID:            1000,
+			Home:           "/home/user",
+			Builder:        "builder",
+			FullTag:        name + ":test",
+		}
+	}
+
+	g := newGen()
+	addImage(g, "builder", nil)
+	addImage(g, "ml", []string{"python"})
+	addImage(g, "tools", []string{"tool"})
+	addImage(g, "plain", []string{"system"})
+
+	for _, name := range []string{"ml", "tools", "plain"} {
+		if err := g.generateContainerfile(name); err != nil {
+			t.Fatalf("generateContainerfile(%s) error = %v", name, err)
+		}
+	}
+
+	ml := g.Containerfiles["ml"]
+	if !strings.Contains(ml, "ARG BUILDER_IMAGE=builder:test\n") || strings.Count(ml, "FROM ${BUILDER_IMAGE} AS ") != 1 {
+		t.Errorf("pixi image should have one builder stage:\n%s", ml)
+	}
+	if !strings.Contains(ml, "COPY --from=python-pixi-build") {
+		t.Errorf("pixi image should copy from its build stage:\n%s", ml)
+	}
+
+	tools := g.Containerfiles["tools"]
+	if !strings.Contains(tools, "FROM ${BUILDER_IMAGE} AS tool-cargo-build\n") {
+		t.Errorf("cargo image should have a cargo build stage:\n%s", tools)
+	}
+	if !strings.Contains(tools, "COPY --from=tool-cargo-build --chown=1000:1000 /home/user/.cargo/bin/ /home/user/.cargo/bin/") {
+		t.Errorf("cargo image should copy binaries from its build stage:\n%s", tools)
+	}
+	finalStage := tools[strings.Index(tools, "FROM ${BASE_IMAGE}"):]
+	if strings.Contains(finalStage, "cargo install") {
+		t.Errorf("cargo install should not run in the final stage:\n%s", finalStage)
+	}
+
+	plain := g.Containerfiles["plain"]
+	if strings.Contains(plain, "FROM builder:test") {
+		t.Errorf("rpm-only image should not use the builder:\n%s", plain)
+	}
+	if strings.Count(plain, "FROM ") != 2 {
+		t.Errorf("rpm-only image should have only its layer scratch stage and the final stage:\n%s", plain)
+	}
+}
+
+func TestGenerateContainerfileCargoWithoutBuilder(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"tools": {Layers: []string{"tool"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasCargoToml: true, HasSrcDir: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"tools": {
+				Name:           "tools",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Layers:         []string{"tool"},
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	err := g.generateContainerfile("tools")
+	if err == nil || !strings.Contains(err.Error(), "has Cargo.toml but no builder configured") {
+		t.Errorf("expected missing builder error, got %v", err)
+	}
+}
+
+func TestGenerateContainerfileTargetArch(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"tool"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasRootYml: true, HasUserYml: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"app": {
+				Name:           "app",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"tool"},
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				FullTag:        "app:test",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+
+	for _, want := range []string{
+		"FROM ${BASE_IMAGE}\n\nARG TARGETARCH\n\n# Bootstrap\n",
+		"ARCH=${TARGETARCH:-$(uname -m)}",
+		"cd /ctx && OV_ARCH=${TARGETARCH} task -t root.yml install\n",
+		"cd /ctx && OV_ARCH=${TARGETARCH} task -t user.yml install\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+}
+
+func TestGenerateContainerfileSecrets(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"tool"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasRootYml: true, HasUserYml: true, secrets: []string{"repo-token"}},
+		},
+		Images: map[string]*ResolvedImage{
+			"app": {
+				Name:           "app",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"tool"},
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				FullTag:        "app:test",
+				Secrets:        []SecretConfig{{ID: "repo-token", Env: "REPO_TOKEN"}},
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+
+	for _, want := range []string{
+		"    --mount=type=secret,id=repo-token,target=/run/secrets/repo-token \\\n    cd /ctx && OV_ARCH=${TARGETARCH} task -t root.yml install\n",
+		"    --mount=type=secret,id=repo-token,target=/run/secrets/repo-token,uid=1000,gid=1000 \\\n    cd /ctx && OV_ARCH=${TARGETARCH} task -t user.yml install\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+
+	cmd := &BuildCmd{}
+	args := withSecretArgs(cmd.buildLocalArgs("docker", []string{"app:test"}, "linux/amd64", "app", ""), g.Images["app"].Secrets, "/home/me")
+	want := []string{
+		"docker", "build", "-f", "-",
+		"-t", "app:test",
+		"--platform", "linux/amd64",
+		"--secret", "id=repo-token,env=REPO_TOKEN",
+		".",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("build args =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestGenerateContainerfileContextIgnore(t *testing.T) {
+	layers := make(map[string]*Layer)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		layers[name] = &Layer{Name: name, HasRootYml: true}
+	}
+	var err error
+	if layers["b"].ignore, err = parseIgnoreRules(".ovignore", "node_modules/\n"); err != nil {
+		t.Fatal(err)
+	}
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"b", "d"}}}},
+		BuildDir: t.TempDir(),
+		Layers:   layers,
+		Images: map[string]*ResolvedImage{
+			"app": {
+				Name:           "app",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"b", "d"},
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				FullTag:        "app:test",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(g.BuildDir, "app", "Containerfile.dockerignore"))
+	if err != nil {
+		t.Fatalf("reading Containerfile.dockerignore: %v", err)
+	}
+	ignore := string(data)
+
+	for _, want := range []string{"\n*\n", "!layers/b\n", "!layers/d\n", "!templates\n", "!.build/app\n", "!layers/b\n!layers/d\n!templates\n!.build/app\nlayers/b/**/node_modules\n"} {
+		if !strings.Contains(ignore, want) {
+			t.Errorf("missing %q in:\n%s", want, ignore)
+		}
+	}
+	for _, unwanted := range []string{"layers/a", "layers/c", "layers/e"} {
+		if strings.Contains(ignore, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, ignore)
+		}
+	}
+
+	// Docker builds get the same rules next to their temporary Containerfile
+	if g.ContextIgnores["app"] != ignore {
+		t.Errorf("ContextIgnores[app] = %q, want %q", g.ContextIgnores["app"], ignore)
+	}
+	path, err := writeBuildFiles(t.TempDir(), g.Containerfiles["app"], g.ContextIgnores["app"])
+	if err != nil {
+		t.Fatalf("writeBuildFiles() error = %v", err)
+	}
+	data, err = os.ReadFile(path + ".dockerignore")
+	if err != nil {
+		t.Fatalf("reading docker ignore file: %v", err)
+	}
+	if !strings.Contains(string(data), "layers/b/**/node_modules\n") {
+		t.Errorf("docker ignore file misses the .ovignore pattern:\n%s", data)
+	}
+}
+
+func TestGenerateContainerfileTagOverride(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/overthinkos", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool"}},
+			"app":    {Base: "fedora", Layers: []string{"tool"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages("foo")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	g := &Generator{
+		Config:         cfg,
+		BuildDir:       t.TempDir(),
+		Layers:         map[string]*Layer{"tool": {Name: "tool", HasRootYml: true}},
+		Images:         images,
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if want := "ARG BASE_IMAGE=ghcr.io/overthinkos/fedora:foo\n"; !strings.Contains(g.Containerfiles["app"], want) {
+		t.Errorf("missing %q in:\n%s", want, g.Containerfiles["app"])
+	}
+	if images["app"].FullTag != "ghcr.io/overthinkos/app:foo" {
+		t.Errorf("FullTag = %q, want ghcr.io/overthinkos/app:foo", images["app"].FullTag)
+	}
+}
+
+func TestGenerateContainerfileCacheID(t *testing.T) {
+	generate := func(cacheID string) string {
+		t.Helper()
+		g := &Generator{
+			Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"tool"}}}},
+			BuildDir: t.TempDir(),
+			Layers: map[string]*Layer{
+				"tool": {Name: "tool", HasRootYml: true, HasUserYml: true, rpmConfig: &RpmConfig{Packages: []string{"jq"}}},
+			},
+			Images: map[string]*ResolvedImage{
+				"app": {
+					Name:           "app",
+					Base:           "quay.io/fedora/fedora:43",
+					IsExternalBase: true,
+					Pkg:            "rpm",
+					Layers:         []string{"tool"},
+					User:           "user",
+					UID:            1000,
+					GID:            1000,
+					Home:           "/home/user",
+					FullTag:        "app:test",
+				},
+			},
+			Containerfiles: make(map[string]string),
+			CacheID:        cacheID,
+		}
+		if err := g.generateContainerfile("app"); err != nil {
+			t.Fatalf("generateContainerfile() error = %v", err)
+		}
+		return g.Containerfiles["app"]
+	}
+
+	// Without a namespace, mounts stay keyed by their destination
+	content := generate("")
+	if strings.Contains(content, ",id=") {
+		t.Errorf("unexpected cache id without cache_id:\n%s", content)
+	}
+	if strings.Count(content, "--mount=type=cache,dst=/var/cache/libdnf5,sharing=locked") != 3 {
+		t.Errorf("expected bare libdnf5 mounts in bootstrap, dnf and root.yml steps:\n%s", content)
+	}
+
+	content = generate("myproj")
+	if n := strings.Count(content, "--mount=type=cache,"); n != strings.Count(content, "--mount=type=cache,id=myproj-") || n != 4 {
+		t.Errorf("every cache mount should be namespaced (got %d mounts):\n%s", n, content)
+	}
+	for _, want := range []string{
+		"--mount=type=cache,id=myproj-var-cache-libdnf5,dst=/var/cache/libdnf5,sharing=locked",
+		"--mount=type=cache,id=myproj-home-user-.cache-npm,dst=/home/user/.cache/npm,uid=1000,gid=1000",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+}
+
+func TestGeneratorBuildArgs(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"svc"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"svc": {Name: "svc", HasRootYml: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"app": {
+				Name:           "app",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"svc"},
+				Tag:            "2026.289.1430",
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				FullTag:        "app:2026.289.1430",
+			},
+		},
+		Containerfiles: make(map[string]string),
+		Created:        time.Date(2026, 10, 16, 14, 30, 0, 0, time.UTC),
+	}
+
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+
+	// The base and version are in the Containerfile, so a standalone build has them
+	baseLabel := `LABEL org.overthink.base="quay.io/fedora/fedora:43"`
+	versionLabel := `LABEL org.opencontainers.image.version="2026.289.1430"`
+	for _, want := range []string{baseLabel, versionLabel} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %s in:\n%s", want, content)
+		}
+	}
+	// The version comes after the layer steps so a new tag doesn't bust their cache
+	if strings.Index(content, versionLabel) < strings.Index(content, "layers/svc/root.yml") {
+		t.Errorf("version label should follow layer steps:\n%s", content)
+	}
+	// Per-build labels stay out of the Containerfile so regenerations leave it unchanged
+	for _, unwanted := range []string{LabelOCICreated, LabelOCIRevision, "2026-10-16"} {
+		if strings.Contains(content, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, content)
+		}
+	}
+
+	want := []string{"--label", "org.opencontainers.image.created=2026-10-16T14:30:00Z"}
+	if got := g.buildArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildArgs() = %q, want %q", got, want)
+	}
+
+	// tag_suffix: git records the commit; zero Created omits the created label
+	g.Created = time.Time{}
+	g.Revision = "0123456789abcdef0123456789abcdef01234567"
+	want = []string{"--label", "org.opencontainers.image.revision=0123456789abcdef0123456789abcdef01234567"}
+	if got := g.buildArgs(); !reflect.DeepEqual(got, want) {
+		t.Errorf("buildArgs() = %q, want %q", got, want)
+	}
+}
+
+func TestGenerateContainerfileCommand(t *testing.T) {
+	newGen := func(app *ResolvedImage) *Generator {
+		return &Generator{
+			Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: app.Layers}}},
+			BuildDir: t.TempDir(),
+			Layers: map[string]*Layer{
+				"svc":         {Name: "svc", HasRootYml: true},
+				"supervisord": {Name: "supervisord", HasRootYml: true},
+				"api":         {Name: "api", HasRootYml: true, HasSupervisord: true, Depends: []string{"supervisord"}},
+			},
+			Images:         map[string]*ResolvedImage{"app": app},
+			Containerfiles: make(map[string]string),
+		}
+	}
+	newImg := func(layers ...string) *ResolvedImage {
+		return &ResolvedImage{
+			Name:           "app",
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Pkg:            "rpm",
+			Layers:         layers,
+			User:           "user",
+			UID:            1000,
+			GID:            1000,
+			Home:           "/home/user",
+			FullTag:        "app:test",
+		}
+	}
+
+	// Explicit entrypoint and cmd, emitted in exec form after the final USER
+	img := newImg("svc")
+	img.Entrypoint = Command{"/usr/bin/tini", "--"}
+	img.Cmd = Command{"my-server", "--port", "8080"}
+	g := newGen(img)
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+	want := "USER 1000\nENTRYPOINT [\"/usr/bin/tini\",\"--\"]\nCMD [\"my-server\",\"--port\",\"8080\"]\n"
+	if !strings.HasSuffix(content, want) {
+		t.Errorf("Containerfile should end with:\n%s\ngot:\n%s", want, content)
+	}
+
+	// Service image without cmd defaults to supervisord
+	g = newGen(newImg("api"))
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if !strings.Contains(g.Containerfiles["app"], `CMD ["supervisord","-n","-c","/etc/supervisord.conf"]`) {
+		t.Errorf("expected default supervisord CMD:\n%s", g.Containerfiles["app"])
+	}
+
+	// Plain image without cmd emits neither
+	g = newGen(newImg("svc"))
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if strings.Contains(g.Containerfiles["app"], "CMD") || strings.Contains(g.Containerfiles["app"], "ENTRYPOINT") {
+		t.Errorf("unexpected ENTRYPOINT/CMD:\n%s", g.Containerfiles["app"])
+	}
+
+	// Auto-intermediates never get a command
+	img = newImg("api")
+	img.Auto = true
+	g = newGen(img)
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if strings.Contains(g.Containerfiles["app"], "CMD") {
+		t.Errorf("auto-intermediate should not get CMD:\n%s", g.Containerfiles["app"])
+	}
+}
+
+func TestGenerateContainerfileSnippets(t *testing.T) {
+	img := &ResolvedImage{
+		Name:              "app",
+		Base:              "quay.io/fedora/fedora:43",
+		IsExternalBase:    true,
+		Pkg:               "rpm",
+		Layers:            []string{"tool"},
+		User:              "user",
+		UID:               1000,
+		GID:               1000,
+		Home:              "/home/user",
+		FullTag:           "app:test",
+		ContainerfilePre:  "RUN rpm --import https://example.com/key.asc",
+		ContainerfilePost: "RUN authselect select sssd --force\nRUN echo done\n",
+	}
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: img.Layers}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasUserYml: true},
+		},
+		Images:         map[string]*ResolvedImage{"app": img},
+		Containerfiles: make(map[string]string),
+	}
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+
+	pre := "# --- begin containerfile_pre (images.yml) ---\nRUN rpm --import https://example.com/key.asc\n# --- end containerfile_pre ---\n"
+	post := "# --- begin containerfile_post (images.yml) ---\nRUN authselect select sssd --force\nRUN echo done\n# --- end containerfile_post ---\n"
+	for _, want := range []string{pre, post} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing snippet block:\n%s\nin:\n%s", want, content)
+		}
+	}
+	if strings.Index(content, "# Bootstrap") > strings.Index(content, pre) {
+		t.Error("containerfile_pre should follow the bootstrap block")
+	}
+	if strings.Index(content, pre) > strings.Index(content, "# Layer: tool") {
+		t.Error("containerfile_pre should precede the layer steps")
+	}
+	// user.yml leaves the build in user mode, post must reset to root first
+	postIdx := strings.Index(content, post)
+	if !strings.Contains(content[strings.Index(content, "# Layer: tool"):postIdx], "USER root") {
+		t.Errorf("containerfile_post should run as root:\n%s", content)
+	}
+	// Only metadata may follow the post block
+	if tail := content[postIdx+len(post):]; strings.Contains(tail, "RUN ") || !strings.HasSuffix(tail, "USER 1000\n") {
+		t.Errorf("containerfile_post should come right before the final USER:\n%s", content)
+	}
+}
+
+func TestGenerateContainerfileCleanup(t *testing.T) {
+	generate := func(pkg string, cleanup, auto bool) string {
+		t.Helper()
+		img := &ResolvedImage{
+			Name:           "app",
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Pkg:            pkg,
+			Cleanup:        cleanup,
+			Auto:           auto,
+			Layers:         []string{"tool"},
+			User:           "user",
+			UID:            1000,
+			GID:            1000,
+			Home:           "/home/user",
+			FullTag:        "app:test",
+		}
+		g := &Generator{
+			Config:         &Config{Images: map[string]ImageConfig{"app": {Layers: img.Layers}}},
+			BuildDir:       t.TempDir(),
+			Layers:         map[string]*Layer{"tool": {Name: "tool", HasUserYml: true}},
+			Images:         map[string]*ResolvedImage{"app": img},
+			Containerfiles: make(map[string]string),
+		}
+		if err := g.generateContainerfile("app"); err != nil {
+			t.Fatalf("generateContainerfile() error = %v", err)
+		}
+		return g.Containerfiles["app"]
+	}
+
+	tests := []struct {
+		pkg       string
+		want      string
+		forbidden string
+	}{
+		{pkg: "rpm", want: "RUN rm -rf /var/cache/dnf /var/cache/libdnf5/* /var/lib/dnf/repos /var/log/dnf5.log* \\\n", forbidden: "apt-get clean"},
+		{pkg: "deb", want: "RUN apt-get clean && \\\n    rm -rf /var/lib/apt/lists/* /var/log/apt /var/log/dpkg.log \\\n", forbidden: "libdnf5/*"},
+		{pkg: "apk", want: "RUN rm -rf /var/cache/apk/* \\\n", forbidden: "apt-get clean"},
+	}
+	for _, tt := range tests {
+		content := generate(tt.pkg, true, false)
+		if !strings.Contains(content, "# Cleanup\n"+tt.want+"    /tmp/* /var/tmp/* /root/.cache /home/user/.cache\n") {
+			t.Errorf("%s: missing cleanup step:\n%s", tt.pkg, content)
+		}
+		if strings.Contains(content, tt.forbidden) {
+			t.Errorf("%s: cleanup should match the package manager:\n%s", tt.pkg, content)
+		}
+		// Runs as root after the user.yml layer, before the final USER
+		cleanupIdx := strings.Index(content, "# Cleanup")
+		if !strings.Contains(content[strings.Index(content, "# Layer: tool"):cleanupIdx], "USER root") {
+			t.Errorf("%s: cleanup should run as root:\n%s", tt.pkg, content)
+		}
+		if cleanupIdx > strings.LastIndex(content, "USER 1000") {
+			t.Errorf("%s: cleanup should precede the final USER:\n%s", tt.pkg, content)
+		}
+	}
+
+	if content := generate("rpm", false, false); strings.Contains(content, "# Cleanup") {
+		t.Errorf("cleanup should be off by default:\n%s", content)
+	}
+	if content := generate("rpm", true, true); strings.Contains(content, "# Cleanup") {
+		t.Errorf("auto-intermediates should skip cleanup:\n%s", content)
+	}
+}
+
+func TestGenerateContainerfileLayerBoundary(t *testing.T) {
+	generate := func(merge *MergeConfig) string {
+		t.Helper()
+		img := &ResolvedImage{
+			Name:           "app",
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Pkg:            "rpm",
+			Layers:         []string{"tool"},
+			User:           "user",
+			UID:            1000,
+			GID:            1000,
+			Home:           "/home/user",
+			FullTag:        "app:test",
+			Merge:          merge,
+		}
+		g := &Generator{
+			Config:         &Config{Images: map[string]ImageConfig{"app": {Layers: img.Layers}}},
+			BuildDir:       t.TempDir(),
+			Layers:         map[string]*Layer{"tool": {Name: "tool", HasUserYml: true}},
+			Images:         map[string]*ResolvedImage{"app": img},
+			Containerfiles: make(map[string]string),
+		}
+		if err := g.generateContainerfile("app"); err != nil {
+			t.Fatalf("generateContainerfile() error = %v", err)
+		}
+		return g.Containerfiles["app"]
+	}
+
+	content := generate(&MergeConfig{Auto: true, PreserveBaseBoundary: true})
+	if !strings.Contains(content, "FROM ${BASE_IMAGE}\n\nARG TARGETARCH\n\nLABEL org.overthink.layer-boundary=app\n") {
+		t.Errorf("missing layer boundary marker right after FROM:\n%s", content)
+	}
+	for _, merge := range []*MergeConfig{nil, {Auto: true}} {
+		if content := generate(merge); strings.Contains(content, LabelLayerBoundary) {
+			t.Errorf("merge %+v: unexpected layer boundary marker:\n%s", merge, content)
+		}
+	}
+}
+
+func TestGenerateContainerfileHealthcheck(t *testing.T) {
+	newGen := func(app *ResolvedImage) *Generator {
+		return &Generator{
+			Config:   &Config{Images: map[string]ImageConfig{"app": {Layers: app.Layers}}},
+			BuildDir: t.TempDir(),
+			Layers: map[string]*Layer{
+				"web": {Name: "web", HasRootYml: true, HasHealthcheck: true, healthcheck: &HealthcheckConfig{
+					Cmd: Command{"curl", "-f", "http://localhost:8080/"}, Interval: "30s", Retries: 3,
+				}},
+				"api": {Name: "api", HasRootYml: true, Depends: []string{"web"}, HasHealthcheck: true, healthcheck: &HealthcheckConfig{
+					Cmd: Command{"/bin/sh", "-c", "api-ping"},
+				}},
+			},
+			Images:         map[string]*ResolvedImage{"app": app},
+			Containerfiles: make(map[string]string),
+		}
+	}
+	newImg := func(layers ...string) *ResolvedImage {
+		return &ResolvedImage{
+			Name:           "app",
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Pkg:            "rpm",
+			Layers:         layers,
+			User:           "user",
+			UID:            1000,
+			GID:            1000,
+			Home:           "/home/user",
+			FullTag:        "app:test",
+		}
+	}
+
+	// Last declaring layer in install order wins (api depends on web)
+	g := newGen(newImg("web", "api"))
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["app"]
+	if !strings.Contains(content, "HEALTHCHECK CMD [\"/bin/sh\",\"-c\",\"api-ping\"]\n") {
+		t.Errorf("expected api healthcheck:\n%s", content)
+	}
+	if strings.Count(content, "HEALTHCHECK") != 1 {
+		t.Errorf("expected exactly one HEALTHCHECK:\n%s", content)
+	}
+
+	// Flags are emitted for set fields only
+	g = newGen(newImg("web"))
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if !strings.Contains(g.Containerfiles["app"], "HEALTHCHECK --interval=30s --retries=3 CMD [\"curl\",\"-f\",\"http://localhost:8080/\"]\n") {
+		t.Errorf("expected web healthcheck:\n%s", g.Containerfiles["app"])
+	}
+
+	// Image-level config wins over layers
+	img := newImg("web", "api")
+	img.Healthcheck = &HealthcheckConfig{Cmd: Command{"true"}, Timeout: "2s"}
+	g = newGen(img)
+	if err := g.generateContainerfile("app"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	if !strings.Contains(g.Containerfiles["app"], "HEALTHCHECK --timeout=2s CMD [\"true\"]\n") {
+		t.Errorf("expected image healthcheck:\n%s", g.Containerfiles["app"])
+	}
+
+	// An auto-intermediate gets none, the image built on it gets its layer's
+	inter := newImg("web")
+	inter.Name, inter.FullTag, inter.Auto = "app-web", "app-web:test", true
+	app := newImg("api")
+	app.Base, app.IsExternalBase, app.Layers = "app-web", false, []string{}
+	g = newGen(app)
+	g.Images["app-web"] = inter
+	g.Layers["api"].HasHealthcheck = false
+	for _, name := range []string{"app-web", "app"} {
+		if err := g.generateContainerfile(name); err != nil {
+			t.Fatalf("generateContainerfile(%s) error = %v", name, err)
+		}
+	}
+	if strings.Contains(g.Containerfiles["app-web"], "HEALTHCHECK") {
+		t.Errorf("auto-intermediate must not get a HEALTHCHECK:\n%s", g.Containerfiles["app-web"])
+	}
+	if !strings.Contains(g.Containerfiles["app"], "HEALTHCHECK --interval=30s --retries=3 CMD [\"curl\",\"-f\",\"http://localhost:8080/\"]\n") {
+		t.Errorf("expected the intermediate's web healthcheck in app:\n%s", g.Containerfiles["app"])
+	}
+}
+
+func TestCollectImageExposedPorts(t *testing.T) {
+	layers := map[string]*Layer{
+		"traefik": {Name: "traefik", HasRootYml: true, HasPorts: true, ports: []string{"8000", "8080"}},
+		"api":     {Name: "api", HasRootYml: true, HasPorts: true, ports: []string{"8080", "9090"}},
+		"tools":   {Name: "tools", HasRootYml: true},
+	}
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"proxy": {Base: "quay.io/fedora/fedora:43", Layers: []string{"traefik"}},
+			"app":   {Base: "proxy", Layers: []string{"api", "tools"}},
+		},
+	}
+
+	// Duplicates across layers and the base chain are deduped, not errored
+	ports, err := CollectImageExposedPorts(cfg, layers, "app")
+	if err != nil {
+		t.Fatalf("CollectImageExposedPorts() error = %v", err)
+	}
+	want := []string{"8000", "8080", "9090"}
+	if strings.Join(ports, ",") != strings.Join(want, ",") {
+		t.Errorf("ports = %v, want %v", ports, want)
+	}
+
+	// writeExpose emits one EXPOSE per unique port of the image's own layers
+	g := &Generator{Layers: layers}
+	var b strings.Builder
+	g.writeExpose(&b, []string{"traefik", "api"})
+	if got := strings.Count(b.String(), "EXPOSE 8080\n"); got != 1 {
+		t.Errorf("EXPOSE 8080 emitted %d times:\n%s", got, b.String())
+	}
+}
+
+func TestGenerateContainerfileRequirementsTxt(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"tools": {Layers: []string{"pip-tool"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"pip-tool": {Name: "pip-tool", HasRequirementsTxt: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"builder": {Name: "builder", FullTag: "builder:test"},
+			"tools": {
+				Name:           "tools",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"pip-tool"},
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				Builder:        "builder",
+				FullTag:        "tools:test",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("tools"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["tools"]
+
+	for _, want := range []string{
+		"FROM ${BUILDER_IMAGE} AS pip-tool-pixi-build\n",
+		"COPY layers/pip-tool/requirements.txt requirements.txt\n",
+		"pixi init && pixi add \"python=" + DefaultPythonVersion + ".*\"",
+		requirementsFilter + " | xargs -r -d '\\n' pixi add --manifest-path pixi.toml --pypi",
+		"pixi install --manifest-path pixi.toml\n",
+		"COPY --from=pip-tool-pixi-build --chown=1000:1000 /home/user/.pixi/envs/default /home/user/.pixi/envs/default\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "pip install") {
+		t.Errorf("requirements.txt must be installed through pixi, not pip:\n%s", content)
+	}
+}
+
+func TestGenerateContainerfileGoMod(t *testing.T) {
+	newGen := func(builder string) *Generator {
+		return &Generator{
+			Config:   &Config{Images: map[string]ImageConfig{"tools": {Layers: []string{"go-tool"}}}},
+			BuildDir: t.TempDir(),
+			Layers: map[string]*Layer{
+				"go-tool": {Name: "go-tool", HasGoMod: true, HasGoSources: true},
+			},
+			Images: map[string]*ResolvedImage{
+				"builder": {Name: "builder", FullTag: "builder:test"},
+				"tools": {
+					Name:           "tools",
+					Base:           "quay.io/fedora/fedora:43",
+					IsExternalBase: true,
+					Pkg:            "rpm",
+					Layers:         []string{"go-tool"},
+					User:           "user",
+					UID:            1000,
+					GID:            1000,
+					Home:           "/home/user",
+					Builder:        builder,
+					FullTag:        "tools:test",
+				},
+			},
+			Containerfiles: make(map[string]string),
+		}
+	}
+
+	// With a builder: dedicated build stage, binaries copied into the final image
+	g := newGen("builder")
+	if err := g.generateContainerfile("tools"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["tools"]
+	for _, want := range []string{
+		"FROM ${BUILDER_IMAGE} AS go-tool-go-build\nUSER 1000:1000\nWORKDIR /home/user\n",
+		"--mount=type=cache,dst=/home/user/go/pkg/mod,uid=1000,gid=1000",
+		"cd /ctx && GOBIN=/home/user/.local/bin go install ./...\n",
+		"COPY --from=go-tool-go-build --chown=1000:1000 /home/user/.local/bin/ /home/user/.local/bin/\n",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "golang") {
+		t.Errorf("Go toolchain should stay in the builder:\n%s", content)
+	}
+
+	// Without a builder: install golang with the layer's packages and build in place
+	g = newGen("")
+	if err := g.generateContainerfile("tools"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content = g.Containerfiles["tools"]
+	if strings.Contains(content, "go-tool-go-build") {
+		t.Errorf("unexpected go build stage without builder:\n%s", content)
+	}
+	pkgIdx := strings.Index(content, "dnf install -y \\\n      golang\n")
+	buildIdx := strings.Index(content, "USER 1000\nRUN --mount=type=bind,from=go-tool")
+	if pkgIdx < 0 || buildIdx < 0 || pkgIdx > buildIdx {
+		t.Errorf("expected golang install followed by in-place build as user:\n%s", content)
+	}
+}
+
+func TestWriteLayerStepsSystemd(t *testing.T) {
+	g := &Generator{
+		Layers: map[string]*Layer{
+			"hello-units": {
+				Name:         "hello-units",
+				HasSystemd:   true,
+				systemdUnits: []string{"hello.service", "hello.timer", "worker@.service"},
+			},
+		},
+	}
+
+	// bootc: units copied and enabled (templates are not enabled)
+	img := &ResolvedImage{Name: "bootc-img", Pkg: "rpm", Bootc: true, UID: 1000, GID: 1000, Home: "/home/user"}
+	var b strings.Builder
+	g.writeLayerSteps(&b, "hello-units", img, false)
+	want := "COPY layers/hello-units/systemd/ /usr/lib/systemd/system/\nRUN systemctl enable hello.service hello.timer\n"
+	if !strings.Contains(b.String(), want) {
+		t.Errorf("expected systemd steps:\n%s\ngot:\n%s", want, b.String())
+	}
+
+	// non-bootc: systemd/ is ignored
+	img.Bootc = false
+	b.Reset()
+	g.writeLayerSteps(&b, "hello-units", img, false)
+	if strings.Contains(b.String(), "systemd") {
+		t.Errorf("non-bootc image should not install systemd units:\n%s", b.String())
+	}
+}
+
+// newGenerateTestGenerator returns a generator for a fedora -> app chain with a
+// service layer, writing into a temporary project dir (no remote inspection)
+func newGenerateTestGenerator(t *testing.T, dir string) *Generator {
+	t.Helper()
+	orig := InspectImageUser
+	t.Cleanup(func() { InspectImageUser = orig })
+	InspectImageUser = func(ref string, uid int) (*UserInfo, error) { return nil, nil }
+
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/overthinkos", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool"}},
+			"app":    {Base: "fedora", Layers: []string{"svc"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages("2026.46.1415")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	return &Generator{
+		Dir:    dir,
+		Config: cfg,
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasRootYml: true},
+			"svc":  {Name: "svc", HasRootYml: true, HasSupervisord: true, serviceConf: "[program:svc]\ncommand=svc\n"},
+		},
+		Tag:            "2026.46.1415",
+		Images:         images,
+		BuildDir:       filepath.Join(dir, ".build"),
+		Containerfiles: make(map[string]string),
+		Created:        time.Date(2026, 2, 15, 14, 15, 0, 0, time.UTC),
+	}
+}
+
+func TestGenerateSkipsUnchangedFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	g := newGenerateTestGenerator(t, dir)
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if g.Updated == 0 || g.Unchanged != 0 {
+		t.Fatalf("first Generate(): %d updated, %d unchanged", g.Updated, g.Unchanged)
+	}
+	firstRun := g.Updated
+
+	// A later run has a new timestamp, which only reaches the build flags
+	g = newGenerateTestGenerator(t, dir)
+	g.Created = g.Created.Add(time.Minute)
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	if g.Updated != 0 || g.Unchanged != firstRun {
+		t.Errorf("second Generate(): %d updated, %d unchanged, want 0 and %d", g.Updated, g.Unchanged, firstRun)
+	}
+}
+
+func TestGenerateNewTag(t *testing.T) {
+	dir := t.TempDir()
+	buildDir := filepath.Join(dir, ".build")
+	readAll := func() map[string]string {
+		files := make(map[string]string)
+		filepath.WalkDir(buildDir, func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				data, _ := os.ReadFile(path)
+				rel, _ := filepath.Rel(buildDir, path)
+				files[rel] = string(data)
+			}
+			return err
+		})
+		return files
+	}
+
+	g := newGenerateTestGenerator(t, dir)
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	before := readAll()
+
+	// A new tag (tag: auto in a later minute) reaches the version labels and
+	// the internal base references, so exactly the Containerfiles change
+	g = newGenerateTestGenerator(t, dir)
+	g.Tag = "2026.46.1416"
+	images, err := g.Config.ResolveAllImages(g.Tag)
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	g.Images = images
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+	var changed []string
+	for path, content := range readAll() {
+		if before[path] != content {
+			changed = append(changed, path)
+		}
+	}
+	sortStrings(changed)
+	want := []string{"app/Containerfile", "fedora/Containerfile"}
+	if !reflect.DeepEqual(changed, want) {
+		t.Errorf("changed files = %v, want %v", changed, want)
+	}
+	if g.Updated != len(want) || g.Unchanged != len(before)-len(want) {
+		t.Errorf("Generate(): %d updated, %d unchanged, want %d and %d", g.Updated, g.Unchanged, len(want), len(before)-len(want))
+	}
+	if !strings.Contains(readAll()["app/Containerfile"], "ARG BASE_IMAGE=ghcr.io/overthinkos/fedora:2026.46.1416\n") {
+		t.Errorf("app/Containerfile should build on the new tag of fedora:\n%s", readAll()["app/Containerfile"])
+	}
+}
+
+func TestGenerateRemovesStaleFiles(t *testing.T) {
+	dir := t.TempDir()
+	buildDir := filepath.Join(dir, ".build")
+
+	// Leftovers from an image that was renamed and a service that was removed
+	for _, path := range []string{"old-app/Containerfile", "app/fragments/50-gone.conf"} {
+		full := filepath.Join(buildDir, path)
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte("stale\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := newGenerateTestGenerator(t, dir)
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	for _, path := range []string{"old-app", "app/fragments/50-gone.conf"} {
+		if _, err := os.Stat(filepath.Join(buildDir, path)); !os.IsNotExist(err) {
+			t.Errorf(".build/%s should have been removed", path)
+		}
+	}
+	for _, path := range []string{"app/Containerfile", "app/fragments/50-svc.conf", "fedora/Containerfile"} {
+		if _, err := os.Stat(filepath.Join(buildDir, path)); err != nil {
+			t.Errorf(".build/%s missing: %v", path, err)
+		}
+	}
+}
+
+func TestGenerateNestedLayers(t *testing.T) {
+	dir := writeNestedLayers(t, map[string]string{
+		"lang/python/layer.yml": "rpm:\n  packages: [python3]\n",
+		"lang/python/root.yml":  "version: '3'\n",
+		"svc/api/layer.yml":     "depends: [python]\nservice: |\n  [program:api]\n  command=api\n",
+		"svc/api/root.yml":      "version: '3'\n",
+		"svc/web/layer.yml":     "depends: [python]\n",
+		"svc/web/user.yml":      "version: '3'\n",
+	})
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	orig := InspectImageUser
+	t.Cleanup(func() { InspectImageUser = orig })
+	InspectImageUser = func(ref string, uid int) (*UserInfo, error) { return nil, nil }
+
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "ghcr.io/overthinkos", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "quay.io/fedora/fedora:43", Layers: []string{}},
+			"api":    {Base: "fedora", Layers: []string{"svc/api"}},
+			"web":    {Base: "fedora", Layers: []string{"svc/web"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages("2026.46.1415")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	images, err = ComputeIntermediates(images, layers, cfg, "2026.46.1415")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	// The shared lang/python prefix becomes an intermediate named after its stage name
+	inter, ok := images["fedora-lang-python"]
+	if !ok || !inter.Auto {
+		t.Fatalf("api base = %q, want auto intermediate fedora-lang-python", images["api"].Base)
+	}
+
+	g := &Generator{
+		Dir:            dir,
+		Config:         cfg,
+		Layers:         layers,
+		Tag:            "2026.46.1415",
+		Images:         images,
+		BuildDir:       filepath.Join(dir, ".build"),
+		Containerfiles: make(map[string]string),
+	}
+	for _, name := range []string{"fedora-lang-python", "api"} {
+		if err := g.generateContainerfile(name); err != nil {
+			t.Fatalf("generateContainerfile(%s) error = %v", name, err)
+		}
+	}
+
+	for name, wants := range map[string][]string{
+		"fedora-lang-python": {
+			"FROM scratch AS lang-python\nCOPY layers/lang/python/ /\n",
+			"RUN --mount=type=bind,from=lang-python,source=/,target=/ctx",
+			"# Layer: lang/python (sha256:",
+		},
+		"api": {
+			"FROM scratch AS svc-api\nCOPY layers/svc/api/ /\n",
+			"RUN --mount=type=bind,from=svc-api,source=/,target=/ctx",
+			"COPY .build/api/fragments/50-svc-api.conf /fragments/50-svc-api.conf",
+		},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(g.Containerfiles[name], want) {
+				t.Errorf("%s Containerfile missing %q:\n%s", name, want, g.Containerfiles[name])
+			}
+		}
+	}
+	if strings.Contains(g.Containerfiles["api"], "AS lang/python") {
+		t.Errorf("stage names must not contain slashes:\n%s", g.Containerfiles["api"])
+	}
+}
+
+func TestGenerateContainerfileRequirementsTxtAfterPixiLayer(t *testing.T) {
+	g := &Generator{
+		Config:   &Config{Images: map[string]ImageConfig{"tools": {Layers: []string{"python", "pip-tool"}}}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"python":   {Name: "python", HasPixiToml: true},
+			"pip-tool": {Name: "pip-tool", HasRequirementsTxt: true, Depends: []string{"python"}},
+		},
+		Images: map[string]*ResolvedImage{
+			"builder": {Name: "builder", FullTag: "builder:test"},
+			"tools": {
+				Name:           "tools",
+				Base:           "quay.io/fedora/fedora:43",
+				IsExternalBase: true,
+				Pkg:            "rpm",
+				Layers:         []string{"python", "pip-tool"},
+				User:           "user",
+				UID:            1000,
+				GID:            1000,
+				Home:           "/home/user",
+				Builder:        "builder",
+				FullTag:        "tools:test",
+			},
+		},
+		Containerfiles: make(map[string]string),
+	}
+
+	if err := g.generateContainerfile("tools"); err != nil {
+		t.Fatalf("generateContainerfile() error = %v", err)
+	}
+	content := g.Containerfiles["tools"]
+
+	// The requirements go into the python layer's project instead of replacing its environment
+	for _, want := range []string{
+		"FROM ${BUILDER_IMAGE} AS python-pixi-build\n",
+		"FROM python-pixi-build AS pip-tool-pixi-build\n",
+		"xargs -r -d '\\n' pixi add --manifest-path pixi.toml --pypi",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("missing %q in:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "pixi init") {
+		t.Errorf("requirements.txt must not start a new pixi project after a pixi layer:\n%s", content)
+	}
+	if strings.Index(content, "--from=pip-tool-pixi-build") < strings.Index(content, "--from=python-pixi-build") {
+		t.Errorf("the requirements environment must be copied last:\n%s", content)
+	}
+}
+
+func TestRequirementsFilter(t *testing.T) {
+	data, err := os.ReadFile("testdata/requirements.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "requirements.txt"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("sh", "-c", requirementsFilter)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s: %v", requirementsFilter, err)
+	}
+	// Inline comments, CRLF and whitespace never reach pixi add, a # inside a URL does
+	want := "requests==2.31\nnumpy>=1.26\npkg @ https://example.com/pkg.tar.gz#sha256=abc\nrich\n"
+	if string(out) != want {
+		t.Errorf("requirements = %q, want %q", out, want)
 	}
 }
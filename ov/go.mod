@@ -3,6 +3,7 @@ module github.com/overthinkos/overthink/ov
 go 1.25.6
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/alecthomas/kong v1.14.0
 	github.com/google/go-containerregistry v0.20.7
 	gopkg.in/yaml.v3 v3.0.1
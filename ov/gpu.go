@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
 )
 
 // GPUMode represents the resolved GPU mode
@@ -34,6 +35,19 @@ func (f GPUFlags) Mode() GPUMode {
 	}
 }
 
+// Spec returns the GPU count spec of the flags for ResolveGPUCount: all for
+// --gpu, none for --no-gpu and fallback otherwise.
+func (f GPUFlags) Spec(fallback string) string {
+	switch f.Mode() {
+	case GPUOn:
+		return "all"
+	case GPUOff:
+		return "none"
+	default:
+		return fallback
+	}
+}
+
 // DetectGPU checks whether an NVIDIA GPU is available by running nvidia-smi.
 // It is a package-level var for testability (same pattern as exec_LookPath).
 var DetectGPU = defaultDetectGPU
@@ -59,6 +73,36 @@ func ResolveGPU(mode GPUMode) bool {
 	}
 }
 
+// GPUAll is the device count that passes through every GPU
+const GPUAll = -1
+
+// ResolveGPUCount resolves an ov shell --gpus value to the number of GPUs to
+// pass through: "all" gives GPUAll, "none" 0 and a number N that many. "auto"
+// (or "") gives GPUAll when imageGPU (the image's gpu: true) is set or
+// DetectGPU finds a GPU, else 0. The returned reason tells how the choice was
+// made, for --verbose.
+func ResolveGPUCount(spec string, imageGPU bool) (int, string, error) {
+	switch spec {
+	case "", "auto":
+		if imageGPU {
+			return GPUAll, "all GPUs (image sets gpu: true)", nil
+		}
+		if DetectGPU() {
+			return GPUAll, "all GPUs (auto: GPU detected)", nil
+		}
+		return 0, "none (auto: no GPU detected)", nil
+	case "all":
+		return GPUAll, "all GPUs", nil
+	case "none":
+		return 0, "none", nil
+	}
+	n, err := strconv.Atoi(spec)
+	if err != nil || n < 1 {
+		return 0, "", fmt.Errorf("invalid --gpus %q (want auto, all, none or a device count)", spec)
+	}
+	return n, fmt.Sprintf("%d GPU(s)", n), nil
+}
+
 // LogGPU prints GPU status to stderr when enabled.
 func LogGPU(gpu bool) {
 	if gpu {
@@ -55,3 +55,39 @@ func TestResolveGPU(t *testing.T) {
 		}
 	})
 }
+
+func TestResolveGPUCount(t *testing.T) {
+	orig := DetectGPU
+	defer func() { DetectGPU = orig }()
+
+	tests := []struct {
+		spec     string
+		imageGPU bool
+		detected bool
+		want     int
+	}{
+		{"", false, true, GPUAll},
+		{"auto", false, false, 0},
+		{"auto", true, false, GPUAll},
+		{"all", false, false, GPUAll},
+		{"none", true, true, 0},
+		{"2", false, false, 2},
+	}
+	for _, tt := range tests {
+		DetectGPU = func() bool { return tt.detected }
+		got, reason, err := ResolveGPUCount(tt.spec, tt.imageGPU)
+		if err != nil || got != tt.want || reason == "" {
+			t.Errorf("ResolveGPUCount(%q, %v) with detection %v = %d, %q, %v; want %d", tt.spec, tt.imageGPU, tt.detected, got, reason, err, tt.want)
+		}
+	}
+
+	DetectGPU = func() bool {
+		t.Error("DetectGPU called for an explicit --gpus")
+		return false
+	}
+	for _, spec := range []string{"0", "-1", "some"} {
+		if _, _, err := ResolveGPUCount(spec, false); err == nil {
+			t.Errorf("ResolveGPUCount(%q) expected error", spec)
+		}
+	}
+}
@@ -7,11 +7,16 @@ import (
 
 // CycleError represents a circular dependency error
 type CycleError struct {
-	Cycle []string
+	Cycle   []string // one cycle, first node repeated at the end (a -> b -> a)
+	Blocked []string // every node a topological sort couldn't place (cycles and their dependents), sorted
 }
 
 func (e *CycleError) Error() string {
-	return fmt.Sprintf("circular dependency: %s", strings.Join(e.Cycle, " -> "))
+	msg := fmt.Sprintf("circular dependency: %s", strings.Join(e.Cycle, " -> "))
+	if len(e.Blocked) > 0 {
+		msg += fmt.Sprintf(" (unresolved: %s)", strings.Join(e.Blocked, ", "))
+	}
+	return msg
 }
 
 // ResolveLayerOrder resolves layer dependencies and returns them in topological order.
@@ -20,11 +25,16 @@ func (e *CycleError) Error() string {
 // 2. Topologically sorts the result
 // 3. Returns layers in install order (dependencies before dependents)
 //
+// after entries only order layers that are needed anyway; they never add one.
+// Neither do depends entries naming a capability: they order the layer after
+// the provider the image includes (validation reports missing or several providers).
+//
 // parentLayers contains layers already installed by parent images (via base chain).
 // These are excluded from the returned order.
 func ResolveLayerOrder(requested []string, layers map[string]*Layer, parentLayers map[string]bool) ([]string, error) {
 	// Build the set of all layers we need (transitive closure)
 	needed := make(map[string]bool)
+	providers := capabilityProviders(layers)
 	visiting := make(map[string]bool) // Track current path for cycle detection
 
 	var addTransitive func(name string, path []string) error
@@ -39,8 +49,15 @@ func ResolveLayerOrder(requested []string, layers map[string]*Layer, parentLayer
 
 		// Check for cycle
 		if visiting[name] {
-			cycle := append(path, name)
-			return &CycleError{Cycle: cycle}
+			// Report the cycle itself, without the path leading to it
+			for i, n := range path {
+				if n == name {
+					path = path[i:]
+					break
+				}
+			}
+			cycle := append(append([]string{}, path...), name)
+			return &CycleError{Cycle: cycle, Blocked: sortedCopy(path)}
 		}
 
 		layer, ok := layers[name]
@@ -53,6 +70,9 @@ func ResolveLayerOrder(requested []string, layers map[string]*Layer, parentLayer
 
 		// Add dependencies first
 		for _, dep := range layer.Depends {
+			if _, ok := layers[dep]; !ok && len(providers[dep]) > 0 {
+				continue // capability, satisfied by a provider the image includes
+			}
 			if err := addTransitive(dep, newPath); err != nil {
 				return err
 			}
@@ -70,17 +90,10 @@ func ResolveLayerOrder(requested []string, layers map[string]*Layer, parentLayer
 	}
 
 	// Build adjacency list for topological sort
-	// Edge from A to B means A depends on B (B must come before A)
+	// Edge from A to B means A depends on (or is after) B (B must come before A)
 	graph := make(map[string][]string)
 	for name := range needed {
-		layer := layers[name]
-		var deps []string
-		for _, dep := range layer.Depends {
-			if needed[dep] { // Only include deps that are in our needed set
-				deps = append(deps, dep)
-			}
-		}
-		graph[name] = deps
+		graph[name] = layers[name].orderDeps(needed, providers) // only edges within our needed set
 	}
 
 	// Topological sort using Kahn's algorithm
@@ -88,7 +101,7 @@ func ResolveLayerOrder(requested []string, layers map[string]*Layer, parentLayer
 }
 
 // ImageNeedsBuilder returns true if any of the image's own resolved layers
-// (excluding parent-provided) have pixi.toml, package.json, or Cargo.toml.
+// (excluding parent-provided) have a pixi manifest, package.json, Cargo.toml, or go.mod.
 // When layers is nil, falls back to unconditional builder dependency.
 func ImageNeedsBuilder(img *ResolvedImage, images map[string]*ResolvedImage, layers map[string]*Layer) bool {
 	if layers == nil {
@@ -116,7 +129,7 @@ func ImageNeedsBuilder(img *ResolvedImage, images map[string]*ResolvedImage, lay
 		if !ok {
 			continue
 		}
-		if layer.PixiManifest() != "" || layer.HasPackageJson || layer.HasCargoToml {
+		if layer.PixiManifest() != "" || layer.HasPackageJson || layer.HasCargoToml || layer.HasGoMod {
 			return true
 		}
 	}
@@ -128,8 +141,12 @@ func ImageNeedsBuilder(img *ResolvedImage, images map[string]*ResolvedImage, lay
 // Each image's Builder field determines its builder dependency.
 // Pass layers to enable conditional builder dependency; nil for unconditional.
 func ResolveImageOrder(images map[string]*ResolvedImage, layers map[string]*Layer) ([]string, error) {
-	// Build adjacency list
-	// Edge from A to B means A depends on B (B must be built before A)
+	return topoSort(imageDependencies(images, layers))
+}
+
+// imageDependencies builds the image adjacency list.
+// Edge from A to B means A depends on B (B must be built before A).
+func imageDependencies(images map[string]*ResolvedImage, layers map[string]*Layer) map[string][]string {
 	graph := make(map[string][]string)
 	for name, img := range images {
 		var deps []string
@@ -147,8 +164,7 @@ func ResolveImageOrder(images map[string]*ResolvedImage, layers map[string]*Laye
 		}
 		graph[name] = deps
 	}
-
-	return topoSort(graph)
+	return graph
 }
 
 // topoSort performs topological sort using Kahn's algorithm.
@@ -213,21 +229,25 @@ func topoSort(graph map[string][]string) ([]string, error) {
 	if len(result) != len(graph) {
 		// Find a cycle for error reporting
 		cycle := findCycle(graph, inDegree)
-		return nil, &CycleError{Cycle: cycle}
+		return nil, &CycleError{Cycle: cycle, Blocked: blockedNodes(inDegree)}
 	}
 
 	return result, nil
 }
 
-// findCycle finds a cycle in the graph for error reporting
+// findCycle finds a cycle in the graph for error reporting. inDegree holds the
+// unplaced dependencies left by Kahn's algorithm: every node with a nonzero
+// count waits on another such node, so following those edges from the first
+// of them (sorted, for a stable message) must run into a cycle. The result
+// starts and ends with the same node.
 func findCycle(graph map[string][]string, inDegree map[string]int) []string {
-	// Start from any node still in the graph (non-zero in-degree)
-	var start string
-	for node, degree := range inDegree {
-		if degree > 0 {
-			start = node
-			break
-		}
+	blocked := blockedNodes(inDegree)
+	if len(blocked) == 0 {
+		return nil
+	}
+	isBlocked := make(map[string]bool, len(blocked))
+	for _, node := range blocked {
+		isBlocked[node] = true
 	}
 
 	// DFS to find cycle
@@ -241,13 +261,22 @@ func findCycle(graph map[string][]string, inDegree map[string]int) []string {
 		path[node] = true
 		cyclePath = append(cyclePath, node)
 
-		for _, dep := range graph[node] {
+		for _, dep := range sortedCopy(graph[node]) {
+			if !isBlocked[dep] {
+				continue
+			}
 			if !visited[dep] {
 				if dfs(dep) {
 					return true
 				}
 			} else if path[dep] {
-				// Found cycle
+				// Found cycle: drop the nodes leading to it
+				for i, n := range cyclePath {
+					if n == dep {
+						cyclePath = cyclePath[i:]
+						break
+					}
+				}
 				cyclePath = append(cyclePath, dep)
 				return true
 			}
@@ -258,13 +287,33 @@ func findCycle(graph map[string][]string, inDegree map[string]int) []string {
 		return false
 	}
 
-	if start != "" {
-		dfs(start)
+	for _, start := range blocked {
+		if !visited[start] && dfs(start) {
+			break
+		}
 	}
-
 	return cyclePath
 }
 
+// blockedNodes returns the sorted nodes with unplaced dependencies left
+func blockedNodes(inDegree map[string]int) []string {
+	var blocked []string
+	for node, degree := range inDegree {
+		if degree > 0 {
+			blocked = append(blocked, node)
+		}
+	}
+	sortStrings(blocked)
+	return blocked
+}
+
+// sortedCopy returns a sorted copy of s
+func sortedCopy(s []string) []string {
+	c := append([]string{}, s...)
+	sortStrings(c)
+	return c
+}
+
 // LayersProvidedByImage returns the set of layers installed by an image
 // (including those inherited from parent images via base chain)
 func LayersProvidedByImage(imageName string, images map[string]*ResolvedImage, layers map[string]*Layer) (map[string]bool, error) {
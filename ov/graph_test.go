@@ -2,6 +2,7 @@ package main
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -104,6 +105,135 @@ func TestResolveLayerOrderCycle(t *testing.T) {
 	} else if len(cycleErr.Cycle) == 0 {
 		t.Error("CycleError.Cycle is empty")
 	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't name layer %s", err, name)
+		}
+	}
+
+	// The path leading into the cycle is not part of it
+	layers["app"] = &Layer{Name: "app", Depends: []string{"b"}}
+	_, err = ResolveLayerOrder([]string{"app"}, layers, nil)
+	if err == nil || err.Error() != "circular dependency: b -> c -> a -> b (unresolved: a, b, c)" {
+		t.Errorf("error = %v, want the b -> c -> a -> b cycle", err)
+	}
+}
+
+func TestTopoSortCycleError(t *testing.T) {
+	// x -> y -> z -> x, plus w waiting on the cycle and v independent
+	graph := map[string][]string{
+		"v": nil,
+		"w": {"x"},
+		"x": {"y"},
+		"y": {"z"},
+		"z": {"x"},
+	}
+	_, err := topoSort(graph)
+	cycleErr, ok := err.(*CycleError)
+	if !ok {
+		t.Fatalf("error = %v, want CycleError", err)
+	}
+	if !reflect.DeepEqual(cycleErr.Cycle, []string{"x", "y", "z", "x"}) {
+		t.Errorf("Cycle = %v, want [x y z x]", cycleErr.Cycle)
+	}
+	if !reflect.DeepEqual(cycleErr.Blocked, []string{"w", "x", "y", "z"}) {
+		t.Errorf("Blocked = %v, want [w x y z]", cycleErr.Blocked)
+	}
+	if want := "circular dependency: x -> y -> z -> x (unresolved: w, x, y, z)"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestResolveLayerOrderAfter(t *testing.T) {
+	// completions prefers to come after the tools it completes, without requiring them
+	layers := map[string]*Layer{
+		"aaa-tools":   {Name: "aaa-tools"},
+		"zzz-tools":   {Name: "zzz-tools"},
+		"completions": {Name: "completions", After: []string{"aaa-tools", "zzz-tools"}},
+		"shell":       {Name: "shell", Depends: []string{"completions"}},
+	}
+
+	tests := []struct {
+		name      string
+		requested []string
+		want      []string
+	}{
+		{"after target absent", []string{"completions"}, []string{"completions"}},
+		{"after target present", []string{"completions", "zzz-tools"}, []string{"zzz-tools", "completions"}},
+		{"after target through depends", []string{"shell", "zzz-tools"}, []string{"zzz-tools", "completions", "shell"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order, err := ResolveLayerOrder(tt.requested, layers, nil)
+			if err != nil {
+				t.Fatalf("ResolveLayerOrder() error = %v", err)
+			}
+			if !reflect.DeepEqual(order, tt.want) {
+				t.Errorf("order = %v, want %v", order, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveLayerOrderAfterCycle(t *testing.T) {
+	// a depends on b, b is after a: only a cycle when both are in the image
+	layers := map[string]*Layer{
+		"a": {Name: "a", Depends: []string{"b"}},
+		"b": {Name: "b", After: []string{"a"}},
+		"c": {Name: "c", After: []string{"d"}},
+		"d": {Name: "d", After: []string{"c"}},
+	}
+
+	_, err := ResolveLayerOrder([]string{"a"}, layers, nil)
+	if _, ok := err.(*CycleError); !ok {
+		t.Errorf("depends/after cycle: error = %v, want CycleError", err)
+	}
+	_, err = ResolveLayerOrder([]string{"c", "d"}, layers, nil)
+	if _, ok := err.(*CycleError); !ok {
+		t.Errorf("after/after cycle: error = %v, want CycleError", err)
+	}
+	if order, err := ResolveLayerOrder([]string{"c"}, layers, nil); err != nil || !reflect.DeepEqual(order, []string{"c"}) {
+		t.Errorf("half of an after cycle: order = %v, error = %v, want [c]", order, err)
+	}
+
+	images := map[string]*ResolvedImage{
+		"img": {Name: "img", Base: "ext:1", IsExternalBase: true, Layers: []string{"a"}},
+	}
+	if _, err := GlobalLayerOrder(images, layers); err == nil {
+		t.Error("GlobalLayerOrder() expected cycle error")
+	}
+}
+
+func TestResolveLayerOrderCapabilities(t *testing.T) {
+	layers := map[string]*Layer{
+		"python":  {Name: "python", Provides: []string{"python-runtime"}},
+		"pypy":    {Name: "pypy", Provides: []string{"python-runtime"}},
+		"scripts": {Name: "scripts", Depends: []string{"python-runtime"}},
+	}
+
+	// Provider in the image itself: ordered before its dependent, never pulled in
+	order, err := ResolveLayerOrder([]string{"scripts", "pypy"}, layers, nil)
+	if err != nil {
+		t.Fatalf("ResolveLayerOrder() error = %v", err)
+	}
+	if want := []string{"pypy", "scripts"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	// Provider in the base chain: satisfied there, nothing added
+	order, err = ResolveLayerOrder([]string{"scripts"}, layers, map[string]bool{"python": true})
+	if err != nil {
+		t.Fatalf("ResolveLayerOrder() with parent provider error = %v", err)
+	}
+	if want := []string{"scripts"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	// Unknown names are still errors
+	layers["broken"] = &Layer{Name: "broken", Depends: []string{"ruby-runtime"}}
+	if _, err := ResolveLayerOrder([]string{"broken"}, layers, nil); err == nil {
+		t.Error("expected error for unknown depends")
+	}
 }
 
 func TestResolveImageOrder(t *testing.T) {
@@ -215,7 +345,12 @@ func TestResolveImageOrderCycle(t *testing.T) {
 
 	_, err := ResolveImageOrder(images, nil)
 	if err == nil {
-		t.Error("expected cycle error, got nil")
+		t.Fatal("expected cycle error, got nil")
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q doesn't name image %s", err, name)
+		}
 	}
 }
 
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ovIgnoreFile lists paths (gitignore syntax) left out of a layer: in a layer
+// directory for that layer, in the project directory for all layers
+const ovIgnoreFile = ".ovignore"
+
+// ignoreRule is one .ovignore pattern
+type ignoreRule struct {
+	glob     string // pattern without "!", leading and trailing "/"
+	anchored bool   // matches the layer-relative path, not a name at any depth
+	dirOnly  bool   // trailing "/": matches directories only
+	negate   bool   // "!": re-includes what earlier rules ignored
+	re       *regexp.Regexp
+}
+
+// ignoreRules are the .ovignore patterns of a layer, in file order (project
+// file first); like gitignore, the last matching rule decides
+type ignoreRules []ignoreRule
+
+// readIgnoreFile parses an .ovignore file (nil when missing)
+func readIgnoreFile(file string) (ignoreRules, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return parseIgnoreRules(file, string(data))
+}
+
+// parseIgnoreRules parses .ovignore content; file names the source in errors
+func parseIgnoreRules(file, content string) (ignoreRules, error) {
+	var rules ignoreRules
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), " \t")
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := ignoreRule{}
+		if strings.HasPrefix(text, "!") {
+			rule.negate = true
+			text = text[1:]
+		}
+		if strings.HasSuffix(text, "/") {
+			rule.dirOnly = true
+			text = strings.TrimRight(text, "/")
+		}
+		rule.anchored = strings.Contains(text, "/")
+		rule.glob = strings.TrimPrefix(text, "/")
+		if rule.glob == "" {
+			continue
+		}
+		expr := "^" + globToRegexp(rule.glob) + "$"
+		if !rule.anchored {
+			expr = "^(.*/)?" + globToRegexp(rule.glob) + "$"
+		}
+		var err error
+		if rule.re, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", file, line, scanner.Text())
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// globToRegexp translates a gitignore glob: * and ? stay within a path
+// segment, ** spans segments, [...] classes are kept
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			if strings.HasPrefix(glob[i:], "**/") {
+				b.WriteString("(.*/)?")
+				i += 2
+			} else if strings.HasPrefix(glob[i:], "**") {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			if end := strings.IndexByte(glob[i:], ']'); end > 0 {
+				class := glob[i+1 : i+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				b.WriteString("[" + class + "]")
+				i += end
+			} else {
+				b.WriteString(`\[`)
+			}
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String()
+}
+
+// Ignored reports whether the layer-relative path rel (slash-separated) is
+// ignored. Callers walking a tree skip ignored directories, which ignores
+// everything below them.
+func (r ignoreRules) Ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// dockerignore returns the rules as Containerfile.dockerignore lines for the
+// layer directory dir (relative to the build context). Directory-only rules
+// apply to files of the same name too, the ignore file can't tell them apart.
+func (r ignoreRules) dockerignore(dir string) []string {
+	var lines []string
+	for _, rule := range r {
+		pattern := path.Join(dir, rule.glob)
+		if !rule.anchored {
+			pattern = path.Join(dir, "**", rule.glob)
+		}
+		if rule.negate {
+			pattern = "!" + pattern
+		}
+		lines = append(lines, pattern)
+	}
+	return lines
+}
+
+// loadIgnoreFiles sets the .ovignore rules of every layer: the project's
+// .ovignore followed by the layer's own
+func loadIgnoreFiles(dir string, layers map[string]*Layer) error {
+	project, err := readIgnoreFile(filepath.Join(dir, ovIgnoreFile))
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", ovIgnoreFile, err)
+	}
+	for _, name := range LayerNames(layers) {
+		layer := layers[name]
+		own, err := readIgnoreFile(filepath.Join(layer.Path, ovIgnoreFile))
+		if err != nil {
+			return fmt.Errorf("layer %s: reading %s: %w", name, ovIgnoreFile, err)
+		}
+		layer.ignore = append(append(ignoreRules{}, project...), own...)
+	}
+	return nil
+}
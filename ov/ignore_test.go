@@ -0,0 +1,101 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules, err := parseIgnoreRules(".ovignore", `# dependencies
+node_modules/
+.venv/
+*.pyc
+!keep.pyc
+/build
+docs/**/*.md
+`)
+	if err != nil {
+		t.Fatalf("parseIgnoreRules() error = %v", err)
+	}
+
+	tests := []struct {
+		rel   string
+		isDir bool
+		want  bool
+	}{
+		{"node_modules", true, true},
+		{"files/opt/app/node_modules", true, true},
+		{"node_modules", false, false}, // directory-only pattern
+		{".venv", true, true},
+		{"cache.pyc", false, true},
+		{"files/lib/cache.pyc", false, true},
+		{"files/lib/keep.pyc", false, false},
+		{"build", true, true},
+		{"files/build", true, false}, // anchored to the layer directory
+		{"docs/a.md", false, true},
+		{"docs/x/y/a.md", false, true},
+		{"files/docs/a.md", false, false},
+		{"root.yml", false, false},
+	}
+	for _, tt := range tests {
+		if got := rules.Ignored(tt.rel, tt.isDir); got != tt.want {
+			t.Errorf("Ignored(%q, %v) = %v, want %v", tt.rel, tt.isDir, got, tt.want)
+		}
+	}
+
+	want := []string{
+		"layers/app/**/node_modules",
+		"layers/app/**/.venv",
+		"layers/app/**/*.pyc",
+		"!layers/app/**/keep.pyc",
+		"layers/app/build",
+		"layers/app/docs/**/*.md",
+	}
+	if got := rules.dockerignore("layers/app"); !reflect.DeepEqual(got, want) {
+		t.Errorf("dockerignore() =\n  %v\nwant\n  %v", got, want)
+	}
+}
+
+func TestLayerHashOvignore(t *testing.T) {
+	dir := writeNestedLayers(t, map[string]string{
+		"app/root.yml":                   "version: '3'\n",
+		"app/.ovignore":                  "node_modules/\n",
+		"app/node_modules/left-pad.js":   "v1\n",
+		"app/files/opt/app/.venv/python": "v1\n",
+	})
+	if err := os.WriteFile(filepath.Join(dir, ".ovignore"), []byte(".venv/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash := func() string {
+		t.Helper()
+		layers, err := ScanLayers(dir)
+		if err != nil {
+			t.Fatalf("ScanLayers() error = %v", err)
+		}
+		h, err := layers["app"].Hash()
+		if err != nil {
+			t.Fatalf("Hash() error = %v", err)
+		}
+		return h
+	}
+
+	before := hash()
+	// Changes below ignored directories (layer and project .ovignore) don't count
+	for _, rel := range []string{"layers/app/node_modules/left-pad.js", "layers/app/files/opt/app/.venv/python"} {
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte("v2\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hash() != before {
+		t.Error("changing an ignored file changed the layer hash")
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "layers/app/root.yml"), []byte("version: '3'\n# changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if hash() == before {
+		t.Error("changing a layer file did not change the layer hash")
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ImagesCmd lists the project's images with their state in the local stores
+type ImagesCmd struct {
+	JSON bool `long:"json" help:"Print the list as JSON"`
+
+	Profile         string `long:"profile" help:"Apply a profile from images.yml"`
+	IncludeDisabled bool   `long:"include-disabled" help:"Also list images with enabled: false"`
+}
+
+// ImageStatus is a project image and its state in the build and run engines
+type ImageStatus struct {
+	Name         string `json:"name"`
+	Ref          string `json:"ref"`
+	Tag          string `json:"tag"`
+	Intermediate bool   `json:"intermediate"`
+	InBuild      *bool  `json:"in_build"` // nil when the build engine is unavailable
+	InRun        *bool  `json:"in_run"`   // nil when the run engine is unavailable
+	Size         int64  `json:"size"`
+	Status       string `json:"status"` // "fresh", "stale", "unknown" (no digest label) or "missing"
+}
+
+func (c *ImagesCmd) Run() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	gen, err := NewGenerator(dir, "", ConfigOptions{Profile: c.Profile, IncludeDisabled: c.IncludeDisabled})
+	if err != nil {
+		return err
+	}
+	if err := generateInputDigests(gen); err != nil {
+		return err
+	}
+	state, err := LoadBuildState(gen.BuildDir)
+	if err != nil {
+		return err
+	}
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+
+	statuses, err := collectImageStatus(gen, state, rt)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		data, err := json.MarshalIndent(statuses, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	printImageTable(os.Stdout, statuses, rt)
+	return nil
+}
+
+// collectImageStatus returns the state of gen's images (InputDigests filled)
+// in build order. An image is looked up by the tag of its last build for
+// gen.Platform (.build/state.json), else its :latest tag, else its current
+// tag. An engine whose binary is missing shows as unavailable.
+func collectImageStatus(gen *Generator, state *BuildState, rt *ResolvedRuntime) ([]ImageStatus, error) {
+	order, err := ResolveImageOrder(gen.Images, gen.Layers)
+	if err != nil {
+		return nil, err
+	}
+
+	available := func(engine string) bool {
+		_, err := exec_LookPath(EngineBinary(engine))
+		return err == nil
+	}
+	buildAvailable, runAvailable := available(rt.BuildEngine), available(rt.RunEngine)
+
+	var statuses []ImageStatus
+	for _, name := range order {
+		img := gen.Images[name]
+		var candidates []string
+		if tag := state.Images[buildStateKey(name, gen.Platform)].Tag; tag != "" {
+			candidates = append(candidates, tag)
+		}
+		for _, tag := range img.Tags {
+			if strings.HasSuffix(tag, ":latest") {
+				candidates = append(candidates, tag)
+			}
+		}
+		candidates = append(candidates, img.FullTag)
+
+		s := ImageStatus{Name: name, Ref: candidates[0], Intermediate: img.Auto, Status: "missing"}
+		found := ""
+		for _, ref := range candidates {
+			inBuild := buildAvailable && LocalImageExists(rt.BuildEngine, ref)
+			inRun := inBuild
+			if rt.RunEngine != rt.BuildEngine {
+				inRun = runAvailable && LocalImageExists(rt.RunEngine, ref)
+			}
+			if buildAvailable {
+				s.InBuild = &inBuild
+			}
+			if runAvailable {
+				s.InRun = &inRun
+			}
+			if inRun {
+				s.Ref, found = ref, rt.RunEngine
+				break
+			}
+			if inBuild {
+				s.Ref, found = ref, rt.BuildEngine
+				break
+			}
+		}
+		_, s.Tag = splitImageRef(s.Ref)
+
+		if found != "" {
+			if size, err := ImageSize(found, s.Ref); err == nil {
+				s.Size = size
+			}
+			s.Status = "unknown"
+			if labels, err := InspectLabels(found, s.Ref); err == nil && labels[LabelInputsDigest] != "" {
+				s.Status = "stale"
+				if labels[LabelInputsDigest] == gen.InputDigests[name] {
+					s.Status = "fresh"
+				}
+			}
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// printImageTable prints statuses as an aligned table with a column per
+// engine ("yes", "no", or "-" when the engine is unavailable)
+func printImageTable(w io.Writer, statuses []ImageStatus, rt *ResolvedRuntime) {
+	engines := []string{rt.BuildEngine}
+	if rt.RunEngine != rt.BuildEngine {
+		engines = append(engines, rt.RunEngine)
+	}
+	presence := func(s ImageStatus, engine string) string {
+		in := s.InBuild
+		if engine != rt.BuildEngine {
+			in = s.InRun
+		}
+		switch {
+		case in == nil:
+			return "-"
+		case *in:
+			return "yes"
+		default:
+			return "no"
+		}
+	}
+
+	header := []string{"IMAGE", "TAG"}
+	for _, engine := range engines {
+		header = append(header, strings.ToUpper(engine))
+	}
+	header = append(header, "SIZE", "STATUS")
+	rows := [][]string{header}
+	for _, s := range statuses {
+		name := s.Name
+		if s.Intermediate {
+			name += " (auto)"
+		}
+		row := []string{name, s.Tag}
+		for _, engine := range engines {
+			row = append(row, presence(s, engine))
+		}
+		size := "-"
+		if s.Size > 0 {
+			size = formatSize(s.Size)
+		}
+		rows = append(rows, append(row, size, s.Status))
+	}
+	printTable(w, rows)
+}
+
+// printTable writes rows (the header first) as columns padded to their widest
+// cell, two spaces apart, without trailing padding on the last column
+func printTable(w io.Writer, rows [][]string) {
+	if len(rows) == 0 {
+		return
+	}
+	widths := make([]int, len(rows[0]))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+	for _, row := range rows {
+		var b strings.Builder
+		for i, cell := range row {
+			if i == len(row)-1 {
+				b.WriteString(cell)
+				break
+			}
+			fmt.Fprintf(&b, "%-*s  ", widths[i], cell)
+		}
+		fmt.Fprintln(w, b.String())
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectImageStatus(t *testing.T) {
+	origLook, origExists, origSize, origInspect := exec_LookPath, LocalImageExists, ImageSize, InspectLabels
+	defer func() {
+		exec_LookPath, LocalImageExists, ImageSize, InspectLabels = origLook, origExists, origSize, origInspect
+	}()
+
+	// docker has both images, podman isn't installed
+	exec_LookPath = func(name string) (string, error) {
+		if name == "docker" {
+			return "/usr/bin/docker", nil
+		}
+		return "", errors.New("not found")
+	}
+	local := map[string]map[string]string{
+		"ghcr.io/overthinkos/fedora:2026.1": {LabelInputsDigest: "sha256:fedora"},
+		"ghcr.io/overthinkos/app:latest":    {LabelInputsDigest: "sha256:old"},
+	}
+	LocalImageExists = func(engine, ref string) bool {
+		if engine != "docker" {
+			t.Errorf("LocalImageExists(%s) called for an unavailable engine", engine)
+		}
+		_, ok := local[ref]
+		return ok
+	}
+	ImageSize = func(engine, ref string) (int64, error) { return 3 * 1024 * 1024, nil }
+	InspectLabels = func(engine, ref string) (map[string]string, error) { return local[ref], nil }
+
+	gen := newDigestGenerator(t, t.TempDir(), "2026.2", time.Time{})
+	gen.Platform = "linux/amd64"
+	gen.InputDigests = map[string]string{"fedora": "sha256:fedora", "app": "sha256:new"}
+	state := &BuildState{Images: map[string]BuildStateEntry{
+		"fedora@linux/amd64": {Digest: "sha256:fedora", Tag: "ghcr.io/overthinkos/fedora:2026.1"},
+		"app@linux/arm64":    {Digest: "sha256:new", Tag: "ghcr.io/overthinkos/app:2026.1"},
+	}}
+	rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman"}
+
+	statuses, err := collectImageStatus(gen, state, rt)
+	if err != nil {
+		t.Fatalf("collectImageStatus() error = %v", err)
+	}
+	if len(statuses) != 2 || statuses[0].Name != "fedora" || statuses[1].Name != "app" {
+		t.Fatalf("statuses = %+v, want fedora and app in build order", statuses)
+	}
+
+	fedora, app := statuses[0], statuses[1]
+	if fedora.Tag != "2026.1" || fedora.Status != "fresh" || fedora.Size != 3*1024*1024 {
+		t.Errorf("fedora = %+v, want the last built tag, fresh, with its size", fedora)
+	}
+	if app.Ref != "ghcr.io/overthinkos/app:latest" || app.Status != "stale" {
+		t.Errorf("app = %+v, want :latest and stale", app)
+	}
+	if fedora.InBuild == nil || !*fedora.InBuild || fedora.InRun != nil {
+		t.Errorf("fedora presence = %v, %v; want docker yes, podman unavailable", fedora.InBuild, fedora.InRun)
+	}
+
+	var out bytes.Buffer
+	printImageTable(&out, statuses, rt)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("table has %d lines, want 3:\n%s", len(lines), out.String())
+	}
+	for i, want := range [][]string{
+		{"IMAGE", "TAG", "DOCKER", "PODMAN", "SIZE", "STATUS"},
+		{"fedora", "2026.1", "yes", "-", "3.0 MB", "fresh"},
+		{"app", "latest", "yes", "-", "3.0 MB", "stale"},
+	} {
+		if got := strings.Fields(lines[i]); strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("line %d = %q, want columns %v", i, lines[i], want)
+		}
+	}
+	if strings.Index(lines[0], "STATUS") != strings.Index(lines[1], "fresh") {
+		t.Errorf("columns not aligned:\n%s", out.String())
+	}
+}
+
+func TestCollectImageStatusMissing(t *testing.T) {
+	origLook, origExists := exec_LookPath, LocalImageExists
+	defer func() { exec_LookPath, LocalImageExists = origLook, origExists }()
+	exec_LookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	LocalImageExists = func(engine, ref string) bool { return false }
+
+	gen := newDigestGenerator(t, t.TempDir(), "2026.2", time.Time{})
+	rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "docker"}
+	statuses, err := collectImageStatus(gen, &BuildState{Images: map[string]BuildStateEntry{}}, rt)
+	if err != nil {
+		t.Fatalf("collectImageStatus() error = %v", err)
+	}
+	for _, s := range statuses {
+		if s.Status != "missing" || s.InBuild == nil || *s.InBuild || s.Size != 0 {
+			t.Errorf("%s = %+v, want missing from an available engine", s.Name, s)
+		}
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"container/heap"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"strings"
 )
 
@@ -10,6 +16,36 @@ type trieNode struct {
 	layer    string                // layer at this position ("" for root)
 	children map[string]*trieNode // layer name → child node
 	images   []string             // user-defined images terminating here
+
+	// Recorded by walkTrieScoped at the end of each collapsed chain (for ov intermediates)
+	chain        []string // layers from the enclosing recorded node to here
+	parent       string   // image (or external base) the node builds on
+	intermediate string   // image created or reused here
+	decision     string   // planAuto, planReused, planBelowThreshold or "" (leaf)
+}
+
+// Decisions recorded on trie nodes at branch points
+const (
+	planAuto           = "auto"            // an auto intermediate was created
+	planReused         = "reused"          // a user image serves as the intermediate
+	planBelowThreshold = "below-threshold" // intermediates: thresholds not met
+)
+
+// siblingGroup is the trie of one sibling group, as walked by ComputeIntermediates
+type siblingGroup struct {
+	parent   string
+	sequence []string // absolute layer sequence of parent (empty for external bases)
+	images   []string
+	root     *trieNode
+}
+
+// allImages returns the images terminating at this node or below it
+func (n *trieNode) allImages() []string {
+	images := append([]string{}, n.images...)
+	for _, key := range sortedKeys(n.children) {
+		images = append(images, n.children[key].allImages()...)
+	}
+	return images
 }
 
 func newTrieNode(layer string) *trieNode {
@@ -21,54 +57,182 @@ func newTrieNode(layer string) *trieNode {
 
 // GlobalLayerOrder computes a global topological order of all layers across
 // all enabled images, using popularity (number of images needing each layer)
-// as the primary tie-breaker and lexicographic as secondary.
+// as the primary tie-breaker and lexicographic as secondary. Images excluded
+// from intermediates (see intermediateExclusions) don't count towards popularity.
 func GlobalLayerOrder(images map[string]*ResolvedImage, layers map[string]*Layer) ([]string, error) {
-	// Count popularity: how many images need each layer (including transitive deps)
-	popularity := make(map[string]int)
-	for _, img := range images {
+	return globalLayerOrder(images, layers, intermediateExclusions(images, ""), nil)
+}
+
+// globalLayerOrder implements GlobalLayerOrder, ignoring the popularity of the
+// excluded images. With weights (see layerWeights) the popularity of each
+// layer is multiplied by its weight, so that big shared layers come first.
+func globalLayerOrder(images map[string]*ResolvedImage, layers map[string]*Layer, excluded map[string]bool, weights map[string]int) ([]string, error) {
+	// Resolve the own layers of every image once
+	own := make(map[string][]string, len(images))
+	for name, img := range images {
 		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
 		if err != nil {
 			return nil, fmt.Errorf("resolving layers for image %q: %w", img.Name, err)
 		}
-		// Also include layers from the base chain
-		allLayers := collectAllImageLayers(img.Name, images, layers)
-		// Merge resolved with allLayers
-		seen := make(map[string]bool)
-		for _, l := range allLayers {
+		own[name] = resolved
+	}
+
+	// All layers of an image (base chain first), memoized so that each chain is
+	// walked once
+	chains := make(map[string][]string, len(images))
+	var chainOf func(name string) []string
+	chainOf = func(name string) []string {
+		if chain, ok := chains[name]; ok {
+			return chain
+		}
+		chains[name] = nil // guards against base cycles
+		img := images[name]
+		var chain []string
+		if !img.IsExternalBase {
+			if _, ok := images[img.Base]; ok {
+				chain = append(chain, chainOf(img.Base)...)
+			}
+		}
+		seen := make(map[string]bool, len(chain))
+		for _, l := range chain {
 			seen[l] = true
 		}
-		for _, l := range resolved {
+		for _, l := range own[name] {
 			if !seen[l] {
-				allLayers = append(allLayers, l)
 				seen[l] = true
+				chain = append(chain, l)
+			}
+		}
+		chains[name] = chain
+		return chain
+	}
+
+	// Count popularity: how many images need each layer (including the base chain).
+	// Layers of excluded images are ordered too, but with no popularity of their own.
+	popularity := make(map[string]int)
+	inUse := make(map[string]bool)
+	for name := range images {
+		for _, l := range chainOf(name) {
+			inUse[l] = true
+			if !excluded[name] {
+				popularity[l]++
 			}
 		}
-		for _, l := range allLayers {
-			popularity[l]++
+	}
+
+	if weights != nil {
+		for l := range popularity {
+			popularity[l] *= weights[l]
 		}
 	}
 
-	// Build dependency graph from layer depends
-	// Only include layers that appear in at least one image
+	// Build dependency graph from layer depends and after. Only include layers
+	// that appear in at least one image, and only edges between layers of the
+	// same image chain: an after (or a capability provider) in another image
+	// doesn't order anything.
+	providers := capabilityProviders(layers)
 	graph := make(map[string][]string)
-	for name := range popularity {
-		layer, ok := layers[name]
-		if !ok {
-			continue
+	edges := make(map[[2]string]bool)
+	for name := range images {
+		chain := chainOf(name)
+		inChain := make(map[string]bool, len(chain))
+		for _, l := range chain {
+			inChain[l] = true
 		}
-		var deps []string
-		for _, dep := range layer.Depends {
-			if _, inUse := popularity[dep]; inUse {
-				deps = append(deps, dep)
+		for _, l := range chain {
+			layer, ok := layers[l]
+			if !ok {
+				continue
+			}
+			if _, ok := graph[l]; !ok {
+				graph[l] = nil
+			}
+			for _, dep := range layer.orderDeps(inChain, providers) {
+				if !edges[[2]string{l, dep}] {
+					edges[[2]string{l, dep}] = true
+					graph[l] = append(graph[l], dep)
+				}
 			}
 		}
-		graph[name] = deps
 	}
 
 	// Kahn's algorithm with popularity-based tie-breaking
 	return topoSortByPopularity(graph, popularity)
 }
 
+// layerWeights returns the estimated size in KB (at least 1) of every layer
+// for intermediates.order_by: size, or nil for the default popularity order
+func layerWeights(layers map[string]*Layer, cfg *Config) (map[string]int, error) {
+	if cfg.Intermediates.OrderBy != OrderBySize {
+		return nil, nil
+	}
+	packageMB := cfg.Intermediates.PackageSizeMB
+	if packageMB == 0 {
+		packageMB = DefaultPackageSizeMB
+	}
+	weights := make(map[string]int, len(layers))
+	for _, name := range LayerNames(layers) {
+		kb, err := layers[name].EstimatedSizeKB(packageMB)
+		if err != nil {
+			return nil, fmt.Errorf("estimating the size of layer %q: %w", name, err)
+		}
+		weights[name] = max(kb, 1)
+	}
+	return weights, nil
+}
+
+// EstimatedSizeKB returns the installed size of the layer: size_hint_mb from
+// layer.yml, or the size of its directory (without ignored files) plus
+// packageMB per rpm, deb or apk package (the longest of the lists, since an
+// image installs only one of them)
+func (l *Layer) EstimatedSizeKB(packageMB int) (int, error) {
+	if l.sizeHintMB > 0 {
+		return l.sizeHintMB * 1024, nil
+	}
+	if l.dirSize == 0 && l.Path != "" {
+		err := filepath.WalkDir(l.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(l.Path, path)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if d.IsDir() {
+				if rel != "." && l.ignore.Ignored(rel, true) {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !d.Type().IsRegular() || l.ignore.Ignored(rel, false) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			l.dirSize += info.Size()
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return 0, err
+		}
+	}
+
+	packages := 0
+	if l.rpmConfig != nil {
+		packages = max(packages, len(l.rpmConfig.Packages))
+	}
+	if l.debConfig != nil {
+		packages = max(packages, len(l.debConfig.Packages))
+	}
+	if l.apkConfig != nil {
+		packages = max(packages, len(l.apkConfig.Packages))
+	}
+	return int(l.dirSize/1024) + packages*packageMB*1024, nil
+}
+
 // topoSortByPopularity performs topological sort with popularity tie-breaking.
 // Higher popularity layers come first among zero-in-degree candidates.
 func topoSortByPopularity(graph map[string][]string, popularity map[string]int) ([]string, error) {
@@ -83,46 +247,59 @@ func topoSortByPopularity(graph map[string][]string, popularity map[string]int)
 	}
 
 	// Find all nodes with no dependencies
-	var queue []string
+	queue := &popularityQueue{popularity: popularity}
 	for node, degree := range inDegree {
 		if degree == 0 {
-			queue = append(queue, node)
+			queue.names = append(queue.names, node)
 		}
 	}
-	sortByPopularity(queue, popularity)
+	heap.Init(queue)
 
 	var result []string
-	for len(queue) > 0 {
-		node := queue[0]
-		queue = queue[1:]
+	for queue.Len() > 0 {
+		node := heap.Pop(queue).(string)
 		result = append(result, node)
 
 		dependents := reverseGraph[node]
 		for _, dep := range dependents {
 			inDegree[dep]--
 			if inDegree[dep] == 0 {
-				queue = append(queue, dep)
+				heap.Push(queue, dep)
 			}
 		}
-		sortByPopularity(queue, popularity)
 	}
 
 	if len(result) != len(graph) {
-		return nil, fmt.Errorf("cycle detected in layer dependency graph")
+		return nil, &CycleError{Cycle: findCycle(graph, inDegree), Blocked: blockedNodes(inDegree)}
 	}
 	return result, nil
 }
 
-// sortByPopularity sorts by descending popularity, then lexicographic ascending.
-func sortByPopularity(s []string, popularity map[string]int) {
-	for i := 0; i < len(s)-1; i++ {
-		for j := i + 1; j < len(s); j++ {
-			pi, pj := popularity[s[i]], popularity[s[j]]
-			if pi < pj || (pi == pj && s[i] > s[j]) {
-				s[i], s[j] = s[j], s[i]
-			}
-		}
+// popularityQueue is a heap of layer names ordered by descending popularity,
+// then lexicographic ascending
+type popularityQueue struct {
+	names      []string
+	popularity map[string]int
+}
+
+func (q *popularityQueue) Len() int { return len(q.names) }
+
+func (q *popularityQueue) Less(i, j int) bool {
+	pi, pj := q.popularity[q.names[i]], q.popularity[q.names[j]]
+	if pi != pj {
+		return pi > pj
 	}
+	return q.names[i] < q.names[j]
+}
+
+func (q *popularityQueue) Swap(i, j int) { q.names[i], q.names[j] = q.names[j], q.names[i] }
+
+func (q *popularityQueue) Push(x any) { q.names = append(q.names, x.(string)) }
+
+func (q *popularityQueue) Pop() any {
+	last := q.names[len(q.names)-1]
+	q.names = q.names[:len(q.names)-1]
+	return last
 }
 
 // collectAllImageLayers returns the complete set of layers for an image,
@@ -177,11 +354,30 @@ func AbsoluteLayerSequence(imageName string, images map[string]*ResolvedImage, l
 // ComputeIntermediates analyzes all images, groups them by direct parent (Base),
 // builds prefix tries of relative layer sequences within each sibling group,
 // creates intermediates at branching points, and returns updated images map.
-// User-defined images always take priority over auto-intermediates.
+// User-defined images always take priority over auto-intermediates. With
+// intermediates.enabled: false the images are returned unchanged.
 func ComputeIntermediates(images map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string) (map[string]*ResolvedImage, error) {
-	globalOrder, err := GlobalLayerOrder(images, layers)
+	result, _, err := computeIntermediates(images, layers, cfg, tag)
+	return result, err
+}
+
+// computeIntermediates implements ComputeIntermediates and also returns the
+// walked trie of every sibling group, in processing order
+func computeIntermediates(images map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string) (map[string]*ResolvedImage, []*siblingGroup, error) {
+	// Pinned intermediates first: the trie walk treats them as user images
+	images, _, err := applyPinnedIntermediates(images, layers, cfg, tag)
+	if err != nil {
+		return nil, nil, fmt.Errorf("applying pinned intermediates: %w", err)
+	}
+
+	excluded := intermediateExclusions(images, cfg.Defaults.Builder)
+	weights, err := layerWeights(layers, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	globalOrder, err := globalLayerOrder(images, layers, excluded, weights)
 	if err != nil {
-		return nil, fmt.Errorf("computing global layer order: %w", err)
+		return nil, nil, fmt.Errorf("computing global layer order: %w", err)
 	}
 
 	// Copy all existing images
@@ -190,13 +386,15 @@ func ComputeIntermediates(images map[string]*ResolvedImage, layers map[string]*L
 		cp := *img
 		result[name] = &cp
 	}
+	if !cfg.Intermediates.IsEnabled() {
+		return result, nil, nil
+	}
 
-	builderName := cfg.Defaults.Builder
-
-	// Group images by their direct parent (Base field)
+	// Group images by their direct parent (Base field), leaving out excluded
+	// images so that their base never changes
 	siblingGroups := make(map[string][]string)
 	for name, img := range images {
-		if name == builderName {
+		if excluded[name] {
 			continue
 		}
 		siblingGroups[img.Base] = append(siblingGroups[img.Base], name)
@@ -206,9 +404,10 @@ func ComputeIntermediates(images map[string]*ResolvedImage, layers map[string]*L
 	// so auto-intermediates from parent groups are visible when processing child groups
 	imageOrder, err := ResolveImageOrder(images, layers)
 	if err != nil {
-		return nil, fmt.Errorf("resolving image order: %w", err)
+		return nil, nil, fmt.Errorf("resolving image order: %w", err)
 	}
 
+	var groups []*siblingGroup
 	processed := make(map[string]bool)
 	for _, parentName := range imageOrder {
 		children := siblingGroups[parentName]
@@ -216,27 +415,196 @@ func ComputeIntermediates(images map[string]*ResolvedImage, layers map[string]*L
 			continue
 		}
 		processed[parentName] = true
-		if err := processSiblingGroup(parentName, children, result, images, layers, cfg, tag, globalOrder); err != nil {
-			return nil, err
+		parentSequence := AbsoluteLayerSequence(parentName, result, layers, globalOrder)
+		root, err := processSiblingGroup(parentName, children, result, images, layers, cfg, tag, globalOrder)
+		if err != nil {
+			return nil, nil, err
 		}
+		groups = append(groups, &siblingGroup{parent: parentName, sequence: parentSequence, images: children, root: root})
 	}
 
-	// Process external-base groups (parent is an external OCI ref, not in imageOrder)
-	for parentBase, children := range siblingGroups {
+	// Process external-base groups (parent is an external OCI ref, not in imageOrder),
+	// sorted so that auto-intermediate names don't depend on map order
+	var externalBases []string
+	for parentBase := range siblingGroups {
+		externalBases = append(externalBases, parentBase)
+	}
+	sortStrings(externalBases)
+	for _, parentBase := range externalBases {
+		children := siblingGroups[parentBase]
 		if processed[parentBase] || len(children) < 2 {
 			continue
 		}
-		if err := processSiblingGroup(parentBase, children, result, images, layers, cfg, tag, globalOrder); err != nil {
-			return nil, err
+		parentSequence := AbsoluteLayerSequence(parentBase, result, layers, globalOrder)
+		root, err := processSiblingGroup(parentBase, children, result, images, layers, cfg, tag, globalOrder)
+		if err != nil {
+			return nil, nil, err
 		}
+		groups = append(groups, &siblingGroup{parent: parentBase, sequence: parentSequence, images: children, root: root})
 	}
 
-	return result, nil
+	return result, groups, nil
+}
+
+// intermediateExclusions returns the images ComputeIntermediates leaves alone:
+// builder images (builder, plus every image named as a builder), which an
+// intermediate built with that builder would otherwise depend on, and images
+// with exclude_from_intermediates
+func intermediateExclusions(images map[string]*ResolvedImage, builder string) map[string]bool {
+	excluded := make(map[string]bool)
+	if _, ok := images[builder]; ok {
+		excluded[builder] = true
+	}
+	for name, img := range images {
+		if img.NoIntermediates {
+			excluded[name] = true
+		}
+		if _, ok := images[img.Builder]; ok {
+			excluded[img.Builder] = true
+		}
+	}
+	return excluded
+}
+
+// applyPinnedIntermediates returns a copy of images with the intermediates.pinned
+// images added, and every image whose absolute layer sequence starts with the
+// layers of a pinned intermediate rebased onto it. The second result lists the
+// images rebased onto each pinned intermediate. Pinned intermediates are applied
+// fewest layers first, so one containing the layers of another (same base)
+// builds on it.
+func applyPinnedIntermediates(images map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string) (map[string]*ResolvedImage, map[string][]string, error) {
+	result := make(map[string]*ResolvedImage, len(images))
+	for name, img := range images {
+		cp := *img
+		result[name] = &cp
+	}
+	users := make(map[string][]string)
+	if len(cfg.Intermediates.Pinned) == 0 {
+		return result, users, nil
+	}
+
+	excluded := intermediateExclusions(images, cfg.Defaults.Builder)
+	weights, err := layerWeights(layers, cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	globalOrder, err := globalLayerOrder(images, layers, excluded, weights)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Layer sets with dependencies, smallest first
+	sets := make(map[string]map[string]bool)
+	var names []string
+	for name, pin := range cfg.Intermediates.Pinned {
+		set := make(map[string]bool)
+		for _, l := range pin.Layers {
+			set[l] = true
+			addTransitiveDeps(l, layers, set, nil)
+		}
+		sets[name] = set
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for i := 1; i < len(names); i++ {
+		for j := i; j > 0 && len(sets[names[j-1]]) > len(sets[names[j]]); j-- {
+			names[j-1], names[j] = names[j], names[j-1]
+		}
+	}
+
+	bases := make(map[string]string)
+	for i, name := range names {
+		pin := cfg.Intermediates.Pinned[name]
+		base := pin.Base
+		if base == "" {
+			base = cfg.Defaults.Base
+		}
+		if base == "" {
+			base = DefaultBase
+		}
+		bases[name] = base
+
+		// Build on the largest pinned intermediate this one contains
+		parentName := base
+		for _, other := range names[:i] {
+			if bases[other] == base && isSubset(sets[other], sets[name]) {
+				parentName = other
+			}
+		}
+
+		need := make(map[string]bool)
+		if _, ok := result[parentName]; ok {
+			provided, err := LayersProvidedByImage(parentName, result, layers)
+			if err != nil {
+				return nil, nil, err
+			}
+			for l := range provided {
+				need[l] = true
+			}
+		}
+		for l := range sets[name] {
+			need[l] = true
+		}
+
+		for _, imgName := range sortedImageNames(result) {
+			img := result[imgName]
+			if img.Base != parentName || img.Pinned || excluded[imgName] {
+				continue
+			}
+			seq := AbsoluteLayerSequence(imgName, result, layers, globalOrder)
+			if len(seq) < len(need) {
+				continue
+			}
+			startsWith := true
+			for _, l := range seq[:len(need)] {
+				if !need[l] {
+					startsWith = false
+					break
+				}
+			}
+			if startsWith {
+				users[name] = append(users[name], imgName)
+			}
+		}
+
+		pathLayers, err := ResolveLayerOrder(pin.Layers, layers, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("pinned intermediate %q: %w", name, err)
+		}
+		createIntermediate(name, parentName, pathLayers, users[name], result, images, cfg, tag, layers, globalOrder)
+		result[name].Auto = false
+		result[name].Pinned = true
+		for _, imgName := range users[name] {
+			updateImageBase(imgName, name, result)
+		}
+	}
+	return result, users, nil
+}
+
+// isSubset reports whether every key of a is in b
+func isSubset(a, b map[string]bool) bool {
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedImageNames returns the names of images, sorted
+func sortedImageNames(images map[string]*ResolvedImage) []string {
+	names := make([]string, 0, len(images))
+	for name := range images {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	return names
 }
 
 // processSiblingGroup builds a prefix trie from the relative layer sequences
 // of children sharing the same parent, and creates intermediates at branch points.
-func processSiblingGroup(parentName string, children []string, result, origImages map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string, globalOrder []string) error {
+// It returns the walked trie.
+func processSiblingGroup(parentName string, children []string, result, origImages map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string, globalOrder []string) (*trieNode, error) {
 	sortStrings(children)
 
 	// Get layers provided by parent
@@ -264,7 +632,7 @@ func processSiblingGroup(parentName string, children []string, result, origImage
 		node.images = append(node.images, childName)
 	}
 
-	return walkTrieScoped(root, parentName, result, origImages, layers, cfg, tag, globalOrder)
+	return root, walkTrieScoped(root, parentName, nil, result, origImages, layers, cfg, tag, globalOrder)
 }
 
 // relativeLayerSequence returns an image's layers minus what the parent provides,
@@ -287,12 +655,14 @@ func relativeLayerSequence(imageName string, parentProvided map[string]bool, ima
 
 // walkTrieScoped walks the trie creating intermediates at branch points.
 // User-defined images at branch points are reused as intermediates without rebasing.
-func walkTrieScoped(node *trieNode, parentName string, result map[string]*ResolvedImage, origImages map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string, globalOrder []string) error {
+// Branch points below the intermediates: thresholds are skipped: their images
+// stay on parentName and pending carries the skipped layers to the next branch.
+func walkTrieScoped(node *trieNode, parentName string, pending []string, result map[string]*ResolvedImage, origImages map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string, globalOrder []string) error {
 	for _, childLayerName := range sortedKeys(node.children) {
 		child := node.children[childLayerName]
 
 		// Collect linear chain: walk as long as exactly one child and no terminal images
-		var pathLayers []string
+		pathLayers := append([]string{}, pending...)
 		current := child
 		pathLayers = append(pathLayers, childLayerName)
 
@@ -303,6 +673,9 @@ func walkTrieScoped(node *trieNode, parentName string, result map[string]*Resolv
 			}
 		}
 
+		current.chain = pathLayers[len(pending):]
+		current.parent = parentName
+
 		// current is at a branch point, leaf, or has terminal images
 		isBranch := len(current.children) >= 2 || (len(current.children) >= 1 && len(current.images) > 0)
 		isLeaf := len(current.children) == 0
@@ -316,21 +689,33 @@ func walkTrieScoped(node *trieNode, parentName string, result map[string]*Resolv
 				}
 			}
 
-			if len(userImages) == 1 && len(current.images) == 1 {
-				// Single user image at branch: use it as intermediate, preserve its Base
+			if len(userImages) == 1 && len(current.images) == 1 && isExistingImageReusable(userImages[0], parentName, pathLayers, result, layers) {
+				// Single user image at branch with exactly the branch's layers:
+				// use it as intermediate, preserve its Base
 				intermediateName := userImages[0]
-				if err := walkTrieScoped(current, intermediateName, result, origImages, layers, cfg, tag, globalOrder); err != nil {
+				current.intermediate, current.decision = intermediateName, planReused
+				if err := walkTrieScoped(current, intermediateName, nil, result, origImages, layers, cfg, tag, globalOrder); err != nil {
+					return err
+				}
+			} else if !cfg.Intermediates.allows(pathLayers, current.allImages()) {
+				// Shared prefix too small for an intermediate: images here stay on the parent
+				current.decision = planBelowThreshold
+				for _, imgName := range current.images {
+					updateImageBase(imgName, parentName, result)
+				}
+				if err := walkTrieScoped(current, parentName, pathLayers, result, origImages, layers, cfg, tag, globalOrder); err != nil {
 					return err
 				}
 			} else {
-				// 0 or 2+ user images: create auto-intermediate
-				intermediateName := pickAutoName(pathLayers, parentName, result, origImages)
-				createIntermediate(intermediateName, parentName, pathLayers, result, origImages, cfg, tag, layers, globalOrder)
+				// 0 or 2+ user images, or one whose layers differ: create auto-intermediate
+				intermediateName := pickAutoName(pathLayers, parentName, &cfg.Defaults, externalBaseNames(origImages, cfg), result, origImages)
+				createIntermediate(intermediateName, parentName, pathLayers, current.allImages(), result, origImages, cfg, tag, layers, globalOrder)
+				current.intermediate, current.decision = intermediateName, planAuto
 				// Rebase all terminal images to this intermediate
 				for _, imgName := range current.images {
 					updateImageBase(imgName, intermediateName, result)
 				}
-				if err := walkTrieScoped(current, intermediateName, result, origImages, layers, cfg, tag, globalOrder); err != nil {
+				if err := walkTrieScoped(current, intermediateName, nil, result, origImages, layers, cfg, tag, globalOrder); err != nil {
 					return err
 				}
 			}
@@ -344,37 +729,157 @@ func walkTrieScoped(node *trieNode, parentName string, result map[string]*Resolv
 	return nil
 }
 
-// pickAutoName chooses a name for an auto-intermediate using {parent}-{lastLayer}.
-// For OCI refs (e.g. "quay.io/fedora/fedora:43"), extracts the short image name.
-// Appends -2, -3 etc. to avoid conflicts with existing or already-created images.
-func pickAutoName(pathLayers []string, parentName string, result, origImages map[string]*ResolvedImage) string {
-	lastLayer := pathLayers[len(pathLayers)-1]
+// isExistingImageReusable reports whether the user-defined image imgName can
+// serve as the intermediate at a trie position: its layer set must equal the
+// set the position implies (the layers parentName provides plus pathLayers),
+// transitive dependencies included on both sides. An image carrying extra
+// layers would otherwise leak them into every image rebased onto it.
+func isExistingImageReusable(imgName, parentName string, pathLayers []string, result map[string]*ResolvedImage, layers map[string]*Layer) bool {
+	implied := make(map[string]bool)
+	if _, ok := result[parentName]; ok {
+		provided, err := LayersProvidedByImage(parentName, result, layers)
+		if err != nil {
+			return false
+		}
+		for l := range provided {
+			implied[l] = true
+		}
+	}
+	for _, l := range pathLayers {
+		implied[l] = true
+	}
+
+	actual, err := LayersProvidedByImage(imgName, result, layers)
+	if err != nil {
+		return false
+	}
 
-	// Extract short parent name from OCI refs: "quay.io/fedora/fedora:43" → "fedora"
-	shortParent := parentName
-	if i := strings.LastIndex(shortParent, ":"); i >= 0 {
-		shortParent = shortParent[:i]
+	for _, set := range []map[string]bool{implied, actual} {
+		var explicit []string
+		for l := range set {
+			explicit = append(explicit, l)
+		}
+		for _, l := range explicit {
+			addTransitiveDeps(l, layers, set, nil)
+		}
 	}
-	if i := strings.LastIndex(shortParent, "/"); i >= 0 {
-		shortParent = shortParent[i+1:]
+	if len(implied) != len(actual) {
+		return false
 	}
+	for l := range implied {
+		if !actual[l] {
+			return false
+		}
+	}
+	return true
+}
 
-	baseName := shortParent + "-" + lastLayer
+// Naming schemes for auto intermediates (defaults.intermediate_names)
+const (
+	IntermediateNamesLastLayer = "last-layer" // {parent}-{last layer} (default)
+	IntermediateNamesPathHash  = "path-hash"  // {parent}-{hash of the layer sequence}
+	IntermediateNamesJoined    = "joined"     // {parent}-{first layer}-{last layer}
+)
+
+// pickAutoName chooses a name for an auto-intermediate, defaults.intermediate_prefix
+// followed by {parent}-{suffix}, where the suffix follows defaults.intermediate_names
+// (the last layer by default). For OCI refs (e.g. "quay.io/fedora/fedora:43"),
+// uses baseNames (see externalBaseNames) or extracts the short image name; a
+// parent that is itself an auto-intermediate loses the prefix. Appends -2, -3
+// etc. to avoid conflicts with existing or already-created images. Names only
+// depend on the layer sequence, so they are stable across runs.
+func pickAutoName(pathLayers []string, parentName string, defaults *ImageConfig, baseNames map[string]string, result, origImages map[string]*ResolvedImage) string {
+	firstLayer := layerStageName(pathLayers[0])
+	lastLayer := layerStageName(pathLayers[len(pathLayers)-1])
+
+	shortParent, ok := baseNames[parentName]
+	if !ok {
+		shortParent = shortBaseName(parentName)
+	}
+	if parent, ok := result[parentName]; ok && parent.Auto {
+		shortParent = strings.TrimPrefix(shortParent, defaults.AutoPrefix)
+	}
+
+	suffix := lastLayer
+	switch defaults.AutoNaming {
+	case IntermediateNamesPathHash:
+		sum := sha256.Sum256([]byte(strings.Join(pathLayers, "\n")))
+		suffix = hex.EncodeToString(sum[:])[:8]
+	case IntermediateNamesJoined:
+		if len(pathLayers) > 1 {
+			suffix = firstLayer + "-" + lastLayer
+		}
+	}
+
+	baseName := defaults.AutoPrefix + shortParent + "-" + suffix
 	name := baseName
-	suffix := 2
+	n := 2
 	for {
 		if _, exists := origImages[name]; !exists {
 			if _, exists := result[name]; !exists {
 				return name
 			}
 		}
-		name = fmt.Sprintf("%s-%d", baseName, suffix)
-		suffix++
+		name = fmt.Sprintf("%s-%d", baseName, n)
+		n++
+	}
+}
+
+// shortBaseName extracts the short image name from an OCI ref:
+// "quay.io/fedora/fedora:43" → "fedora"
+func shortBaseName(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i >= 0 {
+		ref = ref[:i]
+	}
+	if i := strings.LastIndex(ref, "/"); i >= 0 {
+		ref = ref[i+1:]
+	}
+	return ref
+}
+
+// externalBaseNames returns the name of each external base of images in auto
+// intermediate names: intermediates.base_names, else the short image name,
+// followed by the first 6 hex digits of the sha256 of the ref when another
+// external base has the same short name (fedora:42 and fedora:43). Names thus
+// depend only on the bases in use, not on the order groups are processed in.
+func externalBaseNames(images map[string]*ResolvedImage, cfg *Config) map[string]string {
+	bases := make(map[string]bool)
+	refs := make(map[string]int) // short name -> number of bases
+	for _, img := range images {
+		if img.IsExternalBase && !bases[img.Base] {
+			bases[img.Base] = true
+			refs[shortBaseName(img.Base)]++
+		}
+	}
+	names := make(map[string]string, len(bases))
+	for base := range bases {
+		if name, ok := cfg.Intermediates.BaseNames[base]; ok {
+			names[base] = name
+		} else if short := shortBaseName(base); refs[short] > 1 {
+			sum := sha256.Sum256([]byte(base))
+			names[base] = short + "-" + hex.EncodeToString(sum[:])[:6]
+		} else {
+			names[base] = short
+		}
 	}
+	return names
+}
+
+// baseGroup returns the external base at the root of the base chain of name
+func baseGroup(name string, images map[string]*ResolvedImage) string {
+	for i := 0; i <= len(images); i++ { // bounded in case of a base cycle
+		img, ok := images[name]
+		if !ok {
+			break
+		}
+		name = img.Base
+	}
+	return name
 }
 
 // createIntermediate creates an auto-generated intermediate image in the result map.
-func createIntermediate(name, parentName string, pathLayers []string, result map[string]*ResolvedImage, origImages map[string]*ResolvedImage, cfg *Config, tag string, layers map[string]*Layer, globalOrder []string) {
+// branchImages are the images that will build on it (directly or further down).
+func createIntermediate(name, parentName string, pathLayers []string, branchImages []string, result map[string]*ResolvedImage, origImages map[string]*ResolvedImage, cfg *Config, tag string, layers map[string]*Layer, globalOrder []string) {
 	ownLayers := computeOwnLayers(parentName, pathLayers, result, layers, globalOrder)
 
 	isExternalBase := false
@@ -382,10 +887,11 @@ func createIntermediate(name, parentName string, pathLayers []string, result map
 		isExternalBase = true
 	}
 
-	platforms := resolvePlatforms(cfg)
+	platforms := appendChildPlatforms(resolvePlatforms(cfg), branchImages, result)
 	if parent, ok := result[parentName]; ok && len(parent.Platforms) > 0 {
 		platforms = intersectPlatforms(parent.Platforms, platforms)
 	}
+	platforms = branchPlatforms(platforms, branchImages, result)
 
 	img := &ResolvedImage{
 		Name:           name,
@@ -401,7 +907,9 @@ func createIntermediate(name, parentName string, pathLayers []string, result map
 		GID:            resolveIntPtr(cfg.Defaults.GID, nil, 1000),
 		Merge:          cfg.Defaults.Merge,
 		Builder:        cfg.Defaults.Builder,
+		Labels:         mergeLabels(cfg.Defaults.Labels, nil),
 		Auto:           true,
+		BaseGroup:      baseGroup(parentName, result),
 	}
 	if img.Pkg == "" {
 		img.Pkg = "rpm"
@@ -410,15 +918,97 @@ func createIntermediate(name, parentName string, pathLayers []string, result map
 		img.User = "user"
 	}
 	img.Home = fmt.Sprintf("/home/%s", img.User)
+	inheritBranchSettings(img, branchImages, result)
 	if img.Registry != "" {
 		img.FullTag = fmt.Sprintf("%s/%s:%s", img.Registry, name, tag)
 	} else {
 		img.FullTag = fmt.Sprintf("%s:%s", name, tag)
 	}
+	img.Tags = []string{img.FullTag}
 
 	result[name] = img
 }
 
+// inheritBranchSettings sets the registry, package format, user, uid/gid,
+// home and builder of an intermediate from the images building on it, so that
+// a shared base of deb images is a deb image too. Settings the images disagree
+// on keep their defaults and are recorded in BranchConflicts.
+func inheritBranchSettings(img *ResolvedImage, branchImages []string, images map[string]*ResolvedImage) {
+	var first *ResolvedImage
+	disagree := make(map[string]bool)
+	for _, name := range branchImages {
+		child, ok := images[name]
+		if !ok {
+			continue
+		}
+		if first == nil {
+			first = child
+			continue
+		}
+		disagree["registry"] = disagree["registry"] || child.Registry != first.Registry
+		disagree["pkg"] = disagree["pkg"] || child.Pkg != first.Pkg
+		disagree["user"] = disagree["user"] || child.User != first.User
+		disagree["uid"] = disagree["uid"] || child.UID != first.UID
+		disagree["gid"] = disagree["gid"] || child.GID != first.GID
+		disagree["home"] = disagree["home"] || child.Home != first.Home
+		disagree["builder"] = disagree["builder"] || child.Builder != first.Builder
+	}
+	if first == nil {
+		return
+	}
+
+	if !disagree["registry"] {
+		img.Registry = first.Registry
+	}
+	if !disagree["pkg"] && first.Pkg != "" {
+		img.Pkg = first.Pkg
+	}
+	if !disagree["user"] && first.User != "" {
+		img.User = first.User
+		img.Home = fmt.Sprintf("/home/%s", img.User)
+	}
+	if !disagree["uid"] {
+		img.UID = first.UID
+	}
+	if !disagree["gid"] {
+		img.GID = first.GID
+	}
+	if !disagree["home"] && !disagree["user"] && first.Home != "" {
+		img.Home = first.Home
+	}
+	if !disagree["builder"] {
+		img.Builder = first.Builder
+	}
+
+	for setting, differs := range disagree {
+		if differs {
+			img.BranchConflicts = append(img.BranchConflicts, setting)
+		}
+	}
+	sortStrings(img.BranchConflicts)
+}
+
+// appendChildPlatforms adds the platforms the images branching off an
+// intermediate build for that platforms lacks, in image order
+func appendChildPlatforms(platforms []string, branchImages []string, images map[string]*ResolvedImage) []string {
+	result := append([]string{}, platforms...)
+	seen := make(map[string]bool, len(platforms))
+	for _, p := range platforms {
+		seen[p] = true
+	}
+	for _, name := range branchImages {
+		if img, ok := images[name]; ok {
+			for _, p := range img.Platforms {
+				if !seen[p] {
+					seen[p] = true
+					result = append(result, p)
+				}
+			}
+		}
+	}
+	return result
+}
+
 // computeOwnLayers determines which layers an intermediate needs to install
 // (pathLayers minus what the parent already provides).
 func computeOwnLayers(parentName string, pathLayers []string, result map[string]*ResolvedImage, layers map[string]*Layer, globalOrder []string) []string {
@@ -494,6 +1084,30 @@ func resolvePlatforms(cfg *Config) []string {
 	return []string{"linux/amd64", "linux/arm64"}
 }
 
+// branchPlatforms narrows an intermediate's platforms to those at least one of
+// the images branching off it builds for, keeping the order of platforms.
+// Returns platforms unchanged if none of them is needed.
+func branchPlatforms(platforms []string, branchImages []string, images map[string]*ResolvedImage) []string {
+	needed := make(map[string]bool)
+	for _, name := range branchImages {
+		if img, ok := images[name]; ok {
+			for _, p := range img.Platforms {
+				needed[p] = true
+			}
+		}
+	}
+	var result []string
+	for _, p := range platforms {
+		if needed[p] {
+			result = append(result, p)
+		}
+	}
+	if len(result) == 0 {
+		return platforms
+	}
+	return result
+}
+
 // intersectPlatforms returns platforms present in both slices.
 // If the intersection is empty, returns parent (the more restrictive set).
 func intersectPlatforms(parent, defaults []string) []string {
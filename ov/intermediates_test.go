@@ -1,6 +1,11 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -66,6 +71,226 @@ func TestGlobalLayerOrder_RespectsDependencies(t *testing.T) {
 	}
 }
 
+func TestGlobalLayerOrder_ExcludedImages(t *testing.T) {
+	layers := map[string]*Layer{
+		"alpha": {Name: "alpha"},
+		"zeta":  {Name: "zeta"},
+	}
+	// zeta is needed by two images, but one is the builder of the other and
+	// the other is excluded, so alpha is the more popular layer
+	images := map[string]*ResolvedImage{
+		"app":     {Name: "app", Base: "ext:1", IsExternalBase: true, Layers: []string{"alpha"}, Builder: "builder"},
+		"builder": {Name: "builder", Base: "ext:1", IsExternalBase: true, Layers: []string{"zeta"}},
+		"tools":   {Name: "tools", Base: "ext:1", IsExternalBase: true, Layers: []string{"zeta"}, NoIntermediates: true},
+	}
+
+	order, err := GlobalLayerOrder(images, layers)
+	if err != nil {
+		t.Fatalf("GlobalLayerOrder() error = %v", err)
+	}
+	if !reflect.DeepEqual(order, []string{"alpha", "zeta"}) {
+		t.Errorf("GlobalLayerOrder() = %v, want [alpha zeta]", order)
+	}
+}
+
+func TestGlobalLayerOrder_OrderBySize(t *testing.T) {
+	layers := map[string]*Layer{
+		"completions": {Name: "completions", sizeHintMB: 1},
+		"cuda":        {Name: "cuda", sizeHintMB: 2000},
+	}
+	// Both layers are needed by one image
+	images := map[string]*ResolvedImage{
+		"a": {Name: "a", Base: "ext:1", IsExternalBase: true, Layers: []string{"completions"}},
+		"b": {Name: "b", Base: "ext:1", IsExternalBase: true, Layers: []string{"cuda"}},
+	}
+
+	for _, tt := range []struct {
+		orderBy string
+		want    []string
+	}{
+		{"", []string{"completions", "cuda"}},
+		{OrderByPopularity, []string{"completions", "cuda"}},
+		{OrderBySize, []string{"cuda", "completions"}},
+	} {
+		cfg := &Config{Intermediates: IntermediatesConfig{OrderBy: tt.orderBy}}
+		weights, err := layerWeights(layers, cfg)
+		if err != nil {
+			t.Fatalf("layerWeights() error = %v", err)
+		}
+		order, err := globalLayerOrder(images, layers, nil, weights)
+		if err != nil {
+			t.Fatalf("globalLayerOrder() error = %v", err)
+		}
+		if !reflect.DeepEqual(order, tt.want) {
+			t.Errorf("order_by %q: order = %v, want %v", tt.orderBy, order, tt.want)
+		}
+	}
+}
+
+func TestLayerEstimatedSizeKB(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "root.yml"), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	layer := &Layer{Name: "tools", Path: dir, rpmConfig: &RpmConfig{Packages: []string{"git", "jq"}}}
+	if got, err := layer.EstimatedSizeKB(10); err != nil || got != 4+2*10*1024 {
+		t.Errorf("EstimatedSizeKB(10) = %d, %v, want %d", got, err, 4+2*10*1024)
+	}
+
+	layer.sizeHintMB = 3
+	if got, _ := layer.EstimatedSizeKB(10); got != 3*1024 {
+		t.Errorf("EstimatedSizeKB(10) with size_hint_mb = %d, want %d", got, 3*1024)
+	}
+}
+
+func TestGlobalLayerOrder_Cycle(t *testing.T) {
+	// after edges only form a cycle across images
+	layers := map[string]*Layer{
+		"x": {Name: "x", After: []string{"z"}},
+		"y": {Name: "y", After: []string{"x"}},
+		"z": {Name: "z", After: []string{"y"}},
+	}
+	images := map[string]*ResolvedImage{
+		"a": {Name: "a", Base: "ext:1", IsExternalBase: true, Layers: []string{"x", "y"}},
+		"b": {Name: "b", Base: "ext:1", IsExternalBase: true, Layers: []string{"y", "z"}},
+		"c": {Name: "c", Base: "ext:1", IsExternalBase: true, Layers: []string{"z", "x"}},
+	}
+
+	_, err := GlobalLayerOrder(images, layers)
+	if _, ok := err.(*CycleError); !ok {
+		t.Fatalf("GlobalLayerOrder() error = %v, want CycleError", err)
+	}
+	if want := "circular dependency: x -> z -> y -> x (unresolved: x, y, z)"; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
+
+func TestGlobalLayerOrder_AfterInOtherImage(t *testing.T) {
+	// Layers never in the same image chain don't order each other: a and b are
+	// after each other, and b depends on cap, provided by p next to it and by q
+	// (which is after b) elsewhere
+	layers := map[string]*Layer{
+		"a": {Name: "a", After: []string{"b"}},
+		"b": {Name: "b", After: []string{"a"}, Depends: []string{"cap"}},
+		"p": {Name: "p", Provides: []string{"cap"}},
+		"q": {Name: "q", Provides: []string{"cap"}, After: []string{"b"}},
+	}
+	images := map[string]*ResolvedImage{
+		"one":   {Name: "one", Base: "ext:1", IsExternalBase: true, Layers: []string{"a"}},
+		"two":   {Name: "two", Base: "ext:1", IsExternalBase: true, Layers: []string{"p", "b"}},
+		"three": {Name: "three", Base: "ext:1", IsExternalBase: true, Layers: []string{"q"}},
+	}
+
+	order, err := GlobalLayerOrder(images, layers)
+	if err != nil {
+		t.Fatalf("GlobalLayerOrder() error = %v", err)
+	}
+	if want := []string{"a", "p", "b", "q"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("order = %v, want %v", order, want)
+	}
+
+	// In one chain, the after edges still count
+	images["two"].Base, images["two"].IsExternalBase = "one", false
+	if _, err := GlobalLayerOrder(images, layers); err == nil {
+		t.Error("GlobalLayerOrder() with a and b in one chain: want a cycle error")
+	}
+}
+
+func TestGlobalLayerOrder_Unchanged(t *testing.T) {
+	// Orders produced by the original bubble-sort implementation
+	realisticLayers := map[string]*Layer{
+		"pixi":            {Name: "pixi"},
+		"nodejs":          {Name: "nodejs"},
+		"python":          {Name: "python", Depends: []string{"pixi"}},
+		"supervisord":     {Name: "supervisord", Depends: []string{"python"}},
+		"build-toolchain": {Name: "build-toolchain"},
+		"testapi":         {Name: "testapi", Depends: []string{"supervisord"}},
+		"traefik":         {Name: "traefik", Depends: []string{"supervisord"}},
+		"openclaw":        {Name: "openclaw", Depends: []string{"supervisord", "nodejs"}},
+	}
+	realisticImages := map[string]*ResolvedImage{
+		"builder":     {Name: "builder", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{"pixi", "nodejs", "build-toolchain"}},
+		"fedora":      {Name: "fedora", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{}},
+		"fedora-test": {Name: "fedora-test", Base: "fedora", Layers: []string{"traefik", "testapi"}},
+		"openclaw":    {Name: "openclaw", Base: "fedora", Layers: []string{"openclaw"}},
+	}
+	tests := []struct {
+		name   string
+		layers map[string]*Layer
+		images map[string]*ResolvedImage
+		want   []string
+	}{
+		{"realistic", realisticLayers, realisticImages, []string{"pixi", "nodejs", "python", "supervisord", "build-toolchain", "openclaw", "testapi", "traefik"}},
+		{"synthetic", nil, nil, []string{
+			"layer000", "layer001", "layer003", "layer008", "layer011", "layer004", "layer002", "layer005",
+			"layer009", "layer010", "layer013", "layer019", "layer026", "layer020", "layer022", "layer007",
+			"layer014", "layer015", "layer016", "layer033", "layer018", "layer031", "layer037", "layer006",
+			"layer028", "layer025", "layer027", "layer035", "layer039", "layer012", "layer023", "layer024",
+			"layer034", "layer036", "layer017", "layer021", "layer029", "layer030",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers, images := tt.layers, tt.images
+			if layers == nil {
+				layers, images = syntheticLayerGraph(40, 12)
+			}
+			got, err := GlobalLayerOrder(images, layers)
+			if err != nil {
+				t.Fatalf("GlobalLayerOrder() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GlobalLayerOrder() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// syntheticLayerGraph builds a deterministic graph of n layers, each depending
+// on up to three earlier ones, and m images of eight layers each, every fourth
+// one built on an earlier image
+func syntheticLayerGraph(n, m int) (map[string]*Layer, map[string]*ResolvedImage) {
+	seed := uint32(1)
+	next := func(limit int) int {
+		seed = seed*1664525 + 1013904223
+		return int(seed>>8) % limit
+	}
+	layers := make(map[string]*Layer, n)
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("layer%03d", i)
+		layer := &Layer{Name: name}
+		if i > 0 {
+			for d := next(4); d > 0; d-- {
+				layer.Depends = append(layer.Depends, fmt.Sprintf("layer%03d", next(i)))
+			}
+		}
+		layers[name] = layer
+	}
+	images := make(map[string]*ResolvedImage, m)
+	for i := 0; i < m; i++ {
+		name := fmt.Sprintf("image%03d", i)
+		img := &ResolvedImage{Name: name, Base: "quay.io/fedora/fedora:43", IsExternalBase: true}
+		if i > 0 && i%4 == 0 {
+			img.Base, img.IsExternalBase = fmt.Sprintf("image%03d", next(i)), false
+		}
+		for l := 0; l < 8; l++ {
+			img.Layers = append(img.Layers, fmt.Sprintf("layer%03d", next(n)))
+		}
+		images[name] = img
+	}
+	return layers, images
+}
+
+func BenchmarkGlobalLayerOrder(b *testing.B) {
+	layers, images := syntheticLayerGraph(500, 100)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := GlobalLayerOrder(images, layers); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestAbsoluteLayerSequence_WithInternalBase(t *testing.T) {
 	layers := map[string]*Layer{
 		"pixi":    {Name: "pixi", Depends: nil},
@@ -315,6 +540,8 @@ func TestImageNeedsBuilder(t *testing.T) {
 		"python":  {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
 		"nodejs":  {Name: "nodejs", Depends: nil, HasRootYml: true},
 		"tooling": {Name: "tooling", Depends: nil, HasRootYml: true},
+		"piptool": {Name: "piptool", Depends: nil, HasRequirementsTxt: true},
+		"gotool":  {Name: "gotool", Depends: nil, HasGoMod: true, HasGoSources: true},
 	}
 
 	images := map[string]*ResolvedImage{
@@ -351,6 +578,24 @@ func TestImageNeedsBuilder(t *testing.T) {
 		t.Error("simple should not need builder (tooling has root.yml only)")
 	}
 
+	// requirements.txt is a pixi manifest → NEEDS builder
+	images["pip"] = &ResolvedImage{
+		Name: "pip", Base: "ext:1", IsExternalBase: true,
+		Layers: []string{"piptool"},
+	}
+	if !ImageNeedsBuilder(images["pip"], images, layers) {
+		t.Error("pip should need builder (piptool has requirements.txt)")
+	}
+
+	// go.mod → NEEDS builder
+	images["go"] = &ResolvedImage{
+		Name: "go", Base: "ext:1", IsExternalBase: true,
+		Layers: []string{"gotool"},
+	}
+	if !ImageNeedsBuilder(images["go"], images, layers) {
+		t.Error("go should need builder (gotool has go.mod)")
+	}
+
 	// nil layers → conservative true
 	if !ImageNeedsBuilder(images["simple"], images, nil) {
 		t.Error("nil layers should return true (conservative)")
@@ -442,6 +687,9 @@ func TestComputeIntermediates_RealisticConfig(t *testing.T) {
 	if builderIdx < 0 {
 		t.Fatal("builder not in build order")
 	}
+	if result["builder"].Base != images["builder"].Base {
+		t.Errorf("builder base = %q, want %q unchanged", result["builder"].Base, images["builder"].Base)
+	}
 
 	// Verify no cycles by checking builder comes early
 	fedoraIdx := indexOf("fedora")
@@ -835,6 +1083,273 @@ func TestComputeIntermediates_UserImageAsBranchIntermediate(t *testing.T) {
 	}
 }
 
+func TestWalkTrieScoped_ExistingImageWithExtraLayer(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasRootYml: true},
+		"nodejs": {Name: "nodejs", HasRootYml: true},
+		"extra":  {Name: "extra", HasRootYml: true},
+	}
+	newImages := func(toolsLayers []string) map[string]*ResolvedImage {
+		return map[string]*ResolvedImage{
+			"tools": {Name: "tools", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: toolsLayers, Tag: "v1", FullTag: "tools:v1", Pkg: "rpm"},
+			"py":    {Name: "py", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{"python"}, Tag: "v1", FullTag: "py:v1", Pkg: "rpm"},
+			"node":  {Name: "node", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{"pixi", "nodejs"}, Tag: "v1", FullTag: "node:v1", Pkg: "rpm"},
+		}
+	}
+	// tools sits at the branch point after pixi; built by hand, the trie
+	// position doesn't have to match the image's layers
+	newTrie := func() *trieNode {
+		root := newTrieNode("")
+		pixi := newTrieNode("pixi")
+		pixi.images = []string{"tools"}
+		pixi.children["python"] = newTrieNode("python")
+		pixi.children["python"].images = []string{"py"}
+		pixi.children["nodejs"] = newTrieNode("nodejs")
+		pixi.children["nodejs"].images = []string{"node"}
+		root.children["pixi"] = pixi
+		return root
+	}
+	cfg := &Config{Defaults: ImageConfig{Pkg: "rpm"}}
+	globalOrder := []string{"pixi", "extra", "nodejs", "python"}
+
+	// Matching layer set: tools is reused as the intermediate
+	orig := newImages([]string{"pixi"})
+	result := newImages([]string{"pixi"})
+	if err := walkTrieScoped(newTrie(), "quay.io/fedora/fedora:43", nil, result, orig, layers, cfg, "v1", globalOrder); err != nil {
+		t.Fatalf("walkTrieScoped() error = %v", err)
+	}
+	if result["py"].Base != "tools" || result["node"].Base != "tools" {
+		t.Errorf("bases = %q, %q, want tools reused", result["py"].Base, result["node"].Base)
+	}
+
+	// tools carries an extra layer: a separate intermediate takes its place
+	orig = newImages([]string{"pixi", "extra"})
+	result = newImages([]string{"pixi", "extra"})
+	if err := walkTrieScoped(newTrie(), "quay.io/fedora/fedora:43", nil, result, orig, layers, cfg, "v1", globalOrder); err != nil {
+		t.Fatalf("walkTrieScoped() error = %v", err)
+	}
+	inter, ok := result["fedora-pixi"]
+	if !ok || !inter.Auto {
+		t.Fatalf("expected auto-intermediate fedora-pixi, got %v", result)
+	}
+	if !reflect.DeepEqual(inter.Layers, []string{"pixi"}) {
+		t.Errorf("fedora-pixi layers = %v, want [pixi]", inter.Layers)
+	}
+	if result["py"].Base != "fedora-pixi" || result["node"].Base != "fedora-pixi" {
+		t.Errorf("bases = %q, %q, want fedora-pixi", result["py"].Base, result["node"].Base)
+	}
+	if !reflect.DeepEqual(result["tools"].Layers, []string{"pixi", "extra"}) {
+		t.Errorf("tools layers = %v, want its own layers untouched", result["tools"].Layers)
+	}
+}
+
+func TestPickAutoName(t *testing.T) {
+	pathLayers := []string{"pixi", "python", "supervisord"}
+	sum := sha256.Sum256([]byte("pixi\npython\nsupervisord"))
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	tests := []struct {
+		name     string
+		defaults ImageConfig
+		want     string
+	}{
+		{"last-layer default", ImageConfig{}, "fedora-supervisord"},
+		{"last-layer with prefix", ImageConfig{AutoPrefix: "ov-int-", AutoNaming: IntermediateNamesLastLayer}, "ov-int-fedora-supervisord"},
+		{"path-hash", ImageConfig{AutoNaming: IntermediateNamesPathHash}, "fedora-" + hash},
+		{"joined", ImageConfig{AutoPrefix: "ov-int-", AutoNaming: IntermediateNamesJoined}, "ov-int-fedora-pixi-supervisord"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for run := 0; run < 2; run++ {
+				got := pickAutoName(pathLayers, "quay.io/fedora/fedora:43", &tt.defaults, nil, map[string]*ResolvedImage{}, map[string]*ResolvedImage{})
+				if got != tt.want {
+					t.Errorf("pickAutoName() = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+
+	// A single layer path has nothing to join
+	joined := &ImageConfig{AutoNaming: IntermediateNamesJoined}
+	if got := pickAutoName([]string{"pixi"}, "fedora", joined, nil, map[string]*ResolvedImage{}, map[string]*ResolvedImage{}); got != "fedora-pixi" {
+		t.Errorf("pickAutoName(joined, one layer) = %q, want fedora-pixi", got)
+	}
+
+	// Collisions with user images and earlier intermediates get numeric suffixes
+	defaults := &ImageConfig{AutoPrefix: "ov-int-", AutoNaming: IntermediateNamesPathHash}
+	orig := map[string]*ResolvedImage{"ov-int-fedora-" + hash: {Name: "ov-int-fedora-" + hash}}
+	result := map[string]*ResolvedImage{"ov-int-fedora-" + hash + "-2": {Name: "ov-int-fedora-" + hash + "-2", Auto: true}}
+	if got, want := pickAutoName(pathLayers, "fedora", defaults, nil, result, orig), "ov-int-fedora-"+hash+"-3"; got != want {
+		t.Errorf("pickAutoName() with collisions = %q, want %q", got, want)
+	}
+
+	// The prefix isn't repeated for a parent that is an auto intermediate itself
+	prefixed := &ImageConfig{AutoPrefix: "ov-int-"}
+	result = map[string]*ResolvedImage{"ov-int-fedora-pixi": {Name: "ov-int-fedora-pixi", Auto: true}}
+	if got := pickAutoName([]string{"python"}, "ov-int-fedora-pixi", prefixed, nil, result, map[string]*ResolvedImage{}); got != "ov-int-fedora-pixi-python" {
+		t.Errorf("pickAutoName() below an auto parent = %q, want ov-int-fedora-pixi-python", got)
+	}
+}
+
+func TestComputeIntermediates_Thresholds(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasRootYml: true},
+		"nodejs": {Name: "nodejs", HasRootYml: true},
+		"rust":   {Name: "rust", HasRootYml: true},
+		"golang": {Name: "golang", HasRootYml: true},
+	}
+	const base = "quay.io/fedora/fedora:43"
+	newImages := func(imageLayers map[string][]string) map[string]*ResolvedImage {
+		images := make(map[string]*ResolvedImage)
+		for name, l := range imageLayers {
+			images[name] = &ResolvedImage{Name: name, Base: base, IsExternalBase: true, Layers: l, Tag: "v1", FullTag: name + ":v1", Pkg: "rpm"}
+		}
+		return images
+	}
+	autoNames := func(result map[string]*ResolvedImage) []string {
+		var names []string
+		for name, img := range result {
+			if img.Auto {
+				names = append(names, name)
+			}
+		}
+		sortStrings(names)
+		return names
+	}
+
+	// Two images sharing a single layer
+	twoImages := map[string][]string{
+		"app1": {"pixi", "nodejs"},
+		"app2": {"pixi", "rust"},
+	}
+	off := false
+	tests := []struct {
+		name          string
+		intermediates IntermediatesConfig
+		wantAuto      []string
+		wantBase      string
+	}{
+		{"defaults", IntermediatesConfig{}, []string{"fedora-pixi"}, "fedora-pixi"},
+		{"min_shared_layers 1", IntermediatesConfig{MinSharedLayers: 1}, []string{"fedora-pixi"}, "fedora-pixi"},
+		{"min_shared_layers 2", IntermediatesConfig{MinSharedLayers: 2}, nil, base},
+		{"min_shared_images 3", IntermediatesConfig{MinSharedImages: 3}, nil, base},
+		{"disabled", IntermediatesConfig{Enabled: &off}, nil, base},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Defaults: ImageConfig{Pkg: "rpm"}, Intermediates: tt.intermediates}
+			result, err := ComputeIntermediates(newImages(twoImages), layers, cfg, "v1")
+			if err != nil {
+				t.Fatalf("ComputeIntermediates() error = %v", err)
+			}
+			if got := autoNames(result); !reflect.DeepEqual(got, tt.wantAuto) {
+				t.Errorf("auto intermediates = %v, want %v", got, tt.wantAuto)
+			}
+			for _, name := range []string{"app1", "app2"} {
+				if result[name].Base != tt.wantBase {
+					t.Errorf("%s base = %q, want %q", name, result[name].Base, tt.wantBase)
+				}
+			}
+		})
+	}
+
+	// A skipped branch point passes its layers on to the next one
+	cfg := &Config{Defaults: ImageConfig{Pkg: "rpm"}, Intermediates: IntermediatesConfig{MinSharedLayers: 2}}
+	result, err := ComputeIntermediates(newImages(map[string][]string{
+		"app1": {"python", "nodejs"},
+		"app2": {"python", "rust"},
+		"app3": {"pixi", "golang"},
+	}), layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	if got := autoNames(result); !reflect.DeepEqual(got, []string{"fedora-python"}) {
+		t.Fatalf("auto intermediates = %v, want [fedora-python]", got)
+	}
+	if got := result["fedora-python"].Layers; !reflect.DeepEqual(got, []string{"pixi", "python"}) {
+		t.Errorf("fedora-python layers = %v, want [pixi python]", got)
+	}
+	if result["app1"].Base != "fedora-python" || result["app2"].Base != "fedora-python" || result["app3"].Base != base {
+		t.Errorf("bases = %q, %q, %q, want fedora-python, fedora-python, %s", result["app1"].Base, result["app2"].Base, result["app3"].Base, base)
+	}
+}
+
+func TestComputeIntermediates_Pinned(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":        {Name: "pixi", HasRootYml: true},
+		"python":      {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"supervisord": {Name: "supervisord", Depends: []string{"python"}, HasPixiToml: true},
+		"testapi":     {Name: "testapi", Depends: []string{"supervisord"}, HasPixiToml: true},
+		"openclaw":    {Name: "openclaw", Depends: []string{"supervisord"}, HasPackageJson: true},
+		"nodejs":      {Name: "nodejs", HasRootYml: true},
+	}
+	const base = "quay.io/fedora/fedora:43"
+	newImages := func() map[string]*ResolvedImage {
+		images := make(map[string]*ResolvedImage)
+		for name, l := range map[string][]string{
+			"test":     {"testapi"},
+			"openclaw": {"openclaw", "nodejs"},
+			"node":     {"nodejs"},
+		} {
+			images[name] = &ResolvedImage{Name: name, Base: base, IsExternalBase: true, Layers: l, Tag: "v1", FullTag: name + ":v1", Pkg: "rpm"}
+		}
+		return images
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Base: base, Pkg: "rpm"},
+		Intermediates: IntermediatesConfig{Pinned: map[string]PinnedIntermediate{
+			"py-base":      {Layers: []string{"python"}},
+			"runtime-base": {Layers: []string{"pixi", "python", "supervisord"}},
+		}},
+	}
+
+	images := newImages()
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	if len(images) != 3 {
+		t.Errorf("input images modified: %v", images)
+	}
+
+	runtime, ok := result["runtime-base"]
+	if !ok || !runtime.Pinned || runtime.Auto {
+		t.Fatalf("runtime-base = %+v, want a pinned, non-auto image", runtime)
+	}
+	if runtime.Base != "py-base" || !reflect.DeepEqual(runtime.Layers, []string{"supervisord"}) {
+		t.Errorf("runtime-base base = %q layers = %v, want py-base [supervisord]", runtime.Base, runtime.Layers)
+	}
+	if py := result["py-base"]; py.Base != base || !reflect.DeepEqual(py.Layers, []string{"pixi", "python"}) {
+		t.Errorf("py-base base = %q layers = %v, want %s [pixi python]", py.Base, py.Layers, base)
+	}
+	if result["test"].Base != "runtime-base" {
+		t.Errorf("test base = %q, want runtime-base", result["test"].Base)
+	}
+	// openclaw's sequence starts with nodejs (more popular), so it isn't routed
+	if result["openclaw"].Base == "runtime-base" {
+		t.Errorf("openclaw routed through runtime-base, but its sequence doesn't start with its layers")
+	}
+	for name, img := range result {
+		for _, l := range img.Layers {
+			if img.Auto && l == "supervisord" {
+				t.Errorf("auto intermediate %s duplicates the pinned supervisord layer", name)
+			}
+		}
+	}
+
+	// Without pinned intermediates, the trie picks its own split
+	cfg.Intermediates.Pinned = nil
+	result, err = ComputeIntermediates(newImages(), layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	if _, ok := result["runtime-base"]; ok {
+		t.Errorf("runtime-base created without being pinned")
+	}
+}
+
 func TestComputeIntermediates_PlatformInheritance(t *testing.T) {
 	// Parent with restricted platforms should propagate to auto-intermediates.
 	// nvidia is amd64-only; nvidia-supervisord should also be amd64-only.
@@ -937,3 +1452,303 @@ func TestComputeIntermediates_PlatformInheritance(t *testing.T) {
 		}
 	}
 }
+
+func TestComputeIntermediates_SkipsDisabledImages(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":    {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python":  {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"nodejs":  {Name: "nodejs", Depends: nil, HasRootYml: true},
+		"testapi": {Name: "testapi", Depends: []string{"python"}, HasPixiToml: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "ext:1", Layers: []string{}},
+			"app1":   {Base: "fedora", Layers: []string{"python", "testapi"}},
+			"app2":   {Base: "fedora", Enabled: boolPtr(false), Layers: []string{"python", "nodejs"}},
+		},
+	}
+
+	images, err := cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	for name, img := range result {
+		if img.Auto {
+			t.Errorf("unexpected auto intermediate %q: disabled app2 must not count as a sibling", name)
+		}
+	}
+	if _, ok := result["app2"]; ok {
+		t.Error("disabled app2 should not be resolved")
+	}
+
+	// With app2 enabled, the shared pixi/python prefix becomes an intermediate
+	cfg.IncludeDisabled()
+	images, err = cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err = ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	autoCount := 0
+	for _, img := range result {
+		if img.Auto {
+			autoCount++
+		}
+	}
+	if autoCount == 0 {
+		t.Error("expected an auto intermediate once app2 is included")
+	}
+}
+
+func TestComputeIntermediates_PlatformsOfBranchImages(t *testing.T) {
+	// Both children of the shared python prefix are amd64-only, so the
+	// intermediate is not built for arm64 even though fedora is.
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"appA":   {Name: "appA", Depends: []string{"python"}, HasRootYml: true},
+		"appB":   {Name: "appB", Depends: []string{"python"}, HasRootYml: true},
+		"nodejs": {Name: "nodejs", Depends: nil, HasRootYml: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm", Platforms: []string{"linux/amd64", "linux/arm64"}},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "ext:1", Layers: []string{}},
+			"appA":   {Base: "fedora", Layers: []string{"appA"}, Platforms: []string{"linux/amd64"}},
+			"appB":   {Base: "fedora", Layers: []string{"appB"}, Platforms: []string{"linux/amd64"}},
+			"web":    {Base: "fedora", Layers: []string{"nodejs"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	base := result["appA"].Base
+	inter, ok := result[base]
+	if !ok || !inter.Auto {
+		t.Fatalf("appA base = %q, want an auto intermediate", base)
+	}
+	if !reflect.DeepEqual(inter.Platforms, []string{"linux/amd64"}) {
+		t.Errorf("intermediate %q platforms = %v, want [linux/amd64]", base, inter.Platforms)
+	}
+	if !reflect.DeepEqual(result["web"].Platforms, []string{"linux/amd64", "linux/arm64"}) {
+		t.Errorf("web platforms = %v, want both", result["web"].Platforms)
+	}
+}
+
+func TestComputeIntermediates_BranchSettings(t *testing.T) {
+	// Both images on the shared python prefix are deb images for user dev on
+	// another registry, so the intermediate is too, whatever the defaults say.
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"appA":   {Name: "appA", Depends: []string{"python"}, HasRootYml: true},
+		"appB":   {Name: "appB", Depends: []string{"python"}, HasRootYml: true},
+	}
+	uid := 1001
+	deb := func(layer string) ImageConfig {
+		return ImageConfig{
+			Base: "debian:13", Layers: []string{layer}, Registry: "ghcr.io/deb", Pkg: "deb",
+			User: "dev", UID: &uid, GID: &uid, Platforms: []string{"linux/arm64"}, Builder: "deb-builder",
+		}
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm", Platforms: []string{"linux/amd64"}},
+		Images: map[string]ImageConfig{
+			"appA": deb("appA"),
+			"appB": deb("appB"),
+		},
+	}
+	images, err := cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	base := result["appA"].Base
+	inter, ok := result[base]
+	if !ok || !inter.Auto || result["appB"].Base != base {
+		t.Fatalf("appA base = %q, appB base = %q, want a shared auto intermediate", base, result["appB"].Base)
+	}
+	if inter.Pkg != "deb" || inter.User != "dev" || inter.UID != 1001 || inter.GID != 1001 || inter.Home != "/home/dev" {
+		t.Errorf("intermediate %q = pkg %s user %s uid %d gid %d home %s, want deb dev 1001 1001 /home/dev", base, inter.Pkg, inter.User, inter.UID, inter.GID, inter.Home)
+	}
+	if inter.Registry != "ghcr.io/deb" || inter.FullTag != "ghcr.io/deb/"+base+":v1" {
+		t.Errorf("intermediate %q registry = %q full tag = %q, want ghcr.io/deb", base, inter.Registry, inter.FullTag)
+	}
+	if inter.Builder != "deb-builder" {
+		t.Errorf("intermediate %q builder = %q, want deb-builder", base, inter.Builder)
+	}
+	if !reflect.DeepEqual(inter.Platforms, []string{"linux/arm64"}) {
+		t.Errorf("intermediate %q platforms = %v, want [linux/arm64]", base, inter.Platforms)
+	}
+
+	// Images disagreeing on the package format leave the intermediate on the defaults
+	rpm := deb("appB")
+	rpm.Pkg = "rpm"
+	cfg.Images["appB"] = rpm
+	if images, err = cfg.ResolveAllImages("v1"); err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	if result, err = ComputeIntermediates(images, layers, cfg, "v1"); err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+	inter = result[result["appA"].Base]
+	if inter.Pkg != "rpm" || inter.User != "dev" {
+		t.Errorf("intermediate pkg = %s user = %s, want the default rpm and the shared user dev", inter.Pkg, inter.User)
+	}
+	if !reflect.DeepEqual(inter.BranchConflicts, []string{"pkg"}) {
+		t.Errorf("intermediate BranchConflicts = %v, want [pkg]", inter.BranchConflicts)
+	}
+}
+
+func TestComputeIntermediates_ExcludeFromIntermediates(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"appA":   {Name: "appA", Depends: []string{"python"}, HasRootYml: true},
+		"appB":   {Name: "appB", Depends: []string{"python"}, HasRootYml: true},
+		"appC":   {Name: "appC", Depends: []string{"python"}, HasRootYml: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"appA": {Base: "ext:1", Layers: []string{"appA"}},
+			"appB": {Base: "ext:1", Layers: []string{"appB"}},
+			"appC": {Base: "ext:1", Layers: []string{"appC"}, NoIntermediates: true},
+		},
+	}
+	images, err := cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	if result["appC"].Base != "ext:1" {
+		t.Errorf("appC base = %q, want ext:1 unchanged", result["appC"].Base)
+	}
+	base := result["appA"].Base
+	if inter, ok := result[base]; !ok || !inter.Auto || result["appB"].Base != base {
+		t.Errorf("appA base = %q, appB base = %q, want a shared auto intermediate", base, result["appB"].Base)
+	}
+}
+
+func TestComputeIntermediates_BaseGroups(t *testing.T) {
+	// The same layer stacks on fedora:42 and fedora:43 give one intermediate
+	// per base, named after its base whatever order the groups are processed in
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"appA":   {Name: "appA", Depends: []string{"python"}, HasRootYml: true},
+		"appB":   {Name: "appB", Depends: []string{"python"}, HasRootYml: true},
+	}
+	const f42, f43 = "quay.io/fedora/fedora:42", "quay.io/fedora/fedora:43"
+	hash := func(ref string) string {
+		sum := sha256.Sum256([]byte(ref))
+		return hex.EncodeToString(sum[:])[:6]
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"a42": {Base: f42, Layers: []string{"appA"}},
+			"b42": {Base: f42, Layers: []string{"appB"}},
+			"a43": {Base: f43, Layers: []string{"appA"}},
+			"b43": {Base: f43, Layers: []string{"appB"}},
+		},
+	}
+
+	for _, tt := range []struct {
+		name      string
+		baseNames map[string]string
+		want42    string
+		want43    string
+	}{
+		{"hashed", nil, "fedora-" + hash(f42) + "-python", "fedora-" + hash(f43) + "-python"},
+		{"base_names", map[string]string{f42: "f42", f43: "f43"}, "f42-python", "f43-python"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Intermediates.BaseNames = tt.baseNames
+			images, err := cfg.ResolveAllImages("v1")
+			if err != nil {
+				t.Fatalf("ResolveAllImages() error = %v", err)
+			}
+			result, err := ComputeIntermediates(images, layers, cfg, "v1")
+			if err != nil {
+				t.Fatalf("ComputeIntermediates() error = %v", err)
+			}
+			for _, c := range []struct{ image, want, group string }{
+				{"a42", tt.want42, f42}, {"b42", tt.want42, f42},
+				{"a43", tt.want43, f43}, {"b43", tt.want43, f43},
+			} {
+				if got := result[c.image].Base; got != c.want {
+					t.Errorf("%s base = %q, want %q", c.image, got, c.want)
+					continue
+				}
+				if inter := result[c.want]; inter.Base != c.group || inter.BaseGroup != c.group {
+					t.Errorf("%s base = %q, base group = %q, want %s", c.want, inter.Base, inter.BaseGroup, c.group)
+				}
+			}
+		})
+	}
+
+	// A base without namesake keeps its short name
+	if got := externalBaseNames(map[string]*ResolvedImage{"a": {Base: f43, IsExternalBase: true}}, &Config{}); got[f43] != "fedora" {
+		t.Errorf("externalBaseNames() = %v, want fedora for %s", got, f43)
+	}
+}
+
+func TestComputeIntermediates_NoLatestTag(t *testing.T) {
+	// Auto intermediates are only referenced by their exact tag, so they
+	// never get :latest, even when every user image does
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", Depends: nil, HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"appA":   {Name: "appA", Depends: []string{"python"}, HasRootYml: true},
+		"appB":   {Name: "appB", Depends: []string{"python"}, HasRootYml: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Registry: "r", Pkg: "rpm", Latest: boolPtr(true)},
+		Images: map[string]ImageConfig{
+			"fedora": {Base: "ext:1", Layers: []string{}},
+			"appA":   {Base: "fedora", Layers: []string{"appA"}},
+			"appB":   {Base: "fedora", Layers: []string{"appB"}},
+		},
+	}
+	images, err := cfg.ResolveAllImages("2026.46.1415")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "2026.46.1415")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	inter, ok := result[result["appA"].Base]
+	if !ok || !inter.Auto {
+		t.Fatalf("appA base = %q, want an auto intermediate", result["appA"].Base)
+	}
+	if !reflect.DeepEqual(inter.Tags, []string{inter.FullTag}) {
+		t.Errorf("intermediate tags = %v, want only %s", inter.Tags, inter.FullTag)
+	}
+	if want := []string{"r/appA:2026.46.1415", "r/appA:latest"}; !reflect.DeepEqual(result["appA"].Tags, want) {
+		t.Errorf("appA tags = %v, want %v", result["appA"].Tags, want)
+	}
+}
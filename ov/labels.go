@@ -3,7 +3,6 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"os/exec"
 	"strconv"
 	"strings"
 )
@@ -20,8 +19,34 @@ const (
 	LabelPorts    = "org.overthink.ports"
 	LabelVolumes  = "org.overthink.volumes"
 	LabelAliases  = "org.overthink.aliases"
+	LabelBase     = "org.overthink.base"
+	LabelLayers   = "org.overthink.layers"
+
+	// LabelBaseGroup is the external base an intermediate was created for
+	LabelBaseGroup = "org.overthink.base-group"
+
+	// LabelLayerBoundary is set right after FROM by images with
+	// merge.preserve_base_boundary; ov merge keeps the layers before and after
+	// its history entry apart
+	LabelLayerBoundary = "org.overthink.layer-boundary"
+
+	// LabelInputsDigest is the hash of the image's build inputs (see inputsDigest)
+	LabelInputsDigest = "org.overthink.inputs-digest"
+
+	// LabelLayerHashPrefix + <layer stage name> is the content hash of a layer (see Layer.Hash)
+	LabelLayerHashPrefix = "org.overthink.layer."
 )
 
+// Standard OCI annotation keys emitted alongside the org.overthink. labels.
+const (
+	LabelOCICreated  = "org.opencontainers.image.created"
+	LabelOCIVersion  = "org.opencontainers.image.version"
+	LabelOCIRevision = "org.opencontainers.image.revision"
+)
+
+// ReservedLabelPrefix is the label namespace owned by ov; user labels must not use it.
+const ReservedLabelPrefix = "org.overthink."
+
 // LabelSchemaVersion is the current label schema version.
 const LabelSchemaVersion = "1"
 
@@ -39,6 +64,8 @@ type ImageMetadata struct {
 	GID      int
 	User     string
 	Home     string
+	Base     string
+	Layers   []string
 	Ports    []string
 	Volumes  []VolumeMount
 	Aliases  []CollectedAlias
@@ -49,8 +76,7 @@ type ImageMetadata struct {
 var InspectLabels = defaultInspectLabels
 
 func defaultInspectLabels(engine, imageRef string) (map[string]string, error) {
-	binary := EngineBinary(engine)
-	cmd := exec.Command(binary, "inspect", "--format", "{{json .Config.Labels}}", imageRef)
+	cmd := engineCmd(engine, "inspect", "--format", "{{json .Config.Labels}}", imageRef)
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("inspecting %s: %w", imageRef, err)
@@ -86,6 +112,7 @@ func ExtractMetadata(engine, imageRef string) (*ImageMetadata, error) {
 		Registry: labels[LabelRegistry],
 		User:     labels[LabelUser],
 		Home:     labels[LabelHome],
+		Base:     labels[LabelBase],
 	}
 
 	if v := labels[LabelUID]; v != "" {
@@ -104,6 +131,12 @@ func ExtractMetadata(engine, imageRef string) (*ImageMetadata, error) {
 		meta.GID = gid
 	}
 
+	if v := labels[LabelLayers]; v != "" {
+		if err := json.Unmarshal([]byte(v), &meta.Layers); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", LabelLayers, err)
+		}
+	}
+
 	if v := labels[LabelPorts]; v != "" {
 		if err := json.Unmarshal([]byte(v), &meta.Ports); err != nil {
 			return nil, fmt.Errorf("parsing %s: %w", LabelPorts, err)
@@ -388,3 +388,100 @@ func TestLabelVolumeJSON(t *testing.T) {
 		t.Errorf("json.Marshal(LabelVolume) = %s, want %s", data, want)
 	}
 }
+
+func TestWriteLabelsProvenance(t *testing.T) {
+	g := &Generator{
+		Config: &Config{
+			Images: map[string]ImageConfig{
+				"base-img": {Base: "quay.io/fedora/fedora:43", Layers: []string{"pixi"}},
+				"app":      {Base: "base-img", Layers: []string{"svc", "tools"}},
+			},
+		},
+		Layers: map[string]*Layer{
+			"pixi":  {Name: "pixi", HasRootYml: true},
+			"svc":   {Name: "svc", HasRootYml: true},
+			"tools": {Name: "tools", HasRootYml: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"base-img": {Name: "base-img", FullTag: "ghcr.io/test/base-img:2026.1.100"},
+		},
+	}
+
+	img := &ResolvedImage{
+		Name: "app",
+		Base: "base-img",
+		UID:  1000,
+		GID:  1000,
+		User: "user",
+		Home: "/home/user",
+		Labels: map[string]string{
+			"org.opencontainers.image.source": "https://github.com/test/app",
+			"com.example.team":                "infra",
+		},
+	}
+
+	var b strings.Builder
+	g.writeLabels(&b, "app", []string{"svc", "tools"}, img)
+	output := b.String()
+
+	if !strings.Contains(output, `LABEL org.overthink.base="ghcr.io/test/base-img:2026.1.100"`) {
+		t.Errorf("missing or wrong base label in:\n%s", output)
+	}
+	if !strings.Contains(output, `LABEL org.overthink.layers='["svc","tools"]'`) {
+		t.Errorf("missing or wrong layers label in:\n%s", output)
+	}
+
+	// Extra labels are emitted sorted by key
+	teamIdx := strings.Index(output, `LABEL com.example.team="infra"`)
+	sourceIdx := strings.Index(output, `LABEL org.opencontainers.image.source="https://github.com/test/app"`)
+	if teamIdx < 0 || sourceIdx < 0 {
+		t.Fatalf("missing extra labels in:\n%s", output)
+	}
+	if teamIdx > sourceIdx {
+		t.Errorf("extra labels not sorted by key:\n%s", output)
+	}
+
+	// External base is recorded verbatim
+	ext := &ResolvedImage{Name: "base-img", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, User: "user", Home: "/home/user"}
+	b.Reset()
+	g.writeLabels(&b, "base-img", []string{"pixi"}, ext)
+	if !strings.Contains(b.String(), `LABEL org.overthink.base="quay.io/fedora/fedora:43"`) {
+		t.Errorf("missing external base label in:\n%s", b.String())
+	}
+	if strings.Contains(b.String(), LabelBaseGroup) {
+		t.Errorf("base group label on a user image:\n%s", b.String())
+	}
+
+	// Intermediates record the external base of their group
+	inter := &ResolvedImage{Name: "fedora-svc", Base: "base-img", User: "user", Home: "/home/user", Auto: true, BaseGroup: "quay.io/fedora/fedora:43"}
+	b.Reset()
+	g.writeLabels(&b, "fedora-svc", []string{"svc"}, inter)
+	if !strings.Contains(b.String(), `LABEL org.overthink.base-group="quay.io/fedora/fedora:43"`) {
+		t.Errorf("missing base group label in:\n%s", b.String())
+	}
+}
+
+func TestExtractMetadataProvenance(t *testing.T) {
+	orig := InspectLabels
+	defer func() { InspectLabels = orig }()
+
+	InspectLabels = func(engine, imageRef string) (map[string]string, error) {
+		return map[string]string{
+			LabelVersion: "1",
+			LabelImage:   "app",
+			LabelBase:    "ghcr.io/test/base-img:2026.1.100",
+			LabelLayers:  `["svc","tools"]`,
+		}, nil
+	}
+
+	meta, err := ExtractMetadata("docker", "app:latest")
+	if err != nil {
+		t.Fatalf("ExtractMetadata() error = %v", err)
+	}
+	if meta.Base != "ghcr.io/test/base-img:2026.1.100" {
+		t.Errorf("Base = %q, want %q", meta.Base, "ghcr.io/test/base-img:2026.1.100")
+	}
+	if !reflect.DeepEqual(meta.Layers, []string{"svc", "tools"}) {
+		t.Errorf("Layers = %v, want [svc tools]", meta.Layers)
+	}
+}
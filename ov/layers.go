@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -18,22 +21,44 @@ type VolumeYAML struct {
 
 // AliasYAML represents a command alias declaration in layer.yml
 type AliasYAML struct {
-	Name    string `yaml:"name"`
-	Command string `yaml:"command"`
+	Name           string            `yaml:"name"`
+	Command        string            `yaml:"command"`
+	MountCwd       *bool             `yaml:"mount_cwd,omitempty"`       // mount the caller's directory (default: true)
+	Env            map[string]string `yaml:"env,omitempty"`             // set in the container
+	EnvPassthrough []string          `yaml:"env_passthrough,omitempty"` // forwarded from the host when set
+
+	Completion        string `yaml:"completion,omitempty"`         // shell to install a completion for
+	CompletionCommand string `yaml:"completion_command,omitempty"` // prints the completion script in the image
+
+	GPU     AliasGPU `yaml:"gpu,omitempty"`      // GPU passthrough (default: auto)
+	Ports   []string `yaml:"ports,omitempty"`    // "port" or "host:container"
+	RunArgs []string `yaml:"run_args,omitempty"` // extra engine run arguments
 }
 
 // LayerYAML represents the parsed layer.yml file
 type LayerYAML struct {
-	Depends    []string          `yaml:"depends,omitempty"`
-	Env        map[string]string `yaml:"env,omitempty"`
-	PathAppend []string          `yaml:"path_append,omitempty"`
-	Ports      []int             `yaml:"ports,omitempty"`
-	Route      *RouteYAML        `yaml:"route,omitempty"`
-	Service    string            `yaml:"service,omitempty"`
-	Rpm        *RpmConfig        `yaml:"rpm,omitempty"`
-	Deb        *DebConfig        `yaml:"deb,omitempty"`
-	Volumes    []VolumeYAML      `yaml:"volumes,omitempty"`
-	Aliases    []AliasYAML       `yaml:"aliases,omitempty"`
+	Description string             `yaml:"description,omitempty"` // one-line summary shown by ov list layers
+	Maintainer  string             `yaml:"maintainer,omitempty"`  // owner contact, e.g. "Jane Doe <jane@example.com>"
+	Depends     []string           `yaml:"depends,omitempty"`
+	After       []string           `yaml:"after,omitempty"`     // install after these layers when the image has them (never pulls them in)
+	Provides    []string           `yaml:"provides,omitempty"`  // capabilities other layers may depend on, e.g. python-runtime
+	Conflicts   []string           `yaml:"conflicts,omitempty"` // layers that must not share an image with this one
+	Env         map[string]string  `yaml:"env,omitempty"`
+	PathAppend  []string           `yaml:"path_append,omitempty"`
+	Ports       []int              `yaml:"ports,omitempty"`
+	Route       *RouteYAML         `yaml:"route,omitempty"`
+	Service     string             `yaml:"service,omitempty"`
+	Priority    int                `yaml:"priority,omitempty"` // supervisord fragment order (1-99, default 50)
+	Rpm         *RpmConfig         `yaml:"rpm,omitempty"`
+	Deb         *DebConfig         `yaml:"deb,omitempty"`
+	Apk         *ApkConfig         `yaml:"apk,omitempty"`
+	FilesOwner  string             `yaml:"files_owner,omitempty"` // "root" (default) or "user"
+	Volumes     []VolumeYAML       `yaml:"volumes,omitempty"`
+	Aliases     []AliasYAML        `yaml:"aliases,omitempty"`
+	Healthcheck *HealthcheckConfig `yaml:"healthcheck,omitempty"`
+	Platforms   []string           `yaml:"platforms,omitempty"`    // restrict install steps to these platforms (e.g. linux/amd64)
+	Secrets     []string           `yaml:"secrets,omitempty"`      // build secret ids mounted at /run/secrets/<id>
+	SizeHintMB  int                `yaml:"size_hint_mb,omitempty"` // installed size for intermediates.order_by: size (default: estimated)
 }
 
 // RouteYAML represents a route declaration in layer.yml
@@ -49,6 +74,23 @@ type RpmConfig struct {
 	Repos    []RpmRepo `yaml:"repos,omitempty"`
 	Exclude  []string  `yaml:"exclude,omitempty"`
 	Options  []string  `yaml:"options,omitempty"`
+
+	lines []int // layer.yml line of each packages entry
+}
+
+// UnmarshalYAML decodes the rpm section, recording package entry lines for validation
+func (r *RpmConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain RpmConfig
+	if err := value.Decode((*plain)(r)); err != nil {
+		return err
+	}
+	r.lines = packageLines(value)
+	return nil
+}
+
+// Specs returns the parsed package entries, or the first malformed one (see validatePkgConfig)
+func (r *RpmConfig) Specs() ([]PackageSpec, error) {
+	return parsePackageSpecs("rpm", r.Packages, r.lines)
 }
 
 // RpmRepo represents an external RPM repository
@@ -61,79 +103,425 @@ type RpmRepo struct {
 // DebConfig represents Debian package configuration in layer.yml
 type DebConfig struct {
 	Packages []string `yaml:"packages,omitempty"`
+	Repos    []string `yaml:"repos,omitempty"` // "ppa:owner/name" or a one-line "deb ..." source
+	Keys     []DebKey `yaml:"keys,omitempty"`
+
+	lines []int // layer.yml line of each packages entry
+}
+
+// UnmarshalYAML decodes the deb section, recording package entry lines for validation
+func (d *DebConfig) UnmarshalYAML(value *yaml.Node) error {
+	type plain DebConfig
+	if err := value.Decode((*plain)(d)); err != nil {
+		return err
+	}
+	d.lines = packageLines(value)
+	return nil
+}
+
+// Specs returns the parsed package entries, or the first malformed one (see validatePkgConfig)
+func (d *DebConfig) Specs() ([]PackageSpec, error) {
+	return parsePackageSpecs("deb", d.Packages, d.lines)
+}
+
+// DebKey represents an apt signing key, fetched to /etc/apt/keyrings/<name>.asc
+type DebKey struct {
+	Name string `yaml:"name"`
+	URL  string `yaml:"url"`
+}
+
+// PPAs returns the "ppa:" entries of deb.repos
+func (d *DebConfig) PPAs() []string {
+	var ppas []string
+	for _, repo := range d.Repos {
+		if strings.HasPrefix(repo, "ppa:") {
+			ppas = append(ppas, repo)
+		}
+	}
+	return ppas
+}
+
+// Sources returns the "deb " source lines of deb.repos
+func (d *DebConfig) Sources() []string {
+	var sources []string
+	for _, repo := range d.Repos {
+		if strings.HasPrefix(repo, "deb ") {
+			sources = append(sources, repo)
+		}
+	}
+	return sources
+}
+
+// PackageSpec is a parsed rpm/deb packages entry: "name", "name=version" or
+// "!name". For dnf, a name may also be a group (@group, @^environment), a file
+// (/usr/bin/tool) or a provide like pkgconfig(gtk+-3.0).
+type PackageSpec struct {
+	Name    string
+	Version string // constraint after "=", passed verbatim to dnf/apt ("" if unpinned)
+	Exclude bool   // "!name": never install, even as a dependency
+	Line    int    // line in layer.yml (0 if unknown)
+}
+
+var (
+	pkgNameRe    = regexp.MustCompile(`^(@\^?|/)?[A-Za-z0-9_][A-Za-z0-9._+:*?/()-]*$`)
+	pkgVersionRe = regexp.MustCompile(`^[A-Za-z0-9*][A-Za-z0-9._+:~*?-]*$`)
+)
+
+// ParsePackageSpec parses a single packages entry
+func ParsePackageSpec(entry string) (PackageSpec, error) {
+	var spec PackageSpec
+	rest := strings.TrimSpace(entry)
+	if strings.HasPrefix(rest, "!") {
+		spec.Exclude = true
+		rest = rest[1:]
+	}
+	if name, version, ok := strings.Cut(rest, "="); ok {
+		if spec.Exclude {
+			return spec, fmt.Errorf("exclusion %q cannot have a version constraint", entry)
+		}
+		if !pkgVersionRe.MatchString(version) {
+			return spec, fmt.Errorf("invalid version constraint %q", version)
+		}
+		rest, spec.Version = name, version
+	}
+	if !pkgNameRe.MatchString(rest) {
+		return spec, fmt.Errorf("invalid package name %q", rest)
+	}
+	if isGroupOrFile(rest) {
+		if spec.Version != "" {
+			return spec, fmt.Errorf("%q is a group or file and cannot have a version constraint", rest)
+		}
+		if spec.Exclude {
+			return spec, fmt.Errorf("%q is a group or file and cannot be excluded", rest)
+		}
+	}
+	spec.Name = rest
+	return spec, nil
+}
+
+// isGroupOrFile reports whether a packages name is a dnf group (@group) or
+// file (/path) rather than a package
+func isGroupOrFile(name string) bool {
+	return strings.HasPrefix(name, "@") || strings.HasPrefix(name, "/")
+}
+
+// Arg returns the install argument: name-version for dnf, name=version for apt
+func (p PackageSpec) Arg(pkg string) string {
+	if p.Version == "" {
+		return p.Name
+	}
+	if pkg == "rpm" {
+		return p.Name + "-" + p.Version
+	}
+	return p.Name + "=" + p.Version
+}
+
+// parsePackageSpecs parses the packages entries of section (rpm or deb),
+// pairing each with its layer.yml line. The first malformed entry is an error.
+func parsePackageSpecs(section string, entries []string, lines []int) ([]PackageSpec, error) {
+	var specs []PackageSpec
+	for i, entry := range entries {
+		spec, err := ParsePackageSpec(entry)
+		if i < len(lines) {
+			spec.Line = lines[i]
+		}
+		if err != nil {
+			if spec.Line > 0 {
+				return nil, fmt.Errorf("layer.yml line %d: %s.packages: %w", spec.Line, section, err)
+			}
+			return nil, fmt.Errorf("layer.yml: %s.packages: %w", section, err)
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// packageLines returns the line of each item under the "packages" key of a mapping node
+func packageLines(node *yaml.Node) []int {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "packages" {
+			continue
+		}
+		var lines []int
+		for _, item := range node.Content[i+1].Content {
+			lines = append(lines, item.Line)
+		}
+		return lines
+	}
+	return nil
+}
+
+// ApkConfig represents Alpine package configuration in layer.yml
+type ApkConfig struct {
+	Packages []string `yaml:"packages,omitempty"`
 }
 
-// Layer represents a layer directory and its contents
+// Layer represents a layer directory and its contents.
+// Its root.yml and user.yml run with OV_ARCH set to the build's TARGETARCH (amd64, arm64).
 type Layer struct {
-	Name              string
-	Path              string
-	HasRootYml        bool
-	HasPixiToml       bool
-	HasPyprojectToml  bool
-	HasEnvironmentYml bool
-	HasPackageJson    bool
-	HasCargoToml      bool
-	HasSrcDir         bool
-	HasUserYml        bool
-	HasSupervisord    bool
-	HasEnv            bool
-	HasPorts          bool
-	HasRoute          bool
-	HasVolumes        bool
-	HasAliases        bool
-	HasPixiLock       bool
-	Depends           []string
+	Name               string
+	Path               string
+	HasRootYml         bool
+	HasPixiToml        bool
+	HasPyprojectToml   bool
+	HasEnvironmentYml  bool
+	HasRequirementsTxt bool
+	HasPackageJson     bool
+	HasCargoToml       bool
+	HasSrcDir          bool
+	HasGoMod           bool
+	HasGoSources       bool
+	HasUserYml         bool
+	HasSupervisord     bool
+	HasEnv             bool
+	HasPorts           bool
+	HasRoute           bool
+	HasVolumes         bool
+	HasAliases         bool
+	HasPixiLock        bool
+	HasFiles           bool
+	HasSystemd         bool
+	HasHealthcheck     bool
+	Depends            []string
+	After              []string // soft ordering: only applies to layers the image has anyway
+	Provides           []string // capabilities, resolved from depends by the image's layer set
+	Conflicts          []string // layers that must not share an image with this one
+	Description        string   // from layer.yml
+	Maintainer         string   // from layer.yml
 
 	// Pre-populated from layer.yml
-	rpmConfig   *RpmConfig
-	debConfig   *DebConfig
-	ports       []string
-	envConfig   *EnvConfig
-	route       *RouteConfig
-	serviceConf string
-	volumes     []VolumeYAML
-	aliases     []AliasYAML
+	rpmConfig    *RpmConfig
+	debConfig    *DebConfig
+	apkConfig    *ApkConfig
+	ports        []string
+	envConfig    *EnvConfig
+	route        *RouteConfig
+	serviceConf  string
+	priority     int
+	sizeHintMB   int
+	platforms    []string
+	secrets      []string
+	volumes      []VolumeYAML
+	aliases      []AliasYAML
+	filesOwner   string
+	healthcheck  *HealthcheckConfig
+	systemdUnits []string
+	hash         string      // cached by Hash
+	dirSize      int64       // cached by EstimatedSizeKB
+	remoteDir    string      // build-context path of a remote layer ("" for layers/<name>)
+	ignore       ignoreRules // .ovignore patterns (project, then layer)
 }
 
-// ScanLayers scans the layers/ directory and returns all layers
+// ScanLayers scans the layers/ directory and returns all layers. Layers can be
+// grouped in namespace directories (layers/lang/python); the layer name is
+// then the slash-joined path below layers/ ("lang/python").
 func ScanLayers(dir string) (map[string]*Layer, error) {
 	layersDir := filepath.Join(dir, "layers")
-	entries, err := os.ReadDir(layersDir)
+	layers := make(map[string]*Layer)
+	if err := scanLayerDir(layersDir, "", layers); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := scanRemoteLayers(dir, layers); err != nil {
+		return nil, err
+	}
+	if err := loadIgnoreFiles(dir, layers); err != nil {
+		return nil, err
+	}
+	if err := resolveDependsNames(layers); err != nil {
+		return nil, err
+	}
+	return layers, nil
+}
+
+// scanRemoteLayers adds the remote layers of images.yml, fetching them first
+// if needed. A local layer of the same name wins.
+func scanRemoteLayers(dir string, layers map[string]*Layer) error {
+	remote, err := SyncRemoteLayers(dir, false)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range remote {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for _, name := range names {
+		if _, ok := layers[name]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: remote layer %s is shadowed by layers/%s\n", name, name)
+			continue
+		}
+		layer, err := scanLayer(remote[name], name)
+		if err != nil {
+			return fmt.Errorf("scanning remote layer %s: %w", name, err)
+		}
+		rel, err := filepath.Rel(dir, remote[name])
+		if err != nil {
+			return err
+		}
+		layer.remoteDir = filepath.ToSlash(rel)
+		layers[name] = layer
+	}
+	return nil
+}
+
+// scanLayerDir adds the layers below dir, whose layer names start with prefix
+func scanLayerDir(dir, prefix string, layers map[string]*Layer) error {
+	entries, err := os.ReadDir(dir)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return make(map[string]*Layer), nil
+			return err
 		}
-		return nil, fmt.Errorf("reading layers directory: %w", err)
+		return fmt.Errorf("reading layers directory: %w", err)
 	}
 
-	layers := make(map[string]*Layer)
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
 
-		name := entry.Name()
-		layer, err := scanLayer(filepath.Join(layersDir, name), name)
+		name := path.Join(prefix, entry.Name())
+		entryPath := filepath.Join(dir, entry.Name())
+		namespace, err := isNamespaceDir(entryPath)
+		if err != nil {
+			return fmt.Errorf("reading layers directory: %w", err)
+		}
+		if namespace {
+			if err := scanLayerDir(entryPath, name, layers); err != nil {
+				return err
+			}
+			continue
+		}
+
+		layer, err := scanLayer(entryPath, name)
 		if err != nil {
-			return nil, fmt.Errorf("scanning layer %s: %w", name, err)
+			return fmt.Errorf("scanning layer %s: %w", name, err)
 		}
 		layers[name] = layer
 	}
+	return nil
+}
 
-	return layers, nil
+// layerSubdirs are the directories a layer itself may contain
+var layerSubdirs = map[string]bool{"files": true, "src": true, "systemd": true}
+
+// isNamespaceDir reports whether dir groups layers rather than being one:
+// it contains only directories, none of which is a layer subdirectory.
+func isNamespaceDir(dir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || layerSubdirs[entry.Name()] {
+			return false, nil
+		}
+	}
+	return len(entries) > 0, nil
 }
 
-// parseLayerYAML reads and unmarshals a layer.yml file
-func parseLayerYAML(path string) (*LayerYAML, error) {
+// resolveDependsNames rewrites depends and after entries that refer to a
+// nested layer by its leaf name ("python" for lang/python) to the full layer
+// name. Names that match no layer are left for validation; a leaf name shared
+// by several layers must be written out in full.
+func resolveDependsNames(layers map[string]*Layer) error {
+	leaves := make(map[string][]string)
+	for _, name := range LayerNames(layers) {
+		leaf := path.Base(name)
+		leaves[leaf] = append(leaves[leaf], name)
+	}
+	resolve := func(name, field string, refs []string) error {
+		for i, ref := range refs {
+			if _, ok := layers[ref]; ok {
+				continue
+			}
+			switch matches := leaves[ref]; len(matches) {
+			case 0:
+			case 1:
+				refs[i] = matches[0]
+			default:
+				return fmt.Errorf("layer %s: %s %q is ambiguous (%s)", name, field, ref, strings.Join(matches, ", "))
+			}
+		}
+		return nil
+	}
+	for _, name := range LayerNames(layers) {
+		if err := resolve(name, "depends", layers[name].Depends); err != nil {
+			return err
+		}
+		if err := resolve(name, "after", layers[name].After); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// capabilityProviders maps each capability declared via provides to the
+// sorted names of the layers providing it
+func capabilityProviders(layers map[string]*Layer) map[string][]string {
+	providers := make(map[string][]string)
+	for name, layer := range layers {
+		for _, capability := range layer.Provides {
+			providers[capability] = append(providers[capability], name)
+		}
+	}
+	for _, names := range providers {
+		sortStrings(names)
+	}
+	return providers
+}
+
+// orderDeps returns the layers that must be installed before l among present:
+// its depends plus its after entries (ordering only). A depends entry naming a
+// capability stands for the present layers that provide it.
+func (l *Layer) orderDeps(present map[string]bool, providers map[string][]string) []string {
+	var deps []string
+	for _, dep := range l.Depends {
+		if present[dep] {
+			deps = append(deps, dep)
+			continue
+		}
+		for _, provider := range providers[dep] {
+			if present[provider] {
+				deps = append(deps, provider)
+			}
+		}
+	}
+	for _, dep := range l.After {
+		if present[dep] {
+			deps = append(deps, dep)
+		}
+	}
+	return deps
+}
+
+// layerStageName returns the layer name as used for Containerfile stage names
+// and file names inside the image: nested layers have "/" replaced by "-"
+func layerStageName(name string) string {
+	return strings.ReplaceAll(name, "/", "-")
+}
+
+// parseLayerYAML reads and unmarshals a layer.yml file. Unknown keys are
+// returned as warnings rather than errors, so layers carrying metadata for
+// newer ov versions still load.
+func parseLayerYAML(path string) (*LayerYAML, []string, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
 	}
 	var ly LayerYAML
-	if err := yaml.Unmarshal(data, &ly); err != nil {
-		return nil, err
+	if doc.Kind == 0 {
+		return &ly, nil, nil // empty file
 	}
-	return &ly, nil
+	var unknown []string
+	checkKnownFields(&doc, reflect.TypeOf(&ly), "", &unknown)
+	if err := doc.Decode(&ly); err != nil {
+		return nil, nil, err
+	}
+	return &ly, unknown, nil
 }
 
 // scanLayer scans a single layer directory
@@ -148,23 +536,50 @@ func scanLayer(path string, name string) (*Layer, error) {
 	layer.HasPixiToml = fileExists(filepath.Join(path, "pixi.toml"))
 	layer.HasPyprojectToml = fileExists(filepath.Join(path, "pyproject.toml"))
 	layer.HasEnvironmentYml = fileExists(filepath.Join(path, "environment.yml"))
+	layer.HasRequirementsTxt = fileExists(filepath.Join(path, "requirements.txt"))
 	layer.HasPackageJson = fileExists(filepath.Join(path, "package.json"))
 	layer.HasCargoToml = fileExists(filepath.Join(path, "Cargo.toml"))
 	layer.HasSrcDir = dirExists(filepath.Join(path, "src"))
+	layer.HasGoMod = fileExists(filepath.Join(path, "go.mod"))
+	if layer.HasGoMod {
+		layer.HasGoSources = hasGoSources(path)
+	}
 	layer.HasUserYml = fileExists(filepath.Join(path, "user.yml"))
 	layer.HasPixiLock = fileExists(filepath.Join(path, "pixi.lock"))
+	layer.HasFiles = dirExists(filepath.Join(path, "files"))
+	layer.HasSystemd = dirExists(filepath.Join(path, "systemd"))
+	if layer.HasSystemd {
+		units, err := scanSystemdUnits(filepath.Join(path, "systemd"))
+		if err != nil {
+			return nil, err
+		}
+		layer.systemdUnits = units
+	}
 
 	// Parse layer.yml if present
 	yamlPath := filepath.Join(path, "layer.yml")
 	if fileExists(yamlPath) {
-		ly, err := parseLayerYAML(yamlPath)
+		relPath := filepath.Join("layers", name, "layer.yml")
+		ly, warnings, err := parseLayerYAML(yamlPath)
 		if err != nil {
-			return nil, fmt.Errorf("parsing layer.yml: %w", err)
+			return nil, fmt.Errorf("parsing %s: %w", relPath, err)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", relPath, w)
 		}
 
+		layer.Description = ly.Description
+		layer.Maintainer = ly.Maintainer
 		layer.Depends = ly.Depends
+		layer.After = ly.After
+		layer.Provides = ly.Provides
+		layer.Conflicts = ly.Conflicts
 		layer.HasSupervisord = ly.Service != ""
 		layer.serviceConf = ly.Service
+		layer.priority = ly.Priority
+		layer.sizeHintMB = ly.SizeHintMB
+		layer.platforms = ly.Platforms
+		layer.secrets = ly.Secrets
 		layer.HasEnv = len(ly.Env) > 0 || len(ly.PathAppend) > 0
 		layer.HasPorts = len(ly.Ports) > 0
 		layer.HasRoute = ly.Route != nil
@@ -172,6 +587,8 @@ func scanLayer(path string, name string) (*Layer, error) {
 		// Pre-populate package config
 		layer.rpmConfig = ly.Rpm
 		layer.debConfig = ly.Deb
+		layer.apkConfig = ly.Apk
+		layer.filesOwner = ly.FilesOwner
 
 		// Pre-populate ports cache
 		if layer.HasPorts {
@@ -208,6 +625,10 @@ func scanLayer(path string, name string) (*Layer, error) {
 		// Pre-populate aliases
 		layer.HasAliases = len(ly.Aliases) > 0
 		layer.aliases = ly.Aliases
+
+		// Pre-populate healthcheck
+		layer.HasHealthcheck = ly.Healthcheck != nil
+		layer.healthcheck = ly.Healthcheck
 	}
 
 	return layer, nil
@@ -217,12 +638,22 @@ func scanLayer(path string, name string) (*Layer, error) {
 func (l *Layer) HasInstallFiles() bool {
 	hasRpm := l.rpmConfig != nil && len(l.rpmConfig.Packages) > 0
 	hasDeb := l.debConfig != nil && len(l.debConfig.Packages) > 0
-	return hasRpm || hasDeb || l.HasRootYml ||
-		l.HasPixiToml || l.HasPyprojectToml || l.HasEnvironmentYml ||
-		l.HasPackageJson || l.HasCargoToml || l.HasUserYml
+	hasApk := l.apkConfig != nil && len(l.apkConfig.Packages) > 0
+	return hasRpm || hasDeb || hasApk || l.HasFiles || l.HasSystemd || l.HasRootYml ||
+		l.HasPixiToml || l.HasPyprojectToml || l.HasEnvironmentYml || l.HasRequirementsTxt ||
+		l.HasPackageJson || l.HasCargoToml || l.HasGoMod || l.HasUserYml
+}
+
+// FilesOwner returns the owner for files/ contents: "root" (default) or "user"
+func (l *Layer) FilesOwner() string {
+	if l.filesOwner == "" {
+		return "root"
+	}
+	return l.filesOwner
 }
 
-// PixiManifest returns the filename of the pixi manifest if it exists
+// PixiManifest returns the filename of the pixi manifest if it exists.
+// requirements.txt is imported as PyPI dependencies of a pixi environment.
 func (l *Layer) PixiManifest() string {
 	if l.HasPixiToml {
 		return "pixi.toml"
@@ -233,6 +664,9 @@ func (l *Layer) PixiManifest() string {
 	if l.HasEnvironmentYml {
 		return "environment.yml"
 	}
+	if l.HasRequirementsTxt {
+		return "requirements.txt"
+	}
 	return ""
 }
 
@@ -241,11 +675,32 @@ func (l *Layer) RpmConfig() *RpmConfig {
 	return l.rpmConfig
 }
 
+// RpmPackages returns the parsed rpm packages entries (pins and exclusions)
+func (l *Layer) RpmPackages() ([]PackageSpec, error) {
+	if l.rpmConfig == nil {
+		return nil, nil
+	}
+	return l.rpmConfig.Specs()
+}
+
+// DebPackages returns the parsed deb packages entries (pins and exclusions)
+func (l *Layer) DebPackages() ([]PackageSpec, error) {
+	if l.debConfig == nil {
+		return nil, nil
+	}
+	return l.debConfig.Specs()
+}
+
 // DebConfig returns the Debian package config (pre-populated from layer.yml)
 func (l *Layer) DebConfig() *DebConfig {
 	return l.debConfig
 }
 
+// ApkConfig returns the Alpine package config (pre-populated from layer.yml)
+func (l *Layer) ApkConfig() *ApkConfig {
+	return l.apkConfig
+}
+
 // EnvConfig returns the environment config (pre-populated from layer.yml)
 func (l *Layer) EnvConfig() (*EnvConfig, error) {
 	if l.envConfig != nil {
@@ -267,6 +722,68 @@ func (l *Layer) ServiceConf() string {
 	return l.serviceConf
 }
 
+// Healthcheck returns the healthcheck config (pre-populated from layer.yml)
+func (l *Layer) Healthcheck() *HealthcheckConfig {
+	return l.healthcheck
+}
+
+// SystemdUnits returns the unit file names in the layer's systemd/ directory (sorted)
+func (l *Layer) SystemdUnits() []string {
+	return l.systemdUnits
+}
+
+// systemdUnitSuffixes lists the unit types shipped from a layer's systemd/ directory
+var systemdUnitSuffixes = []string{".service", ".socket", ".timer", ".path", ".mount", ".target"}
+
+// scanSystemdUnits returns the sorted unit file names in dir
+func scanSystemdUnits(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading systemd directory: %w", err)
+	}
+	var units []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		for _, suffix := range systemdUnitSuffixes {
+			if strings.HasSuffix(entry.Name(), suffix) {
+				units = append(units, entry.Name())
+				break
+			}
+		}
+	}
+	return units, nil // os.ReadDir sorts by name
+}
+
+// Platforms returns the platforms the layer installs on (empty means all)
+func (l *Layer) Platforms() []string {
+	return l.platforms
+}
+
+// Secrets returns the build secret ids the layer's install steps mount
+func (l *Layer) Secrets() []string {
+	return l.secrets
+}
+
+// DefaultServicePriority is the supervisord fragment priority for layers that don't set one
+const DefaultServicePriority = 50
+
+// ServicePriority returns the supervisord fragment priority (default 50)
+func (l *Layer) ServicePriority() int {
+	if l.priority == 0 {
+		return DefaultServicePriority
+	}
+	return l.priority
+}
+
+// ServiceFragmentName returns the supervisord fragment file name, "<priority>-<layer>.conf".
+// It depends only on the layer itself, so adding or removing other layers
+// never renames existing fragments (and never busts their build cache).
+func (l *Layer) ServiceFragmentName() string {
+	return fmt.Sprintf("%02d-%s.conf", l.ServicePriority(), layerStageName(l.Name))
+}
+
 // RouteConfig represents a route file declaration
 type RouteConfig struct {
 	Host string
@@ -382,6 +899,25 @@ func fileExists(path string) bool {
 	return !info.IsDir()
 }
 
+// hasGoSources reports whether any .go file exists under dir (skipping files/)
+func hasGoSources(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && path != dir && d.Name() == "files" {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && strings.HasSuffix(d.Name(), ".go") {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
 // dirExists checks if a directory exists
 func dirExists(path string) bool {
 	info, err := os.Stat(path)
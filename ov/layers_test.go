@@ -1,7 +1,10 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -11,7 +14,7 @@ func TestScanLayers(t *testing.T) {
 		t.Fatalf("ScanLayers() error = %v", err)
 	}
 
-	expectedLayers := []string{"pixi", "python", "nodejs", "cargo-tool", "webservice", "pixi-locked"}
+	expectedLayers := []string{"pixi", "python", "nodejs", "cargo-tool", "webservice", "pixi-locked", "dotfiles", "pip-tool", "go-tool", "hello-units", "apt-extras", "pinned", "orphaned"}
 	for _, name := range expectedLayers {
 		if _, ok := layers[name]; !ok {
 			t.Errorf("missing layer %q", name)
@@ -93,6 +96,14 @@ func TestLayerNodejs(t *testing.T) {
 	if !reflect.DeepEqual(deb.Packages, []string{"nodejs", "npm"}) {
 		t.Errorf("DebConfig().Packages = %v, want [nodejs npm]", deb.Packages)
 	}
+
+	apk := nodejs.ApkConfig()
+	if apk == nil {
+		t.Fatal("nodejs should have apk config")
+	}
+	if !reflect.DeepEqual(apk.Packages, []string{"nodejs", "npm"}) {
+		t.Errorf("ApkConfig().Packages = %v, want [nodejs npm]", apk.Packages)
+	}
 }
 
 func TestLayerCargoTool(t *testing.T) {
@@ -134,8 +145,8 @@ func TestLayerNames(t *testing.T) {
 	}
 
 	names := LayerNames(layers)
-	if len(names) != 6 {
-		t.Errorf("LayerNames() returned %d names, want 6", len(names))
+	if len(names) != 13 {
+		t.Errorf("LayerNames() returned %d names, want 12", len(names))
 	}
 
 	// Should be sorted
@@ -180,6 +191,65 @@ func TestLayerPorts(t *testing.T) {
 	}
 }
 
+func TestLayerMetadata(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	ws := layers["webservice"]
+	if ws.Description != "Demo web service with ports, route, volume and alias" {
+		t.Errorf("Description = %q", ws.Description)
+	}
+	if ws.Maintainer != "Overthink Maintainers <maintainers@example.com>" {
+		t.Errorf("Maintainer = %q", ws.Maintainer)
+	}
+	if layers["pixi"].Description != "" {
+		t.Errorf("pixi Description = %q, want empty", layers["pixi"].Description)
+	}
+}
+
+func TestParseLayerYAMLUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "layer.yml")
+	content := "description: tools\nowner: team-a\nrpm:\n  packages: [git]\nvolumes:\n  - name: data\n    path: /data\n    mode: rw\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ly, warnings, err := parseLayerYAML(path)
+	if err != nil {
+		t.Fatalf("parseLayerYAML() error = %v", err)
+	}
+	if ly.Description != "tools" {
+		t.Errorf("Description = %q, want tools", ly.Description)
+	}
+	want := []string{"owner: unknown field", "volumes[0].mode: unknown field"}
+	if !reflect.DeepEqual(warnings, want) {
+		t.Errorf("warnings = %q, want %q", warnings, want)
+	}
+}
+
+func TestScanLayersMalformedYAML(t *testing.T) {
+	dir := t.TempDir()
+	layerDir := filepath.Join(dir, "layers", "broken")
+	if err := os.MkdirAll(layerDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(layerDir, "layer.yml"), []byte("description: ok\ndepends: [a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := ScanLayers(dir)
+	if err == nil {
+		t.Fatal("ScanLayers() expected error for malformed layer.yml")
+	}
+	for _, want := range []string{filepath.Join("layers", "broken", "layer.yml"), "line "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want it to contain %q", err, want)
+		}
+	}
+}
+
 func TestLayerPortsNone(t *testing.T) {
 	layers, err := ScanLayers("testdata")
 	if err != nil {
@@ -372,3 +442,298 @@ func TestRouteLayers(t *testing.T) {
 	}
 }
 
+func TestLayerFilesOnly(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	dotfiles := layers["dotfiles"]
+	if dotfiles == nil {
+		t.Fatal("dotfiles layer not found")
+	}
+
+	if !dotfiles.HasFiles {
+		t.Error("dotfiles should have files/")
+	}
+	if !dotfiles.HasInstallFiles() {
+		t.Error("a layer with only files/ should count as having install files")
+	}
+	if dotfiles.FilesOwner() != "root" {
+		t.Errorf("FilesOwner() = %q, want root", dotfiles.FilesOwner())
+	}
+}
+
+func TestLayerHealthcheck(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	ws := layers["webservice"]
+	if !ws.HasHealthcheck {
+		t.Fatal("webservice should have healthcheck")
+	}
+	want := &HealthcheckConfig{
+		Cmd:      Command{"curl", "-fsS", "http://localhost:8080/health"},
+		Interval: "30s",
+		Timeout:  "5s",
+		Retries:  3,
+	}
+	if !reflect.DeepEqual(ws.Healthcheck(), want) {
+		t.Errorf("Healthcheck() = %+v, want %+v", ws.Healthcheck(), want)
+	}
+
+	if layers["pixi"].HasHealthcheck || layers["pixi"].Healthcheck() != nil {
+		t.Error("pixi should not have healthcheck")
+	}
+}
+
+func TestLayerRequirementsTxt(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	pip := layers["pip-tool"]
+	if pip == nil {
+		t.Fatal("pip-tool layer not found")
+	}
+	if !pip.HasRequirementsTxt {
+		t.Error("pip-tool should have requirements.txt")
+	}
+	if pip.PixiManifest() != "requirements.txt" {
+		t.Errorf("PixiManifest() = %q, want %q", pip.PixiManifest(), "requirements.txt")
+	}
+	if !pip.HasInstallFiles() {
+		t.Error("requirements.txt-only layer should have install files")
+	}
+	if layers["python"].HasRequirementsTxt {
+		t.Error("python should not have requirements.txt")
+	}
+}
+
+func TestLayerGoTool(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	goTool := layers["go-tool"]
+	if goTool == nil {
+		t.Fatal("go-tool layer not found")
+	}
+	if !goTool.HasGoMod {
+		t.Error("go-tool should have go.mod")
+	}
+	if !goTool.HasGoSources {
+		t.Error("go-tool should have Go sources (cmd/hello/main.go)")
+	}
+	if !goTool.HasInstallFiles() {
+		t.Error("go.mod layer should have install files")
+	}
+	if layers["cargo-tool"].HasGoMod {
+		t.Error("cargo-tool should not have go.mod")
+	}
+}
+
+func TestLayerDebRepos(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	extras := layers["apt-extras"]
+	if extras == nil {
+		t.Fatal("apt-extras layer not found")
+	}
+	deb := extras.DebConfig()
+	if deb == nil {
+		t.Fatal("apt-extras should have deb config")
+	}
+	// Comments and blank lines in layer.yml are not entries
+	if !reflect.DeepEqual(deb.PPAs(), []string{"ppa:deadsnakes/ppa"}) {
+		t.Errorf("PPAs() = %v", deb.PPAs())
+	}
+	wantSources := []string{"deb [signed-by=/etc/apt/keyrings/example.asc] https://apt.example.com/ stable main"}
+	if !reflect.DeepEqual(deb.Sources(), wantSources) {
+		t.Errorf("Sources() = %v, want %v", deb.Sources(), wantSources)
+	}
+	wantKeys := []DebKey{{Name: "example", URL: "https://apt.example.com/key.asc"}}
+	if !reflect.DeepEqual(deb.Keys, wantKeys) {
+		t.Errorf("Keys = %v, want %v", deb.Keys, wantKeys)
+	}
+	if !reflect.DeepEqual(deb.Packages, []string{"python3.12", "example-tool"}) {
+		t.Errorf("Packages = %v", deb.Packages)
+	}
+}
+
+func TestLayerPackageSpecs(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	pinned := layers["pinned"]
+	if pinned == nil {
+		t.Fatal("pinned layer not found")
+	}
+
+	// Comments and blank lines are skipped; lines point into layer.yml
+	wantRpm := []PackageSpec{
+		{Name: "nodejs", Version: "20.11.*", Line: 6},
+		{Name: "git-core", Line: 8},
+		{Name: "nodejs-docs", Exclude: true, Line: 9},
+	}
+	if got, err := pinned.RpmPackages(); err != nil || !reflect.DeepEqual(got, wantRpm) {
+		t.Errorf("RpmPackages() = %+v, %v; want %+v", got, err, wantRpm)
+	}
+
+	wantDeb := []PackageSpec{
+		{Name: "nodejs", Version: "20.11.1-1nodesource1", Line: 13},
+		{Name: "git", Line: 14},
+		{Name: "nodejs-doc", Exclude: true, Line: 15},
+	}
+	if got, err := pinned.DebPackages(); err != nil || !reflect.DeepEqual(got, wantDeb) {
+		t.Errorf("DebPackages() = %+v, %v; want %+v", got, err, wantDeb)
+	}
+
+	// Malformed entries are reported with their line, not dropped
+	rpm := &RpmConfig{Packages: []string{"git", "nodejs >= 20"}, lines: []int{4, 5}}
+	if specs, err := rpm.Specs(); err == nil || !strings.Contains(err.Error(), "layer.yml line 5: rpm.packages: ") {
+		t.Errorf("Specs() = %+v, %v; want the line 5 error", specs, err)
+	}
+}
+
+func TestParsePackageSpec(t *testing.T) {
+	tests := []struct {
+		entry   string
+		want    PackageSpec
+		wantErr bool
+	}{
+		{entry: "git", want: PackageSpec{Name: "git"}},
+		{entry: "nodejs-20.11.*", want: PackageSpec{Name: "nodejs-20.11.*"}},
+		{entry: "python3=3.12.1-1", want: PackageSpec{Name: "python3", Version: "3.12.1-1"}},
+		{entry: "libfoo:amd64=1:2.0~rc1", want: PackageSpec{Name: "libfoo:amd64", Version: "1:2.0~rc1"}},
+		{entry: "!kernel-debug*", want: PackageSpec{Name: "kernel-debug*", Exclude: true}},
+		{entry: "@development-tools", want: PackageSpec{Name: "@development-tools"}},
+		{entry: "@^workstation-product-environment", want: PackageSpec{Name: "@^workstation-product-environment"}},
+		{entry: "/usr/bin/xclip", want: PackageSpec{Name: "/usr/bin/xclip"}},
+		{entry: "pkgconfig(gtk+-3.0)", want: PackageSpec{Name: "pkgconfig(gtk+-3.0)"}},
+		{entry: "nodejs/bookworm-backports", want: PackageSpec{Name: "nodejs/bookworm-backports"}},
+		{entry: "@development-tools=1", wantErr: true},
+		{entry: "!/usr/bin/xclip", wantErr: true},
+		{entry: "@", wantErr: true},
+		{entry: "nodejs=", wantErr: true},
+		{entry: "=1.0", wantErr: true},
+		{entry: "!nodejs=20", wantErr: true},
+		{entry: "nodejs >= 20", wantErr: true},
+		{entry: "nodejs=20=1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePackageSpec(tt.entry)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePackageSpec(%q) expected error, got %+v", tt.entry, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParsePackageSpec(%q) error = %v", tt.entry, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePackageSpec(%q) = %+v, want %+v", tt.entry, got, tt.want)
+		}
+	}
+}
+
+func TestLayerSystemdUnits(t *testing.T) {
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	units := layers["hello-units"]
+	if units == nil {
+		t.Fatal("hello-units layer not found")
+	}
+	if !units.HasSystemd {
+		t.Error("hello-units should have systemd/")
+	}
+	if !units.HasInstallFiles() {
+		t.Error("systemd-only layer should have install files")
+	}
+	want := []string{"hello.service", "hello.timer", "worker@.service"}
+	if !reflect.DeepEqual(units.SystemdUnits(), want) {
+		t.Errorf("SystemdUnits() = %v, want %v", units.SystemdUnits(), want)
+	}
+	if layers["pixi"].HasSystemd {
+		t.Error("pixi should not have systemd/")
+	}
+}
+
+// writeNestedLayers creates a two-level layer hierarchy under a temp project dir
+func writeNestedLayers(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for rel, content := range files {
+		path := filepath.Join(dir, "layers", rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestScanLayersNested(t *testing.T) {
+	dir := writeNestedLayers(t, map[string]string{
+		"tool/root.yml":             "version: '3'\n",
+		"lang/python/layer.yml":     "depends: [tool]\n",
+		"lang/python/root.yml":      "version: '3'\n",
+		"lang/go/files/etc/go.conf": "x\n",
+		"svc/web/layer.yml":         "depends: [python, lang/go]\nservice: |\n  [program:web]\n",
+	})
+
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+	if got, want := LayerNames(layers), []string{"lang/go", "lang/python", "svc/web", "tool"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("LayerNames() = %v, want %v", got, want)
+	}
+	if got := layers["lang/python"].Path; got != filepath.Join(dir, "layers", "lang", "python") {
+		t.Errorf("lang/python Path = %q", got)
+	}
+	// A files/-only layer is a layer, not a namespace
+	if !layers["lang/go"].HasFiles {
+		t.Error("lang/go should have files/")
+	}
+	// Leaf references resolve to the full name
+	if got, want := layers["svc/web"].Depends, []string{"lang/python", "lang/go"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("svc/web Depends = %v, want %v", got, want)
+	}
+	if got := layers["svc/web"].ServiceFragmentName(); got != "50-svc-web.conf" {
+		t.Errorf("ServiceFragmentName() = %q, want 50-svc-web.conf", got)
+	}
+}
+
+func TestScanLayersNestedAmbiguousDepends(t *testing.T) {
+	dir := writeNestedLayers(t, map[string]string{
+		"lang/python/root.yml": "version: '3'\n",
+		"ml/python/root.yml":   "version: '3'\n",
+		"app/layer.yml":        "depends: [python]\n",
+	})
+
+	_, err := ScanLayers(dir)
+	if err == nil {
+		t.Fatal("ScanLayers() expected error for ambiguous leaf name")
+	}
+	if want := `layer app: depends "python" is ambiguous (lang/python, ml/python)`; err.Error() != want {
+		t.Errorf("error = %q, want %q", err, want)
+	}
+}
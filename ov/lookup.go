@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ImageMatch is an image found by FindImagesFor
+type ImageMatch struct {
+	Image string
+	Via   []string // how it matched: "layer", "alias"
+
+	// Base or builder of other images: only picked when nothing else matches
+	Base    bool
+	Builder bool
+}
+
+// Preferred reports whether the image is meant to be run itself, i.e. it is
+// neither the internal base nor the builder of another image
+func (m ImageMatch) Preferred() bool {
+	return !m.Base && !m.Builder
+}
+
+// FindImagesFor returns the enabled images that install the layer name (base
+// chain included) or define the alias name (see CollectImageAliases), sorted
+// by image name
+func FindImagesFor(cfg *Config, layers map[string]*Layer, name string) ([]ImageMatch, error) {
+	images, err := cfg.ResolveAllImages(MetadataTag)
+	if err != nil {
+		return nil, err
+	}
+	bases := make(map[string]bool)
+	builders := make(map[string]bool)
+	for imageName, img := range images {
+		if !img.IsExternalBase {
+			bases[img.Base] = true
+		}
+		if img.Builder != "" && img.Builder != imageName {
+			builders[img.Builder] = true
+		}
+	}
+
+	var matches []ImageMatch
+	for _, imageName := range cfg.ImageNames() {
+		m := ImageMatch{Image: imageName, Base: bases[imageName], Builder: builders[imageName]}
+		if _, ok := layers[name]; ok {
+			provided, err := LayersProvidedByImage(imageName, images, layers)
+			if err != nil {
+				return nil, err
+			}
+			if provided[name] {
+				m.Via = append(m.Via, "layer")
+			}
+		}
+		aliases, err := CollectImageAliases(cfg, layers, imageName)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range aliases {
+			if a.Name == name {
+				m.Via = append(m.Via, "alias")
+				break
+			}
+		}
+		if len(m.Via) > 0 {
+			matches = append(matches, m)
+		}
+	}
+	return matches, nil
+}
+
+// pickImage chooses the image of FindImagesFor matches for name: the only
+// match, or else the only preferred one. No match lists the available images,
+// several list the candidates.
+func pickImage(name string, matches []ImageMatch, available []string) (ImageMatch, error) {
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	var preferred []ImageMatch
+	for _, m := range matches {
+		if m.Preferred() {
+			preferred = append(preferred, m)
+		}
+	}
+	if len(preferred) == 1 {
+		return preferred[0], nil
+	}
+	if len(matches) == 0 {
+		return ImageMatch{}, fmt.Errorf("no image, layer or alias named %q; images: %s", name, strings.Join(available, ", "))
+	}
+
+	var candidates []string
+	for _, m := range matches {
+		if len(preferred) > 0 && !m.Preferred() {
+			continue
+		}
+		candidates = append(candidates, m.Image)
+	}
+	return ImageMatch{}, fmt.Errorf("%q is in several images, name one of them: %s", name, strings.Join(candidates, ", "))
+}
+
+// resolveImageName returns name if it is an image of cfg, or else the image
+// that pickImage chooses for the layer or alias name
+func resolveImageName(cfg *Config, dir, name string) (string, error) {
+	if _, ok := cfg.Images[name]; ok {
+		return name, nil
+	}
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		return "", err
+	}
+	matches, err := FindImagesFor(cfg, layers, name)
+	if err != nil {
+		return "", err
+	}
+	m, err := pickImage(name, matches, cfg.ImageNames())
+	if err != nil {
+		return "", err
+	}
+	return m.Image, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindImagesFor(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Base: "quay.io/fedora/fedora:43", Builder: "builder"},
+		Images: map[string]ImageConfig{
+			"base":    {Layers: []string{"devtools"}},
+			"builder": {Layers: []string{"pixi"}},
+			"app":     {Base: "base", Layers: []string{"openclaw"}},
+			"web":     {Base: "base", Layers: []string{"node"}, Aliases: []AliasConfig{{Name: "oc", Command: "openclaw"}}},
+			"old":     {Layers: []string{"openclaw"}, Enabled: boolPtr(false)},
+		},
+	}
+	layers := map[string]*Layer{
+		"devtools": {Name: "devtools"},
+		"pixi":     {Name: "pixi"},
+		"node":     {Name: "node"},
+		"openclaw": {Name: "openclaw", HasUserYml: true, HasAliases: true, aliases: []AliasYAML{{Name: "oc", Command: "openclaw"}}},
+	}
+
+	tests := []struct {
+		name string
+		want []ImageMatch
+	}{
+		{"openclaw", []ImageMatch{{Image: "app", Via: []string{"layer"}}}},
+		{"oc", []ImageMatch{{Image: "app", Via: []string{"alias"}}, {Image: "web", Via: []string{"alias"}}}},
+		{"devtools", []ImageMatch{
+			{Image: "app", Via: []string{"layer"}},
+			{Image: "base", Via: []string{"layer"}, Base: true},
+			{Image: "web", Via: []string{"layer"}},
+		}},
+		{"pixi", []ImageMatch{{Image: "builder", Via: []string{"layer"}, Builder: true}}},
+		{"nothing", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindImagesFor(cfg, layers, tt.name)
+			if err != nil {
+				t.Fatalf("FindImagesFor() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindImagesFor(%q) = %+v, want %+v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPickImage(t *testing.T) {
+	available := []string{"app", "base", "builder", "web"}
+	app := ImageMatch{Image: "app", Via: []string{"layer"}}
+	web := ImageMatch{Image: "web", Via: []string{"alias"}}
+	base := ImageMatch{Image: "base", Via: []string{"layer"}, Base: true}
+	builder := ImageMatch{Image: "builder", Via: []string{"layer"}, Builder: true}
+
+	tests := []struct {
+		name    string
+		matches []ImageMatch
+		want    string
+		wantErr string
+	}{
+		{"single", []ImageMatch{app}, "app", ""},
+		{"single builder", []ImageMatch{builder}, "builder", ""},
+		{"preferred over base", []ImageMatch{app, base}, "app", ""},
+		{"preferred over builder", []ImageMatch{builder, web}, "web", ""},
+		{"several preferred", []ImageMatch{app, base, web}, "", `"x" is in several images, name one of them: app, web`},
+		{"only base and builder", []ImageMatch{base, builder}, "", `"x" is in several images, name one of them: base, builder`},
+		{"none", nil, "", `no image, layer or alias named "x"; images: app, base, builder, web`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := pickImage("x", tt.matches, available)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("pickImage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got.Image != tt.want {
+				t.Errorf("pickImage() = %q, %v; want %q", got.Image, err, tt.want)
+			}
+		})
+	}
+}
@@ -4,36 +4,53 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/alecthomas/kong"
 )
 
 // CLI defines the command-line interface structure
 type CLI struct {
-	Generate GenerateCmd `cmd:"" help:"Write .build/ (Containerfiles)"`
-	Validate ValidateCmd `cmd:"" help:"Check images.yml + layers, exit 0 or 1"`
-	Inspect  InspectCmd  `cmd:"" help:"Print resolved config for an image (JSON)"`
-	List     ListCmd     `cmd:"" help:"List components"`
-	New      NewCmd      `cmd:"" help:"Scaffold new components"`
-	Build    BuildCmd    `cmd:"" help:"Build container images"`
-	Merge    MergeCmd    `cmd:"" help:"Merge small layers in a built container image"`
-	Shell    ShellCmd    `cmd:"" help:"Start a bash shell in a container image"`
-	Start    StartCmd    `cmd:"" help:"Start a service container with supervisord (detached)"`
-	Stop     StopCmd     `cmd:"" help:"Stop a running service container"`
-	Enable   EnableCmd   `cmd:"" help:"Enable a service (quadlet: generate .container + reload)"`
-	Disable  DisableCmd  `cmd:"" help:"Disable service auto-start (quadlet only)"`
-	Status   StatusCmd   `cmd:"" help:"Show service container status"`
-	Logs     LogsCmd     `cmd:"" help:"Show service container logs"`
-	Update   UpdateCmd   `cmd:"" help:"Update image and restart if active"`
-	Remove   RemoveCmd   `cmd:"" help:"Remove service container"`
-	Alias    AliasCmd    `cmd:"" help:"Manage command aliases for container images"`
-	Config   ConfigCmd   `cmd:"" help:"Manage runtime configuration"`
-	Version  VersionCmd  `cmd:"" help:"Print computed CalVer tag"`
+	Quiet bool `short:"q" long:"quiet" help:"Don't show the progress of image transfers between engines"`
+
+	Generate      GenerateCmd      `cmd:"" help:"Write .build/ (Containerfiles)"`
+	Validate      ValidateCmd      `cmd:"" help:"Check images.yml + layers, exit 0 or 1"`
+	Schema        SchemaCmd        `cmd:"" help:"Print a JSON Schema for images.yml"`
+	Inspect       InspectCmd       `cmd:"" help:"Print resolved config for an image (JSON)"`
+	List          ListCmd          `cmd:"" help:"List components"`
+	Images        ImagesCmd        `cmd:"" help:"Show the project's images in the build and run engines (size, tag, staleness)"`
+	Intermediates IntermediatesCmd `cmd:"" help:"Explain the computed intermediates (tries, reused images, base changes)"`
+	New           NewCmd           `cmd:"" help:"Scaffold new components"`
+	Build         BuildCmd         `cmd:"" help:"Build container images"`
+	Merge         MergeCmd         `cmd:"" help:"Merge small layers in a built container image"`
+	Prune         PruneCmd         `cmd:"" help:"Remove old tags of the project's images from the local store"`
+	Shell         ShellCmd         `cmd:"" help:"Start a bash shell in a container image"`
+	Start         StartCmd         `cmd:"" help:"Start a service container with supervisord (detached)"`
+	Stop          StopCmd          `cmd:"" help:"Stop a running service container"`
+	Up            UpCmd            `cmd:"" help:"Run a service image detached for this project (restart policy, GPUs)"`
+	Down          DownCmd          `cmd:"" help:"Stop and remove the service container of ov up"`
+	Ps            PsCmd            `cmd:"" help:"List the service containers of ov up"`
+	Enable        EnableCmd        `cmd:"" help:"Enable a service (quadlet: generate .container + reload)"`
+	Disable       DisableCmd       `cmd:"" help:"Disable service auto-start (quadlet only)"`
+	Status        StatusCmd        `cmd:"" help:"Show service container status"`
+	Logs          LogsCmd          `cmd:"" help:"Show service container logs"`
+	Update        UpdateCmd        `cmd:"" help:"Update image and restart if active"`
+	UpdateLayers  UpdateLayersCmd  `cmd:"" help:"Refetch remote layers and refresh remote-layers.lock"`
+	Remove        RemoveCmd        `cmd:"" help:"Remove service container"`
+	Alias         AliasCmd         `cmd:"" help:"Manage command aliases for container images"`
+	Config        ConfigCmd        `cmd:"" help:"Manage runtime configuration"`
+	Doctor        DoctorCmd        `cmd:"" help:"Check the endpoint and server of each container engine"`
+	Version       VersionCmd       `cmd:"" help:"Print computed tag (CalVer or defaults.tag_format)"`
 }
 
 // GenerateCmd generates Containerfiles
 type GenerateCmd struct {
-	Tag string `long:"tag" help:"Override tag (default: CalVer)"`
+	Tag     string `long:"tag" help:"Override tag (default: CalVer)"`
+	Format  string `long:"format" help:"Extra build description: gha-matrix (print JSON instead of writing .build/) or script (also write .build/build.sh for podman)"`
+	Compose bool   `long:"compose" help:"Also write .build/compose.yaml for service images"`
+
+	Profile         string `long:"profile" help:"Apply a profile from images.yml"`
+	IncludeDisabled bool   `long:"include-disabled" help:"Also generate images with enabled: false"`
 }
 
 func (c *GenerateCmd) Run() error {
@@ -42,16 +59,40 @@ func (c *GenerateCmd) Run() error {
 		return err
 	}
 
-	gen, err := NewGenerator(dir, c.Tag)
+	gen, err := NewGenerator(dir, c.Tag, ConfigOptions{Profile: c.Profile, IncludeDisabled: c.IncludeDisabled})
 	if err != nil {
 		return err
 	}
 
-	return gen.Generate()
+	switch c.Format {
+	case "", "script":
+		gen.Compose = c.Compose
+		gen.Script = c.Format == "script"
+		return gen.Generate()
+	case "gha-matrix":
+		order, err := ResolveImageOrder(gen.Images, gen.Layers)
+		if err != nil {
+			return err
+		}
+		data, err := ExportGitHubMatrix(order, gen.Images, gen.Layers).JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	default:
+		return fmt.Errorf("unknown format: %s", c.Format)
+	}
 }
 
 // ValidateCmd validates images.yml and layers
-type ValidateCmd struct{}
+type ValidateCmd struct {
+	Profile         string `long:"profile" help:"Apply a profile from images.yml"`
+	IncludeDisabled bool   `long:"include-disabled" help:"Also validate images with enabled: false"`
+	Conflicts       bool   `long:"conflicts" help:"Also report files installed by more than one layer of an image"`
+	Remote          bool   `long:"remote" help:"Also check that the external base images exist in their registries"`
+	Deep            bool   `long:"deep" help:"Also parse the layer files (root.yml, user.yml, pixi.toml, package.json, ...), as validate.deep: true does"`
+}
 
 func (c *ValidateCmd) Run() error {
 	dir, err := os.Getwd()
@@ -59,7 +100,7 @@ func (c *ValidateCmd) Run() error {
 		return err
 	}
 
-	cfg, err := LoadConfig(dir)
+	cfg, err := LoadConfigWith(dir, ConfigOptions{Profile: c.Profile, IncludeDisabled: c.IncludeDisabled})
 	if err != nil {
 		return err
 	}
@@ -69,7 +110,114 @@ func (c *ValidateCmd) Run() error {
 		return err
 	}
 
-	return Validate(cfg, layers)
+	if err := Validate(cfg, layers); err != nil {
+		return err
+	}
+	if err := warnBranchConflicts(cfg, layers); err != nil {
+		return err
+	}
+	if c.Deep || cfg.Validate.Deep {
+		if err := ValidateLayerFiles(layers); err != nil {
+			return err
+		}
+	}
+	if c.Conflicts {
+		if err := ValidateFileConflicts(cfg, layers); err != nil {
+			return err
+		}
+	}
+	if c.Remote {
+		return ValidateRemoteBases(cfg)
+	}
+	return nil
+}
+
+// SchemaCmd prints the images.yml JSON Schema
+type SchemaCmd struct{}
+
+func (c *SchemaCmd) Run() error {
+	data, err := ConfigSchema()
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// UpdateLayersCmd refetches every remote layer at its ref
+type UpdateLayersCmd struct{}
+
+func (c *UpdateLayersCmd) Run() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	if _, err := SyncRemoteLayers(dir, true); err != nil {
+		return err
+	}
+	lock, err := readRemoteLayersLock(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for name := range lock {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t%s\t%s\n", name, lock[name].URL, lock[name].Commit)
+	}
+	return nil
+}
+
+// IntermediatesCmd explains how ComputeIntermediates arranged the images,
+// without writing .build/
+type IntermediatesCmd struct {
+	JSON bool `long:"json" help:"Print the plan as JSON"`
+
+	Profile         string `long:"profile" help:"Apply a profile from images.yml"`
+	IncludeDisabled bool   `long:"include-disabled" help:"Also plan images with enabled: false"`
+}
+
+func (c *IntermediatesCmd) Run() error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	cfg, err := LoadConfigWith(dir, ConfigOptions{Profile: c.Profile, IncludeDisabled: c.IncludeDisabled})
+	if err != nil {
+		return err
+	}
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		return err
+	}
+	if err := Validate(cfg, layers); err != nil {
+		return err
+	}
+
+	calverTag := ComputeTag(cfg, dir, time.Now().UTC())
+	images, err := cfg.ResolveAllImages(calverTag)
+	if err != nil {
+		return err
+	}
+	plan, err := PlanIntermediates(images, layers, cfg, calverTag)
+	if err != nil {
+		return err
+	}
+
+	if c.JSON {
+		data, err := plan.JSON()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+	fmt.Print(plan.Text())
+	return nil
 }
 
 // InspectCmd prints resolved config for an image
@@ -89,7 +237,7 @@ func (c *InspectCmd) Run() error {
 		return err
 	}
 
-	calverTag := ComputeCalVer()
+	calverTag := ComputeTag(cfg, dir, time.Now().UTC())
 	resolved, err := cfg.ResolveImage(c.Image, calverTag)
 	if err != nil {
 		return err
@@ -120,6 +268,18 @@ func (c *InspectCmd) Run() error {
 			for _, p := range resolved.Ports {
 				fmt.Println(p)
 			}
+		case "exposed":
+			layers, err := ScanLayers(dir)
+			if err != nil {
+				return err
+			}
+			exposed, err := CollectImageExposedPorts(cfg, layers, c.Image)
+			if err != nil {
+				return err
+			}
+			for _, p := range exposed {
+				fmt.Println(p)
+			}
 		case "volumes":
 			layers, err := ScanLayers(dir)
 			if err != nil {
@@ -151,6 +311,14 @@ func (c *InspectCmd) Run() error {
 	}
 
 	// Output full JSON
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		return err
+	}
+	resolved.ExposedPorts, err = CollectImageExposedPorts(cfg, layers, c.Image)
+	if err != nil {
+		return err
+	}
 	data, err := json.MarshalIndent(resolved, "", "  ")
 	if err != nil {
 		return err
@@ -191,7 +359,9 @@ func (c *ListImagesCmd) Run() error {
 }
 
 // ListLayersCmd lists layers from filesystem
-type ListLayersCmd struct{}
+type ListLayersCmd struct {
+	Unused bool `long:"unused" help:"Only layers no image uses, with their directory sizes"`
+}
 
 func (c *ListLayersCmd) Run() error {
 	dir, err := os.Getwd()
@@ -204,8 +374,38 @@ func (c *ListLayersCmd) Run() error {
 		return err
 	}
 
-	for _, name := range LayerNames(layers) {
-		fmt.Println(name)
+	if c.Unused {
+		cfg, err := LoadConfig(dir)
+		if err != nil {
+			return err
+		}
+		unused := FindUnusedLayers(cfg, layers)
+		width := 0
+		for _, name := range unused {
+			width = max(width, len(name))
+		}
+		for _, name := range unused {
+			size, err := dirSize(layers[name].Path)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%-*s  %s\n", width, name, formatSize(size))
+		}
+		return nil
+	}
+
+	// Descriptions from layer.yml form a second column
+	names := LayerNames(layers)
+	width := 0
+	for _, name := range names {
+		width = max(width, len(name))
+	}
+	for _, name := range names {
+		if desc := layers[name].Description; desc != "" {
+			fmt.Printf("%-*s  %s\n", width, name, desc)
+		} else {
+			fmt.Println(name)
+		}
 	}
 	return nil
 }
@@ -224,7 +424,7 @@ func (c *ListTargetsCmd) Run() error {
 		return err
 	}
 
-	calverTag := ComputeCalVer()
+	calverTag := ComputeTag(cfg, dir, time.Now().UTC())
 	images, err := cfg.ResolveAllImages(calverTag)
 	if err != nil {
 		return err
@@ -250,6 +450,8 @@ func (c *ListTargetsCmd) Run() error {
 		img := images[name]
 		if img.Auto {
 			fmt.Printf("%s [auto]\n", name)
+		} else if img.Pinned {
+			fmt.Printf("%s [pinned]\n", name)
 		} else {
 			fmt.Println(name)
 		}
@@ -371,7 +573,7 @@ type ConfigCmd struct {
 
 // ConfigGetCmd prints the resolved value for a key
 type ConfigGetCmd struct {
-	Key string `arg:"" help:"Config key (engine.build, engine.run, run_mode, auto_enable)"`
+	Key string `arg:"" help:"Config key (engine.build, engine.run, engine.docker_host, engine.podman_host, run_mode, auto_enable, transfer, pull_fallback)"`
 }
 
 func (c *ConfigGetCmd) Run() error {
@@ -386,6 +588,10 @@ func (c *ConfigGetCmd) Run() error {
 		fmt.Println(rt.BuildEngine)
 	case "engine.run":
 		fmt.Println(rt.RunEngine)
+	case "engine.docker_host":
+		fmt.Println(rt.DockerHost)
+	case "engine.podman_host":
+		fmt.Println(rt.PodmanHost)
 	case "run_mode":
 		fmt.Println(rt.RunMode)
 	case "auto_enable":
@@ -394,8 +600,12 @@ func (c *ConfigGetCmd) Run() error {
 		} else {
 			fmt.Println("false")
 		}
+	case "transfer":
+		fmt.Println(rt.Transfer)
+	case "pull_fallback":
+		fmt.Println(rt.PullFallback)
 	default:
-		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, run_mode, auto_enable)", c.Key)
+		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, engine.docker_host, engine.podman_host, run_mode, auto_enable, transfer, pull_fallback)", c.Key)
 	}
 	return nil
 }
@@ -423,7 +633,7 @@ func (c *ConfigListCmd) Run() error {
 		return err
 	}
 	for _, v := range vals {
-		fmt.Printf("%-15s %-10s (%s)\n", v.Key, v.Value, v.Source)
+		fmt.Printf("%-19s %-10s (%s)\n", v.Key, v.Value, v.Source)
 	}
 	return nil
 }
@@ -457,11 +667,16 @@ func (c *ConfigPathCmd) Run() error {
 	return nil
 }
 
-// VersionCmd prints the computed CalVer tag
+// VersionCmd prints the computed tag
 type VersionCmd struct{}
 
 func (c *VersionCmd) Run() error {
 	version := ComputeCalVer()
+	if dir, err := os.Getwd(); err == nil {
+		if cfg, err := LoadConfig(dir); err == nil {
+			version = ComputeTag(cfg, dir, time.Now().UTC())
+		}
+	}
 	println(version)
 	return nil
 }
@@ -473,6 +688,7 @@ func main() {
 		kong.Description("Overthink build system - composable container images"),
 		kong.UsageOnError(),
 	)
+	TransferProgress = !cli.Quiet && isTerminal(os.Stderr)
 	err := ctx.Run()
 	ctx.FatalIfErrorf(err)
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// MatrixEntry is one image in a GitHub Actions build matrix
+type MatrixEntry struct {
+	Name      string   `json:"name"`
+	Target    string   `json:"target"` // argument for ov build
+	Platforms []string `json:"platforms"`
+	Tag       string   `json:"tag"`
+	Auto      bool     `json:"auto"` // auto-generated intermediate
+}
+
+// MatrixWave is a set of images whose dependencies are all in earlier waves.
+// Include is shaped for strategy.matrix (fromJSON(...).waves[N]).
+type MatrixWave struct {
+	Include []MatrixEntry `json:"include"`
+}
+
+// GitHubMatrix groups images into dependency waves for GitHub Actions jobs
+type GitHubMatrix struct {
+	Waves []MatrixWave `json:"waves"`
+}
+
+// ExportGitHubMatrix groups images in build order into waves: images with only
+// external bases are wave 0, every other image is one wave after its deepest
+// dependency (base or builder). Entries keep build order within a wave.
+func ExportGitHubMatrix(order []string, images map[string]*ResolvedImage, layers map[string]*Layer) *GitHubMatrix {
+	deps := imageDependencies(images, layers)
+	wave := make(map[string]int)
+	matrix := &GitHubMatrix{}
+	for _, name := range order {
+		w := 0
+		for _, dep := range deps[name] {
+			if wave[dep]+1 > w {
+				w = wave[dep] + 1
+			}
+		}
+		wave[name] = w
+
+		for len(matrix.Waves) <= w {
+			matrix.Waves = append(matrix.Waves, MatrixWave{Include: []MatrixEntry{}})
+		}
+		img := images[name]
+		platforms := img.Platforms
+		if platforms == nil {
+			platforms = []string{}
+		}
+		matrix.Waves[w].Include = append(matrix.Waves[w].Include, MatrixEntry{
+			Name:      name,
+			Target:    name,
+			Platforms: platforms,
+			Tag:       img.FullTag,
+			Auto:      img.Auto,
+		})
+	}
+	return matrix
+}
+
+// JSON returns the matrix as indented JSON
+func (m *GitHubMatrix) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestExportGitHubMatrix(t *testing.T) {
+	images := map[string]*ResolvedImage{
+		"fedora": {
+			Name:           "fedora",
+			Base:           "quay.io/fedora/fedora:43",
+			IsExternalBase: true,
+			Platforms:      []string{"linux/amd64", "linux/arm64"},
+			FullTag:        "ghcr.io/overthinkos/fedora:2026.46.1415",
+		},
+		"fedora-python": {
+			Name:    "fedora-python",
+			Base:    "fedora",
+			Auto:    true,
+			FullTag: "ghcr.io/overthinkos/fedora-python:2026.46.1415",
+		},
+		"app": {
+			Name:    "app",
+			Base:    "fedora-python",
+			FullTag: "ghcr.io/overthinkos/app:2026.46.1415",
+		},
+		"tools": {
+			Name:    "tools",
+			Base:    "fedora",
+			FullTag: "ghcr.io/overthinkos/tools:2026.46.1415",
+		},
+	}
+
+	order, err := ResolveImageOrder(images, nil)
+	if err != nil {
+		t.Fatalf("ResolveImageOrder() error = %v", err)
+	}
+	matrix := ExportGitHubMatrix(order, images, nil)
+
+	var waves [][]string
+	for _, w := range matrix.Waves {
+		var names []string
+		for _, e := range w.Include {
+			names = append(names, e.Name)
+		}
+		sortStrings(names)
+		waves = append(waves, names)
+	}
+	want := [][]string{{"fedora"}, {"fedora-python", "tools"}, {"app"}}
+	if !reflect.DeepEqual(waves, want) {
+		t.Errorf("waves = %v, want %v", waves, want)
+	}
+
+	first := matrix.Waves[0].Include[0]
+	if first.Target != "fedora" || first.Tag != "ghcr.io/overthinkos/fedora:2026.46.1415" || len(first.Platforms) != 2 || first.Auto {
+		t.Errorf("wave 0 entry = %+v", first)
+	}
+
+	data, err := matrix.JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	var decoded struct {
+		Waves []struct {
+			Include []map[string]interface{} `json:"include"`
+		} `json:"waves"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	for _, e := range decoded.Waves[1].Include {
+		if e["name"] == "fedora-python" && e["auto"] != true {
+			t.Errorf("fedora-python should be marked auto: %v", e)
+		}
+	}
+}
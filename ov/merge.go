@@ -2,27 +2,36 @@ package main
 
 import (
 	"archive/tar"
-	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"os"
-	"os/exec"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 // MergeCmd merges small layers in a built container image
 type MergeCmd struct {
-	Image  string `arg:"" optional:"" help:"Image name from images.yml"`
-	All    bool   `long:"all" help:"Merge all images with merge.auto enabled"`
-	MaxMB  int    `long:"max-mb" help:"Maximum size of a merged layer (MB)"`
-	Tag    string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
-	DryRun bool   `long:"dry-run" help:"Print merge plan without modifying the image"`
+	Image    string `arg:"" optional:"" help:"Image name from images.yml"`
+	All      bool   `long:"all" help:"Merge all images with merge.auto enabled"`
+	MaxMB    int    `long:"max-mb" help:"Maximum size of a merged layer (MB)"`
+	MinMB    int    `long:"min-mb" help:"Merge groups below this size (MB) into the next one when it fits"`
+	Strategy string `long:"strategy" help:"Grouping strategy: greedy (default) or pack"`
+	Tag      string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
+	DryRun   bool   `long:"dry-run" help:"Print merge plan without modifying the image"`
+	JSON     bool   `long:"json" help:"With --dry-run, print the plan as JSON"`
+	Push     string `long:"push" help:"Push the merged image to this registry reference instead of saving it locally"`
+	Insecure bool   `long:"insecure" help:"With --push, allow plain HTTP and unverified TLS for the registry"`
 }
 
 // MergeStep represents one step in the merge plan
@@ -33,10 +42,25 @@ type MergeStep struct {
 
 const defaultMaxMB = 128
 
+// Merge strategies (merge.strategy)
+const (
+	MergeStrategyGreedy = "greedy" // fill each group in layer order (default)
+	MergeStrategyPack   = "pack"   // fewest layers, rewriting as few bytes as possible
+)
+
 func (c *MergeCmd) Run() error {
 	if c.Image == "" && !c.All {
 		return fmt.Errorf("specify an image name or use --all")
 	}
+	if c.JSON && !c.DryRun {
+		return fmt.Errorf("--json requires --dry-run")
+	}
+	if c.Push != "" && (c.All || c.DryRun) {
+		return fmt.Errorf("--push can't be combined with --all or --dry-run")
+	}
+	if c.Insecure && c.Push == "" {
+		return fmt.Errorf("--insecure requires --push")
+	}
 
 	dir, err := os.Getwd()
 	if err != nil {
@@ -48,35 +72,46 @@ func (c *MergeCmd) Run() error {
 		return err
 	}
 
+	var reports []*MergeReport
 	if c.All {
-		return c.runAll(cfg)
+		reports, err = c.runAll(cfg)
+	} else {
+		reports, err = c.runOne(cfg, c.Image)
+	}
+	if err != nil {
+		return err
+	}
+	if c.DryRun {
+		return c.printReports(reports)
 	}
-	return c.runOne(cfg, c.Image)
+	return nil
 }
 
-// runAll merges all images that have merge.auto enabled.
-func (c *MergeCmd) runAll(cfg *Config) error {
+// runAll merges all images that have merge.auto enabled. With --dry-run it
+// returns their reports instead.
+func (c *MergeCmd) runAll(cfg *Config) ([]*MergeReport, error) {
 	dir, err := os.Getwd()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	layers, err := ScanLayers(dir)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	images, err := cfg.ResolveAllImages("unused")
+	images, err := cfg.ResolveAllImages(MetadataTag)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Merge in dependency order so base images are merged before children
 	order, err := ResolveImageOrder(images, layers)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	var reports []*MergeReport
 	merged := 0
 	for _, name := range order {
 		resolved := images[name]
@@ -84,205 +119,798 @@ func (c *MergeCmd) runAll(cfg *Config) error {
 			continue
 		}
 		fmt.Fprintf(os.Stderr, "\n--- %s ---\n", name)
-		if err := c.runOne(cfg, name); err != nil {
-			return fmt.Errorf("merging %s: %w", name, err)
+		r, err := c.runOne(cfg, name)
+		if err != nil {
+			return nil, fmt.Errorf("merging %s: %w", name, err)
 		}
+		reports = append(reports, r...)
 		merged++
 	}
 
 	if merged == 0 {
 		fmt.Fprintf(os.Stderr, "No images have merge.auto enabled\n")
 	}
-	return nil
+	return reports, nil
 }
 
-// runOne merges a single image.
-func (c *MergeCmd) runOne(cfg *Config, imageName string) error {
-	resolved, err := cfg.ResolveImage(imageName, "unused")
+// runOne merges a single image. With --dry-run it returns the reports of the
+// merge instead.
+func (c *MergeCmd) runOne(cfg *Config, imageName string) ([]*MergeReport, error) {
+	resolved, err := cfg.ResolveImage(imageName, MetadataTag)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Determine max_mb: CLI flags -> images.yml -> default
-	maxMB := defaultMaxMB
-	if resolved.Merge != nil && resolved.Merge.MaxMB > 0 {
-		maxMB = resolved.Merge.MaxMB
+	switch c.Strategy {
+	case "", MergeStrategyGreedy, MergeStrategyPack:
+	default:
+		return nil, fmt.Errorf("--strategy %q must be %s or %s", c.Strategy, MergeStrategyGreedy, MergeStrategyPack)
 	}
-	if c.MaxMB > 0 {
-		maxMB = c.MaxMB
-	}
-
-	maxBytes := int64(maxMB) * 1024 * 1024
+	plan := newMergePlanner(resolved.Merge, c.MaxMB, c.MinMB, c.Strategy)
 
 	imageRef := resolveShellImageRef(resolved.Registry, resolved.Name, c.Tag)
 
 	// Resolve build engine for save/load
 	rt, err := ResolveRuntime()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	engine := rt.BuildEngine
 
-	img, cleanup, err := loadImageFromDaemon(imageRef, engine)
+	// Multi-platform builds leave a manifest list in podman's store
+	list := engine == "podman" && podmanManifestExists(imageRef)
+	if !list && len(resolved.Platforms) > 1 {
+		fmt.Fprintf(os.Stderr, "Warning: %s is built for %s, but the %s store has a single platform image of it; merging only that one\n",
+			imageName, strings.Join(resolved.Platforms, ", "), engine)
+	}
+
+	if c.DryRun {
+		return dryRunReports(imageRef, engine, list, plan)
+	}
+	if c.Push != "" {
+		return nil, pushMergedImage(engine, imageRef, c.Push, c.Insecure, plan, os.Stderr)
+	}
+	return nil, mergeEngineImage(engine, []string{imageRef}, plan, os.Stderr)
+}
+
+// newMergePlanner returns the planner for an image's merge settings, the CLI
+// flags maxMB, minMB and strategy overriding them when set
+func newMergePlanner(m *MergeConfig, maxMB, minMB int, strategy string) mergePlanner {
+	// max_mb, min_mb and strategy: CLI flags -> images.yml -> default
+	if maxMB <= 0 {
+		maxMB = defaultMaxMB
+		if m != nil && m.MaxMB > 0 {
+			maxMB = m.MaxMB
+		}
+	}
+	if minMB <= 0 && m != nil {
+		minMB = m.MinMB
+	}
+	if strategy == "" {
+		strategy = MergeStrategyGreedy
+		if m != nil && m.Strategy != "" {
+			strategy = m.Strategy
+		}
+	}
+	maxBytes := int64(maxMB) * 1024 * 1024
+	minBytes := int64(minMB) * 1024 * 1024
+
+	// Boundary markers only pin groups with merge.preserve_base_boundary
+	preserve := m != nil && m.PreserveBaseBoundary
+	plan := mergePlanner{
+		steps: func(sizes []int64, boundaries map[int]bool) []MergeStep {
+			if !preserve {
+				boundaries = nil
+			}
+			return planMergeWith(sizes, boundaries, maxBytes, minBytes, strategy)
+		},
+	}
+	if m != nil && m.Reproducible {
+		epoch := sourceDateEpoch()
+		plan.epoch = &epoch
+	}
+	return plan
+}
+
+// sourceDateEpoch returns the time in SOURCE_DATE_EPOCH, or the Unix epoch
+// when it is unset or invalid
+func sourceDateEpoch() time.Time {
+	v := os.Getenv("SOURCE_DATE_EPOCH")
+	if v == "" {
+		return time.Unix(0, 0).UTC()
+	}
+	sec, err := strconv.ParseInt(v, 10, 64)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "Warning: ignoring invalid SOURCE_DATE_EPOCH %q\n", v)
+		return time.Unix(0, 0).UTC()
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+// dryRunReports returns the merge report of imageRef, or of each platform
+// image of the podman manifest list imageRef
+func dryRunReports(imageRef, engine string, list bool, plan mergePlanner) ([]*MergeReport, error) {
+	if list {
+		idx, cleanup, err := loadIndexFromPodman(imageRef)
+		if err != nil {
+			return nil, err
+		}
+		defer cleanup()
+		var reports []*MergeReport
+		err = forEachPlatformImage(idx, func(platform string, img v1.Image) error {
+			r, err := planReport(imageRef, img, plan)
+			if err != nil {
+				return fmt.Errorf("%s: %w", platform, err)
+			}
+			r.Platform = platform
+			reports = append(reports, r)
+			return nil
+		})
+		return reports, err
+	}
+
+	img, cleanup, err := LoadEngineImage(imageRef, engine)
+	if err != nil {
+		return nil, err
 	}
 	defer cleanup()
+	r, err := planReport(imageRef, img, plan)
+	if err != nil {
+		return nil, err
+	}
+	return []*MergeReport{r}, nil
+}
 
+// planReport plans the merge of img and describes it
+func planReport(ref string, img v1.Image, plan mergePlanner) (*MergeReport, error) {
 	layers, err := img.Layers()
 	if err != nil {
-		return fmt.Errorf("reading layers: %w", err)
+		return nil, fmt.Errorf("reading layers: %w", err)
+	}
+	sizes, err := layerSizes(layers)
+	if err != nil {
+		return nil, err
+	}
+	boundaries, err := boundaryLayers(img)
+	if err != nil {
+		return nil, err
 	}
+	createdBy, err := layerCreatedBy(img)
+	if err != nil {
+		return nil, err
+	}
+	return newMergeReport(ref, sizes, createdBy, boundaries, plan.steps(sizes, boundaries)), nil
+}
 
+// mergeEngineImage merges the image refs[0] of the engine's local store and
+// saves the result under all refs. A podman manifest list is merged per
+// platform (see mergeManifestList).
+func mergeEngineImage(engine string, refs []string, plan mergePlanner, out io.Writer) error {
+	if engine == "podman" && podmanManifestExists(refs[0]) {
+		return mergeManifestList(refs[0], plan, out)
+	}
+
+	img, cleanup, err := LoadEngineImage(refs[0], engine)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	spillDir, err := os.MkdirTemp("", "ov-merge-")
+	if err != nil {
+		return fmt.Errorf("creating spill directory: %w", err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	newImg, err := mergeImage(img, plan, spillDir, out)
+	if err != nil || newImg == img {
+		return err
+	}
+	if err := SaveEngineImage(newImg, engine, refs...); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved %s\n", strings.Join(refs, ", "))
+	return nil
+}
+
+// layerSizes returns the (compressed) size of each layer
+func layerSizes(layers []v1.Layer) ([]int64, error) {
 	sizes := make([]int64, len(layers))
 	for i, layer := range layers {
 		size, err := layer.Size()
 		if err != nil {
-			return fmt.Errorf("reading layer %d size: %w", i, err)
+			return nil, fmt.Errorf("reading layer %d size: %w", i, err)
 		}
 		sizes[i] = size
 	}
+	return sizes, nil
+}
 
-	steps := planMerge(sizes, maxBytes)
+// planMerge groups consecutive layers into groups up to maxBytes.
+// Groups with 2+ layers are merged; single-layer groups are kept as-is.
+func planMerge(sizes []int64, maxBytes int64) []MergeStep {
+	return planMergeWith(sizes, nil, maxBytes, 0, MergeStrategyGreedy)
+}
 
-	if c.DryRun {
-		printMergePlan(sizes, steps)
-		return nil
+// planMergeWith groups consecutive layers with the given strategy, then merges
+// each group smaller than minBytes into the next one (unless that is a single
+// layer above maxBytes), so a merged group can exceed maxBytes by less than
+// minBytes. A layer in boundaries always starts a new group. Groups with 2+
+// layers are merged; single-layer groups are kept as-is.
+func planMergeWith(sizes []int64, boundaries map[int]bool, maxBytes, minBytes int64, strategy string) []MergeStep {
+	var steps []MergeStep
+	start := 0
+	for end := 1; end <= len(sizes); end++ {
+		if end < len(sizes) && !boundaries[end] {
+			continue
+		}
+		for _, group := range planGroups(sizes[start:end], maxBytes, minBytes, strategy) {
+			for i := range group {
+				group[i] += start
+			}
+			steps = append(steps, MergeStep{Keep: len(group) == 1, Layers: group})
+		}
+		start = end
 	}
+	return steps
+}
 
-	// Check if any merging is needed
-	mergeCount := 0
-	for _, step := range steps {
-		if !step.Keep {
-			mergeCount++
+// planGroups groups consecutive layers as planMergeWith does, without boundaries
+func planGroups(sizes []int64, maxBytes, minBytes int64, strategy string) [][]int {
+	var groups [][]int
+	if strategy == MergeStrategyPack {
+		groups = packGroups(sizes, maxBytes)
+	} else {
+		groups = greedyGroups(sizes, maxBytes)
+	}
+
+	groupSize := func(group []int) int64 {
+		var total int64
+		for _, idx := range group {
+			total += sizes[idx]
 		}
+		return total
 	}
-	if mergeCount == 0 {
-		fmt.Fprintf(os.Stderr, "No layers to merge (%d layers)\n", len(layers))
-		return nil
+	for i := 0; i < len(groups)-1; i++ {
+		for i < len(groups)-1 && groupSize(groups[i]) < minBytes && groupSize(groups[i+1]) <= maxBytes {
+			groups[i] = append(groups[i], groups[i+1]...)
+			groups = append(groups[:i+1], groups[i+2:]...)
+		}
 	}
+	return groups
+}
 
-	newImg, err := executeMerge(img, layers, steps)
+// boundaryLayers returns the layers following a layer boundary marker in the
+// history of img (see LabelLayerBoundary): the first layer of every image
+// built with merge.preserve_base_boundary on top of its base
+func boundaryLayers(img v1.Image) (map[int]bool, error) {
+	cfgFile, err := img.ConfigFile()
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("reading config: %w", err)
 	}
-
-	if err := saveImageToDaemon(newImg, imageRef, engine); err != nil {
-		return err
+	boundaries := make(map[int]bool)
+	layer, marked := 0, false
+	for _, h := range cfgFile.History {
+		if strings.Contains(h.CreatedBy, LabelLayerBoundary+"=") {
+			marked = true
+		}
+		if h.EmptyLayer {
+			continue
+		}
+		if marked && layer > 0 {
+			boundaries[layer] = true
+		}
+		layer++
+		marked = false
 	}
-
-	newLayers, _ := newImg.Layers()
-	fmt.Fprintf(os.Stderr, "Merged: %d layers -> %d layers\n", len(layers), len(newLayers))
-	fmt.Fprintf(os.Stderr, "Saved %s\n", imageRef)
-	return nil
+	return boundaries, nil
 }
 
-// planMerge groups consecutive layers into groups up to maxBytes.
-// Groups with 2+ layers are merged; single-layer groups are kept as-is.
-func planMerge(sizes []int64, maxBytes int64) []MergeStep {
-	var steps []MergeStep
+// greedyGroups fills each group with the following layers until the next one
+// would exceed maxBytes
+func greedyGroups(sizes []int64, maxBytes int64) [][]int {
+	var groups [][]int
 	var group []int
 	var groupSize int64
+	for i, size := range sizes {
+		if len(group) > 0 && groupSize+size > maxBytes {
+			groups = append(groups, group)
+			group, groupSize = nil, 0
+		}
+		group = append(group, i)
+		groupSize += size
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
 
-	flushGroup := func() {
-		if len(group) >= 2 {
-			steps = append(steps, MergeStep{Keep: false, Layers: group})
-		} else {
-			for _, idx := range group {
-				steps = append(steps, MergeStep{Keep: true, Layers: []int{idx}})
+// packGroups splits the layers into the fewest groups of at most maxBytes
+// (a bigger layer stays alone) and, among those splits, the one merging the
+// fewest bytes: small layers are merged with each other rather than onto a
+// layer near the cap, unless that saves a layer.
+func packGroups(sizes []int64, maxBytes int64) [][]int {
+	// best[i]: the best split of the first i layers; start[i]: where its last group starts
+	type split struct {
+		groups int
+		merged int64
+	}
+	n := len(sizes)
+	best := make([]split, n+1)
+	start := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = split{groups: -1}
+		var size int64
+		for j := i - 1; j >= 0; j-- {
+			size += sizes[j]
+			if j < i-1 && size > maxBytes {
+				break
+			}
+			candidate := split{groups: best[j].groups + 1, merged: best[j].merged}
+			if j < i-1 {
+				candidate.merged += size
+			}
+			if best[i].groups < 0 || candidate.groups < best[i].groups ||
+				(candidate.groups == best[i].groups && candidate.merged < best[i].merged) {
+				best[i] = candidate
+				start[i] = j
 			}
 		}
-		group = nil
-		groupSize = 0
 	}
 
-	for i, size := range sizes {
-		if groupSize+size <= maxBytes {
-			group = append(group, i)
-			groupSize += size
-		} else {
-			flushGroup()
-			group = []int{i}
-			groupSize = size
+	var groups [][]int
+	for i := n; i > 0; i = start[i] {
+		var group []int
+		for idx := start[i]; idx < i; idx++ {
+			group = append(group, idx)
 		}
+		groups = append([][]int{group}, groups...)
 	}
-	flushGroup()
-
-	return steps
+	return groups
 }
 
-// tarEntry holds a tar header and its content for deduplication.
+// tarEntry is a tar entry of a merge group: its header and where it is in
+// the group (the content stays in the source layer until it is written).
 type tarEntry struct {
-	Header  *tar.Header
-	Content []byte
+	Header *tar.Header
+	Layer  int                // layer of the group
+	Index  int                // position in the layer's tar
+	Sum    *[sha256.Size]byte // content digest, only computed to compare with the layers below
 }
 
-// mergeLayers combines multiple layers into one, deduplicating paths (last writer wins).
-func mergeLayers(layers []v1.Layer) (v1.Layer, error) {
-	// Collect all entries, tracking insertion order and deduplicating by path.
+// OCI whiteout markers: .wh.<name> removes <name> from the layers below,
+// .wh..wh..opq in a directory hides everything the layers below have in it
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = ".wh..wh..opq"
+)
+
+// lowerFile is a regular file left by the layers below a merge group
+type lowerFile struct {
+	Mode     int64
+	Uid, Gid int
+	Size     int64
+	Sum      [sha256.Size]byte
+}
+
+// lowerFiles holds the regular files the layers below a merge group leave,
+// by cleaned path, so that the merged layer can skip byte-identical copies
+type lowerFiles struct {
+	files map[string]lowerFile
+	index pathIndex // paths of files
+}
+
+func newLowerFiles() *lowerFiles {
+	return &lowerFiles{files: make(map[string]lowerFile), index: make(pathIndex)}
+}
+
+// add applies a layer on top of the files, hashing its regular files
+func (f *lowerFiles) add(layer v1.Layer) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return fmt.Errorf("reading uncompressed layer: %w", err)
+	}
+	defer rc.Close()
+
+	removeBelow := func(dir string) {
+		f.index.removeBelow(dir, func(p string) {
+			delete(f.files, p)
+		})
+	}
+
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar entry: %w", err)
+		}
+		p := cleanTarPath(hdr.Name)
+		dir, base := path.Dir(p), path.Base(p)
+		switch {
+		case base == whiteoutOpaque:
+			removeBelow(dir)
+			continue
+		case strings.HasPrefix(base, whiteoutPrefix):
+			target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+			delete(f.files, target)
+			removeBelow(target)
+			continue
+		}
+
+		delete(f.files, p)
+		if hdr.Typeflag == tar.TypeDir {
+			continue
+		}
+		removeBelow(p)
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, tr); err != nil {
+			return fmt.Errorf("reading tar content for %s: %w", hdr.Name, err)
+		}
+		file := lowerFile{Mode: hdr.Mode, Uid: hdr.Uid, Gid: hdr.Gid, Size: hdr.Size}
+		copy(file.Sum[:], h.Sum(nil))
+		f.files[p] = file
+		f.index.add(p)
+	}
+}
+
+// candidate reports whether the files include one at p that hdr could be a
+// byte-identical copy of, so that its content is worth hashing. A nil f has
+// no files.
+func (f *lowerFiles) candidate(p string, hdr *tar.Header) bool {
+	if f == nil {
+		return false
+	}
+	file, ok := f.files[p]
+	return ok && hdr.Typeflag == tar.TypeReg && file.Size == hdr.Size &&
+		file.Mode == hdr.Mode && file.Uid == hdr.Uid && file.Gid == hdr.Gid
+}
+
+// provides reports whether the files include a byte-identical copy of entry at p
+func (f *lowerFiles) provides(p string, entry *tarEntry) bool {
+	return entry.Sum != nil && f.candidate(p, entry.Header) && *entry.Sum == f.files[p].Sum
+}
+
+// mergeLayers flattens layers into one, as if they were applied in order:
+// later entries replace earlier ones (last writer wins), and whiteouts and
+// opaque directory markers drop what earlier layers of the group added below
+// their path. The markers themselves are kept for the layers below the group
+// (lower, nil when there are none) and written before all other entries, so
+// that extractors applying entries in order don't remove files the group
+// re-creates. Files the layers below already provide with the same content,
+// mode and owner are left out. Also returns the content bytes saved by
+// dropping overwritten and identical files. With a non-nil epoch, every
+// entry gets it as modification time and no access or change time.
+//
+// Only headers are kept in memory. A first pass over the layers decides which
+// entries survive; the merged tar is then streamed from the layers on demand
+// and spilled to a file in spillDir, which later reads of the layer use.
+func mergeLayers(layers []v1.Layer, lower *lowerFiles, spillDir string, epoch *time.Time) (v1.Layer, int64, error) {
+	hasLower := lower != nil
+
+	// Entries and markers by cleaned path, each in first-seen order
 	entries := make(map[string]*tarEntry)
-	var order []string
+	markers := make(map[string]*tarEntry)
+	var order, markerOrder []string
+	seen := make(map[string]bool)
+	index := make(pathIndex)
+	var saved int64
+
+	// removeBelow drops the entries and markers under dir
+	removeBelow := func(dir string) {
+		index.removeBelow(dir, func(p string) {
+			delete(entries, p)
+			delete(markers, p)
+		})
+	}
 
-	for _, layer := range layers {
-		rc, err := layer.Uncompressed()
+	for li, layer := range layers {
+		layerEntries, err := scanLayerEntries(layer, li, lower)
 		if err != nil {
-			return nil, fmt.Errorf("reading uncompressed layer: %w", err)
+			return nil, 0, err
 		}
 
-		tr := tar.NewReader(rc)
-		for {
-			hdr, err := tr.Next()
-			if err == io.EOF {
-				break
+		// A layer's markers only apply to the layers below it, so apply them
+		// before adding its other entries
+		for _, entry := range layerEntries {
+			p := cleanTarPath(entry.Header.Name)
+			dir, base := path.Dir(p), path.Base(p)
+			switch {
+			case base == whiteoutOpaque:
+				removeBelow(dir)
+			case strings.HasPrefix(base, whiteoutPrefix):
+				target := path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))
+				delete(entries, target)
+				removeBelow(target)
+			default:
+				continue
 			}
-			if err != nil {
-				rc.Close()
-				return nil, fmt.Errorf("reading tar entry: %w", err)
+			if hasLower {
+				markers[p] = entry
+				index.add(p)
+				if !seen[p] {
+					seen[p] = true
+					markerOrder = append(markerOrder, p)
+				}
 			}
+		}
 
-			var content []byte
-			if hdr.Size > 0 {
-				content, err = io.ReadAll(tr)
-				if err != nil {
-					rc.Close()
-					return nil, fmt.Errorf("reading tar content for %s: %w", hdr.Name, err)
-				}
+		for _, entry := range layerEntries {
+			p := cleanTarPath(entry.Header.Name)
+			if strings.HasPrefix(path.Base(p), whiteoutPrefix) {
+				continue
+			}
+			// A file replacing a directory replaces its contents too
+			if entry.Header.Typeflag != tar.TypeDir {
+				removeBelow(p)
 			}
+			if prev, ok := entries[p]; ok && prev.Header.Typeflag == tar.TypeReg {
+				saved += prev.Header.Size
+			}
+			entries[p] = entry
+			index.add(p)
+			if !seen[p] {
+				seen[p] = true
+				order = append(order, p)
+			}
+		}
+	}
 
-			if _, seen := entries[hdr.Name]; !seen {
-				order = append(order, hdr.Name)
+	// hidden reports whether a kept marker removes p from the layers below
+	hidden := func(p string) bool {
+		for cur := p; cur != "." && cur != "/"; cur = path.Dir(cur) {
+			dir := path.Dir(cur)
+			if _, ok := markers[path.Join(dir, whiteoutPrefix+path.Base(cur))]; ok {
+				return true
 			}
-			entries[hdr.Name] = &tarEntry{Header: hdr, Content: content}
+			if _, ok := markers[path.Join(dir, whiteoutOpaque)]; ok {
+				return true
+			}
+		}
+		return false
+	}
+	for p, entry := range entries {
+		if lower.provides(p, entry) && !hidden(p) {
+			saved += entry.Header.Size
+			delete(entries, p)
 		}
-		rc.Close()
 	}
 
-	// Write deduplicated entries in order.
-	var buf bytes.Buffer
-	tw := tar.NewWriter(&buf)
-	for _, name := range order {
-		entry := entries[name]
-		if err := tw.WriteHeader(entry.Header); err != nil {
-			return nil, fmt.Errorf("writing tar header for %s: %w", name, err)
+	if epoch != nil {
+		for _, entry := range entries {
+			stampHeader(entry.Header, *epoch)
+		}
+		for _, entry := range markers {
+			stampHeader(entry.Header, *epoch)
+		}
+	}
+
+	// Entries without content are written from their headers: markers first,
+	// then directories (before what they contain) and hard links last (after
+	// their targets). File contents are copied from the layers in order.
+	var headFirst, headLast []*tar.Header
+	for _, p := range markerOrder {
+		if entry, ok := markers[p]; ok {
+			hdr := *entry.Header
+			hdr.Size = 0
+			headFirst = append(headFirst, &hdr)
+		}
+	}
+	stream := make([]map[int]*tarEntry, len(layers))
+	for _, p := range order {
+		entry, ok := entries[p]
+		if !ok {
+			continue
+		}
+		switch entry.Header.Typeflag {
+		case tar.TypeDir:
+			headFirst = append(headFirst, entry.Header)
+		case tar.TypeLink:
+			headLast = append(headLast, entry.Header)
+		default:
+			if stream[entry.Layer] == nil {
+				stream[entry.Layer] = make(map[int]*tarEntry)
+			}
+			stream[entry.Layer][entry.Index] = entry
+		}
+	}
+
+	produce := func(w io.Writer) error {
+		tw := tar.NewWriter(w)
+		for _, hdr := range headFirst {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("writing tar header for %s: %w", hdr.Name, err)
+			}
 		}
-		if len(entry.Content) > 0 {
-			if _, err := tw.Write(entry.Content); err != nil {
-				return nil, fmt.Errorf("writing tar content for %s: %w", name, err)
+		for li, wanted := range stream {
+			if len(wanted) == 0 {
+				continue
 			}
+			if err := copyLayerEntries(tw, layers[li], wanted); err != nil {
+				return err
+			}
+		}
+		for _, hdr := range headLast {
+			if err := tw.WriteHeader(hdr); err != nil {
+				return fmt.Errorf("writing tar header for %s: %w", hdr.Name, err)
+			}
+		}
+		if err := tw.Close(); err != nil {
+			return fmt.Errorf("closing tar writer: %w", err)
+		}
+		return nil
+	}
+
+	spill := &spillOpener{dir: spillDir, produce: produce}
+	merged, err := tarball.LayerFromOpener(spill.open)
+	return merged, saved, err
+}
+
+// stampHeader sets the modification time of hdr to t and drops its access
+// and change times
+func stampHeader(hdr *tar.Header, t time.Time) {
+	hdr.ModTime = t
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+}
+
+// scanLayerEntries reads the tar headers of layer li of a merge group,
+// hashing the files that may be copies of files the layers below provide
+func scanLayerEntries(layer v1.Layer, li int, lower *lowerFiles) ([]*tarEntry, error) {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading uncompressed layer: %w", err)
+	}
+	defer rc.Close()
+
+	var entries []*tarEntry
+	tr := tar.NewReader(rc)
+	for index := 0; ; index++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar entry: %w", err)
+		}
+		entry := &tarEntry{Header: hdr, Layer: li, Index: index}
+		if lower.candidate(cleanTarPath(hdr.Name), hdr) {
+			h := sha256.New()
+			if _, err := io.Copy(h, tr); err != nil {
+				return nil, fmt.Errorf("reading tar content for %s: %w", hdr.Name, err)
+			}
+			entry.Sum = new([sha256.Size]byte)
+			copy(entry.Sum[:], h.Sum(nil))
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// copyLayerEntries writes the entries of layer at the positions in wanted to
+// tw, streaming their content
+func copyLayerEntries(tw *tar.Writer, layer v1.Layer, wanted map[int]*tarEntry) error {
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return fmt.Errorf("reading uncompressed layer: %w", err)
+	}
+	defer rc.Close()
+
+	tr := tar.NewReader(rc)
+	for index, left := 0, len(wanted); left > 0; index++ {
+		hdr, err := tr.Next()
+		if err != nil {
+			return fmt.Errorf("reading tar entry: %w", err)
+		}
+		entry, ok := wanted[index]
+		if !ok {
+			continue
+		}
+		left--
+		if err := tw.WriteHeader(entry.Header); err != nil {
+			return fmt.Errorf("writing tar header for %s: %w", hdr.Name, err)
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return fmt.Errorf("copying tar content for %s: %w", hdr.Name, err)
 		}
 	}
-	if err := tw.Close(); err != nil {
-		return nil, fmt.Errorf("closing tar writer: %w", err)
+	return nil
+}
+
+// spillOpener is a layer opener producing its tar on demand. The first read
+// to the end is also written to a file in dir, which later opens read
+// instead of producing the tar again.
+type spillOpener struct {
+	dir     string
+	produce func(io.Writer) error
+
+	mu   sync.Mutex
+	file string // complete spill file
+}
+
+func (s *spillOpener) open() (io.ReadCloser, error) {
+	s.mu.Lock()
+	file := s.file
+	s.mu.Unlock()
+	if file != "" {
+		return os.Open(file)
 	}
 
-	data := buf.Bytes()
-	return tarball.LayerFromOpener(func() (io.ReadCloser, error) {
-		return io.NopCloser(bytes.NewReader(data)), nil
-	})
+	tmp, err := os.CreateTemp(s.dir, "ov-merge-*.tar")
+	if err != nil {
+		return nil, fmt.Errorf("creating spill file: %w", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		// A reader closing early fails the pipe write, discarding the spill
+		err := s.produce(io.MultiWriter(pw, tmp))
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		s.mu.Lock()
+		if err == nil && s.file == "" {
+			s.file = tmp.Name()
+		} else {
+			os.Remove(tmp.Name())
+		}
+		s.mu.Unlock()
+		pw.CloseWithError(err)
+	}()
+	return pr, nil
+}
+
+// cleanTarPath normalizes a tar entry name ("./usr/bin/" → "usr/bin")
+func cleanTarPath(name string) string {
+	return path.Clean(strings.TrimPrefix(name, "./"))
+}
+
+// pathIndex maps directories to the cleaned paths directly in them, so that
+// what is below a path can be found without scanning every path
+type pathIndex map[string]map[string]bool
+
+// add records p and its parent directories
+func (x pathIndex) add(p string) {
+	for p != "." && p != "/" {
+		dir := path.Dir(p)
+		children := x[dir]
+		if children == nil {
+			children = make(map[string]bool)
+			x[dir] = children
+		}
+		if children[p] {
+			// The parents were recorded with p
+			return
+		}
+		children[p] = true
+		p = dir
+	}
+}
+
+// removeBelow forgets the paths under dir ("." for all of them) and calls
+// remove with each. dir itself stays recorded.
+func (x pathIndex) removeBelow(dir string, remove func(string)) {
+	children, ok := x[dir]
+	if !ok {
+		return
+	}
+	delete(x, dir)
+	for p := range children {
+		remove(p)
+		x.removeBelow(p, remove)
+	}
 }
 
 // executeMerge rebuilds the image with merged layers and aligned history.
-func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image, error) {
+// The merged layers are spilled to spillDir, which must outlive the image.
+// A non-nil epoch is the timestamp of the merged layers' entries and history
+// entries and of the config.
+func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep, spillDir string, epoch *time.Time) (v1.Image, error) {
 	cfgFile, err := img.ConfigFile()
 	if err != nil {
 		return nil, fmt.Errorf("reading config: %w", err)
@@ -290,6 +918,16 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 
 	history := cfgFile.History
 
+	manifest, err := img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest: %w", err)
+	}
+	// Merged layers get the layer media type matching the manifest's
+	mergedMediaType := types.DockerLayer
+	if manifest.MediaType == types.OCIManifestSchema1 {
+		mergedMediaType = types.OCILayer
+	}
+
 	// Map layer indices to history entries.
 	// History entries with EmptyLayer=true don't correspond to actual layers.
 	layerToHistory := make(map[int]int) // layer index -> history index
@@ -305,6 +943,10 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 
 	var newAddenda []mutate.Addendum
 
+	// Files left by layers[:indexed], extended up to each merge group
+	lower := newLowerFiles()
+	indexed := 0
+
 	// Process steps: for each step, emit the corresponding layer + history.
 	// Also emit any empty-layer history entries that fall between steps.
 	prevMaxHistIdx := -1
@@ -339,10 +981,15 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 			if hi, ok := layerToHistory[li]; ok {
 				h = history[hi]
 			}
-			newAddenda = append(newAddenda, mutate.Addendum{
+			add := mutate.Addendum{
 				Layer:   layers[li],
 				History: h,
-			})
+			}
+			if li < len(manifest.Layers) {
+				add.MediaType = manifest.Layers[li].MediaType
+				add.Annotations = manifest.Layers[li].Annotations
+			}
+			newAddenda = append(newAddenda, add)
 			// Emit empty-layer entries between this layer's history and maxHistIdx
 			if hi, ok := layerToHistory[step.Layers[0]]; ok {
 				for ei := hi + 1; ei <= maxHistIdx; ei++ {
@@ -357,31 +1004,52 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 			// Merge group
 			groupLayers := make([]v1.Layer, len(step.Layers))
 			var createdByParts []string
+			var created v1.Time
 			for i, li := range step.Layers {
 				groupLayers[i] = layers[li]
 				if hi, ok := layerToHistory[li]; ok {
 					if history[hi].CreatedBy != "" {
 						createdByParts = append(createdByParts, history[hi].CreatedBy)
 					}
+					// The merged layer is as recent as its newest layer
+					if history[hi].Created.After(created.Time) {
+						created = history[hi].Created
+					}
+				}
+			}
+			if epoch != nil {
+				created = v1.Time{Time: *epoch}
+			}
+
+			for ; indexed < step.Layers[0]; indexed++ {
+				if err := lower.add(layers[indexed]); err != nil {
+					return nil, fmt.Errorf("indexing layer %d: %w", indexed, err)
 				}
 			}
 
-			merged, err := mergeLayers(groupLayers)
+			// Whiteouts are only needed if there are layers below the group
+			var below *lowerFiles
+			if step.Layers[0] > 0 {
+				below = lower
+			}
+			merged, saved, err := mergeLayers(groupLayers, below, spillDir, epoch)
 			if err != nil {
 				return nil, fmt.Errorf("merging layers %v: %w", step.Layers, err)
 			}
 
 			mergedSize, _ := merged.Size()
-			fmt.Fprintf(os.Stderr, "Merging layers %d-%d (%.1f MB)\n",
+			fmt.Fprintf(os.Stderr, "Merging layers %d-%d (%.1f MB, %.1f MB of duplicate files saved)\n",
 				step.Layers[0], step.Layers[len(step.Layers)-1],
-				float64(mergedSize)/(1024*1024))
+				float64(mergedSize)/(1024*1024), float64(saved)/(1024*1024))
 
 			h := v1.History{
+				Created:   created,
 				CreatedBy: "ov merge: " + strings.Join(createdByParts, " && "),
 			}
 			newAddenda = append(newAddenda, mutate.Addendum{
-				Layer:   merged,
-				History: h,
+				Layer:     merged,
+				History:   h,
+				MediaType: mergedMediaType,
 			})
 
 			// Emit empty-layer history entries that fall within the merge range
@@ -408,20 +1076,30 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 		}
 	}
 
-	// Reconstruct image from empty base + config + layers
+	// Reconstruct image from empty base + config + layers, keeping the
+	// manifest and config media types and the manifest annotations
 	newImg := empty.Image
-	newImg, err = mutate.ConfigFile(newImg, cfgFile)
-	if err != nil {
-		return nil, fmt.Errorf("setting config: %w", err)
+	if manifest.MediaType != "" {
+		newImg = mutate.MediaType(newImg, manifest.MediaType)
+	}
+	if manifest.Config.MediaType != "" {
+		newImg = mutate.ConfigMediaType(newImg, manifest.Config.MediaType)
+	}
+	if len(manifest.Annotations) > 0 {
+		newImg = mutate.Annotations(newImg, manifest.Annotations).(v1.Image)
 	}
 
-	// Clear history and diff IDs since addenda will rebuild them
-	cf, _ := newImg.ConfigFile()
+	// The config is kept as is, except for history and diff IDs which the
+	// addenda rebuild
+	cf := cfgFile.DeepCopy()
 	cf.History = nil
 	cf.RootFS.DiffIDs = nil
+	if epoch != nil {
+		cf.Created = v1.Time{Time: *epoch}
+	}
 	newImg, err = mutate.ConfigFile(newImg, cf)
 	if err != nil {
-		return nil, fmt.Errorf("clearing config history: %w", err)
+		return nil, fmt.Errorf("setting config: %w", err)
 	}
 
 	newImg, err = mutate.Append(newImg, newAddenda...)
@@ -432,6 +1110,13 @@ func executeMerge(img v1.Image, layers []v1.Layer, steps []MergeStep) (v1.Image,
 	return newImg, nil
 }
 
+// Image transfer between ov merge and the engine's local store.
+// Package-level vars for testability.
+var (
+	LoadEngineImage = loadImageFromDaemon
+	SaveEngineImage = saveImageToDaemon
+)
+
 // loadImageFromDaemon loads an image from the container engine via save.
 // The caller must call cleanup() when done with the image to remove the temp file.
 func loadImageFromDaemon(ref string, engine string) (v1.Image, func(), error) {
@@ -443,7 +1128,7 @@ func loadImageFromDaemon(ref string, engine string) (v1.Image, func(), error) {
 	cleanup := func() { os.Remove(tmpFile.Name()) }
 
 	binary := EngineBinary(engine)
-	cmd := exec.Command(binary, "save", ref)
+	cmd := engineCmd(engine, "save", ref)
 	cmd.Stdout = tmpFile
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
@@ -463,8 +1148,9 @@ func loadImageFromDaemon(ref string, engine string) (v1.Image, func(), error) {
 	return img, cleanup, nil
 }
 
-// saveImageToDaemon saves an image to the container engine via load.
-func saveImageToDaemon(img v1.Image, ref string, engine string) error {
+// saveImageToDaemon saves an image to the container engine via load, tagged
+// with each of refs.
+func saveImageToDaemon(img v1.Image, engine string, refs ...string) error {
 	tmpFile, err := os.CreateTemp("", "ov-merge-*.tar")
 	if err != nil {
 		return fmt.Errorf("creating temp file: %w", err)
@@ -472,17 +1158,21 @@ func saveImageToDaemon(img v1.Image, ref string, engine string) error {
 	defer os.Remove(tmpFile.Name())
 	defer tmpFile.Close()
 
-	tag, err := name.NewTag(ref)
-	if err != nil {
-		return fmt.Errorf("parsing image ref %q: %w", ref, err)
+	tagged := make(map[name.Reference]v1.Image)
+	for _, ref := range refs {
+		tag, err := name.NewTag(ref)
+		if err != nil {
+			return fmt.Errorf("parsing image ref %q: %w", ref, err)
+		}
+		tagged[tag] = img
 	}
 
-	if err := tarball.WriteToFile(tmpFile.Name(), tag, img); err != nil {
+	if err := tarball.MultiRefWriteToFile(tmpFile.Name(), tagged); err != nil {
 		return fmt.Errorf("writing image tarball: %w", err)
 	}
 
 	binary := EngineBinary(engine)
-	cmd := exec.Command(binary, "load", "-i", tmpFile.Name())
+	cmd := engineCmd(engine, "load", "-i", tmpFile.Name())
 	cmd.Stdout = os.Stderr
 	cmd.Stderr = os.Stderr
 	if err := cmd.Run(); err != nil {
@@ -492,34 +1182,27 @@ func saveImageToDaemon(img v1.Image, ref string, engine string) error {
 	return nil
 }
 
-// printMergePlan displays the dry-run merge plan.
-func printMergePlan(sizes []int64, steps []MergeStep) {
-	for _, step := range steps {
-		if step.Keep {
-			idx := step.Layers[0]
-			fmt.Fprintf(os.Stderr, "Layer %2d: %7.1f MB  [keep]\n", idx, float64(sizes[idx])/(1024*1024))
+// printReports prints the dry-run reports to stdout, as text or JSON (--json).
+// JSON is one document: the report of a single platform image, or an array of
+// the reports of a manifest list or --all.
+func (c *MergeCmd) printReports(reports []*MergeReport) error {
+	if c.JSON {
+		var data []byte
+		var err error
+		if len(reports) == 1 && reports[0].Platform == "" && !c.All {
+			data, err = reports[0].JSON()
 		} else {
-			var total int64
-			for _, idx := range step.Layers {
-				total += sizes[idx]
-			}
-			for i, idx := range step.Layers {
-				prefix := " "
-				switch {
-				case i == 0:
-					prefix = "\\"
-				case i == len(step.Layers)-1:
-					prefix = "/"
-				}
-				suffix := ""
-				if i == len(step.Layers)-1 {
-					suffix = fmt.Sprintf("  > merge (%.1f MB)", float64(total)/(1024*1024))
-				}
-				fmt.Fprintf(os.Stderr, "Layer %2d: %7.1f MB  %s%s\n", idx, float64(sizes[idx])/(1024*1024), prefix, suffix)
-			}
+			data, err = MergeReportsJSON(reports)
+		}
+		if err != nil {
+			return err
 		}
+		fmt.Println(string(data))
+		return nil
 	}
-
-	resultLayers := len(steps)
-	fmt.Fprintf(os.Stderr, "\n%d layers -> %d layers\n", len(sizes), resultLayers)
+	for _, r := range reports {
+		// A blank line separates the reports of several images
+		fmt.Println(r.Text())
+	}
+	return nil
 }
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// mergeManifestList merges every platform image of the podman manifest list ref
+// and replaces the list with one listing the merged images.
+func mergeManifestList(ref string, plan mergePlanner, out io.Writer) error {
+	idx, cleanup, err := loadIndexFromPodman(ref)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	spillDir, err := os.MkdirTemp("", "ov-merge-")
+	if err != nil {
+		return fmt.Errorf("creating spill directory: %w", err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	newIdx, err := mergeIndex(idx, plan, spillDir, out)
+	if err != nil {
+		return err
+	}
+	if err := saveIndexToPodman(newIdx, ref); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Saved manifest list %s\n", ref)
+	return nil
+}
+
+// mergePlanner plans the merge of an image's layers and sets the timestamps
+// of what the merge rewrites
+type mergePlanner struct {
+	// steps plans the merge of layers of the given sizes, the layers in
+	// boundaries following a layer boundary marker
+	steps func(sizes []int64, boundaries map[int]bool) []MergeStep
+
+	// epoch is the timestamp of merged layer entries, merged history entries
+	// and the config (merge.reproducible); nil keeps the image's timestamps
+	epoch *time.Time
+}
+
+// platformImage reports whether an index entry is the image of a platform
+// (not a nested index or an attestation with an unknown platform)
+func platformImage(desc v1.Descriptor) bool {
+	return desc.MediaType.IsImage() && desc.Platform != nil &&
+		desc.Platform.OS != "" && desc.Platform.OS != "unknown"
+}
+
+// forEachPlatformImage calls fn with the platform image entries of idx, in order
+func forEachPlatformImage(idx v1.ImageIndex, fn func(platform string, img v1.Image) error) error {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("reading index manifest: %w", err)
+	}
+	for _, desc := range manifest.Manifests {
+		if !platformImage(desc) {
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("reading %s image: %w", desc.Platform, err)
+		}
+		if err := fn(desc.Platform.String(), img); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeIndex merges the layers of every platform image of idx independently
+// and reassembles the index with the index media type and annotations, and
+// the platform and annotations of every entry. Other entries (nested indexes,
+// attestations) are carried over unchanged.
+func mergeIndex(idx v1.ImageIndex, plan mergePlanner, spillDir string, out io.Writer) (v1.ImageIndex, error) {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, fmt.Errorf("reading index manifest: %w", err)
+	}
+
+	var newIdx v1.ImageIndex = empty.Index
+	if manifest.MediaType != "" {
+		newIdx = mutate.IndexMediaType(newIdx, manifest.MediaType)
+	}
+	if len(manifest.Annotations) > 0 {
+		newIdx = mutate.Annotations(newIdx, manifest.Annotations).(v1.ImageIndex)
+	}
+
+	var adds []mutate.IndexAddendum
+	for _, desc := range manifest.Manifests {
+		add := mutate.IndexAddendum{
+			Descriptor: v1.Descriptor{
+				Platform:    desc.Platform,
+				Annotations: desc.Annotations,
+				URLs:        desc.URLs,
+			},
+		}
+		switch {
+		case platformImage(desc):
+			img, err := idx.Image(desc.Digest)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s image: %w", desc.Platform, err)
+			}
+			fmt.Fprintf(out, "%s:\n", desc.Platform)
+			if add.Add, err = mergeImage(img, plan, spillDir, out); err != nil {
+				return nil, fmt.Errorf("%s: %w", desc.Platform, err)
+			}
+		case desc.MediaType.IsImage():
+			if add.Add, err = idx.Image(desc.Digest); err != nil {
+				return nil, fmt.Errorf("reading image %s: %w", desc.Digest, err)
+			}
+		case desc.MediaType.IsIndex():
+			if add.Add, err = idx.ImageIndex(desc.Digest); err != nil {
+				return nil, fmt.Errorf("reading index %s: %w", desc.Digest, err)
+			}
+		default:
+			return nil, fmt.Errorf("index entry %s has unsupported media type %s", desc.Digest, desc.MediaType)
+		}
+		adds = append(adds, add)
+	}
+	return mutate.AppendManifests(newIdx, adds...), nil
+}
+
+// mergeImage merges the layers of img with the plan, returning img itself if
+// there is nothing to merge
+func mergeImage(img v1.Image, plan mergePlanner, spillDir string, out io.Writer) (v1.Image, error) {
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("reading layers: %w", err)
+	}
+	sizes, err := layerSizes(layers)
+	if err != nil {
+		return nil, err
+	}
+	boundaries, err := boundaryLayers(img)
+	if err != nil {
+		return nil, err
+	}
+	steps := plan.steps(sizes, boundaries)
+	if len(steps) == len(layers) {
+		fmt.Fprintf(out, "No layers to merge (%d layers)\n", len(layers))
+		return img, nil
+	}
+	newImg, err := executeMerge(img, layers, steps, spillDir, plan.epoch)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Merged: %d layers -> %d layers\n", len(layers), len(steps))
+	return newImg, nil
+}
+
+// podmanManifestExists reports whether ref is a manifest list in podman's store
+func podmanManifestExists(ref string) bool {
+	return engineCmd("podman", "manifest", "exists", ref).Run() == nil
+}
+
+// loadIndexFromPodman exports the manifest list ref with the images of all
+// platforms to an OCI layout. The caller must call cleanup() when done with
+// the index to remove the layout.
+func loadIndexFromPodman(ref string) (v1.ImageIndex, func(), error) {
+	dir, err := os.MkdirTemp("", "ov-merge-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating temp dir: %w", err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	cmd := engineCmd("podman", "manifest", "push", "--all", ref, "oci:"+dir)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("podman manifest push %s: %w", ref, err)
+	}
+
+	// index.json of the layout lists the pushed manifest list
+	p, err := layout.FromPath(dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("reading exported manifest list: %w", err)
+	}
+	outer, err := p.ImageIndex()
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("reading exported manifest list: %w", err)
+	}
+	manifest, err := outer.IndexManifest()
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("reading exported manifest list: %w", err)
+	}
+	for _, desc := range manifest.Manifests {
+		if desc.MediaType.IsIndex() {
+			idx, err := outer.ImageIndex(desc.Digest)
+			if err != nil {
+				cleanup()
+				return nil, nil, fmt.Errorf("reading exported manifest list: %w", err)
+			}
+			return idx, cleanup, nil
+		}
+	}
+	cleanup()
+	return nil, nil, fmt.Errorf("podman manifest push %s: no manifest list in the exported layout", ref)
+}
+
+// saveIndexToPodman replaces the podman manifest list ref with idx: its images
+// are loaded under temporary tags, added to a new list and untagged again.
+func saveIndexToPodman(idx v1.ImageIndex, ref string) error {
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return fmt.Errorf("reading index manifest: %w", err)
+	}
+
+	var entries []v1.Descriptor
+	var refs []string
+	for i, desc := range manifest.Manifests {
+		if !desc.MediaType.IsImage() {
+			fmt.Fprintf(os.Stderr, "Warning: leaving nested index %s out of %s, podman can't add it back\n", desc.Digest, ref)
+			continue
+		}
+		img, err := idx.Image(desc.Digest)
+		if err != nil {
+			return fmt.Errorf("reading image %s: %w", desc.Digest, err)
+		}
+		tmpRef := fmt.Sprintf("%s-ov-merge-%d", ref, i)
+		if err := SaveEngineImage(img, "podman", tmpRef); err != nil {
+			return err
+		}
+		defer engineCmd("podman", "untag", tmpRef).Run()
+		entries = append(entries, desc)
+		refs = append(refs, tmpRef)
+	}
+
+	for _, args := range podmanManifestRebuildArgs(ref, manifest.Annotations, entries, refs) {
+		cmd := engineCmd(args[0], args[1:]...)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("podman manifest %s: %w", args[2], err)
+		}
+	}
+	return nil
+}
+
+// podmanManifestRebuildArgs returns the podman commands recreating the manifest
+// list ref from the images refs (loaded in podman's store), entries holding
+// their platforms and annotations
+func podmanManifestRebuildArgs(ref string, annotations map[string]string, entries []v1.Descriptor, refs []string) [][]string {
+	cmds := [][]string{
+		{"podman", "manifest", "rm", ref},
+		{"podman", "manifest", "create", ref},
+	}
+	for i, desc := range entries {
+		args := []string{"podman", "manifest", "add"}
+		if p := desc.Platform; p != nil {
+			if p.OS != "" {
+				args = append(args, "--os", p.OS)
+			}
+			if p.Architecture != "" {
+				args = append(args, "--arch", p.Architecture)
+			}
+			if p.Variant != "" {
+				args = append(args, "--variant", p.Variant)
+			}
+			if p.OSVersion != "" {
+				args = append(args, "--os-version", p.OSVersion)
+			}
+			if len(p.OSFeatures) > 0 {
+				args = append(args, "--os-features", strings.Join(p.OSFeatures, ","))
+			}
+		}
+		args = append(args, annotationArgs(desc.Annotations)...)
+		cmds = append(cmds, append(args, ref, "containers-storage:"+refs[i]))
+	}
+	if len(annotations) > 0 {
+		args := append([]string{"podman", "manifest", "annotate", "--index"}, annotationArgs(annotations)...)
+		cmds = append(cmds, append(args, ref))
+	}
+	return cmds
+}
+
+// annotationArgs returns --annotation flags for annotations, sorted by key
+func annotationArgs(annotations map[string]string) []string {
+	keys := make([]string, 0, len(annotations))
+	for k := range annotations {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+	var args []string
+	for _, k := range keys {
+		args = append(args, "--annotation", k+"="+annotations[k])
+	}
+	return args
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// indexImage builds an image of one small layer per file map
+func indexImage(t *testing.T, files ...map[string]string) v1.Image {
+	t.Helper()
+	img := empty.Image
+	for _, f := range files {
+		layer, err := makeTarLayer(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img, err = mutate.AppendLayers(img, layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return img
+}
+
+func TestMergeIndex(t *testing.T) {
+	amd64 := indexImage(t, map[string]string{"a": "1"}, map[string]string{"b": "2"}, map[string]string{"c": "3"})
+	arm64 := indexImage(t, map[string]string{"a": "1"}, map[string]string{"b": "2"})
+	attestation := indexImage(t, map[string]string{"sbom.json": "{}"})
+
+	idx := mutate.IndexMediaType(empty.Index, types.OCIImageIndex)
+	idx = mutate.Annotations(idx, map[string]string{"org.opencontainers.image.source": "https://example.com/repo"}).(v1.ImageIndex)
+	idx = mutate.AppendManifests(idx,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{
+			Platform:    &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"},
+			Annotations: map[string]string{"org.opencontainers.image.title": "arm"},
+		}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"}}},
+	)
+
+	plan := mergePlanner{steps: func(sizes []int64, _ map[int]bool) []MergeStep { return planMerge(sizes, 1024*mb) }}
+	newIdx, err := mergeIndex(idx, plan, t.TempDir(), io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orig, err := idx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := newIdx.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaType != types.OCIImageIndex || !reflect.DeepEqual(manifest.Annotations, orig.Annotations) {
+		t.Errorf("index media type = %s annotations = %v, want %s %v", manifest.MediaType, manifest.Annotations, types.OCIImageIndex, orig.Annotations)
+	}
+	if len(manifest.Manifests) != 3 {
+		t.Fatalf("index entries = %d, want 3", len(manifest.Manifests))
+	}
+	for i, desc := range manifest.Manifests {
+		want := orig.Manifests[i]
+		if !reflect.DeepEqual(desc.Platform, want.Platform) || !reflect.DeepEqual(desc.Annotations, want.Annotations) {
+			t.Errorf("entry %d platform = %v annotations = %v, want %v %v", i, desc.Platform, desc.Annotations, want.Platform, want.Annotations)
+		}
+		img, err := newIdx.Image(desc.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(layers) != 1 {
+			t.Errorf("%s: layers = %d, want 1", desc.Platform, len(layers))
+		}
+	}
+	if manifest.Manifests[2].Digest != orig.Manifests[2].Digest {
+		t.Errorf("attestation digest changed, want it carried over unchanged")
+	}
+}
+
+func TestPodmanManifestRebuildArgs(t *testing.T) {
+	entries := []v1.Descriptor{
+		{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+		{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, Annotations: map[string]string{"b": "2", "a": "1"}},
+	}
+	got := podmanManifestRebuildArgs("ghcr.io/x/app:v1", map[string]string{"k": "v"}, entries,
+		[]string{"ghcr.io/x/app:v1-ov-merge-0", "ghcr.io/x/app:v1-ov-merge-1"})
+	want := [][]string{
+		{"podman", "manifest", "rm", "ghcr.io/x/app:v1"},
+		{"podman", "manifest", "create", "ghcr.io/x/app:v1"},
+		{"podman", "manifest", "add", "--os", "linux", "--arch", "amd64", "ghcr.io/x/app:v1", "containers-storage:ghcr.io/x/app:v1-ov-merge-0"},
+		{"podman", "manifest", "add", "--os", "linux", "--arch", "arm64", "--variant", "v8", "--annotation", "a=1", "--annotation", "b=2",
+			"ghcr.io/x/app:v1", "containers-storage:ghcr.io/x/app:v1-ov-merge-1"},
+		{"podman", "manifest", "annotate", "--index", "--annotation", "k=v", "ghcr.io/x/app:v1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("podmanManifestRebuildArgs() =\n%v\nwant\n%v", got, want)
+	}
+}
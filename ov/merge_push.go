@@ -0,0 +1,210 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// pushMergedImage merges the image ref of the engine's local store and pushes
+// the result to the registry reference pushRef instead of saving it locally.
+// A podman manifest list is merged per platform and pushed as a list.
+func pushMergedImage(engine, ref, pushRef string, insecure bool, plan mergePlanner, out io.Writer) error {
+	spillDir, err := os.MkdirTemp("", "ov-merge-")
+	if err != nil {
+		return fmt.Errorf("creating spill directory: %w", err)
+	}
+	defer os.RemoveAll(spillDir)
+
+	if engine == "podman" && podmanManifestExists(ref) {
+		idx, cleanup, err := loadIndexFromPodman(ref)
+		if err != nil {
+			return err
+		}
+		defer cleanup()
+		newIdx, err := mergeIndex(idx, plan, spillDir, out)
+		if err != nil {
+			return err
+		}
+		return pushIndex(newIdx, pushRef, insecure, out)
+	}
+
+	img, cleanup, err := LoadEngineImage(ref, engine)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	newImg, err := mergeImage(img, plan, spillDir, out)
+	if err != nil {
+		return err
+	}
+	return pushImage(newImg, pushRef, insecure, out)
+}
+
+// pushImage pushes img to the registry reference ref, one layer at a time so
+// the progress of each layer can be printed, and prints the pushed digest
+func pushImage(img v1.Image, ref string, insecure bool, out io.Writer) error {
+	r, opts, err := pushTarget(ref, insecure)
+	if err != nil {
+		return err
+	}
+	if err := pushLayers(r.Context(), img, opts, out); err != nil {
+		return err
+	}
+	if err := remote.Write(r, img, opts...); err != nil {
+		return fmt.Errorf("pushing %s: %w", ref, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return fmt.Errorf("computing image digest: %w", err)
+	}
+	fmt.Fprintf(out, "Pushed %s@%s\n", r.Context(), digest)
+	return nil
+}
+
+// pushIndex pushes the platform images of idx layer by layer, then the index
+// itself, and prints the pushed digest of the index
+func pushIndex(idx v1.ImageIndex, ref string, insecure bool, out io.Writer) error {
+	r, opts, err := pushTarget(ref, insecure)
+	if err != nil {
+		return err
+	}
+	err = forEachPlatformImage(idx, func(platform string, img v1.Image) error {
+		fmt.Fprintf(out, "%s:\n", platform)
+		return pushLayers(r.Context(), img, opts, out)
+	})
+	if err != nil {
+		return err
+	}
+	if err := remote.WriteIndex(r, idx, opts...); err != nil {
+		return fmt.Errorf("pushing %s: %w", ref, err)
+	}
+	digest, err := idx.Digest()
+	if err != nil {
+		return fmt.Errorf("computing index digest: %w", err)
+	}
+	fmt.Fprintf(out, "Pushed %s@%s\n", r.Context(), digest)
+	return nil
+}
+
+// pushTarget parses the push reference and returns the remote options: the
+// credentials of the default keychain (~/.docker/config.json and credential
+// helpers) and, with insecure, plain HTTP and unverified TLS for the registry
+func pushTarget(ref string, insecure bool) (name.Reference, []remote.Option, error) {
+	var nameOpts []name.Option
+	opts := []remote.Option{remote.WithAuthFromKeychain(authn.DefaultKeychain)}
+	if insecure {
+		nameOpts = append(nameOpts, name.Insecure)
+		t := remote.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		opts = append(opts, remote.WithTransport(t))
+	}
+	r, err := name.ParseReference(ref, nameOpts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing push reference %q: %w", ref, err)
+	}
+	return r, opts, nil
+}
+
+// pushLayers uploads the layers of img to repo, printing the progress of
+// each. Layers the registry already has are skipped; layers of the base image
+// recorded in the org.overthink.base label are mounted from its repository
+// when the registry supports cross-repository mounts.
+func pushLayers(repo name.Repository, img v1.Image, opts []remote.Option, out io.Writer) error {
+	layers, err := img.Layers()
+	if err != nil {
+		return fmt.Errorf("reading layers: %w", err)
+	}
+	mounts := baseLayers(img, repo, opts)
+	for i, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil {
+			return fmt.Errorf("computing layer %d digest: %w", i, err)
+		}
+		size, err := layer.Size()
+		if err != nil {
+			return fmt.Errorf("reading layer %d size: %w", i, err)
+		}
+		if m, ok := mounts[digest]; ok {
+			layer = m
+		}
+
+		fmt.Fprintf(out, "  [%d/%d] %s %s", i+1, len(layers), shortDigest(digest), formatMB(size))
+		updates := make(chan v1.Update, 64)
+		done := make(chan struct{})
+		go func() {
+			printLayerProgress(out, updates, size)
+			close(done)
+		}()
+		err = remote.WriteLayer(repo, layer, append(opts, remote.WithProgress(updates))...)
+		<-done
+		fmt.Fprintln(out)
+		if err != nil {
+			return fmt.Errorf("pushing layer %s: %w", digest, err)
+		}
+	}
+	return nil
+}
+
+// printLayerProgress prints the upload progress of a layer of the given size
+// in steps of 25% until updates is closed
+func printLayerProgress(out io.Writer, updates <-chan v1.Update, size int64) {
+	step := 0
+	for u := range updates {
+		if u.Error != nil || size <= 0 {
+			continue
+		}
+		for step < 4 && u.Complete*4 >= size*int64(step+1) {
+			step++
+			fmt.Fprintf(out, " %d%%", step*25)
+		}
+	}
+}
+
+// baseLayers returns the layers of the image's base (org.overthink.base), by
+// digest, for cross-repository mounts when the base is in the registry of repo.
+// Failing to read the base only disables mounting.
+func baseLayers(img v1.Image, repo name.Repository, opts []remote.Option) map[v1.Hash]v1.Layer {
+	cfg, err := img.ConfigFile()
+	if err != nil || cfg.Config.Labels[LabelBase] == "" {
+		return nil
+	}
+	var nameOpts []name.Option
+	if repo.Scheme() == "http" {
+		nameOpts = append(nameOpts, name.Insecure)
+	}
+	ref, err := name.ParseReference(cfg.Config.Labels[LabelBase], nameOpts...)
+	if err != nil || ref.Context().RegistryStr() != repo.RegistryStr() {
+		return nil
+	}
+	base, err := remote.Image(ref, opts...)
+	if err != nil {
+		return nil
+	}
+	layers, err := base.Layers()
+	if err != nil {
+		return nil
+	}
+	mounts := make(map[v1.Hash]v1.Layer, len(layers))
+	for _, layer := range layers {
+		if digest, err := layer.Digest(); err == nil {
+			mounts[digest] = layer
+		}
+	}
+	return mounts
+}
+
+// shortDigest returns the algorithm and first 12 hex characters of a digest
+func shortDigest(h v1.Hash) string {
+	if len(h.Hex) > 12 {
+		return h.Algorithm + ":" + h.Hex[:12]
+	}
+	return h.String()
+}
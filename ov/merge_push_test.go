@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPushMergedImage(t *testing.T) {
+	origLoad := LoadEngineImage
+	defer func() { LoadEngineImage = origLoad }()
+
+	source := indexImage(t, map[string]string{"a": "1"}, map[string]string{"b": "2"}, map[string]string{"c": "3"})
+	LoadEngineImage = func(ref, engine string) (v1.Image, func(), error) {
+		return source, func() {}, nil
+	}
+
+	// Count blob uploads to see which layers are skipped
+	var uploads int32
+	reg := registry.New(registry.Logger(log.New(io.Discard, "", 0)))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/blobs/uploads/") {
+			atomic.AddInt32(&uploads, 1)
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	pushRef := strings.TrimPrefix(srv.URL, "http://") + "/x/app:merged"
+	plan := newMergePlanner(&MergeConfig{MaxMB: 128}, 0, 0, "")
+
+	var out bytes.Buffer
+	if err := pushMergedImage("docker", "app:latest", pushRef, true, plan, &out); err != nil {
+		t.Fatalf("pushMergedImage() error = %v", err)
+	}
+
+	ref, err := name.ParseReference(pushRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed, err := remote.Image(ref)
+	if err != nil {
+		t.Fatalf("reading pushed image: %v", err)
+	}
+	layers, err := pushed.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(layers) != 1 {
+		t.Errorf("pushed %d layers, want 1 merged layer", len(layers))
+	}
+	digest, err := pushed.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "@"+digest.String()) {
+		t.Errorf("output doesn't report the pushed digest %s:\n%s", digest, out.String())
+	}
+	if !strings.Contains(out.String(), "[1/1]") || !strings.Contains(out.String(), "100%") {
+		t.Errorf("output doesn't show the layer progress:\n%s", out.String())
+	}
+	if uploads == 0 {
+		t.Errorf("no blobs uploaded")
+	}
+
+	// Pushing the same merge again skips every blob the registry has
+	atomic.StoreInt32(&uploads, 0)
+	out.Reset()
+	if err := pushMergedImage("docker", "app:latest", pushRef, false, plan, &out); err != nil {
+		t.Fatalf("second pushMergedImage() error = %v", err)
+	}
+	if uploads != 0 {
+		t.Errorf("second push uploaded %d blobs, want 0", uploads)
+	}
+	if !strings.Contains(out.String(), "@"+digest.String()) {
+		t.Errorf("second push digest differs, want %s:\n%s", digest, out.String())
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// MergeReport is what ov merge --dry-run prints for an image: the planned
+// groups with the size and history of every layer, and the totals
+type MergeReport struct {
+	Image        string             `json:"image"`
+	Platform     string             `json:"platform,omitempty"` // manifest list entries only
+	Groups       []MergeReportGroup `json:"groups"`
+	LayersBefore int                `json:"layers_before"`
+	LayersAfter  int                `json:"layers_after"`
+	TotalBytes   int64              `json:"total_bytes"`  // compressed size of all layers
+	MergedBytes  int64              `json:"merged_bytes"` // compressed size of the layers that get merged
+}
+
+// MergeReportGroup is a planned group: a single layer kept as is, or layers merged into one
+type MergeReportGroup struct {
+	Merge  bool               `json:"merge"`
+	Bytes  int64              `json:"bytes"`
+	Layers []MergeReportLayer `json:"layers"`
+}
+
+// MergeReportLayer is a layer of the image
+type MergeReportLayer struct {
+	Index     int    `json:"index"`
+	Bytes     int64  `json:"bytes"`
+	CreatedBy string `json:"created_by"`         // history entry of the layer, shortened
+	Boundary  bool   `json:"boundary,omitempty"` // follows a layer boundary marker
+}
+
+// createdByWidth is the length CreatedBy is shortened to
+const createdByWidth = 48
+
+// newMergeReport describes steps planned for layers of the given sizes;
+// createdBy holds the history entry of each layer
+func newMergeReport(image string, sizes []int64, createdBy []string, boundaries map[int]bool, steps []MergeStep) *MergeReport {
+	r := &MergeReport{Image: image, Groups: []MergeReportGroup{}, LayersBefore: len(sizes), LayersAfter: len(steps)}
+	for _, step := range steps {
+		group := MergeReportGroup{Merge: !step.Keep}
+		for _, idx := range step.Layers {
+			layer := MergeReportLayer{Index: idx, Bytes: sizes[idx], Boundary: boundaries[idx]}
+			if idx < len(createdBy) {
+				layer.CreatedBy = shortCreatedBy(createdBy[idx])
+			}
+			group.Layers = append(group.Layers, layer)
+			group.Bytes += sizes[idx]
+		}
+		r.TotalBytes += group.Bytes
+		if group.Merge {
+			r.MergedBytes += group.Bytes
+		}
+		r.Groups = append(r.Groups, group)
+	}
+	return r
+}
+
+// layerCreatedBy returns the CreatedBy of the history entry of every layer of img
+func layerCreatedBy(img v1.Image) ([]string, error) {
+	cfgFile, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var createdBy []string
+	for _, h := range cfgFile.History {
+		if !h.EmptyLayer {
+			createdBy = append(createdBy, h.CreatedBy)
+		}
+	}
+	return createdBy, nil
+}
+
+// shortCreatedBy drops the shell prefix of a history entry, collapses
+// whitespace and cuts it to createdByWidth
+func shortCreatedBy(s string) string {
+	s = strings.TrimPrefix(s, "/bin/sh -c ")
+	s = strings.TrimPrefix(s, "#(nop) ")
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > createdByWidth {
+		s = string(r[:createdByWidth-3]) + "..."
+	}
+	return s
+}
+
+// JSON returns the report as indented JSON
+func (r *MergeReport) JSON() ([]byte, error) {
+	return json.MarshalIndent(r, "", "  ")
+}
+
+// MergeReportsJSON encodes reports as one JSON array
+func MergeReportsJSON(reports []*MergeReport) ([]byte, error) {
+	if reports == nil {
+		reports = []*MergeReport{}
+	}
+	return json.MarshalIndent(reports, "", "  ")
+}
+
+// Text renders the report as a table of layers, merged groups bracketed by
+// \ and /, followed by the totals
+func (r *MergeReport) Text() string {
+	var b strings.Builder
+	title := r.Image
+	if r.Platform != "" {
+		title += " (" + r.Platform + ")"
+	}
+	fmt.Fprintf(&b, "%s\n\n", title)
+	b.WriteString("Layer        Size     Created by\n")
+
+	var mergedLayers, mergedGroups int
+	for _, group := range r.Groups {
+		if group.Merge {
+			mergedLayers += len(group.Layers)
+			mergedGroups++
+		}
+		for i, layer := range group.Layers {
+			if layer.Boundary {
+				b.WriteString("---- layer boundary ----\n")
+			}
+			bracket, note := " ", "[keep]"
+			if group.Merge {
+				switch i {
+				case 0:
+					bracket, note = "\\", ""
+				case len(group.Layers) - 1:
+					bracket, note = "/", fmt.Sprintf("> merge (%s)", formatMB(group.Bytes))
+				default:
+					bracket, note = "|", ""
+				}
+			}
+			line := fmt.Sprintf("%5d  %10s  %s  %-*s  %s", layer.Index, formatMB(layer.Bytes), bracket, createdByWidth, layer.CreatedBy, note)
+			b.WriteString(strings.TrimRight(line, " ") + "\n")
+		}
+	}
+
+	fmt.Fprintf(&b, "\nLayers: %d -> %d\n", r.LayersBefore, r.LayersAfter)
+	fmt.Fprintf(&b, "Size:   %s, %s in %d layers merged into %d\n",
+		formatMB(r.TotalBytes), formatMB(r.MergedBytes), mergedLayers, mergedGroups)
+	return b.String()
+}
+
+// formatMB formats a byte count in MB with one decimal
+func formatMB(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/(1024*1024))
+}
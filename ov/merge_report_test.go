@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mergeReportFixture is a report of five layers with a boundary before the last
+func mergeReportFixture() *MergeReport {
+	sizes := []int64{250 * mb, 4 * mb, 3 * mb / 2, 2 * mb, 20 * mb}
+	createdBy := []string{
+		"/bin/sh -c dnf install -y python3 python3-pip git   && dnf clean all",
+		"/bin/sh -c #(nop) COPY dir:1234 in /etc/app",
+		"RUN /bin/sh -c pixi install # buildkit",
+		"/bin/sh -c #(nop) ENV PATH=/usr/local/bin:/usr/bin",
+		"/bin/sh -c supervisord-config",
+	}
+	boundaries := map[int]bool{4: true}
+	steps := planMergeWith(sizes, boundaries, 128*mb, 0, MergeStrategyGreedy)
+	return newMergeReport("ghcr.io/x/app:latest", sizes, createdBy, boundaries, steps)
+}
+
+func TestMergeReportText(t *testing.T) {
+	got := mergeReportFixture().Text()
+	want, err := os.ReadFile(filepath.Join("testdata", "merge-report.golden.txt"))
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if got != string(want) {
+		t.Errorf("Text() =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestMergeReportJSON(t *testing.T) {
+	r := mergeReportFixture()
+	if r.LayersBefore != 5 || r.LayersAfter != 3 || r.TotalBytes != 555*mb/2 || r.MergedBytes != 15*mb/2 {
+		t.Errorf("totals = %d -> %d layers, %d / %d bytes", r.LayersBefore, r.LayersAfter, r.TotalBytes, r.MergedBytes)
+	}
+
+	data, err := r.JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	var decoded MergeReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(&decoded, r) {
+		t.Errorf("decoded = %+v, want %+v", decoded, r)
+	}
+	for _, key := range []string{`"layers_before"`, `"layers_after"`, `"merged_bytes"`, `"created_by"`, `"boundary"`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("JSON missing %s", key)
+		}
+	}
+}
+
+func TestMergeReportsJSON(t *testing.T) {
+	amd64, arm64 := mergeReportFixture(), mergeReportFixture()
+	amd64.Platform, arm64.Platform = "linux/amd64", "linux/arm64"
+
+	data, err := MergeReportsJSON([]*MergeReport{amd64, arm64})
+	if err != nil {
+		t.Fatalf("MergeReportsJSON() error = %v", err)
+	}
+	// One document, not one per platform
+	var decoded []*MergeReport
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON array: %v\n%s", err, data)
+	}
+	if !reflect.DeepEqual(decoded, []*MergeReport{amd64, arm64}) {
+		t.Errorf("decoded = %+v, want the amd64 and arm64 reports", decoded)
+	}
+
+	if data, err = MergeReportsJSON(nil); err != nil || string(data) != "[]" {
+		t.Errorf("MergeReportsJSON(nil) = %s, %v; want []", data, err)
+	}
+}
@@ -3,13 +3,20 @@ package main
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"fmt"
 	"io"
+	"reflect"
+	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/empty"
 	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 )
 
 const mb = 1024 * 1024
@@ -85,6 +92,120 @@ func TestPlanMerge_MixedSizes(t *testing.T) {
 	}
 }
 
+// TestPlanMergeStrategies locks in the plans of both strategies, with and without min_mb.
+func TestPlanMergeStrategies(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  []int64 // MB
+		max    int64
+		min    int64
+		greedy [][]int
+		pack   [][]int
+	}{
+		{"all fit", []int64{10, 20, 30, 15}, 1024, 0,
+			[][]int{{0, 1, 2, 3}}, [][]int{{0, 1, 2, 3}}},
+		{"equal halves", []int64{40, 40, 40, 40}, 100, 0,
+			[][]int{{0, 1}, {2, 3}}, [][]int{{0, 1}, {2, 3}}},
+		{"large layer alone", []int64{10, 300, 20}, 256, 0,
+			[][]int{{0}, {1}, {2}}, [][]int{{0}, {1}, {2}}},
+		// Same number of layers, but pack merges the small ones with each other
+		{"small run between large layers", []int64{250, 4, 4, 4, 250}, 256, 0,
+			[][]int{{0, 1}, {2, 3}, {4}}, [][]int{{0}, {1, 2, 3}, {4}}},
+		{"small run after a large layer", []int64{200, 50, 10, 10, 60}, 256, 0,
+			[][]int{{0, 1}, {2, 3, 4}}, [][]int{{0}, {1, 2, 3, 4}}},
+		// Pack still merges onto a large layer when that saves a layer
+		{"pack fills up to the cap", []int64{250, 3, 3}, 256, 0,
+			[][]int{{0, 1, 2}}, [][]int{{0, 1, 2}}},
+		// Groups below min_mb coalesce forward, even past max_mb
+		{"min_mb", []int64{250, 4, 4, 4, 250}, 256, 16,
+			[][]int{{0, 1}, {2, 3, 4}}, [][]int{{0}, {1, 2, 3, 4}}},
+		{"min_mb skips oversized layers", []int64{4, 300, 4}, 256, 16,
+			[][]int{{0}, {1}, {2}}, [][]int{{0}, {1}, {2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sizes := make([]int64, len(tt.sizes))
+			for i, size := range tt.sizes {
+				sizes[i] = size * mb
+			}
+			for strategy, want := range map[string][][]int{MergeStrategyGreedy: tt.greedy, MergeStrategyPack: tt.pack} {
+				steps := planMergeWith(sizes, nil, tt.max*mb, tt.min*mb, strategy)
+				var got [][]int
+				for _, step := range steps {
+					if step.Keep != (len(step.Layers) == 1) {
+						t.Errorf("%s: step %v Keep = %v", strategy, step.Layers, step.Keep)
+					}
+					got = append(got, step.Layers)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: plan = %v, want %v", strategy, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestPlanMergeBoundaries verifies a boundary splits layers that would otherwise merge.
+func TestPlanMergeBoundaries(t *testing.T) {
+	sizes := []int64{10 * mb, 10 * mb, 10 * mb, 10 * mb, 10 * mb}
+
+	steps := planMergeWith(sizes, nil, 100*mb, 0, MergeStrategyGreedy)
+	if len(steps) != 1 {
+		t.Fatalf("without boundaries: %d steps, want 1", len(steps))
+	}
+
+	for _, strategy := range []string{MergeStrategyGreedy, MergeStrategyPack} {
+		// min_mb doesn't merge across a boundary either
+		steps = planMergeWith(sizes, map[int]bool{2: true, 4: true}, 100*mb, 50*mb, strategy)
+		var got [][]int
+		for _, step := range steps {
+			got = append(got, step.Layers)
+		}
+		want := [][]int{{0, 1}, {2, 3}, {4}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: groups = %v, want %v", strategy, got, want)
+		}
+		if steps[0].Keep || steps[1].Keep || !steps[2].Keep {
+			t.Errorf("%s: steps = %+v, want the single layer kept", strategy, steps)
+		}
+	}
+}
+
+// TestBoundaryLayers verifies boundary markers in the history pin the next layer.
+func TestBoundaryLayers(t *testing.T) {
+	var adds []mutate.Addendum
+	for _, h := range []v1.History{
+		{CreatedBy: "RUN base"},
+		{CreatedBy: "RUN base2"},
+		{CreatedBy: "/bin/sh -c #(nop) LABEL org.overthink.layer-boundary=app", EmptyLayer: true},
+		{CreatedBy: "ENV A=1", EmptyLayer: true},
+		{CreatedBy: "RUN app"},
+		{CreatedBy: "RUN app2"},
+	} {
+		add := mutate.Addendum{History: h}
+		if !h.EmptyLayer {
+			layer, err := makeTarLayer(map[string]string{"f": h.CreatedBy})
+			if err != nil {
+				t.Fatal(err)
+			}
+			add.Layer = layer
+		}
+		adds = append(adds, add)
+	}
+	img, err := mutate.Append(empty.Image, adds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	boundaries, err := boundaryLayers(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(boundaries, map[int]bool{2: true}) {
+		t.Errorf("boundaryLayers() = %v, want layer 2", boundaries)
+	}
+}
+
 // makeTarLayer creates a synthetic layer containing the given files.
 func makeTarLayer(files map[string]string) (v1.Layer, error) {
 	var buf bytes.Buffer
@@ -155,7 +276,7 @@ func TestMergeLayers(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	merged, err := mergeLayers([]v1.Layer{layer1, layer2})
+	merged, _, err := mergeLayers([]v1.Layer{layer1, layer2}, nil, t.TempDir(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -191,7 +312,7 @@ func TestMergeLayers_Whiteout(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	merged, err := mergeLayers([]v1.Layer{layer1, layer2})
+	merged, _, err := mergeLayers([]v1.Layer{layer1, layer2}, newLowerFiles(), t.TempDir(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -209,6 +330,218 @@ func TestMergeLayers_Whiteout(t *testing.T) {
 	}
 }
 
+// readTarNames returns the entry names of a layer in tar order.
+func readTarNames(t *testing.T, layer v1.Layer) []string {
+	t.Helper()
+	entries, err := scanLayerEntries(layer, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Header.Name)
+	}
+	return names
+}
+
+// mergeTarLayers merges layers built from the given file maps.
+func mergeTarLayers(t *testing.T, lower *lowerFiles, files ...map[string]string) v1.Layer {
+	t.Helper()
+	var layers []v1.Layer
+	for _, f := range files {
+		layer, err := makeTarLayer(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, layer)
+	}
+	merged, _, err := mergeLayers(layers, lower, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return merged
+}
+
+// TestMergeLayers_OpaqueDir verifies an opaque marker drops the earlier children of its directory.
+func TestMergeLayers_OpaqueDir(t *testing.T) {
+	merged := mergeTarLayers(t, newLowerFiles(),
+		map[string]string{"etc/app/a.conf": "a", "etc/app/b.conf": "b", "etc/other": "o"},
+		map[string]string{"etc/app/.wh..wh..opq": "", "etc/app/c.conf": "c"},
+	)
+
+	names := readTarNames(t, merged)
+	want := []string{"etc/app/.wh..wh..opq", "etc/app/c.conf", "etc/other"}
+	sortStrings(names)
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+	if first := readTarNames(t, merged)[0]; first != "etc/app/.wh..wh..opq" {
+		t.Errorf("first entry = %q, want the opaque marker before the re-added files", first)
+	}
+}
+
+// TestMergeLayers_WhiteoutThenRecreate verifies a re-created file follows its whiteout.
+func TestMergeLayers_WhiteoutThenRecreate(t *testing.T) {
+	merged := mergeTarLayers(t, newLowerFiles(),
+		map[string]string{"usr/bin/app": "v1"},
+		map[string]string{"usr/bin/.wh.app": ""},
+		map[string]string{"usr/bin/app": "v3"},
+	)
+
+	names := readTarNames(t, merged)
+	if !reflect.DeepEqual(names, []string{"usr/bin/.wh.app", "usr/bin/app"}) {
+		t.Errorf("entries = %v, want the whiteout, then usr/bin/app", names)
+	}
+	entries, err := readTarEntries(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["usr/bin/app"] != "v3" {
+		t.Errorf("usr/bin/app = %q, want v3", entries["usr/bin/app"])
+	}
+}
+
+// TestMergeLayers_NestedRemoval verifies a directory whiteout drops everything below it.
+func TestMergeLayers_NestedRemoval(t *testing.T) {
+	files := []map[string]string{
+		{"opt/tool/bin/x": "x", "opt/tool/lib/y": "y", "opt/keep": "k"},
+		{"opt/tool/lib/.wh.y": "", "opt/tool/share/z": "z"},
+		{"opt/.wh.tool": ""},
+	}
+
+	names := readTarNames(t, mergeTarLayers(t, newLowerFiles(), files...))
+	if !reflect.DeepEqual(names, []string{"opt/.wh.tool", "opt/keep"}) {
+		t.Errorf("entries = %v, want [opt/.wh.tool opt/keep]", names)
+	}
+
+	// Without layers below the group the whiteout isn't needed
+	names = readTarNames(t, mergeTarLayers(t, nil, files...))
+	if !reflect.DeepEqual(names, []string{"opt/keep"}) {
+		t.Errorf("entries without lower layers = %v, want [opt/keep]", names)
+	}
+}
+
+// TestMergeLayers_Dedupe verifies duplicate paths collapse to one entry and
+// files identical to the layers below are left out.
+func TestMergeLayers_Dedupe(t *testing.T) {
+	lower := newLowerFiles()
+	base, err := makeTarLayer(map[string]string{
+		"etc/same.conf":  "same",
+		"etc/other.conf": "old",
+		"etc/gone.conf":  "gone",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lower.add(base); err != nil {
+		t.Fatal(err)
+	}
+
+	var layers []v1.Layer
+	for _, files := range []map[string]string{
+		{"app/bin": "v1", "etc/same.conf": "same", "etc/other.conf": "new"},
+		{"app/bin": "v2", "etc/.wh.gone.conf": ""},
+		{"etc/gone.conf": "gone"},
+	} {
+		layer, err := makeTarLayer(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layers = append(layers, layer)
+	}
+	merged, saved, err := mergeLayers(layers, lower, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// etc/same.conf is provided below; etc/gone.conf is identical but its
+	// whiteout must not remove it
+	names := readTarNames(t, merged)
+	sortStrings(names)
+	want := []string{"app/bin", "etc/.wh.gone.conf", "etc/gone.conf", "etc/other.conf"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+	entries, err := readTarEntries(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries["app/bin"] != "v2" || entries["etc/other.conf"] != "new" {
+		t.Errorf("app/bin = %q, etc/other.conf = %q, want v2 and new", entries["app/bin"], entries["etc/other.conf"])
+	}
+	if saved != int64(len("v1")+len("same")) {
+		t.Errorf("saved = %d, want %d", saved, len("v1")+len("same"))
+	}
+
+	// The same content with another mode is not a duplicate
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "etc/same.conf", Size: 4, Mode: 0600})
+	tw.Write([]byte("same"))
+	tw.Close()
+	data := buf.Bytes()
+	modeLayer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged, saved, err = mergeLayers([]v1.Layer{modeLayer}, lower, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := readTarNames(t, merged); !reflect.DeepEqual(names, []string{"etc/same.conf"}) || saved != 0 {
+		t.Errorf("entries = %v saved = %d, want etc/same.conf kept with another mode", names, saved)
+	}
+}
+
+// TestMergeLayers_Wide verifies merging over wide layers, with many files
+// below the group and in it, takes time linear in their entries.
+func TestMergeLayers_Wide(t *testing.T) {
+	if testing.Short() {
+		t.Skip("merges layers of 100k files")
+	}
+	const n = 100000
+	files := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("usr/share/d%d/f%d", i%100, i)] = "x"
+	}
+	wide, err := makeTarLayer(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opaque, err := makeTarLayer(map[string]string{"usr/share/d0/" + whiteoutOpaque: ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	lower := newLowerFiles()
+	for _, layer := range []v1.Layer{wide, opaque} {
+		if err := lower.add(layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	merged, saved, err := mergeLayers([]v1.Layer{wide, wide}, lower, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := readTarNames(t, merged)
+	elapsed := time.Since(start)
+
+	// Only the files the opaque directory hid from the layers below are new
+	if len(names) != n/100 {
+		t.Errorf("merged %d entries, want %d", len(names), n/100)
+	}
+	if want := int64(n + n - n/100); saved != want {
+		t.Errorf("saved = %d, want %d", saved, want)
+	}
+	// A scan of every path per entry takes minutes here
+	if elapsed > 30*time.Second {
+		t.Errorf("merge took %v, want time linear in the entries", elapsed)
+	}
+}
+
 // TestHistoryAlignment verifies empty-layer history entries are preserved correctly.
 func TestHistoryAlignment(t *testing.T) {
 	// Build a synthetic image with layers and mixed history
@@ -252,7 +585,7 @@ func TestHistoryAlignment(t *testing.T) {
 		t.Fatalf("expected 1 merge step, got %d steps", len(steps))
 	}
 
-	newImg, err := executeMerge(img, layers, steps)
+	newImg, err := executeMerge(img, layers, steps, t.TempDir(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -283,3 +616,338 @@ func TestHistoryAlignment(t *testing.T) {
 		t.Errorf("expected 1 layer after merge, got %d", len(newLayers))
 	}
 }
+
+// zeroLayer is a layer of files of zeros, produced on demand
+func zeroLayer(t *testing.T, prefix string, files int, size int64) v1.Layer {
+	t.Helper()
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		pr, pw := io.Pipe()
+		go func() {
+			tw := tar.NewWriter(pw)
+			for i := 0; i < files; i++ {
+				hdr := &tar.Header{Name: fmt.Sprintf("%s/file%d", prefix, i), Size: size, Mode: 0644, Typeflag: tar.TypeReg}
+				if err := tw.WriteHeader(hdr); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+				if _, err := io.CopyN(tw, zeroReader{}, size); err != nil {
+					pw.CloseWithError(err)
+					return
+				}
+			}
+			pw.CloseWithError(tw.Close())
+		}()
+		return pr, nil
+	}, tarball.WithCompressionLevel(gzip.BestSpeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// TestMergeLayers_BoundedMemory verifies merging streams the layer contents
+// instead of holding them in memory.
+func TestMergeLayers_BoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("merges several hundred MB")
+	}
+	const fileSize = 32 * mb
+	var layers []v1.Layer
+	for _, prefix := range []string{"a", "b", "c"} {
+		layers = append(layers, zeroLayer(t, prefix, 4, fileSize))
+	}
+	// The last layer replaces the files of the first one
+	layers = append(layers, zeroLayer(t, "a", 4, fileSize))
+	const input = 16 * fileSize
+
+	// Garbage of earlier tests doesn't count
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var peak atomic.Uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapAlloc > peak.Load() {
+				peak.Store(stats.HeapAlloc)
+			}
+			select {
+			case <-done:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+		}
+	}()
+
+	merged, saved, err := mergeLayers(layers, nil, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := merged.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(done)
+	<-sampled
+
+	if want := int64(12 * fileSize); n < want {
+		t.Errorf("merged tar = %d bytes, want at least %d", n, want)
+	}
+	if saved != 4*fileSize {
+		t.Errorf("saved = %d, want %d", saved, 4*fileSize)
+	}
+	if grown := int64(peak.Load()) - int64(before.HeapAlloc); grown > input/8 {
+		t.Errorf("heap grew by %d MB merging %d MB, want far less", grown/mb, input/mb)
+	}
+}
+
+// TestExecuteMerge_PreservesConfig verifies a merged image keeps the config,
+// media types and annotations of the original.
+func TestExecuteMerge_PreservesConfig(t *testing.T) {
+	created := v1.Time{Time: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)}
+	cfg := &v1.ConfigFile{
+		Architecture:  "arm64",
+		Variant:       "v8",
+		OS:            "linux",
+		OSVersion:     "6.1",
+		OSFeatures:    []string{"sse4"},
+		Author:        "overthink",
+		Container:     "builder",
+		DockerVersion: "27.0",
+		Created:       created,
+		Config: v1.Config{
+			Env:          []string{"PATH=/usr/bin", "HOME=/home/user"},
+			Entrypoint:   []string{"/usr/bin/supervisord"},
+			Cmd:          []string{"-n"},
+			Labels:       map[string]string{LabelVersion: "1"},
+			ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+			Volumes:      map[string]struct{}{"/data": {}},
+			User:         "1000",
+			WorkingDir:   "/home/user",
+			StopSignal:   "SIGTERM",
+			Shell:        []string{"/bin/bash", "-c"},
+			OnBuild:      []string{"RUN true"},
+			Healthcheck:  &v1.HealthConfig{Test: []string{"CMD", "true"}, Interval: time.Minute},
+		},
+		RootFS: v1.RootFS{Type: "layers"},
+	}
+
+	img := mutate.MediaType(empty.Image, types.OCIManifestSchema1)
+	img = mutate.ConfigMediaType(img, types.OCIConfigJSON)
+	img = mutate.Annotations(img, map[string]string{"org.opencontainers.image.source": "https://example.com/repo"}).(v1.Image)
+	img, err := mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var adds []mutate.Addendum
+	for i, files := range []map[string]string{{"a": "1"}, {"b": "2"}, {"c": "3"}} {
+		layer, err := makeTarLayer(files)
+		if err != nil {
+			t.Fatal(err)
+		}
+		adds = append(adds, mutate.Addendum{
+			Layer:     layer,
+			MediaType: types.OCILayer,
+			History:   v1.History{CreatedBy: fmt.Sprintf("RUN step%d", i), Created: created},
+		})
+	}
+	if img, err = mutate.Append(img, adds...); err != nil {
+		t.Fatal(err)
+	}
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []MergeStep{{Layers: []int{0, 1}}, {Keep: true, Layers: []int{2}}}
+	newImg, err := executeMerge(img, layers, steps, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := newImg.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after.RootFS.DiffIDs) != 2 || len(after.History) != 2 {
+		t.Errorf("diff ids = %d, history = %d, want 2 each", len(after.RootFS.DiffIDs), len(after.History))
+	}
+	if !after.History[0].Created.Equal(created.Time) {
+		t.Errorf("merged history created = %v, want %v", after.History[0].Created, created)
+	}
+	want, got := before.DeepCopy(), after.DeepCopy()
+	for _, cf := range []*v1.ConfigFile{want, got} {
+		cf.RootFS.DiffIDs = nil
+		cf.History = nil
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("config after merge = %+v\nwant %+v", got, want)
+	}
+
+	origManifest, err := img.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := newImg.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.MediaType != types.OCIManifestSchema1 || manifest.Config.MediaType != types.OCIConfigJSON {
+		t.Errorf("media types = %s, %s, want OCI", manifest.MediaType, manifest.Config.MediaType)
+	}
+	if !reflect.DeepEqual(manifest.Annotations, origManifest.Annotations) {
+		t.Errorf("annotations = %v, want %v", manifest.Annotations, origManifest.Annotations)
+	}
+	for i, layer := range manifest.Layers {
+		if layer.MediaType != types.OCILayer {
+			t.Errorf("layer %d media type = %s, want %s", i, layer.MediaType, types.OCILayer)
+		}
+	}
+}
+
+// builtImage returns an image of two small layers whose tar entries, history
+// and config carry the build time built
+func builtImage(t *testing.T, built time.Time) v1.Image {
+	t.Helper()
+	img, err := mutate.ConfigFile(empty.Image, &v1.ConfigFile{Created: v1.Time{Time: built}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"etc/a", "etc/b"} {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		content := []byte(name)
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), ModTime: built}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write(content)
+		tw.Close()
+		data := buf.Bytes()
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err = mutate.Append(img, mutate.Addendum{
+			Layer:   layer,
+			History: v1.History{CreatedBy: fmt.Sprintf("RUN step%d", i), Created: v1.Time{Time: built}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return img
+}
+
+func TestExecuteMerge_Reproducible(t *testing.T) {
+	first := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	second := first.Add(time.Hour)
+	epoch := time.Unix(1700000000, 0).UTC()
+
+	merge := func(img v1.Image, epoch *time.Time) v1.Image {
+		t.Helper()
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatal(err)
+		}
+		newImg, err := executeMerge(img, layers, []MergeStep{{Layers: []int{0, 1}}}, t.TempDir(), epoch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newImg
+	}
+	digest := func(img v1.Image) v1.Hash {
+		t.Helper()
+		h, err := img.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return h
+	}
+
+	// Without the flag the merge keeps the build's timestamps: the same
+	// input merges to the same digest, a rebuild to another one
+	plain := digest(merge(builtImage(t, first), nil))
+	if again := digest(merge(builtImage(t, first), nil)); again != plain {
+		t.Errorf("merging the same image twice: %s != %s", again, plain)
+	}
+	if rebuilt := digest(merge(builtImage(t, second), nil)); rebuilt == plain {
+		t.Errorf("merging a rebuild without reproducible gave the same digest %s", rebuilt)
+	}
+
+	// With the flag, rebuilds at other times merge to the same digest
+	merged := merge(builtImage(t, first), &epoch)
+	if a, b := digest(merged), digest(merge(builtImage(t, second), &epoch)); a != b {
+		t.Errorf("reproducible merges of rebuilds: %s != %s", a, b)
+	}
+
+	cfg, err := merged.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Created.Equal(epoch) || !cfg.History[0].Created.Equal(epoch) {
+		t.Errorf("config created = %v, history created = %v, want %v", cfg.Created, cfg.History[0].Created, epoch)
+	}
+	layers, err := merged.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layers[0].Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	tr := tar.NewReader(rc)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !hdr.ModTime.Equal(epoch) {
+			t.Errorf("%s mtime = %v, want %v", hdr.Name, hdr.ModTime, epoch)
+		}
+	}
+}
+
+func TestNewMergePlannerReproducible(t *testing.T) {
+	if plan := newMergePlanner(&MergeConfig{}, 0, 0, ""); plan.epoch != nil {
+		t.Errorf("epoch = %v without reproducible, want nil", plan.epoch)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	plan := newMergePlanner(&MergeConfig{Reproducible: true}, 0, 0, "")
+	if plan.epoch == nil || plan.epoch.Unix() != 0 {
+		t.Errorf("epoch = %v, want the Unix epoch", plan.epoch)
+	}
+
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	plan = newMergePlanner(&MergeConfig{Reproducible: true}, 0, 0, "")
+	if plan.epoch == nil || plan.epoch.Unix() != 1700000000 {
+		t.Errorf("epoch = %v, want SOURCE_DATE_EPOCH 1700000000", plan.epoch)
+	}
+}
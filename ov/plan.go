@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// IntermediatesPlan explains what ComputeIntermediates did: the trie of every
+// sibling group, the intermediates it created or reused, and how the bases of
+// user images changed. Printed by ov intermediates (text or --json).
+type IntermediatesPlan struct {
+	Groups        []PlanGroup        `json:"groups"`
+	Intermediates []PlanIntermediate `json:"intermediates"` // auto and pinned intermediates
+	Images        []PlanImage        `json:"images"`        // user images
+}
+
+// PlanGroup is the trie of images sharing a parent
+type PlanGroup struct {
+	Parent   string      `json:"parent"`   // image or external base of the group
+	Sequence []string    `json:"sequence"` // absolute layer sequence of the parent
+	Images   []string    `json:"images"`   // images of the group, before rebasing
+	Children []*PlanNode `json:"children"`
+}
+
+// PlanNode is a collapsed chain of the trie: a branch point, or a leaf where images end
+type PlanNode struct {
+	PathLayers   []string    `json:"path_layers"`            // layers of the chain below the enclosing node
+	Sequence     []string    `json:"sequence"`               // absolute layer sequence at this node
+	Parent       string      `json:"parent"`                 // image the node builds on
+	Intermediate string      `json:"intermediate,omitempty"` // image created or reused here
+	Decision     string      `json:"decision,omitempty"`     // auto, reused, below-threshold ("" for leaves)
+	Images       []string    `json:"images,omitempty"`       // images whose sequence ends here
+	Children     []*PlanNode `json:"children,omitempty"`
+}
+
+// PlanIntermediate is a generated image
+type PlanIntermediate struct {
+	Name      string   `json:"name"`
+	Base      string   `json:"base"`
+	BaseGroup string   `json:"base_group"` // external base at the root of its base chain
+	Layers    []string `json:"layers"`
+	Auto      bool     `json:"auto,omitempty"`
+	Pinned    bool     `json:"pinned,omitempty"`
+}
+
+// PlanImage is a user image with its base before and after ComputeIntermediates
+type PlanImage struct {
+	Name       string `json:"name"`
+	BaseBefore string `json:"base_before"`
+	BaseAfter  string `json:"base_after"`
+}
+
+// PlanIntermediates runs ComputeIntermediates on images and reports its decisions
+func PlanIntermediates(images map[string]*ResolvedImage, layers map[string]*Layer, cfg *Config, tag string) (*IntermediatesPlan, error) {
+	result, groups, err := computeIntermediates(images, layers, cfg, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &IntermediatesPlan{
+		Groups:        []PlanGroup{},
+		Intermediates: []PlanIntermediate{},
+		Images:        []PlanImage{},
+	}
+	for _, group := range groups {
+		pg := PlanGroup{
+			Parent:   group.parent,
+			Sequence: append([]string{}, group.sequence...),
+			Images:   append([]string{}, group.images...),
+		}
+		pg.Children = planChildren(group.root, pg.Sequence)
+		plan.Groups = append(plan.Groups, pg)
+	}
+	for _, name := range sortedImageNames(result) {
+		img := result[name]
+		if orig, ok := images[name]; ok {
+			plan.Images = append(plan.Images, PlanImage{Name: name, BaseBefore: orig.Base, BaseAfter: img.Base})
+		} else if img.Auto || img.Pinned {
+			plan.Intermediates = append(plan.Intermediates, PlanIntermediate{
+				Name:      name,
+				Base:      img.Base,
+				Layers:    append([]string{}, img.Layers...),
+				Auto:      img.Auto,
+				Pinned:    img.Pinned,
+				BaseGroup: img.BaseGroup,
+			})
+		}
+	}
+	return plan, nil
+}
+
+// planChildren converts the recorded chains below node, sequence being the
+// absolute layer sequence at node
+func planChildren(node *trieNode, sequence []string) []*PlanNode {
+	children := []*PlanNode{}
+	for _, key := range sortedKeys(node.children) {
+		// Follow the chain walkTrieScoped collapsed to the node it recorded
+		current := node.children[key]
+		for current.parent == "" && len(current.children) == 1 {
+			for _, next := range current.children {
+				current = next
+			}
+		}
+		seq := append(append([]string{}, sequence...), current.chain...)
+		children = append(children, &PlanNode{
+			PathLayers:   append([]string{}, current.chain...),
+			Sequence:     seq,
+			Parent:       current.parent,
+			Intermediate: current.intermediate,
+			Decision:     current.decision,
+			Images:       append([]string{}, current.images...),
+			Children:     planChildren(current, seq),
+		})
+	}
+	return children
+}
+
+// JSON returns the plan as indented JSON
+func (p *IntermediatesPlan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+// Text renders the plan as indented trees, one per sibling group, followed
+// by the intermediates and the base changes of user images
+func (p *IntermediatesPlan) Text() string {
+	var b strings.Builder
+	for _, group := range p.Groups {
+		fmt.Fprintf(&b, "%s (%s)\n", group.Parent, strings.Join(group.Images, ", "))
+		writePlanNodes(&b, group.Children, "  ")
+		b.WriteString("\n")
+	}
+
+	if len(p.Intermediates) > 0 {
+		b.WriteString("Intermediates:\n")
+		for _, inter := range p.Intermediates {
+			kind := "auto"
+			if inter.Pinned {
+				kind = "pinned"
+			}
+			base := inter.Base
+			if inter.BaseGroup != inter.Base {
+				base += " (" + inter.BaseGroup + ")"
+			}
+			fmt.Fprintf(&b, "  %s [%s] on %s: %s\n", inter.Name, kind, base, strings.Join(inter.Layers, ", "))
+		}
+		b.WriteString("\n")
+	}
+
+	b.WriteString("Images:\n")
+	width := 0
+	for _, img := range p.Images {
+		if len(img.Name) > width {
+			width = len(img.Name)
+		}
+	}
+	for _, img := range p.Images {
+		if img.BaseBefore == img.BaseAfter {
+			fmt.Fprintf(&b, "  %-*s  %s (unchanged)\n", width, img.Name, img.BaseAfter)
+		} else {
+			fmt.Fprintf(&b, "  %-*s  %s -> %s\n", width, img.Name, img.BaseBefore, img.BaseAfter)
+		}
+	}
+	return b.String()
+}
+
+// writePlanNodes writes one line per node: its layers, the intermediate of a
+// branch point and the images ending there
+func writePlanNodes(b *strings.Builder, nodes []*PlanNode, indent string) {
+	for _, node := range nodes {
+		line := indent + strings.Join(node.PathLayers, ", ")
+		switch node.Decision {
+		case planAuto, planReused:
+			line += fmt.Sprintf(" -> %s [%s]", node.Intermediate, node.Decision)
+		case planBelowThreshold:
+			line += " [" + planBelowThreshold + "]"
+		}
+		if len(node.Images) > 0 {
+			line += ": " + strings.Join(node.Images, ", ")
+		}
+		b.WriteString(line + "\n")
+		writePlanNodes(b, node.Children, indent+"  ")
+	}
+}
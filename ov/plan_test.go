@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanIntermediates(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":        {Name: "pixi", HasRootYml: true},
+		"python":      {Name: "python", Depends: []string{"pixi"}, HasPixiToml: true},
+		"supervisord": {Name: "supervisord", Depends: []string{"python"}, HasPixiToml: true},
+		"testapi":     {Name: "testapi", Depends: []string{"supervisord"}, HasPixiToml: true},
+		"openclaw":    {Name: "openclaw", Depends: []string{"supervisord"}, HasPackageJson: true},
+		"jupyter":     {Name: "jupyter", Depends: []string{"python"}, HasPixiToml: true},
+	}
+	const base = "quay.io/fedora/fedora:43"
+	images := make(map[string]*ResolvedImage)
+	for name, l := range map[string][]string{
+		"test":     {"testapi"},
+		"openclaw": {"openclaw"},
+		"notebook": {"jupyter"},
+	} {
+		images[name] = &ResolvedImage{Name: name, Base: base, IsExternalBase: true, Layers: l, Tag: "v1", FullTag: name + ":v1", Pkg: "rpm"}
+	}
+	cfg := &Config{Defaults: ImageConfig{Pkg: "rpm"}}
+
+	plan, err := PlanIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("PlanIntermediates() error = %v", err)
+	}
+
+	if len(plan.Groups) != 1 {
+		t.Fatalf("groups = %d, want 1", len(plan.Groups))
+	}
+	group := plan.Groups[0]
+	if group.Parent != base || len(group.Sequence) != 0 {
+		t.Errorf("group parent = %q sequence = %v, want %s and no layers", group.Parent, group.Sequence, base)
+	}
+	if len(group.Children) != 1 {
+		t.Fatalf("group children = %d, want 1", len(group.Children))
+	}
+	python := group.Children[0]
+	if !reflect.DeepEqual(python.PathLayers, []string{"pixi", "python"}) || python.Decision != planAuto || python.Intermediate != "fedora-python" || python.Parent != base {
+		t.Errorf("python node = %+v, want auto fedora-python for [pixi python] on %s", python, base)
+	}
+	if len(python.Children) != 2 {
+		t.Fatalf("python children = %d, want 2", len(python.Children))
+	}
+	notebook, supervisord := python.Children[0], python.Children[1]
+	if !reflect.DeepEqual(notebook.Images, []string{"notebook"}) || notebook.Decision != "" || notebook.Parent != "fedora-python" {
+		t.Errorf("jupyter node = %+v, want leaf with notebook on fedora-python", notebook)
+	}
+	if !reflect.DeepEqual(supervisord.Sequence, []string{"pixi", "python", "supervisord"}) || supervisord.Intermediate != "fedora-python-supervisord" {
+		t.Errorf("supervisord node = %+v, want fedora-python-supervisord at [pixi python supervisord]", supervisord)
+	}
+
+	wantImages := []PlanImage{
+		{Name: "notebook", BaseBefore: base, BaseAfter: "fedora-python"},
+		{Name: "openclaw", BaseBefore: base, BaseAfter: "fedora-python-supervisord"},
+		{Name: "test", BaseBefore: base, BaseAfter: "fedora-python-supervisord"},
+	}
+	if !reflect.DeepEqual(plan.Images, wantImages) {
+		t.Errorf("images = %+v, want %+v", plan.Images, wantImages)
+	}
+	if len(plan.Intermediates) != 2 || plan.Intermediates[0].Name != "fedora-python" || !plan.Intermediates[0].Auto {
+		t.Errorf("intermediates = %+v, want fedora-python and fedora-python-supervisord", plan.Intermediates)
+	}
+	for _, inter := range plan.Intermediates {
+		if inter.BaseGroup != base {
+			t.Errorf("intermediate %s base group = %q, want %s", inter.Name, inter.BaseGroup, base)
+		}
+	}
+
+	// The JSON keys tooling relies on
+	data, err := plan.JSON()
+	if err != nil {
+		t.Fatalf("JSON() error = %v", err)
+	}
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	for _, key := range []string{`"path_layers"`, `"parent"`, `"children"`, `"base_before"`, `"base_after"`} {
+		if !strings.Contains(string(data), key) {
+			t.Errorf("JSON missing %s", key)
+		}
+	}
+
+	text := plan.Text()
+	for _, want := range []string{
+		base + " (notebook, openclaw, test)\n",
+		"  pixi, python -> fedora-python [auto]\n",
+		"    jupyter: notebook\n",
+		"    supervisord -> fedora-python-supervisord [auto]\n",
+		"      openclaw: openclaw\n",
+		"  fedora-python-supervisord [auto] on fedora-python (" + base + "): supervisord\n",
+		"  test      " + base + " -> fedora-python-supervisord\n",
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("Text() missing %q in:\n%s", want, text)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// ProfileConfig is a named overlay in images.yml profiles, selected with --profile
+type ProfileConfig struct {
+	Defaults  ImageConfig             `yaml:"defaults,omitempty"`   // set fields replace the images.yml defaults
+	AddLayers []string                `yaml:"add_layers,omitempty"` // appended to every image
+	Images    map[string]ProfileImage `yaml:"images,omitempty"`     // per-image patches
+}
+
+// ProfileImage patches one image in a profile
+type ProfileImage struct {
+	Tag          string   `yaml:"tag,omitempty"`
+	Registry     string   `yaml:"registry,omitempty"`
+	AddLayers    []string `yaml:"add_layers,omitempty"`
+	RemoveLayers []string `yaml:"remove_layers,omitempty"`
+}
+
+// applyProfile applies the named profile to the defaults and images.
+// An empty name leaves the config unchanged.
+func (c *Config) applyProfile(name string) error {
+	if name == "" {
+		return nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		var names []string
+		for n := range c.Profiles {
+			names = append(names, n)
+		}
+		sortStrings(names)
+		if len(names) == 0 {
+			return fmt.Errorf("unknown profile %q (images.yml defines no profiles)", name)
+		}
+		return fmt.Errorf("unknown profile %q (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	dv := reflect.ValueOf(&c.Defaults).Elem()
+	pv := reflect.ValueOf(profile.Defaults)
+	for i := 0; i < pv.NumField(); i++ {
+		if !pv.Field(i).IsZero() {
+			dv.Field(i).Set(pv.Field(i))
+		}
+	}
+
+	for imageName, img := range c.Images {
+		img.Layers = appendMissing(img.Layers, profile.AddLayers)
+		c.Images[imageName] = img
+	}
+
+	var imageNames []string
+	for imageName := range profile.Images {
+		imageNames = append(imageNames, imageName)
+	}
+	sortStrings(imageNames)
+	for _, imageName := range imageNames {
+		patch := profile.Images[imageName]
+		img, ok := c.Images[imageName]
+		if !ok {
+			return fmt.Errorf("profile %q: image %q not found in images.yml", name, imageName)
+		}
+		if patch.Tag != "" {
+			img.Tag = patch.Tag
+		}
+		if patch.Registry != "" {
+			img.Registry = patch.Registry
+		}
+		img.Layers = appendMissing(img.Layers, patch.AddLayers)
+		if len(patch.RemoveLayers) > 0 {
+			remove := make(map[string]bool)
+			for _, l := range patch.RemoveLayers {
+				remove[l] = true
+			}
+			var kept []string
+			for _, l := range img.Layers {
+				if !remove[l] {
+					kept = append(kept, l)
+				}
+			}
+			img.Layers = kept
+		}
+		c.Images[imageName] = img
+	}
+	return nil
+}
+
+// appendMissing returns list followed by the entries of add it doesn't contain yet
+func appendMissing(list, add []string) []string {
+	if len(add) == 0 {
+		return list
+	}
+	result := append([]string{}, list...)
+	for _, a := range add {
+		found := false
+		for _, l := range result {
+			if l == a {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, a)
+		}
+	}
+	return result
+}
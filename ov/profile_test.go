@@ -0,0 +1,157 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const profileTestConfig = `defaults:
+  registry: ghcr.io/overthinkos
+  merge:
+    auto: true
+    max_mb: 128
+images:
+  fedora:
+    base: quay.io/fedora/fedora:43
+    layers: [tool]
+  app:
+    base: fedora
+    layers: [tool, svc, extra]
+  web:
+    base: fedora
+    layers: [tool, svc]
+profiles:
+  dev:
+    defaults:
+      registry: localhost:5000
+      merge:
+        auto: false
+    add_layers: [debug]
+    images:
+      app:
+        tag: dev
+        remove_layers: [extra]
+  ci:
+    images:
+      web:
+        registry: ghcr.io/ci
+        add_layers: [extra]
+`
+
+func TestLoadConfigProfile(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"images.yml": profileTestConfig})
+	cfg, err := LoadConfigWith(dir, ConfigOptions{Profile: "dev"})
+	if err != nil {
+		t.Fatalf("LoadConfigWith() error = %v", err)
+	}
+
+	if cfg.Defaults.Registry != "localhost:5000" {
+		t.Errorf("Defaults.Registry = %q, want localhost:5000", cfg.Defaults.Registry)
+	}
+	if cfg.Defaults.Merge == nil || cfg.Defaults.Merge.Auto {
+		t.Errorf("Defaults.Merge = %+v, want auto: false", cfg.Defaults.Merge)
+	}
+
+	tests := []struct {
+		image  string
+		layers []string
+		tag    string
+	}{
+		{"fedora", []string{"tool", "debug"}, ""},
+		{"app", []string{"tool", "svc", "debug"}, "dev"},
+		{"web", []string{"tool", "svc", "debug"}, ""},
+	}
+	for _, tt := range tests {
+		img := cfg.Images[tt.image]
+		if !reflect.DeepEqual(img.Layers, tt.layers) {
+			t.Errorf("%s layers = %v, want %v", tt.image, img.Layers, tt.layers)
+		}
+		if img.Tag != tt.tag {
+			t.Errorf("%s tag = %q, want %q", tt.image, img.Tag, tt.tag)
+		}
+	}
+
+	cfg, err = LoadConfigWith(dir, ConfigOptions{Profile: "ci"})
+	if err != nil {
+		t.Fatalf("LoadConfigWith(ci) error = %v", err)
+	}
+	if got := cfg.Images["web"]; got.Registry != "ghcr.io/ci" || !reflect.DeepEqual(got.Layers, []string{"tool", "svc", "extra"}) {
+		t.Errorf("ci web = %+v, want registry ghcr.io/ci and extra layer", got)
+	}
+	if cfg.Defaults.Registry != "ghcr.io/overthinkos" {
+		t.Errorf("ci Defaults.Registry = %q, want unchanged", cfg.Defaults.Registry)
+	}
+}
+
+func TestLoadConfigProfileIntermediates(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"images.yml": profileTestConfig})
+	cfg, err := LoadConfigWith(dir, ConfigOptions{Profile: "dev"})
+	if err != nil {
+		t.Fatalf("LoadConfigWith() error = %v", err)
+	}
+	layers := map[string]*Layer{
+		"tool":  {Name: "tool", HasRootYml: true},
+		"svc":   {Name: "svc", HasRootYml: true},
+		"extra": {Name: "extra", HasRootYml: true},
+		"debug": {Name: "debug", HasRootYml: true},
+	}
+	images, err := cfg.ResolveAllImages("v1")
+	if err != nil {
+		t.Fatalf("ResolveAllImages() error = %v", err)
+	}
+	result, err := ComputeIntermediates(images, layers, cfg, "v1")
+	if err != nil {
+		t.Fatalf("ComputeIntermediates() error = %v", err)
+	}
+
+	// fedora installs debug itself, so app and web only add svc on top
+	for _, name := range []string{"app", "web"} {
+		provided, err := LayersProvidedByImage(name, result, layers)
+		if err != nil {
+			t.Fatalf("LayersProvidedByImage(%s) error = %v", name, err)
+		}
+		if !provided["debug"] {
+			t.Errorf("%s should get the profile's debug layer", name)
+		}
+	}
+	if got := result["app"].FullTag; got != "localhost:5000/app:dev" {
+		t.Errorf("app FullTag = %q, want localhost:5000/app:dev", got)
+	}
+}
+
+func TestLoadConfigProfileUnknown(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{"images.yml": profileTestConfig})
+	_, err := LoadConfigWith(dir, ConfigOptions{Profile: "prod"})
+	if err == nil {
+		t.Fatal("LoadConfigWith() expected error for unknown profile")
+	}
+	if want := `unknown profile "prod" (available: ci, dev)`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestLoadConfigNoProfileUnchanged(t *testing.T) {
+	data, err := os.ReadFile("testdata/images.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := LoadConfig("testdata")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+
+	withProfiles := string(data) + "\nprofiles:\n  dev:\n    defaults:\n      registry: localhost:5000\n    add_layers: [debug]\n"
+	dir := writeConfigFiles(t, map[string]string{"images.yml": withProfiles})
+	got, err := LoadConfigWith(dir, ConfigOptions{})
+	if err != nil {
+		t.Fatalf("LoadConfigWith() error = %v", err)
+	}
+	if !reflect.DeepEqual(got.Defaults, want.Defaults) {
+		t.Errorf("Defaults = %+v, want %+v", got.Defaults, want.Defaults)
+	}
+	if !reflect.DeepEqual(got.Images, want.Images) {
+		t.Errorf("Images differ without a selected profile")
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PruneCmd removes old tags of the project's images from the run engine's store
+type PruneCmd struct {
+	Keep      int    `long:"keep" default:"3" help:"Tags to keep per image, newest first (:latest is always kept)"`
+	OlderThan string `long:"older-than" help:"Only remove tags older than this (e.g. 30d, 12h)"`
+	DryRun    bool   `long:"dry-run" help:"Print what would be removed, with sizes, without removing it"`
+}
+
+// LocalImage is a tag in an engine's local store
+type LocalImage struct {
+	Ref     string // repository:tag
+	ID      string
+	Created time.Time
+	Size    int64
+	Name    string // org.overthink.image label, "" for images not built by ov
+}
+
+// ListLocalImages lists the tags of the engine's local store with the
+// image each points to. Package-level var for testability.
+var ListLocalImages = defaultListLocalImages
+
+func defaultListLocalImages(engine string) ([]LocalImage, error) {
+	out, err := engineCmd(engine, "images", "--format", "{{.Repository}}:{{.Tag}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s images: %w", EngineBinary(engine), err)
+	}
+	var refs []string
+	for _, ref := range strings.Fields(string(out)) {
+		if !strings.Contains(ref, "<none>") {
+			refs = append(refs, ref)
+		}
+	}
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	format := fmt.Sprintf("{{.Id}}\t{{.Created}}\t{{.Size}}\t{{index .Config.Labels %q}}", LabelImage)
+	args := append([]string{"image", "inspect", "--format", format}, refs...)
+	out, err = engineCmd(engine, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s image inspect: %w", EngineBinary(engine), err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	if len(lines) != len(refs) {
+		return nil, fmt.Errorf("%s image inspect: got %d images for %d tags", EngineBinary(engine), len(lines), len(refs))
+	}
+
+	images := make([]LocalImage, 0, len(refs))
+	for i, line := range lines {
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("%s image inspect: unexpected output %q", EngineBinary(engine), line)
+		}
+		size, _ := strconv.ParseInt(fields[2], 10, 64)
+		name := fields[3]
+		if name == "<no value>" {
+			name = ""
+		}
+		images = append(images, LocalImage{
+			Ref:     refs[i],
+			ID:      fields[0],
+			Created: parseEngineTime(fields[1]),
+			Size:    size,
+			Name:    name,
+		})
+	}
+	return images, nil
+}
+
+// parseEngineTime parses the creation time of docker (RFC 3339) or podman
+// (Go's time.Time format), returning the zero time if it's neither
+func parseEngineTime(value string) time.Time {
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999 -0700 MST"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// RemoveImage removes the tag ref from the engine's local store, and the
+// image with its last tag. Package-level var for testability.
+var RemoveImage = func(engine, ref string) error {
+	out, err := engineCmd(engine, "rmi", ref).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s rmi %s: %w: %s", EngineBinary(engine), ref, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// parseAge parses an --older-than age: a number of days ("30d") or a Go duration ("12h")
+func parseAge(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid age %q (want e.g. 30d or 12h)", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid age %q (want e.g. 30d or 12h)", value)
+	}
+	return d, nil
+}
+
+// splitImageRef splits an image reference into repository and tag
+func splitImageRef(ref string) (string, string) {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i], ref[i+1:]
+	}
+	return ref, ""
+}
+
+// pruneSelection are the tags ov prune removes
+type pruneSelection struct {
+	Remove []LocalImage
+	Freed  map[string]bool // IDs of images that lose their last tag
+}
+
+// selectPrune picks the tags to remove: per image name, all but the newest
+// keep tags, sparing :latest, protected refs and, with olderThan, tags
+// created within olderThan of now. An image's name is its org.overthink.image
+// label, else the project image whose repository it's in (repos maps
+// repository to image name); other images are never touched.
+func selectPrune(images []LocalImage, repos map[string]string, protected map[string]bool, keep int, olderThan time.Duration, now time.Time) pruneSelection {
+	groups := make(map[string][]LocalImage)
+	for _, img := range images {
+		repo, tag := splitImageRef(img.Ref)
+		if tag == "" || tag == "latest" {
+			continue
+		}
+		name := img.Name
+		if name == "" {
+			name = repos[repo]
+		}
+		if name == "" {
+			continue
+		}
+		groups[name] = append(groups[name], img)
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	sel := pruneSelection{Freed: make(map[string]bool)}
+	removed := make(map[string]bool)
+	for _, name := range names {
+		group := groups[name]
+		sort.SliceStable(group, func(i, j int) bool {
+			if !group[i].Created.Equal(group[j].Created) {
+				return group[i].Created.After(group[j].Created)
+			}
+			return group[i].Ref > group[j].Ref
+		})
+		for i, img := range group {
+			if i < keep || protected[img.Ref] {
+				continue
+			}
+			if olderThan > 0 && now.Sub(img.Created) < olderThan {
+				continue
+			}
+			sel.Remove = append(sel.Remove, img)
+			removed[img.Ref] = true
+		}
+	}
+
+	// An image is freed when none of its tags (ov's or not) remain
+	kept := make(map[string]bool)
+	for _, img := range images {
+		if !removed[img.Ref] {
+			kept[img.ID] = true
+		}
+	}
+	for _, img := range sel.Remove {
+		if !kept[img.ID] {
+			sel.Freed[img.ID] = true
+		}
+	}
+	return sel
+}
+
+func (c *PruneCmd) Run() error {
+	if c.Keep < 0 {
+		return fmt.Errorf("--keep must be at least 0, got %d", c.Keep)
+	}
+	var olderThan time.Duration
+	if c.OlderThan != "" {
+		var err error
+		if olderThan, err = parseAge(c.OlderThan); err != nil {
+			return fmt.Errorf("--older-than: %w", err)
+		}
+	}
+
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+
+	// Without images.yml only images carrying ov's labels are pruned
+	repos := make(map[string]string)
+	protected := make(map[string]bool)
+	dir, _ := os.Getwd()
+	if cfg, err := LoadConfig(dir); err == nil {
+		images, err := cfg.ResolveAllImages(MetadataTag)
+		if err != nil {
+			return err
+		}
+		for name, img := range images {
+			repo, _ := splitImageRef(img.FullTag)
+			repos[repo] = name
+			protected[img.FullTag] = true // pinned tags
+			if img.IsExternalBase {
+				protected[img.Base] = true
+			}
+		}
+	}
+
+	images, err := ListLocalImages(rt.RunEngine)
+	if err != nil {
+		return err
+	}
+	sel := selectPrune(images, repos, protected, c.Keep, olderThan, time.Now())
+	return applyPrune(os.Stdout, rt.RunEngine, sel, c.DryRun)
+}
+
+// applyPrune removes the selected tags, or with dryRun only prints them,
+// with the size of the images they free
+func applyPrune(out io.Writer, engine string, sel pruneSelection, dryRun bool) error {
+	if len(sel.Remove) == 0 {
+		fmt.Fprintln(out, "Nothing to prune")
+		return nil
+	}
+
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	var freed int64
+	removed, failed := 0, 0
+	counted := make(map[string]bool)
+	for _, img := range sel.Remove {
+		if !dryRun {
+			if err := RemoveImage(engine, img.Ref); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+				failed++
+				continue
+			}
+		}
+		removed++
+
+		size := "untag only"
+		if sel.Freed[img.ID] {
+			size = formatSize(img.Size)
+			if !counted[img.ID] {
+				counted[img.ID] = true
+				freed += img.Size
+			}
+		}
+		fmt.Fprintf(out, "%s %s (%s, %s)\n", verb, img.Ref, img.Created.Local().Format("2006-01-02"), size)
+	}
+
+	fmt.Fprintf(out, "%s %d tag(s), freeing %s\n", verb, removed, formatSize(freed))
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d tag(s)", failed)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAge(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30d", 30 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"0d", 0, false},
+		{"d", 0, true},
+		{"-1d", 0, true},
+		{"month", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseAge(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseAge(%q) = %v, %v; want %v (error %v)", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSplitImageRef(t *testing.T) {
+	tests := []struct {
+		ref, repo, tag string
+	}{
+		{"ghcr.io/x/app:2026.280.1015", "ghcr.io/x/app", "2026.280.1015"},
+		{"localhost:5000/app:1", "localhost:5000/app", "1"},
+		{"localhost:5000/app", "localhost:5000/app", ""},
+		{"app", "app", ""},
+	}
+	for _, tt := range tests {
+		if repo, tag := splitImageRef(tt.ref); repo != tt.repo || tag != tt.tag {
+			t.Errorf("splitImageRef(%q) = %q, %q; want %q, %q", tt.ref, repo, tag, tt.repo, tt.tag)
+		}
+	}
+}
+
+// pruneImages is a local store with four CalVer tags of app (one sharing
+// its image with :latest), two of a labeled intermediate and a foreign image
+func pruneImages(now time.Time) []LocalImage {
+	day := 24 * time.Hour
+	return []LocalImage{
+		{Ref: "ghcr.io/x/app:latest", ID: "a4", Created: now.Add(-1 * day), Size: 400},
+		{Ref: "ghcr.io/x/app:2026.280.1015", ID: "a4", Created: now.Add(-1 * day), Size: 400},
+		{Ref: "ghcr.io/x/app:2026.270.1200", ID: "a3", Created: now.Add(-10 * day), Size: 300},
+		{Ref: "ghcr.io/x/app:2026.250.1200", ID: "a2", Created: now.Add(-40 * day), Size: 200},
+		{Ref: "ghcr.io/x/app:2026.240.1200", ID: "a1", Created: now.Add(-50 * day), Size: 100},
+		{Ref: "ghcr.io/x/fedora-ov:2026.270.1200", ID: "f2", Created: now.Add(-10 * day), Size: 20, Name: "fedora-ov"},
+		{Ref: "ghcr.io/x/fedora-ov:2026.240.1200", ID: "f1", Created: now.Add(-50 * day), Size: 10, Name: "fedora-ov"},
+		{Ref: "quay.io/fedora/fedora:42", ID: "q1", Created: now.Add(-90 * day), Size: 1000},
+	}
+}
+
+func pruneRefs(sel pruneSelection) []string {
+	var refs []string
+	for _, img := range sel.Remove {
+		refs = append(refs, img.Ref)
+	}
+	return refs
+}
+
+func TestSelectPrune(t *testing.T) {
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	repos := map[string]string{"ghcr.io/x/app": "app"}
+
+	tests := []struct {
+		name      string
+		keep      int
+		olderThan time.Duration
+		protected map[string]bool
+		want      []string
+	}{
+		{"keep newest", 2, 0, nil, []string{"ghcr.io/x/app:2026.250.1200", "ghcr.io/x/app:2026.240.1200"}},
+		{"keep none spares latest", 0, 0, nil, []string{
+			"ghcr.io/x/app:2026.280.1015", "ghcr.io/x/app:2026.270.1200", "ghcr.io/x/app:2026.250.1200", "ghcr.io/x/app:2026.240.1200",
+			"ghcr.io/x/fedora-ov:2026.270.1200", "ghcr.io/x/fedora-ov:2026.240.1200",
+		}},
+		{"older than", 1, 45 * 24 * time.Hour, nil, []string{"ghcr.io/x/app:2026.240.1200", "ghcr.io/x/fedora-ov:2026.240.1200"}},
+		{"protected base", 1, 0, map[string]bool{"ghcr.io/x/fedora-ov:2026.240.1200": true}, []string{
+			"ghcr.io/x/app:2026.270.1200", "ghcr.io/x/app:2026.250.1200", "ghcr.io/x/app:2026.240.1200",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sel := selectPrune(pruneImages(now), repos, tt.protected, tt.keep, tt.olderThan, now)
+			if got := pruneRefs(sel); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("selectPrune() removes %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// The tag sharing its image with :latest frees nothing
+	sel := selectPrune(pruneImages(now), repos, nil, 0, 0, now)
+	if sel.Freed["a4"] || !sel.Freed["a3"] || sel.Freed["q1"] {
+		t.Errorf("Freed = %v, want a1-a3 and f1-f2 only", sel.Freed)
+	}
+}
+
+func TestApplyPrune(t *testing.T) {
+	orig := RemoveImage
+	defer func() { RemoveImage = orig }()
+	var removed []string
+	RemoveImage = func(engine, ref string) error {
+		if strings.HasSuffix(ref, ":busy") {
+			return errors.New("image is in use by a container")
+		}
+		removed = append(removed, engine+" "+ref)
+		return nil
+	}
+
+	created := time.Date(2026, 9, 1, 12, 0, 0, 0, time.UTC)
+	sel := pruneSelection{
+		Remove: []LocalImage{
+			{Ref: "app:1", ID: "a1", Created: created, Size: 2 * 1024 * 1024 * 1024},
+			{Ref: "app:2", ID: "a2", Created: created, Size: 1024},
+		},
+		Freed: map[string]bool{"a1": true},
+	}
+
+	var out bytes.Buffer
+	if err := applyPrune(&out, "podman", sel, true); err != nil {
+		t.Fatalf("applyPrune(dry run) error = %v", err)
+	}
+	if removed != nil {
+		t.Errorf("dry run removed %v", removed)
+	}
+	for _, want := range []string{"Would remove app:1 (2026-09-01, 2.0 GB)", "Would remove app:2 (2026-09-01, untag only)", "Would remove 2 tag(s), freeing 2.0 GB"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry run output doesn't contain %q:\n%s", want, out.String())
+		}
+	}
+
+	out.Reset()
+	if err := applyPrune(&out, "podman", sel, false); err != nil {
+		t.Fatalf("applyPrune() error = %v", err)
+	}
+	if !reflect.DeepEqual(removed, []string{"podman app:1", "podman app:2"}) {
+		t.Errorf("removed %v, want both tags from podman", removed)
+	}
+
+	sel.Remove = append(sel.Remove, LocalImage{Ref: "app:busy", ID: "a3"})
+	out.Reset()
+	if err := applyPrune(&out, "podman", sel, false); err == nil || !strings.Contains(err.Error(), "1 tag") {
+		t.Errorf("applyPrune() error = %v, want the failed removal counted", err)
+	}
+}
@@ -73,6 +73,13 @@ func ImageExists(ref string) (bool, error) {
 	return true, nil
 }
 
+// HeadImage checks with a HEAD request that the image ref exists in its
+// registry. Package-level var for testability.
+var HeadImage = func(ref string) error {
+	_, err := crane.Head(ref)
+	return err
+}
+
 // contains is a simple string contains check
 func contains(s, substr string) bool {
 	for i := 0; i <= len(s)-len(substr); i++ {
@@ -93,7 +100,10 @@ type UserInfo struct {
 
 // InspectImageUser inspects a remote image for a user with the given UID
 // Returns the user info if found, or nil if not found
-func InspectImageUser(ref string, uid int) (*UserInfo, error) {
+// Package-level var for testability.
+var InspectImageUser = defaultInspectImageUser
+
+func defaultInspectImageUser(ref string, uid int) (*UserInfo, error) {
 	// Parse reference
 	imgRef, err := name.ParseReference(ref)
 	if err != nil {
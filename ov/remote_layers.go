@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RemoteLayer is a layer fetched from a git repository (images.yml remote_layers)
+type RemoteLayer struct {
+	URL  string `yaml:"url"`
+	Ref  string `yaml:"ref,omitempty"`  // branch, tag or commit (default: the remote's HEAD)
+	Path string `yaml:"path,omitempty"` // layer directory inside the repository (default: its root)
+}
+
+// remoteLayerLock pins a remote layer to the commit it was fetched at
+type remoteLayerLock struct {
+	RemoteLayer `yaml:",inline"`
+	Commit      string `yaml:"commit"`
+}
+
+// remoteLayersLockFile records the resolved commit of every remote layer, next
+// to images.yml, so that checkouts are reproducible until ov update-layers
+const remoteLayersLockFile = "remote-layers.lock"
+
+// remoteLayersDir is where remote layer checkouts are cached, below the project:
+// the work tree in <name>/, its git directory in <name>.git/ (kept out of the
+// work tree, which is copied into the image)
+const remoteLayersDir = ".build/remote-layers"
+
+// SyncRemoteLayers makes sure every remote layer of images.yml is checked out
+// in .build/remote-layers/<name> at its locked commit and returns the layer
+// directory of each. Cached checkouts are used as they are, so offline runs
+// work once a layer has been fetched. Layers without a lock entry (or whose
+// url, ref or path changed) are fetched and locked; update refetches all refs.
+func SyncRemoteLayers(dir string, update bool) (map[string]string, error) {
+	specs, err := loadRemoteLayerSpecs(dir)
+	if err != nil {
+		return nil, err
+	}
+	lock, err := readRemoteLayersLock(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	layerDirs := make(map[string]string)
+	newLock := make(map[string]remoteLayerLock)
+	var names []string
+	for name := range specs {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for _, name := range names {
+		spec := specs[name]
+		if spec.URL == "" {
+			return nil, fmt.Errorf("remote_layers.%s: url is required", name)
+		}
+		checkout := filepath.Join(dir, remoteLayersDir, name)
+
+		locked, ok := lock[name]
+		if update || !ok || locked.RemoteLayer != spec || locked.Commit == "" {
+			ref := spec.Ref
+			if ref == "" {
+				ref = "HEAD"
+			}
+			fmt.Fprintf(os.Stderr, "Fetching remote layer %s (%s %s)\n", name, spec.URL, ref)
+			commit, err := gitFetchCheckout(checkout, spec.URL, ref)
+			if err != nil {
+				return nil, fmt.Errorf("remote layer %s: fetching %s %s: %w", name, spec.URL, ref, err)
+			}
+			locked = remoteLayerLock{RemoteLayer: spec, Commit: commit}
+		} else if head, err := gitHead(checkout); err != nil || head != locked.Commit {
+			fmt.Fprintf(os.Stderr, "Fetching remote layer %s (%s %s)\n", name, spec.URL, locked.Commit)
+			if _, err := gitFetchCheckout(checkout, spec.URL, locked.Commit); err != nil {
+				return nil, fmt.Errorf("remote layer %s: commit %s is not cached in %s and fetching it from %s failed: %w", name, locked.Commit, path.Join(remoteLayersDir, name), spec.URL, err)
+			}
+		}
+		newLock[name] = locked
+
+		layerDir := filepath.Join(checkout, filepath.FromSlash(spec.Path))
+		if !dirExists(layerDir) {
+			return nil, fmt.Errorf("remote layer %s: %s has no directory %q", name, spec.URL, spec.Path)
+		}
+		layerDirs[name] = layerDir
+	}
+
+	if !remoteLayerLocksEqual(lock, newLock) {
+		if err := writeRemoteLayersLock(dir, newLock); err != nil {
+			return nil, err
+		}
+	}
+	return layerDirs, nil
+}
+
+// loadRemoteLayerSpecs reads the remote_layers section of images.yml. It is
+// top-level only (included fragments can't declare remote layers) and read
+// directly, since ScanLayers runs without a loaded config.
+func loadRemoteLayerSpecs(dir string) (map[string]RemoteLayer, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "images.yml"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("reading images.yml: %w", err)
+	}
+	var doc struct {
+		RemoteLayers map[string]RemoteLayer `yaml:"remote_layers"`
+	}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("parsing images.yml: %w", err)
+	}
+	return doc.RemoteLayers, nil
+}
+
+// readRemoteLayersLock reads remote-layers.lock (empty when missing)
+func readRemoteLayersLock(dir string) (map[string]remoteLayerLock, error) {
+	lock := make(map[string]remoteLayerLock)
+	data, err := os.ReadFile(filepath.Join(dir, remoteLayersLockFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return lock, nil
+		}
+		return nil, fmt.Errorf("reading %s: %w", remoteLayersLockFile, err)
+	}
+	if err := yaml.Unmarshal(data, &lock); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", remoteLayersLockFile, err)
+	}
+	return lock, nil
+}
+
+// writeRemoteLayersLock writes remote-layers.lock, or removes it when there
+// are no remote layers left
+func writeRemoteLayersLock(dir string, lock map[string]remoteLayerLock) error {
+	lockPath := filepath.Join(dir, remoteLayersLockFile)
+	if len(lock) == 0 {
+		if err := os.Remove(lockPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	data, err := yaml.Marshal(lock)
+	if err != nil {
+		return err
+	}
+	header := "# remote-layers.lock (generated by ov -- refresh with ov update-layers)\n"
+	return os.WriteFile(lockPath, append([]byte(header), data...), 0644)
+}
+
+// remoteLayerLocksEqual reports whether two lock maps have the same entries
+func remoteLayerLocksEqual(a, b map[string]remoteLayerLock) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, entry := range a {
+		if other, ok := b[name]; !ok || other != entry {
+			return false
+		}
+	}
+	return true
+}
+
+// gitFetchCheckout shallow-fetches ref (a branch, tag, commit or HEAD) from url
+// into the work tree checkout (git directory checkout.git), creating it if
+// needed, checks it out and returns the resolved commit.
+func gitFetchCheckout(checkout, url, ref string) (string, error) {
+	if !dirExists(checkout + ".git") {
+		if err := os.MkdirAll(checkout, 0755); err != nil {
+			return "", err
+		}
+		if err := runGit(checkout, "init", "-q"); err != nil {
+			return "", err
+		}
+	}
+	if err := runGit(checkout, "fetch", "-q", "--depth", "1", url, ref); err != nil {
+		return "", err
+	}
+	if err := runGit(checkout, "checkout", "-q", "--force", "--detach", "FETCH_HEAD"); err != nil {
+		return "", err
+	}
+	return gitHead(checkout)
+}
+
+// gitHead returns the commit checked out in the work tree checkout
+func gitHead(checkout string) (string, error) {
+	out, err := exec.Command("git", "--git-dir", checkout+".git", "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// runGit runs a git command on the work tree checkout, returning its output as the error
+func runGit(checkout string, args ...string) error {
+	cmd := exec.Command("git", append([]string{"--git-dir", checkout + ".git", "--work-tree", checkout}, args...)...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// gitCommitAll commits the work tree of the repository at dir
+func gitCommitAll(t *testing.T, dir, msg string) {
+	t.Helper()
+	for _, args := range [][]string{
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", msg},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", dir}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", args[0], err, out)
+		}
+	}
+}
+
+func TestScanLayersRemote(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+
+	// Shared repository with the layer in a subdirectory
+	src := writeConfigFiles(t, map[string]string{
+		"shared/pixi/layer.yml": "description: shared pixi\n",
+		"shared/pixi/root.yml":  "version: '3'\n",
+		"shared/local/root.yml": "version: '3'\n",
+	})
+	if out, err := exec.Command("git", "init", "-q", src).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	gitCommitAll(t, src, "first")
+
+	dir := writeConfigFiles(t, map[string]string{
+		"layers/local/root.yml": "version: '3'\n",
+		"images.yml": `remote_layers:
+  pixi:
+    url: ` + src + `
+    path: shared/pixi
+  local:
+    url: ` + src + `
+    path: shared/local
+images: {}
+`,
+	})
+
+	layers, err := ScanLayers(dir)
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+	pixi, ok := layers["pixi"]
+	if !ok {
+		t.Fatalf("remote layer pixi missing, got %v", LayerNames(layers))
+	}
+	if pixi.Description != "shared pixi" || !pixi.HasRootYml {
+		t.Errorf("pixi = %+v, want the remote layer.yml and root.yml", pixi)
+	}
+	if layers["local"].remoteDir != "" {
+		t.Errorf("local layer should win over the remote one of the same name")
+	}
+	g := &Generator{Layers: layers}
+	if got, want := g.layerContextDir("pixi"), ".build/remote-layers/pixi/shared/pixi"; got != want {
+		t.Errorf("layerContextDir(pixi) = %q, want %q", got, want)
+	}
+	if got := g.layerContextDir("local"); got != "layers/local" {
+		t.Errorf("layerContextDir(local) = %q, want layers/local", got)
+	}
+
+	lock, err := readRemoteLayersLock(dir)
+	if err != nil {
+		t.Fatalf("readRemoteLayersLock() error = %v", err)
+	}
+	first := lock["pixi"].Commit
+	if len(first) != 40 {
+		t.Fatalf("locked commit = %q, want a sha", first)
+	}
+
+	// New upstream commits don't change the locked checkout...
+	if err := os.WriteFile(filepath.Join(src, "shared/pixi/layer.yml"), []byte("description: shared pixi v2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	gitCommitAll(t, src, "second")
+	if layers, err = ScanLayers(dir); err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+	if layers["pixi"].Description != "shared pixi" {
+		t.Errorf("description = %q, want the locked version", layers["pixi"].Description)
+	}
+
+	// ...until ov update-layers
+	if _, err := SyncRemoteLayers(dir, true); err != nil {
+		t.Fatalf("SyncRemoteLayers(update) error = %v", err)
+	}
+	if layers, err = ScanLayers(dir); err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+	if layers["pixi"].Description != "shared pixi v2" {
+		t.Errorf("description = %q after update, want v2", layers["pixi"].Description)
+	}
+
+	// Offline: the cached checkout is enough
+	offline := src + ".offline"
+	if err := os.Rename(src, offline); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ScanLayers(dir); err != nil {
+		t.Fatalf("ScanLayers() offline with cache error = %v", err)
+	}
+
+	// Offline without a cache is an error naming the layer
+	if err := os.RemoveAll(filepath.Join(dir, ".build")); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ScanLayers(dir)
+	if err == nil || !strings.Contains(err.Error(), "remote layer local: commit") || !strings.Contains(err.Error(), "is not cached") {
+		t.Errorf("ScanLayers() offline without cache error = %v, want not cached error", err)
+	}
+}
+
+func TestGenerateKeepsRemoteLayers(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	orig := InspectImageUser
+	t.Cleanup(func() { InspectImageUser = orig })
+	InspectImageUser = func(ref string, uid int) (*UserInfo, error) { return nil, nil }
+
+	src := writeConfigFiles(t, map[string]string{
+		"shared/pixi/root.yml": "version: '3'\n",
+	})
+	if out, err := exec.Command("git", "init", "-q", src).CombinedOutput(); err != nil {
+		t.Fatalf("git init: %v\n%s", err, out)
+	}
+	gitCommitAll(t, src, "first")
+
+	dir := writeConfigFiles(t, map[string]string{
+		"layers/tool/root.yml": "version: '3'\n",
+		"images.yml": `remote_layers:
+  pixi:
+    url: ` + src + `
+    path: shared/pixi
+images:
+  app:
+    base: quay.io/fedora/fedora:43
+    layers: [tool, pixi]
+`,
+	})
+
+	g, err := NewGenerator(dir, "test", ConfigOptions{})
+	if err != nil {
+		t.Fatalf("NewGenerator() error = %v", err)
+	}
+	if err := g.Generate(); err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// The checkout the Containerfile copies from survives the stale dir cleanup
+	if _, err := os.Stat(filepath.Join(dir, remoteLayersDir, "pixi", "shared/pixi/root.yml")); err != nil {
+		t.Errorf("remote layer checkout removed by generate: %v", err)
+	}
+	if want := "COPY .build/remote-layers/pixi/shared/pixi/ /"; !strings.Contains(g.Containerfiles["app"], want) {
+		t.Errorf("Containerfile missing %q:\n%s", want, g.Containerfiles["app"])
+	}
+}
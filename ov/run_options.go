@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RunOptions are the container options of ov shell and ov up that go to the
+// engine's run command: the network, a read-only root filesystem and resource
+// limits. Zero values leave the engine's defaults.
+type RunOptions struct {
+	Network  string  // host, none, bridge, slirp4netns, pasta, container:<name> or a network name
+	ReadOnly bool    // read-only root filesystem, with tmpfs on /tmp and /run
+	Memory   string  // memory limit, e.g. 512m or 2g
+	CPUs     float64 // CPU limit, e.g. 1.5
+	ShmSize  string  // size of /dev/shm, e.g. 2g
+}
+
+// RunFlags provides the run option flags via Kong.
+// Embed in command structs that run containers.
+type RunFlags struct {
+	Network  string  `long:"network" help:"Network of the container (host, none, bridge, slirp4netns, pasta, container:NAME or a network name)"`
+	ReadOnly bool    `name:"read-only" help:"Mount the root filesystem read-only (tmpfs on /tmp and /run)"`
+	Memory   string  `long:"memory" help:"Memory limit (e.g. 512m, 2g)"`
+	CPUs     float64 `name:"cpus" help:"CPU limit (e.g. 1.5)"`
+	ShmSize  string  `name:"shm-size" help:"Size of /dev/shm (e.g. 2g)"`
+}
+
+// Options returns the run options of the flags on top of the image's shell
+// defaults (nil for none). A flag replaces the default of its option.
+func (f RunFlags) Options(defaults *ShellConfig) RunOptions {
+	var o RunOptions
+	if defaults != nil {
+		o = RunOptions{
+			Network:  defaults.Network,
+			ReadOnly: defaults.ReadOnly,
+			Memory:   defaults.Memory,
+			CPUs:     defaults.CPUs,
+			ShmSize:  defaults.ShmSize,
+		}
+	}
+	if f.Network != "" {
+		o.Network = f.Network
+	}
+	if f.ReadOnly {
+		o.ReadOnly = true
+	}
+	if f.Memory != "" {
+		o.Memory = f.Memory
+	}
+	if f.CPUs != 0 {
+		o.CPUs = f.CPUs
+	}
+	if f.ShmSize != "" {
+		o.ShmSize = f.ShmSize
+	}
+	return o
+}
+
+// PublishesPorts reports whether published ports reach the container on its
+// network. With host, none or another container's network they don't.
+func (o RunOptions) PublishesPorts() bool {
+	return o.Network != "host" && o.Network != "none" && !strings.HasPrefix(o.Network, "container:")
+}
+
+// sizeRe matches memory sizes of docker and podman: a number with an optional
+// b, k, m, g or t unit (kb, mb, ... too)
+var sizeRe = regexp.MustCompile(`^[0-9]+([kKmMgGtT][bB]?|[bB])?$`)
+
+// networkRe matches --network values, including podman's mode options like
+// slirp4netns:port_handler=slirp4netns
+var networkRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.:,=/-]*$`)
+
+// podmanNetworks are network modes only podman has
+var podmanNetworks = []string{"slirp4netns", "pasta"}
+
+// checkRunOptions reports the first invalid option of o for engine
+func checkRunOptions(engine string, o RunOptions) error {
+	if o.Network != "" {
+		if !networkRe.MatchString(o.Network) {
+			return fmt.Errorf("invalid network %q", o.Network)
+		}
+		mode, _, _ := strings.Cut(o.Network, ":")
+		for _, n := range podmanNetworks {
+			if mode == n && engine != "podman" {
+				return fmt.Errorf("network %s is only available with podman", n)
+			}
+		}
+	}
+	if o.Memory != "" && !sizeRe.MatchString(o.Memory) {
+		return fmt.Errorf("invalid memory limit %q: want a size like 512m or 2g", o.Memory)
+	}
+	if o.ShmSize != "" && !sizeRe.MatchString(o.ShmSize) {
+		return fmt.Errorf("invalid shm size %q: want a size like 512m or 2g", o.ShmSize)
+	}
+	if o.CPUs < 0 {
+		return fmt.Errorf("invalid CPU limit %s: must be positive", strconv.FormatFloat(o.CPUs, 'f', -1, 64))
+	}
+	return nil
+}
+
+// runOptionArgs returns the run arguments of o for engine. published says
+// whether ports are published: podman's slirp4netns then forwards them with
+// its own port handler, which keeps the clients' source addresses (the
+// default rootlesskit handler rewrites them). A read-only root filesystem
+// gets tmpfs on /tmp and /run, which podman mounts by itself
+// (--read-only-tmpfs) and docker needs explicitly.
+func runOptionArgs(engine string, o RunOptions, published bool) ([]string, error) {
+	if err := checkRunOptions(engine, o); err != nil {
+		return nil, err
+	}
+	var args []string
+	if o.Network != "" {
+		network := o.Network
+		if engine == "podman" && network == "slirp4netns" && published {
+			network = "slirp4netns:port_handler=slirp4netns"
+		}
+		args = append(args, "--network", network)
+	}
+	if o.ReadOnly {
+		args = append(args, "--read-only")
+		if engine != "podman" {
+			args = append(args, "--tmpfs", "/tmp", "--tmpfs", "/run")
+		}
+	}
+	if o.Memory != "" {
+		args = append(args, "--memory", o.Memory)
+	}
+	if o.CPUs > 0 {
+		args = append(args, "--cpus", strconv.FormatFloat(o.CPUs, 'f', -1, 64))
+	}
+	if o.ShmSize != "" {
+		args = append(args, "--shm-size", o.ShmSize)
+	}
+	return args, nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alecthomas/kong"
+)
+
+func TestRunOptionArgs(t *testing.T) {
+	tests := []struct {
+		name      string
+		engine    string
+		opts      RunOptions
+		published bool
+		want      []string
+		wantErr   bool
+	}{
+		{"none", "docker", RunOptions{}, true, nil, false},
+		{"host network", "docker", RunOptions{Network: "host"}, false, []string{"--network", "host"}, false},
+		{"named network", "podman", RunOptions{Network: "devnet"}, true, []string{"--network", "devnet"}, false},
+		{"slirp4netns with ports", "podman", RunOptions{Network: "slirp4netns"}, true, []string{"--network", "slirp4netns:port_handler=slirp4netns"}, false},
+		{"slirp4netns without ports", "podman", RunOptions{Network: "slirp4netns"}, false, []string{"--network", "slirp4netns"}, false},
+		{"slirp4netns options kept", "podman", RunOptions{Network: "slirp4netns:allow_host_loopback=true"}, true, []string{"--network", "slirp4netns:allow_host_loopback=true"}, false},
+		{"slirp4netns on docker", "docker", RunOptions{Network: "slirp4netns"}, false, nil, true},
+		{"pasta on docker", "docker", RunOptions{Network: "pasta:-T,8080"}, false, nil, true},
+		{"bad network", "docker", RunOptions{Network: "-it"}, false, nil, true},
+		{"read-only docker", "docker", RunOptions{ReadOnly: true}, false, []string{"--read-only", "--tmpfs", "/tmp", "--tmpfs", "/run"}, false},
+		{"read-only podman", "podman", RunOptions{ReadOnly: true}, false, []string{"--read-only"}, false},
+		{"limits", "docker", RunOptions{Memory: "2g", CPUs: 1.5, ShmSize: "512mb"}, false, []string{"--memory", "2g", "--cpus", "1.5", "--shm-size", "512mb"}, false},
+		{"whole cpus", "podman", RunOptions{CPUs: 2}, false, []string{"--cpus", "2"}, false},
+		{"bad memory", "docker", RunOptions{Memory: "2 GiB"}, false, nil, true},
+		{"bad shm size", "docker", RunOptions{ShmSize: "-1"}, false, nil, true},
+		{"negative cpus", "docker", RunOptions{CPUs: -1}, false, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runOptionArgs(tt.engine, tt.opts, tt.published)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("runOptionArgs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runOptionArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunFlagsOptions(t *testing.T) {
+	defaults := &ShellConfig{Network: "devnet", ReadOnly: true, Memory: "1g", CPUs: 2}
+	got := RunFlags{Memory: "4g", ShmSize: "1g"}.Options(defaults)
+	want := RunOptions{Network: "devnet", ReadOnly: true, Memory: "4g", CPUs: 2, ShmSize: "1g"}
+	if got != want {
+		t.Errorf("Options() = %+v, want %+v", got, want)
+	}
+	if got := (RunFlags{Network: "host"}).Options(nil); got != (RunOptions{Network: "host"}) {
+		t.Errorf("Options(nil) = %+v", got)
+	}
+
+	for network, publishes := range map[string]bool{"": true, "bridge": true, "host": false, "none": false, "container:db": false} {
+		if got := (RunOptions{Network: network}).PublishesPorts(); got != publishes {
+			t.Errorf("PublishesPorts(%q) = %v, want %v", network, got, publishes)
+		}
+	}
+}
+
+func TestRunFlagsParse(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"up", "app", "--network", "host", "--read-only", "--memory", "2g", "--cpus", "0.5", "--shm-size", "1g"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := RunFlags{Network: "host", ReadOnly: true, Memory: "2g", CPUs: 0.5, ShmSize: "1g"}
+	if cli.Up.RunFlags != want {
+		t.Errorf("RunFlags = %+v, want %+v", cli.Up.RunFlags, want)
+	}
+}
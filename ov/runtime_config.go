@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
@@ -13,12 +14,18 @@ type RuntimeConfig struct {
 	Engine     EngineConfig `yaml:"engine"`
 	RunMode    string       `yaml:"run_mode,omitempty"`
 	AutoEnable *bool        `yaml:"auto_enable,omitempty"`
+	Transfer   string       `yaml:"transfer,omitempty"`
+
+	PullFallback string `yaml:"pull_fallback,omitempty"`
 }
 
 // EngineConfig specifies which container engine to use
 type EngineConfig struct {
 	Build string `yaml:"build,omitempty"`
 	Run   string `yaml:"run,omitempty"`
+
+	DockerHost string `yaml:"docker_host,omitempty"` // docker --host (unix://, tcp:// or ssh:// URL)
+	PodmanHost string `yaml:"podman_host,omitempty"` // podman --url (URL) or --connection (name)
 }
 
 // ResolvedRuntime holds the fully resolved runtime configuration
@@ -27,6 +34,15 @@ type ResolvedRuntime struct {
 	RunEngine   string // "docker" or "podman"
 	RunMode     string // "direct" or "quadlet"
 	AutoEnable  bool   // auto-enable quadlet on first start
+	Transfer    string // "auto", "pipe" or "skopeo"
+
+	// PullFallback is "true", "false" or "always" (see EnsureImage)
+	PullFallback string
+
+	// DockerHost and PodmanHost are the engines' endpoints, "" for the
+	// default local socket (see EngineEndpoints)
+	DockerHost string
+	PodmanHost string
 }
 
 // RuntimeConfigPath returns the path to the user's runtime config file.
@@ -94,6 +110,12 @@ func ResolveRuntime() (*ResolvedRuntime, error) {
 		RunEngine:   resolveValue(os.Getenv("OV_RUN_ENGINE"), cfg.Engine.Run, "docker"),
 		RunMode:     resolveValue(os.Getenv("OV_RUN_MODE"), cfg.RunMode, "direct"),
 		AutoEnable:  resolveAutoEnable(os.Getenv("OV_AUTO_ENABLE"), cfg.AutoEnable),
+		Transfer:    resolveValue(os.Getenv("OV_TRANSFER"), cfg.Transfer, TransferAuto),
+
+		PullFallback: resolveValue(os.Getenv("OV_PULL_FALLBACK"), cfg.PullFallback, PullFallbackTrue),
+
+		DockerHost: resolveValue(os.Getenv("DOCKER_HOST"), cfg.Engine.DockerHost, ""),
+		PodmanHost: resolveValue(os.Getenv(podmanHostEnv()), cfg.Engine.PodmanHost, ""),
 	}
 
 	if err := validateEngine(rt.BuildEngine, "engine.build"); err != nil {
@@ -105,6 +127,18 @@ func ResolveRuntime() (*ResolvedRuntime, error) {
 	if err := validateRunMode(rt.RunMode); err != nil {
 		return nil, err
 	}
+	if err := validateTransfer(rt.Transfer); err != nil {
+		return nil, err
+	}
+	if err := validatePullFallback(rt.PullFallback); err != nil {
+		return nil, err
+	}
+	if err := validateDockerHost(rt.DockerHost); err != nil {
+		return nil, err
+	}
+
+	// Every engine invocation targets the resolved endpoints
+	EngineEndpoints = map[string]string{"docker": rt.DockerHost, "podman": rt.PodmanHost}
 
 	if rt.RunMode == "quadlet" && rt.RunEngine != "podman" {
 		fmt.Fprintf(os.Stderr, "Warning: run_mode=quadlet requires podman; engine.run=%s\n", rt.RunEngine)
@@ -138,6 +172,36 @@ func validateRunMode(value string) error {
 	return nil
 }
 
+func validateTransfer(value string) error {
+	if value != TransferAuto && value != TransferPipe && value != TransferSkopeo {
+		return fmt.Errorf("transfer must be \"auto\", \"pipe\" or \"skopeo\", got %q", value)
+	}
+	return nil
+}
+
+func validatePullFallback(value string) error {
+	if value != PullFallbackTrue && value != PullFallbackFalse && value != PullFallbackAlways {
+		return fmt.Errorf("pull_fallback must be \"true\", \"false\" or \"always\", got %q", value)
+	}
+	return nil
+}
+
+// podmanHostEnv returns the environment variable podman takes its endpoint
+// from: CONTAINER_CONNECTION (a connection name) if set, else CONTAINER_HOST
+func podmanHostEnv() string {
+	if os.Getenv("CONTAINER_CONNECTION") != "" {
+		return "CONTAINER_CONNECTION"
+	}
+	return "CONTAINER_HOST"
+}
+
+func validateDockerHost(value string) error {
+	if value != "" && !strings.Contains(value, "://") {
+		return fmt.Errorf("engine.docker_host must be a URL such as ssh://host or tcp://host:2376, got %q", value)
+	}
+	return nil
+}
+
 func resolveAutoEnable(envVal string, cfgVal *bool) bool {
 	if envVal != "" {
 		return envVal == "true" || envVal == "1"
@@ -160,6 +224,10 @@ func GetConfigValue(key string) (string, error) {
 		return cfg.Engine.Build, nil
 	case "engine.run":
 		return cfg.Engine.Run, nil
+	case "engine.docker_host":
+		return cfg.Engine.DockerHost, nil
+	case "engine.podman_host":
+		return cfg.Engine.PodmanHost, nil
 	case "run_mode":
 		return cfg.RunMode, nil
 	case "auto_enable":
@@ -170,8 +238,12 @@ func GetConfigValue(key string) (string, error) {
 			return "false", nil
 		}
 		return "", nil
+	case "transfer":
+		return cfg.Transfer, nil
+	case "pull_fallback":
+		return cfg.PullFallback, nil
 	default:
-		return "", fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, run_mode, auto_enable)", key)
+		return "", fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, engine.docker_host, engine.podman_host, run_mode, auto_enable, transfer, pull_fallback)", key)
 	}
 }
 
@@ -183,6 +255,12 @@ func SetConfigValue(key, value string) error {
 		if err := validateEngine(value, key); err != nil {
 			return err
 		}
+	case "engine.docker_host":
+		if err := validateDockerHost(value); err != nil {
+			return err
+		}
+	case "engine.podman_host":
+		// A connection name or URL, checked by podman itself
 	case "run_mode":
 		if err := validateRunMode(value); err != nil {
 			return err
@@ -191,8 +269,16 @@ func SetConfigValue(key, value string) error {
 		if value != "true" && value != "false" {
 			return fmt.Errorf("auto_enable must be \"true\" or \"false\", got %q", value)
 		}
+	case "transfer":
+		if err := validateTransfer(value); err != nil {
+			return err
+		}
+	case "pull_fallback":
+		if err := validatePullFallback(value); err != nil {
+			return err
+		}
 	default:
-		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, run_mode, auto_enable)", key)
+		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, engine.docker_host, engine.podman_host, run_mode, auto_enable, transfer, pull_fallback)", key)
 	}
 
 	cfg, err := LoadRuntimeConfig()
@@ -205,11 +291,19 @@ func SetConfigValue(key, value string) error {
 		cfg.Engine.Build = value
 	case "engine.run":
 		cfg.Engine.Run = value
+	case "engine.docker_host":
+		cfg.Engine.DockerHost = value
+	case "engine.podman_host":
+		cfg.Engine.PodmanHost = value
 	case "run_mode":
 		cfg.RunMode = value
 	case "auto_enable":
 		b := value == "true"
 		cfg.AutoEnable = &b
+	case "transfer":
+		cfg.Transfer = value
+	case "pull_fallback":
+		cfg.PullFallback = value
 	}
 
 	return SaveRuntimeConfig(cfg)
@@ -233,12 +327,20 @@ func ResetConfigValue(key string) error {
 		cfg.Engine.Build = ""
 	case "engine.run":
 		cfg.Engine.Run = ""
+	case "engine.docker_host":
+		cfg.Engine.DockerHost = ""
+	case "engine.podman_host":
+		cfg.Engine.PodmanHost = ""
 	case "run_mode":
 		cfg.RunMode = ""
 	case "auto_enable":
 		cfg.AutoEnable = nil
+	case "transfer":
+		cfg.Transfer = ""
+	case "pull_fallback":
+		cfg.PullFallback = ""
 	default:
-		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, run_mode, auto_enable)", key)
+		return fmt.Errorf("unknown config key %q (valid: engine.build, engine.run, engine.docker_host, engine.podman_host, run_mode, auto_enable, transfer, pull_fallback)", key)
 	}
 
 	return SaveRuntimeConfig(cfg)
@@ -294,5 +396,9 @@ func ListConfigValues() ([]configKeySource, error) {
 		resolve("engine.run", "OV_RUN_ENGINE", cfg.Engine.Run, "docker"),
 		resolve("run_mode", "OV_RUN_MODE", cfg.RunMode, "direct"),
 		autoEnableEntry(),
+		resolve("transfer", "OV_TRANSFER", cfg.Transfer, TransferAuto),
+		resolve("pull_fallback", "OV_PULL_FALLBACK", cfg.PullFallback, PullFallbackTrue),
+		resolve("engine.docker_host", "DOCKER_HOST", cfg.Engine.DockerHost, ""),
+		resolve("engine.podman_host", podmanHostEnv(), cfg.Engine.PodmanHost, ""),
 	}, nil
 }
@@ -63,7 +63,7 @@ func TestResolveRuntime_Defaults(t *testing.T) {
 	}
 
 	// Ensure env vars are clear
-	for _, key := range []string{"OV_BUILD_ENGINE", "OV_RUN_ENGINE", "OV_RUN_MODE", "OV_AUTO_ENABLE"} {
+	for _, key := range []string{"OV_BUILD_ENGINE", "OV_RUN_ENGINE", "OV_RUN_MODE", "OV_AUTO_ENABLE", "OV_TRANSFER", "OV_PULL_FALLBACK"} {
 		os.Unsetenv(key)
 	}
 
@@ -83,6 +83,12 @@ func TestResolveRuntime_Defaults(t *testing.T) {
 	if rt.AutoEnable {
 		t.Error("AutoEnable should default to false")
 	}
+	if rt.Transfer != TransferAuto {
+		t.Errorf("Transfer = %q, want %q", rt.Transfer, TransferAuto)
+	}
+	if rt.PullFallback != PullFallbackTrue {
+		t.Errorf("PullFallback = %q, want %q", rt.PullFallback, PullFallbackTrue)
+	}
 }
 
 func TestResolveRuntime_EnvOverridesConfig(t *testing.T) {
@@ -227,6 +233,11 @@ func TestListConfigValues(t *testing.T) {
 	os.Unsetenv("OV_RUN_ENGINE")
 	os.Unsetenv("OV_RUN_MODE")
 	os.Unsetenv("OV_AUTO_ENABLE")
+	os.Unsetenv("OV_TRANSFER")
+	os.Unsetenv("OV_PULL_FALLBACK")
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("CONTAINER_CONNECTION", "")
+	t.Setenv("CONTAINER_HOST", "")
 
 	SetConfigValue("engine.build", "podman")
 
@@ -234,8 +245,8 @@ func TestListConfigValues(t *testing.T) {
 	if err != nil {
 		t.Fatalf("ListConfigValues() error: %v", err)
 	}
-	if len(vals) != 4 {
-		t.Fatalf("expected 4 values, got %d", len(vals))
+	if len(vals) != 8 {
+		t.Fatalf("expected 8 values, got %d", len(vals))
 	}
 
 	// engine.build should come from config
@@ -250,6 +261,14 @@ func TestListConfigValues(t *testing.T) {
 	if vals[3].Key != "auto_enable" || vals[3].Value != "false" || vals[3].Source != "default" {
 		t.Errorf("auto_enable entry: %+v", vals[3])
 	}
+	// transfer should be default auto
+	if vals[4].Key != "transfer" || vals[4].Value != TransferAuto || vals[4].Source != "default" {
+		t.Errorf("transfer entry: %+v", vals[4])
+	}
+	// pull_fallback should be default true
+	if vals[5].Key != "pull_fallback" || vals[5].Value != PullFallbackTrue || vals[5].Source != "default" {
+		t.Errorf("pull_fallback entry: %+v", vals[5])
+	}
 }
 
 func TestGetConfigValue_UnknownKey(t *testing.T) {
@@ -410,3 +429,110 @@ func TestAutoEnable_ListConfigValues(t *testing.T) {
 		t.Error("auto_enable not found in ListConfigValues output")
 	}
 }
+
+func TestTransfer_SetGetReset(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.yml")
+
+	orig := RuntimeConfigPath
+	defer func() { RuntimeConfigPath = orig }()
+	RuntimeConfigPath = func() (string, error) { return configPath, nil }
+
+	if err := SetConfigValue("transfer", "rsync"); err == nil {
+		t.Error("expected error for invalid transfer value")
+	}
+	if err := SetConfigValue("transfer", "skopeo"); err != nil {
+		t.Fatalf("SetConfigValue(transfer) error: %v", err)
+	}
+	if val, _ := GetConfigValue("transfer"); val != "skopeo" {
+		t.Errorf("GetConfigValue(transfer) = %q, want %q", val, "skopeo")
+	}
+
+	t.Setenv("OV_TRANSFER", "pipe")
+	rt, err := ResolveRuntime()
+	if err != nil {
+		t.Fatalf("ResolveRuntime() error: %v", err)
+	}
+	if rt.Transfer != "pipe" {
+		t.Errorf("Transfer = %q, want %q (env should override config)", rt.Transfer, "pipe")
+	}
+
+	if err := ResetConfigValue("transfer"); err != nil {
+		t.Fatalf("ResetConfigValue(transfer) error: %v", err)
+	}
+	if val, _ := GetConfigValue("transfer"); val != "" {
+		t.Errorf("after reset, GetConfigValue(transfer) = %q, want empty", val)
+	}
+}
+
+func TestPullFallback_SetValidates(t *testing.T) {
+	orig := RuntimeConfigPath
+	defer func() { RuntimeConfigPath = orig }()
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	RuntimeConfigPath = func() (string, error) { return configPath, nil }
+
+	if err := SetConfigValue("pull_fallback", "sometimes"); err == nil {
+		t.Error("expected error for invalid pull_fallback value")
+	}
+	if err := SetConfigValue("pull_fallback", "always"); err != nil {
+		t.Fatalf("SetConfigValue(pull_fallback) error: %v", err)
+	}
+	if val, _ := GetConfigValue("pull_fallback"); val != "always" {
+		t.Errorf("GetConfigValue(pull_fallback) = %q, want %q", val, "always")
+	}
+}
+
+func TestEngineHosts_Resolve(t *testing.T) {
+	orig, origEndpoints := RuntimeConfigPath, EngineEndpoints
+	defer func() { RuntimeConfigPath, EngineEndpoints = orig, origEndpoints }()
+	configPath := filepath.Join(t.TempDir(), "config.yml")
+	RuntimeConfigPath = func() (string, error) { return configPath, nil }
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("CONTAINER_HOST", "")
+	t.Setenv("CONTAINER_CONNECTION", "")
+
+	if err := SetConfigValue("engine.docker_host", "builder"); err == nil {
+		t.Error("expected error for a docker_host without a scheme")
+	}
+	if err := SetConfigValue("engine.docker_host", "ssh://builder"); err != nil {
+		t.Fatalf("SetConfigValue(engine.docker_host) error: %v", err)
+	}
+	if err := SetConfigValue("engine.podman_host", "builder"); err != nil {
+		t.Fatalf("SetConfigValue(engine.podman_host) error: %v", err)
+	}
+
+	rt, err := ResolveRuntime()
+	if err != nil {
+		t.Fatalf("ResolveRuntime() error: %v", err)
+	}
+	if rt.DockerHost != "ssh://builder" || rt.PodmanHost != "builder" {
+		t.Errorf("hosts = %q, %q; want the config values", rt.DockerHost, rt.PodmanHost)
+	}
+	if EngineEndpoints["docker"] != "ssh://builder" || EngineEndpoints["podman"] != "builder" {
+		t.Errorf("EngineEndpoints = %v, want the resolved hosts", EngineEndpoints)
+	}
+
+	// The engines' own variables override the config
+	t.Setenv("DOCKER_HOST", "tcp://ci:2376")
+	t.Setenv("CONTAINER_HOST", "ssh://core@ci/run/podman/podman.sock")
+	if rt, err = ResolveRuntime(); err != nil {
+		t.Fatalf("ResolveRuntime() error: %v", err)
+	}
+	if rt.DockerHost != "tcp://ci:2376" || rt.PodmanHost != "ssh://core@ci/run/podman/podman.sock" {
+		t.Errorf("hosts = %q, %q; want the environment values", rt.DockerHost, rt.PodmanHost)
+	}
+	t.Setenv("CONTAINER_CONNECTION", "ci")
+	if rt, err = ResolveRuntime(); err != nil {
+		t.Fatalf("ResolveRuntime() error: %v", err)
+	}
+	if rt.PodmanHost != "ci" {
+		t.Errorf("PodmanHost = %q, want CONTAINER_CONNECTION to win", rt.PodmanHost)
+	}
+
+	if err := ResetConfigValue("engine.docker_host"); err != nil {
+		t.Fatalf("ResetConfigValue(engine.docker_host) error: %v", err)
+	}
+	if val, _ := GetConfigValue("engine.docker_host"); val != "" {
+		t.Errorf("after reset, GetConfigValue(engine.docker_host) = %q, want empty", val)
+	}
+}
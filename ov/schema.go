@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// schemaPatterns are the validation regexps that `schema:"pattern:<name>"`
+// struct tags refer to, so the schema uses the same patterns as ov validate
+var schemaPatterns = map[string]*regexp.Regexp{
+	"platform":  platformRe,
+	"secret_id": secretIDRe,
+	"alias":     aliasNameRe,
+}
+
+// ConfigSchema returns a JSON Schema (draft 2020-12) for images.yml, derived
+// from the yaml tags of Config and the types it contains. `schema` struct tags
+// add enums (`schema:"enum:rpm|deb|apk"`) and patterns (`schema:"pattern:platform"`);
+// for slices they apply to the items.
+func ConfigSchema() ([]byte, error) {
+	defs := make(map[string]interface{})
+	root, err := schemaForStruct(reflect.TypeOf(Config{}), defs)
+	if err != nil {
+		return nil, err
+	}
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["title"] = "overthink images.yml"
+	root["$defs"] = defs
+	return json.MarshalIndent(root, "", "  ")
+}
+
+// schemaForType returns the schema for a Go type, adding named structs to
+// defs. Types images.yml can't hold are an error.
+func schemaForType(t reflect.Type, defs map[string]interface{}) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(AliasGPU("")) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "boolean"},
+				map[string]interface{}{"enum": []string{"auto"}},
+			},
+		}, nil
+	}
+	if t == reflect.TypeOf(Command{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Slice:
+		items, err := schemaForType(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, fmt.Errorf("schema: unsupported map key type %s", t.Key())
+		}
+		values, err := schemaForType(t.Elem(), defs)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if _, ok := defs[t.Name()]; !ok {
+			defs[t.Name()] = nil // placeholder against recursion
+			def, err := schemaForStruct(t, defs)
+			if err != nil {
+				return nil, err
+			}
+			defs[t.Name()] = def
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + t.Name()}, nil
+	}
+	return nil, fmt.Errorf("schema: unsupported type %s", t)
+}
+
+// schemaForStruct returns an object schema with one property per yaml field.
+// Unknown keys are rejected like in LoadConfig, except x- extension keys.
+func schemaForStruct(t reflect.Type, defs map[string]interface{}) (map[string]interface{}, error) {
+	properties := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+
+		prop, err := schemaForType(f.Type, defs)
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", t.Name(), f.Name, err)
+		}
+		if tag := f.Tag.Get("schema"); tag != "" {
+			target := prop
+			if items, ok := prop["items"].(map[string]interface{}); ok {
+				target = items
+			}
+			kind, value, _ := strings.Cut(tag, ":")
+			switch kind {
+			case "enum":
+				target["enum"] = strings.Split(value, "|")
+			case "pattern":
+				re, ok := schemaPatterns[value]
+				if !ok {
+					return nil, fmt.Errorf("%s.%s: unknown schema pattern %q", t.Name(), f.Name, value)
+				}
+				target["pattern"] = re.String()
+			default:
+				return nil, fmt.Errorf("%s.%s: unknown schema tag %q", t.Name(), f.Name, tag)
+			}
+		}
+		properties[name] = prop
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"patternProperties":    map[string]interface{}{"^x-": map[string]interface{}{}},
+		"additionalProperties": false,
+	}, nil
+}
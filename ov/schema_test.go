@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkSchema validates value (decoded YAML) against the subset of JSON Schema
+// that ConfigSchema emits and returns the violations
+func checkSchema(root, schema map[string]interface{}, value interface{}, path string) []string {
+	if ref, ok := schema["$ref"].(string); ok {
+		name := strings.TrimPrefix(ref, "#/$defs/")
+		return checkSchema(root, root["$defs"].(map[string]interface{})[name].(map[string]interface{}), value, path)
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, s := range oneOf {
+			if len(checkSchema(root, s.(map[string]interface{}), value, path)) == 0 {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return []string{fmt.Sprintf("%s: matches %d of oneOf", path, matches)}
+		}
+		return nil
+	}
+
+	var errs []string
+	switch schema["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			return []string{fmt.Sprintf("%s: want string, got %T", path, value)}
+		}
+		if pattern, ok := schema["pattern"].(string); ok && !regexp.MustCompile(pattern).MatchString(s) {
+			errs = append(errs, fmt.Sprintf("%s: %q does not match %s", path, s, pattern))
+		}
+		if enum, ok := schema["enum"].([]interface{}); ok {
+			found := false
+			for _, e := range enum {
+				found = found || e == s
+			}
+			if !found {
+				errs = append(errs, fmt.Sprintf("%s: %q not in %v", path, s, enum))
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want boolean, got %T", path, value))
+		}
+	case "integer":
+		if _, ok := value.(int); !ok {
+			errs = append(errs, fmt.Sprintf("%s: want integer, got %T", path, value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want array, got %T", path, value)}
+		}
+		for i, item := range items {
+			errs = append(errs, checkSchema(root, schema["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("%s: want object, got %T", path, value)}
+		}
+		props, _ := schema["properties"].(map[string]interface{})
+		patterns, _ := schema["patternProperties"].(map[string]interface{})
+		for key, v := range obj {
+			keyPath := joinKeyPath(path, key)
+			if prop, ok := props[key]; ok {
+				errs = append(errs, checkSchema(root, prop.(map[string]interface{}), v, keyPath)...)
+				continue
+			}
+			matched := false
+			for pattern := range patterns {
+				matched = matched || regexp.MustCompile(pattern).MatchString(key)
+			}
+			if matched {
+				continue
+			}
+			switch additional := schema["additionalProperties"].(type) {
+			case bool:
+				if !additional {
+					errs = append(errs, fmt.Sprintf("%s: additional property not allowed", keyPath))
+				}
+			case map[string]interface{}:
+				errs = append(errs, checkSchema(root, additional, v, keyPath)...)
+			}
+		}
+	}
+	return errs
+}
+
+func loadSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	data, err := ConfigSchema()
+	if err != nil {
+		t.Fatalf("ConfigSchema() error = %v", err)
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	return schema
+}
+
+func TestConfigSchemaValidatesTestdata(t *testing.T) {
+	schema := loadSchema(t)
+	data, err := os.ReadFile("testdata/images.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		t.Fatalf("parsing testdata/images.yml: %v", err)
+	}
+	if errs := checkSchema(schema, schema, value, ""); len(errs) > 0 {
+		t.Errorf("testdata/images.yml does not match the schema:\n  %s", strings.Join(errs, "\n  "))
+	}
+}
+
+func TestConfigSchemaRejects(t *testing.T) {
+	schema := loadSchema(t)
+	tests := []struct {
+		yml  string
+		want string
+	}{
+		{"images:\n  app:\n    pkg: pacman\n", "images.app.pkg"},
+		{"defaults:\n  platforms: [amd64]\n", "defaults.platforms[0]"},
+		{"images:\n  app:\n    platfroms: [linux/amd64]\n", "images.app.platfroms: additional property not allowed"},
+		{"images:\n  app:\n    aliases:\n      - name: -bad\n", "images.app.aliases[0].name"},
+		{"images:\n  app:\n    uid: user\n", "images.app.uid: want integer"},
+	}
+	for _, tt := range tests {
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(tt.yml), &value); err != nil {
+			t.Fatal(err)
+		}
+		errs := checkSchema(schema, schema, value, "")
+		if !strings.Contains(strings.Join(errs, "\n"), tt.want) {
+			t.Errorf("schema errors for %q = %v, want one containing %q", tt.yml, errs, tt.want)
+		}
+	}
+}
+
+func TestConfigSchemaCoversConfig(t *testing.T) {
+	schema := loadSchema(t)
+	defs := schema["$defs"].(map[string]interface{})
+
+	// Every yaml field of every struct reachable from Config has a property
+	seen := make(map[reflect.Type]bool)
+	var walk func(reflect.Type)
+	walk = func(rt reflect.Type) {
+		for rt.Kind() == reflect.Pointer || rt.Kind() == reflect.Slice || rt.Kind() == reflect.Map {
+			rt = rt.Elem()
+		}
+		if rt.Kind() != reflect.Struct || seen[rt] {
+			return
+		}
+		seen[rt] = true
+		def := schema
+		if rt != reflect.TypeOf(Config{}) {
+			d, ok := defs[rt.Name()].(map[string]interface{})
+			if !ok {
+				t.Errorf("schema has no $defs entry for %s", rt.Name())
+				return
+			}
+			def = d
+		}
+		properties := def["properties"].(map[string]interface{})
+		for i := 0; i < rt.NumField(); i++ {
+			f := rt.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(f.Name)
+			}
+			if _, ok := properties[name]; !ok {
+				t.Errorf("schema of %s has no property %q (field %s)", rt.Name(), name, f.Name)
+			}
+			walk(f.Type)
+		}
+	}
+	walk(reflect.TypeOf(Config{}))
+}
+
+func TestSchemaForStructErrors(t *testing.T) {
+	type withChan struct {
+		Events chan string `yaml:"events"`
+	}
+	type withPattern struct {
+		Name string `yaml:"name" schema:"pattern:nope"`
+	}
+	type withIntKeys struct {
+		Sizes map[int]string `yaml:"sizes"`
+	}
+	tests := []struct {
+		typ  reflect.Type
+		want string
+	}{
+		{reflect.TypeOf(withChan{}), "withChan.Events: schema: unsupported type chan string"},
+		{reflect.TypeOf(withPattern{}), `withPattern.Name: unknown schema pattern "nope"`},
+		{reflect.TypeOf(withIntKeys{}), "withIntKeys.Sizes: schema: unsupported map key type int"},
+	}
+	for _, tt := range tests {
+		_, err := schemaForStruct(tt.typ, make(map[string]interface{}))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("schemaForStruct(%s) error = %v, want %q", tt.typ.Name(), err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// generateBuildScript writes .build/build.sh, a podman-only alternative to
+// ov build: images are built in dependency waves from the generated
+// Containerfiles, each wave finishing (and tagging locally) before the next.
+func (g *Generator) generateBuildScript(order []string) error {
+	path := filepath.Join(g.BuildDir, "build.sh")
+	if err := g.writeGenerated(path, g.buildScript(order)); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0755)
+}
+
+// buildScript renders the build script for images in build order
+func (g *Generator) buildScript(order []string) string {
+	var b strings.Builder
+
+	b.WriteString("#!/usr/bin/env bash\n")
+	b.WriteString("# .build/build.sh (generated by ov generate --format script -- do not edit)\n")
+	b.WriteString("# Builds all images with podman in dependency order for the host platform\n")
+	b.WriteString("# (or $PLATFORM). Usage: .build/build.sh [--jobs N]\n")
+	b.WriteString("set -euo pipefail\n")
+	b.WriteString("cd \"$(dirname \"$0\")/..\"\n\n")
+
+	// The labels of the build itself are taken when the script runs, so a
+	// regeneration leaves the script unchanged (ov build passes buildArgs)
+	labels := fmt.Sprintf(`--label "%s=$CREATED"`, LabelOCICreated)
+	b.WriteString("# Labels of the build itself, taken when it runs\n")
+	b.WriteString("CREATED=$(date -u +%Y-%m-%dT%H:%M:%SZ)\n")
+	if g.Config.Defaults.TagSuffix == "git" {
+		b.WriteString("REVISION=$(git rev-parse HEAD 2>/dev/null || true)\n")
+		labels += fmt.Sprintf(` ${REVISION:+--label "%s=$REVISION"}`, LabelOCIRevision)
+	}
+	b.WriteString("\n")
+
+	b.WriteString("JOBS=1\n")
+	b.WriteString("while [ $# -gt 0 ]; do\n")
+	b.WriteString("  case \"$1\" in\n")
+	b.WriteString("    --jobs) JOBS=$2; shift 2 ;;\n")
+	b.WriteString("    --jobs=*) JOBS=${1#--jobs=}; shift ;;\n")
+	b.WriteString("    *) echo \"usage: $0 [--jobs N]\" >&2; exit 2 ;;\n")
+	b.WriteString("  esac\n")
+	b.WriteString("done\n\n")
+
+	b.WriteString("build() {\n")
+	b.WriteString("  echo \"--- Building $1 ---\" >&2\n")
+	b.WriteString("  case \"$1\" in\n")
+	cmd := &BuildCmd{}
+	for _, name := range order {
+		img := g.Images[name]
+		args := cmd.buildLocalArgs("podman", img.Tags, "", name, img.Registry)
+		args = withSecretArgs(args, img.Secrets, "$HOME")
+		args = withContextArgs(args, "--ignorefile", fmt.Sprintf(".build/%s/Containerfile.dockerignore", name))
+		var quoted []string
+		for _, arg := range args {
+			if arg == "-" {
+				arg = fmt.Sprintf(".build/%s/Containerfile", name)
+			}
+			quoted = append(quoted, shellQuoteHome(arg))
+		}
+		// podman build ... ${PLATFORM:+--platform "$PLATFORM"} <labels> <rest>
+		cmdline := quoted[0] + " " + quoted[1] + " ${PLATFORM:+--platform \"$PLATFORM\"} " + labels + " " + strings.Join(quoted[2:], " ")
+		b.WriteString(fmt.Sprintf("    %s) %s ;;\n", shellQuote(name), cmdline))
+	}
+	b.WriteString("  esac\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("# Run a build in the background, at most $JOBS at a time\n")
+	b.WriteString("pids=()\n")
+	b.WriteString("run() {\n")
+	b.WriteString("  if [ \"$JOBS\" -le 1 ]; then\n")
+	b.WriteString("    build \"$1\"\n")
+	b.WriteString("    return\n")
+	b.WriteString("  fi\n")
+	b.WriteString("  while [ \"$(jobs -pr | wc -l)\" -ge \"$JOBS\" ]; do\n")
+	b.WriteString("    wait -n\n")
+	b.WriteString("  done\n")
+	b.WriteString("  build \"$1\" &\n")
+	b.WriteString("  pids+=(\"$!\")\n")
+	b.WriteString("}\n\n")
+
+	b.WriteString("# Wait for the current wave; any failed build stops the script\n")
+	b.WriteString("wave_done() {\n")
+	b.WriteString("  for pid in \"${pids[@]}\"; do\n")
+	b.WriteString("    wait \"$pid\"\n")
+	b.WriteString("  done\n")
+	b.WriteString("  pids=()\n")
+	b.WriteString("}\n")
+
+	for i, wave := range ExportGitHubMatrix(order, g.Images, g.Layers).Waves {
+		b.WriteString(fmt.Sprintf("\n# Wave %d\n", i))
+		for _, entry := range wave.Include {
+			b.WriteString(fmt.Sprintf("run %s\n", shellQuote(entry.Name)))
+		}
+		b.WriteString("wave_done\n")
+	}
+	return b.String()
+}
+
+// shellQuoteHome is shellQuote leaving $HOME (from a secret src with ~) for
+// the shell to expand
+func shellQuoteHome(s string) string {
+	parts := strings.Split(s, "$HOME")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = shellQuote(part)
+		}
+	}
+	return strings.Join(parts, `"$HOME"`)
+}
+
+// shellQuote single-quotes s unless it consists only of safe characters
+func shellQuote(s string) string {
+	safe := s != ""
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:=,@+", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
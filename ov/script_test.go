@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateBuildScript(t *testing.T) {
+	g := &Generator{
+		Config: &Config{Defaults: ImageConfig{TagSuffix: "git"}, Images: map[string]ImageConfig{
+			"fedora": {Layers: []string{"tool"}},
+			"web":    {Base: "fedora", Layers: []string{"tool"}, Tag: "v1"},
+			"api":    {Base: "fedora", Layers: []string{"tool"}, Secrets: []SecretConfig{{ID: "npmrc", Src: "~/.npmrc"}}},
+		}},
+		BuildDir: t.TempDir(),
+		Layers: map[string]*Layer{
+			"tool": {Name: "tool", HasRootYml: true},
+		},
+		Images: map[string]*ResolvedImage{
+			"fedora": {Name: "fedora", Base: "quay.io/fedora/fedora:43", IsExternalBase: true, Layers: []string{"tool"}, Registry: "ghcr.io/overthinkos", Tag: "2026.46.1415", FullTag: "ghcr.io/overthinkos/fedora:2026.46.1415", Tags: []string{"ghcr.io/overthinkos/fedora:2026.46.1415", "ghcr.io/overthinkos/fedora:latest"}},
+			"web":    {Name: "web", Base: "fedora", Layers: []string{"tool"}, Registry: "ghcr.io/overthinkos", Tag: "v1", FullTag: "ghcr.io/overthinkos/web:v1", Tags: []string{"ghcr.io/overthinkos/web:v1"}},
+			"api":    {Name: "api", Base: "fedora", Layers: []string{"tool"}, Secrets: []SecretConfig{{ID: "npmrc", Src: "~/.npmrc"}}, Tag: "2026.46.1415", FullTag: "api:2026.46.1415", Tags: []string{"api:2026.46.1415", "api:latest"}},
+		},
+		Containerfiles: make(map[string]string),
+		Created:        time.Date(2026, 2, 15, 14, 15, 0, 0, time.UTC),
+		Revision:       "0123456789abcdef0123456789abcdef01234567",
+	}
+
+	if err := g.generateBuildScript([]string{"fedora", "api", "web"}); err != nil {
+		t.Fatalf("generateBuildScript() error = %v", err)
+	}
+	path := filepath.Join(g.BuildDir, "build.sh")
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading build.sh: %v", err)
+	}
+	want, err := os.ReadFile(filepath.Join("testdata", "build.golden.sh"))
+	if err != nil {
+		t.Fatalf("reading golden file: %v", err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("build.sh =\n%s\nwant\n%s", got, want)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&0111 == 0 {
+		t.Errorf("build.sh mode = %v, want executable", info.Mode())
+	}
+
+	// The created and revision labels are taken when the script runs, so a
+	// later generation at another commit leaves it unchanged
+	g.Created = g.Created.Add(time.Hour)
+	g.Revision = "89abcdef0123456789abcdef0123456789abcdef"
+	if script := g.buildScript([]string{"fedora", "api", "web"}); script != string(got) {
+		t.Errorf("build.sh changed with the generation time and revision:\n%s", script)
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"ghcr.io/overthinkos/fedora:latest", "ghcr.io/overthinkos/fedora:latest"},
+		{"id=npmrc,src=~/.npmrc", "'id=npmrc,src=~/.npmrc'"},
+		{"it's", `'it'\''s'`},
+		{"", "''"},
+	}
+	for _, tt := range tests {
+		if got := shellQuote(tt.in); got != tt.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+
+	// $HOME from a secret src stays expandable
+	if got, want := shellQuoteHome("id=npmrc,src=$HOME/my rc"), `id=npmrc,src="$HOME"'/my rc'`; got != want {
+		t.Errorf("shellQuoteHome() = %q, want %q", got, want)
+	}
+	secrets := []SecretConfig{{ID: "npmrc", Src: "~/.npmrc"}, {ID: "key", Src: "/etc/key"}}
+	args := withSecretArgs([]string{"docker", "build", "."}, secrets, "/home/me")
+	if got := strings.Join(args, " "); got != "docker build --secret id=npmrc,src=/home/me/.npmrc --secret id=key,src=/etc/key ." {
+		t.Errorf("withSecretArgs() = %s", got)
+	}
+}
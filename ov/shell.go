@@ -3,21 +3,68 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
 // ShellCmd starts a bash shell in a container image
 type ShellCmd struct {
-	Image     string `arg:"" help:"Image name from images.yml"`
-	Workspace string `short:"w" long:"workspace" default:"." help:"Host path to mount at /workspace (default: current directory)"`
-	Tag       string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
-	Command   string `short:"c" help:"Command to execute instead of interactive shell"`
-	GPUFlags  `embed:""`
+	Image      string   `arg:"" optional:"" help:"Image name from images.yml, or a layer or alias name of one"`
+	Workspace  string   `short:"w" long:"workspace" default:"." help:"Host path to mount at /workspace (default: current directory)"`
+	Tag        string   `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
+	Command    string   `short:"c" help:"Command to execute instead of interactive shell"`
+	Cwd        string   `long:"cwd" help:"Host directory to mount at the same path and run in (set by aliases)"`
+	Env        []string `short:"e" long:"env" sep:"none" help:"Set a variable in the container (KEY=VALUE, or KEY to pass the host's value)"`
+	EnvPass    []string `name:"env-passthrough" sep:"none" placeholder:"PATTERN" help:"Pass the host variables matching a name or glob (e.g. AWS_*) if set, in addition to the image's shell.env_passthrough"`
+	SSH        bool     `long:"ssh" help:"Forward the host's SSH agent (mounts $SSH_AUTH_SOCK and sets SSH_AUTH_SOCK in the container)"`
+	Publish    []string `short:"p" long:"publish" aliases:"port" sep:"none" help:"Publish a port in addition to the image's (port or host:container), replacing a default for the same container port"`
+	Mount      []string `long:"mount" sep:"none" help:"Bind-mount a host path (src[:dst][:ro], relative src from the current directory, dst defaults to src)"`
+	RunArg     []string `long:"run-arg" sep:"none" help:"Extra argument for the engine's run command (e.g. --run-arg=--shm-size=2g)"`
+	Persist    bool     `long:"persist" help:"Keep the container of the shell and reattach to it next time (one per image and project)"`
+	Name       string   `long:"name" help:"Name of the persistent shell (default: derived from the image and project directory)"`
+	Reset      bool     `long:"reset" help:"Remove the persistent shell (with --persist: start a new one)"`
+	List       bool     `long:"list" help:"List the persistent shells with their images and when they were last used"`
+	GPUs       string   `name:"gpus" xor:"gpu" placeholder:"auto|all|none|N" help:"GPUs to pass through: auto (default, all if the image sets gpu: true or a GPU is detected), all, none or a count N"`
+	Verbose    bool     `short:"v" long:"verbose" help:"Print how the shell is set up (GPU choice, user mapping, passed variables)"`
+	KeepUser   bool     `name:"keep-image-user" help:"Run as the image's uid:gid instead of mapping it to your host user"`
+	GPUFlags   `embed:""`
+	RunFlags   `embed:""`
+	StaleFlags `embed:""`
+	Args       []string `arg:"" optional:"" help:"Arguments appended to the -c command, each quoted for the shell (after --)"`
 }
 
 func (c *ShellCmd) Run() error {
+	if c.List {
+		rt, err := ResolveRuntime()
+		if err != nil {
+			return err
+		}
+		shells, err := ListOvContainers(rt.RunEngine, LabelShell, LabelShellProject)
+		if err != nil {
+			return err
+		}
+		printPersistentShells(os.Stdout, shells)
+		return nil
+	}
+	if c.Image == "" {
+		return fmt.Errorf("expected an image name")
+	}
+	if c.Name != "" && !shellNameRe.MatchString(c.Name) {
+		return fmt.Errorf("invalid --name %q: must match %s", c.Name, shellNameRe)
+	}
+	if c.Persist && c.Command != "" {
+		return fmt.Errorf("--persist keeps an interactive shell and can't be combined with -c")
+	}
+	for _, pattern := range c.EnvPass {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("--env-passthrough %q: %w", pattern, err)
+		}
+	}
+
 	// Resolve workspace to absolute path (needed regardless of config source)
 	absWorkspace, err := filepath.Abs(c.Workspace)
 	if err != nil {
@@ -31,8 +78,20 @@ func (c *ShellCmd) Run() error {
 		return fmt.Errorf("workspace path %q is not a directory", absWorkspace)
 	}
 
-	gpu := ResolveGPU(c.GPUFlags.Mode())
-	LogGPU(gpu)
+	command := c.Command
+	if len(c.Args) > 0 {
+		if command == "" {
+			return fmt.Errorf("arguments after the image need -c")
+		}
+		command = appendShellArgs(command, c.Args)
+	}
+
+	var cwd string
+	if c.Cwd != "" {
+		if cwd, err = resolveShellCwd(c.Cwd); err != nil {
+			return err
+		}
+	}
 
 	rt, err := ResolveRuntime()
 	if err != nil {
@@ -40,16 +99,50 @@ func (c *ShellCmd) Run() error {
 	}
 	engine := rt.RunEngine
 
+	// A layer or alias name stands for the image that has it
+	dir, _ := os.Getwd()
+	cfg, cfgErr := LoadConfig(dir)
+	if cfgErr == nil {
+		image, err := resolveImageName(cfg, dir, c.Image)
+		if err != nil {
+			return err
+		}
+		if image != c.Image {
+			fmt.Fprintf(os.Stderr, "Using image %s for %s\n", image, c.Image)
+			c.Image = image
+		}
+	}
+
+	var persistName string
+	if c.Persist || c.Reset {
+		persistName = persistentShellName(dir, c.Image, c.Name)
+		reuse, err := preparePersistentShell(os.Stderr, engine, persistName, c.Reset)
+		if err != nil {
+			return err
+		}
+		if !c.Persist {
+			if !reuse && !c.Reset {
+				fmt.Fprintf(os.Stderr, "No persistent shell %s\n", persistName)
+			}
+			return nil
+		}
+		if reuse {
+			fmt.Fprintf(os.Stderr, "Reattaching to %s (created with its mounts, ports and env then; --reset starts a new one)\n", persistName)
+			return execEngine(engine, attachShellArgs(engine, persistName))
+		}
+	}
+
 	var imageRef string
 	var uid, gid int
-	var ports []string
+	var home string
+	var imageGPU bool
+	var ports, mountSpecs, passPatterns []string
+	var shellDefaults *ShellConfig
 	var volumes []VolumeMount
 
 	// Try images.yml first (existing path)
-	dir, _ := os.Getwd()
-	cfg, cfgErr := LoadConfig(dir)
 	if cfgErr == nil {
-		resolved, err := cfg.ResolveImage(c.Image, "unused")
+		resolved, err := cfg.ResolveImage(c.Image, MetadataTag)
 		if err != nil {
 			return err
 		}
@@ -64,7 +157,15 @@ func (c *ShellCmd) Run() error {
 		imageRef = resolveShellImageRef(resolved.Registry, resolved.Name, c.Tag)
 		uid = resolved.UID
 		gid = resolved.GID
+		home = resolved.Home
+		imageGPU = resolved.GPU
 		ports = resolved.Ports
+		shellDefaults = resolved.Shell
+		if resolved.Shell != nil {
+			ports = slices.Concat(ports, resolved.Shell.Ports)
+			mountSpecs = resolved.Shell.Mounts
+			passPatterns = resolved.Shell.EnvPassthrough
+		}
 	} else {
 		// Label path: resolve from image labels
 		imageRef = resolveShellImageRef("", c.Image, c.Tag)
@@ -80,6 +181,7 @@ func (c *ShellCmd) Run() error {
 		}
 		uid = meta.UID
 		gid = meta.GID
+		home = meta.Home
 		ports = meta.Ports
 		volumes = meta.Volumes
 		// Re-resolve imageRef with registry from labels if available
@@ -91,23 +193,209 @@ func (c *ShellCmd) Run() error {
 	if cfgErr != nil {
 		// Already ensured above in the label path
 	} else {
-		if err := EnsureImage(imageRef, rt); err != nil {
+		if err := c.StaleFlags.EnsureImage(imageRef, rt, dir, c.Image); err != nil {
 			return err
 		}
 	}
 
-	args := buildShellArgs(engine, imageRef, absWorkspace, uid, gid, ports, volumes, gpu, c.Command)
+	gpus, reason, err := ResolveGPUCount(c.gpuSpec(), imageGPU)
+	if err != nil {
+		return err
+	}
+	if c.Verbose {
+		fmt.Fprintf(os.Stderr, "GPU passthrough: %s\n", reason)
+	}
 
-	// Find engine binary
-	enginePath, err := findExecutable(EngineBinary(engine))
+	mounts, err := mergeShellMounts(dir, mountSpecs, c.Mount)
 	if err != nil {
 		return err
 	}
+	ports = mergeShellPorts(ports, c.Publish)
 
-	// Replace process with engine
+	opts := c.RunFlags.Options(shellDefaults)
+	if c.Persist && opts.ReadOnly {
+		return fmt.Errorf("--persist keeps its changes in the container's root filesystem and can't be combined with a read-only one (--read-only or shell.read_only)")
+	}
+	if !opts.PublishesPorts() {
+		if len(c.Publish) > 0 {
+			return fmt.Errorf("--publish has no effect with --network %s", opts.Network)
+		}
+		if c.Verbose && len(ports) > 0 {
+			fmt.Fprintf(os.Stderr, "Ports: none published on network %s\n", opts.Network)
+		}
+		ports = nil
+	}
+	optArgs, err := runOptionArgs(engine, opts, len(ports) > 0)
+	if err != nil {
+		return err
+	}
+
+	// Host variables go as bare -e KEY, so the engine reads their values
+	// from its environment and they don't show in the process list
+	env := passthroughEnv(slices.Concat(passPatterns, c.EnvPass), os.Environ(), c.Env)
+	env = append(env, c.Env...)
+	if c.SSH {
+		m, e, err := sshAgentMount(os.Getenv("SSH_AUTH_SOCK"))
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, m)
+		env = append(env, e)
+	}
+	if c.Verbose && len(env) > 0 {
+		names := make([]string, len(env))
+		for i, e := range env {
+			names[i], _, _ = strings.Cut(e, "=")
+		}
+		fmt.Fprintf(os.Stderr, "Env: %s\n", strings.Join(names, " "))
+	}
+
+	// The workspace is always bind-mounted, so files the shell writes are
+	// owned by the host user unless --keep-image-user
+	user := shellUserArgs(rt, os.Getuid(), os.Getgid(), uid, gid, home, !c.KeepUser)
+	if c.Verbose {
+		fmt.Fprintf(os.Stderr, "User: %s\n", strings.Join(user, " "))
+	}
+
+	args := buildShellArgs(engine, imageRef, absWorkspace, user, ports, volumes, mounts, gpus, command, cwd, env, slices.Concat(optArgs, c.RunArg))
+	if c.Persist {
+		fmt.Fprintf(os.Stderr, "Creating persistent shell %s\n", persistName)
+		args = persistShellArgs(args, persistName, c.Image, dir)
+	}
+	return execEngine(engine, args)
+}
+
+// gpuSpec returns the --gpus value, with --gpu as all and --no-gpu as none
+func (c *ShellCmd) gpuSpec() string {
+	return c.GPUFlags.Spec(c.GPUs)
+}
+
+// execEngine replaces the process with the engine command line args
+func execEngine(engine string, args []string) error {
+	enginePath, err := findExecutable(EngineBinary(engine))
+	if err != nil {
+		return err
+	}
 	return syscall.Exec(enginePath, args, os.Environ())
 }
 
+// resolveShellCwd returns the absolute path of an existing directory to mount
+// at the same path in the container. Any directory but / can be mounted.
+func resolveShellCwd(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", fmt.Errorf("resolving --cwd path: %w", err)
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("--cwd path %q: %w", abs, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("--cwd path %q is not a directory", abs)
+	}
+	if abs == "/" {
+		return "", fmt.Errorf("--cwd can't mount / into the container")
+	}
+	return abs, nil
+}
+
+// appendShellArgs appends args to command, each quoted so the shell passes it
+// on as one argument. Used by the Windows alias wrappers, which can't quote
+// for the container's shell themselves.
+func appendShellArgs(command string, args []string) string {
+	var b strings.Builder
+	b.WriteString(command)
+	for _, arg := range args {
+		b.WriteString(" ")
+		b.WriteString(shellQuote(arg))
+	}
+	return b.String()
+}
+
+// ShellMount is a host path bind-mounted by ov shell
+type ShellMount struct {
+	Source   string // absolute host path
+	Target   string // absolute container path
+	ReadOnly bool
+}
+
+// parseShellMount parses a "src[:dst][:ro]" mount. A relative src (or ~/...)
+// is resolved against dir (or the home directory) and must exist; dst
+// defaults to the resolved src. A trailing ":rw" is accepted too.
+func parseShellMount(spec, dir string) (ShellMount, error) {
+	parts := strings.Split(spec, ":")
+	var m ShellMount
+	if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+		m.ReadOnly = parts[n-1] == "ro"
+		parts = parts[:n-1]
+	}
+	if len(parts) > 2 || parts[0] == "" {
+		return m, fmt.Errorf("mount %q: want src[:dst][:ro]", spec)
+	}
+
+	src := parts[0]
+	if rest, ok := strings.CutPrefix(src, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return m, fmt.Errorf("mount %q: %w", spec, err)
+		}
+		src = filepath.Join(home, rest)
+	} else if !filepath.IsAbs(src) {
+		src = filepath.Join(dir, src)
+	}
+	if _, err := os.Stat(src); err != nil {
+		if os.IsNotExist(err) {
+			return m, fmt.Errorf("mount %q: source %s does not exist", spec, src)
+		}
+		return m, fmt.Errorf("mount %q: %w", spec, err)
+	}
+	m.Source = filepath.Clean(src)
+
+	m.Target = m.Source
+	if len(parts) == 2 {
+		if !strings.HasPrefix(parts[1], "/") {
+			return m, fmt.Errorf("mount %q: destination %q must be an absolute path", spec, parts[1])
+		}
+		m.Target = filepath.Clean(parts[1])
+	}
+	return m, nil
+}
+
+// mergeShellMounts parses the image's default mounts and the --mount flags,
+// both relative to dir. A flag replaces a default with the same destination.
+func mergeShellMounts(dir string, defaults, flags []string) ([]ShellMount, error) {
+	var mounts []ShellMount
+	for _, spec := range slices.Concat(defaults, flags) {
+		m, err := parseShellMount(spec, dir)
+		if err != nil {
+			return nil, err
+		}
+		mounts = slices.DeleteFunc(mounts, func(prev ShellMount) bool { return prev.Target == m.Target })
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// mergeShellPorts returns the default port mappings followed by the --publish
+// ones. A flag replaces the defaults for the same container port.
+func mergeShellPorts(defaults, flags []string) []string {
+	containerPort := func(mapping string) string {
+		return mapping[strings.LastIndex(mapping, ":")+1:]
+	}
+	var ports []string
+	for _, mapping := range defaults {
+		if !slices.ContainsFunc(flags, func(f string) bool { return containerPort(f) == containerPort(mapping) }) && !slices.Contains(ports, mapping) {
+			ports = append(ports, mapping)
+		}
+	}
+	for _, mapping := range flags {
+		if !slices.Contains(ports, mapping) {
+			ports = append(ports, mapping)
+		}
+	}
+	return ports
+}
+
 // resolveShellImageRef builds the full image reference from registry, name, and tag.
 func resolveShellImageRef(registry, name, tag string) string {
 	if registry != "" {
@@ -116,28 +404,47 @@ func resolveShellImageRef(registry, name, tag string) string {
 	return fmt.Sprintf("%s:%s", name, tag)
 }
 
-// buildShellArgs constructs the container run argument list.
-func buildShellArgs(engine, imageRef, workspace string, uid, gid int, ports []string, volumes []VolumeMount, gpu bool, command string) []string {
-	binary := EngineBinary(engine)
+// buildShellArgs constructs the container run argument list. A non-empty cwd
+// is mounted at the same path and used as the working directory; user holds
+// the user arguments (see shellUserArgs), gpus GPUs
+// are passed through (see GPUCountRunArgs), bind mounts follow the named
+// volumes, env entries are passed as -e flags and runArgs as they are, before
+// the image.
+func buildShellArgs(engine, imageRef, workspace string, user, ports []string, volumes []VolumeMount, mounts []ShellMount, gpus int, command, cwd string, env, runArgs []string) []string {
 	interactive := "-it"
 	if command != "" {
 		interactive = "-i"
 	}
-	args := []string{
-		binary, "run", "--rm", interactive,
+	workdir := "/workspace"
+	args := append([]string{EngineBinary(engine)}, EngineArgs(engine)...)
+	args = append(args,
+		"run", "--rm", interactive,
 		"-v", fmt.Sprintf("%s:/workspace", workspace),
-		"-w", "/workspace",
-		"--user", fmt.Sprintf("%d:%d", uid, gid),
-	}
-	if gpu {
-		args = append(args, GPURunArgs(engine)...)
+	)
+	if cwd != "" {
+		args = append(args, "-v", fmt.Sprintf("%s:%s", cwd, cwd))
+		workdir = cwd
 	}
+	args = append(args, "-w", workdir)
+	args = append(args, user...)
+	args = append(args, GPUCountRunArgs(engine, gpus)...)
 	for _, port := range ports {
 		args = append(args, "-p", localizePort(port))
 	}
 	for _, vol := range volumes {
 		args = append(args, "-v", fmt.Sprintf("%s:%s", vol.VolumeName, vol.ContainerPath))
 	}
+	for _, m := range mounts {
+		spec := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			spec += ":ro"
+		}
+		args = append(args, "-v", spec)
+	}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	args = append(args, runArgs...)
 	args = append(args, "--entrypoint", "bash", imageRef)
 	if command != "" {
 		args = append(args, "-c", command)
@@ -145,6 +452,46 @@ func buildShellArgs(engine, imageRef, workspace string, uid, gid int, ports []st
 	return args
 }
 
+// shellUserArgs returns the run arguments for the user of ov shell: the
+// image's uid:gid, or with mapHost the same user mapped to the host user
+// (hostUID:hostGID), so files written to bind mounts belong to the caller.
+//
+//   - podman: --userns=keep-id:uid=<uid>,gid=<gid> runs the image user as the
+//     host user. Rootful podman (host root) has no keep-id and runs the image
+//     user as is.
+//   - docker: --user hostUID:hostGID when it differs from the image user, with
+//     the image's group added and HOME kept at the image's home, so paths like
+//     ~/.pixi/bin still resolve. Caches go to /tmp, since the home is not
+//     necessarily writable for the host user.
+//
+// A negative host UID (Windows) never maps.
+func shellUserArgs(rt *ResolvedRuntime, hostUID, hostGID, uid, gid int, home string, mapHost bool) []string {
+	image := []string{"--user", fmt.Sprintf("%d:%d", uid, gid)}
+	if !mapHost || hostUID < 0 {
+		return image
+	}
+	if rt.RunEngine == "podman" {
+		if hostUID == 0 {
+			return image
+		}
+		return append([]string{fmt.Sprintf("--userns=keep-id:uid=%d,gid=%d", uid, gid)}, image...)
+	}
+	if hostUID == uid && hostGID == gid {
+		return image
+	}
+	args := []string{
+		"--user", fmt.Sprintf("%d:%d", hostUID, hostGID),
+		"--group-add", strconv.Itoa(gid),
+	}
+	if home != "" {
+		args = append(args, "-e", "HOME="+home)
+	}
+	return append(args,
+		"-e", "XDG_CACHE_HOME=/tmp/.cache",
+		"-e", "npm_config_cache=/tmp/.npm",
+	)
+}
+
 // localizePort prefixes a port mapping with 127.0.0.1 to bind only to localhost.
 // "80:8000" -> "127.0.0.1:80:8000", "8080" -> "127.0.0.1:8080:8080"
 func localizePort(mapping string) string {
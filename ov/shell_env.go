@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// sshAgentSocket is where ov shell --ssh mounts the host's SSH agent socket
+const sshAgentSocket = "/tmp/ov-ssh-agent.sock"
+
+// passthroughEnv returns the names of the variables in environ ("KEY=VALUE"
+// entries, as os.Environ) that match one of patterns, sorted. A pattern is a
+// variable name or a glob like AWS_* (see path.Match). Variables set by an
+// explicit -e entry in env are left out, so the explicit value wins.
+func passthroughEnv(patterns, environ, env []string) []string {
+	explicit := make(map[string]bool, len(env))
+	for _, e := range env {
+		name, _, _ := strings.Cut(e, "=")
+		explicit[name] = true
+	}
+	var names []string
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == "" || explicit[name] || slices.Contains(names, name) {
+			continue
+		}
+		if slices.ContainsFunc(patterns, func(p string) bool {
+			ok, _ := path.Match(p, name)
+			return ok
+		}) {
+			names = append(names, name)
+		}
+	}
+	sortStrings(names)
+	return names
+}
+
+// sshAgentMount returns the mount and the variable that forward the SSH agent
+// socket sock ($SSH_AUTH_SOCK) into ov shell
+func sshAgentMount(sock string) (ShellMount, string, error) {
+	if sock == "" {
+		return ShellMount{}, "", fmt.Errorf("--ssh: SSH_AUTH_SOCK is not set; start an SSH agent first")
+	}
+	info, err := os.Stat(sock)
+	if err != nil {
+		return ShellMount{}, "", fmt.Errorf("--ssh: agent socket: %w", err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return ShellMount{}, "", fmt.Errorf("--ssh: SSH_AUTH_SOCK %s is not a socket", sock)
+	}
+	return ShellMount{Source: sock, Target: sshAgentSocket}, "SSH_AUTH_SOCK=" + sshAgentSocket, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPassthroughEnv(t *testing.T) {
+	environ := []string{
+		"AWS_PROFILE=dev",
+		"AWS_REGION=eu-west-1",
+		"AWSOME=1",
+		"HTTPS_PROXY=http://proxy:3128",
+		"https_proxy=http://proxy:3128",
+		"EMPTY=",
+		"HOME=/home/me",
+		"TOKEN=secret",
+	}
+	tests := []struct {
+		name          string
+		patterns, env []string
+		want          []string
+	}{
+		{"none", nil, nil, nil},
+		{"glob", []string{"AWS_*"}, nil, []string{"AWS_PROFILE", "AWS_REGION"}},
+		{"names", []string{"HTTPS_PROXY", "NO_PROXY", "EMPTY"}, nil, []string{"EMPTY", "HTTPS_PROXY"}},
+		{"class", []string{"[Hh][Tt][Tt][Pp][Ss]_[Pp][Rr][Oo][Xx][Yy]"}, nil, []string{"HTTPS_PROXY", "https_proxy"}},
+		{"overlap", []string{"AWS_*", "AWS_REGION", "AWS?*"}, nil, []string{"AWSOME", "AWS_PROFILE", "AWS_REGION"}},
+		{"explicit wins", []string{"AWS_*", "TOKEN"}, []string{"AWS_REGION=us-east-1", "TOKEN"}, []string{"AWS_PROFILE"}},
+		{"bad pattern", []string{"AWS_["}, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := passthroughEnv(tt.patterns, environ, tt.env); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("passthroughEnv(%v) = %v, want %v", tt.patterns, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHAgentMount(t *testing.T) {
+	dir, err := os.MkdirTemp("", "ov-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sock := filepath.Join(dir, "agent.sock") // short path, unix sockets are limited to ~100 bytes
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer l.Close()
+
+	m, env, err := sshAgentMount(sock)
+	if err != nil {
+		t.Fatalf("sshAgentMount() error = %v", err)
+	}
+	if want := (ShellMount{Source: sock, Target: sshAgentSocket}); m != want {
+		t.Errorf("sshAgentMount() mount = %+v, want %+v", m, want)
+	}
+	if env != "SSH_AUTH_SOCK="+sshAgentSocket {
+		t.Errorf("sshAgentMount() env = %q", env)
+	}
+
+	args := buildShellArgs("docker", "img:latest", "/tmp", nil, nil, nil, []ShellMount{m}, 0, "", "", []string{env}, nil)
+	want := []string{"docker", "run", "--rm", "-it", "-v", "/tmp:/workspace", "-w", "/workspace",
+		"-v", sock + ":" + sshAgentSocket, "-e", "SSH_AUTH_SOCK=" + sshAgentSocket, "--entrypoint", "bash", "img:latest"}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs() =\n  %v\nwant\n  %v", args, want)
+	}
+
+	if _, _, err := sshAgentMount(""); err == nil {
+		t.Error("expected error without SSH_AUTH_SOCK")
+	}
+	if _, _, err := sshAgentMount(filepath.Join(dir, "gone.sock")); err == nil {
+		t.Error("expected error for a missing socket")
+	}
+	file := filepath.Join(dir, "file")
+	os.WriteFile(file, nil, 0600)
+	if _, _, err := sshAgentMount(file); err == nil {
+		t.Error("expected error for a regular file")
+	}
+}
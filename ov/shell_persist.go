@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// Labels of persistent shell containers (ov shell --persist)
+const (
+	LabelShell        = "org.overthink.shell"         // image name
+	LabelShellProject = "org.overthink.shell.project" // project directory
+)
+
+// shellNameRe matches valid --name values (container name characters)
+var shellNameRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// persistentShellName returns the container name of the persistent shell of
+// image in the project directory project: ov-shell-<image>-<dir>-<hash> (see
+// projectScope). An explicit name gives ov-shell-<name>.
+func persistentShellName(project, image, name string) string {
+	if name != "" {
+		return "ov-shell-" + name
+	}
+	return fmt.Sprintf("ov-shell-%s-%s", image, projectScope(project))
+}
+
+// preparePersistentShell decides how ov shell --persist enters the shell
+// name: it returns true when its container exists and is started again. With
+// reset an existing container is removed first, so a new one is created.
+func preparePersistentShell(out io.Writer, engine, name string, reset bool) (bool, error) {
+	if ContainerStatus(engine, name) == "" {
+		return false, nil
+	}
+	if !reset {
+		return true, nil
+	}
+	if err := RemoveContainer(engine, name); err != nil {
+		return false, err
+	}
+	fmt.Fprintf(out, "Removed persistent shell %s\n", name)
+	return false, nil
+}
+
+// attachShellArgs returns the command line that starts the existing container
+// name again and attaches to it
+func attachShellArgs(engine, name string) []string {
+	args := append([]string{EngineBinary(engine)}, EngineArgs(engine)...)
+	return append(args, "start", "-ai", name)
+}
+
+// persistShellArgs turns the run command line of buildShellArgs into one that
+// keeps the container: --rm is replaced by its name and the labels that
+// ov shell --list finds it by.
+func persistShellArgs(args []string, name, image, project string) []string {
+	persisted := make([]string, 0, len(args)+5)
+	replaced := false
+	for _, arg := range args {
+		if arg == "--rm" && !replaced {
+			persisted = append(persisted,
+				"--name", name,
+				"--label", LabelShell+"="+image,
+				"--label", LabelShellProject+"="+project,
+			)
+			replaced = true
+			continue
+		}
+		persisted = append(persisted, arg)
+	}
+	return persisted
+}
+
+// printPersistentShells prints shells as an aligned table, most recently
+// used first
+func printPersistentShells(w io.Writer, shells []OvContainer) {
+	if len(shells) == 0 {
+		fmt.Fprintln(w, "No persistent shells")
+		return
+	}
+	printOvContainers(w, shells, "LAST USED")
+}
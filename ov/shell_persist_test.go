@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistentShellName(t *testing.T) {
+	a := persistentShellName("/home/me/Web App", "python", "")
+	if !strings.HasPrefix(a, "ov-shell-python-web-app-") || len(a) != len("ov-shell-python-web-app-")+8 {
+		t.Errorf("persistentShellName() = %q, want ov-shell-python-web-app-<hash>", a)
+	}
+	if !shellNameRe.MatchString(a) {
+		t.Errorf("persistentShellName() = %q is not a valid container name", a)
+	}
+	if b := persistentShellName("/srv/web app", "python", ""); b == a || !strings.HasPrefix(b, "ov-shell-python-web-app-") {
+		t.Errorf("projects in directories of the same name collide: %q and %q", a, b)
+	}
+	if a != persistentShellName("/home/me/Web App", "python", "") {
+		t.Error("persistentShellName() is not stable")
+	}
+	if got := persistentShellName("/home/me/app", "python", "scratch"); got != "ov-shell-scratch" {
+		t.Errorf("persistentShellName(name) = %q, want ov-shell-scratch", got)
+	}
+}
+
+func TestPreparePersistentShell(t *testing.T) {
+	origStatus, origRemove := ContainerStatus, RemoveContainer
+	defer func() { ContainerStatus, RemoveContainer = origStatus, origRemove }()
+
+	containers := map[string]bool{}
+	var removed []string
+	ContainerStatus = func(engine, name string) string {
+		if containers[name] {
+			return "exited"
+		}
+		return ""
+	}
+	RemoveContainer = func(engine, name string) error {
+		if name == "ov-shell-stuck" {
+			return errors.New("device busy")
+		}
+		removed = append(removed, name)
+		delete(containers, name)
+		return nil
+	}
+
+	var out bytes.Buffer
+	tests := []struct {
+		name      string
+		exists    bool
+		reset     bool
+		wantReuse bool
+	}{
+		{"create", false, false, false},
+		{"reuse", true, false, true},
+		{"reset", true, true, false},
+		{"reset without container", false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			containers["ov-shell-x"], removed = tt.exists, nil
+			reuse, err := preparePersistentShell(&out, "docker", "ov-shell-x", tt.reset)
+			if err != nil || reuse != tt.wantReuse {
+				t.Errorf("preparePersistentShell() = %v, %v; want %v", reuse, err, tt.wantReuse)
+			}
+			if wantRemoved := tt.exists && tt.reset; (len(removed) == 1) != wantRemoved {
+				t.Errorf("removed %v, want removal %v", removed, wantRemoved)
+			}
+		})
+	}
+
+	containers["ov-shell-stuck"] = true
+	if _, err := preparePersistentShell(&out, "docker", "ov-shell-stuck", true); err == nil {
+		t.Error("expected the removal error")
+	}
+}
+
+func TestPersistShellArgs(t *testing.T) {
+	run := buildShellArgs("podman", "fedora:latest", "/tmp", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "", "", nil, nil)
+	got := persistShellArgs(run, "ov-shell-x", "fedora", "/home/me/app")
+	want := []string{
+		"podman", "run",
+		"--name", "ov-shell-x",
+		"--label", "org.overthink.shell=fedora",
+		"--label", "org.overthink.shell.project=/home/me/app",
+		"-it",
+		"-v", "/tmp:/workspace",
+		"-w", "/workspace",
+		"--user", "1000:1000",
+		"--entrypoint", "bash", "fedora:latest",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("persistShellArgs() =\n  %v\nwant\n  %v", got, want)
+	}
+	if got := attachShellArgs("docker", "ov-shell-x"); !reflect.DeepEqual(got, []string{"docker", "start", "-ai", "ov-shell-x"}) {
+		t.Errorf("attachShellArgs() = %v", got)
+	}
+}
+
+func TestListPersistentShells(t *testing.T) {
+	out := "/ov-shell-python-app-0123abcd\tpython\t/home/me/app\texited\t2026-10-01T09:30:00.123456789Z\n" +
+		"ov-shell-scratch\tfedora\t/srv/x\trunning\t2026-10-02 08:00:00.5 +0000 UTC\n"
+	shells, err := parseOvContainers(out)
+	if err != nil {
+		t.Fatalf("parseOvContainers() error = %v", err)
+	}
+	want := []OvContainer{
+		{Name: "ov-shell-python-app-0123abcd", Image: "python", Project: "/home/me/app", Status: "exited", Started: time.Date(2026, 10, 1, 9, 30, 0, 123456789, time.UTC)},
+		{Name: "ov-shell-scratch", Image: "fedora", Project: "/srv/x", Status: "running", Started: time.Date(2026, 10, 2, 8, 0, 0, 500000000, time.UTC)},
+	}
+	if len(shells) != 2 || !shells[0].Started.Equal(want[0].Started) || !shells[1].Started.Equal(want[1].Started) {
+		t.Fatalf("parseOvContainers() = %+v, want %+v", shells, want)
+	}
+	for i := range want {
+		shells[i].Started, want[i].Started = time.Time{}, time.Time{}
+	}
+	if !reflect.DeepEqual(shells, want) {
+		t.Errorf("parseOvContainers() = %+v, want %+v", shells, want)
+	}
+	if _, err := parseOvContainers("garbage\n"); err == nil {
+		t.Error("expected error for unexpected output")
+	}
+
+	var buf bytes.Buffer
+	shells[0].Started = time.Date(2026, 10, 1, 9, 30, 0, 0, time.UTC)
+	printPersistentShells(&buf, shells)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "ov-shell-python-app-0123abcd") || strings.Join(strings.Fields(lines[2])[3:], " ") != "- running" {
+		t.Errorf("table, most recently used first:\n%s", buf.String())
+	}
+}
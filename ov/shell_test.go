@@ -1,12 +1,17 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/alecthomas/kong"
 )
 
 func TestBuildShellArgs(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", 1000, 1000, nil, nil, false, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -21,7 +26,7 @@ func TestBuildShellArgs(t *testing.T) {
 }
 
 func TestBuildShellArgsCustomUIDGID(t *testing.T) {
-	args := buildShellArgs("docker", "fedora:latest", "/tmp", 1001, 1002, nil, nil, false, "")
+	args := buildShellArgs("docker", "fedora:latest", "/tmp", []string{"--user", "1001:1002"}, nil, nil, nil, 0, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/tmp:/workspace",
@@ -36,7 +41,7 @@ func TestBuildShellArgsCustomUIDGID(t *testing.T) {
 }
 
 func TestBuildShellArgsWithPorts(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", 1000, 1000, []string{"9090:9090", "8080:8080"}, nil, false, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", []string{"--user", "1000:1000"}, []string{"9090:9090", "8080:8080"}, nil, nil, 0, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -53,7 +58,7 @@ func TestBuildShellArgsWithPorts(t *testing.T) {
 }
 
 func TestBuildShellArgsWithSinglePort(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", 1000, 1000, []string{"8080"}, nil, false, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", []string{"--user", "1000:1000"}, []string{"8080"}, nil, nil, 0, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -72,7 +77,7 @@ func TestBuildShellArgsWithVolumes(t *testing.T) {
 	volumes := []VolumeMount{
 		{VolumeName: "ov-openclaw-data", ContainerPath: "/home/user/.openclaw"},
 	}
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/openclaw:latest", "/home/user/project", 1000, 1000, nil, volumes, false, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/openclaw:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, volumes, nil, 0, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -88,7 +93,7 @@ func TestBuildShellArgsWithVolumes(t *testing.T) {
 }
 
 func TestBuildShellArgsWithGPU(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", 1000, 1000, nil, nil, true, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, GPUAll, "", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -104,7 +109,7 @@ func TestBuildShellArgsWithGPU(t *testing.T) {
 }
 
 func TestBuildShellArgsWithGPUPodman(t *testing.T) {
-	args := buildShellArgs("podman", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", 1000, 1000, nil, nil, true, "")
+	args := buildShellArgs("podman", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, GPUAll, "", "", nil, nil)
 	want := []string{
 		"podman", "run", "--rm", "-it",
 		"-v", "/home/user/project:/workspace",
@@ -120,7 +125,7 @@ func TestBuildShellArgsWithGPUPodman(t *testing.T) {
 }
 
 func TestBuildShellArgsWithoutGPU(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", 1000, 1000, nil, nil, false, "")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "", "", nil, nil)
 	for _, arg := range args {
 		if arg == "--gpus" {
 			t.Error("buildShellArgs(gpu=false) should not contain --gpus")
@@ -149,7 +154,7 @@ func TestLocalizePort(t *testing.T) {
 }
 
 func TestBuildShellArgsWithCommand(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", 1000, 1000, nil, nil, false, "echo hello")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "echo hello", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-i",
 		"-v", "/home/user/project:/workspace",
@@ -165,7 +170,7 @@ func TestBuildShellArgsWithCommand(t *testing.T) {
 }
 
 func TestBuildShellArgsWithCommandAndGPU(t *testing.T) {
-	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", 1000, 1000, nil, nil, true, "nvidia-smi")
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/ollama:latest", "/home/user/project", []string{"--user", "1000:1000"}, nil, nil, nil, GPUAll, "nvidia-smi", "", nil, nil)
 	want := []string{
 		"docker", "run", "--rm", "-i",
 		"-v", "/home/user/project:/workspace",
@@ -181,6 +186,76 @@ func TestBuildShellArgsWithCommandAndGPU(t *testing.T) {
 	}
 }
 
+func TestBuildShellArgsWithCwd(t *testing.T) {
+	args := buildShellArgs("docker", "ghcr.io/overthinkos/fedora:latest", "/srv/data/project", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "ls 'a b'", "/srv/data/project", nil, nil)
+	want := []string{
+		"docker", "run", "--rm", "-i",
+		"-v", "/srv/data/project:/workspace",
+		"-v", "/srv/data/project:/srv/data/project",
+		"-w", "/srv/data/project",
+		"--user", "1000:1000",
+		"--entrypoint", "bash",
+		"ghcr.io/overthinkos/fedora:latest",
+		"-c", "ls 'a b'",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs(cwd) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestBuildShellArgsWithEnv(t *testing.T) {
+	args := buildShellArgs("docker", "fedora:latest", "/tmp", []string{"--user", "1000:1000"}, nil, nil, nil, 0, "env", "", []string{"GREETING=hello world", "OPENAI_API_KEY"}, nil)
+	want := []string{
+		"docker", "run", "--rm", "-i",
+		"-v", "/tmp:/workspace",
+		"-w", "/workspace",
+		"--user", "1000:1000",
+		"-e", "GREETING=hello world",
+		"-e", "OPENAI_API_KEY",
+		"--entrypoint", "bash",
+		"fedora:latest",
+		"-c", "env",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs(env) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestBuildShellArgsWithRunArgs(t *testing.T) {
+	args := buildShellArgs("podman", "fedora:latest", "/tmp", []string{"--user", "1000:1000"}, []string{"8888:8888"}, nil, nil, GPUAll, "", "", nil, []string{"--shm-size=2g"})
+	want := []string{
+		"podman", "run", "--rm", "-it",
+		"-v", "/tmp:/workspace",
+		"-w", "/workspace",
+		"--user", "1000:1000",
+		"--device", "nvidia.com/gpu=all",
+		"-p", "127.0.0.1:8888:8888",
+		"--shm-size=2g",
+		"--entrypoint", "bash",
+		"fedora:latest",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs(run args) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestResolveShellCwd(t *testing.T) {
+	dir := t.TempDir()
+	if got, err := resolveShellCwd(dir); err != nil || got != dir {
+		t.Errorf("resolveShellCwd(%q) = %q, %v; want the directory", dir, got, err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/", file, filepath.Join(dir, "missing")} {
+		if _, err := resolveShellCwd(path); err == nil {
+			t.Errorf("resolveShellCwd(%q) should fail", path)
+		}
+	}
+}
+
 func TestResolveShellImageRef(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -221,3 +296,205 @@ func TestResolveShellImageRef(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendShellArgs(t *testing.T) {
+	got := appendShellArgs("grep -r", []string{"it's", "a b", "-n"})
+	if want := `grep -r 'it'\''s' 'a b' -n`; got != want {
+		t.Errorf("appendShellArgs() = %q, want %q", got, want)
+	}
+	if got := appendShellArgs("ls", nil); got != "ls" {
+		t.Errorf("appendShellArgs(no args) = %q, want %q", got, "ls")
+	}
+}
+
+func TestBuildShellArgsWithMounts(t *testing.T) {
+	mounts := []ShellMount{{Source: "/home/me/src", Target: "/src"}, {Source: "/data", Target: "/data", ReadOnly: true}}
+	args := buildShellArgs("docker", "fedora:latest", "/tmp", []string{"--user", "1000:1000"}, nil, nil, mounts, 0, "", "", nil, nil)
+	want := []string{
+		"docker", "run", "--rm", "-it",
+		"-v", "/tmp:/workspace",
+		"-w", "/workspace",
+		"--user", "1000:1000",
+		"-v", "/home/me/src:/src",
+		"-v", "/data:/data:ro",
+		"--entrypoint", "bash", "fedora:latest",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs(mounts) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestParseShellMount(t *testing.T) {
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "src"), 0755)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	os.MkdirAll(filepath.Join(home, "notes"), 0755)
+
+	tests := []struct {
+		spec string
+		want ShellMount
+	}{
+		{"src", ShellMount{Source: filepath.Join(dir, "src"), Target: filepath.Join(dir, "src")}},
+		{"./src/:/src", ShellMount{Source: filepath.Join(dir, "src"), Target: "/src"}},
+		{"src:ro", ShellMount{Source: filepath.Join(dir, "src"), Target: filepath.Join(dir, "src"), ReadOnly: true}},
+		{dir + ":/work/:ro", ShellMount{Source: dir, Target: "/work", ReadOnly: true}},
+		{"src:/src:rw", ShellMount{Source: filepath.Join(dir, "src"), Target: "/src"}},
+		{"~/notes:/notes", ShellMount{Source: filepath.Join(home, "notes"), Target: "/notes"}},
+	}
+	for _, tt := range tests {
+		got, err := parseShellMount(tt.spec, dir)
+		if err != nil || got != tt.want {
+			t.Errorf("parseShellMount(%q) = %+v, %v; want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+
+	for spec, want := range map[string]string{
+		"missing:/m": "does not exist",
+		"src:rel":    "must be an absolute path",
+		"a:/b:/c":    "want src[:dst][:ro]",
+		":/empty":    "want src[:dst][:ro]",
+	} {
+		if _, err := parseShellMount(spec, dir); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseShellMount(%q) error = %v, want %q", spec, err, want)
+		}
+	}
+}
+
+func TestMergeShellMounts(t *testing.T) {
+	dir := t.TempDir()
+	for _, d := range []string{"cache", "data", "src"} {
+		os.MkdirAll(filepath.Join(dir, d), 0755)
+	}
+
+	// The flag replaces the default mounted at /data
+	mounts, err := mergeShellMounts(dir, []string{"data:/data:ro", "cache:/cache"}, []string{"src:/data", "src:/src"})
+	if err != nil {
+		t.Fatalf("mergeShellMounts() error = %v", err)
+	}
+	want := []ShellMount{
+		{Source: filepath.Join(dir, "cache"), Target: "/cache"},
+		{Source: filepath.Join(dir, "src"), Target: "/data"},
+		{Source: filepath.Join(dir, "src"), Target: "/src"},
+	}
+	if !reflect.DeepEqual(mounts, want) {
+		t.Errorf("mergeShellMounts() = %+v, want %+v", mounts, want)
+	}
+
+	if _, err := mergeShellMounts(dir, []string{"gone"}, nil); err == nil {
+		t.Error("expected error for a default mount whose source doesn't exist")
+	}
+}
+
+func TestMergeShellPorts(t *testing.T) {
+	got := mergeShellPorts([]string{"8080", "3000:3000", "9090:9090"}, []string{"3001:3000", "8080", "5173"})
+	want := []string{"9090:9090", "3001:3000", "8080", "5173"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeShellPorts() = %v, want %v", got, want)
+	}
+	if got := mergeShellPorts([]string{"8080", "8080"}, nil); !reflect.DeepEqual(got, []string{"8080"}) {
+		t.Errorf("mergeShellPorts(duplicates) = %v, want [8080]", got)
+	}
+}
+
+func TestShellCmdFlags(t *testing.T) {
+	var cli CLI
+	parser, err := kong.New(&cli)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"shell", "app", "--mount", "src", "--mount=data:/data:ro", "-p", "5173", "--publish", "8000:80", "--port=9000", "-c", "ls", "--", "-l"}); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	c := cli.Shell
+	if !reflect.DeepEqual(c.Mount, []string{"src", "data:/data:ro"}) {
+		t.Errorf("Mount = %q", c.Mount)
+	}
+	if !reflect.DeepEqual(c.Publish, []string{"5173", "8000:80", "9000"}) {
+		t.Errorf("Publish = %q", c.Publish)
+	}
+	if c.Command != "ls" || !reflect.DeepEqual(c.Args, []string{"-l"}) {
+		t.Errorf("Command = %q, Args = %q", c.Command, c.Args)
+	}
+}
+
+func TestBuildShellArgsWithGPUCount(t *testing.T) {
+	args := buildShellArgs("podman", "ollama:latest", "/tmp", []string{"--user", "1000:1000"}, nil, nil, nil, 2, "", "", nil, nil)
+	want := []string{
+		"podman", "run", "--rm", "-it",
+		"-v", "/tmp:/workspace",
+		"-w", "/workspace",
+		"--user", "1000:1000",
+		"--device", "nvidia.com/gpu=0",
+		"--device", "nvidia.com/gpu=1",
+		"--entrypoint", "bash", "ollama:latest",
+	}
+	if !reflect.DeepEqual(args, want) {
+		t.Errorf("buildShellArgs(2 GPUs) =\n  %v\nwant\n  %v", args, want)
+	}
+}
+
+func TestShellCmdGPUSpec(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"--gpus", "2"}, "2"},
+		{[]string{"--gpus=none"}, "none"},
+		{[]string{"--gpu"}, "all"},
+		{[]string{"--no-gpu"}, "none"},
+	}
+	for _, tt := range tests {
+		var cli CLI
+		parser, err := kong.New(&cli)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.Parse(append([]string{"shell", "app"}, tt.args...)); err != nil {
+			t.Fatalf("Parse(%v) error = %v", tt.args, err)
+		}
+		if got := cli.Shell.gpuSpec(); got != tt.want {
+			t.Errorf("gpuSpec() for %v = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	var cli CLI
+	parser, _ := kong.New(&cli)
+	if _, err := parser.Parse([]string{"shell", "app", "--gpu", "--gpus", "2"}); err == nil {
+		t.Error("expected error combining --gpu and --gpus")
+	}
+}
+
+func TestShellUserArgs(t *testing.T) {
+	docker := &ResolvedRuntime{RunEngine: "docker"}
+	podman := &ResolvedRuntime{RunEngine: "podman"}
+	imageUser := []string{"--user", "1000:1000"}
+	tests := []struct {
+		name             string
+		rt               *ResolvedRuntime
+		hostUID, hostGID int
+		mapHost          bool
+		want             []string
+	}{
+		{"docker same uid", docker, 1000, 1000, true, imageUser},
+		{"docker other uid", docker, 1001, 1005, true, []string{
+			"--user", "1001:1005", "--group-add", "1000",
+			"-e", "HOME=/home/user", "-e", "XDG_CACHE_HOME=/tmp/.cache", "-e", "npm_config_cache=/tmp/.npm",
+		}},
+		{"docker keep image user", docker, 1001, 1005, false, imageUser},
+		{"podman same uid", podman, 1000, 1000, true, []string{"--userns=keep-id:uid=1000,gid=1000", "--user", "1000:1000"}},
+		{"podman other uid", podman, 1001, 1005, true, []string{"--userns=keep-id:uid=1000,gid=1000", "--user", "1000:1000"}},
+		{"podman keep image user", podman, 1001, 1005, false, imageUser},
+		{"rootful podman", podman, 0, 0, true, imageUser},
+		{"no host uid", docker, -1, -1, true, imageUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := shellUserArgs(tt.rt, tt.hostUID, tt.hostGID, 1000, 1000, "/home/user", tt.mapHost)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("shellUserArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
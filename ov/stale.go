@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// StaleFlags are the freshness check flags shared by commands that run images
+type StaleFlags struct {
+	CheckStale   bool `long:"check-stale" help:"Warn if the image is older than its build inputs in images.yml and layers/"`
+	RebuildStale bool `long:"rebuild-stale" help:"Rebuild the image if it is older than its build inputs (implies --check-stale)"`
+}
+
+// EnsureImage ensures imageRef is in the run engine's store (see EnsureImage)
+// and, with --check-stale or --rebuild-stale, that it is up to date with the
+// build inputs of imageName in the project directory dir.
+func (f StaleFlags) EnsureImage(imageRef string, rt *ResolvedRuntime, dir, imageName string) error {
+	if !f.CheckStale && !f.RebuildStale {
+		return EnsureImage(imageRef, rt)
+	}
+	return EnsureFreshImage(imageRef, rt, dir, imageName, f.RebuildStale)
+}
+
+// ImageStaleness returns why the local image imageRef of imageName in the
+// project directory dir is out of date with its build inputs, or "" if it is
+// up to date or there is no way to tell. Package-level var for testability.
+var ImageStaleness = defaultImageStaleness
+
+func defaultImageStaleness(engine, imageRef, dir, imageName string) (string, error) {
+	labels, err := InspectLabels(engine, imageRef)
+	if err != nil {
+		return "", err
+	}
+
+	// Images built by ov carry the digest of their build inputs
+	if built := labels[LabelInputsDigest]; built != "" {
+		current, err := CurrentInputsDigest(dir, imageName)
+		if err != nil {
+			return "", err
+		}
+		if current == built {
+			return "", nil
+		}
+		return fmt.Sprintf("its build inputs changed (built from %s, now %s)", shortInputsDigest(built), shortInputsDigest(current)), nil
+	}
+
+	// Older images only have their build timestamp
+	created, err := time.Parse(time.RFC3339, labels[LabelOCICreated])
+	if err != nil {
+		return "", nil
+	}
+	path, mtime, err := newestInput(dir)
+	if err != nil {
+		return "", err
+	}
+	if mtime.After(created) {
+		return fmt.Sprintf("%s changed after it was built (%s)", path, created.Local().Format("2006-01-02 15:04")), nil
+	}
+	return "", nil
+}
+
+// CurrentInputsDigest returns the inputs digest a build of imageName in the
+// project directory dir would have now. Package-level var for testability.
+var CurrentInputsDigest = defaultCurrentInputsDigest
+
+func defaultCurrentInputsDigest(dir, imageName string) (string, error) {
+	gen, err := NewGenerator(dir, "", ConfigOptions{})
+	if err != nil {
+		return "", err
+	}
+	if _, ok := gen.Images[imageName]; !ok {
+		return "", fmt.Errorf("image %q not found in images.yml", imageName)
+	}
+	if err := generateInputDigests(gen); err != nil {
+		return "", err
+	}
+	return gen.InputDigests[imageName], nil
+}
+
+// generateInputDigests fills gen.InputDigests for all of gen's images the way
+// Generate does, writing the Containerfiles to a scratch directory and leaving
+// .build/ alone. gen.BuildDir is restored afterwards.
+func generateInputDigests(gen *Generator) error {
+	buildDir, err := os.MkdirTemp("", "ov-stale-")
+	if err != nil {
+		return fmt.Errorf("creating temp dir: %w", err)
+	}
+	defer os.RemoveAll(buildDir)
+	defer func(dir string) { gen.BuildDir = dir }(gen.BuildDir)
+	gen.BuildDir = buildDir
+
+	order, err := ResolveImageOrder(gen.Images, gen.Layers)
+	if err != nil {
+		return fmt.Errorf("resolving image order: %w", err)
+	}
+	for _, name := range order {
+		if err := gen.resolveUserContext(gen.Images[name]); err != nil {
+			return fmt.Errorf("resolving user context for %s: %w", name, err)
+		}
+	}
+	for _, name := range order {
+		if err := gen.generateContainerfile(name); err != nil {
+			return fmt.Errorf("generating Containerfile for %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// newestInput returns the most recently modified build input of the project
+// directory dir (images.yml, layers/, templates/), relative to dir
+func newestInput(dir string) (string, time.Time, error) {
+	var newest string
+	var mtime time.Time
+	for _, root := range []string{"images.yml", "layers", "templates"} {
+		err := filepath.WalkDir(filepath.Join(dir, root), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() || isEditorBackup(d.Name()) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if info.ModTime().After(mtime) {
+				newest, mtime = path, info.ModTime()
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return "", time.Time{}, err
+		}
+	}
+	if newest == "" {
+		return "", mtime, nil
+	}
+	rel, err := filepath.Rel(dir, newest)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return filepath.ToSlash(rel), mtime, nil
+}
+
+// shortInputsDigest returns the first 12 hex characters of an inputs digest
+func shortInputsDigest(digest string) string {
+	const prefix = "sha256:"
+	if len(digest) > len(prefix)+12 {
+		return digest[len(prefix) : len(prefix)+12]
+	}
+	return digest
+}
+
+// RebuildImage builds imageName of the project in the current directory.
+// Package-level var for testability.
+var RebuildImage = func(imageName string) error {
+	return (&BuildCmd{Images: []string{imageName}, Parallel: 1}).Run()
+}
+
+// EnsureFreshImage ensures imageRef is in the run engine's store (see
+// EnsureImage) and checks it against the current build inputs of imageName in
+// the project directory dir. A stale image is reported, or with rebuild built
+// again and transferred to the run engine. Failing to check only warns.
+func EnsureFreshImage(imageRef string, rt *ResolvedRuntime, dir, imageName string, rebuild bool) error {
+	if err := EnsureImage(imageRef, rt); err != nil {
+		return err
+	}
+
+	reason, err := ImageStaleness(rt.RunEngine, imageRef, dir, imageName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: can't tell whether %s is up to date: %v\n", imageRef, err)
+		return nil
+	}
+	if reason == "" {
+		return nil
+	}
+
+	if !rebuild {
+		fmt.Fprintf(os.Stderr, "\nWarning: image %s is STALE: %s\n", imageRef, reason)
+		fmt.Fprintf(os.Stderr, "Warning: rebuild it with 'ov build %s', or pass --rebuild-stale\n\n", imageName)
+		return nil
+	}
+
+	fmt.Fprintf(os.Stderr, "Rebuilding stale image %s: %s\n", imageRef, reason)
+	if err := RebuildImage(imageName); err != nil {
+		return fmt.Errorf("rebuilding %s: %w", imageName, err)
+	}
+	if rt.BuildEngine != rt.RunEngine {
+		return TransferImage(rt.BuildEngine, rt.RunEngine, imageRef, rt.Transfer)
+	}
+	return nil
+}
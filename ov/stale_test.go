@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageStaleness(t *testing.T) {
+	origInspect, origDigest := InspectLabels, CurrentInputsDigest
+	defer func() { InspectLabels, CurrentInputsDigest = origInspect, origDigest }()
+	CurrentInputsDigest = func(dir, imageName string) (string, error) {
+		return "sha256:1111111111111111aaaa", nil
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "images.yml"), []byte("images: {}\n"), 0644)
+	writeLayerFile(t, dir, "tool", "version: '3'\ntasks: {}\n")
+	mtime := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	for _, p := range []string{"images.yml", "layers/tool/root.yml"} {
+		os.Chtimes(filepath.Join(dir, p), mtime, mtime)
+	}
+	later := mtime.Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "layers/tool/root.yml"), later, later)
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   string // substring of the reason, "" for fresh
+	}{
+		{"matching digest", map[string]string{LabelInputsDigest: "sha256:1111111111111111aaaa"}, ""},
+		{"changed digest", map[string]string{LabelInputsDigest: "sha256:2222222222222222bbbb"}, "built from 222222222222, now 111111111111"},
+		{"built after inputs", map[string]string{LabelOCICreated: later.Add(time.Minute).Format(time.RFC3339)}, ""},
+		{"built before inputs", map[string]string{LabelOCICreated: mtime.Format(time.RFC3339)}, "layers/tool/root.yml changed"},
+		{"no labels", map[string]string{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			InspectLabels = func(engine, imageRef string) (map[string]string, error) {
+				return tt.labels, nil
+			}
+			got, err := ImageStaleness("docker", "app:latest", dir, "app")
+			if err != nil {
+				t.Fatalf("ImageStaleness() error = %v", err)
+			}
+			if tt.want == "" && got != "" {
+				t.Errorf("ImageStaleness() = %q, want fresh", got)
+			}
+			if tt.want != "" && !strings.Contains(got, tt.want) {
+				t.Errorf("ImageStaleness() = %q, want it to contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureFreshImage(t *testing.T) {
+	origExists, origStale, origRebuild := LocalImageExists, ImageStaleness, RebuildImage
+	defer func() {
+		LocalImageExists, ImageStaleness, RebuildImage = origExists, origStale, origRebuild
+	}()
+	LocalImageExists = func(engine, imageRef string) bool { return true }
+
+	var rebuilds []string
+	stub := func(reason string) {
+		rebuilds = nil
+		ImageStaleness = func(engine, imageRef, dir, imageName string) (string, error) {
+			return reason, nil
+		}
+		RebuildImage = func(imageName string) error {
+			rebuilds = append(rebuilds, imageName)
+			return nil
+		}
+	}
+	rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "docker"}
+
+	t.Run("fresh", func(t *testing.T) {
+		stub("")
+		stderr := captureStderr(t, func() {
+			if err := EnsureFreshImage("app:latest", rt, ".", "app", true); err != nil {
+				t.Fatalf("EnsureFreshImage() error = %v", err)
+			}
+		})
+		if rebuilds != nil || stderr != "" {
+			t.Errorf("fresh image rebuilt %v with output %q, want neither", rebuilds, stderr)
+		}
+	})
+
+	t.Run("stale warns", func(t *testing.T) {
+		stub("layers/tool/root.yml changed after it was built")
+		stderr := captureStderr(t, func() {
+			if err := EnsureFreshImage("app:latest", rt, ".", "app", false); err != nil {
+				t.Fatalf("EnsureFreshImage() error = %v", err)
+			}
+		})
+		if rebuilds != nil {
+			t.Errorf("rebuilt %v without --rebuild-stale", rebuilds)
+		}
+		for _, want := range []string{"app:latest is STALE", "layers/tool/root.yml", "ov build app", "--rebuild-stale"} {
+			if !strings.Contains(stderr, want) {
+				t.Errorf("warning %q doesn't mention %q", stderr, want)
+			}
+		}
+	})
+
+	t.Run("stale rebuilds", func(t *testing.T) {
+		stub("its build inputs changed")
+		captureStderr(t, func() {
+			if err := EnsureFreshImage("app:latest", rt, ".", "app", true); err != nil {
+				t.Fatalf("EnsureFreshImage() error = %v", err)
+			}
+		})
+		if len(rebuilds) != 1 || rebuilds[0] != "app" {
+			t.Errorf("rebuilds = %v, want [app]", rebuilds)
+		}
+	})
+
+	t.Run("check failure only warns", func(t *testing.T) {
+		stub("")
+		ImageStaleness = func(engine, imageRef, dir, imageName string) (string, error) {
+			return "", os.ErrNotExist
+		}
+		stderr := captureStderr(t, func() {
+			if err := EnsureFreshImage("app:latest", rt, ".", "app", true); err != nil {
+				t.Fatalf("EnsureFreshImage() error = %v", err)
+			}
+		})
+		if rebuilds != nil || !strings.Contains(stderr, "can't tell") {
+			t.Errorf("rebuilds = %v, output %q; want a warning only", rebuilds, stderr)
+		}
+	})
+}
+
+// captureStderr returns what fn writes to os.Stderr
+func captureStderr(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stderr
+	os.Stderr = w
+	defer func() { os.Stderr = orig }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestGenerateInputDigestsKeepsBuildDir(t *testing.T) {
+	dir := t.TempDir()
+	gen := newDigestGenerator(t, dir, "2026.2", time.Time{})
+	buildDir := gen.BuildDir
+	if err := generateInputDigests(gen); err != nil {
+		t.Fatalf("generateInputDigests() error = %v", err)
+	}
+	if gen.BuildDir != buildDir {
+		t.Errorf("BuildDir = %q, want %q restored", gen.BuildDir, buildDir)
+	}
+	if gen.InputDigests["app"] == "" {
+		t.Errorf("InputDigests = %v, want a digest for app", gen.InputDigests)
+	}
+	if _, err := os.Stat(buildDir); !os.IsNotExist(err) {
+		t.Errorf("%s written, want .build/ left alone", buildDir)
+	}
+}
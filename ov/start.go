@@ -10,10 +10,11 @@ import (
 
 // StartCmd launches a container with supervisord in the background
 type StartCmd struct {
-	Image     string `arg:"" help:"Image name from images.yml"`
-	Workspace string `short:"w" long:"workspace" default:"." help:"Host path to mount at /workspace (default: current directory)"`
-	Tag       string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
-	GPUFlags  `embed:""`
+	Image      string `arg:"" help:"Image name from images.yml"`
+	Workspace  string `short:"w" long:"workspace" default:"." help:"Host path to mount at /workspace (default: current directory)"`
+	Tag        string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
+	GPUFlags   `embed:""`
+	StaleFlags `embed:""`
 }
 
 func (c *StartCmd) Run() error {
@@ -55,7 +56,7 @@ func (c *StartCmd) runDirect(rt *ResolvedRuntime) error {
 	dir, _ := os.Getwd()
 	cfg, cfgErr := LoadConfig(dir)
 	if cfgErr == nil {
-		resolved, err := cfg.ResolveImage(c.Image, "unused")
+		resolved, err := cfg.ResolveImage(c.Image, MetadataTag)
 		if err != nil {
 			return err
 		}
@@ -89,7 +90,7 @@ func (c *StartCmd) runDirect(rt *ResolvedRuntime) error {
 	}
 
 	if cfgErr == nil {
-		if err := EnsureImage(imageRef, rt); err != nil {
+		if err := c.StaleFlags.EnsureImage(imageRef, rt, dir, c.Image); err != nil {
 			return err
 		}
 	}
@@ -171,7 +172,7 @@ func (c *StopCmd) Run() error {
 	engine := EngineBinary(rt.RunEngine)
 	name := containerName(c.Image)
 
-	cmd := exec.Command(engine, "stop", name)
+	cmd := engineCmd(engine, "stop", name)
 	output, err := cmd.CombinedOutput()
 	if err != nil {
 		return fmt.Errorf("%s stop failed: %w\n%s", engine, err, strings.TrimSpace(string(output)))
@@ -183,13 +184,13 @@ func (c *StopCmd) Run() error {
 
 // buildStartArgs constructs the container run argument list for detached supervisord.
 func buildStartArgs(engine, imageRef, workspace string, ports []string, name string, volumes []VolumeMount, gpu bool) []string {
-	binary := EngineBinary(engine)
-	args := []string{
-		binary, "run", "-d", "--rm",
+	args := append([]string{EngineBinary(engine)}, EngineArgs(engine)...)
+	args = append(args,
+		"run", "-d", "--rm",
 		"--name", name,
 		"-v", fmt.Sprintf("%s:/workspace", workspace),
 		"-w", "/workspace",
-	}
+	)
 	if gpu {
 		args = append(args, GPURunArgs(engine)...)
 	}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unmarshalStrict decodes data into out like yaml.Unmarshal, but rejects keys
+// that don't map to a field of out, at any depth. Keys starting with "x-" are
+// user extensions (e.g. anchors for YAML merges) and are ignored. file names
+// the source in errors.
+func unmarshalStrict(file string, data []byte, out interface{}) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+
+	var unknown []string
+	checkKnownFields(&doc, reflect.TypeOf(out), "", &unknown)
+	switch len(unknown) {
+	case 0:
+	case 1:
+		return fmt.Errorf("%s: %s", file, unknown[0])
+	default:
+		return fmt.Errorf("%s: %d unknown fields:\n  %s", file, len(unknown), strings.Join(unknown, "\n  "))
+	}
+
+	if err := doc.Decode(out); err != nil {
+		return fmt.Errorf("parsing %s: %w", file, err)
+	}
+	return nil
+}
+
+// yamlUnmarshalerType is implemented by types that decode themselves (e.g. Command)
+var yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+
+// checkKnownFields walks node alongside type t and appends an error for every
+// mapping key that t has no yaml field for. Type mismatches are left to the decoder.
+func checkKnownFields(node *yaml.Node, t reflect.Type, path string, unknown *[]string) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, n := range node.Content {
+			checkKnownFields(n, t, path, unknown)
+		}
+		return
+	case yaml.AliasNode:
+		node = node.Alias
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(yamlUnmarshalerType) {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i].Value, node.Content[i+1]
+			if key == "<<" {
+				// YAML merge: the merged mappings belong to the same struct
+				if value.Kind == yaml.SequenceNode {
+					for _, n := range value.Content {
+						checkKnownFields(n, t, path, unknown)
+					}
+				} else {
+					checkKnownFields(value, t, path, unknown)
+				}
+				continue
+			}
+			if strings.HasPrefix(key, "x-") {
+				continue
+			}
+			keyPath := joinKeyPath(path, key)
+			field, ok := fields[key]
+			if !ok {
+				var names []string
+				for name := range fields {
+					names = append(names, name)
+				}
+				sortStrings(names)
+				if suggestion := closestName(key, names); suggestion != "" {
+					*unknown = append(*unknown, fmt.Sprintf("%s: unknown field (did you mean %q?)", keyPath, suggestion))
+				} else {
+					*unknown = append(*unknown, fmt.Sprintf("%s: unknown field", keyPath))
+				}
+				continue
+			}
+			checkKnownFields(value, field.Type, keyPath, unknown)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			checkKnownFields(node.Content[i+1], t.Elem(), joinKeyPath(path, node.Content[i].Value), unknown)
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, n := range node.Content {
+			checkKnownFields(n, t.Elem(), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	}
+}
+
+// yamlFields maps the yaml key of every field of struct type t to the field
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields
+}
+
+// joinKeyPath appends key to a dotted config path
+func joinKeyPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// closestName returns the candidate with the smallest edit distance to
+// target, if it is close enough to be a likely typo
+func closestName(target string, candidates []string) string {
+	maxDist := 2
+	if len(target) >= 9 {
+		maxDist = 3
+	}
+	best, bestDist := "", maxDist+1
+	for _, candidate := range candidates {
+		if d := levenshteinDistance(target, candidate); d < bestDist {
+			best, bestDist = candidate, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigUnknownFields(t *testing.T) {
+	tests := []struct {
+		name string
+		yml  string
+		want string
+	}{
+		{
+			name: "defaults",
+			yml:  "defaults:\n  platfroms: [linux/amd64]\nimages: {}\n",
+			want: `images.yml: defaults.platfroms: unknown field (did you mean "platforms"?)`,
+		},
+		{
+			name: "image",
+			yml:  "images:\n  app:\n    bsae: fedora\n    layers: [tool]\n",
+			want: `images.app.bsae: unknown field (did you mean "base"?)`,
+		},
+		{
+			name: "alias entry",
+			yml:  "images:\n  app:\n    layers: [tool]\n    aliases:\n      - name: hello\n        comand: echo\n",
+			want: `images.app.aliases[0].comand: unknown field (did you mean "command"?)`,
+		},
+		{
+			name: "merge settings",
+			yml:  "defaults:\n  merge:\n    auto: true\n    max_bm: 128\nimages: {}\n",
+			want: `defaults.merge.max_bm: unknown field (did you mean "max_mb"?)`,
+		},
+		{
+			name: "long key",
+			yml:  "images:\n  app:\n    layers: [tool]\n    push_intermediate: false\n",
+			want: `images.app.push_intermediate: unknown field (did you mean "push_intermediates"?)`,
+		},
+		{
+			name: "no suggestion",
+			yml:  "images:\n  app:\n    layers: [tool]\n    favourite_color: blue\n",
+			want: "images.app.favourite_color: unknown field\n",
+		},
+		{
+			name: "top level",
+			yml:  "imges:\n  app:\n    layers: [tool]\n",
+			want: `imges: unknown field (did you mean "images"?)`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeConfigFiles(t, map[string]string{"images.yml": tt.yml})
+			_, err := LoadConfig(dir)
+			if err == nil {
+				t.Fatal("LoadConfig() expected error")
+			}
+			if !strings.Contains(err.Error()+"\n", tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigUnknownFieldsCollected(t *testing.T) {
+	yml := "defaults:\n  regsitry: ghcr.io/x\nimages:\n  app:\n    layres: [tool]\n"
+	dir := writeConfigFiles(t, map[string]string{"images.yml": yml})
+	_, err := LoadConfig(dir)
+	if err == nil {
+		t.Fatal("LoadConfig() expected error")
+	}
+	for _, want := range []string{"2 unknown fields", "defaults.regsitry", "images.app.layres"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %q, want it to contain %q", err, want)
+		}
+	}
+}
+
+func TestLoadConfigExtensionKeys(t *testing.T) {
+	yml := `x-common: &common
+  registry: ghcr.io/test
+  platforms: [linux/amd64]
+defaults:
+  <<: *common
+  x-note: ignored
+images:
+  app:
+    <<: *common
+    layers: [tool]
+    labels:
+      x-custom: kept
+`
+	dir := writeConfigFiles(t, map[string]string{"images.yml": yml})
+	cfg, err := LoadConfig(dir)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if cfg.Defaults.Registry != "ghcr.io/test" {
+		t.Errorf("Defaults.Registry = %q, want merged from x-common", cfg.Defaults.Registry)
+	}
+	if cfg.Images["app"].Labels["x-custom"] != "kept" {
+		t.Errorf("labels are data, x- keys must be kept: %v", cfg.Images["app"].Labels)
+	}
+}
@@ -0,0 +1,59 @@
+#!/usr/bin/env bash
+# .build/build.sh (generated by ov generate --format script -- do not edit)
+# Builds all images with podman in dependency order for the host platform
+# (or $PLATFORM). Usage: .build/build.sh [--jobs N]
+set -euo pipefail
+cd "$(dirname "$0")/.."
+
+# Labels of the build itself, taken when it runs
+CREATED=$(date -u +%Y-%m-%dT%H:%M:%SZ)
+REVISION=$(git rev-parse HEAD 2>/dev/null || true)
+
+JOBS=1
+while [ $# -gt 0 ]; do
+  case "$1" in
+    --jobs) JOBS=$2; shift 2 ;;
+    --jobs=*) JOBS=${1#--jobs=}; shift ;;
+    *) echo "usage: $0 [--jobs N]" >&2; exit 2 ;;
+  esac
+done
+
+build() {
+  echo "--- Building $1 ---" >&2
+  case "$1" in
+    fedora) podman build ${PLATFORM:+--platform "$PLATFORM"} --label "org.opencontainers.image.created=$CREATED" ${REVISION:+--label "org.opencontainers.image.revision=$REVISION"} -f .build/fedora/Containerfile -t ghcr.io/overthinkos/fedora:2026.46.1415 -t ghcr.io/overthinkos/fedora:latest --ignorefile .build/fedora/Containerfile.dockerignore . ;;
+    api) podman build ${PLATFORM:+--platform "$PLATFORM"} --label "org.opencontainers.image.created=$CREATED" ${REVISION:+--label "org.opencontainers.image.revision=$REVISION"} -f .build/api/Containerfile -t api:2026.46.1415 -t api:latest --secret id=npmrc,src="$HOME"/.npmrc --ignorefile .build/api/Containerfile.dockerignore . ;;
+    web) podman build ${PLATFORM:+--platform "$PLATFORM"} --label "org.opencontainers.image.created=$CREATED" ${REVISION:+--label "org.opencontainers.image.revision=$REVISION"} -f .build/web/Containerfile -t ghcr.io/overthinkos/web:v1 --ignorefile .build/web/Containerfile.dockerignore . ;;
+  esac
+}
+
+# Run a build in the background, at most $JOBS at a time
+pids=()
+run() {
+  if [ "$JOBS" -le 1 ]; then
+    build "$1"
+    return
+  fi
+  while [ "$(jobs -pr | wc -l)" -ge "$JOBS" ]; do
+    wait -n
+  done
+  build "$1" &
+  pids+=("$!")
+}
+
+# Wait for the current wave; any failed build stops the script
+wave_done() {
+  for pid in "${pids[@]}"; do
+    wait "$pid"
+  done
+  pids=()
+}
+
+# Wave 0
+run fedora
+wave_done
+
+# Wave 1
+run api
+run web
+wave_done
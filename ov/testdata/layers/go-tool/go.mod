@@ -0,0 +1,3 @@
+module example.com/go-tool
+
+go 1.22
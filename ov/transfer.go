@@ -2,8 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
 // LocalImageExists checks whether an image reference exists in the given engine's local store.
@@ -11,66 +21,501 @@ import (
 var LocalImageExists = defaultLocalImageExists
 
 func defaultLocalImageExists(engine, imageRef string) bool {
-	binary := EngineBinary(engine)
 	switch engine {
 	case "podman":
-		cmd := exec.Command(binary, "image", "exists", imageRef)
+		cmd := engineCmd(engine, "image", "exists", imageRef)
 		return cmd.Run() == nil
 	default:
 		// Docker has no "image exists" subcommand; use "image inspect"
-		cmd := exec.Command(binary, "image", "inspect", imageRef)
+		cmd := engineCmd(engine, "image", "inspect", imageRef)
 		cmd.Stdout = nil
 		cmd.Stderr = nil
 		return cmd.Run() == nil
 	}
 }
 
-// TransferImage pipes an image from one engine to another via save | load.
-func TransferImage(srcEngine, dstEngine, imageRef string) error {
-	srcBinary := EngineBinary(srcEngine)
-	dstBinary := EngineBinary(dstEngine)
+// LocalImageTags lists the tags of all images in the engine's local store.
+// Package-level var for testability.
+var LocalImageTags = defaultLocalImageTags
+
+func defaultLocalImageTags(engine string) ([]string, error) {
+	out, err := engineCmd(engine, "images", "--format", "{{.Tag}}").Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// Transfer methods (transfer in ~/.config/ov/config.yml)
+const (
+	TransferAuto   = "auto"   // skopeo if installed, else podman's transports, else pipe
+	TransferPipe   = "pipe"   // <src> save | <dst> load
+	TransferSkopeo = "skopeo" // skopeo copy between the engines' stores
+
+	// transferPodman copies with podman itself, which reads and writes
+	// docker's store through the docker-daemon transport
+	transferPodman = "podman"
+)
+
+// Cached lookup of skopeo (see skopeoInstalled)
+var (
+	skopeoOnce  sync.Once
+	skopeoFound bool
+)
+
+// skopeoInstalled reports whether skopeo is in $PATH, looking it up once
+func skopeoInstalled() bool {
+	skopeoOnce.Do(func() {
+		_, err := exec_LookPath("skopeo")
+		skopeoFound = err == nil
+	})
+	return skopeoFound
+}
+
+// transferMethod picks the method moving an image from srcEngine to dstEngine
+// for the transfer setting ("" means auto). skopeo and podman only reach the
+// local stores, so an engine with a remote endpoint is always piped; two
+// remote engines are refused, since the image would stream through this
+// machine on its way between them.
+func transferMethod(setting, srcEngine, dstEngine string) (string, error) {
+	srcEndpoint, dstEndpoint := EngineEndpoints[srcEngine], EngineEndpoints[dstEngine]
+	if isRemoteEndpoint(srcEndpoint) && isRemoteEndpoint(dstEndpoint) {
+		return "", fmt.Errorf("%s (%s) and %s (%s) are both remote; transfer the image on the remote host, or point one engine at this machine",
+			srcEngine, srcEndpoint, dstEngine, dstEndpoint)
+	}
+	remote := ""
+	if isRemoteEndpoint(srcEndpoint) {
+		remote = srcEngine
+	} else if isRemoteEndpoint(dstEndpoint) {
+		remote = dstEngine
+	}
+
+	switch setting {
+	case TransferPipe:
+		return TransferPipe, nil
+	case TransferSkopeo:
+		if remote != "" {
+			return "", fmt.Errorf("transfer=skopeo can't reach the remote %s endpoint %s; use transfer=pipe", remote, EngineEndpoints[remote])
+		}
+		if !skopeoInstalled() {
+			return "", fmt.Errorf("transfer=skopeo, but skopeo is not installed")
+		}
+		return TransferSkopeo, nil
+	}
+	if remote != "" {
+		return TransferPipe, nil
+	}
+	if skopeoInstalled() {
+		return TransferSkopeo, nil
+	}
+	if srcEngine == "podman" || dstEngine == "podman" {
+		return transferPodman, nil
+	}
+	return TransferPipe, nil
+}
+
+// engineTransport returns the containers/image reference of imageRef in an
+// engine's local store
+func engineTransport(engine, imageRef string) string {
+	if engine == "podman" {
+		return "containers-storage:" + imageRef
+	}
+	return "docker-daemon:" + imageRef
+}
+
+// transferArgs returns the command copying imageRef from srcEngine's store to
+// dstEngine's with the skopeo or podman method
+func transferArgs(method, srcEngine, dstEngine, imageRef string) []string {
+	if method == TransferSkopeo {
+		return []string{"skopeo", "copy", engineTransport(srcEngine, imageRef), engineTransport(dstEngine, imageRef)}
+	}
+	if dstEngine == "podman" {
+		return []string{"podman", "pull", engineTransport(srcEngine, imageRef)}
+	}
+	return []string{"podman", "push", imageRef, engineTransport(dstEngine, imageRef)}
+}
+
+// TransferImage copies an image from one engine to another with the method
+// picked by the transfer setting (see transferMethod). With auto, a failed
+// skopeo or podman copy falls back to save | load.
+func TransferImage(srcEngine, dstEngine, imageRef, setting string) error {
+	return TransferImageOut(srcEngine, dstEngine, imageRef, setting, os.Stderr, TransferProgress)
+}
+
+// TransferImageOut is TransferImage writing its output to out, with the
+// progress line of a pipe if progress is set.
+// Package-level var for testability.
+var TransferImageOut = defaultTransferImageOut
+
+func defaultTransferImageOut(srcEngine, dstEngine, imageRef, setting string, out io.Writer, progress bool) error {
+	method, err := transferMethod(setting, srcEngine, dstEngine)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Transferring %s from %s to %s (%s)\n", imageRef, srcEngine, dstEngine, method)
 
-	fmt.Fprintf(os.Stderr, "Transferring %s from %s to %s\n", imageRef, srcEngine, dstEngine)
+	if method == TransferPipe {
+		err = pipeImage(srcEngine, dstEngine, imageRef, out, progress)
+	} else {
+		args := transferArgs(method, srcEngine, dstEngine, imageRef)
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err = cmd.Run(); err != nil {
+			err = fmt.Errorf("%s: %w", strings.Join(args[:2], " "), err)
+			if setting != TransferSkopeo {
+				fmt.Fprintf(out, "Warning: %v; falling back to %s save | %s load\n", err, srcEngine, dstEngine)
+				err = pipeImage(srcEngine, dstEngine, imageRef, out, progress)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
 
-	save := exec.Command(srcBinary, "save", imageRef)
-	load := exec.Command(dstBinary, "load")
+	fmt.Fprintf(out, "Transferred %s to %s\n", imageRef, dstEngine)
+	return nil
+}
+
+// pipeImage pipes an image from one engine to another via save | load,
+// writing the output of load and, if progress is set, a progress line to out
+func pipeImage(srcEngine, dstEngine, imageRef string, out io.Writer, progress bool) error {
+	save := engineCmd(srcEngine, "save", imageRef)
+	load := engineCmd(dstEngine, "load")
 
-	pipe, err := save.StdoutPipe()
+	// The pipe is ours rather than save.StdoutPipe(): load reads it through
+	// the counter in a goroutine of its own, which save.Wait() would cut short
+	// by closing a pipe it owns
+	r, w, err := os.Pipe()
 	if err != nil {
 		return fmt.Errorf("creating pipe: %w", err)
 	}
-	load.Stdin = pipe
-	load.Stderr = os.Stderr
+	defer r.Close()
+	counter := &countingReader{r: r}
+	save.Stdout = w
+	load.Stdin = counter
+	load.Stderr = out
+
+	if progress {
+		total, _ := ImageSize(srcEngine, imageRef)
+		stop := reportProgress(out, counter, total)
+		defer stop()
+	}
 
 	if err := load.Start(); err != nil {
-		return fmt.Errorf("starting %s load: %w", dstBinary, err)
+		w.Close()
+		return fmt.Errorf("starting %s load: %w", EngineBinary(dstEngine), err)
 	}
-	if err := save.Run(); err != nil {
-		return fmt.Errorf("%s save failed: %w", srcBinary, err)
+	saveErr := save.Start()
+	// save holds its own copy of the write end; closing ours lets load see EOF
+	w.Close()
+	if saveErr == nil {
+		saveErr = save.Wait()
 	}
-	if err := load.Wait(); err != nil {
-		return fmt.Errorf("%s load failed: %w", dstBinary, err)
+	loadErr := load.Wait()
+	if saveErr != nil {
+		return fmt.Errorf("%s save failed: %w", EngineBinary(srcEngine), saveErr)
+	}
+	if loadErr != nil {
+		return fmt.Errorf("%s load failed: %w", EngineBinary(dstEngine), loadErr)
 	}
-
-	fmt.Fprintf(os.Stderr, "Transferred %s to %s\n", imageRef, dstEngine)
 	return nil
 }
 
+// TransferProgress enables the progress line of save | load transfers. main
+// turns it on unless --quiet is given or stderr is not a terminal.
+var TransferProgress = false
+
+// isTerminal reports whether f is a terminal
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// ImageSize returns the size of an image in the engine's local store, used to
+// estimate the progress of a transfer.
+// Package-level var for testability.
+var ImageSize = defaultImageSize
+
+func defaultImageSize(engine, imageRef string) (int64, error) {
+	out, err := engineCmd(engine, "image", "inspect", "--format", "{{.Size}}", imageRef).Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// reportProgress rewrites a progress line of the bytes read by counter on out
+// every two seconds. The returned stop function prints the total bytes and
+// elapsed time.
+func reportProgress(out io.Writer, counter *countingReader, total int64) (stop func()) {
+	start := time.Now()
+	ticker := time.NewTicker(2 * time.Second)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		for {
+			select {
+			case <-ticker.C:
+				fmt.Fprintf(out, "\r%s", progressLine(counter.n.Load(), total, time.Since(start)))
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(done)
+		<-finished
+		elapsed := time.Since(start).Round(time.Second)
+		fmt.Fprintf(out, "\r%s in %s\n", formatSize(counter.n.Load()), elapsed)
+	}
+}
+
+// progressLine describes a transfer of n bytes after elapsed, with a
+// percentage of total if known
+func progressLine(n, total int64, elapsed time.Duration) string {
+	line := formatSize(n)
+	if total > 0 {
+		// The saved archive can be bigger than the image size reported
+		pct := n * 100 / total
+		if pct > 99 {
+			pct = 99
+		}
+		line += fmt.Sprintf(" of ~%s (%d%%)", formatSize(total), pct)
+	}
+	if secs := elapsed.Seconds(); secs > 0 {
+		line += fmt.Sprintf(" at %s/s", formatSize(int64(float64(n)/secs)))
+	}
+	return line
+}
+
+// Pull fallbacks (pull_fallback in ~/.config/ov/config.yml)
+const (
+	PullFallbackTrue   = "true"   // pull images found in no local store
+	PullFallbackFalse  = "false"  // never pull
+	PullFallbackAlways = "always" // also pull when the registry has a newer image
+)
+
+// PullImage pulls an image into the engine's local store, writing the
+// engine's output to out.
+// Package-level var for testability.
+var PullImage = defaultPullImage
+
+func defaultPullImage(engine, imageRef string, out io.Writer) error {
+	cmd := engineCmd(engine, "pull", imageRef)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// RemoteDigest returns the digest of an image in its registry.
+// Package-level var for testability.
+var RemoteDigest = defaultRemoteDigest
+
+func defaultRemoteDigest(imageRef string) (string, error) {
+	ref, err := name.ParseReference(imageRef)
+	if err != nil {
+		return "", fmt.Errorf("parsing reference %q: %w", imageRef, err)
+	}
+	desc, err := remote.Head(ref, remote.WithAuthFromKeychain(authn.DefaultKeychain))
+	if err != nil {
+		return "", fmt.Errorf("fetching digest of %s: %w", imageRef, err)
+	}
+	return desc.Digest.String(), nil
+}
+
+// LocalRepoDigests returns the registry digests recorded for an image in the
+// engine's local store ("repo@sha256:...").
+// Package-level var for testability.
+var LocalRepoDigests = defaultLocalRepoDigests
+
+func defaultLocalRepoDigests(engine, imageRef string) ([]string, error) {
+	out, err := engineCmd(engine, "image", "inspect", "--format", "{{range .RepoDigests}}{{println .}}{{end}}", imageRef).Output()
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(out)), nil
+}
+
+// refRegistry returns the registry host of imageRef, or "" if it has none
+// (a first path component without ".", ":" and other than localhost)
+func refRegistry(imageRef string) string {
+	first, _, ok := strings.Cut(imageRef, "/")
+	if !ok || (!strings.ContainsAny(first, ".:") && first != "localhost") {
+		return ""
+	}
+	return first
+}
+
+// pullIfNewer pulls imageRef into the engine's store when the registry's
+// digest is not one the local image was pulled or pushed as. Failures only
+// warn, the local image is still usable.
+func pullIfNewer(engine, imageRef string, out io.Writer) {
+	digest, err := RemoteDigest(imageRef)
+	if err != nil {
+		fmt.Fprintf(out, "Warning: %v; using the local image\n", err)
+		return
+	}
+	local, _ := LocalRepoDigests(engine, imageRef)
+	for _, d := range local {
+		if strings.HasSuffix(d, "@"+digest) {
+			return
+		}
+	}
+	fmt.Fprintf(out, "Pulling %s into %s: the registry has a newer image (%s)\n", imageRef, engine, digest)
+	if err := PullImage(engine, imageRef, out); err != nil {
+		fmt.Fprintf(out, "Warning: pulling %s: %v; using the local image\n", imageRef, err)
+	}
+}
+
 // EnsureImage ensures the image is available in the run engine's local store,
-// transferring from the build engine if needed.
+// transferring it from the build engine if needed. An image found in neither
+// store is pulled from its registry (pull_fallback); with pull_fallback=always
+// a local image is also replaced by a newer one from the registry.
 func EnsureImage(imageRef string, rt *ResolvedRuntime) error {
-	if LocalImageExists(rt.RunEngine, imageRef) {
+	return EnsureImages([]string{imageRef}, rt, 1)
+}
+
+// ensureAction is what EnsureImages does to make an image available
+type ensureAction int
+
+const (
+	ensureNothing     ensureAction = iota
+	ensurePullIfNewer              // in the run engine, pull_fallback=always
+	ensureTransfer                 // in the build engine only
+	ensurePull                     // in neither, pulled from its registry
+	ensureMissing                  // in neither, and not pulled
+)
+
+// planEnsure checks where imageRef is, the run engine first, and returns what
+// makes it available in the run engine
+func planEnsure(imageRef string, rt *ResolvedRuntime) ensureAction {
+	pull := rt.PullFallback != PullFallbackFalse && refRegistry(imageRef) != ""
+	switch {
+	case LocalImageExists(rt.RunEngine, imageRef):
+		if pull && rt.PullFallback == PullFallbackAlways {
+			return ensurePullIfNewer
+		}
+		return ensureNothing
+	case rt.BuildEngine != rt.RunEngine && LocalImageExists(rt.BuildEngine, imageRef):
+		return ensureTransfer
+	case pull:
+		// Not built here, but possibly pushed by CI
+		return ensurePull
+	default:
+		return ensureMissing
+	}
+}
+
+// runEnsure carries out action for imageRef, writing its output to out
+func runEnsure(imageRef string, action ensureAction, rt *ResolvedRuntime, out io.Writer, progress bool) error {
+	var pullErr error
+	switch action {
+	case ensureNothing:
+		return nil
+	case ensurePullIfNewer:
+		pullIfNewer(rt.RunEngine, imageRef, out)
 		return nil
+	case ensureTransfer:
+		return TransferImageOut(rt.BuildEngine, rt.RunEngine, imageRef, rt.Transfer, out, progress)
+	case ensurePull:
+		fmt.Fprintf(out, "Pulling %s into %s\n", imageRef, rt.RunEngine)
+		if pullErr = PullImage(rt.RunEngine, imageRef, out); pullErr == nil {
+			return nil
+		}
 	}
 
-	if rt.BuildEngine == rt.RunEngine {
-		return fmt.Errorf("image %s not found in %s; build it first with: ov build", imageRef, rt.RunEngine)
+	where := rt.RunEngine
+	if rt.BuildEngine != rt.RunEngine {
+		where = rt.RunEngine + " or " + rt.BuildEngine
 	}
+	if pullErr != nil {
+		return fmt.Errorf("image %s not found in %s, and pulling it from %s failed (%v); build it first with: ov build",
+			imageRef, where, refRegistry(imageRef), pullErr)
+	}
+	return fmt.Errorf("image %s not found in %s; build it first with: ov build", imageRef, where)
+}
 
-	if !LocalImageExists(rt.BuildEngine, imageRef) {
-		return fmt.Errorf("image %s not found in %s or %s; build it first with: ov build",
-			imageRef, rt.RunEngine, rt.BuildEngine)
+// EnsureImages ensures every image of refs is available in the run engine's
+// local store, like EnsureImage. It checks where each image is first, in
+// order, then transfers and pulls up to parallel images at once. With more
+// than one at a time, their output is prefixed with the image reference and
+// the progress line of pipes is left out. All failures are reported.
+func EnsureImages(refs []string, rt *ResolvedRuntime, parallel int) error {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	type job struct {
+		ref    string
+		action ensureAction
+	}
+	var jobs []job
+	seen := make(map[string]bool)
+	for _, ref := range refs {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		if action := planEnsure(ref, rt); action != ensureNothing {
+			jobs = append(jobs, job{ref, action})
+		}
 	}
 
-	return TransferImage(rt.BuildEngine, rt.RunEngine, imageRef)
+	errs := make([]error, len(jobs))
+	if parallel == 1 || len(jobs) <= 1 {
+		for i, j := range jobs {
+			errs[i] = runEnsure(j.ref, j.action, rt, os.Stderr, TransferProgress)
+		}
+	} else {
+		var outMu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, parallel)
+		for i, j := range jobs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				pw := newPrefixWriter(os.Stderr, &outMu, "["+j.ref+"] ")
+				defer pw.Flush()
+				errs[i] = runEnsure(j.ref, j.action, rt, pw, false)
+			}()
+		}
+		wg.Wait()
+	}
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		msgs := make([]string, len(failed))
+		for i, err := range failed {
+			msgs[i] = "  " + err.Error()
+		}
+		return fmt.Errorf("%d of %d images failed:\n%s", len(failed), len(seen), strings.Join(msgs, "\n"))
+	}
 }
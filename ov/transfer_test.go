@@ -1,8 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestEnsureImage(t *testing.T) {
@@ -94,3 +104,392 @@ func TestEnsureImage(t *testing.T) {
 		}
 	})
 }
+
+// stubSkopeo makes the skopeo lookup find it or not and resets the cached
+// result, returning the number of lookups made
+func stubSkopeo(t *testing.T, installed bool) *int {
+	t.Helper()
+	orig := exec_LookPath
+	t.Cleanup(func() {
+		exec_LookPath = orig
+		skopeoOnce = sync.Once{}
+	})
+	lookups := 0
+	exec_LookPath = func(name string) (string, error) {
+		lookups++
+		if installed && name == "skopeo" {
+			return "/usr/bin/skopeo", nil
+		}
+		return "", errors.New("not found")
+	}
+	skopeoOnce = sync.Once{}
+	return &lookups
+}
+
+func TestTransferMethod(t *testing.T) {
+	tests := []struct {
+		setting  string
+		skopeo   bool
+		src, dst string
+		want     string
+		wantErr  bool
+	}{
+		{"", true, "docker", "podman", TransferSkopeo, false},
+		{TransferAuto, true, "podman", "docker", TransferSkopeo, false},
+		{TransferAuto, false, "docker", "podman", transferPodman, false},
+		{TransferAuto, false, "podman", "docker", transferPodman, false},
+		{TransferAuto, false, "docker", "docker", TransferPipe, false},
+		{TransferPipe, true, "docker", "podman", TransferPipe, false},
+		{TransferSkopeo, true, "docker", "podman", TransferSkopeo, false},
+		{TransferSkopeo, false, "docker", "podman", "", true},
+	}
+	for _, tt := range tests {
+		stubSkopeo(t, tt.skopeo)
+		got, err := transferMethod(tt.setting, tt.src, tt.dst)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("transferMethod(%q, %s, %s) with skopeo=%v = %q, %v; want %q",
+				tt.setting, tt.src, tt.dst, tt.skopeo, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransferMethodRemoteEndpoints(t *testing.T) {
+	orig := EngineEndpoints
+	defer func() { EngineEndpoints = orig }()
+	stubSkopeo(t, true)
+
+	tests := []struct {
+		setting        string
+		docker, podman string
+		want           string
+		wantErr        string
+	}{
+		{TransferAuto, "ssh://builder", "", TransferPipe, ""},
+		{TransferAuto, "unix:///var/run/docker.sock", "", TransferSkopeo, ""},
+		{TransferAuto, "", "builder", TransferPipe, ""},
+		{TransferPipe, "ssh://builder", "", TransferPipe, ""},
+		{TransferSkopeo, "ssh://builder", "", "", "can't reach the remote docker endpoint"},
+		{TransferAuto, "ssh://builder", "builder", "", "both remote"},
+	}
+	for _, tt := range tests {
+		EngineEndpoints = map[string]string{"docker": tt.docker, "podman": tt.podman}
+		got, err := transferMethod(tt.setting, "docker", "podman")
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("transferMethod(%q) with docker=%q podman=%q error = %v, want %q", tt.setting, tt.docker, tt.podman, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("transferMethod(%q) with docker=%q podman=%q = %q, %v; want %q", tt.setting, tt.docker, tt.podman, got, err, tt.want)
+		}
+	}
+}
+
+func TestTransferMethodCachesLookup(t *testing.T) {
+	lookups := stubSkopeo(t, true)
+	for i := 0; i < 3; i++ {
+		if _, err := transferMethod(TransferAuto, "docker", "podman"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if *lookups != 1 {
+		t.Errorf("skopeo looked up %d times, want 1", *lookups)
+	}
+}
+
+func TestTransferArgs(t *testing.T) {
+	tests := []struct {
+		method, src, dst string
+		want             []string
+	}{
+		{TransferSkopeo, "docker", "podman", []string{"skopeo", "copy", "docker-daemon:app:latest", "containers-storage:app:latest"}},
+		{TransferSkopeo, "podman", "docker", []string{"skopeo", "copy", "containers-storage:app:latest", "docker-daemon:app:latest"}},
+		{transferPodman, "docker", "podman", []string{"podman", "pull", "docker-daemon:app:latest"}},
+		{transferPodman, "podman", "docker", []string{"podman", "push", "app:latest", "docker-daemon:app:latest"}},
+	}
+	for _, tt := range tests {
+		if got := transferArgs(tt.method, tt.src, tt.dst, "app:latest"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("transferArgs(%s, %s, %s) = %v, want %v", tt.method, tt.src, tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestCountingReader(t *testing.T) {
+	counter := &countingReader{r: strings.NewReader(strings.Repeat("x", 10000))}
+	buf := make([]byte, 3000)
+	for {
+		if _, err := counter.Read(buf); err != nil {
+			break
+		}
+	}
+	if got := counter.n.Load(); got != 10000 {
+		t.Errorf("counted %d bytes, want 10000", got)
+	}
+}
+
+func TestPipeImage(t *testing.T) {
+	// Fake engines: podman save writes an archive, docker load stores stdin
+	dir := t.TempDir()
+	loaded := filepath.Join(dir, "loaded")
+	scripts := map[string]string{
+		"podman": "#!/bin/sh\nhead -c 300000 /dev/zero\n",
+		"docker": "#!/bin/sh\ncat > " + loaded + "\necho Loaded image >&2\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	origEndpoints := EngineEndpoints
+	EngineEndpoints = map[string]string{}
+	defer func() { EngineEndpoints = origEndpoints }()
+
+	var out bytes.Buffer
+	if err := pipeImage("podman", "docker", "app:latest", &out, false); err != nil {
+		t.Fatalf("pipeImage() error = %v", err)
+	}
+	info, err := os.Stat(loaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 300000 {
+		t.Errorf("docker load got %d bytes, want 300000", info.Size())
+	}
+	if !strings.Contains(out.String(), "Loaded image") {
+		t.Errorf("load output missing from %q", out.String())
+	}
+}
+
+func TestProgressLine(t *testing.T) {
+	tests := []struct {
+		n, total int64
+		elapsed  time.Duration
+		want     string
+	}{
+		{512 * 1024 * 1024, 0, 4 * time.Second, "512.0 MB at 128.0 MB/s"},
+		{1536 * 1024 * 1024, 6144 * 1024 * 1024, 10 * time.Second, "1.5 GB of ~6.0 GB (25%) at 153.6 MB/s"},
+		// The archive outgrowing the reported size never shows 100%
+		{7 * 1024 * 1024 * 1024, 6 * 1024 * 1024 * 1024, 0, "7.0 GB of ~6.0 GB (99%)"},
+	}
+	for _, tt := range tests {
+		if got := progressLine(tt.n, tt.total, tt.elapsed); got != tt.want {
+			t.Errorf("progressLine(%d, %d, %s) = %q, want %q", tt.n, tt.total, tt.elapsed, got, tt.want)
+		}
+	}
+}
+
+func TestReportProgressFinishes(t *testing.T) {
+	counter := &countingReader{r: strings.NewReader("abc")}
+	var out bytes.Buffer
+	stop := reportProgress(&out, counter, 0)
+	io.Copy(io.Discard, counter)
+	stop()
+	if !strings.HasSuffix(out.String(), "3 B in 0s\n") {
+		t.Errorf("final line = %q, want the total bytes and elapsed time", out.String())
+	}
+}
+
+func TestEnsureImagePull(t *testing.T) {
+	origExists, origPull := LocalImageExists, PullImage
+	origRemote, origLocal := RemoteDigest, LocalRepoDigests
+	defer func() {
+		LocalImageExists, PullImage = origExists, origPull
+		RemoteDigest, LocalRepoDigests = origRemote, origLocal
+	}()
+
+	const ref = "ghcr.io/x/app:latest"
+	var pulls []string
+	stub := func(exists bool, pullErr error) {
+		pulls = nil
+		LocalImageExists = func(engine, r string) bool { return exists }
+		PullImage = func(engine, r string, out io.Writer) error {
+			pulls = append(pulls, engine+" "+r)
+			return pullErr
+		}
+		RemoteDigest = func(r string) (string, error) { return "sha256:new", nil }
+		LocalRepoDigests = func(engine, r string) ([]string, error) {
+			return []string{"ghcr.io/x/app@sha256:old"}, nil
+		}
+	}
+
+	t.Run("missing image pulled", func(t *testing.T) {
+		stub(false, nil)
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", PullFallback: PullFallbackTrue}
+		if err := EnsureImage(ref, rt); err != nil {
+			t.Fatalf("expected the pull to satisfy EnsureImage, got: %v", err)
+		}
+		if !reflect.DeepEqual(pulls, []string{"podman " + ref}) {
+			t.Errorf("pulls = %v, want the run engine pulling %s", pulls, ref)
+		}
+	})
+
+	t.Run("default pulls", func(t *testing.T) {
+		stub(false, nil)
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "docker"}
+		if err := EnsureImage(ref, rt); err != nil || len(pulls) != 1 {
+			t.Errorf("EnsureImage() = %v with pulls %v, want one pull", err, pulls)
+		}
+	})
+
+	t.Run("pull failure", func(t *testing.T) {
+		stub(false, errors.New("manifest unknown"))
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", PullFallback: PullFallbackTrue}
+		err := EnsureImage(ref, rt)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		for _, want := range []string{"podman or docker", "ghcr.io", "manifest unknown", "ov build"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q doesn't mention %q", err, want)
+			}
+		}
+	})
+
+	t.Run("pull disabled", func(t *testing.T) {
+		stub(false, nil)
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", PullFallback: PullFallbackFalse}
+		if err := EnsureImage(ref, rt); err == nil || pulls != nil {
+			t.Errorf("EnsureImage() = %v with pulls %v, want the build error and no pull", err, pulls)
+		}
+	})
+
+	t.Run("no registry", func(t *testing.T) {
+		stub(false, nil)
+		rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", PullFallback: PullFallbackTrue}
+		if err := EnsureImage("app:latest", rt); err == nil || pulls != nil {
+			t.Errorf("EnsureImage() = %v with pulls %v, want the build error and no pull", err, pulls)
+		}
+	})
+
+	t.Run("always pulls newer image", func(t *testing.T) {
+		stub(true, nil)
+		rt := &ResolvedRuntime{BuildEngine: "podman", RunEngine: "podman", PullFallback: PullFallbackAlways}
+		if err := EnsureImage(ref, rt); err != nil {
+			t.Fatal(err)
+		}
+		if len(pulls) != 1 {
+			t.Errorf("pulls = %v, want a pull of the newer remote image", pulls)
+		}
+	})
+
+	t.Run("always keeps current image", func(t *testing.T) {
+		stub(true, nil)
+		RemoteDigest = func(r string) (string, error) { return "sha256:old", nil }
+		rt := &ResolvedRuntime{BuildEngine: "podman", RunEngine: "podman", PullFallback: PullFallbackAlways}
+		if err := EnsureImage(ref, rt); err != nil || pulls != nil {
+			t.Errorf("EnsureImage() = %v with pulls %v, want no pull", err, pulls)
+		}
+	})
+
+	t.Run("true keeps local image", func(t *testing.T) {
+		stub(true, nil)
+		rt := &ResolvedRuntime{BuildEngine: "podman", RunEngine: "podman", PullFallback: PullFallbackTrue}
+		if err := EnsureImage(ref, rt); err != nil || pulls != nil {
+			t.Errorf("EnsureImage() = %v with pulls %v, want no pull", err, pulls)
+		}
+	})
+}
+
+func TestRefRegistry(t *testing.T) {
+	tests := map[string]string{
+		"ghcr.io/x/app:latest":      "ghcr.io",
+		"localhost:5000/app:v1":     "localhost:5000",
+		"localhost/app:v1":          "localhost",
+		"atrawog/app:latest":        "",
+		"app:latest":                "",
+		"registry.local/app@sha256": "registry.local",
+	}
+	for ref, want := range tests {
+		if got := refRegistry(ref); got != want {
+			t.Errorf("refRegistry(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestEnsureImages(t *testing.T) {
+	origExists, origTransfer := LocalImageExists, TransferImageOut
+	defer func() { LocalImageExists, TransferImageOut = origExists, origTransfer }()
+
+	rt := &ResolvedRuntime{BuildEngine: "docker", RunEngine: "podman", PullFallback: PullFallbackFalse}
+	refs := []string{"a:latest", "b:latest", "c:latest", "a:latest"}
+
+	var mu sync.Mutex
+	var checks, transfers []string
+	stub := func(fail map[string]bool) {
+		checks, transfers = nil, nil
+		LocalImageExists = func(engine, ref string) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			checks = append(checks, engine+" "+ref)
+			return engine == "docker" // only in the build engine
+		}
+		TransferImageOut = func(src, dst, ref, setting string, out io.Writer, progress bool) error {
+			if progress {
+				t.Errorf("progress line enabled for a parallel transfer of %s", ref)
+			}
+			fmt.Fprintf(out, "copying %s\n", ref)
+			mu.Lock()
+			transfers = append(transfers, ref)
+			mu.Unlock()
+			if fail[ref] {
+				return fmt.Errorf("transferring %s: no space left on device", ref)
+			}
+			return nil
+		}
+	}
+
+	t.Run("checks in order, transfers in parallel", func(t *testing.T) {
+		stub(nil)
+		stderr := captureStderr(t, func() {
+			if err := EnsureImages(refs, rt, 3); err != nil {
+				t.Fatalf("EnsureImages() error = %v", err)
+			}
+		})
+		want := []string{
+			"podman a:latest", "docker a:latest",
+			"podman b:latest", "docker b:latest",
+			"podman c:latest", "docker c:latest",
+		}
+		if !reflect.DeepEqual(checks, want) {
+			t.Errorf("existence checks = %v, want %v", checks, want)
+		}
+		sort.Strings(transfers)
+		if !reflect.DeepEqual(transfers, []string{"a:latest", "b:latest", "c:latest"}) {
+			t.Errorf("transfers = %v, want each image once", transfers)
+		}
+		if !strings.Contains(stderr, "[b:latest] copying b:latest\n") {
+			t.Errorf("output not prefixed with the image:\n%s", stderr)
+		}
+	})
+
+	t.Run("all failures reported", func(t *testing.T) {
+		stub(map[string]bool{"a:latest": true, "c:latest": true})
+		var err error
+		captureStderr(t, func() { err = EnsureImages(refs, rt, 2) })
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		for _, want := range []string{"2 of 3 images failed", "transferring a:latest", "transferring c:latest"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("error %q doesn't mention %q", err, want)
+			}
+		}
+		if len(transfers) != 3 {
+			t.Errorf("transfers = %v, want the failure of one not to stop the others", transfers)
+		}
+	})
+
+	t.Run("missing image fails without transfers", func(t *testing.T) {
+		stub(nil)
+		LocalImageExists = func(engine, ref string) bool { return ref != "b:latest" }
+		err := EnsureImages(refs, &ResolvedRuntime{BuildEngine: "docker", RunEngine: "docker"}, 4)
+		if err == nil || !strings.Contains(err.Error(), "image b:latest not found in docker") {
+			t.Errorf("EnsureImages() = %v, want b not found", err)
+		}
+		if transfers != nil {
+			t.Errorf("transfers = %v, want none", transfers)
+		}
+	})
+}
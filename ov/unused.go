@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// FindUnusedLayers returns the sorted names of layers no image can install:
+// layers that are neither listed by an image (disabled images and profile
+// add_layers count, as do builder images) nor a transitive dependency of one.
+func FindUnusedLayers(cfg *Config, layers map[string]*Layer) []string {
+	used := make(map[string]bool)
+	var use func(name string)
+	use = func(name string) {
+		if used[name] {
+			return
+		}
+		used[name] = true
+		if layer, ok := layers[name]; ok {
+			for _, dep := range layer.Depends {
+				use(dep)
+			}
+		}
+	}
+
+	for _, l := range cfg.Defaults.Layers {
+		use(l)
+	}
+	for _, img := range cfg.Images {
+		for _, l := range img.Layers {
+			use(l)
+		}
+	}
+	for _, profile := range cfg.Profiles {
+		for _, l := range profile.AddLayers {
+			use(l)
+		}
+		for _, l := range profile.Defaults.Layers {
+			use(l)
+		}
+		for _, img := range profile.Images {
+			for _, l := range img.AddLayers {
+				use(l)
+			}
+		}
+	}
+
+	var unused []string
+	for _, name := range LayerNames(layers) {
+		if !used[name] {
+			unused = append(unused, name)
+		}
+	}
+	return unused
+}
+
+// dirSize returns the total size of the regular files below dir
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// formatSize formats a byte count for listings (e.g. "512 B", "3.4 KB", "12.0 MB", "6.2 GB")
+func formatSize(size int64) string {
+	switch {
+	case size < 1024:
+		return fmt.Sprintf("%d B", size)
+	case size < 1024*1024:
+		return fmt.Sprintf("%.1f KB", float64(size)/1024)
+	case size < 1024*1024*1024:
+		return fmt.Sprintf("%.1f MB", float64(size)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.1f GB", float64(size)/(1024*1024*1024))
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindUnusedLayers(t *testing.T) {
+	cfg, err := LoadConfig("testdata")
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	layers, err := ScanLayers("testdata")
+	if err != nil {
+		t.Fatalf("ScanLayers() error = %v", err)
+	}
+
+	unused := FindUnusedLayers(cfg, layers)
+	has := func(name string) bool {
+		for _, n := range unused {
+			if n == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("orphaned") {
+		t.Errorf("FindUnusedLayers() = %v, want the orphaned layer", unused)
+	}
+	// pixi is listed by images, python is listed by ml-cuda, nodejs by ubuntu-dev
+	for _, name := range []string{"pixi", "python", "nodejs"} {
+		if has(name) {
+			t.Errorf("FindUnusedLayers() reports used layer %q", name)
+		}
+	}
+}
+
+func TestFindUnusedLayersReachability(t *testing.T) {
+	layers := map[string]*Layer{
+		"app":      {Name: "app", Depends: []string{"runtime"}},
+		"runtime":  {Name: "runtime", Depends: []string{"libs"}},
+		"libs":     {Name: "libs"},
+		"tools":    {Name: "tools"},
+		"debug":    {Name: "debug"},
+		"legacy":   {Name: "legacy"},
+		"orphaned": {Name: "orphaned"},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Builder: "builder"},
+		Images: map[string]ImageConfig{
+			"web":     {Layers: []string{"app"}},
+			"builder": {Layers: []string{"tools"}},
+			"old":     {Enabled: boolPtr(false), Layers: []string{"legacy"}},
+		},
+		Profiles: map[string]ProfileConfig{
+			"dev": {AddLayers: []string{"debug"}},
+		},
+	}
+
+	if got, want := FindUnusedLayers(cfg, layers), []string{"orphaned"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindUnusedLayers() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateFailOnUnused(t *testing.T) {
+	layers := map[string]*Layer{
+		"app":      {Name: "app", HasRootYml: true},
+		"orphaned": {Name: "orphaned", HasRootYml: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Base: "quay.io/fedora/fedora:43"},
+		Images:   map[string]ImageConfig{"web": {Layers: []string{"app"}}},
+	}
+	if err := Validate(cfg, layers); err != nil {
+		t.Fatalf("Validate() without fail_on_unused error = %v", err)
+	}
+
+	cfg.FailOnUnused = true
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("Validate() expected error for unused layer")
+	}
+	if want := `layer "orphaned" is not used by any image (fail_on_unused)`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := []struct {
+		size int64
+		want string
+	}{
+		{0, "0 B"},
+		{512, "512 B"},
+		{3482, "3.4 KB"},
+		{12 * 1024 * 1024, "12.0 MB"},
+		{6656 * 1024 * 1024, "6.5 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatSize(tt.size); got != tt.want {
+			t.Errorf("formatSize(%d) = %q, want %q", tt.size, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Labels of service containers started by ov up
+const (
+	LabelService        = "org.overthink.service"         // image name
+	LabelServiceProject = "org.overthink.service.project" // project directory
+)
+
+// UpCmd runs a service image detached, named after the image and project
+type UpCmd struct {
+	Image      string `arg:"" help:"Image name from images.yml"`
+	Workspace  string `short:"w" long:"workspace" default:"." help:"Host path to mount at /workspace (default: current directory)"`
+	Tag        string `long:"tag" default:"latest" help:"Image tag to use (default: latest)"`
+	Restart    string `long:"restart" default:"unless-stopped" enum:"no,on-failure,always,unless-stopped" help:"Restart policy (no, on-failure, always, unless-stopped)"`
+	GPUFlags   `embed:""`
+	RunFlags   `embed:""`
+	StaleFlags `embed:""`
+}
+
+// DownCmd stops and removes the service container of ov up
+type DownCmd struct {
+	Image string `arg:"" help:"Image name from images.yml"`
+}
+
+// PsCmd lists the service containers of ov up
+type PsCmd struct {
+	All bool `short:"a" long:"all" help:"List the service containers of all projects"`
+}
+
+// serviceContainerName returns the container name of ov up for image in the
+// project directory project: ov-<image>-<dir>-<hash> (see projectScope)
+func serviceContainerName(project, image string) string {
+	return fmt.Sprintf("ov-%s-%s", image, projectScope(project))
+}
+
+// RunDetached runs the engine command line args, which starts a detached
+// container, and returns the container id it prints. Package-level var for
+// testability.
+var RunDetached = func(args []string) (string, error) {
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("%s run failed: %w\n%s", args[0], err, strings.TrimSpace(string(out)))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// StopContainer stops the container name. Package-level var for testability.
+var StopContainer = func(engine, name string) error {
+	out, err := engineCmd(engine, "stop", name).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s stop %s: %w: %s", EngineBinary(engine), name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (c *UpCmd) Run() error {
+	absWorkspace, err := filepath.Abs(c.Workspace)
+	if err != nil {
+		return fmt.Errorf("resolving workspace path: %w", err)
+	}
+	info, err := os.Stat(absWorkspace)
+	if err != nil {
+		return fmt.Errorf("workspace path %q: %w", absWorkspace, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("workspace path %q is not a directory", absWorkspace)
+	}
+
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+	engine := rt.RunEngine
+
+	var imageRef string
+	var imageGPU bool
+	var ports []string
+	var shellDefaults *ShellConfig
+	var volumes []VolumeMount
+
+	// Try images.yml first, fall back to image labels
+	dir, _ := os.Getwd()
+	cfg, cfgErr := LoadConfig(dir)
+	if cfgErr == nil {
+		resolved, err := cfg.ResolveImage(c.Image, MetadataTag)
+		if err != nil {
+			return err
+		}
+		layers, err := ScanLayers(dir)
+		if err != nil {
+			return err
+		}
+		volumes, err = CollectImageVolumes(cfg, layers, c.Image, resolved.Home)
+		if err != nil {
+			return err
+		}
+		imageRef = resolveShellImageRef(resolved.Registry, resolved.Name, c.Tag)
+		imageGPU = resolved.GPU
+		ports = resolved.Ports
+		shellDefaults = resolved.Shell
+		if err := c.StaleFlags.EnsureImage(imageRef, rt, dir, c.Image); err != nil {
+			return err
+		}
+	} else {
+		imageRef = resolveShellImageRef("", c.Image, c.Tag)
+		if err := EnsureImage(imageRef, rt); err != nil {
+			return err
+		}
+		meta, err := ExtractMetadata(engine, imageRef)
+		if err != nil {
+			return err
+		}
+		if meta == nil {
+			return fmt.Errorf("image %s has no embedded metadata; run from project directory or rebuild with latest ov", imageRef)
+		}
+		ports = meta.Ports
+		volumes = meta.Volumes
+		if meta.Registry != "" {
+			imageRef = resolveShellImageRef(meta.Registry, c.Image, c.Tag)
+			if err := EnsureImage(imageRef, rt); err != nil {
+				return err
+			}
+		}
+	}
+
+	gpus, _, err := ResolveGPUCount(c.GPUFlags.Spec("auto"), imageGPU)
+	if err != nil {
+		return err
+	}
+	LogGPU(gpus != 0)
+
+	opts := c.RunFlags.Options(shellDefaults)
+	if !opts.PublishesPorts() && len(ports) > 0 {
+		fmt.Fprintf(os.Stderr, "Not publishing %s on network %s\n", strings.Join(ports, ", "), opts.Network)
+		ports = nil
+	}
+	optArgs, err := runOptionArgs(engine, opts, len(ports) > 0)
+	if err != nil {
+		return err
+	}
+
+	name := serviceContainerName(dir, c.Image)
+	args := buildUpArgs(engine, imageRef, absWorkspace, name, c.Image, dir, c.Restart, ports, volumes, gpus, optArgs)
+	id, err := upService(engine, name, args)
+	if err != nil {
+		return err
+	}
+	fmt.Println(id)
+	return nil
+}
+
+// upService starts the service container name with the run command line
+// args and returns its short id. A running container is left alone; a
+// stopped one is removed and created again, so it runs the current image.
+func upService(engine, name string, args []string) (string, error) {
+	switch ContainerStatus(engine, name) {
+	case "":
+	case "running":
+		fmt.Fprintf(os.Stderr, "%s is already up (ov down to stop it)\n", name)
+		return shortContainerID(ContainerID(engine, name)), nil
+	default:
+		if err := RemoveContainer(engine, name); err != nil {
+			return "", err
+		}
+	}
+
+	id, err := RunDetached(args)
+	if err != nil {
+		return "", err
+	}
+	id = shortContainerID(id)
+	fmt.Fprintf(os.Stderr, "Started %s as %s\n", name, id)
+	return id, nil
+}
+
+// shortContainerID returns the first 12 characters of a container id
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// buildUpArgs constructs the detached run command line of ov up: the
+// container is named and labeled for ov ps, restarts by policy restart and
+// runs supervisord with the image's ports and volumes. opts are the run
+// option arguments (see runOptionArgs).
+func buildUpArgs(engine, imageRef, workspace, name, image, project, restart string, ports []string, volumes []VolumeMount, gpus int, opts []string) []string {
+	args := append([]string{EngineBinary(engine)}, EngineArgs(engine)...)
+	args = append(args,
+		"run", "-d",
+		"--name", name,
+		"--restart", restart,
+		"--label", LabelService+"="+image,
+		"--label", LabelServiceProject+"="+project,
+		"-v", fmt.Sprintf("%s:/workspace", workspace),
+		"-w", "/workspace",
+	)
+	args = append(args, GPUCountRunArgs(engine, gpus)...)
+	for _, port := range ports {
+		args = append(args, "-p", localizePort(port))
+	}
+	for _, vol := range volumes {
+		args = append(args, "-v", fmt.Sprintf("%s:%s", vol.VolumeName, vol.ContainerPath))
+	}
+	args = append(args, opts...)
+	args = append(args, imageRef)
+	return append(args, supervisordCmd...)
+}
+
+func (c *DownCmd) Run() error {
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+	dir, _ := os.Getwd()
+	return downService(rt.RunEngine, serviceContainerName(dir, c.Image))
+}
+
+// downService stops and removes the service container name
+func downService(engine, name string) error {
+	switch ContainerStatus(engine, name) {
+	case "":
+		fmt.Fprintf(os.Stderr, "%s is not up\n", name)
+		return nil
+	case "running":
+		if err := StopContainer(engine, name); err != nil {
+			return err
+		}
+	}
+	if err := RemoveContainer(engine, name); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Removed %s\n", name)
+	return nil
+}
+
+func (c *PsCmd) Run() error {
+	rt, err := ResolveRuntime()
+	if err != nil {
+		return err
+	}
+	var filters []string
+	if !c.All {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		filters = append(filters, "label="+LabelServiceProject+"="+dir)
+	}
+	services, err := ListOvContainers(rt.RunEngine, LabelService, LabelServiceProject, filters...)
+	if err != nil {
+		return err
+	}
+	if len(services) == 0 {
+		fmt.Fprintln(os.Stdout, "No service containers")
+		return nil
+	}
+	printOvContainers(os.Stdout, services, "STARTED")
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServiceContainerName(t *testing.T) {
+	a := serviceContainerName("/home/me/Web App", "jupyter")
+	if !strings.HasPrefix(a, "ov-jupyter-web-app-") || len(a) != len("ov-jupyter-web-app-")+8 {
+		t.Errorf("serviceContainerName() = %q, want ov-jupyter-web-app-<hash>", a)
+	}
+	if b := serviceContainerName("/srv/web app", "jupyter"); b == a {
+		t.Errorf("projects in directories of the same name collide: %q", b)
+	}
+	if a == containerName("jupyter") {
+		t.Error("ov up container shares the name of ov start")
+	}
+}
+
+func TestBuildUpArgs(t *testing.T) {
+	vols := []VolumeMount{{VolumeName: "ov-jupyter-data", ContainerPath: "/home/user/data"}}
+	got := buildUpArgs("docker", "ghcr.io/x/jupyter:latest", "/home/me/app", "ov-jupyter-app-0123abcd", "jupyter", "/home/me/app",
+		"unless-stopped", []string{"8888:8888"}, vols, 2, []string{"--memory", "2g"})
+	want := []string{
+		"docker", "run", "-d",
+		"--name", "ov-jupyter-app-0123abcd",
+		"--restart", "unless-stopped",
+		"--label", "org.overthink.service=jupyter",
+		"--label", "org.overthink.service.project=/home/me/app",
+		"-v", "/home/me/app:/workspace",
+		"-w", "/workspace",
+		"--gpus", "2",
+		"-p", "127.0.0.1:8888:8888",
+		"-v", "ov-jupyter-data:/home/user/data",
+		"--memory", "2g",
+		"ghcr.io/x/jupyter:latest",
+	}
+	want = append(want, supervisordCmd...)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("buildUpArgs(docker) =\n  %v\nwant\n  %v", got, want)
+	}
+
+	got = buildUpArgs("podman", "jupyter:latest", "/tmp", "ov-jupyter-tmp-0123abcd", "jupyter", "/tmp", "always", nil, nil, 1, nil)
+	joined := strings.Join(got, " ")
+	if !strings.Contains(joined, "--restart always") || !strings.Contains(joined, "--device nvidia.com/gpu=0") {
+		t.Errorf("buildUpArgs(podman) = %v, want the restart policy and the CDI device", got)
+	}
+	if strings.Contains(joined, "--gpus") {
+		t.Errorf("buildUpArgs(podman) = %v, want no --gpus", got)
+	}
+}
+
+func TestUpDownService(t *testing.T) {
+	origStatus, origID, origRemove, origStop, origRun := ContainerStatus, ContainerID, RemoveContainer, StopContainer, RunDetached
+	defer func() {
+		ContainerStatus, ContainerID, RemoveContainer, StopContainer, RunDetached = origStatus, origID, origRemove, origStop, origRun
+	}()
+
+	status := ""
+	var calls []string
+	ContainerStatus = func(engine, name string) string { return status }
+	ContainerID = func(engine, name string) string { return "aaaaaaaaaaaaaaaa" }
+	RemoveContainer = func(engine, name string) error {
+		calls = append(calls, "rm "+name)
+		return nil
+	}
+	StopContainer = func(engine, name string) error {
+		calls = append(calls, "stop "+name)
+		return nil
+	}
+	RunDetached = func(args []string) (string, error) {
+		calls = append(calls, "run")
+		return "0123456789abcdef0123\n", nil
+	}
+
+	tests := []struct {
+		name, status string
+		wantID       string
+		wantCalls    []string
+	}{
+		{"new", "", "0123456789ab", []string{"run"}},
+		{"running", "running", "aaaaaaaaaaaa", nil},
+		{"stopped", "exited", "0123456789ab", []string{"rm ov-x", "run"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, calls = tt.status, nil
+			id, err := upService("docker", "ov-x", []string{"docker", "run"})
+			if err != nil || id != tt.wantID {
+				t.Errorf("upService() = %q, %v; want %q", id, err, tt.wantID)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %v, want %v", calls, tt.wantCalls)
+			}
+		})
+	}
+
+	RunDetached = func(args []string) (string, error) { return "", errors.New("port in use") }
+	status = ""
+	if _, err := upService("docker", "ov-x", nil); err == nil {
+		t.Error("expected the run error")
+	}
+
+	for _, tt := range []struct {
+		status    string
+		wantCalls []string
+	}{
+		{"", nil},
+		{"running", []string{"stop ov-x", "rm ov-x"}},
+		{"exited", []string{"rm ov-x"}},
+	} {
+		status, calls = tt.status, nil
+		if err := downService("docker", "ov-x"); err != nil {
+			t.Fatalf("downService(%q) error = %v", tt.status, err)
+		}
+		if !reflect.DeepEqual(calls, tt.wantCalls) {
+			t.Errorf("downService(%q) calls = %v, want %v", tt.status, calls, tt.wantCalls)
+		}
+	}
+}
+
+func TestUpCmdShellDefaults(t *testing.T) {
+	origExists, origDetect, origStatus, origRun := LocalImageExists, DetectGPU, ContainerStatus, RunDetached
+	defer func() {
+		LocalImageExists, DetectGPU, ContainerStatus, RunDetached = origExists, origDetect, origStatus, origRun
+	}()
+	LocalImageExists = func(engine, imageRef string) bool { return true }
+	DetectGPU = func() bool { return false }
+	ContainerStatus = func(engine, name string) string { return "" }
+	var got []string
+	RunDetached = func(args []string) (string, error) {
+		got = args
+		return "0123456789abcdef", nil
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	t.Setenv("OV_BUILD_ENGINE", "docker")
+	t.Setenv("OV_RUN_ENGINE", "docker")
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "images.yml"), []byte(`images:
+  app:
+    layers: [tool]
+    shell:
+      memory: 2g
+      cpus: 1.5
+`), 0644)
+	writeLayerFile(t, dir, "tool", "version: '3'\ntasks: {}\n")
+	t.Chdir(dir)
+
+	// The image's shell defaults apply, a flag replaces its option
+	cmd := &UpCmd{Image: "app", Workspace: dir, Tag: "latest", Restart: "no", RunFlags: RunFlags{CPUs: 2}}
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	joined := strings.Join(got, " ")
+	if !strings.Contains(joined, "--memory 2g") || !strings.Contains(joined, "--cpus 2 ") {
+		t.Errorf("ov up args = %v, want --memory 2g from shell and --cpus 2 from the flag", got)
+	}
+}
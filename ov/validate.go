@@ -2,9 +2,16 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path"
+	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
 )
 
 // ValidationError collects multiple validation errors
@@ -39,6 +46,9 @@ func Validate(cfg *Config, layers map[string]*Layer) error {
 	// Validate layers referenced in images
 	validateLayerReferences(cfg, layers, errs)
 
+	// Validate apk images have apk packages in their layers
+	validateApkLayers(cfg, layers, errs)
+
 	// Validate layer contents
 	validateLayerContents(layers, errs)
 
@@ -51,6 +61,9 @@ func Validate(cfg *Config, layers map[string]*Layer) error {
 	// Validate image base references
 	validateBaseReferences(cfg, errs)
 
+	// Validate extends references
+	validateExtends(cfg, errs)
+
 	// Validate no circular dependencies in images
 	validateImageDAG(cfg, layers, errs)
 
@@ -60,39 +73,172 @@ func Validate(cfg *Config, layers map[string]*Layer) error {
 	// Validate routes
 	validateRoutes(cfg, layers, errs)
 
+	// Validate bootc images use systemd units, not supervisord
+	validateBootcServices(cfg, layers, errs)
+
+	// Validate platform-restricted layers
+	validateLayerPlatforms(cfg, layers, errs)
+
+	// Validate images only build for platforms their base builds
+	validateImagePlatforms(cfg, errs)
+
 	// Validate volumes
 	validateVolumes(layers, errs)
 
 	// Validate merge config
 	validateMergeConfig(cfg, errs)
 
+	// Validate ov shell defaults
+	validateShellConfig(cfg, errs)
+
+	// Validate extra labels
+	validateLabels(cfg, errs)
+
+	// Validate entrypoint/cmd
+	validateCommands(cfg, errs)
+
+	// Validate containerfile_pre/containerfile_post snippets
+	validateSnippets(cfg, errs)
+
+	// Validate healthchecks
+	validateHealthchecks(cfg, layers, errs)
+
 	// Validate aliases
 	validateAliases(cfg, layers, errs)
 
 	// Validate builder
 	validateBuilder(cfg, layers, errs)
 
+	// Validate builders include the toolchains their images' layers build with
+	validateBuilderToolchains(cfg, layers, errs)
+
+	// Validate cache_id
+	validateCacheID(cfg, errs)
+
+	// Validate push_intermediates
+	validatePushIntermediates(cfg, errs)
+
+	// Validate intermediate_prefix/intermediate_names
+	validateIntermediateNaming(cfg, errs)
+
+	// Validate intermediates: thresholds
+	validateIntermediateThresholds(cfg, errs)
+
+	// Validate intermediates.pinned
+	validatePinnedIntermediates(cfg, layers, errs)
+
+	// Validate cache_registry
+	validateCacheRegistry(cfg, errs)
+
+	// Validate tag_format/tag_suffix
+	validateTagFormatPlacement(cfg, errs)
+
+	// Validate task_version/task_sha256
+	validateTaskPin(cfg, errs)
+
+	// Validate build secrets
+	validateSecrets(cfg, layers, errs)
+
+	// Validate every layer is used (fail_on_unused)
+	validateUnusedLayers(cfg, layers, errs)
+
 	// Validate no circular dependencies in layers
 	validateLayerDAG(cfg, layers, errs)
 
+	// Capabilities: one provider per image, no conflicting layers
+	validateCapabilities(cfg, layers, errs)
+
 	if errs.HasErrors() {
 		return errs
 	}
 	return nil
 }
 
-// validatePkgValues ensures pkg is "rpm" or "deb"
+// warnBranchConflicts warns about auto intermediates whose images disagree
+// on settings they inherit (see inheritBranchSettings). Only ov validate
+// warns, the commands that compute intermediates stay quiet.
+func warnBranchConflicts(cfg *Config, layers map[string]*Layer) error {
+	images, err := cfg.ResolveAllImages(MetadataTag)
+	if err != nil {
+		return err
+	}
+	images, err = ComputeIntermediates(images, layers, cfg, MetadataTag)
+	if err != nil {
+		return fmt.Errorf("computing intermediates: %w", err)
+	}
+	var names []string
+	for name := range images {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	for _, name := range names {
+		if conflicts := images[name].BranchConflicts; len(conflicts) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: images building on intermediate %s disagree on %s, using the defaults\n", name, strings.Join(conflicts, ", "))
+		}
+	}
+	return nil
+}
+
+// validateUnusedLayers reports layers no image uses when fail_on_unused is set
+func validateUnusedLayers(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	if !cfg.FailOnUnused {
+		return
+	}
+	for _, name := range FindUnusedLayers(cfg, layers) {
+		errs.Add("layer %q is not used by any image (fail_on_unused)", name)
+	}
+}
+
+// validatePkgValues ensures pkg is "rpm", "deb" or "apk"
 func validatePkgValues(cfg *Config, errs *ValidationError) {
-	if cfg.Defaults.Pkg != "" && cfg.Defaults.Pkg != "rpm" && cfg.Defaults.Pkg != "deb" {
-		errs.Add("defaults: pkg must be \"rpm\" or \"deb\", got %q", cfg.Defaults.Pkg)
+	if cfg.Defaults.Pkg != "" && !isValidPkg(cfg.Defaults.Pkg) {
+		errs.Add("defaults: pkg must be \"rpm\", \"deb\" or \"apk\", got %q", cfg.Defaults.Pkg)
 	}
 
 	for name, img := range cfg.Images {
 		if !img.IsEnabled() {
 			continue
 		}
-		if img.Pkg != "" && img.Pkg != "rpm" && img.Pkg != "deb" {
-			errs.Add("image %q: pkg must be \"rpm\" or \"deb\", got %q", name, img.Pkg)
+		if img.Pkg != "" && !isValidPkg(img.Pkg) {
+			errs.Add("image %q: pkg must be \"rpm\", \"deb\" or \"apk\", got %q", name, img.Pkg)
+		}
+	}
+}
+
+// isValidPkg checks if a string is a supported package manager
+func isValidPkg(pkg string) bool {
+	return pkg == "rpm" || pkg == "deb" || pkg == "apk"
+}
+
+// validateApkLayers ensures apk images don't use layers that only declare rpm/deb packages
+func validateApkLayers(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		pkg := img.Pkg
+		if pkg == "" {
+			pkg = cfg.Defaults.Pkg
+		}
+		if pkg != "apk" {
+			continue
+		}
+
+		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+		if err != nil {
+			continue // layer DAG validation will catch this
+		}
+
+		for _, layerName := range resolved {
+			layer := layers[layerName]
+			rpm := layer.RpmConfig()
+			deb := layer.DebConfig()
+			apk := layer.ApkConfig()
+			hasOther := (rpm != nil && len(rpm.Packages) > 0) || (deb != nil && len(deb.Packages) > 0)
+			hasApk := apk != nil && len(apk.Packages) > 0
+			if hasOther && !hasApk {
+				errs.Add("image %q: pkg is \"apk\" but layer %q only declares rpm/deb packages (add an apk section to its layer.yml)", imageName, layerName)
+			}
 		}
 	}
 }
@@ -119,10 +265,16 @@ func validateLayerReferences(cfg *Config, layers map[string]*Layer, errs *Valida
 
 // validateLayerContents validates each layer has required files
 func validateLayerContents(layers map[string]*Layer, errs *ValidationError) {
+	providers := capabilityProviders(layers)
 	for name, layer := range layers {
 		// Layer must have at least one install file
 		if !layer.HasInstallFiles() {
-			errs.Add("layer %q: must have at least one install file (layer.yml rpm/deb packages, root.yml, pixi.toml, pyproject.toml, environment.yml, package.json, Cargo.toml, or user.yml)", name)
+			errs.Add("layer %q: must have at least one install file (layer.yml rpm/deb/apk packages, files/, root.yml, pixi.toml, pyproject.toml, environment.yml, requirements.txt, package.json, Cargo.toml, go.mod, or user.yml)", name)
+		}
+
+		// files_owner must be root or user
+		if owner := layer.FilesOwner(); owner != "root" && owner != "user" {
+			errs.Add("layer %q layer.yml: files_owner must be \"root\" or \"user\", got %q", name, owner)
 		}
 
 		// Cargo.toml requires src/ directory
@@ -130,9 +282,32 @@ func validateLayerContents(layers map[string]*Layer, errs *ValidationError) {
 			errs.Add("layer %q: Cargo.toml requires src/ directory", name)
 		}
 
-		// Validate depends references
+		// requirements.txt is its own pixi manifest, a second one in the same layer would be ignored
+		if layer.HasRequirementsTxt && (layer.HasPixiToml || layer.HasPyprojectToml || layer.HasEnvironmentYml) {
+			errs.Add("layer %q: requirements.txt cannot be combined with pixi.toml, pyproject.toml, or environment.yml (list the packages as pypi-dependencies instead)", name)
+		}
+
+		// go.mod requires Go sources (a main package to install)
+		if layer.HasGoMod && !layer.HasGoSources {
+			errs.Add("layer %q: go.mod requires .go source files (a main package)", name)
+		}
+
+		if layer.sizeHintMB < 0 {
+			errs.Add("layer %q layer.yml: size_hint_mb must be >= 0, got %d", name, layer.sizeHintMB)
+		}
+
+		// priority orders supervisord fragments, 00 is reserved for the header
+		if layer.priority != 0 {
+			if !layer.HasSupervisord {
+				errs.Add("layer %q layer.yml: priority requires a service", name)
+			} else if layer.priority < 1 || layer.priority > 99 {
+				errs.Add("layer %q layer.yml: priority must be between 1 and 99, got %d", name, layer.priority)
+			}
+		}
+
+		// Validate depends references (a layer or a capability some layer provides)
 		for _, dep := range layer.Depends {
-			if _, ok := layers[dep]; !ok {
+			if _, ok := layers[dep]; !ok && len(providers[dep]) == 0 {
 				suggestion := findSimilarName(dep, LayerNames(layers))
 				if suggestion != "" {
 					errs.Add("layer %q depends: unknown layer %q (did you mean %q?)", name, dep, suggestion)
@@ -141,6 +316,44 @@ func validateLayerContents(layers map[string]*Layer, errs *ValidationError) {
 				}
 			}
 		}
+
+		// after entries are soft, but must still name a layer (typos would silently drop the ordering)
+		for _, dep := range layer.After {
+			if _, ok := layers[dep]; !ok {
+				if suggestion := findSimilarName(dep, LayerNames(layers)); suggestion != "" {
+					errs.Add("layer %q after: unknown layer %q (did you mean %q?)", name, dep, suggestion)
+				} else {
+					errs.Add("layer %q after: unknown layer %q", name, dep)
+				}
+			}
+		}
+
+		// A capability named like a layer could never be depended on
+		for _, capability := range layer.Provides {
+			if _, ok := layers[capability]; ok {
+				errs.Add("layer %q provides: %q is a layer name", name, capability)
+			}
+		}
+
+		for _, other := range layer.Conflicts {
+			if _, ok := layers[other]; !ok {
+				if suggestion := findSimilarName(other, LayerNames(layers)); suggestion != "" {
+					errs.Add("layer %q conflicts: unknown layer %q (did you mean %q?)", name, other, suggestion)
+				} else {
+					errs.Add("layer %q conflicts: unknown layer %q", name, other)
+				}
+			}
+		}
+	}
+
+	// Nested layers share the stage namespace: lang/python and lang-python collide
+	stages := make(map[string]string)
+	for _, name := range LayerNames(layers) {
+		stage := layerStageName(name)
+		if other, ok := stages[stage]; ok {
+			errs.Add("layer %q: stage name %q is already used by layer %q", name, stage, other)
+		}
+		stages[stage] = name
 	}
 }
 
@@ -168,6 +381,7 @@ func validatePkgConfig(layers map[string]*Layer, errs *ValidationError) {
 	for name, layer := range layers {
 		rpm := layer.RpmConfig()
 		if rpm != nil {
+			validatePackageSpecs(name, "rpm", rpm.Packages, rpm.lines, errs)
 			// copr without packages is an error
 			if len(rpm.Copr) > 0 && len(rpm.Packages) == 0 {
 				errs.Add("layer %q layer.yml: rpm.copr requires rpm.packages", name)
@@ -177,15 +391,196 @@ func validatePkgConfig(layers map[string]*Layer, errs *ValidationError) {
 				errs.Add("layer %q layer.yml: rpm.repos requires rpm.packages", name)
 			}
 		}
+		deb := layer.DebConfig()
+		if deb != nil {
+			validatePackageSpecs(name, "deb", deb.Packages, deb.lines, errs)
+			if len(deb.Repos) > 0 && len(deb.Packages) == 0 {
+				errs.Add("layer %q layer.yml: deb.repos requires deb.packages", name)
+			}
+			for _, repo := range deb.Repos {
+				if !strings.HasPrefix(repo, "ppa:") && !strings.HasPrefix(repo, "deb ") {
+					errs.Add("layer %q layer.yml: deb.repos entry %q must start with \"ppa:\" or \"deb \"", name, repo)
+				} else if strings.ContainsAny(repo, "'\n") {
+					errs.Add("layer %q layer.yml: deb.repos entry %q must be a single line without quotes", name, repo)
+				}
+			}
+			for _, key := range deb.Keys {
+				if !volumeNameRe.MatchString(key.Name) || key.URL == "" {
+					errs.Add("layer %q layer.yml: deb.keys entries require a name (lowercase, hyphens) and url", name)
+				}
+			}
+		}
+	}
+}
+
+// validatePackageSpecs reports malformed packages entries with their layer.yml line
+func validatePackageSpecs(layerName, section string, entries []string, lines []int, errs *ValidationError) {
+	for i, entry := range entries {
+		spec, err := ParsePackageSpec(entry)
+		if err == nil && section == "deb" && isGroupOrFile(spec.Name) {
+			err = fmt.Errorf("%q: groups and file paths are dnf-only", spec.Name)
+		}
+		if err != nil {
+			if i < len(lines) {
+				errs.Add("layer %q layer.yml line %d: %s.packages: %v", layerName, lines[i], section, err)
+			} else {
+				errs.Add("layer %q layer.yml: %s.packages: %v", layerName, section, err)
+			}
+		}
 	}
 }
 
 // validateBaseReferences ensures base references resolve
 func validateBaseReferences(cfg *Config, errs *ValidationError) {
 	// Base references can be:
-	// 1. External OCI images (always valid)
-	// 2. Names of other images in images.yml (validated by image DAG check)
-	// No additional validation needed here
+	// 1. External OCI images, which must be valid references and should pin
+	//    a tag (an error with strict_base_tags, else a warning)
+	// 2. Names of other images in images.yml (validated by image DAG check),
+	//    which must be enabled when the referencing image is
+	images := cfg.ImageNames()
+	check := func(where, base string) {
+		if _, ok := cfg.Images[base]; ok {
+			return
+		}
+		problem, err := checkExternalBase(base, images)
+		switch {
+		case err != nil:
+			errs.Add("%s: base %q %v", where, base, err)
+		case problem == "":
+		case cfg.StrictBaseTags:
+			errs.Add("%s: base %q %s (strict_base_tags)", where, base, problem)
+		default:
+			fmt.Fprintf(os.Stderr, "Warning: %s: base %q %s; pin a tag (strict_base_tags: true makes this an error)\n", where, base, problem)
+		}
+	}
+
+	if cfg.Defaults.Base != "" {
+		check("defaults", cfg.Defaults.Base)
+	}
+	for _, name := range images {
+		img := cfg.Images[name]
+		base := img.Base
+		if base == "" {
+			base = cfg.Defaults.Base
+		}
+		if baseImg, ok := cfg.Images[base]; ok && !baseImg.IsEnabled() {
+			errs.Add("image %q: base %q is disabled (enable it or change the base)", name, base)
+		}
+		if img.Base != "" {
+			check(fmt.Sprintf("image %q", name), img.Base)
+		}
+	}
+}
+
+// checkExternalBase checks the external base reference base. It must parse
+// as an image reference; a bare name close to one of images is taken for a
+// typo of that image. For a valid reference it returns what is wrong with its
+// tag: none or latest, which float ("" when pinned by tag or digest).
+func checkExternalBase(base string, images []string) (string, error) {
+	if !strings.ContainsAny(base, "/:@") {
+		if suggestion := findSimilarName(base, images); suggestion != "" {
+			return "", fmt.Errorf("is not an image in images.yml (did you mean %q?)", suggestion)
+		}
+	}
+	ref, err := name.ParseReference(base)
+	if err != nil {
+		return "", fmt.Errorf("is not a valid image reference: %v", err)
+	}
+	tag, ok := ref.(name.Tag)
+	if !ok {
+		return "", nil // pinned by digest
+	}
+	if !strings.Contains(base[strings.LastIndex(base, "/")+1:], ":") {
+		return "has no tag, so it floats with latest", nil
+	}
+	if tag.TagStr() == "latest" {
+		return "uses the floating tag latest", nil
+	}
+	return "", nil
+}
+
+// ValidateRemoteBases checks that the external base of every enabled image
+// exists in its registry (ov validate --remote), with one HEAD request per
+// reference
+func ValidateRemoteBases(cfg *Config) error {
+	images, err := cfg.ResolveAllImages(MetadataTag)
+	if err != nil {
+		return err
+	}
+	users := make(map[string][]string)
+	var bases []string
+	for _, imageName := range cfg.ImageNames() {
+		img := images[imageName]
+		if !img.IsExternalBase {
+			continue
+		}
+		if _, ok := users[img.Base]; !ok {
+			bases = append(bases, img.Base)
+		}
+		users[img.Base] = append(users[img.Base], imageName)
+	}
+
+	errs := &ValidationError{}
+	for _, base := range bases {
+		if err := HeadImage(base); err != nil {
+			errs.Add("base %q of %s not found in its registry: %v", base, strings.Join(users[base], ", "), err)
+		}
+	}
+	if errs.HasErrors() {
+		return errs
+	}
+	return nil
+}
+
+// validateExtends checks that extends names an existing image without cycles
+// and that inherit_layers is only used together with extends
+func validateExtends(cfg *Config, errs *ValidationError) {
+	if cfg.Defaults.Extends != "" || cfg.Defaults.InheritLayers {
+		errs.Add("defaults: extends and inherit_layers are per-image only")
+	}
+
+	for name, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		if img.InheritLayers && img.Extends == "" {
+			errs.Add("image %q: inherit_layers requires extends", name)
+		}
+		if img.Extends == "" {
+			continue
+		}
+		if _, ok := cfg.Images[img.Extends]; !ok {
+			errs.Add("image %q: extends unknown image %q", name, img.Extends)
+			continue
+		}
+
+		path := []string{name}
+		seen := map[string]bool{name: true}
+		for cur := img.Extends; cur != ""; cur = cfg.Images[cur].Extends {
+			if _, ok := cfg.Images[cur]; !ok {
+				break // reported for the image that extends it
+			}
+			path = append(path, cur)
+			if seen[cur] {
+				// Report each cycle once, from its first member in name order
+				if cur == name && isFirstName(name, path) {
+					errs.Add("image extends cycle: %s", strings.Join(path, " -> "))
+				}
+				break
+			}
+			seen[cur] = true
+		}
+	}
+}
+
+// isFirstName returns true if no name in names sorts before name
+func isFirstName(name string, names []string) bool {
+	for _, n := range names {
+		if n < name {
+			return false
+		}
+	}
+	return true
 }
 
 // validateImageDAG checks for circular image dependencies
@@ -207,8 +602,10 @@ func validateImageDAG(cfg *Config, layers map[string]*Layer, errs *ValidationErr
 	}
 }
 
-// validateLayerDAG checks for circular layer dependencies
+// validateLayerDAG checks for circular layer dependencies, within each image
+// and in the layer order shared by all images (GlobalLayerOrder)
 func validateLayerDAG(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	before := len(errs.Errors)
 	// Check each image's layers for cycles
 	for imageName, img := range cfg.Images {
 		if !img.IsEnabled() {
@@ -223,6 +620,82 @@ func validateLayerDAG(cfg *Config, layers map[string]*Layer, errs *ValidationErr
 			}
 		}
 	}
+	if len(errs.Errors) > before {
+		return
+	}
+
+	// Orderings of layers from different images must not contradict each
+	// other, or no intermediates can be computed
+	images, err := cfg.ResolveAllImages("test")
+	if err != nil {
+		return // reported by validateImageDAG
+	}
+	if _, err := ResolveImageOrder(images, layers); err != nil {
+		return // image cycles are reported by validateImageDAG
+	}
+	if _, err := GlobalLayerOrder(images, layers); err != nil {
+		if cycleErr, ok := err.(*CycleError); ok {
+			errs.Add("layer order cycle across images: %s", strings.Join(cycleErr.Cycle, " -> "))
+		}
+	}
+}
+
+// validateCapabilities checks the complete layer set of every enabled image
+// (base chain included): a capability has at most one provider, a capability
+// depends on has exactly one, and no two layers conflict.
+func validateCapabilities(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	images, err := cfg.ResolveAllImages("test")
+	if err != nil {
+		return // reported by validateImageDAG
+	}
+	if _, err := ResolveImageOrder(images, layers); err != nil {
+		return // image cycles are reported by validateImageDAG
+	}
+	providers := capabilityProviders(layers)
+
+	for _, imageName := range cfg.ImageNames() {
+		chain, err := imageLayerChain(imageName, images, layers)
+		if err != nil {
+			continue // layer DAG validation will catch this
+		}
+		inImage := make(map[string]bool)
+		for _, layerName := range chain {
+			inImage[layerName] = true
+		}
+
+		provided := make(map[string][]string)
+		var capabilities []string
+		for _, layerName := range chain {
+			for _, capability := range layers[layerName].Provides {
+				if provided[capability] == nil {
+					capabilities = append(capabilities, capability)
+				}
+				provided[capability] = append(provided[capability], layerName)
+			}
+		}
+		for _, capability := range capabilities {
+			if len(provided[capability]) > 1 {
+				errs.Add("image %q: capability %q is provided by layers %s", imageName, capability, strings.Join(provided[capability], ", "))
+			}
+		}
+
+		for _, layerName := range chain {
+			layer := layers[layerName]
+			for _, dep := range layer.Depends {
+				if _, ok := layers[dep]; ok || len(provided[dep]) > 0 {
+					continue
+				}
+				if len(providers[dep]) > 0 {
+					errs.Add("image %q: layer %q depends on %q, but no layer of the image provides it (provided by %s)", imageName, layerName, dep, strings.Join(providers[dep], ", "))
+				}
+			}
+			for _, other := range layer.Conflicts {
+				if inImage[other] {
+					errs.Add("image %q: layer %q conflicts with layer %q", imageName, layerName, other)
+				}
+			}
+		}
+	}
 }
 
 // validatePorts validates port declarations in layers and images
@@ -327,56 +800,655 @@ func validateRoutes(cfg *Config, layers map[string]*Layer, errs *ValidationError
 	}
 }
 
-// validateMergeConfig validates merge configuration
-func validateMergeConfig(cfg *Config, errs *ValidationError) {
-	check := func(name string, m *MergeConfig) {
-		if m == nil {
-			return
+// validateBootcServices rejects supervisord service layers in bootc images,
+// which ship systemd units instead
+func validateBootcServices(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() || !(img.Bootc || cfg.Defaults.Bootc) {
+			continue
 		}
-		if m.MaxMB < 0 {
-			errs.Add("%s: merge max_mb must be > 0, got %d", name, m.MaxMB)
+
+		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+		if err != nil {
+			continue // layer DAG validation will catch this
 		}
-	}
 
-	check("defaults", cfg.Defaults.Merge)
-	for name, img := range cfg.Images {
-		if !img.IsEnabled() {
-			continue
+		for _, layerName := range resolved {
+			if layer, ok := layers[layerName]; ok && layer.HasSupervisord {
+				errs.Add("image %q: bootc image cannot use supervisord service layer %q (ship systemd units in the layer's systemd/ directory instead)", imageName, layerName)
+			}
 		}
-		check(fmt.Sprintf("image %q", name), img.Merge)
 	}
 }
 
-// volumeNameRe matches valid volume names: lowercase alphanumeric + hyphens
-var volumeNameRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+// platformRe matches an OCI platform ("os/arch" or "os/arch/variant")
+var platformRe = regexp.MustCompile(`^[a-z0-9]+/[a-z0-9_]+(/[a-z0-9]+)?$`)
 
-// validateVolumes validates volume declarations in layers
-func validateVolumes(layers map[string]*Layer, errs *ValidationError) {
+// validateLayerPlatforms validates layer.yml platforms. Only RUN steps are guarded
+// by TARGETARCH, so build stages and services (which are not) can't be restricted.
+func validateLayerPlatforms(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
 	for name, layer := range layers {
-		if !layer.HasVolumes {
+		for _, p := range layer.Platforms() {
+			if !platformRe.MatchString(p) {
+				errs.Add("layer %q layer.yml: platform %q must be \"os/arch\" (e.g. linux/amd64)", name, p)
+			}
+		}
+		if len(layer.Platforms()) == 0 {
 			continue
 		}
-		seen := make(map[string]bool)
-		for _, vol := range layer.Volumes() {
-			if vol.Name == "" {
-				errs.Add("layer %q layer.yml volumes: missing required \"name\" field", name)
-			} else if !volumeNameRe.MatchString(vol.Name) {
-				errs.Add("layer %q layer.yml volumes: name %q must be lowercase alphanumeric with hyphens", name, vol.Name)
-			} else if seen[vol.Name] {
-				errs.Add("layer %q layer.yml volumes: duplicate volume name %q", name, vol.Name)
-			} else {
-				seen[vol.Name] = true
+		if layer.PixiManifest() != "" || layer.HasPackageJson || layer.HasCargoToml || layer.HasGoMod {
+			errs.Add("layer %q layer.yml: platforms cannot be combined with build-stage manifests (pixi, package.json, Cargo.toml, go.mod)", name)
+		}
+		if layer.HasSupervisord {
+			errs.Add("layer %q layer.yml: platforms cannot be combined with service", name)
+		}
+	}
+
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		platforms := img.Platforms
+		if len(platforms) == 0 {
+			platforms = cfg.Defaults.Platforms
+		}
+		if len(platforms) == 0 {
+			continue
+		}
+
+		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+		if err != nil {
+			continue // layer DAG validation will catch this
+		}
+
+		for _, layerName := range resolved {
+			layer, ok := layers[layerName]
+			if !ok || len(layer.Platforms()) == 0 {
+				continue
 			}
-			if vol.Path == "" {
-				errs.Add("layer %q layer.yml volumes: missing required \"path\" field", name)
+			if !platformsOverlap(layer.Platforms(), platforms) {
+				errs.Add("image %q: layer %q supports %s but the image builds for %s", imageName, layerName, strings.Join(layer.Platforms(), ", "), strings.Join(platforms, ", "))
 			}
 		}
 	}
 }
 
-// validateAliases validates alias declarations in layers and images
-func validateAliases(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
-	// Validate layer aliases
+// validateImagePlatforms checks that every platform of an image is also built
+// by its internal base
+func validateImagePlatforms(cfg *Config, errs *ValidationError) {
+	platformsOf := func(img ImageConfig) []string {
+		if len(img.Platforms) > 0 {
+			return img.Platforms
+		}
+		return resolvePlatforms(cfg)
+	}
+
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		base := img.Base
+		if base == "" {
+			base = cfg.Defaults.Base
+		}
+		baseImg, ok := cfg.Images[base]
+		if !ok || !baseImg.IsEnabled() {
+			continue
+		}
+		basePlatforms := platformsOf(baseImg)
+		for _, p := range platformsOf(img) {
+			found := false
+			for _, bp := range basePlatforms {
+				if bp == p {
+					found = true
+					break
+				}
+			}
+			if !found {
+				errs.Add("image %q: platform %s is not built by its base %q (%s)", imageName, p, base, strings.Join(basePlatforms, ", "))
+			}
+		}
+	}
+}
+
+// platformsOverlap reports whether any layer platform matches an image platform by os/arch
+func platformsOverlap(layerPlatforms, imagePlatforms []string) bool {
+	osArch := func(p string) string {
+		parts := strings.SplitN(p, "/", 3)
+		if len(parts) < 2 {
+			return p
+		}
+		return parts[0] + "/" + parts[1]
+	}
+	for _, lp := range layerPlatforms {
+		for _, ip := range imagePlatforms {
+			if osArch(lp) == osArch(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// cacheIDRe matches a cache namespace usable in a --mount=type=cache id
+var cacheIDRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// validateCacheID validates the cache mount namespace, which is project-wide
+func validateCacheID(cfg *Config, errs *ValidationError) {
+	if id := cfg.Defaults.CacheID; id != "" && !cacheIDRe.MatchString(id) {
+		errs.Add("defaults: cache_id %q must match %s", id, cacheIDRe.String())
+	}
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() && img.CacheID != "" {
+			errs.Add("image %q: cache_id is only allowed in defaults (caches are shared project-wide)", imageName)
+		}
+	}
+}
+
+// validatePushIntermediates rejects push_intermediates outside defaults
+func validatePushIntermediates(cfg *Config, errs *ValidationError) {
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() && img.PushIntermediates != nil {
+			errs.Add("image %q: push_intermediates is only allowed in defaults (it applies to auto intermediates)", imageName)
+		}
+	}
+}
+
+// intermediatePrefixRe matches a prefix that keeps auto intermediate names valid image names
+var intermediatePrefixRe = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// validateIntermediateNaming validates intermediate_prefix and intermediate_names,
+// which are defaults-only like push_intermediates, and intermediates.base_names
+func validateIntermediateNaming(cfg *Config, errs *ValidationError) {
+	if p := cfg.Defaults.AutoPrefix; p != "" && !intermediatePrefixRe.MatchString(p) {
+		errs.Add("defaults: intermediate_prefix %q must match %s", p, intermediatePrefixRe.String())
+	}
+	switch cfg.Defaults.AutoNaming {
+	case "", IntermediateNamesLastLayer, IntermediateNamesPathHash, IntermediateNamesJoined:
+	default:
+		errs.Add("defaults: intermediate_names %q must be one of %s, %s, %s", cfg.Defaults.AutoNaming,
+			IntermediateNamesLastLayer, IntermediateNamesPathHash, IntermediateNamesJoined)
+	}
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() && img.AutoPrefix != "" {
+			errs.Add("image %q: intermediate_prefix is only allowed in defaults", imageName)
+		}
+		if img.IsEnabled() && img.AutoNaming != "" {
+			errs.Add("image %q: intermediate_names is only allowed in defaults", imageName)
+		}
+	}
+
+	var bases []string
+	for base := range cfg.Intermediates.BaseNames {
+		bases = append(bases, base)
+	}
+	sortStrings(bases)
+	usedBy := make(map[string]string)
+	for _, base := range bases {
+		name := cfg.Intermediates.BaseNames[base]
+		if !intermediatePrefixRe.MatchString(name) {
+			errs.Add("intermediates: base_names %q: %q must match %s", base, name, intermediatePrefixRe.String())
+		} else if other, ok := usedBy[name]; ok {
+			errs.Add("intermediates: base_names %q and %q are both named %q", other, base, name)
+		}
+		usedBy[name] = base
+	}
+}
+
+// validateIntermediateThresholds validates the intermediates: thresholds and
+// order, and that exclude_from_intermediates is set per image
+func validateIntermediateThresholds(cfg *Config, errs *ValidationError) {
+	if cfg.Defaults.NoIntermediates {
+		errs.Add("defaults: exclude_from_intermediates is per-image only")
+	}
+	if n := cfg.Intermediates.MinSharedImages; n < 0 {
+		errs.Add("intermediates: min_shared_images must be >= 0, got %d", n)
+	}
+	if n := cfg.Intermediates.MinSharedLayers; n < 0 {
+		errs.Add("intermediates: min_shared_layers must be >= 0, got %d", n)
+	}
+	switch cfg.Intermediates.OrderBy {
+	case "", OrderByPopularity, OrderBySize:
+	default:
+		errs.Add("intermediates: order_by %q must be %s or %s", cfg.Intermediates.OrderBy, OrderByPopularity, OrderBySize)
+	}
+	if n := cfg.Intermediates.PackageSizeMB; n < 0 {
+		errs.Add("intermediates: package_size_mb must be >= 0, got %d", n)
+	}
+}
+
+// validatePinnedIntermediates checks intermediates.pinned: names must not clash
+// with images, layers must exist, and some image must build on each pinned
+// intermediate unless it sets allow_unused
+func validatePinnedIntermediates(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	pinned := cfg.Intermediates.Pinned
+	if len(pinned) == 0 {
+		return
+	}
+	var names []string
+	for name := range pinned {
+		names = append(names, name)
+	}
+	sortStrings(names)
+
+	valid := true
+	for _, name := range names {
+		pin := pinned[name]
+		if _, ok := cfg.Images[name]; ok {
+			errs.Add("intermediates.pinned.%s: name is already used by an image", name)
+			valid = false
+		}
+		if len(pin.Layers) == 0 {
+			errs.Add("intermediates.pinned.%s: layers must not be empty", name)
+			valid = false
+		}
+		for _, layerName := range pin.Layers {
+			if _, ok := layers[layerName]; ok {
+				continue
+			}
+			valid = false
+			if suggestion := findSimilarName(layerName, LayerNames(layers)); suggestion != "" {
+				errs.Add("intermediates.pinned.%s: layer %q not found (did you mean %q?)", name, layerName, suggestion)
+			} else {
+				errs.Add("intermediates.pinned.%s: layer %q not found", name, layerName)
+			}
+		}
+	}
+	if !valid {
+		return
+	}
+
+	images, err := cfg.ResolveAllImages("test")
+	if err != nil {
+		return // reported by validateImageDAG
+	}
+	_, users, err := applyPinnedIntermediates(images, layers, cfg, "test")
+	if err != nil {
+		return // layer and image cycles are reported elsewhere
+	}
+	for _, name := range names {
+		if len(users[name]) == 0 && !pinned[name].AllowUnused {
+			errs.Add("intermediates.pinned.%s: no image's layer sequence starts with its layers (set allow_unused: true to keep it)", name)
+		}
+	}
+}
+
+// validateTagFormatPlacement ensures tag_format and tag_suffix are only set in
+// defaults (one tag is computed per run). Their values are checked by LoadConfig.
+func validateTagFormatPlacement(cfg *Config, errs *ValidationError) {
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() && img.TagFormat != "" {
+			errs.Add("image %q: tag_format is only allowed in defaults", imageName)
+		}
+		if img.IsEnabled() && img.TagSuffix != "" {
+			errs.Add("image %q: tag_suffix is only allowed in defaults", imageName)
+		}
+	}
+}
+
+var cacheRegistryRe = regexp.MustCompile(`^[a-z0-9]+([._-][a-z0-9]+)*(:[0-9]+)?(/[a-z0-9]+([._-][a-z0-9]+)*)*$`)
+
+// validateCacheRegistry validates the build cache repository (or the "gha" shorthand)
+func validateCacheRegistry(cfg *Config, errs *ValidationError) {
+	check := func(name, ref string) {
+		if ref != "" && ref != "gha" && !cacheRegistryRe.MatchString(ref) {
+			errs.Add("%s: cache_registry %q must be \"gha\" or a repository without tag (e.g. ghcr.io/org/cache)", name, ref)
+		}
+	}
+	check("defaults", cfg.Defaults.CacheRegistry)
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() {
+			check(fmt.Sprintf("image %q", imageName), img.CacheRegistry)
+		}
+	}
+}
+
+var (
+	taskVersionRe = regexp.MustCompile(`^v?[0-9]+\.[0-9]+\.[0-9]+$`)
+	sha256Re      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	archRe        = regexp.MustCompile(`^[a-z0-9_]+$`)
+)
+
+// validateTaskPin validates the go-task version and checksums used by the bootstrap
+func validateTaskPin(cfg *Config, errs *ValidationError) {
+	check := func(name string, img ImageConfig) {
+		if v := img.TaskVersion; v != "" && v != "latest" && !taskVersionRe.MatchString(v) {
+			errs.Add("%s: task_version %q must be a release like \"v3.44.0\" or \"latest\"", name, v)
+		}
+		for arch, sum := range img.TaskSHA256 {
+			if !archRe.MatchString(arch) {
+				errs.Add("%s: task_sha256 key %q must be an architecture (e.g. amd64, arm64)", name, arch)
+			}
+			if !sha256Re.MatchString(sum) {
+				errs.Add("%s: task_sha256 %s must be 64 lowercase hex characters", name, arch)
+			}
+		}
+	}
+
+	check("defaults", cfg.Defaults)
+	for imageName, img := range cfg.Images {
+		if img.IsEnabled() {
+			check(fmt.Sprintf("image %q", imageName), img)
+		}
+	}
+}
+
+var secretIDRe = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._-]*$`)
+
+// validateSecrets validates layer secret ids and the image secrets that provide them
+func validateSecrets(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	declared := make(map[string]bool)
+	for name, layer := range layers {
+		for _, id := range layer.Secrets() {
+			if !secretIDRe.MatchString(id) {
+				errs.Add("layer %q layer.yml: secret %q must match %s", name, id, secretIDRe.String())
+			}
+			declared[id] = true
+		}
+	}
+
+	check := func(name string, secrets []SecretConfig) {
+		seen := make(map[string]bool)
+		for _, s := range secrets {
+			if !secretIDRe.MatchString(s.ID) {
+				errs.Add("%s: secret id %q must match %s", name, s.ID, secretIDRe.String())
+				continue
+			}
+			if seen[s.ID] {
+				errs.Add("%s: duplicate secret %q", name, s.ID)
+			}
+			seen[s.ID] = true
+			if (s.Src == "") == (s.Env == "") {
+				errs.Add("%s: secret %q must set exactly one of src or env", name, s.ID)
+			}
+		}
+	}
+
+	check("defaults", cfg.Defaults.Secrets)
+	for _, s := range cfg.Defaults.Secrets {
+		if s.ID != "" && !declared[s.ID] {
+			errs.Add("defaults: secret %q is not declared by any layer", s.ID)
+		}
+	}
+
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() || len(img.Secrets) == 0 {
+			continue
+		}
+		name := fmt.Sprintf("image %q", imageName)
+		check(name, img.Secrets)
+
+		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+		if err != nil {
+			continue // layer DAG validation will catch this
+		}
+		used := make(map[string]bool)
+		for _, layerName := range resolved {
+			if layer, ok := layers[layerName]; ok {
+				for _, id := range layer.Secrets() {
+					used[id] = true
+				}
+			}
+		}
+		for _, s := range img.Secrets {
+			if s.ID != "" && !used[s.ID] {
+				errs.Add("%s: secret %q is not declared by any of its layers", name, s.ID)
+			}
+		}
+	}
+}
+
+// validateMergeConfig validates merge configuration
+func validateMergeConfig(cfg *Config, errs *ValidationError) {
+	check := func(name string, m *MergeConfig) {
+		if m == nil {
+			return
+		}
+		if m.MaxMB < 0 {
+			errs.Add("%s: merge max_mb must be > 0, got %d", name, m.MaxMB)
+		}
+		if m.MinMB < 0 {
+			errs.Add("%s: merge min_mb must be >= 0, got %d", name, m.MinMB)
+		} else if m.MaxMB > 0 && m.MinMB > m.MaxMB {
+			errs.Add("%s: merge min_mb (%d) must not exceed max_mb (%d)", name, m.MinMB, m.MaxMB)
+		}
+		switch m.Strategy {
+		case "", MergeStrategyGreedy, MergeStrategyPack:
+		default:
+			errs.Add("%s: merge strategy %q must be %s or %s", name, m.Strategy, MergeStrategyGreedy, MergeStrategyPack)
+		}
+	}
+
+	check("defaults", cfg.Defaults.Merge)
+	for name, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		check(fmt.Sprintf("image %q", name), img.Merge)
+	}
+}
+
+// envPatternRe matches the characters of shell env_passthrough patterns:
+// variable names with the glob characters of path.Match
+var envPatternRe = regexp.MustCompile(`^[A-Za-z0-9_*?\[\]^-]+$`)
+
+// validateShellConfig validates the ov shell mounts, ports, run options and
+// env_passthrough patterns. Mount sources are only checked by ov shell, on the
+// host it runs on, and podman-only networks with the engine it runs.
+func validateShellConfig(cfg *Config, errs *ValidationError) {
+	check := func(name string, s *ShellConfig) {
+		if s == nil {
+			return
+		}
+		for _, spec := range s.Mounts {
+			parts := strings.Split(spec, ":")
+			if n := len(parts); n > 1 && (parts[n-1] == "ro" || parts[n-1] == "rw") {
+				parts = parts[:n-1]
+			}
+			switch {
+			case len(parts) > 2 || parts[0] == "":
+				errs.Add("%s: shell mounts: %q must be \"src[:dst][:ro]\"", name, spec)
+			case len(parts) == 2 && !strings.HasPrefix(parts[1], "/"):
+				errs.Add("%s: shell mounts: destination %q in %q must be an absolute path", name, parts[1], spec)
+			}
+		}
+		for _, mapping := range s.Ports {
+			parts := strings.Split(mapping, ":")
+			if len(parts) > 2 {
+				errs.Add("%s: shell ports: %q must be \"port\" or \"host:container\" format", name, mapping)
+				continue
+			}
+			for _, port := range parts {
+				if !isValidPort(port) {
+					errs.Add("%s: shell ports: %q in %q is not a valid port number (1-65535)", name, port, mapping)
+				}
+			}
+		}
+		if s.Network != "" && !networkRe.MatchString(s.Network) {
+			errs.Add("%s: shell network: %q is not a valid network", name, s.Network)
+		}
+		if s.Memory != "" && !sizeRe.MatchString(s.Memory) {
+			errs.Add("%s: shell memory: %q must be a size like 512m or 2g", name, s.Memory)
+		}
+		if s.ShmSize != "" && !sizeRe.MatchString(s.ShmSize) {
+			errs.Add("%s: shell shm_size: %q must be a size like 512m or 2g", name, s.ShmSize)
+		}
+		if s.CPUs < 0 {
+			errs.Add("%s: shell cpus must be >= 0", name)
+		}
+		for _, pattern := range s.EnvPassthrough {
+			if _, err := path.Match(pattern, ""); err != nil || !envPatternRe.MatchString(pattern) {
+				errs.Add("%s: shell env_passthrough: %q must be a variable name or a glob like AWS_*", name, pattern)
+			}
+		}
+	}
+
+	check("defaults", cfg.Defaults.Shell)
+	for name, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		check(fmt.Sprintf("image %q", name), img.Shell)
+	}
+}
+
+// labelKeyRe matches valid label keys: alphanumeric segments separated by dots, hyphens or underscores
+var labelKeyRe = regexp.MustCompile(`^[A-Za-z0-9]+([._-][A-Za-z0-9]+)*$`)
+
+// validateLabels ensures extra label keys are well-formed and stay out of the org.overthink. namespace
+func validateLabels(cfg *Config, errs *ValidationError) {
+	check := func(name string, labels map[string]string) {
+		for key := range labels {
+			if !labelKeyRe.MatchString(key) {
+				errs.Add("%s: label key %q is invalid (use alphanumerics separated by '.', '-' or '_')", name, key)
+				continue
+			}
+			if strings.HasPrefix(key, ReservedLabelPrefix) {
+				errs.Add("%s: label key %q uses the reserved %q prefix", name, key, ReservedLabelPrefix)
+			}
+		}
+	}
+
+	check("defaults", cfg.Defaults.Labels)
+	for name, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		check(fmt.Sprintf("image %q", name), img.Labels)
+	}
+}
+
+// validateCommands ensures entrypoint/cmd are only set per image. Defaults also
+// seed auto-generated intermediates, which must not carry a command.
+func validateCommands(cfg *Config, errs *ValidationError) {
+	if len(cfg.Defaults.Entrypoint) > 0 {
+		errs.Add("defaults: entrypoint is not allowed (defaults apply to auto-generated intermediates; set it per image)")
+	}
+	if len(cfg.Defaults.Cmd) > 0 {
+		errs.Add("defaults: cmd is not allowed (defaults apply to auto-generated intermediates; set it per image)")
+	}
+}
+
+// fromLineRe matches a FROM instruction (case-insensitive, like the Containerfile parser)
+var fromLineRe = regexp.MustCompile(`(?i)^\s*FROM\s`)
+
+// validateSnippets rejects snippets that would break the generated stage structure
+func validateSnippets(cfg *Config, errs *ValidationError) {
+	if cfg.Defaults.ContainerfilePre != "" || cfg.Defaults.ContainerfilePost != "" {
+		errs.Add("defaults: containerfile_pre/containerfile_post are not allowed (defaults apply to auto-generated intermediates; set them per image)")
+	}
+
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		check := func(field, snippet string) {
+			for i, line := range strings.Split(snippet, "\n") {
+				if fromLineRe.MatchString(line) {
+					errs.Add("image %q %s line %d: FROM is not allowed (snippets must not start new stages)", imageName, field, i+1)
+				}
+			}
+		}
+		check("containerfile_pre", img.ContainerfilePre)
+		check("containerfile_post", img.ContainerfilePost)
+	}
+}
+
+// validateHealthchecks validates healthcheck declarations and rejects images whose
+// layers declare conflicting healthchecks without an image-level override
+func validateHealthchecks(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	check := func(name string, hc *HealthcheckConfig) {
+		if len(hc.Cmd) == 0 {
+			errs.Add("%s healthcheck: missing required \"cmd\" field", name)
+		}
+		for field, value := range map[string]string{"interval": hc.Interval, "timeout": hc.Timeout, "start_period": hc.StartPeriod} {
+			if value == "" {
+				continue
+			}
+			if _, err := time.ParseDuration(value); err != nil {
+				errs.Add("%s healthcheck: %s %q is not a valid duration (e.g. \"30s\")", name, field, value)
+			}
+		}
+		if hc.Retries < 0 {
+			errs.Add("%s healthcheck: retries must be >= 0, got %d", name, hc.Retries)
+		}
+	}
+
+	for name, layer := range layers {
+		if layer.HasHealthcheck {
+			check(fmt.Sprintf("layer %q layer.yml", name), layer.Healthcheck())
+		}
+	}
+
+	if cfg.Defaults.Healthcheck != nil {
+		errs.Add("defaults: healthcheck is not allowed (defaults apply to auto-generated intermediates; set it per image)")
+	}
+
+	for imageName, img := range cfg.Images {
+		if !img.IsEnabled() {
+			continue
+		}
+		if img.Healthcheck != nil {
+			check(fmt.Sprintf("image %q", imageName), img.Healthcheck)
+			continue
+		}
+
+		resolved, err := ResolveLayerOrder(img.Layers, layers, nil)
+		if err != nil {
+			continue // layer DAG validation will catch this
+		}
+
+		var declaring []string
+		var first *HealthcheckConfig
+		conflict := false
+		for _, layerName := range resolved {
+			layer, ok := layers[layerName]
+			if !ok || !layer.HasHealthcheck {
+				continue
+			}
+			declaring = append(declaring, layerName)
+			if first == nil {
+				first = layer.Healthcheck()
+			} else if !reflect.DeepEqual(first, layer.Healthcheck()) {
+				conflict = true
+			}
+		}
+		if conflict {
+			errs.Add("image %q: layers %s declare conflicting healthchecks (set healthcheck in images.yml to choose one)", imageName, strings.Join(declaring, ", "))
+		}
+	}
+}
+
+// volumeNameRe matches valid volume names: lowercase alphanumeric + hyphens
+var volumeNameRe = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// validateVolumes validates volume declarations in layers
+func validateVolumes(layers map[string]*Layer, errs *ValidationError) {
+	for name, layer := range layers {
+		if !layer.HasVolumes {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, vol := range layer.Volumes() {
+			if vol.Name == "" {
+				errs.Add("layer %q layer.yml volumes: missing required \"name\" field", name)
+			} else if !volumeNameRe.MatchString(vol.Name) {
+				errs.Add("layer %q layer.yml volumes: name %q must be lowercase alphanumeric with hyphens", name, vol.Name)
+			} else if seen[vol.Name] {
+				errs.Add("layer %q layer.yml volumes: duplicate volume name %q", name, vol.Name)
+			} else {
+				seen[vol.Name] = true
+			}
+			if vol.Path == "" {
+				errs.Add("layer %q layer.yml volumes: missing required \"path\" field", name)
+			}
+		}
+	}
+}
+
+// validateAliases validates alias declarations in layers and images
+func validateAliases(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	// Validate layer aliases
 	for name, layer := range layers {
 		if !layer.HasAliases {
 			continue
@@ -395,6 +1467,9 @@ func validateAliases(cfg *Config, layers map[string]*Layer, errs *ValidationErro
 			if a.Command == "" {
 				errs.Add("layer %q layer.yml aliases: missing required \"command\" field for alias %q", name, a.Name)
 			}
+			validateAliasEnv(fmt.Sprintf("layer %q layer.yml aliases", name), a.Name, a.Env, a.EnvPassthrough, errs)
+			validateAliasCompletion(fmt.Sprintf("layer %q layer.yml aliases", name), a.Name, a.Completion, a.CompletionCommand, errs)
+			validateAliasPorts(fmt.Sprintf("layer %q layer.yml aliases", name), a.Name, a.Ports, errs)
 		}
 	}
 
@@ -417,6 +1492,75 @@ func validateAliases(cfg *Config, layers map[string]*Layer, errs *ValidationErro
 			} else {
 				seen[a.Name] = true
 			}
+			validateAliasEnv(fmt.Sprintf("image %q aliases", imageName), a.Name, a.Env, a.EnvPassthrough, errs)
+			validateAliasCompletion(fmt.Sprintf("image %q aliases", imageName), a.Name, a.Completion, a.CompletionCommand, errs)
+			validateAliasPorts(fmt.Sprintf("image %q aliases", imageName), a.Name, a.Ports, errs)
+		}
+	}
+
+	// Aliases of the same name from two layers of an image: the image must
+	// override them, unless both layers define them the same way
+	for _, imageName := range cfg.ImageNames() {
+		collisions, err := imageAliasCollisions(cfg.Images[imageName], layers)
+		if err != nil {
+			continue // reported by the layer checks
+		}
+		for _, c := range collisions {
+			if c.Identical {
+				fmt.Fprintf(os.Stderr, "Warning: image %q: alias %q is defined the same way by layers %q and %q, using one\n", imageName, c.Alias, c.First, c.Other)
+				continue
+			}
+			errs.Add("image %q: alias %q is defined by layers %q and %q; remove one or override it in the image's aliases", imageName, c.Alias, c.First, c.Other)
+		}
+	}
+}
+
+// validateAliasEnv checks that an alias's env and env_passthrough entries
+// are variable names, which its wrapper script uses unquoted
+func validateAliasEnv(where, alias string, env map[string]string, passthrough []string, errs *ValidationError) {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sortStrings(keys)
+	for _, k := range keys {
+		if !envNameRe.MatchString(k) {
+			errs.Add("%s: alias %q env key %q must match [A-Za-z_][A-Za-z0-9_]*", where, alias, k)
+		}
+	}
+	for _, k := range passthrough {
+		if !envNameRe.MatchString(k) {
+			errs.Add("%s: alias %q env_passthrough entry %q must match [A-Za-z_][A-Za-z0-9_]*", where, alias, k)
+		}
+	}
+}
+
+// validateAliasCompletion checks that an alias's completion names a supported
+// shell and comes with the command printing the completion script
+func validateAliasCompletion(where, alias, completion, command string, errs *ValidationError) {
+	switch {
+	case completion == "" && command != "":
+		errs.Add("%s: alias %q completion_command requires completion", where, alias)
+	case completion == "":
+	case completion != "bash" && completion != "zsh" && completion != "fish":
+		errs.Add("%s: alias %q completion must be \"bash\", \"zsh\" or \"fish\", got %q", where, alias, completion)
+	case command == "":
+		errs.Add("%s: alias %q completion requires completion_command", where, alias)
+	}
+}
+
+// validateAliasPorts checks an alias's ports like image port mappings
+func validateAliasPorts(where, alias string, ports []string, errs *ValidationError) {
+	for _, mapping := range ports {
+		parts := strings.Split(mapping, ":")
+		if len(parts) > 2 {
+			errs.Add("%s: alias %q ports: %q must be \"port\" or \"host:container\" format", where, alias, mapping)
+			continue
+		}
+		for _, port := range parts {
+			if !isValidPort(port) {
+				errs.Add("%s: alias %q ports: %q in %q is not a valid port number (1-65535)", where, alias, port, mapping)
+			}
 		}
 	}
 }
@@ -483,7 +1627,7 @@ func validateBuilder(cfg *Config, layers map[string]*Layer, errs *ValidationErro
 				if !ok {
 					continue
 				}
-				if layer.PixiManifest() != "" || layer.HasPackageJson {
+				if layer.PixiManifest() != "" || layer.HasPackageJson || layer.HasCargoToml {
 					needsBuilder = true
 					break
 				}
@@ -491,9 +1635,113 @@ func validateBuilder(cfg *Config, layers map[string]*Layer, errs *ValidationErro
 		}
 
 		if needsBuilder && resolvedBuilder == "" {
-			errs.Add("image %q: has pixi/npm layers but no builder configured (set defaults.builder or image builder in images.yml)", imageName)
+			errs.Add("image %q: has pixi/npm/cargo layers but no builder configured (set defaults.builder or image builder in images.yml)", imageName)
+		}
+	}
+}
+
+// builderToolchains are the toolchains the build stages run in the builder
+// image: a builder has one when a layer of it provides the capability, or else
+// is the well-known layer of that name
+var builderToolchains = []struct {
+	Capability string
+	Layer      string
+	Manifest   string // what needs it, for messages
+	Needs      func(*Layer) bool
+}{
+	{"pixi", "pixi", "pixi manifest", func(l *Layer) bool { return l.PixiManifest() != "" }},
+	{"nodejs", "nodejs", "package.json", func(l *Layer) bool { return l.HasPackageJson }},
+	{"rust", "rust", "Cargo.toml", func(l *Layer) bool { return l.HasCargoToml }},
+	{"go", "golang", "go.mod", func(l *Layer) bool { return l.HasGoMod }},
+}
+
+// validateBuilderToolchains checks that the builder of every enabled image
+// (base chain included) has the toolchains of the image's own layers, the ones
+// built in builder stages (see ImageNeedsBuilder). Each missing toolchain is
+// reported once per builder, with the images and layers that need it.
+func validateBuilderToolchains(cfg *Config, layers map[string]*Layer, errs *ValidationError) {
+	images, err := cfg.ResolveAllImages("test")
+	if err != nil {
+		return // reported by validateImageDAG
+	}
+
+	type missing struct {
+		builder   string
+		toolchain int // index into builderToolchains
+	}
+	builderLayers := make(map[string]map[string]bool)
+	needed := make(map[missing][][]string) // image name, then the layers needing the toolchain
+	var keys []missing
+	for _, imageName := range cfg.ImageNames() {
+		img := images[imageName]
+		if img == nil || img.Builder == "" || img.Builder == imageName {
+			continue
+		}
+		if _, ok := images[img.Builder]; !ok {
+			continue // reported by validateBuilder
+		}
+
+		var parentLayers map[string]bool
+		if !img.IsExternalBase {
+			if parentLayers, err = LayersProvidedByImage(img.Base, images, layers); err != nil {
+				continue
+			}
+		}
+		own, err := ResolveLayerOrder(img.Layers, layers, parentLayers)
+		if err != nil {
+			continue // reported by validateLayerDAG
+		}
+
+		provided, ok := builderLayers[img.Builder]
+		if !ok {
+			if provided, err = LayersProvidedByImage(img.Builder, images, layers); err != nil {
+				continue
+			}
+			builderLayers[img.Builder] = provided
+		}
+
+		for i, tc := range builderToolchains {
+			var using []string
+			for _, layerName := range own {
+				if layer, ok := layers[layerName]; ok && tc.Needs(layer) {
+					using = append(using, layerName)
+				}
+			}
+			if len(using) == 0 || builderHasToolchain(provided, layers, tc.Capability, tc.Layer) {
+				continue
+			}
+			key := missing{img.Builder, i}
+			if needed[key] == nil {
+				keys = append(keys, key)
+			}
+			needed[key] = append(needed[key], append([]string{imageName}, using...))
+		}
+	}
+
+	for _, key := range keys {
+		tc := builderToolchains[key.toolchain]
+		var users []string
+		for _, u := range needed[key] {
+			noun := "layer"
+			if len(u) > 2 {
+				noun = "layers"
+			}
+			users = append(users, fmt.Sprintf("image %q (%s %s)", u[0], noun, strings.Join(u[1:], ", ")))
+		}
+		errs.Add("builder %q: no %s toolchain for %s layers (add the %q layer or a layer providing %q): %s",
+			key.builder, tc.Capability, tc.Manifest, tc.Layer, tc.Capability, strings.Join(users, "; "))
+	}
+}
+
+// builderHasToolchain reports whether the layer set provided has a layer
+// providing capability, or else the well-known layer fallback
+func builderHasToolchain(provided map[string]bool, layers map[string]*Layer, capability, fallback string) bool {
+	for layerName := range provided {
+		if layer, ok := layers[layerName]; ok && slices.Contains(layer.Provides, capability) {
+			return true
 		}
 	}
+	return provided[fallback]
 }
 
 // isValidPort checks if a string is a valid port number (1-65535)
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
+	"reflect"
 	"strings"
 	"testing"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestValidateSuccess(t *testing.T) {
@@ -123,6 +127,27 @@ func TestValidateCargoWithoutSrc(t *testing.T) {
 	}
 }
 
+func TestValidateRequirementsTxtWithPixiManifest(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{},
+	}
+	layers := map[string]*Layer{
+		"tool": {
+			Name:               "tool",
+			HasPixiToml:        true,
+			HasRequirementsTxt: true,
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for requirements.txt next to pixi.toml")
+	}
+	if !strings.Contains(err.Error(), "requirements.txt cannot be combined") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
 func TestValidateCoprWithoutPackages(t *testing.T) {
 	cfg := &Config{
 		Images: map[string]ImageConfig{},
@@ -226,6 +251,33 @@ func TestValidateLayerCycle(t *testing.T) {
 	}
 }
 
+func TestValidateLayerAfterAcrossImages(t *testing.T) {
+	layers := map[string]*Layer{
+		"a": {Name: "a", HasRootYml: true, After: []string{"b"}},
+		"b": {Name: "b", HasRootYml: true, After: []string{"a"}},
+		"c": {Name: "c", HasRootYml: true},
+	}
+
+	// Opposite after: in images that never share the two layers
+	cfg := &Config{
+		Defaults: ImageConfig{Pkg: "rpm"},
+		Images: map[string]ImageConfig{
+			"one": {Layers: []string{"a"}},
+			"two": {Layers: []string{"b"}},
+		},
+	}
+	if err := Validate(cfg, layers); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+
+	// ...but an image building on the other has both in its chain
+	cfg.Images["two"] = ImageConfig{Base: "one", Layers: []string{"b", "c"}}
+	err := Validate(cfg, layers)
+	if err == nil || !strings.Contains(err.Error(), "layer order cycle across images: a -> b -> a") {
+		t.Errorf("Validate() error = %v, want a layer order cycle", err)
+	}
+}
+
 func TestValidateMultipleErrors(t *testing.T) {
 	cfg := &Config{
 		Defaults: ImageConfig{Pkg: "invalid"},
@@ -662,6 +714,47 @@ func TestValidateAliasesValid(t *testing.T) {
 	}
 }
 
+func TestValidateAliasCollisions(t *testing.T) {
+	layers := map[string]*Layer{
+		"node":   {Name: "node", HasUserYml: true, HasAliases: true, aliases: []AliasYAML{{Name: "cli", Command: "node-cli"}, {Name: "npx", Command: "npx"}}},
+		"python": {Name: "python", HasUserYml: true, HasAliases: true, aliases: []AliasYAML{{Name: "cli", Command: "py-cli"}}},
+		"npm":    {Name: "npm", HasUserYml: true, HasAliases: true, aliases: []AliasYAML{{Name: "npx", Command: "npx"}}},
+	}
+
+	t.Run("conflict", func(t *testing.T) {
+		cfg := &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"node", "python"}}}}
+		err := Validate(cfg, layers)
+		if err == nil || !strings.Contains(err.Error(), `image "app": alias "cli" is defined by layers "node" and "python"`) {
+			t.Errorf("Validate() error = %v, want the alias collision", err)
+		}
+	})
+
+	t.Run("image override", func(t *testing.T) {
+		cfg := &Config{Images: map[string]ImageConfig{"app": {
+			Layers:  []string{"node", "python"},
+			Aliases: []AliasConfig{{Name: "cli", Command: "py-cli"}},
+		}}}
+		if err := Validate(cfg, layers); err != nil {
+			t.Errorf("Validate() unexpected error: %v", err)
+		}
+	})
+
+	t.Run("identical", func(t *testing.T) {
+		cfg := &Config{Images: map[string]ImageConfig{"app": {Layers: []string{"node", "npm"}}}}
+		if err := Validate(cfg, layers); err != nil {
+			t.Errorf("Validate() unexpected error: %v", err)
+		}
+		collisions, err := imageAliasCollisions(cfg.Images["app"], layers)
+		if want := []aliasCollision{{Alias: "npx", First: "node", Other: "npm", Identical: true}}; err != nil || !reflect.DeepEqual(collisions, want) {
+			t.Errorf("imageAliasCollisions() = %+v, %v; want %+v", collisions, err, want)
+		}
+		aliases, err := CollectImageAliases(cfg, layers, "app")
+		if err != nil || len(aliases) != 2 {
+			t.Errorf("CollectImageAliases() = %+v, %v; want cli and one npx", aliases, err)
+		}
+	})
+}
+
 func TestValidateAliasesMissingName(t *testing.T) {
 	cfg := &Config{
 		Images: map[string]ImageConfig{},
@@ -778,6 +871,67 @@ func TestValidateImageAliasesDuplicate(t *testing.T) {
 	}
 }
 
+func TestValidateAliasEnvNames(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"test": {
+				Layers: []string{"svc"},
+				Aliases: []AliasConfig{
+					{Name: "mycli", Env: map[string]string{"OK_1": "x", "BAD-KEY": "y"}, EnvPassthrough: []string{"HOME", "$(id)"}},
+				},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"svc": {Name: "svc", HasUserYml: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for invalid env names")
+	}
+	for _, want := range []string{`env key "BAD-KEY"`, `env_passthrough entry "$(id)"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "OK_1") || strings.Contains(err.Error(), `"HOME"`) {
+		t.Errorf("valid names reported: %v", err)
+	}
+}
+
+func TestValidateAliasCompletion(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"test": {
+				Layers: []string{"svc"},
+				Aliases: []AliasConfig{
+					{Name: "ok", Completion: "zsh", CompletionCommand: "ok completion zsh"},
+					{Name: "shell", Completion: "tcsh", CompletionCommand: "shell completion"},
+					{Name: "nocmd", Completion: "bash"},
+					{Name: "noshell", CompletionCommand: "noshell completion bash"},
+				},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"svc": {Name: "svc", HasUserYml: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for invalid completions")
+	}
+	for _, want := range []string{`alias "shell" completion must be`, `alias "nocmd" completion requires completion_command`, `alias "noshell" completion_command requires completion`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't mention %s: %v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), `alias "ok"`) {
+		t.Errorf("valid completion reported: %v", err)
+	}
+}
+
 func TestValidateSelfBuilder(t *testing.T) {
 	cfg := &Config{
 		Images: map[string]ImageConfig{
@@ -887,3 +1041,1017 @@ func TestLevenshteinDistance(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateApkLayerWithoutApkPackages(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"alpine": {
+				Base:   "alpine:3.20",
+				Pkg:    "apk",
+				Layers: []string{"tools", "portable"},
+			},
+		},
+	}
+	layers := map[string]*Layer{
+		"tools": {
+			Name:      "tools",
+			rpmConfig: &RpmConfig{Packages: []string{"git"}},
+			debConfig: &DebConfig{Packages: []string{"git"}},
+		},
+		"portable": {
+			Name:      "portable",
+			rpmConfig: &RpmConfig{Packages: []string{"jq"}},
+			apkConfig: &ApkConfig{Packages: []string{"jq"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for apk image with rpm/deb-only layer")
+	}
+	if !strings.Contains(err.Error(), `layer "tools" only declares rpm/deb packages`) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if strings.Contains(err.Error(), `"portable"`) {
+		t.Errorf("layer with apk packages should not be rejected: %v", err)
+	}
+}
+
+func TestValidateApkPkgAccepted(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Pkg: "apk"},
+		Images: map[string]ImageConfig{
+			"alpine": {Base: "alpine:3.20", Layers: []string{"tools"}},
+		},
+	}
+	layers := map[string]*Layer{
+		"tools": {
+			Name:      "tools",
+			apkConfig: &ApkConfig{Packages: []string{"git"}},
+		},
+	}
+
+	if err := Validate(cfg, layers); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+}
+
+func TestValidateFilesOnlyLayer(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"test": {Layers: []string{"dotfiles"}},
+		},
+	}
+	layers := map[string]*Layer{
+		"dotfiles": {Name: "dotfiles", HasFiles: true},
+	}
+
+	if err := Validate(cfg, layers); err != nil {
+		t.Errorf("Validate() unexpected error: %v", err)
+	}
+}
+
+func TestValidateFilesOwnerInvalid(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{},
+	}
+	layers := map[string]*Layer{
+		"dotfiles": {Name: "dotfiles", HasFiles: true, filesOwner: "nobody"},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for invalid files_owner")
+	}
+	if !strings.Contains(err.Error(), "files_owner must be") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{
+			Labels: map[string]string{"org.overthink.image": "spoofed"},
+		},
+		Images: map[string]ImageConfig{
+			"app": {Labels: map[string]string{"bad key": "x", "com.example.team": "infra"}},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected label validation errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `defaults: label key "org.overthink.image" uses the reserved`) {
+		t.Errorf("missing reserved prefix error: %v", msg)
+	}
+	if !strings.Contains(msg, `image "app": label key "bad key" is invalid`) {
+		t.Errorf("missing invalid key error: %v", msg)
+	}
+	if strings.Contains(msg, "com.example.team") {
+		t.Errorf("valid label key rejected: %v", msg)
+	}
+}
+
+func TestValidateDefaultsCommandRejected(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{
+			Entrypoint: Command{"/usr/bin/tini", "--"},
+			Cmd:        Command{"supervisord"},
+		},
+		Images: map[string]ImageConfig{
+			"app": {Cmd: Command{"my-server"}},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected error for entrypoint/cmd in defaults")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "defaults: entrypoint is not allowed") || !strings.Contains(msg, "defaults: cmd is not allowed") {
+		t.Errorf("unexpected error: %v", msg)
+	}
+	if strings.Contains(msg, `image "app"`) {
+		t.Errorf("per-image cmd should be accepted: %v", msg)
+	}
+}
+
+func TestValidateDebRepos(t *testing.T) {
+	layers := map[string]*Layer{
+		"ok":      {Name: "ok", debConfig: &DebConfig{Packages: []string{"jq"}, Repos: []string{"ppa:owner/name"}}},
+		"no-pkgs": {Name: "no-pkgs", HasRootYml: true, debConfig: &DebConfig{Repos: []string{"ppa:owner/name"}}},
+		"bad":     {Name: "bad", debConfig: &DebConfig{Packages: []string{"jq"}, Repos: []string{"https://apt.example.com stable main"}, Keys: []DebKey{{Name: "example"}}}},
+	}
+
+	err := Validate(&Config{Images: map[string]ImageConfig{}}, layers)
+	if err == nil {
+		t.Fatal("expected deb repo errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`layer "no-pkgs" layer.yml: deb.repos requires deb.packages`,
+		`layer "bad" layer.yml: deb.repos entry "https://apt.example.com stable main" must start with "ppa:" or "deb "`,
+		`layer "bad" layer.yml: deb.keys entries require a name`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in: %v", want, msg)
+		}
+	}
+	if strings.Contains(msg, `"ok"`) {
+		t.Errorf("valid deb repos rejected: %v", msg)
+	}
+}
+
+func TestValidatePackageSpecs(t *testing.T) {
+	var rpm RpmConfig
+	if err := yaml.Unmarshal([]byte("packages:\n  - git\n\n  - nodejs=\n  - \"!vim=9\"\n"), &rpm); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+	layers := map[string]*Layer{
+		"tools": {Name: "tools", rpmConfig: &rpm},
+	}
+
+	err := Validate(&Config{Images: map[string]ImageConfig{}}, layers)
+	if err == nil {
+		t.Fatal("expected malformed package errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`layer "tools" layer.yml line 4: rpm.packages: invalid version constraint ""`,
+		`layer "tools" layer.yml line 5: rpm.packages: exclusion "!vim=9" cannot have a version constraint`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in: %v", want, msg)
+		}
+	}
+	if strings.Contains(msg, `"git"`) {
+		t.Errorf("valid package rejected: %v", msg)
+	}
+
+	// Groups and file provides are dnf-only
+	var deb DebConfig
+	if err := yaml.Unmarshal([]byte("packages:\n  - \"@build-essential\"\n"), &deb); err != nil {
+		t.Fatalf("yaml.Unmarshal() error = %v", err)
+	}
+	err = Validate(&Config{Images: map[string]ImageConfig{}}, map[string]*Layer{"tools": {Name: "tools", debConfig: &deb}})
+	if err == nil || !strings.Contains(err.Error(), `layer "tools" layer.yml line 2: deb.packages: "@build-essential": groups and file paths are dnf-only`) {
+		t.Errorf("Validate() = %v, want the dnf-only error", err)
+	}
+}
+
+func TestValidateLayerPlatforms(t *testing.T) {
+	layers := map[string]*Layer{
+		"cuda":     {Name: "cuda", HasRootYml: true, platforms: []string{"linux/amd64"}},
+		"bad-plat": {Name: "bad-plat", HasRootYml: true, platforms: []string{"amd64"}},
+		"cuda-py":  {Name: "cuda-py", HasPixiToml: true, platforms: []string{"linux/amd64"}},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Platforms: []string{"linux/amd64", "linux/arm64"}},
+		Images: map[string]ImageConfig{
+			"gpu": {Layers: []string{"cuda"}},
+			"arm": {Platforms: []string{"linux/arm64"}, Layers: []string{"cuda"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected platform errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`layer "bad-plat" layer.yml: platform "amd64" must be "os/arch"`,
+		`layer "cuda-py" layer.yml: platforms cannot be combined with build-stage manifests`,
+		`image "arm": layer "cuda" supports linux/amd64 but the image builds for linux/arm64`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in: %v", want, msg)
+		}
+	}
+	if strings.Contains(msg, `image "gpu"`) {
+		t.Errorf("multi-platform image with an amd64-only layer should be valid: %v", msg)
+	}
+}
+
+func TestValidateCacheID(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{CacheID: "my project"},
+		Images: map[string]ImageConfig{
+			"app": {CacheID: "app"},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected cache_id errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `defaults: cache_id "my project" must match`) {
+		t.Errorf("missing pattern error: %v", msg)
+	}
+	if !strings.Contains(msg, `image "app": cache_id is only allowed in defaults`) {
+		t.Errorf("missing per-image error: %v", msg)
+	}
+}
+
+func TestValidateSecrets(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Secrets: []SecretConfig{{ID: "npmrc", Src: "~/.npmrc"}}},
+		Images: map[string]ImageConfig{
+			"app":   {Layers: []string{"tool"}, Secrets: []SecretConfig{{ID: "repo-token", Env: "REPO_TOKEN"}}},
+			"other": {Layers: []string{"plain"}, Secrets: []SecretConfig{{ID: "repo-token", Src: "token", Env: "TOKEN"}}},
+		},
+	}
+	layers := map[string]*Layer{
+		"tool":  {Name: "tool", HasRootYml: true, secrets: []string{"repo-token"}},
+		"plain": {Name: "plain", HasRootYml: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected secret errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`defaults: secret "npmrc" is not declared by any layer`,
+		`image "other": secret "repo-token" must set exactly one of src or env`,
+		`image "other": secret "repo-token" is not declared by any of its layers`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in: %v", want, msg)
+		}
+	}
+	if strings.Contains(msg, `image "app"`) {
+		t.Errorf("unexpected error for image app: %v", msg)
+	}
+}
+
+func TestValidateCacheRegistry(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{CacheRegistry: "ghcr.io/overthinkos/cache"},
+		Images: map[string]ImageConfig{
+			"ci":     {CacheRegistry: "gha"},
+			"local":  {CacheRegistry: "localhost:5000/cache"},
+			"tagged": {CacheRegistry: "ghcr.io/overthinkos/cache:latest"},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected cache_registry error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `image "tagged": cache_registry "ghcr.io/overthinkos/cache:latest" must be`) {
+		t.Errorf("missing tag error: %v", msg)
+	}
+	for _, ok := range []string{`defaults: cache_registry`, `image "ci"`, `image "local"`} {
+		if strings.Contains(msg, ok) {
+			t.Errorf("unexpected error for %s: %v", ok, msg)
+		}
+	}
+}
+
+func TestValidatePushIntermediates(t *testing.T) {
+	off := false
+	cfg := &Config{
+		Defaults: ImageConfig{PushIntermediates: &off},
+		Images: map[string]ImageConfig{
+			"app": {PushIntermediates: &off},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected push_intermediates error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `image "app": push_intermediates is only allowed in defaults`) {
+		t.Errorf("missing per-image error: %v", msg)
+	}
+	if strings.Contains(msg, "defaults: push_intermediates") {
+		t.Errorf("unexpected defaults error: %v", msg)
+	}
+}
+
+func TestValidateIntermediateNaming(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{AutoPrefix: "Ov_", AutoNaming: "random"},
+		Images: map[string]ImageConfig{
+			"app": {AutoPrefix: "ov-int-", AutoNaming: IntermediateNamesJoined},
+		},
+		Intermediates: IntermediatesConfig{BaseNames: map[string]string{
+			"fedora:42": "f4x", "fedora:43": "f4x", "debian:13": "Debian",
+		}},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected intermediate naming errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`defaults: intermediate_prefix "Ov_" must match`,
+		`defaults: intermediate_names "random" must be one of last-layer, path-hash, joined`,
+		`image "app": intermediate_prefix is only allowed in defaults`,
+		`image "app": intermediate_names is only allowed in defaults`,
+		`intermediates: base_names "debian:13": "Debian" must match`,
+		`intermediates: base_names "fedora:42" and "fedora:43" are both named "f4x"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing error %q in: %v", want, msg)
+		}
+	}
+
+	cfg.Defaults = ImageConfig{AutoPrefix: "ov-int-", AutoNaming: IntermediateNamesPathHash}
+	cfg.Images = map[string]ImageConfig{"app": {}}
+	cfg.Intermediates.BaseNames = map[string]string{"fedora:42": "f42", "fedora:43": "f43"}
+	if err := Validate(cfg, map[string]*Layer{}); err != nil {
+		t.Errorf("Validate() error = %v, want valid defaults accepted", err)
+	}
+}
+
+func TestValidateIntermediateThresholds(t *testing.T) {
+	cfg := &Config{
+		Defaults:      ImageConfig{NoIntermediates: true},
+		Intermediates: IntermediatesConfig{MinSharedImages: -1, MinSharedLayers: -2, OrderBy: "weight", PackageSizeMB: -1},
+		Images:        map[string]ImageConfig{"app": {}},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected threshold errors")
+	}
+	for _, want := range []string{
+		"intermediates: min_shared_images must be >= 0, got -1",
+		"intermediates: min_shared_layers must be >= 0, got -2",
+		"defaults: exclude_from_intermediates is per-image only",
+		`intermediates: order_by "weight" must be popularity or size`,
+		"intermediates: package_size_mb must be >= 0, got -1",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in: %v", want, err)
+		}
+	}
+
+	cfg.Defaults = ImageConfig{}
+	cfg.Images["app"] = ImageConfig{NoIntermediates: true}
+	cfg.Intermediates = IntermediatesConfig{MinSharedImages: 3, MinSharedLayers: 2, OrderBy: OrderBySize, PackageSizeMB: 25}
+	if err := Validate(cfg, map[string]*Layer{}); err != nil {
+		t.Errorf("Validate() error = %v, want thresholds accepted", err)
+	}
+}
+
+func TestValidatePinnedIntermediates(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":   {Name: "pixi", HasRootYml: true},
+		"python": {Name: "python", Depends: []string{"pixi"}, HasRootYml: true},
+		"nodejs": {Name: "nodejs", HasRootYml: true},
+	}
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"app": {Layers: []string{"python"}},
+			"web": {Layers: []string{"nodejs"}},
+		},
+		Intermediates: IntermediatesConfig{Pinned: map[string]PinnedIntermediate{
+			"app":      {Layers: []string{"pixi"}},
+			"empty":    {},
+			"typo":     {Layers: []string{"pyhton"}},
+			"py-base":  {Layers: []string{"python"}},
+			"node-py":  {Layers: []string{"nodejs", "python"}},
+			"spare-py": {Layers: []string{"nodejs", "python"}, AllowUnused: true},
+		}},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected pinned intermediate errors")
+	}
+	for _, want := range []string{
+		"intermediates.pinned.app: name is already used by an image",
+		"intermediates.pinned.empty: layers must not be empty",
+		`intermediates.pinned.typo: layer "pyhton" not found (did you mean "python"?)`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in: %v", want, err)
+		}
+	}
+
+	// Dead pinned intermediates are only checked once the definitions are valid
+	for _, name := range []string{"app", "empty", "typo"} {
+		delete(cfg.Intermediates.Pinned, name)
+	}
+	err = Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected unused pinned intermediate error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "intermediates.pinned.node-py: no image's layer sequence starts with its layers") {
+		t.Errorf("missing unused error for node-py: %v", msg)
+	}
+	if strings.Contains(msg, "py-base") || strings.Contains(msg, "spare-py") {
+		t.Errorf("unexpected error for a used or allow_unused intermediate: %v", msg)
+	}
+}
+
+func TestValidateTaskPin(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{TaskVersion: "v3.44.0", TaskSHA256: map[string]string{"amd64": "abc"}},
+		Images: map[string]ImageConfig{
+			"app":      {TaskVersion: "main"},
+			"floating": {TaskVersion: "latest"},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected task pin errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `defaults: task_sha256 amd64 must be 64 lowercase hex characters`) {
+		t.Errorf("missing checksum error: %v", msg)
+	}
+	if !strings.Contains(msg, `image "app": task_version "main" must be a release`) {
+		t.Errorf("missing version error: %v", msg)
+	}
+	if strings.Contains(msg, `"floating"`) || strings.Contains(msg, "defaults: task_version") {
+		t.Errorf("valid task versions rejected: %v", msg)
+	}
+}
+
+func TestValidateSnippets(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{ContainerfilePost: "RUN true"},
+		Images: map[string]ImageConfig{
+			"app":  {ContainerfilePre: "RUN rpm --import /tmp/key.asc", ContainerfilePost: "RUN true\n  from alpine AS x\n"},
+			"good": {ContainerfilePre: "# FROM is fine in a comment\nRUN echo FROM"},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected snippet errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, "defaults: containerfile_pre/containerfile_post are not allowed") {
+		t.Errorf("missing defaults error: %v", msg)
+	}
+	if !strings.Contains(msg, `image "app" containerfile_post line 2: FROM is not allowed`) {
+		t.Errorf("missing FROM error: %v", msg)
+	}
+	if strings.Contains(msg, `"good"`) || strings.Contains(msg, "containerfile_pre line") {
+		t.Errorf("valid snippets rejected: %v", msg)
+	}
+}
+
+func TestValidateHealthcheckConflict(t *testing.T) {
+	layers := map[string]*Layer{
+		"web": {Name: "web", HasRootYml: true, HasHealthcheck: true, healthcheck: &HealthcheckConfig{Cmd: Command{"web-ping"}}},
+		"api": {Name: "api", HasRootYml: true, HasHealthcheck: true, healthcheck: &HealthcheckConfig{Cmd: Command{"api-ping"}}},
+	}
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"conflict": {Layers: []string{"web", "api"}},
+			"override": {Layers: []string{"web", "api"}, Healthcheck: &HealthcheckConfig{Cmd: Command{"true"}}},
+			"single":   {Layers: []string{"web"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected conflicting healthcheck error")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `image "conflict": layers api, web declare conflicting healthchecks`) &&
+		!strings.Contains(msg, `image "conflict": layers web, api declare conflicting healthchecks`) {
+		t.Errorf("unexpected error: %v", msg)
+	}
+	if strings.Contains(msg, `"override"`) || strings.Contains(msg, `"single"`) {
+		t.Errorf("override/single images should be valid: %v", msg)
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	layers := map[string]*Layer{
+		"python":     {Name: "python", HasRootYml: true, Provides: []string{"python-runtime"}},
+		"pypy":       {Name: "pypy", HasRootYml: true, Provides: []string{"python-runtime"}},
+		"scripts":    {Name: "scripts", HasRootYml: true, Depends: []string{"python-runtime"}},
+		"nodejs":     {Name: "nodejs", HasRootYml: true, Conflicts: []string{"nodejs-lts"}},
+		"nodejs-lts": {Name: "nodejs-lts", HasRootYml: true},
+	}
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"base":       {Base: "quay.io/fedora/fedora:43", Layers: []string{"python"}},
+			"derived":    {Base: "base", Layers: []string{"scripts"}},
+			"own":        {Base: "quay.io/fedora/fedora:43", Layers: []string{"pypy", "scripts"}},
+			"missing":    {Base: "quay.io/fedora/fedora:43", Layers: []string{"scripts"}},
+			"twice":      {Base: "base", Layers: []string{"pypy"}},
+			"conflicted": {Base: "quay.io/fedora/fedora:43", Layers: []string{"nodejs-lts", "nodejs"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected capability errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`image "missing": layer "scripts" depends on "python-runtime", but no layer of the image provides it (provided by pypy, python)`,
+		`image "twice": capability "python-runtime" is provided by layers python, pypy`,
+		`image "conflicted": layer "nodejs" conflicts with layer "nodejs-lts"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing error %q in:\n%s", want, msg)
+		}
+	}
+	for _, valid := range []string{`image "base"`, `image "derived"`, `image "own"`} {
+		if strings.Contains(msg, valid) {
+			t.Errorf("%s should be valid: %v", valid, msg)
+		}
+	}
+}
+
+func TestValidateBuilderToolchains(t *testing.T) {
+	layers := map[string]*Layer{
+		"pixi":         {Name: "pixi", HasRootYml: true},
+		"nodejs":       {Name: "nodejs", HasRootYml: true},
+		"rust-nightly": {Name: "rust-nightly", HasRootYml: true, Provides: []string{"rust"}},
+		"golang":       {Name: "golang", HasRootYml: true},
+		"notebook":     {Name: "notebook", HasPixiToml: true},
+		"webapp":       {Name: "webapp", HasPackageJson: true},
+		"tool":         {Name: "tool", HasCargoToml: true, HasSrcDir: true},
+		"gotool":       {Name: "gotool", HasGoMod: true, HasGoSources: true},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Builder: "builder"},
+		Images: map[string]ImageConfig{
+			"builder-base": {Base: "quay.io/fedora/fedora:43", Layers: []string{"pixi"}},
+			"builder":      {Base: "builder-base", Layers: []string{"nodejs"}},
+			"full":         {Base: "quay.io/fedora/fedora:43", Layers: []string{"rust-nightly", "golang"}},
+			"py":           {Base: "quay.io/fedora/fedora:43", Layers: []string{"notebook", "webapp"}},
+			"cli":          {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool"}},
+			"cli2":         {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool", "gotool"}},
+			"own":          {Base: "quay.io/fedora/fedora:43", Layers: []string{"tool", "gotool"}, Builder: "full"},
+			"derived":      {Base: "own", Layers: []string{"webapp"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected builder toolchain errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`builder "builder": no rust toolchain for Cargo.toml layers (add the "rust" layer or a layer providing "rust"): image "cli" (layer tool); image "cli2" (layer tool)`,
+		`builder "builder": no go toolchain for go.mod layers (add the "golang" layer or a layer providing "go"): image "cli2" (layer gotool)`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing error %q in:\n%s", want, msg)
+		}
+	}
+	if n := len(err.(*ValidationError).Errors); n != 2 {
+		t.Errorf("got %d errors, want 2 (base chain and provides count, inherited layers are the base's):\n%s", n, msg)
+	}
+}
+
+func TestValidateCapabilityNames(t *testing.T) {
+	layers := map[string]*Layer{
+		"python": {Name: "python", HasRootYml: true, Provides: []string{"python"}, Conflicts: []string{"nodjs"}},
+		"nodejs": {Name: "nodejs", HasRootYml: true},
+	}
+	cfg := &Config{Images: map[string]ImageConfig{}}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected provides/conflicts errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`layer "python" provides: "python" is a layer name`,
+		`layer "python" conflicts: unknown layer "nodjs" (did you mean "nodejs"?)`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing error %q in:\n%s", want, msg)
+		}
+	}
+}
+
+func TestValidateHealthcheckInvalid(t *testing.T) {
+	layers := map[string]*Layer{
+		"web": {Name: "web", HasRootYml: true, HasHealthcheck: true, healthcheck: &HealthcheckConfig{Interval: "often", Retries: -1}},
+	}
+	cfg := &Config{
+		Defaults: ImageConfig{Healthcheck: &HealthcheckConfig{Cmd: Command{"true"}}},
+		Images:   map[string]ImageConfig{},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected healthcheck errors")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		`layer "web" layer.yml healthcheck: missing required "cmd" field`,
+		`layer "web" layer.yml healthcheck: interval "often" is not a valid duration`,
+		`layer "web" layer.yml healthcheck: retries must be >= 0, got -1`,
+		`defaults: healthcheck is not allowed`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %q in: %v", want, msg)
+		}
+	}
+}
+
+func TestValidateGoModWithoutSources(t *testing.T) {
+	cfg := &Config{Images: map[string]ImageConfig{}}
+	layers := map[string]*Layer{
+		"gotool": {Name: "gotool", HasGoMod: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for go.mod without sources")
+	}
+	if !strings.Contains(err.Error(), "go.mod requires .go source files") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateServicePriority(t *testing.T) {
+	layers := map[string]*Layer{
+		"early":  {Name: "early", HasUserYml: true, HasSupervisord: true, priority: 10},
+		"late":   {Name: "late", HasUserYml: true, HasSupervisord: true, priority: 100},
+		"no-svc": {Name: "no-svc", HasUserYml: true, priority: 20},
+	}
+	cfg := &Config{Images: map[string]ImageConfig{}}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected priority errors")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `layer "late" layer.yml: priority must be between 1 and 99, got 100`) {
+		t.Errorf("missing range error: %v", msg)
+	}
+	if !strings.Contains(msg, `layer "no-svc" layer.yml: priority requires a service`) {
+		t.Errorf("missing service error: %v", msg)
+	}
+	if strings.Contains(msg, `"early"`) {
+		t.Errorf("priority 10 should be valid: %v", msg)
+	}
+}
+
+func TestValidateBootcSupervisord(t *testing.T) {
+	layers := map[string]*Layer{
+		"api":         {Name: "api", HasRootYml: true, HasSupervisord: true},
+		"hello-units": {Name: "hello-units", HasSystemd: true},
+	}
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"os":      {Bootc: true, Layers: []string{"api", "hello-units"}},
+			"service": {Layers: []string{"api"}},
+		},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("expected error for supervisord layer in bootc image")
+	}
+	msg := err.Error()
+	if !strings.Contains(msg, `image "os": bootc image cannot use supervisord service layer "api"`) {
+		t.Errorf("unexpected error: %v", msg)
+	}
+	if strings.Contains(msg, `"service"`) || strings.Contains(msg, "hello-units") {
+		t.Errorf("non-bootc service image and systemd layer should be valid: %v", msg)
+	}
+}
+
+func TestValidateDisabledBase(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"fedora": {Enabled: boolPtr(false), Layers: []string{"pixi"}},
+			"app":    {Base: "fedora", Layers: []string{"pixi"}},
+			"old":    {Enabled: boolPtr(false), Base: "fedora", Layers: []string{"pixi"}},
+		},
+	}
+	layers := map[string]*Layer{
+		"pixi": {Name: "pixi", HasRootYml: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("Validate() expected error for enabled image on a disabled base")
+	}
+	want := `image "app": base "fedora" is disabled (enable it or change the base)`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+	if strings.Contains(err.Error(), `image "old"`) {
+		t.Errorf("disabled image should not be reported: %v", err)
+	}
+
+	cfg.IncludeDisabled()
+	if err := Validate(cfg, layers); err != nil {
+		t.Errorf("Validate() after IncludeDisabled() error = %v", err)
+	}
+}
+
+func TestValidateExtends(t *testing.T) {
+	tests := []struct {
+		name   string
+		images map[string]ImageConfig
+		want   []string
+	}{
+		{
+			name: "cycle",
+			images: map[string]ImageConfig{
+				"a": {Extends: "b", Layers: []string{"pixi"}},
+				"b": {Extends: "c", Layers: []string{"pixi"}},
+				"c": {Extends: "a", Layers: []string{"pixi"}},
+			},
+			want: []string{"image extends cycle: a -> b -> c -> a"},
+		},
+		{
+			name: "self",
+			images: map[string]ImageConfig{
+				"a": {Extends: "a", Layers: []string{"pixi"}},
+			},
+			want: []string{"image extends cycle: a -> a"},
+		},
+		{
+			name: "unknown image",
+			images: map[string]ImageConfig{
+				"a": {Extends: "missing", Layers: []string{"pixi"}},
+			},
+			want: []string{`image "a": extends unknown image "missing"`},
+		},
+		{
+			name: "inherit_layers without extends",
+			images: map[string]ImageConfig{
+				"a": {InheritLayers: true, Layers: []string{"pixi"}},
+			},
+			want: []string{`image "a": inherit_layers requires extends`},
+		},
+	}
+	layers := map[string]*Layer{
+		"pixi": {Name: "pixi", HasRootYml: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{Images: tt.images}
+			cfg.applyExtends()
+			err := Validate(cfg, layers)
+			if err == nil {
+				t.Fatal("Validate() expected error")
+			}
+			for _, want := range tt.want {
+				if strings.Count(err.Error(), want) != 1 {
+					t.Errorf("error = %v, want exactly one %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateImagePlatforms(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Platforms: []string{"linux/amd64", "linux/arm64"}},
+		Images: map[string]ImageConfig{
+			"fedora": {Layers: []string{"pixi"}},
+			"nvidia": {Base: "fedora", Layers: []string{"pixi"}, Platforms: []string{"linux/amd64"}},
+			"cuda":   {Base: "nvidia", Layers: []string{"pixi"}},
+			"tool":   {Base: "nvidia", Layers: []string{"pixi"}, Platforms: []string{"linux/amd64"}},
+		},
+	}
+	layers := map[string]*Layer{
+		"pixi": {Name: "pixi", HasRootYml: true},
+	}
+
+	err := Validate(cfg, layers)
+	if err == nil {
+		t.Fatal("Validate() expected error for a platform the base doesn't build")
+	}
+	want := `image "cuda": platform linux/arm64 is not built by its base "nvidia" (linux/amd64)`
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want it to contain %q", err, want)
+	}
+	for _, name := range []string{`"nvidia"`, `"tool"`} {
+		if strings.Contains(err.Error(), "image "+name) {
+			t.Errorf("image %s should be valid: %v", name, err)
+		}
+	}
+}
+
+func TestValidateShellConfig(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Shell: &ShellConfig{Mounts: []string{"a:b:c:ro", "data:/data:ro", "~/src"}, Network: "slirp4netns", Memory: "2g", ShmSize: "big", CPUs: -1}},
+		Images: map[string]ImageConfig{
+			"app": {Shell: &ShellConfig{Mounts: []string{"src:relative"}, Ports: []string{"3000", "1:2:3", "0:80"},
+				EnvPassthrough: []string{"AWS_*", "SSH_AUTH_SOCK", "[Hh]ttp_proxy", "AWS_[", "MY VAR"}}},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected shell errors")
+	}
+	for _, want := range []string{
+		`defaults: shell mounts: "a:b:c:ro" must be "src[:dst][:ro]"`,
+		`image "app": shell mounts: destination "relative" in "src:relative" must be an absolute path`,
+		`image "app": shell ports: "1:2:3" must be "port" or "host:container" format`,
+		`image "app": shell ports: "0" in "0:80" is not a valid port number`,
+		`image "app": shell env_passthrough: "AWS_[" must be a variable name or a glob`,
+		`defaults: shell shm_size: "big" must be a size`,
+		`defaults: shell cpus must be >= 0`,
+		`image "app": shell env_passthrough: "MY VAR" must be a variable name or a glob`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	for _, ok := range []string{"data:/data:ro", "~/src", `"3000"`, `"AWS_*"`, `"SSH_AUTH_SOCK"`, `"[Hh]ttp_proxy"`, "slirp4netns", `"2g"`} {
+		if strings.Contains(err.Error(), ok) {
+			t.Errorf("valid entry %s rejected: %v", ok, err)
+		}
+	}
+}
+
+func TestValidateMergeConfig(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Merge: &MergeConfig{MaxMB: 64, MinMB: 128, Strategy: "best"}},
+		Images: map[string]ImageConfig{
+			"app": {Merge: &MergeConfig{MinMB: -1}},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil {
+		t.Fatal("expected merge errors")
+	}
+	for _, want := range []string{
+		"defaults: merge min_mb (128) must not exceed max_mb (64)",
+		`defaults: merge strategy "best" must be greedy or pack`,
+		`image "app": merge min_mb must be >= 0, got -1`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing error %q in: %v", want, err)
+		}
+	}
+
+	cfg.Defaults.Merge = &MergeConfig{MaxMB: 256, MinMB: 16, Strategy: MergeStrategyPack}
+	cfg.Images["app"] = ImageConfig{}
+	if err := Validate(cfg, map[string]*Layer{}); err != nil {
+		t.Errorf("Validate() error = %v, want merge settings accepted", err)
+	}
+}
+
+func TestCheckExternalBase(t *testing.T) {
+	images := []string{"fedora-base", "nvidia"}
+	tests := []struct {
+		base        string
+		wantProblem string
+		wantErr     string
+	}{
+		{"quay.io/fedora/fedora:43", "", ""},
+		{"localhost:5000/base:1.0", "", ""},
+		{"ubuntu@sha256:" + strings.Repeat("a", 64), "", ""},
+		{"ubuntu", "has no tag, so it floats with latest", ""},
+		{"localhost:5000/base", "has no tag, so it floats with latest", ""},
+		{"docker.io/library/debian:latest", "uses the floating tag latest", ""},
+		{"quay.io/Fedora/fedora:43", "", "is not a valid image reference"},
+		{"fedora:4 3", "", "is not a valid image reference"},
+		{"ubuntu:", "", "is not a valid image reference"},
+		{"fedora-bsae", "", `is not an image in images.yml (did you mean "fedora-base"?)`},
+		{"nvidai", "", `(did you mean "nvidia"?)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.base, func(t *testing.T) {
+			problem, err := checkExternalBase(tt.base, images)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("checkExternalBase() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || problem != tt.wantProblem {
+				t.Errorf("checkExternalBase() = %q, %v; want %q", problem, err, tt.wantProblem)
+			}
+		})
+	}
+}
+
+func TestValidateStrictBaseTags(t *testing.T) {
+	cfg := &Config{
+		Defaults: ImageConfig{Base: "quay.io/fedora/fedora:latest"},
+		Images: map[string]ImageConfig{
+			"base": {},
+			"app":  {Base: "base"},
+			"tool": {Base: "alpine"},
+			"typo": {Base: "bsae"},
+		},
+	}
+
+	err := Validate(cfg, map[string]*Layer{})
+	if err == nil || !strings.Contains(err.Error(), `image "typo": base "bsae" is not an image in images.yml (did you mean "base"?)`) {
+		t.Fatalf("Validate() error = %v, want the suggestion", err)
+	}
+	if strings.Contains(err.Error(), "floating") || strings.Contains(err.Error(), "no tag") {
+		t.Errorf("floating tags are errors without strict_base_tags: %v", err)
+	}
+
+	cfg.StrictBaseTags = true
+	err = Validate(cfg, map[string]*Layer{})
+	for _, want := range []string{
+		`defaults: base "quay.io/fedora/fedora:latest" uses the floating tag latest (strict_base_tags)`,
+		`image "tool": base "alpine" has no tag, so it floats with latest (strict_base_tags)`,
+	} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), `image "app"`) {
+		t.Errorf("internal base reported: %v", err)
+	}
+}
+
+func TestValidateRemoteBases(t *testing.T) {
+	orig := HeadImage
+	defer func() { HeadImage = orig }()
+	var checked []string
+	HeadImage = func(ref string) error {
+		checked = append(checked, ref)
+		if ref == "quay.io/fedora/fedroa:43" {
+			return errors.New("MANIFEST_UNKNOWN")
+		}
+		return nil
+	}
+
+	cfg := &Config{
+		Defaults: ImageConfig{Base: "quay.io/fedora/fedora:43"},
+		Images: map[string]ImageConfig{
+			"base": {},
+			"app":  {Base: "base"},
+			"one":  {Base: "quay.io/fedora/fedroa:43"},
+			"two":  {Base: "quay.io/fedora/fedroa:43"},
+		},
+	}
+	err := ValidateRemoteBases(cfg)
+	if err == nil || !strings.Contains(err.Error(), `base "quay.io/fedora/fedroa:43" of one, two not found in its registry: MANIFEST_UNKNOWN`) {
+		t.Errorf("ValidateRemoteBases() error = %v", err)
+	}
+	if want := []string{"quay.io/fedora/fedora:43", "quay.io/fedora/fedroa:43"}; !reflect.DeepEqual(checked, want) {
+		t.Errorf("checked %v, want each external base once: %v", checked, want)
+	}
+}
+
+func TestWarnBranchConflictsReportsErrors(t *testing.T) {
+	cfg := &Config{
+		Images: map[string]ImageConfig{
+			"a": {Layers: []string{"missing"}},
+			"b": {Layers: []string{"missing"}},
+		},
+	}
+	if err := warnBranchConflicts(cfg, map[string]*Layer{}); err == nil {
+		t.Error("expected the error computing intermediates")
+	}
+}
@@ -2,6 +2,11 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -29,3 +34,145 @@ func ComputeCalVerAt(t time.Time) string {
 	// No leading zeros on components (valid semver)
 	return fmt.Sprintf("%d.%d.%d", year, dayOfYear, hhmm)
 }
+
+// tagCounter is the tag_format placeholder for the per-period build counter
+const tagCounter = "{n}"
+
+// ComputeTag computes the tag for images built at t. Without defaults.tag_format
+// this is CalVer. A {n} counter in the format is one higher than the highest
+// counter of the local image tags with the same rendered format. With
+// tag_suffix: git, the commit of the project in dir is appended (gitTagSuffix).
+func ComputeTag(cfg *Config, dir string, t time.Time) string {
+	suffix := ""
+	if cfg.Defaults.TagSuffix == "git" {
+		suffix, _ = gitTagSuffix(dir)
+	}
+
+	format := cfg.Defaults.TagFormat
+	if format == "" {
+		return ComputeCalVerAt(t) + suffix
+	}
+	var existing []string
+	if strings.Contains(format, tagCounter) {
+		engine := "docker"
+		if rt, err := ResolveRuntime(); err == nil {
+			engine = rt.BuildEngine
+		}
+		tags, err := LocalImageTags(engine)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: listing %s image tags for the tag counter: %v\n", engine, err)
+		}
+		// Counters continue across commits: ignore the git suffix of earlier builds
+		for _, tag := range tags {
+			existing = append(existing, gitSuffixRe.ReplaceAllString(tag, ""))
+		}
+	}
+	return ComputeTagAt(format, t, existing) + suffix
+}
+
+// gitSuffixRe matches the suffix gitTagSuffix appends
+var gitSuffixRe = regexp.MustCompile(`-g[0-9a-f]+(-dirty)?$`)
+
+// GitRevision returns the HEAD commit of the git repository at dir and whether
+// the worktree has uncommitted changes.
+// Package-level var for testability (same pattern as LocalImageExists in transfer.go).
+var GitRevision = defaultGitRevision
+
+func defaultGitRevision(dir string) (string, bool, error) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false, err
+	}
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain").Output()
+	if err != nil {
+		return "", false, err
+	}
+	return strings.TrimSpace(string(out)), len(strings.TrimSpace(string(status))) > 0, nil
+}
+
+// gitTagSuffix returns the tag suffix "-g<shortsha>" ("-dirty" appended for
+// uncommitted changes) and the full commit sha. Without git or a repository
+// both are empty and a warning is printed.
+func gitTagSuffix(dir string) (string, string) {
+	sha, dirty, err := GitRevision(dir)
+	if err != nil || len(sha) < 7 {
+		fmt.Fprintf(os.Stderr, "Warning: tag_suffix: git: no git revision for %s, tags get no suffix\n", dir)
+		return "", ""
+	}
+	suffix := "-g" + sha[:7]
+	if dirty {
+		suffix += "-dirty"
+	}
+	return suffix, sha
+}
+
+// ComputeTagAt renders a tag_format (Go time layout plus {n}) for t. {n} becomes
+// the highest counter among existing tags of the same rendered format plus one,
+// or 1 if there are none.
+func ComputeTagAt(format string, t time.Time, existing []string) string {
+	parts := strings.Split(format, tagCounter)
+	for i, part := range parts {
+		parts[i] = t.Format(part)
+	}
+	if len(parts) == 1 {
+		return parts[0]
+	}
+
+	quoted := make([]string, len(parts))
+	for i, part := range parts {
+		quoted[i] = regexp.QuoteMeta(part)
+	}
+	re := regexp.MustCompile("^" + strings.Join(quoted, "([0-9]+)") + "$")
+	n := 1
+	for _, tag := range existing {
+		m := re.FindStringSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		for _, s := range m[1:] {
+			if v, err := strconv.Atoi(s); err == nil && v >= n {
+				n = v + 1
+			}
+		}
+	}
+	return strings.Join(parts, strconv.Itoa(n))
+}
+
+// validateTagFormat checks that a tag_format contains a date or time token,
+// no placeholder other than {n}, and renders to characters valid in an image tag
+func validateTagFormat(format string) error {
+	rest := format
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unterminated placeholder %q", rest[start:])
+		}
+		if token := rest[start : start+end+1]; token != tagCounter {
+			return fmt.Errorf("unknown placeholder %q (only %s is supported)", token, tagCounter)
+		}
+		rest = rest[start+end+1:]
+	}
+
+	layout := strings.ReplaceAll(format, tagCounter, "")
+	t1 := time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC)
+	t2 := time.Date(2009, 10, 11, 12, 13, 14, 0, time.UTC)
+	if t1.Format(layout) == t2.Format(layout) {
+		return fmt.Errorf("%q has no date or time token (e.g. 2006, 01, 02, 1504)", format)
+	}
+
+	sample := ComputeTagAt(format, t2, nil)
+	for i, r := range sample {
+		valid := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || (i > 0 && (r == '.' || r == '-'))
+		if !valid {
+			return fmt.Errorf("%q renders to %q, %q is not allowed in an image tag", format, sample, string(r))
+		}
+	}
+	if len(sample) > 128 {
+		return fmt.Errorf("%q renders to more than 128 characters", format)
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -70,3 +72,154 @@ func TestComputeCalVer(t *testing.T) {
 		t.Errorf("ComputeCalVer() = %q, expected format YYYY.DDD.HHMM with 2 dots", version)
 	}
 }
+
+func TestComputeTagAt(t *testing.T) {
+	at := time.Date(2026, 2, 14, 8, 5, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		format   string
+		existing []string
+		want     string
+	}{
+		{"date and time", "20060102-1504", nil, "20260214-0805"},
+		{"first build of the day", "v2006.01.02-{n}", nil, "v2026.02.14-1"},
+		{"counter collision", "v2006.01.02-{n}", []string{"v2026.02.14-1", "v2026.02.14-2", "latest"}, "v2026.02.14-3"},
+		{"counter is numeric", "v2006.01.02-{n}", []string{"v2026.02.14-9", "v2026.02.14-10"}, "v2026.02.14-11"},
+		{"other days don't count", "v2006.01.02-{n}", []string{"v2026.02.13-7", "v2026.02.14-1-rc"}, "v2026.02.14-1"},
+		{"counter without separator", "2006.01.02.{n}", []string{"2026.02.14.4"}, "2026.02.14.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ComputeTagAt(tt.format, at, tt.existing); got != tt.want {
+				t.Errorf("ComputeTagAt(%q) = %q, want %q", tt.format, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputeTag(t *testing.T) {
+	t.Setenv("OV_BUILD_ENGINE", "podman")
+	orig := LocalImageTags
+	t.Cleanup(func() { LocalImageTags = orig })
+	var engine string
+	LocalImageTags = func(e string) ([]string, error) {
+		engine = e
+		return []string{"2026.02.14-1"}, nil
+	}
+
+	at := time.Date(2026, 2, 14, 8, 5, 0, 0, time.UTC)
+	if got := ComputeTag(&Config{}, ".", at); got != "2026.45.805" {
+		t.Errorf("ComputeTag() without tag_format = %q, want CalVer", got)
+	}
+	if engine != "" {
+		t.Error("local tags should only be listed for formats with {n}")
+	}
+
+	cfg := &Config{Defaults: ImageConfig{TagFormat: "2006.01.02-{n}"}}
+	if got := ComputeTag(cfg, ".", at); got != "2026.02.14-2" {
+		t.Errorf("ComputeTag() = %q, want 2026.02.14-2", got)
+	}
+	if engine != "podman" {
+		t.Errorf("listed tags of %q, want the build engine podman", engine)
+	}
+}
+
+func TestComputeTagGitSuffix(t *testing.T) {
+	t.Setenv("OV_BUILD_ENGINE", "podman")
+	origTags, origGit := LocalImageTags, GitRevision
+	t.Cleanup(func() { LocalImageTags, GitRevision = origTags, origGit })
+	LocalImageTags = func(string) ([]string, error) {
+		return []string{"2026.02.14-1-gabcdef0", "2026.02.14-2-g1234567-dirty"}, nil
+	}
+	sha, dirty, gitErr := "0123456789abcdef0123456789abcdef01234567", false, error(nil)
+	var gitDir string
+	GitRevision = func(dir string) (string, bool, error) {
+		gitDir = dir
+		return sha, dirty, gitErr
+	}
+
+	at := time.Date(2026, 2, 14, 8, 5, 0, 0, time.UTC)
+	cfg := &Config{Defaults: ImageConfig{TagSuffix: "git"}}
+	if got := ComputeTag(cfg, "/project", at); got != "2026.45.805-g0123456" {
+		t.Errorf("ComputeTag() = %q, want 2026.45.805-g0123456", got)
+	}
+	if gitDir != "/project" {
+		t.Errorf("git ran in %q, want the project directory", gitDir)
+	}
+
+	dirty = true
+	if got := ComputeTag(cfg, "/project", at); got != "2026.45.805-g0123456-dirty" {
+		t.Errorf("ComputeTag() of a dirty worktree = %q, want -dirty suffix", got)
+	}
+
+	// The counter ignores the suffixes of earlier builds
+	cfg.Defaults.TagFormat = "2006.01.02-{n}"
+	if got := ComputeTag(cfg, "/project", at); got != "2026.02.14-3-g0123456-dirty" {
+		t.Errorf("ComputeTag() with counter = %q, want 2026.02.14-3-g0123456-dirty", got)
+	}
+
+	// Without git the tag has no suffix
+	gitErr = errors.New("not a git repository")
+	if got := ComputeTag(cfg, "/project", at); got != "2026.02.14-3" {
+		t.Errorf("ComputeTag() without git = %q, want 2026.02.14-3", got)
+	}
+
+	// Without tag_suffix git is not consulted
+	gitDir = ""
+	if got := ComputeTag(&Config{}, "/project", at); got != "2026.45.805" || gitDir != "" {
+		t.Errorf("ComputeTag() without tag_suffix = %q (git dir %q), want plain CalVer", got, gitDir)
+	}
+}
+
+func TestValidateTagFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string // substring of the error, "" for valid
+	}{
+		{"v2006.01.02-{n}", ""},
+		{"20060102-1504", ""},
+		{"2006.01.02-{build}", `unknown placeholder "{build}"`},
+		{"2006.01.02-{n", `unterminated placeholder "{n"`},
+		{"release-{n}", "no date or time token"},
+		{"2006/01/02", `"/" is not allowed`},
+		{"-2006.01.02", `"-" is not allowed`},
+	}
+	for _, tt := range tests {
+		err := validateTagFormat(tt.format)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("validateTagFormat(%q) error = %v", tt.format, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validateTagFormat(%q) error = %v, want %q", tt.format, err, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigTagFormat(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"images.yml": "defaults:\n  tag_format: \"2006.01.02-{counter}\"\nimages: {}\n",
+	})
+	_, err := LoadConfig(dir)
+	if err == nil {
+		t.Fatal("LoadConfig() expected error for invalid tag_format")
+	}
+	if want := `defaults.tag_format: unknown placeholder "{counter}"`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}
+
+func TestLoadConfigTagSuffix(t *testing.T) {
+	dir := writeConfigFiles(t, map[string]string{
+		"images.yml": "defaults:\n  tag_suffix: svn\nimages: {}\n",
+	})
+	_, err := LoadConfig(dir)
+	if err == nil {
+		t.Fatal("LoadConfig() expected error for unknown tag_suffix")
+	}
+	if want := `defaults.tag_suffix: must be "git", got "svn"`; !strings.Contains(err.Error(), want) {
+		t.Errorf("error = %v, want %q", err, want)
+	}
+}